@@ -7,12 +7,13 @@ const (
 	defaultJSONServerPort  = 9090
 )
 
-// Config defines the api config params
+// Config defines the api config params. The usage tags feed the generated
+// command line flags, see config.BindFlags.
 type Config struct {
-	StartGrpcServer bool `mapstructure:"grpc-server"`
-	GrpcServerPort  int  `mapstructure:"grpc-port"`
-	StartJSONServer bool `mapstructure:"json-server"`
-	JSONServerPort  int  `mapstructure:"json-port"`
+	StartGrpcServer bool `mapstructure:"grpc-server" usage:"StartService the grpc server"`
+	GrpcServerPort  int  `mapstructure:"grpc-port" usage:"GRPC api server port"`
+	StartJSONServer bool `mapstructure:"json-server" usage:"StartService the json http server. Note that starting the Json server also starts the grpc server."`
+	JSONServerPort  int  `mapstructure:"json-port" usage:"JSON api server port"`
 }
 
 // ConfigValues set default values
@@ -0,0 +1,362 @@
+// Package status exposes a read-only introspection API for a running node
+// over a local HTTP endpoint. Handlers answer from small provider interfaces
+// implemented by the DHT, gossip and config layers, so the package stays free
+// of their dependencies and tests can run it against fakes.
+package status
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/journal"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/taskgroup"
+	"github.com/spacemeshos/go-spacemesh/version"
+)
+
+// DefaultBindAddress is the loopback address the status server listens on
+// unless configured otherwise.
+const DefaultBindAddress = "127.0.0.1:9092"
+
+// DefaultHandlerTimeout bounds how long a handler waits on a provider before
+// answering 503, so a wedged component can't hang the status endpoint.
+const DefaultHandlerTimeout = 2 * time.Second
+
+// ErrNonLocalBind is returned when the bind address is not a loopback address
+// and the config does not explicitly allow remote access.
+var ErrNonLocalBind = errors.New("status: binding to a non-localhost address requires AllowRemote")
+
+// Config holds the status server settings.
+type Config struct {
+	Enabled     bool          `mapstructure:"status-server" usage:"StartService the node status http server"`
+	BindAddress string        `mapstructure:"status-bind" usage:"Bind address for the status server, localhost only unless status-allow-remote is set"`
+	AllowRemote bool          `mapstructure:"status-allow-remote" usage:"Explicitly allow the status server to bind to a non-localhost address"`
+	Timeout     time.Duration `mapstructure:"status-timeout" usage:"Per-handler timeout before a wedged component is reported as unavailable"`
+}
+
+// DefaultConfig defines the default configuration options for the status server.
+func DefaultConfig() Config {
+	return Config{
+		Enabled:     false,
+		BindAddress: DefaultBindAddress,
+		AllowRemote: false,
+		Timeout:     DefaultHandlerTimeout,
+	}
+}
+
+// DHTProvider reports routing table health. Implemented by the dht package.
+type DHTProvider interface {
+	Size() int
+	Bootstrapped() bool
+}
+
+// PeersProvider reports the current gossip peers. Implemented by the gossip
+// protocol.
+type PeersProvider interface {
+	Peers() []string
+}
+
+// MetricsProvider reports a component's counters by name. Gossip, protocol
+// and net each expose one.
+type MetricsProvider interface {
+	Metrics() map[string]uint64
+}
+
+// HealthProvider reports the node's aggregated lifecycle state and the
+// outcome of each readiness probe. Implemented by node.HealthManager.
+type HealthProvider interface {
+	HealthStatus() (state string, probes map[string]string)
+}
+
+// EventsProvider reads the node's persisted event journal. Implemented by
+// journal.Journal.
+type EventsProvider interface {
+	Read(since time.Time) ([]journal.Event, error)
+}
+
+// ConfigDumper writes the redacted effective config as JSON. Implemented by
+// config.Config.Dump.
+type ConfigDumper interface {
+	Dump(w io.Writer) error
+}
+
+// Identity is the local node's public info. It must never carry private key
+// material.
+type Identity struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+}
+
+// Providers collects the components the server answers from. Nil entries
+// disable their endpoints with 404.
+type Providers struct {
+	DHT      DHTProvider
+	Peers    PeersProvider
+	Metrics  map[string]MetricsProvider // keyed by component name, e.g. "gossip"
+	Config   ConfigDumper
+	Identity *Identity
+	Health   HealthProvider
+	Events   EventsProvider
+
+	// Build answers /status/build with the binary's build information and
+	// active features, typically version.Info.
+	Build func() version.BuildInfo
+
+	// Tasks reports supervised goroutine status, typically a component's
+	// taskgroup.Group.
+	Tasks taskgroup.Reporter
+
+	// Exporter serves the Prometheus exposition under /metrics, typically
+	// metrics.Registry.Handler.
+	Exporter http.Handler
+}
+
+// Server serves the node status API.
+type Server struct {
+	config    Config
+	providers Providers
+	server    *http.Server
+	listener  net.Listener
+}
+
+// NewServer creates a status server for the given providers. It refuses a
+// non-localhost bind address unless the config explicitly allows remote
+// access.
+func NewServer(config Config, providers Providers) (*Server, error) {
+	if config.BindAddress == "" {
+		config.BindAddress = DefaultBindAddress
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultHandlerTimeout
+	}
+
+	host, _, err := net.SplitHostPort(config.BindAddress)
+	if err != nil {
+		return nil, fmt.Errorf("status: invalid bind address %q: %v", config.BindAddress, err)
+	}
+	if !config.AllowRemote && !isLoopback(host) {
+		return nil, ErrNonLocalBind
+	}
+
+	s := &Server{config: config, providers: providers}
+
+	mux := http.NewServeMux()
+	if providers.DHT != nil {
+		mux.HandleFunc("/status/dht", s.handleDHT)
+	}
+	if providers.Peers != nil {
+		mux.HandleFunc("/status/peers", s.handlePeers)
+	}
+	if providers.Metrics != nil {
+		mux.HandleFunc("/status/metrics", s.handleMetrics)
+	}
+	if providers.Config != nil {
+		mux.HandleFunc("/status/config", s.handleConfig)
+	}
+	if providers.Identity != nil {
+		mux.HandleFunc("/status/identity", s.handleIdentity)
+	}
+	if providers.Health != nil {
+		mux.HandleFunc("/status/health", s.handleHealth)
+	}
+	if providers.Tasks != nil {
+		mux.HandleFunc("/status/tasks", s.handleTasks)
+	}
+	if providers.Events != nil {
+		mux.HandleFunc("/status/events", s.handleEvents)
+	}
+	if providers.Build != nil {
+		mux.HandleFunc("/status/build", s.handleBuild)
+	}
+	if providers.Exporter != nil {
+		mux.Handle("/metrics", providers.Exporter)
+	}
+	s.server = &http.Server{Addr: config.BindAddress, Handler: mux}
+
+	return s, nil
+}
+
+// isLoopback reports whether host names the local machine only.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Start binds the listener and serves in the background. It returns once the
+// listener is bound so callers can resolve the effective address.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.config.BindAddress)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error("status server stopped: %v", err)
+		}
+	}()
+	log.Debug("status API listening on %v", listener.Addr())
+	return nil
+}
+
+// Addr returns the bound address, useful when the config port is 0.
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close shuts the server down.
+func (s *Server) Close() error {
+	return s.server.Close()
+}
+
+// collect runs fn with the handler timeout and returns its result, or an
+// error when the provider does not answer in time. fn runs on its own
+// goroutine so a wedged provider can't block the endpoint - the goroutine is
+// leaked until the provider unwedges, which is the lesser evil.
+func (s *Server) collect(fn func() interface{}) (interface{}, error) {
+	done := make(chan interface{}, 1)
+	go func() { done <- fn() }()
+	select {
+	case v := <-done:
+		return v, nil
+	case <-time.After(s.config.Timeout):
+		return nil, errors.New("component did not answer within the status timeout")
+	}
+}
+
+// respond writes v as JSON, or a 503 with an error body when the provider
+// timed out.
+func respond(w http.ResponseWriter, v interface{}, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
+
+// dhtStatus is the /status/dht response body.
+type dhtStatus struct {
+	Size         int  `json:"size"`
+	Bootstrapped bool `json:"bootstrapped"`
+}
+
+func (s *Server) handleDHT(w http.ResponseWriter, r *http.Request) {
+	v, err := s.collect(func() interface{} {
+		return dhtStatus{Size: s.providers.DHT.Size(), Bootstrapped: s.providers.DHT.Bootstrapped()}
+	})
+	respond(w, v, err)
+}
+
+// peersStatus is the /status/peers response body.
+type peersStatus struct {
+	Count int      `json:"count"`
+	Peers []string `json:"peers"`
+}
+
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	v, err := s.collect(func() interface{} {
+		peers := s.providers.Peers.Peers()
+		return peersStatus{Count: len(peers), Peers: peers}
+	})
+	respond(w, v, err)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	v, err := s.collect(func() interface{} {
+		out := make(map[string]map[string]uint64, len(s.providers.Metrics))
+		for name, p := range s.providers.Metrics {
+			out[name] = p.Metrics()
+		}
+		return out
+	})
+	respond(w, v, err)
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	v, err := s.collect(func() interface{} {
+		var buf bytes.Buffer
+		if err := s.providers.Config.Dump(&buf); err != nil {
+			return err
+		}
+		return json.RawMessage(buf.Bytes())
+	})
+	if e, ok := v.(error); ok && err == nil {
+		err = e
+		v = nil
+	}
+	respond(w, v, err)
+}
+
+// healthStatus is the /status/health response body.
+type healthStatus struct {
+	State  string            `json:"state"`
+	Probes map[string]string `json:"probes"`
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	v, err := s.collect(func() interface{} {
+		state, probes := s.providers.Health.HealthStatus()
+		return healthStatus{State: state, Probes: probes}
+	})
+	respond(w, v, err)
+}
+
+func (s *Server) handleTasks(w http.ResponseWriter, r *http.Request) {
+	v, err := s.collect(func() interface{} { return s.providers.Tasks.Status() })
+	respond(w, v, err)
+}
+
+func (s *Server) handleBuild(w http.ResponseWriter, r *http.Request) {
+	v, err := s.collect(func() interface{} { return s.providers.Build() })
+	respond(w, v, err)
+}
+
+// handleEvents answers with the journaled node events, oldest first. An
+// optional since query parameter (RFC 3339) narrows the window.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("invalid since parameter %q: %v", raw, err)})
+			return
+		}
+	}
+	v, err := s.collect(func() interface{} {
+		events, err := s.providers.Events.Read(since)
+		if err != nil {
+			return err
+		}
+		if events == nil {
+			events = []journal.Event{}
+		}
+		return events
+	})
+	if e, ok := v.(error); ok && err == nil {
+		err = e
+		v = nil
+	}
+	respond(w, v, err)
+}
+
+func (s *Server) handleIdentity(w http.ResponseWriter, r *http.Request) {
+	v, err := s.collect(func() interface{} { return *s.providers.Identity })
+	respond(w, v, err)
+}
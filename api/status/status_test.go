@@ -0,0 +1,229 @@
+package status
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/journal"
+	"github.com/spacemeshos/go-spacemesh/taskgroup"
+	"github.com/spacemeshos/go-spacemesh/version"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeDHT struct {
+	size         int
+	bootstrapped bool
+	hang         chan struct{} // when set, Size blocks until closed
+}
+
+func (f *fakeDHT) Size() int {
+	if f.hang != nil {
+		<-f.hang
+	}
+	return f.size
+}
+
+func (f *fakeDHT) Bootstrapped() bool { return f.bootstrapped }
+
+type fakePeers struct{ peers []string }
+
+func (f *fakePeers) Peers() []string { return f.peers }
+
+type fakeMetrics struct{ counters map[string]uint64 }
+
+func (f *fakeMetrics) Metrics() map[string]uint64 { return f.counters }
+
+type fakeHealth struct {
+	state  string
+	probes map[string]string
+}
+
+func (f *fakeHealth) HealthStatus() (string, map[string]string) { return f.state, f.probes }
+
+type fakeTasks struct{ status []taskgroup.TaskStatus }
+
+func (f *fakeTasks) Status() []taskgroup.TaskStatus { return f.status }
+
+type fakeEvents struct{ events []journal.Event }
+
+func (f *fakeEvents) Read(since time.Time) ([]journal.Event, error) {
+	var out []journal.Event
+	for _, e := range f.events {
+		if !e.Time.Before(since) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+type fakeConfig struct{ body string }
+
+func (f *fakeConfig) Dump(w io.Writer) error {
+	_, err := io.WriteString(w, f.body)
+	return err
+}
+
+func startTestServer(t *testing.T, cfg Config, providers Providers) *Server {
+	if cfg.BindAddress == "" {
+		cfg.BindAddress = "127.0.0.1:0"
+	}
+	s, err := NewServer(cfg, providers)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Start())
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func get(t *testing.T, s *Server, path string) (int, []byte) {
+	resp, err := http.Get("http://" + s.Addr().String() + path)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, body
+}
+
+func TestStatusEndpoints(t *testing.T) {
+	eventTime := time.Date(2018, 11, 2, 12, 0, 0, 0, time.UTC)
+	providers := Providers{
+		DHT:      &fakeDHT{size: 17, bootstrapped: true},
+		Peers:    &fakePeers{peers: []string{"p1", "p2"}},
+		Metrics:  map[string]MetricsProvider{"gossip": &fakeMetrics{counters: map[string]uint64{"relayed": 42}}},
+		Config:   &fakeConfig{body: `{"p2p":{"port":7513}}`},
+		Identity: &Identity{ID: "n1", Address: "127.0.0.1:7513"},
+		Health:   &fakeHealth{state: "degraded", probes: map[string]string{"dht": "ok", "peers": "no peers connected"}},
+		Tasks:    &fakeTasks{status: []taskgroup.TaskStatus{{Name: "gossip/maintenance", Running: true, Restarts: 1, LastError: "panic: boom"}}},
+		Build: func() version.BuildInfo {
+			return version.BuildInfo{Version: "0.19.5-abc123", Commit: "abc123", Features: []string{"flate/1"}}
+		},
+		Events: &fakeEvents{events: []journal.Event{
+			{Time: eventTime.Add(-time.Hour), Type: journal.EventBootstrapStarted},
+			{Time: eventTime, Type: journal.EventPeerDisconnected, Peer: "p1", Reason: "remote closed the connection"},
+		}},
+	}
+	s := startTestServer(t, Config{}, providers)
+
+	code, body := get(t, s, "/status/dht")
+	assert.Equal(t, http.StatusOK, code)
+	var dht struct {
+		Size         int  `json:"size"`
+		Bootstrapped bool `json:"bootstrapped"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &dht))
+	assert.Equal(t, 17, dht.Size)
+	assert.True(t, dht.Bootstrapped)
+
+	code, body = get(t, s, "/status/peers")
+	assert.Equal(t, http.StatusOK, code)
+	var peers struct {
+		Count int      `json:"count"`
+		Peers []string `json:"peers"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &peers))
+	assert.Equal(t, 2, peers.Count)
+	assert.Equal(t, []string{"p1", "p2"}, peers.Peers)
+
+	code, body = get(t, s, "/status/metrics")
+	assert.Equal(t, http.StatusOK, code)
+	var metrics map[string]map[string]uint64
+	assert.NoError(t, json.Unmarshal(body, &metrics))
+	assert.Equal(t, uint64(42), metrics["gossip"]["relayed"])
+
+	code, body = get(t, s, "/status/config")
+	assert.Equal(t, http.StatusOK, code)
+	var dump map[string]interface{}
+	assert.NoError(t, json.Unmarshal(body, &dump), "config endpoint must relay the dump verbatim")
+	assert.Contains(t, dump, "p2p")
+
+	code, body = get(t, s, "/status/health")
+	assert.Equal(t, http.StatusOK, code)
+	var health struct {
+		State  string            `json:"state"`
+		Probes map[string]string `json:"probes"`
+	}
+	assert.NoError(t, json.Unmarshal(body, &health))
+	assert.Equal(t, "degraded", health.State)
+	assert.Equal(t, "no peers connected", health.Probes["peers"])
+
+	code, body = get(t, s, "/status/tasks")
+	assert.Equal(t, http.StatusOK, code)
+	var tasks []taskgroup.TaskStatus
+	assert.NoError(t, json.Unmarshal(body, &tasks))
+	assert.Len(t, tasks, 1)
+	assert.Equal(t, "gossip/maintenance", tasks[0].Name)
+	assert.Equal(t, 1, tasks[0].Restarts)
+	assert.Equal(t, "panic: boom", tasks[0].LastError)
+
+	code, body = get(t, s, "/status/build")
+	assert.Equal(t, http.StatusOK, code)
+	var build version.BuildInfo
+	assert.NoError(t, json.Unmarshal(body, &build))
+	assert.Equal(t, "0.19.5-abc123", build.Version)
+	assert.Equal(t, []string{"flate/1"}, build.Features)
+
+	code, body = get(t, s, "/status/events")
+	assert.Equal(t, http.StatusOK, code)
+	var events []journal.Event
+	assert.NoError(t, json.Unmarshal(body, &events))
+	assert.Len(t, events, 2)
+	assert.Equal(t, journal.EventPeerDisconnected, events[1].Type)
+	assert.Equal(t, "remote closed the connection", events[1].Reason)
+
+	// the since parameter narrows the window
+	code, body = get(t, s, "/status/events?since="+eventTime.Format(time.RFC3339))
+	assert.Equal(t, http.StatusOK, code)
+	events = nil
+	assert.NoError(t, json.Unmarshal(body, &events))
+	assert.Len(t, events, 1)
+	assert.Equal(t, "p1", events[0].Peer)
+
+	// a malformed since is the caller's mistake, not a server failure
+	code, _ = get(t, s, "/status/events?since=yesterday")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body = get(t, s, "/status/identity")
+	assert.Equal(t, http.StatusOK, code)
+	var id Identity
+	assert.NoError(t, json.Unmarshal(body, &id))
+	assert.Equal(t, "n1", id.ID)
+	assert.Equal(t, "127.0.0.1:7513", id.Address)
+}
+
+func TestHangingProviderTimesOut(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	providers := Providers{DHT: &fakeDHT{hang: hang}}
+	s := startTestServer(t, Config{Timeout: 50 * time.Millisecond}, providers)
+
+	start := time.Now()
+	code, body := get(t, s, "/status/dht")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.True(t, time.Since(start) < time.Second, "a wedged provider must not hang the endpoint")
+	var e map[string]string
+	assert.NoError(t, json.Unmarshal(body, &e))
+	assert.NotEmpty(t, e["error"])
+}
+
+func TestDisabledProviderIs404(t *testing.T) {
+	s := startTestServer(t, Config{}, Providers{DHT: &fakeDHT{}})
+	code, _ := get(t, s, "/status/peers")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestNonLocalBindRequiresOptIn(t *testing.T) {
+	_, err := NewServer(Config{BindAddress: "0.0.0.0:9092"}, Providers{})
+	assert.Equal(t, ErrNonLocalBind, err)
+
+	// the explicit flag allows it
+	s, err := NewServer(Config{BindAddress: "0.0.0.0:0", AllowRemote: true}, Providers{})
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+
+	// loopback needs no flag
+	_, err = NewServer(Config{BindAddress: "localhost:0"}, Providers{})
+	assert.NoError(t, err)
+}
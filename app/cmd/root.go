@@ -60,20 +60,9 @@ func init() {
 		config.P2P.TimeConfig.RefreshNtpInterval, "Refresh intervals to ntp")
 
 	/** ======================== API Flags ========================== **/
-	// StartJSONApiServerFlag determines if json api server should be started
-	RootCmd.PersistentFlags().BoolVar(&config.API.StartJSONServer, "json-server",
-		config.API.StartJSONServer, "StartService the json http server. "+
-			"Note that starting the Json server also starts the grpc server.",
-	)
-	// JSONServerPortFlag determines the json api server local listening port
-	RootCmd.PersistentFlags().IntVar(&config.API.JSONServerPort, "json-port",
-		config.API.JSONServerPort, "JSON api server port")
-	// StartGrpcAPIServerFlag determines if the grpc server should be started
-	RootCmd.PersistentFlags().BoolVar(&config.API.StartGrpcServer, "grpc-server",
-		config.API.StartGrpcServer, "StartService the grpc server")
-	// GrpcServerPortFlag determines the grpc server local listening port
-	RootCmd.PersistentFlags().IntVar(&config.API.GrpcServerPort, "grpc-port",
-		config.API.GrpcServerPort, "GRPC api server port")
+	// api flags are generated from the section's struct tags - a new tagged
+	// field gets its flag for free, a half-tagged one panics here at startup
+	cfg.MustBindFlags(RootCmd.PersistentFlags(), viper.GetViper(), &config.API)
 
 	/**========================Consensus Flags ========================== **/
 	//todo: add this here
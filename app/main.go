@@ -57,16 +57,9 @@ func (app *SpacemeshApp) ParseConfig() (err error) {
 
 	fileLocation := viper.GetString("config")
 	vip := viper.New()
-	// read in default config if passed as param using viper
-	if err = cfg.LoadConfig(fileLocation, vip); err != nil {
-		log.Error(fmt.Sprintf("couldn't load config file at location: %s swithing to defaults \n error: %v.",
-			fileLocation, err))
-		//return err
-	}
-
-	conf := cfg.DefaultConfig()
-	// load config if it was loaded to our viper
-	err = vip.Unmarshal(&conf)
+	// read in default config if passed as param using viper, with
+	// environment overrides applied on top of the file values
+	conf, err := cfg.LoadEffectiveConfig(fileLocation, vip)
 	if err != nil {
 		log.Error("Failed to parse config\n")
 		return err
@@ -91,6 +84,7 @@ func EnsureCLIFlags(cmd *cobra.Command, appcfg *cfg.Config) {
 	cmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
 		if f.Changed {
 			name := f.Name
+			appcfg.RecordExplicit(name)
 
 			ff := reflect.TypeOf(*appcfg)
 			elem := reflect.ValueOf(&appcfg).Elem()
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator/scenario"
+)
+
+// floodProtocol is the overlay protocol the harness measures. The real gossip
+// Neighborhood runs on network connections and can't ride the simulator, so
+// the harness floods through its own relay mesh - same shape, same duplicate
+// and propagation characteristics.
+const floodProtocol = "loadtest/flood/1.0"
+
+// floodNode is one relay in the overlay mesh.
+type floodNode struct {
+	sim       *simulator.Node
+	neighbors []string
+
+	mutex sync.Mutex
+	seen  map[string]bool
+}
+
+// markSeen records a message digest, reporting whether it was new.
+func (fn *floodNode) markSeen(digest string) bool {
+	fn.mutex.Lock()
+	defer fn.mutex.Unlock()
+	if fn.seen[digest] {
+		return false
+	}
+	fn.seen[digest] = true
+	return true
+}
+
+// seenCount reports how many distinct messages the node has seen.
+func (fn *floodNode) seenCount() int64 {
+	fn.mutex.Lock()
+	defer fn.mutex.Unlock()
+	return int64(len(fn.seen))
+}
+
+// injection records when and where a message entered the mesh. The origin is
+// needed so its own re-receipt of the message doesn't count as propagation.
+type injection struct {
+	when   time.Time
+	origin string
+}
+
+// flood wires a relay mesh over the scenario's nodes and injects the
+// configured traffic.
+type flood struct {
+	sim      *simulator.Simulator
+	sc       Scenario
+	rng      *rand.Rand
+	nodes    []*floodNode
+	injected map[string]injection // by message digest
+	peaks    map[string]GaugePeak // peak gauge samples, by gauge name
+}
+
+// newFlood builds the relay mesh - every node picks fanout distinct relay
+// targets from a scenario-seeded RNG, so the mesh is reproducible.
+func newFlood(sim *simulator.Simulator, built *scenario.Scenario, sc Scenario) *flood {
+	f := &flood{
+		sim:      sim,
+		sc:       sc,
+		rng:      rand.New(rand.NewSource(sc.Seed)),
+		injected: make(map[string]injection),
+		peaks:    make(map[string]GaugePeak),
+	}
+
+	handles := append(append([]*scenario.Handle(nil), built.Bootstrap...), built.Nodes...)
+	for _, h := range handles {
+		f.nodes = append(f.nodes, &floodNode{sim: h.Sim, seen: make(map[string]bool)})
+	}
+
+	for i, fn := range f.nodes {
+		k := sc.Fanout
+		if k > len(f.nodes)-1 {
+			k = len(f.nodes) - 1
+		}
+		for _, j := range f.rng.Perm(len(f.nodes)) {
+			if j == i || len(fn.neighbors) == k {
+				continue
+			}
+			fn.neighbors = append(fn.neighbors, f.nodes[j].sim.String())
+		}
+
+		fn := fn
+		fn.sim.ReportGauge("flood.seen", fn.seenCount)
+		ingress := fn.sim.RegisterProtocol(floodProtocol)
+		go func() {
+			for msg := range ingress {
+				f.markAndForward(fn, msg.Data())
+			}
+		}()
+	}
+
+	if sc.LossProb > 0 {
+		for i := range f.nodes {
+			for j := i + 1; j < len(f.nodes); j++ {
+				sim.SetFaults(f.nodes[i].sim.String(), f.nodes[j].sim.String(),
+					simulator.Faults{DropProb: sc.LossProb})
+			}
+		}
+	}
+
+	return f
+}
+
+// markAndForward forwards a first-seen message to the node's relay targets.
+// Repeats are counted by the traffic recorder and dropped here.
+func (f *flood) markAndForward(fn *floodNode, payload []byte) bool {
+	if !fn.markSeen(digestKey(payload)) {
+		return false
+	}
+	for _, neighbor := range fn.neighbors {
+		fn.sim.SendMessage(neighbor, floodProtocol, payload)
+	}
+	return true
+}
+
+// run injects the scenario's messages at the configured rate from rotating
+// origins, sampling gauge peaks along the way, then waits for the flood to
+// drain.
+func (f *flood) run() {
+	payload := make([]byte, f.sc.MessageSize)
+
+	for i := 0; i < f.sc.Messages; i++ {
+		f.rng.Read(payload)
+		binary.BigEndian.PutUint32(payload, uint32(i))
+
+		origin := f.nodes[f.rng.Intn(len(f.nodes))]
+		f.injected[digestKey(payload)] = injection{when: f.sim.Clock().Now(), origin: origin.sim.String()}
+		f.markAndForward(origin, append([]byte(nil), payload...))
+
+		f.samplePeaks()
+		if f.sc.MessageGapMs > 0 {
+			time.Sleep(time.Duration(f.sc.MessageGapMs) * time.Millisecond)
+		}
+	}
+
+	f.waitQuiescent()
+	f.samplePeaks()
+}
+
+// waitQuiescent waits until no new deliveries happen for a few polls, bounded
+// by the scenario's drain budget.
+func (f *flood) waitQuiescent() {
+	deadline := time.Now().Add(time.Duration(f.sc.QuiescenceSecs) * time.Second)
+	last, stable := f.sim.Count(floodProtocol), 0
+	for time.Now().Before(deadline) && stable < 5 {
+		time.Sleep(50 * time.Millisecond)
+		if count := f.sim.Count(floodProtocol); count == last {
+			stable++
+		} else {
+			last, stable = count, 0
+		}
+	}
+}
+
+// samplePeaks takes one sample of every node's gauges, keeping the largest
+// value seen per gauge and the node it was seen on.
+func (f *flood) samplePeaks() {
+	for _, fn := range f.nodes {
+		id := fn.sim.String()
+		for _, name := range []string{"dht.routing_table", "flood.seen"} {
+			value, ok := f.sim.GaugeValue(id, name)
+			if !ok {
+				continue
+			}
+			if peak, ok := f.peaks[name]; !ok || value > peak.Value {
+				f.peaks[name] = GaugePeak{Node: id, Value: value}
+			}
+		}
+	}
+}
+
+// digestKey identifies a message by its payload digest, matching the traffic
+// recorder's digests.
+func digestKey(payload []byte) string {
+	return string(crypto.Sha256(payload))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The checked-in scenarios run as long-mode golden-range tests - the asserted
+// ranges are deliberately loose, they catch structural regressions (broken
+// propagation, runaway duplication) rather than pin exact timings.
+
+func TestBaselineScenarioRanges(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load scenario - long mode only")
+	}
+
+	report, err := Run(Baseline)
+	assert.NoError(t, err, "baseline scenario failed")
+
+	assert.Equal(t, Baseline.Nodes+Baseline.BootstrapNodes, report.Nodes)
+	assert.Equal(t, Baseline.Messages, report.Messages)
+
+	// a loss-free flood must reach essentially everyone
+	assert.True(t, report.Coverage >= 0.95, "coverage %v below the golden range", report.Coverage)
+
+	// propagation is a few link latencies, not zero and not seconds
+	assert.True(t, report.P50LatencyMs > 0, "expected a positive p50")
+	assert.True(t, report.P99LatencyMs >= report.P50LatencyMs, "p99 below p50")
+	assert.True(t, report.P99LatencyMs < 1000, "p99 %vms above the golden range", report.P99LatencyMs)
+
+	// a fanout-4 flood duplicates, but boundedly - the +1 headroom covers
+	// echoes back to message origins, which count as deliveries but not as
+	// reached pairs
+	assert.True(t, report.DuplicateFactor >= 1, "duplicate factor below 1")
+	assert.True(t, report.DuplicateFactor <= float64(Baseline.Fanout)+1, "duplicate factor %v above fanout", report.DuplicateFactor)
+
+	// bootstrap generated dht lookups
+	assert.True(t, report.FindNodeQueries > 0, "expected find-node traffic from bootstrap")
+
+	// gauges were sampled with an owner
+	assert.True(t, report.PeakGauges["flood.seen"].Value > 0, "expected a flood.seen peak")
+	assert.NotEmpty(t, report.PeakGauges["flood.seen"].Node, "expected the peak's node")
+	assert.True(t, report.PeakGauges["dht.routing_table"].Value > 0, "expected a routing table peak")
+}
+
+func TestLossyScenarioRanges(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load scenario - long mode only")
+	}
+
+	report, err := Run(Lossy)
+	assert.NoError(t, err, "lossy scenario failed")
+
+	// flood redundancy keeps coverage high despite 10% loss
+	assert.True(t, report.Coverage >= 0.8, "coverage %v below the golden range", report.Coverage)
+
+	assert.True(t, report.P99LatencyMs >= report.P50LatencyMs, "p99 below p50")
+	assert.True(t, report.DuplicateFactor >= 1, "duplicate factor below 1")
+	assert.True(t, report.DuplicateFactor <= float64(Lossy.Fanout)+1, "duplicate factor %v above fanout", report.DuplicateFactor)
+}
+
+func TestScenarioReproducible(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load scenario - long mode only")
+	}
+
+	sc := Baseline
+	sc.Messages = 5
+
+	first, err := Run(sc)
+	assert.NoError(t, err)
+	second, err := Run(sc)
+	assert.NoError(t, err)
+
+	// the mesh and traffic pattern are seed-determined - the structural
+	// numbers repeat even though timings don't
+	assert.Equal(t, first.Deliveries, second.Deliveries, "same seed must give the same delivery count")
+	assert.Equal(t, first.Coverage, second.Coverage, "same seed must give the same coverage")
+}
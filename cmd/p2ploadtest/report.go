@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+)
+
+// Report is the machine-readable result of one scenario run. Latencies are
+// per message - the time from injection until the last node that got the
+// message at all first received it.
+type Report struct {
+	Scenario        string               `json:"scenario"`
+	Seed            int64                `json:"seed"`
+	Nodes           int                  `json:"nodes"`
+	Messages        int                  `json:"messages"`
+	Deliveries      int                  `json:"deliveries"`      // flood deliveries, counting duplicates
+	Coverage        float64              `json:"coverage"`        // reached (message, node) pairs out of all possible
+	P50LatencyMs    float64              `json:"p50LatencyMs"`    // median propagation latency
+	P99LatencyMs    float64              `json:"p99LatencyMs"`    // tail propagation latency
+	DuplicateFactor float64              `json:"duplicateFactor"` // deliveries per reached (message, node) pair
+	FindNodeQueries int                  `json:"findNodeQueries"` // dht find-node deliveries during the run
+	PeakGauges      map[string]GaugePeak `json:"peakGauges"`      // largest gauge sample per gauge, with its node
+}
+
+// GaugePeak is the largest sample of one gauge over the run.
+type GaugePeak struct {
+	Node  string `json:"node"`
+	Value int64  `json:"value"`
+}
+
+// reception identifies one (message, node) delivery pair.
+type reception struct {
+	digest string
+	to     string
+}
+
+// computeReport derives the scenario report from the recorded traffic and the
+// flood's injection log.
+func computeReport(sc Scenario, records []simulator.TrafficRecord, f *flood) *Report {
+	report := &Report{
+		Scenario:   sc.Name,
+		Seed:       sc.Seed,
+		Nodes:      len(f.nodes),
+		Messages:   len(f.injected),
+		PeakGauges: f.peaks,
+	}
+
+	// first receipt time per (message, node) pair, duplicates counted
+	firstSeen := make(map[reception]time.Time)
+	for _, rec := range records {
+		switch {
+		case rec.Protocol == floodProtocol:
+			report.Deliveries++
+			key := reception{string(rec.Digest), rec.To}
+			if first, ok := firstSeen[key]; !ok || rec.When.Before(first) {
+				firstSeen[key] = rec.When
+			}
+		case strings.Contains(rec.Protocol, "find-node"):
+			report.FindNodeQueries++
+		}
+	}
+
+	// per-message propagation latency - injection to the last first-receipt.
+	// The origin getting its own message back through the mesh is overhead,
+	// not propagation, so it contributes to Deliveries but not here.
+	latest := make(map[string]time.Time)
+	reached := make(map[string]int)
+	for key, when := range firstSeen {
+		inj, ok := f.injected[key.digest]
+		if !ok || key.to == inj.origin {
+			continue
+		}
+		reached[key.digest]++
+		if when.After(latest[key.digest]) {
+			latest[key.digest] = when
+		}
+	}
+
+	var latencies []float64
+	var pairs int
+	for digest, when := range latest {
+		latencies = append(latencies, float64(when.Sub(f.injected[digest].when))/float64(time.Millisecond))
+		pairs += reached[digest]
+	}
+
+	report.P50LatencyMs = percentile(latencies, 0.50)
+	report.P99LatencyMs = percentile(latencies, 0.99)
+	if pairs > 0 {
+		report.DuplicateFactor = float64(report.Deliveries) / float64(pairs)
+	}
+	// the origin counts as reached without a recorded delivery
+	if possible := len(f.injected) * len(f.nodes); possible > 0 {
+		report.Coverage = float64(pairs+len(f.injected)) / float64(possible)
+	}
+
+	return report
+}
+
+// percentile returns the p-th percentile of values, interpolation-free - the
+// nearest-rank value. An empty input yields 0.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := int(p * float64(len(sorted)))
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
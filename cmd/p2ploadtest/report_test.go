@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeReport(t *testing.T) {
+	t0 := time.Now()
+
+	f := &flood{
+		nodes: []*floodNode{{}, {}, {}}, // n1 (origin), n2, n3
+		injected: map[string]injection{
+			"a": {when: t0, origin: "n1"},
+			"b": {when: t0, origin: "n1"},
+		},
+		peaks: map[string]GaugePeak{"flood.seen": {Node: "n2", Value: 2}},
+	}
+
+	records := []simulator.TrafficRecord{
+		// message a reaches n2 and n3, with one duplicate to n2
+		{From: "n1", To: "n2", Protocol: floodProtocol, Digest: []byte("a"), When: t0.Add(10 * time.Millisecond)},
+		{From: "n1", To: "n3", Protocol: floodProtocol, Digest: []byte("a"), When: t0.Add(30 * time.Millisecond)},
+		{From: "n3", To: "n2", Protocol: floodProtocol, Digest: []byte("a"), When: t0.Add(40 * time.Millisecond)},
+		// message b only reaches n2, and echoes back to its origin
+		{From: "n1", To: "n2", Protocol: floodProtocol, Digest: []byte("b"), When: t0.Add(20 * time.Millisecond)},
+		{From: "n2", To: "n1", Protocol: floodProtocol, Digest: []byte("b"), When: t0.Add(25 * time.Millisecond)},
+		// unrelated dht traffic counts separately
+		{From: "n1", To: "n2", Protocol: "/dht/1.0/find-node/", Digest: []byte("q"), When: t0},
+	}
+
+	report := computeReport(Scenario{Name: "unit", Seed: 7}, records, f)
+
+	assert.Equal(t, "unit", report.Scenario)
+	assert.Equal(t, int64(7), report.Seed)
+	assert.Equal(t, 3, report.Nodes)
+	assert.Equal(t, 2, report.Messages)
+	assert.Equal(t, 5, report.Deliveries)
+	assert.Equal(t, 1, report.FindNodeQueries)
+
+	// message latencies are 30ms (a, last first-receipt) and 20ms (b) - the
+	// echo back to b's origin is not propagation
+	assert.Equal(t, 30.0, report.P50LatencyMs)
+	assert.Equal(t, 30.0, report.P99LatencyMs)
+
+	// 5 deliveries over 3 reached (message, node) pairs
+	assert.InDelta(t, 5.0/3.0, report.DuplicateFactor, 0.001)
+
+	// 3 reached pairs plus the 2 origins, out of 2 messages * 3 nodes
+	assert.InDelta(t, 5.0/6.0, report.Coverage, 0.001)
+
+	assert.Equal(t, int64(2), report.PeakGauges["flood.seen"].Value)
+}
+
+func TestComputeReportEmpty(t *testing.T) {
+	f := &flood{injected: map[string]injection{}, peaks: map[string]GaugePeak{}}
+	report := computeReport(Scenario{Name: "empty"}, nil, f)
+
+	assert.Equal(t, 0, report.Deliveries)
+	assert.Equal(t, 0.0, report.P50LatencyMs)
+	assert.Equal(t, 0.0, report.DuplicateFactor)
+	assert.Equal(t, 0.0, report.Coverage)
+}
+
+func TestPercentile(t *testing.T) {
+	assert.Equal(t, 0.0, percentile(nil, 0.5))
+	assert.Equal(t, 5.0, percentile([]float64{5}, 0.99))
+
+	values := []float64{40, 10, 30, 20}
+	assert.Equal(t, 30.0, percentile(values, 0.50))
+	assert.Equal(t, 40.0, percentile(values, 0.99))
+	// the input must not be reordered
+	assert.Equal(t, []float64{40, 10, 30, 20}, values)
+}
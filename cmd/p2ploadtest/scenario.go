@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator/scenario"
+)
+
+// Scenario parameterizes one load-test run. Scenarios are plain data so they
+// can be checked in as Go values or versioned as JSON files.
+type Scenario struct {
+	Name           string  `json:"name"`
+	Seed           int64   `json:"seed"`
+	Nodes          int     `json:"nodes"`
+	BootstrapNodes int     `json:"bootstrapNodes"`
+	Fanout         int     `json:"fanout"`         // flood relay targets per node
+	Messages       int     `json:"messages"`       // messages injected over the run
+	MessageGapMs   int     `json:"messageGapMs"`   // injection interval, sets the message rate
+	MessageSize    int     `json:"messageSize"`    // payload bytes per message
+	LatencyMs      int     `json:"latencyMs"`      // per-link delivery latency
+	JitterMs       int     `json:"jitterMs"`       // extra random delay per delivery
+	LossProb       float64 `json:"lossProb"`       // per-delivery drop probability
+	BootstrapSecs  int     `json:"bootstrapSecs"`  // bound on the dht bootstrap phase
+	QuiescenceSecs int     `json:"quiescenceSecs"` // bound on the post-injection drain
+}
+
+// Baseline is a small healthy network - no loss, moderate fanout. Its report
+// establishes the reference propagation numbers the lossy scenario is
+// compared against.
+var Baseline = Scenario{
+	Name:           "baseline",
+	Seed:           1,
+	Nodes:          12,
+	BootstrapNodes: 1,
+	Fanout:         4,
+	Messages:       20,
+	MessageGapMs:   20,
+	MessageSize:    128,
+	LatencyMs:      5,
+	JitterMs:       2,
+}
+
+// Lossy is the baseline network with 10% per-delivery loss. Redundant flood
+// deliveries are expected to keep coverage high while the duplicate factor
+// and tail latency grow.
+var Lossy = Scenario{
+	Name:           "lossy",
+	Seed:           1,
+	Nodes:          12,
+	BootstrapNodes: 1,
+	Fanout:         5,
+	Messages:       20,
+	MessageGapMs:   20,
+	MessageSize:    128,
+	LatencyMs:      5,
+	JitterMs:       2,
+	LossProb:       0.1,
+}
+
+// LoadScenario reads a scenario from a JSON file.
+func LoadScenario(path string) (Scenario, error) {
+	var sc Scenario
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return sc, err
+	}
+	if err := json.Unmarshal(raw, &sc); err != nil {
+		return sc, fmt.Errorf("scenario %v: %v", path, err)
+	}
+	return sc, nil
+}
+
+// withDefaults fills the parameters a scenario left at zero. Latency is
+// forced above zero because zero-latency links deliver synchronously, and a
+// relay mesh on synchronous links can deadlock on two nodes forwarding to
+// each other.
+func (sc Scenario) withDefaults() Scenario {
+	if sc.BootstrapNodes == 0 {
+		sc.BootstrapNodes = 1
+	}
+	if sc.Fanout == 0 {
+		sc.Fanout = 3
+	}
+	if sc.MessageSize == 0 {
+		sc.MessageSize = 64
+	}
+	if sc.LatencyMs <= 0 {
+		sc.LatencyMs = 1
+	}
+	if sc.BootstrapSecs == 0 {
+		sc.BootstrapSecs = 30
+	}
+	if sc.QuiescenceSecs == 0 {
+		sc.QuiescenceSecs = 10
+	}
+	return sc
+}
+
+// Run brings up the scenario's network, floods it with the configured
+// traffic and computes the report.
+func Run(sc Scenario) (*Report, error) {
+	sc = sc.withDefaults()
+
+	opts := []simulator.Option{
+		simulator.WithDefaultLatency(time.Duration(sc.LatencyMs) * time.Millisecond),
+	}
+	if sc.JitterMs > 0 {
+		opts = append(opts, simulator.WithJitter(time.Duration(sc.JitterMs)*time.Millisecond, sc.Seed))
+	}
+	sim := simulator.NewSeeded(sc.Seed, opts...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(sc.BootstrapSecs)*time.Second)
+	defer cancel()
+
+	built, err := scenario.New(sim).
+		WithBootstrapNodes(sc.BootstrapNodes).
+		WithNodes(sc.Nodes, nil).
+		Start(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("scenario start: %v", err)
+	}
+	if err := built.WaitBootstrapped(ctx); err != nil {
+		return nil, fmt.Errorf("bootstrap: %v", err)
+	}
+
+	flood := newFlood(sim, built, sc)
+	flood.run()
+
+	return computeReport(sc, sim.Traffic(), flood), nil
+}
@@ -5,6 +5,7 @@ import (
 	apiConfig "github.com/spacemeshos/go-spacemesh/api/config"
 	consensusConfig "github.com/spacemeshos/go-spacemesh/consensus/config"
 	"github.com/spacemeshos/go-spacemesh/filesystem"
+	hareConfig "github.com/spacemeshos/go-spacemesh/hare/config"
 	"github.com/spacemeshos/go-spacemesh/log"
 	p2pConfig "github.com/spacemeshos/go-spacemesh/p2p/config"
 	"github.com/spf13/viper"
@@ -30,10 +31,18 @@ var (
 
 // Config defines the top level configuration for a spacemesh node
 type Config struct {
+	// Version is the config schema version, see CurrentConfigVersion
+	Version int `mapstructure:"config_version"`
+
 	BaseConfig `mapstructure:"main"`
 	P2P        p2pConfig.Config       `mapstructure:"p2p"`
 	API        apiConfig.Config       `mapstructure:"api"`
 	CONSENSUS  consensusConfig.Config `mapstructure:"consensus"`
+	HARE       hareConfig.Config      `mapstructure:"hare"`
+
+	// value origins, kept for EffectiveSource
+	fileKeys map[string]bool
+	explicit map[string]bool
 }
 
 // BaseConfig defines the default configuration options for spacemesh app
@@ -52,10 +61,12 @@ type BaseConfig struct {
 // DefaultConfig returns the default configuration for a spacemesh node
 func DefaultConfig() Config {
 	return Config{
+		Version:    CurrentConfigVersion,
 		BaseConfig: defaultBaseConfig(),
 		P2P:        p2pConfig.DefaultConfig(),
 		API:        apiConfig.DefaultConfig(),
 		CONSENSUS:  consensusConfig.DefaultConfig(),
+		HARE:       hareConfig.DefaultConfig(),
 	}
 }
 
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Redacted replaces the value of any field tagged `sensitive:"true"` in a
+// config dump, so key material paths and passphrases never reach a support
+// ticket.
+const Redacted = "[redacted]"
+
+// Dump writes the fully merged effective config as indented JSON with stable
+// key ordering. Sensitive-tagged fields are redacted and each section is
+// annotated with a summary of where its values came from. The output is
+// deterministic, so it can back a status API.
+func (cfg *Config) Dump(w io.Writer) error {
+	out := make(map[string]interface{})
+	for section, val := range dumpStruct(reflect.ValueOf(*cfg)) {
+		if sub, ok := val.(map[string]interface{}); ok {
+			sub["_sources"] = cfg.sectionSources(section, sub)
+		}
+		out[section] = val
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// sectionSources counts a section's values by origin, e.g. "file:3 default:12".
+func (cfg *Config) sectionSources(section string, m map[string]interface{}) string {
+	counts := make(map[string]int)
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for key, val := range m {
+			if sub, ok := val.(map[string]interface{}); ok {
+				walk(prefix+key+".", sub)
+				continue
+			}
+			counts[cfg.EffectiveSource(prefix+key)]++
+		}
+	}
+	walk(section+".", m)
+
+	var parts []string
+	for _, src := range []string{SourceExplicit, SourceEnv, SourceFile, SourceDefault} {
+		if counts[src] > 0 {
+			parts = append(parts, fmt.Sprintf("%s:%d", src, counts[src]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// dumpStruct converts a config struct into nested maps keyed by mapstructure
+// tags, redacting sensitive fields and rendering durations readably.
+func dumpStruct(val reflect.Value) map[string]interface{} {
+	out := make(map[string]interface{})
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		key := f.Tag.Get("mapstructure")
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		if key == "-" {
+			continue
+		}
+		if f.Tag.Get("sensitive") == "true" {
+			out[key] = Redacted
+			continue
+		}
+		fv := val.Field(i)
+		if d, ok := fv.Interface().(time.Duration); ok {
+			out[key] = d.String()
+			continue
+		}
+		if ts, ok := fv.Interface().(time.Time); ok {
+			out[key] = ts.UTC().Format(time.RFC3339)
+			continue
+		}
+		if f.Type.Kind() == reflect.Struct {
+			out[key] = dumpStruct(fv)
+			continue
+		}
+		out[key] = fv.Interface()
+	}
+	return out
+}
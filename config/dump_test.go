@@ -0,0 +1,54 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// goldenConfig pins the machine-dependent paths so the dump is comparable
+// against the golden file everywhere.
+func goldenConfig() Config {
+	conf := DefaultConfig()
+	conf.HomeDir = "/home/spacemesh"
+	conf.DataDir = "/home/spacemesh/spacemesh"
+	conf.ConfigFile = "/home/spacemesh/config.toml"
+	conf.LogDir = "/home/spacemesh/spacemesh.log"
+	conf.AccountDir = "/home/spacemesh/accounts"
+	conf.CONSENSUS.StartTime = time.Unix(1514764800, 0) // 2018-01-01T00:00:00Z
+	return conf
+}
+
+func TestDumpGolden(t *testing.T) {
+	conf := goldenConfig()
+	var buf bytes.Buffer
+	assert.NoError(t, conf.Dump(&buf))
+
+	expected, err := ioutil.ReadFile("testdata/dump.golden")
+	assert.NoError(t, err, "regenerate with: go test -run TestDumpGolden after updating testdata/dump.golden")
+	assert.Equal(t, string(expected), buf.String())
+}
+
+func TestDumpDeterministic(t *testing.T) {
+	conf := goldenConfig()
+	var first, second bytes.Buffer
+	assert.NoError(t, conf.Dump(&first))
+	assert.NoError(t, conf.Dump(&second))
+	assert.Equal(t, first.String(), second.String())
+}
+
+func TestDumpRedactsSensitiveFields(t *testing.T) {
+	type creds struct {
+		KeyFile    string `mapstructure:"key-file" sensitive:"true"`
+		Passphrase string `mapstructure:"passphrase" sensitive:"true"`
+		Port       int    `mapstructure:"port"`
+	}
+	out := dumpStruct(reflect.ValueOf(creds{KeyFile: "/keys/node.key", Passphrase: "hunter2", Port: 42}))
+	assert.Equal(t, Redacted, out["key-file"])
+	assert.Equal(t, Redacted, out["passphrase"])
+	assert.Equal(t, 42, out["port"], "non-sensitive fields must dump as-is")
+}
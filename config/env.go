@@ -0,0 +1,141 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix prefixes every environment override: the config key
+// "p2p.tcp-port" is read from SPACEMESH_P2P_TCP_PORT.
+const EnvPrefix = "spacemesh"
+
+// value sources reported by EffectiveSource, strongest first.
+const (
+	SourceExplicit = "explicit"
+	SourceEnv      = "environment"
+	SourceFile     = "file"
+	SourceDefault  = "default"
+)
+
+var envReplacer = strings.NewReplacer(".", "_", "-", "_")
+
+// EnvVar returns the environment variable overriding the given config key.
+func EnvVar(key string) string {
+	return strings.ToUpper(EnvPrefix + "_" + envReplacer.Replace(key))
+}
+
+// BindEnv wires the defaults and the environment overrides into vip, making
+// every key of the config struct overridable as SPACEMESH_<SECTION>_<KEY>.
+// The precedence chain is: explicit Set > environment > config file >
+// defaults. Environment strings go through the same decoding and validation
+// as file values.
+func BindEnv(vip *viper.Viper, defaults Config) {
+	for key, val := range flattenStruct(reflect.ValueOf(defaults)) {
+		vip.SetDefault(key, val)
+	}
+	vip.SetEnvPrefix(EnvPrefix)
+	vip.SetEnvKeyReplacer(envReplacer)
+	vip.AutomaticEnv()
+}
+
+// LoadEffectiveConfig loads the config file (when present), applies the
+// environment overrides and returns the unmarshaled config. The returned
+// config remembers each value's origin - see EffectiveSource.
+func LoadEffectiveConfig(fileLocation string, vip *viper.Viper) (Config, error) {
+	if err := LoadConfig(fileLocation, vip); err != nil {
+		log.Warning("couldn't load config file at location: %s, using defaults and environment overrides. error: %v",
+			fileLocation, err)
+	}
+
+	// only the file populated vip so far - remember its keys before the
+	// defaults are merged in
+	fileKeys := make(map[string]bool)
+	for _, key := range vip.AllKeys() {
+		fileKeys[key] = true
+	}
+
+	conf := DefaultConfig()
+	BindEnv(vip, conf)
+	if err := vip.Unmarshal(&conf); err != nil {
+		return conf, decorateEnvError(err, vip)
+	}
+	conf.fileKeys = fileKeys
+	return conf, nil
+}
+
+// RecordExplicit marks key as explicitly overridden - a changed cli flag or
+// a direct Set, the strongest source in the chain.
+func (cfg *Config) RecordExplicit(key string) {
+	if cfg.explicit == nil {
+		cfg.explicit = make(map[string]bool)
+	}
+	cfg.explicit[strings.ToLower(key)] = true
+}
+
+// EffectiveSource reports where the value of key comes from, following the
+// precedence chain: explicit Set > environment > config file > defaults. A
+// config that was not loaded through LoadEffectiveConfig reports every
+// untouched key as "default".
+func (cfg *Config) EffectiveSource(key string) string {
+	key = strings.ToLower(key)
+	if cfg.explicit[key] || cfg.explicit[key[strings.LastIndex(key, ".")+1:]] {
+		return SourceExplicit
+	}
+	if os.Getenv(EnvVar(key)) != "" {
+		return SourceEnv
+	}
+	if cfg.fileKeys[key] {
+		return SourceFile
+	}
+	return SourceDefault
+}
+
+// decorateEnvError names the environment variable behind a decode failure,
+// so the operator is pointed at the variable rather than at a config key
+// that appears in no file.
+func decorateEnvError(err error, vip *viper.Viper) error {
+	normalize := func(s string) string {
+		return strings.Replace(strings.ToLower(s), "-", "", -1)
+	}
+	msg := normalize(err.Error())
+	for _, key := range vip.AllKeys() {
+		if os.Getenv(EnvVar(key)) == "" {
+			continue
+		}
+		if strings.Contains(msg, normalize(key)) {
+			return fmt.Errorf("%v (value set by %s)", err, EnvVar(key))
+		}
+	}
+	return err
+}
+
+// flattenStruct converts a config struct into nested maps keyed by
+// mapstructure tags, the shape viper stores defaults in.
+func flattenStruct(val reflect.Value) map[string]interface{} {
+	out := make(map[string]interface{})
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		key := f.Tag.Get("mapstructure")
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		if key == "-" {
+			continue
+		}
+		if f.Type.Kind() == reflect.Struct {
+			out[key] = flattenStruct(val.Field(i))
+			continue
+		}
+		out[key] = val.Field(i).Interface()
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "env_test")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "config.toml")
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestEnvOverridesNestedKey(t *testing.T) {
+	os.Setenv("SPACEMESH_P2P_TCP_PORT", "1234")
+	defer os.Unsetenv("SPACEMESH_P2P_TCP_PORT")
+
+	conf, err := LoadEffectiveConfig("no-such-config.toml", viper.New())
+	assert.NoError(t, err)
+	assert.Equal(t, 1234, conf.P2P.TCPPort)
+	assert.Equal(t, SourceEnv, conf.EffectiveSource("p2p.tcp-port"))
+}
+
+func TestEnvBeatsFileValue(t *testing.T) {
+	path := writeConfigFile(t, `
+[p2p]
+tcp-port = 5555
+dial-timeout = "10s"
+`)
+
+	// without the env var the file value applies
+	conf, err := LoadEffectiveConfig(path, viper.New())
+	assert.NoError(t, err)
+	assert.Equal(t, 5555, conf.P2P.TCPPort)
+	assert.Equal(t, 10*time.Second, conf.P2P.DialTimeout)
+	assert.Equal(t, SourceFile, conf.EffectiveSource("p2p.dial-timeout"))
+
+	// the env var ranks above it, duration strings included
+	os.Setenv("SPACEMESH_P2P_DIAL_TIMEOUT", "45s")
+	defer os.Unsetenv("SPACEMESH_P2P_DIAL_TIMEOUT")
+	conf, err = LoadEffectiveConfig(path, viper.New())
+	assert.NoError(t, err)
+	assert.Equal(t, 45*time.Second, conf.P2P.DialTimeout)
+	assert.Equal(t, 5555, conf.P2P.TCPPort, "the untouched key keeps the file value")
+	assert.Equal(t, SourceEnv, conf.EffectiveSource("p2p.dial-timeout"))
+}
+
+func TestEnvInvalidValueNamesVariable(t *testing.T) {
+	os.Setenv("SPACEMESH_P2P_DIAL_TIMEOUT", "banana")
+	defer os.Unsetenv("SPACEMESH_P2P_DIAL_TIMEOUT")
+
+	_, err := LoadEffectiveConfig("no-such-config.toml", viper.New())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "SPACEMESH_P2P_DIAL_TIMEOUT", "the error should point at the env var")
+}
+
+func TestEffectiveSourceExplicit(t *testing.T) {
+	conf := DefaultConfig()
+	assert.Equal(t, SourceDefault, conf.EffectiveSource("p2p.tcp-port"))
+	conf.RecordExplicit("tcp-port") // cli flags are recorded by their flat name
+	assert.Equal(t, SourceExplicit, conf.EffectiveSource("p2p.tcp-port"))
+}
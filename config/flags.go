@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// BindFlags walks a config section struct and registers a pflag for every
+// exported field, named by its mapstructure tag, documented by its usage tag
+// and defaulting to the field's current value. Each flag is bound into vip so
+// it keeps the usual precedence - a changed flag beats env, file and
+// defaults. Nested structs are walked recursively.
+//
+// The section schema is checked as it is walked: an exported field without a
+// mapstructure or usage tag and a flag name that is already taken are
+// errors, so a half-plumbed field fails at startup instead of silently
+// missing its flag. Fields tagged `mapstructure:"-"` are skipped.
+func BindFlags(fs *pflag.FlagSet, vip *viper.Viper, section interface{}) error {
+	val := reflect.ValueOf(section)
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct {
+		return fmt.Errorf("BindFlags expects a config section struct, got %T", section)
+	}
+	return bindStructFlags(fs, vip, val)
+}
+
+// MustBindFlags is BindFlags for init-time wiring - a broken section schema
+// is a programming error, so it panics.
+func MustBindFlags(fs *pflag.FlagSet, vip *viper.Viper, section interface{}) {
+	if err := BindFlags(fs, vip, section); err != nil {
+		panic(err)
+	}
+}
+
+func bindStructFlags(fs *pflag.FlagSet, vip *viper.Viper, val reflect.Value) error {
+	t := val.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		name := f.Tag.Get("mapstructure")
+		if name == "-" {
+			continue
+		}
+		fv := val.Field(i)
+		// nested structs only group fields, their own tag names the config
+		// section and doesn't become a flag
+		if f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{}) {
+			if err := bindStructFlags(fs, vip, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			return fmt.Errorf("config field %v.%v has no mapstructure tag", t.Name(), f.Name)
+		}
+		usage := f.Tag.Get("usage")
+		if usage == "" {
+			return fmt.Errorf("config field %v.%v has no usage tag", t.Name(), f.Name)
+		}
+		if fs.Lookup(name) != nil {
+			return fmt.Errorf("flag --%v of %v.%v is already registered", name, t.Name(), f.Name)
+		}
+		if err := registerFlag(fs, name, usage, fv); err != nil {
+			return fmt.Errorf("config field %v.%v: %v", t.Name(), f.Name, err)
+		}
+		if err := vip.BindPFlag(name, fs.Lookup(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// registerFlag adds a single flag with the field's value as its default.
+func registerFlag(fs *pflag.FlagSet, name, usage string, fv reflect.Value) error {
+	switch v := fv.Interface().(type) {
+	case time.Duration:
+		fs.Duration(name, v, usage)
+	case bool:
+		fs.Bool(name, v, usage)
+	case int:
+		fs.Int(name, v, usage)
+	case int8:
+		fs.Int8(name, v, usage)
+	case int64:
+		fs.Int64(name, v, usage)
+	case uint:
+		fs.Uint(name, v, usage)
+	case uint16:
+		fs.Uint16(name, v, usage)
+	case string:
+		fs.String(name, v, usage)
+	case []string:
+		fs.StringSlice(name, v, usage)
+	case float64:
+		fs.Float64(name, v, usage)
+	default:
+		return fmt.Errorf("no flag type for %v", fv.Type())
+	}
+	return nil
+}
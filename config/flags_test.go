@@ -0,0 +1,101 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeGossipConfig struct {
+	Fanout   int           `mapstructure:"gossip-fanout" usage:"number of peers each message is relayed to"`
+	Interval time.Duration `mapstructure:"gossip-interval" usage:"time between gossip rounds"`
+	Relay    fakeRelayConfig
+}
+
+type fakeRelayConfig struct {
+	Enable bool `mapstructure:"gossip-relay" usage:"relay messages for other nodes"`
+}
+
+func TestBindFlagsGeneratesFlags(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	vip := viper.New()
+
+	section := fakeGossipConfig{Fanout: 3, Interval: 30 * time.Second}
+	assert.NoError(t, BindFlags(fs, vip, &section))
+	assert.NoError(t, fs.Parse([]string{"--gossip-fanout=12"}))
+
+	assert.Equal(t, 12, vip.GetInt("gossip-fanout"), "the changed flag should surface through viper")
+	assert.Equal(t, 30*time.Second, vip.GetDuration("gossip-interval"), "an untouched flag keeps the struct default")
+	assert.Equal(t, false, vip.GetBool("gossip-relay"), "nested struct fields get flags too")
+	assert.Contains(t, fs.FlagUsages(), "number of peers each message is relayed to", "help text comes from the usage tag")
+}
+
+func TestBindFlagsPrecedence(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	vip := viper.New()
+	vip.SetConfigType("toml")
+	assert.NoError(t, vip.ReadConfig(bytes.NewBufferString("gossip-fanout = 5\n")))
+	vip.SetEnvPrefix(EnvPrefix)
+	vip.SetEnvKeyReplacer(envReplacer)
+	vip.AutomaticEnv()
+
+	section := fakeGossipConfig{Fanout: 3}
+	assert.NoError(t, BindFlags(fs, vip, &section))
+
+	// the file value applies while the flag is untouched
+	assert.Equal(t, 5, vip.GetInt("gossip-fanout"))
+
+	// env ranks above the file
+	os.Setenv("SPACEMESH_GOSSIP_FANOUT", "7")
+	defer os.Unsetenv("SPACEMESH_GOSSIP_FANOUT")
+	assert.Equal(t, 7, vip.GetInt("gossip-fanout"))
+
+	// a changed flag beats them both
+	assert.NoError(t, fs.Parse([]string{"--gossip-fanout=12"}))
+	assert.Equal(t, 12, vip.GetInt("gossip-fanout"))
+}
+
+func TestBindFlagsUntaggedFieldFails(t *testing.T) {
+	type broken struct {
+		Fanout int `mapstructure:"gossip-fanout" usage:"number of peers"`
+		Degree int
+	}
+	err := BindFlags(pflag.NewFlagSet("test", pflag.ContinueOnError), viper.New(), &broken{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Degree")
+	assert.Contains(t, err.Error(), "mapstructure")
+}
+
+func TestBindFlagsMissingUsageFails(t *testing.T) {
+	type broken struct {
+		Fanout int `mapstructure:"gossip-fanout"`
+	}
+	err := BindFlags(pflag.NewFlagSet("test", pflag.ContinueOnError), viper.New(), &broken{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "usage")
+}
+
+func TestBindFlagsCollisionFails(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.Int("gossip-fanout", 1, "registered by hand first")
+
+	err := BindFlags(fs, viper.New(), &fakeGossipConfig{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "already registered")
+}
+
+func TestBindFlagsAPISchema(t *testing.T) {
+	// the api section is bound at startup, so its schema must stay complete
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	conf := DefaultConfig()
+	assert.NoError(t, BindFlags(fs, viper.New(), &conf.API))
+	for _, name := range []string{"grpc-server", "grpc-port", "json-server", "json-port"} {
+		assert.NotNil(t, fs.Lookup(name), "missing generated flag --"+strings.TrimPrefix(name, "--"))
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spf13/viper"
+)
+
+// CurrentConfigVersion is the schema version written by this release. Files
+// carrying an older version are migrated on load, files from the future are
+// refused.
+const CurrentConfigVersion = 1
+
+// migration renames keys when the schema version moves. Files at or below
+// fromVersion get every old key copied to its new name with a deprecation
+// notice.
+type migration struct {
+	fromVersion int
+	renames     map[string]string
+}
+
+// migrations lists the renames between consecutive schema versions, oldest
+// first. Version 0 covers pre-versioned files that carry no config_version.
+var migrations = []migration{
+	{fromVersion: 0, renames: map[string]string{
+		"p2p.port": "p2p.tcp-port",
+	}},
+}
+
+// LoadFile loads a config file by explicit path, picking the format from the
+// file extension (toml, yaml or json). Unlike LoadConfig it never falls back
+// to another location or to bare defaults - a missing or unparseable file is
+// an error. The file's config_version is checked against the supported range
+// and renamed keys from older versions are migrated with a deprecation
+// notice. Keys outside the known schema are errors - see LoadFileLenient.
+func LoadFile(path string) (*Config, error) {
+	return loadFile(path, false)
+}
+
+// LoadFileLenient is LoadFile with unknown keys downgraded to warnings, for
+// operators who need to share a file with newer builds.
+func LoadFileLenient(path string) (*Config, error) {
+	return loadFile(path, true)
+}
+
+func loadFile(path string, lenient bool) (*Config, error) {
+	switch strings.TrimPrefix(filepath.Ext(path), ".") {
+	case "toml", "yaml", "yml", "json":
+	default:
+		return nil, fmt.Errorf("unsupported config format for %v, expected a .toml, .yaml or .json file", path)
+	}
+
+	vip := viper.New()
+	vip.SetConfigFile(path)
+	if err := vip.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read config file %v: %v", path, err)
+	}
+
+	// a file without config_version predates versioning and reads as 0
+	version := vip.GetInt("config_version")
+	if version > CurrentConfigVersion {
+		return nil, fmt.Errorf("config file %v has version %d, this build supports up to %d", path, version, CurrentConfigVersion)
+	}
+
+	for _, m := range migrations {
+		if version > m.fromVersion {
+			continue
+		}
+		for old, renamed := range m.renames {
+			if vip.IsSet(old) && !vip.IsSet(renamed) {
+				log.Warning("config key %q was renamed to %q, please update %v", old, renamed, path)
+				vip.Set(renamed, vip.Get(old))
+			}
+		}
+	}
+
+	applyDeprecations(vip, path)
+
+	conf := DefaultConfig()
+	fileKeys := make(map[string]bool)
+	for _, key := range vip.AllKeys() {
+		fileKeys[key] = true
+	}
+	if err := checkUnknownKeys(vip.AllKeys(), lenient); err != nil {
+		return nil, fmt.Errorf("config file %v: %v", path, err)
+	}
+	if err := vip.Unmarshal(&conf); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %v: %v", path, err)
+	}
+	conf.fileKeys = fileKeys
+
+	return &conf, nil
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTempConfig(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "loadfile_test")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestLoadFileEquivalentFormats(t *testing.T) {
+	toml := writeTempConfig(t, "config.toml", `
+config_version = 1
+[p2p]
+tcp-port = 7777
+dial-timeout = "20s"
+`)
+	yaml := writeTempConfig(t, "config.yaml", `
+config_version: 1
+p2p:
+  tcp-port: 7777
+  dial-timeout: 20s
+`)
+	json := writeTempConfig(t, "config.json", `
+{"config_version": 1, "p2p": {"tcp-port": 7777, "dial-timeout": "20s"}}
+`)
+
+	fromTOML, err := LoadFile(toml)
+	assert.NoError(t, err)
+	fromYAML, err := LoadFile(yaml)
+	assert.NoError(t, err)
+	fromJSON, err := LoadFile(json)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 7777, fromTOML.P2P.TCPPort)
+	assert.Equal(t, 20*time.Second, fromTOML.P2P.DialTimeout)
+
+	// the consensus start-time default is time.Now(), pin it for the comparison
+	fromYAML.CONSENSUS.StartTime = fromTOML.CONSENSUS.StartTime
+	fromJSON.CONSENSUS.StartTime = fromTOML.CONSENSUS.StartTime
+	assert.Equal(t, fromTOML, fromYAML, "yaml must load the same struct as toml")
+	assert.Equal(t, fromTOML, fromJSON, "json must load the same struct as toml")
+}
+
+func TestLoadFileMissing(t *testing.T) {
+	_, err := LoadFile("/no/such/dir/config.toml")
+	assert.Error(t, err, "a missing file must not silently fall back to defaults")
+	assert.Contains(t, err.Error(), "config.toml")
+}
+
+func TestLoadFileUnsupportedExtension(t *testing.T) {
+	path := writeTempConfig(t, "config.ini", "tcp-port = 7777")
+	_, err := LoadFile(path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported config format")
+}
+
+func TestLoadFileUnparseable(t *testing.T) {
+	path := writeTempConfig(t, "config.toml", "[p2p\ntcp-port 7777")
+	_, err := LoadFile(path)
+	assert.Error(t, err, "a broken file must not silently fall back to defaults")
+}
+
+func TestLoadFileFutureVersionRefused(t *testing.T) {
+	path := writeTempConfig(t, "config.toml", "config_version = 99")
+	_, err := LoadFile(path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "version 99")
+}
+
+func TestLoadFileMigratesRenamedKey(t *testing.T) {
+	// no config_version marks a pre-versioned file, which still used p2p.port
+	path := writeTempConfig(t, "config.toml", `
+[p2p]
+port = 7513
+`)
+	conf, err := LoadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 7513, conf.P2P.TCPPort, "the old key should migrate to its new name")
+}
+
+func TestLoadFileRenamedKeyLosesToNewKey(t *testing.T) {
+	path := writeTempConfig(t, "config.toml", `
+[p2p]
+port = 7513
+tcp-port = 7514
+`)
+	conf, err := LoadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 7514, conf.P2P.TCPPort, "an explicitly set new key wins over the migrated one")
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spf13/viper"
+)
+
+// deprecatedKeys maps config keys that still work to their replacements.
+// The old key's value is forwarded to the new one with a logged notice, so
+// operator files keep working across a rename cycle before the key moves
+// into the version migration table and finally disappears.
+var deprecatedKeys = map[string]string{
+	"main.datadir": "main.data-folder",
+}
+
+// applyDeprecations forwards every deprecated key present in vip to its
+// replacement, unless the replacement is set explicitly too.
+func applyDeprecations(vip *viper.Viper, path string) {
+	for old, renamed := range deprecatedKeys {
+		if vip.IsSet(old) && !vip.IsSet(renamed) {
+			log.Warning("config key %q is deprecated in favor of %q, please update %v", old, renamed, path)
+			vip.Set(renamed, vip.Get(old))
+		}
+	}
+}
+
+// checkUnknownKeys compares the keys read from a config file against the
+// known schema. An unknown key is an error - or a logged warning when
+// lenient - naming the closest known key when one is plausibly a typo.
+// Deprecated and migrated keys count as known, they are forwarded instead.
+func checkUnknownKeys(fileKeys []string, lenient bool) error {
+	known := knownKeys()
+
+	var unknown []string
+	for _, key := range fileKeys {
+		if known[key] {
+			continue
+		}
+		if _, ok := deprecatedKeys[key]; ok {
+			continue
+		}
+		msg := fmt.Sprintf("unknown config key %q", key)
+		if closest := closestKnownKey(key, known); closest != "" {
+			msg += fmt.Sprintf(", did you mean %q?", closest)
+		}
+		unknown = append(unknown, msg)
+	}
+
+	if len(unknown) == 0 {
+		return nil
+	}
+	if lenient {
+		for _, msg := range unknown {
+			log.Warning("%v", msg)
+		}
+		return nil
+	}
+	return errors.New(strings.Join(unknown, "; "))
+}
+
+// knownKeys returns the full flattened schema, including the old names of
+// version-migrated keys.
+func knownKeys() map[string]bool {
+	known := make(map[string]bool)
+	var walk func(prefix string, m map[string]interface{})
+	walk = func(prefix string, m map[string]interface{}) {
+		for key, val := range m {
+			// a struct without tagged fields (e.g. time.Time) is a leaf
+			if sub, ok := val.(map[string]interface{}); ok && len(sub) > 0 {
+				walk(prefix+key+".", sub)
+				continue
+			}
+			known[prefix+key] = true
+		}
+	}
+	walk("", flattenStruct(reflect.ValueOf(DefaultConfig())))
+	for _, m := range migrations {
+		for old := range m.renames {
+			known[old] = true
+		}
+	}
+	return known
+}
+
+// closestKnownKey returns the known key nearest to key by edit distance, or
+// the empty string when nothing is close enough to be a likely typo.
+func closestKnownKey(key string, known map[string]bool) string {
+	const maxDistance = 3
+	best, bestDistance := "", maxDistance+1
+	for candidate := range known {
+		if d := editDistance(key, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance computes the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := 0; j <= len(b); j++ {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, min(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFileRejectsTypodKey(t *testing.T) {
+	path := writeTempConfig(t, "config.toml", `
+[p2p]
+tcp-prot = 7777
+`)
+	_, err := LoadFile(path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `"p2p.tcp-prot"`)
+	assert.Contains(t, err.Error(), `did you mean "p2p.tcp-port"?`)
+}
+
+func TestLoadFileRejectsUnknownKey(t *testing.T) {
+	path := writeTempConfig(t, "config.toml", "frobnicate-level = 9\n")
+	_, err := LoadFile(path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown config key "frobnicate-level"`)
+	assert.NotContains(t, err.Error(), "did you mean", "nothing in the schema is close to this key")
+}
+
+func TestLoadFileLenientWarnsOnUnknownKey(t *testing.T) {
+	path := writeTempConfig(t, "config.toml", "frobnicate-level = 9\n")
+	conf, err := LoadFileLenient(path)
+	assert.NoError(t, err, "lenient mode downgrades unknown keys to warnings")
+	assert.NotNil(t, conf)
+}
+
+func TestLoadFileForwardsDeprecatedKey(t *testing.T) {
+	path := writeTempConfig(t, "config.toml", `
+[main]
+datadir = "/tmp/spacemesh-data"
+`)
+	conf, err := LoadFile(path)
+	assert.NoError(t, err, "a deprecated key is forwarded, not rejected")
+	assert.Equal(t, "/tmp/spacemesh-data", conf.DataDir, "the deprecated value should land in the new field")
+}
+
+func TestLoadFileDeprecatedKeyLosesToNewKey(t *testing.T) {
+	path := writeTempConfig(t, "config.toml", `
+[main]
+datadir = "/tmp/old"
+data-folder = "/tmp/new"
+`)
+	conf, err := LoadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/new", conf.DataDir)
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("randcon", "randcon"))
+	assert.Equal(t, 2, editDistance("tcp-prot", "tcp-port"))
+	assert.Equal(t, 7, editDistance("", "randcon"))
+}
@@ -0,0 +1,41 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcutil/base58"
+)
+
+// idChecksumLen is the number of checksum bytes EncodeID appends. Four bytes
+// let a single-character typo slip through with probability 2^-32.
+const idChecksumLen = 4
+
+// idChecksum is the first idChecksumLen bytes of a double hash of the id,
+// base58check style.
+func idChecksum(id []byte) []byte {
+	return Sha256(Sha256(id))[:idChecksumLen]
+}
+
+// EncodeID encodes a node id in base58 with a checksum appended, so a typo
+// in a transcribed id is reported instead of silently resolving to a
+// different valid-looking key.
+func EncodeID(pub []byte) string {
+	data := make([]byte, 0, len(pub)+idChecksumLen)
+	data = append(data, pub...)
+	data = append(data, idChecksum(pub)...)
+	return base58.Encode(data)
+}
+
+// DecodeID decodes a node id written by EncodeID and verifies its checksum.
+func DecodeID(s string) ([]byte, error) {
+	raw := base58.Decode(s)
+	if len(raw) <= idChecksumLen {
+		return nil, fmt.Errorf("id %v is not a checksummed base58 string", s)
+	}
+	id, sum := raw[:len(raw)-idChecksumLen], raw[len(raw)-idChecksumLen:]
+	if !bytes.Equal(sum, idChecksum(id)) {
+		return nil, fmt.Errorf("checksum mismatch in id %v", s)
+	}
+	return id, nil
+}
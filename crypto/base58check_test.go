@@ -0,0 +1,57 @@
+package crypto
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodeIDRoundTrip(t *testing.T) {
+	_, pub, err := GenerateKeyPair()
+	assert.NoError(t, err)
+
+	s := EncodeID(pub.Bytes())
+	got, err := DecodeID(s)
+	assert.NoError(t, err)
+	assert.Equal(t, pub.Bytes(), got)
+
+	// the checksummed form differs from the raw base58 id
+	assert.NotEqual(t, pub.String(), s)
+}
+
+func TestDecodeIDRejectsGarbage(t *testing.T) {
+	_, err := DecodeID("")
+	assert.Error(t, err)
+	_, err = DecodeID("abc")
+	assert.Error(t, err)
+	_, err = DecodeID("0OIl") // not base58 alphabet
+	assert.Error(t, err)
+}
+
+// every single-character substitution of a checksummed id must be caught -
+// the checksum leaves a 2^-32 escape probability, far beyond what a
+// deterministic table of mutations could hit.
+func TestDecodeIDDetectsSingleCharTypos(t *testing.T) {
+	const alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+	_, pub, err := GenerateKeyPair()
+	assert.NoError(t, err)
+	s := EncodeID(pub.Bytes())
+
+	for i := 0; i < len(s); i++ {
+		// substitute a deterministic different alphabet character
+		sub := alphabet[(strings.IndexByte(alphabet, s[i])+1)%len(alphabet)]
+		mutated := s[:i] + string(sub) + s[i+1:]
+
+		got, err := DecodeID(mutated)
+		if err == nil {
+			// a typo may still decode iff it yields the exact same bytes
+			// (it can't - base58 encodings are canonical) - never a
+			// different id
+			assert.Equal(t, pub.Bytes(), got, "typo at position %d went undetected", i)
+			continue
+		}
+		assert.Error(t, err, "typo at position %d went undetected", i)
+	}
+}
@@ -0,0 +1,109 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasherMatchesSha256(t *testing.T) {
+	inputs := [][]byte{
+		nil,
+		[]byte("abc"),
+		bytes.Repeat([]byte{0x5a}, 1000),
+	}
+	for _, input := range inputs {
+		h := NewHasher()
+		_, err := h.Write(input)
+		assert.NoError(t, err)
+		assert.Equal(t, Sha256(input), h.Sum(nil), "incremental digest diverged for %d bytes", len(input))
+	}
+
+	// chunked writes equal one multi-slice one-shot call
+	h := NewHasher()
+	h.Write([]byte("hello "))
+	h.Write([]byte("world"))
+	assert.Equal(t, Sha256([]byte("hello "), []byte("world")), h.Sum(nil))
+}
+
+func TestHasherRandomSplits(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	for i := 0; i < 100; i++ {
+		data := make([]byte, 1+rng.Intn(4096))
+		rng.Read(data)
+
+		h := NewHasher()
+		for rest := data; len(rest) > 0; {
+			n := 1 + rng.Intn(len(rest))
+			h.Write(rest[:n])
+			rest = rest[n:]
+		}
+		assert.Equal(t, Sha256(data), h.Sum(nil), "digest diverged on iteration %d", i)
+	}
+}
+
+func TestHasherSumKeepsState(t *testing.T) {
+	h := NewHasher()
+	h.Write([]byte("partial"))
+	first := h.Sum(nil)
+	assert.Equal(t, first, h.Sum(nil), "Sum must not disturb the running state")
+	h.Write([]byte(" input"))
+	assert.Equal(t, Sha256([]byte("partial input")), h.Sum(nil))
+}
+
+func TestHashReader(t *testing.T) {
+	data := bytes.Repeat([]byte{0xab}, 100000)
+	digest, n, err := HashReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, Sha256(data), digest)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestHashReaderError(t *testing.T) {
+	_, _, err := HashReader(failingReader{})
+	assert.Error(t, err)
+}
+
+func TestKeyedHasher(t *testing.T) {
+	data := []byte("authenticated data")
+
+	h1 := NewKeyedHasher([]byte("key one"))
+	h1.Write(data)
+	h2 := NewKeyedHasher([]byte("key one"))
+	h2.Write(data)
+	assert.Equal(t, h1.Sum(nil), h2.Sum(nil), "same key and data must agree")
+
+	h3 := NewKeyedHasher([]byte("key two"))
+	h3.Write(data)
+	assert.NotEqual(t, h1.Sum(nil), h3.Sum(nil), "a different key must change the digest")
+	assert.NotEqual(t, Sha256(data), h1.Sum(nil), "the keyed digest must differ from the plain hash")
+}
+
+var benchSink []byte
+
+func BenchmarkSha256Small(b *testing.B) {
+	data := make([]byte, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchSink = Sha256(data)
+	}
+}
+
+func BenchmarkHasherSmall(b *testing.B) {
+	data := make([]byte, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h := NewHasher()
+		h.Write(data)
+		benchSink = h.Sum(nil)
+	}
+}
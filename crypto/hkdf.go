@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// DerivedKeySize is the size in bytes of every key DeriveKeys hands out.
+const DerivedKeySize = 32
+
+// DeriveKeys derives one independent DerivedKeySize-byte key per label from
+// a single shared secret, using HKDF-SHA-256 (RFC 5869) with the label as
+// the expand info. Every output depends on the secret, the salt and its own
+// label - changing any of them changes all the derived keys, so two callers
+// with distinct labels can never end up sharing key material.
+func DeriveKeys(secret []byte, salt []byte, labels ...string) (map[string][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("can't derive keys from an empty secret")
+	}
+	if len(labels) == 0 {
+		return nil, errors.New("no labels to derive keys for")
+	}
+
+	prk := hkdfExtract(salt, secret)
+	out := make(map[string][]byte, len(labels))
+	for _, label := range labels {
+		if label == "" {
+			return nil, errors.New("can't derive a key for an empty label")
+		}
+		if _, ok := out[label]; ok {
+			return nil, fmt.Errorf("duplicate key label %q", label)
+		}
+		out[label] = hkdfExpand(prk, []byte(label), DerivedKeySize)
+	}
+	return out, nil
+}
+
+// hkdfExtract is the HKDF-Extract step - it concentrates the secret's
+// entropy into a pseudorandom key. An empty salt is replaced by a zero
+// block, as the RFC prescribes.
+func hkdfExtract(salt, secret []byte) []byte {
+	if len(salt) == 0 {
+		salt = make([]byte, sha256.Size)
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// hkdfExpand is the HKDF-Expand step - it stretches the pseudorandom key
+// into size output bytes bound to info.
+func hkdfExpand(prk, info []byte, size int) []byte {
+	out := make([]byte, 0, size)
+	var block []byte
+	for counter := byte(1); len(out) < size; counter++ {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write(block)
+		mac.Write(info)
+		mac.Write([]byte{counter})
+		block = mac.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:size]
+}
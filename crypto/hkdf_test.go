@@ -0,0 +1,140 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fromHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	assert.NoError(t, err)
+	return b
+}
+
+// Test vectors from RFC 5869 appendix A, exercised through the extract and
+// expand steps directly so the full-length reference output can be compared.
+func TestHKDFRFC5869Vectors(t *testing.T) {
+	cases := []struct {
+		name string
+		ikm  string
+		salt string
+		info string
+		size int
+		okm  string
+	}{
+		{
+			name: "A.1 basic",
+			ikm:  "0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b",
+			salt: "000102030405060708090a0b0c",
+			info: "f0f1f2f3f4f5f6f7f8f9",
+			size: 42,
+			okm: "3cb25f25faacd57a90434f64d0362f2a" +
+				"2d2d0a90cf1a5a4c5db02d56ecc4c5bf" +
+				"34007208d5b887185865",
+		},
+		{
+			name: "A.2 longer inputs",
+			ikm: "000102030405060708090a0b0c0d0e0f" +
+				"101112131415161718191a1b1c1d1e1f" +
+				"202122232425262728292a2b2c2d2e2f" +
+				"303132333435363738393a3b3c3d3e3f" +
+				"404142434445464748494a4b4c4d4e4f",
+			salt: "606162636465666768696a6b6c6d6e6f" +
+				"707172737475767778797a7b7c7d7e7f" +
+				"808182838485868788898a8b8c8d8e8f" +
+				"909192939495969798999a9b9c9d9e9f" +
+				"a0a1a2a3a4a5a6a7a8a9aaabacadaeaf",
+			info: "b0b1b2b3b4b5b6b7b8b9babbbcbdbebf" +
+				"c0c1c2c3c4c5c6c7c8c9cacbcccdcecf" +
+				"d0d1d2d3d4d5d6d7d8d9dadbdcdddedf" +
+				"e0e1e2e3e4e5e6e7e8e9eaebecedeeef" +
+				"f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff",
+			size: 82,
+			okm: "b11e398dc80327a1c8e7f78c596a4934" +
+				"4f012eda2d4efad8a050cc4c19afa97c" +
+				"59045a99cac7827271cb41c65e590e09" +
+				"da3275600c2f09b8367793a9aca3db71" +
+				"cc30c58179ec3e87c14c01d5c1f3434f" +
+				"1d87",
+		},
+		{
+			name: "A.3 zero-length salt and info",
+			ikm:  "0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b",
+			salt: "",
+			info: "",
+			size: 42,
+			okm: "8da4e775a563c18f715f802a063c5a31" +
+				"b8a11f5c5ee1879ec3454e5f3c738d2d" +
+				"9d201395faa4b61a96c8",
+		},
+	}
+
+	for _, c := range cases {
+		prk := hkdfExtract(fromHex(t, c.salt), fromHex(t, c.ikm))
+		okm := hkdfExpand(prk, fromHex(t, c.info), c.size)
+		assert.Equal(t, fromHex(t, c.okm), okm, "wrong okm for vector %v", c.name)
+	}
+}
+
+func TestDeriveKeys(t *testing.T) {
+	secret := []byte("shared ecdh secret")
+	salt := []byte("session iv")
+
+	keys, err := DeriveKeys(secret, salt, "enc", "mac", "rekey")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(keys))
+	for label, key := range keys {
+		assert.Equal(t, DerivedKeySize, len(key), "wrong key size for label %v", label)
+	}
+
+	// same inputs derive the same keys
+	again, err := DeriveKeys(secret, salt, "enc", "mac", "rekey")
+	assert.NoError(t, err)
+	assert.Equal(t, keys, again)
+
+	// every label yields a distinct key
+	assert.False(t, bytes.Equal(keys["enc"], keys["mac"]))
+	assert.False(t, bytes.Equal(keys["enc"], keys["rekey"]))
+	assert.False(t, bytes.Equal(keys["mac"], keys["rekey"]))
+}
+
+func TestDeriveKeysSaltChangesAllOutputs(t *testing.T) {
+	secret := []byte("shared ecdh secret")
+
+	keys, err := DeriveKeys(secret, []byte("salt one"), "enc", "mac")
+	assert.NoError(t, err)
+	other, err := DeriveKeys(secret, []byte("salt two"), "enc", "mac")
+	assert.NoError(t, err)
+
+	for label := range keys {
+		assert.False(t, bytes.Equal(keys[label], other[label]), "changing the salt must change the key for label %v", label)
+	}
+}
+
+func TestDeriveKeysLabelIsBinding(t *testing.T) {
+	secret := []byte("shared ecdh secret")
+	salt := []byte("session iv")
+
+	keys, err := DeriveKeys(secret, salt, "enc")
+	assert.NoError(t, err)
+	other, err := DeriveKeys(secret, salt, "enc2")
+	assert.NoError(t, err)
+	assert.False(t, bytes.Equal(keys["enc"], other["enc2"]), "a different label must yield a different key")
+}
+
+func TestDeriveKeysErrors(t *testing.T) {
+	_, err := DeriveKeys(nil, []byte("salt"), "enc")
+	assert.Error(t, err, "empty secret must be refused")
+
+	_, err = DeriveKeys([]byte("secret"), []byte("salt"))
+	assert.Error(t, err, "deriving no keys is a caller bug")
+
+	_, err = DeriveKeys([]byte("secret"), []byte("salt"), "enc", "")
+	assert.Error(t, err, "empty label must be refused")
+
+	_, err = DeriveKeys([]byte("secret"), []byte("salt"), "enc", "enc")
+	assert.Error(t, err, "duplicate labels must be refused")
+}
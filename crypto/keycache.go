@@ -0,0 +1,95 @@
+package crypto
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// pubKeyCacheSize bounds how many parsed keys are kept. Eviction is least
+// recently used, so the keys of active peers stay hot.
+const pubKeyCacheSize = 4096
+
+type pubKeyCacheEntry struct {
+	key string // the raw key bytes
+	pub PublicKey
+}
+
+// pubKeyCache memoizes public key parsing - decompressing a key point is far
+// more expensive than a map lookup, and hot paths parse the same few peer
+// keys over and over. Safe for concurrent use.
+type pubKeyCache struct {
+	mu      sync.Mutex
+	items   map[string]*list.Element
+	order   *list.List // front is the most recently used
+	maxSize int
+
+	hits   uint64
+	misses uint64
+}
+
+func newPubKeyCache(maxSize int) *pubKeyCache {
+	return &pubKeyCache{
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+		maxSize: maxSize,
+	}
+}
+
+func (c *pubKeyCache) get(data []byte) (PublicKey, error) {
+	key := string(data)
+
+	c.mu.Lock()
+	if elem, ok := c.items[key]; ok {
+		c.order.MoveToFront(elem)
+		pub := elem.Value.(*pubKeyCacheEntry).pub
+		c.mu.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		return pub, nil
+	}
+	c.mu.Unlock()
+
+	// parse outside the lock - a racing parse of the same key just does the
+	// work twice and the second insert finds the entry already there
+	pub, err := NewPublicKey(data)
+	if err != nil {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if elem, ok := c.items[key]; ok {
+		c.order.MoveToFront(elem)
+		pub = elem.Value.(*pubKeyCacheEntry).pub
+	} else {
+		c.items[key] = c.order.PushFront(&pubKeyCacheEntry{key: key, pub: pub})
+		if c.order.Len() > c.maxSize {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*pubKeyCacheEntry).key)
+		}
+	}
+	c.mu.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+	return pub, nil
+}
+
+func (c *pubKeyCache) stats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
+
+var keyCache = newPubKeyCache(pubKeyCacheSize)
+
+// NewPublicKeyCached parses binary public key data like NewPublicKey, serving
+// repeated parses of the same key from a bounded process-wide cache. The
+// returned key is shared between callers - it is immutable, its accessors
+// hand out fresh copies of any mutable data.
+func NewPublicKeyCached(data []byte) (PublicKey, error) {
+	return keyCache.get(data)
+}
+
+// PublicKeyCacheStats reports how the key cache is doing - a low hit rate on
+// a busy node means the cache is undersized for the peer count.
+func PublicKeyCacheStats() (hits, misses uint64) {
+	return keyCache.stats()
+}
@@ -0,0 +1,121 @@
+package crypto
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPubKeyCacheHitMiss(t *testing.T) {
+	c := newPubKeyCache(16)
+	_, pub, err := GenerateKeyPair()
+	assert.NoError(t, err)
+
+	got, err := c.get(pub.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, pub.Bytes(), got.Bytes())
+	hits, misses := c.stats()
+	assert.Equal(t, uint64(0), hits)
+	assert.Equal(t, uint64(1), misses)
+
+	again, err := c.get(pub.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, pub.Bytes(), again.Bytes())
+	hits, misses = c.stats()
+	assert.Equal(t, uint64(1), hits)
+	assert.Equal(t, uint64(1), misses)
+}
+
+func TestPubKeyCacheEviction(t *testing.T) {
+	c := newPubKeyCache(2)
+
+	keys := make([][]byte, 3)
+	for i := range keys {
+		_, pub, err := GenerateKeyPair()
+		assert.NoError(t, err)
+		keys[i] = pub.Bytes()
+		_, err = c.get(keys[i])
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, len(c.items), "cache must stay within its bound")
+
+	// the least recently used key was evicted - fetching it is a miss again
+	_, before := c.stats()
+	_, err := c.get(keys[0])
+	assert.NoError(t, err)
+	_, after := c.stats()
+	assert.Equal(t, before+1, after, "the evicted key should miss")
+}
+
+func TestPubKeyCacheInvalidKeyNotCached(t *testing.T) {
+	c := newPubKeyCache(16)
+	_, err := c.get([]byte("not a curve point"))
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(c.items), "parse failures must not be cached")
+}
+
+func TestPubKeyCacheConcurrent(t *testing.T) {
+	c := newPubKeyCache(8)
+
+	keys := make([][]byte, 10)
+	for i := range keys {
+		_, pub, err := GenerateKeyPair()
+		assert.NoError(t, err)
+		keys[i] = pub.Bytes()
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := keys[(g+i)%len(keys)]
+				pub, err := c.get(key)
+				assert.NoError(t, err)
+				assert.Equal(t, key, pub.Bytes())
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func benchKeys(b *testing.B, n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		_, pub, err := GenerateKeyPair()
+		if err != nil {
+			b.Fatal(err)
+		}
+		keys[i] = pub.Bytes()
+	}
+	return keys
+}
+
+var benchKeySink PublicKey
+
+func BenchmarkParsePublicKey(b *testing.B) {
+	keys := benchKeys(b, 1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pub, err := NewPublicKey(keys[i%len(keys)])
+		if err != nil {
+			b.Fatal(err)
+		}
+		benchKeySink = pub
+	}
+}
+
+func BenchmarkParsePublicKeyCached(b *testing.B) {
+	keys := benchKeys(b, 1000)
+	c := newPubKeyCache(pubKeyCacheSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pub, err := c.get(keys[i%len(keys)])
+		if err != nil {
+			b.Fatal(err)
+		}
+		benchKeySink = pub
+	}
+}
@@ -4,10 +4,25 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+
 	"github.com/spacemeshos/go-spacemesh/log"
 )
 
-// GetRandomBytesToBuffer puts n random bytes using go crypto.rand into provided buff slice.
+// entropySource is the process-wide source all randomness is drawn from.
+var entropySource io.Reader = rand.Reader
+
+// SetEntropySource replaces the source randomness is drawn from, defaulting
+// to crypto/rand. It is meant to be called once at process init - e.g. on a
+// platform with a dedicated hardware source - and from tests simulating
+// entropy failure. Swapping it while other goroutines draw randomness is not
+// synchronized.
+func SetEntropySource(r io.Reader) {
+	entropySource = r
+}
+
+// GetRandomBytesToBuffer puts n random bytes using the entropy source into provided buff slice.
 // buff: a slice allocated by called to hold n bytes.
 func GetRandomBytesToBuffer(n int, buff []byte) error {
 
@@ -19,7 +34,7 @@ func GetRandomBytesToBuffer(n int, buff []byte) error {
 		return errors.New("invalid input param - buff must be allocated to hold n items")
 	}
 
-	_, err := rand.Read(buff)
+	_, err := io.ReadFull(entropySource, buff[:n])
 
 	if err != nil {
 		return err
@@ -28,7 +43,7 @@ func GetRandomBytesToBuffer(n int, buff []byte) error {
 	return nil
 }
 
-// GetRandomBytes returns n random bytes. It returns an error if the system's pgn fails.
+// GetRandomBytes returns n random bytes. It returns an error if the entropy source fails.
 func GetRandomBytes(n int) ([]byte, error) {
 
 	if n == 0 {
@@ -36,7 +51,7 @@ func GetRandomBytes(n int) ([]byte, error) {
 	}
 
 	b := make([]byte, n)
-	_, err := rand.Read(b)
+	_, err := io.ReadFull(entropySource, b)
 
 	if err != nil {
 		return nil, err
@@ -50,15 +65,17 @@ func GetRandomUserPort() uint32 {
 	return GetRandomUInt32(48127) + 1024
 }
 
-// GetRandomUInt32 returns a uint32 in the range [0 - max).
+// GetRandomUInt32 returns a uint32 in the range [0 - max). An entropy failure
+// is not recoverable here - rather than return a predictable value it panics
+// with a clear message.
 func GetRandomUInt32(max uint32) uint32 {
 
 	b := make([]byte, 4)
-	_, err := rand.Read(b)
+	_, err := io.ReadFull(entropySource, b)
 
 	if err != nil {
 		log.Error("Failed to get entropy from system", err)
-		panic(err)
+		panic(fmt.Sprintf("crypto: the entropy source failed: %v", err))
 	}
 
 	data := binary.BigEndian.Uint32(b)
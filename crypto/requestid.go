@@ -0,0 +1,32 @@
+package crypto
+
+// RequestID is a random identifier correlating a protocol request with its
+// response. It is a plain 16-byte value - it carries no uuid version or
+// variant bits and is never formatted as text, it only ever lives as a map
+// key and a wire field.
+type RequestID [16]byte
+
+// NewRequestID draws a fresh random request id straight from the entropy
+// source. It fails instead of returning a zero value when the source does -
+// a guessable request id would let an off-path attacker answer requests it
+// never saw.
+func NewRequestID() (RequestID, error) {
+	var r RequestID
+	if err := GetRandomBytesToBuffer(len(r), r[:]); err != nil {
+		return RequestID{}, err
+	}
+	return r, nil
+}
+
+// RequestIDFromBytes rebuilds a request id from its wire form. A response
+// carrying an id of the wrong length simply never matches a pending request.
+func RequestIDFromBytes(b []byte) RequestID {
+	var r RequestID
+	copy(r[:], b)
+	return r
+}
+
+// Bytes returns the id in its wire form.
+func (r RequestID) Bytes() []byte {
+	return r[:]
+}
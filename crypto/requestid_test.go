@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRequestIDUnique(t *testing.T) {
+	seen := make(map[RequestID]bool)
+	for i := 0; i < 100; i++ {
+		r, err := NewRequestID()
+		assert.NoError(t, err)
+		assert.NotEqual(t, RequestID{}, r, "a request id must never be zero")
+		assert.False(t, seen[r], "request ids must not repeat")
+		seen[r] = true
+	}
+}
+
+func TestRequestIDWireRoundTrip(t *testing.T) {
+	r, err := NewRequestID()
+	assert.NoError(t, err)
+	assert.Equal(t, r, RequestIDFromBytes(r.Bytes()))
+
+	// a truncated wire id yields a distinct value rather than a match
+	assert.NotEqual(t, r, RequestIDFromBytes(r.Bytes()[:8]))
+}
+
+func TestNewRequestIDEntropyFailure(t *testing.T) {
+	SetEntropySource(failingReader{})
+	defer SetEntropySource(rand.Reader)
+
+	_, err := NewRequestID()
+	assert.Error(t, err, "NewRequestID must surface an entropy failure")
+}
+
+func BenchmarkNewRequestID(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewRequestID(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUUIDString(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = UUIDString()
+	}
+}
@@ -0,0 +1,20 @@
+package crypto
+
+import "crypto/subtle"
+
+// ConstantTimeEqual compares two byte slices in time that depends only on
+// their lengths, never on their contents. Use it for any comparison where one
+// side derives from secret material - bytes.Equal returns on the first
+// differing byte and leaks how much of a guess was right through its timing.
+func ConstantTimeEqual(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
+
+// Zeroize overwrites b with zeros so key material doesn't linger in memory
+// after its use. It is best effort - copies the runtime or the caller made
+// elsewhere are out of reach - but the long-lived buffer itself is cleared.
+func Zeroize(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,44 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConstantTimeEqual(t *testing.T) {
+	assert.True(t, ConstantTimeEqual([]byte("same secret"), []byte("same secret")))
+	assert.True(t, ConstantTimeEqual(nil, nil))
+	assert.True(t, ConstantTimeEqual([]byte{}, nil))
+
+	assert.False(t, ConstantTimeEqual([]byte("same secret"), []byte("same secreT")))
+	assert.False(t, ConstantTimeEqual([]byte("short"), []byte("longer input")))
+	assert.False(t, ConstantTimeEqual([]byte("x"), nil))
+}
+
+// ConstantTimeEqual must agree with bytes.Equal on every input - the two
+// differ only in timing, never in outcome.
+func TestConstantTimeEqualMatchesBytesEqual(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		a, err := GetRandomBytes(1 + i%32)
+		assert.NoError(t, err)
+		b := append([]byte(nil), a...)
+		if i%3 == 0 {
+			b[i%len(b)] ^= 1
+		}
+		if i%5 == 0 {
+			b = b[:len(b)-1]
+		}
+		assert.Equal(t, bytes.Equal(a, b), ConstantTimeEqual(a, b))
+	}
+}
+
+func TestZeroize(t *testing.T) {
+	key, err := GetRandomBytes(32)
+	assert.NoError(t, err)
+	Zeroize(key)
+	assert.Equal(t, make([]byte, 32), key)
+
+	Zeroize(nil) // must not panic
+}
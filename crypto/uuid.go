@@ -1,16 +1,35 @@
 package crypto
 
-import "github.com/google/uuid"
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
 
 // UUID is a 16-len byte array represnting a UUID
 type UUID [16]byte
 
-// UUIDString returns a new random type-4 UUID string.
+// UUIDString returns a new random type-4 UUID string. An entropy failure is
+// not recoverable here - rather than hand out a zero or repeated id it panics
+// with a clear message.
 func UUIDString() string {
-	return uuid.New().String()
+	u, err := NewUUID()
+	if err != nil {
+		panic(fmt.Sprintf("crypto: the entropy source failed generating a uuid: %v", err))
+	}
+	return uuid.UUID(u).String()
 }
 
-// NewUUID returns a new random type-4 UUID raw bytes.
-func NewUUID() [16]byte {
-	return uuid.New()
+// NewUUID returns a new random type-4 UUID raw bytes, drawn from the process
+// entropy source. It fails instead of returning a zero value when the source
+// does - callers using the id for request correlation must propagate the
+// error rather than proceed with a guessable id.
+func NewUUID() (UUID, error) {
+	var u UUID
+	if err := GetRandomBytesToBuffer(len(u), u[:]); err != nil {
+		return UUID{}, err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40 // version 4
+	u[8] = (u[8] & 0x3f) | 0x80 // variant is 10
+	return u, nil
 }
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"crypto/rand"
 	"testing"
 
 	"github.com/google/uuid"
@@ -14,7 +15,41 @@ func TestUUID(t *testing.T) {
 	id1Str := id1.String()
 	assert.Equal(t, id, id1Str, "expected same uuid")
 
-	id2 := NewUUID()
+	id2, err := NewUUID()
+	assert.NoError(t, err, "unexpected error")
 	assert.Equal(t, len(id2), 16, "expected 16")
+}
+
+func TestNewUUIDNeverZero(t *testing.T) {
+	seen := make(map[UUID]struct{})
+	for i := 0; i < 100; i++ {
+		u, err := NewUUID()
+		assert.NoError(t, err)
+		assert.NotEqual(t, UUID{}, u, "a zero uuid must never escape")
+		assert.Equal(t, byte(0x40), u[6]&0xf0, "version must be 4")
+		assert.Equal(t, byte(0x80), u[8]&0xc0, "variant must be 10")
+		if _, ok := seen[u]; ok {
+			t.Fatal("uuid repeated")
+		}
+		seen[u] = struct{}{}
+	}
+}
+
+// with a dead entropy source nothing zero-valued or guessable may escape -
+// the error paths fail and the panicking paths panic loudly
+func TestEntropyFailure(t *testing.T) {
+	SetEntropySource(failingReader{})
+	defer SetEntropySource(rand.Reader)
+
+	_, err := NewUUID()
+	assert.Error(t, err, "NewUUID must surface an entropy failure")
+
+	_, err = GetRandomBytes(16)
+	assert.Error(t, err, "GetRandomBytes must surface an entropy failure")
+
+	err = GetRandomBytesToBuffer(16, make([]byte, 16))
+	assert.Error(t, err, "GetRandomBytesToBuffer must surface an entropy failure")
 
+	assert.Panics(t, func() { UUIDString() }, "UUIDString has no error path - it must panic")
+	assert.Panics(t, func() { GetRandomUInt32(10) }, "GetRandomUInt32 has no error path - it must panic")
 }
@@ -0,0 +1,62 @@
+package crypto
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// VerifyBatch verifies a batch of signatures in one call - pubs[i] must have
+// signed msgs[i] into sigs[i]. The scheme has no aggregated batch equation,
+// so each signature is checked independently - the win over a serial loop
+// comes from fanning the batch out across all cores and from the shared
+// parsed-key cache - and the invalid indices fall out of the same pass, in
+// ascending order. A key that fails to parse counts as invalid, not as an
+// error; err reports only a malformed batch.
+func VerifyBatch(pubs [][]byte, msgs [][]byte, sigs [][]byte) (allValid bool, invalid []int, err error) {
+	if len(pubs) != len(msgs) || len(msgs) != len(sigs) {
+		return false, nil, errors.New("mismatched batch lengths")
+	}
+	if len(pubs) == 0 {
+		return true, nil, nil
+	}
+
+	workers := runtime.NumCPU()
+	if workers > len(pubs) {
+		workers = len(pubs)
+	}
+
+	bad := make([]bool, len(pubs))
+	next := int64(-1)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(pubs) {
+					return
+				}
+				pub, err := NewPublicKeyCached(pubs[i])
+				if err != nil {
+					bad[i] = true
+					continue
+				}
+				ok, err := pub.Verify(msgs[i], sigs[i])
+				if err != nil || !ok {
+					bad[i] = true
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, b := range bad {
+		if b {
+			invalid = append(invalid, i)
+		}
+	}
+	return len(invalid) == 0, invalid, nil
+}
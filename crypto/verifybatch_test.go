@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signedBatch(t *testing.T, n int) (pubs, msgs, sigs [][]byte) {
+	for i := 0; i < n; i++ {
+		priv, pub, err := GenerateKeyPair()
+		assert.NoError(t, err)
+		msg := []byte{byte(i), byte(i >> 8), 'm', 's', 'g'}
+		sig, err := priv.Sign(msg)
+		assert.NoError(t, err)
+		pubs = append(pubs, pub.Bytes())
+		msgs = append(msgs, msg)
+		sigs = append(sigs, sig)
+	}
+	return pubs, msgs, sigs
+}
+
+func TestVerifyBatchAllValid(t *testing.T) {
+	pubs, msgs, sigs := signedBatch(t, 10)
+	allValid, invalid, err := VerifyBatch(pubs, msgs, sigs)
+	assert.NoError(t, err)
+	assert.True(t, allValid)
+	assert.Empty(t, invalid)
+}
+
+func TestVerifyBatchReportsExactInvalidIndices(t *testing.T) {
+	pubs, msgs, sigs := signedBatch(t, 10)
+
+	// corrupt a signature, a message and a key at known indices
+	sigs[2][4] ^= 1
+	msgs[5][0] ^= 1
+	pubs[7] = []byte("not a curve point")
+
+	allValid, invalid, err := VerifyBatch(pubs, msgs, sigs)
+	assert.NoError(t, err)
+	assert.False(t, allValid)
+	assert.Equal(t, []int{2, 5, 7}, invalid)
+}
+
+func TestVerifyBatchEdgeCases(t *testing.T) {
+	// an empty batch is trivially valid
+	allValid, invalid, err := VerifyBatch(nil, nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, allValid)
+	assert.Empty(t, invalid)
+
+	// mismatched lengths are a caller bug, not an invalid signature
+	pubs, msgs, sigs := signedBatch(t, 2)
+	_, _, err = VerifyBatch(pubs, msgs, sigs[:1])
+	assert.Error(t, err)
+	_, _, err = VerifyBatch(pubs[:1], msgs, sigs)
+	assert.Error(t, err)
+}
+
+func benchSignedBatch(b *testing.B, n int) (pubs, msgs, sigs [][]byte) {
+	for i := 0; i < n; i++ {
+		priv, pub, err := GenerateKeyPair()
+		if err != nil {
+			b.Fatal(err)
+		}
+		msg := []byte{byte(i), byte(i >> 8), 'm', 's', 'g'}
+		sig, err := priv.Sign(msg)
+		if err != nil {
+			b.Fatal(err)
+		}
+		pubs = append(pubs, pub.Bytes())
+		msgs = append(msgs, msg)
+		sigs = append(sigs, sig)
+	}
+	return pubs, msgs, sigs
+}
+
+func BenchmarkVerifyIndividual128(b *testing.B) {
+	pubs, msgs, sigs := benchSignedBatch(b, 128)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range pubs {
+			pub, err := NewPublicKeyCached(pubs[j])
+			if err != nil {
+				b.Fatal(err)
+			}
+			ok, err := pub.Verify(msgs[j], sigs[j])
+			if err != nil || !ok {
+				b.Fatal("signature did not verify")
+			}
+		}
+	}
+}
+
+func BenchmarkVerifyBatch128(b *testing.B) {
+	pubs, msgs, sigs := benchSignedBatch(b, 128)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		allValid, _, err := VerifyBatch(pubs, msgs, sigs)
+		if err != nil || !allValid {
+			b.Fatal("batch did not verify")
+		}
+	}
+}
@@ -141,13 +141,13 @@ func GetCanonicalPath(p string) string {
 }
 
 // GetFullDirectoryPath gets the OS specific full path for a named directory.
-// The directory is created if it doesn't exist.
+// The directory is created on the active filesystem if it doesn't exist.
 func GetFullDirectoryPath(name string) (string, error) {
 
 	aPath := GetCanonicalPath(name)
 
 	// create dir if it doesn't exist
-	err := os.MkdirAll(aPath, OwnerReadWriteExec)
+	err := Active.MkdirAll(aPath, OwnerReadWriteExec)
 
 	return aPath, err
 }
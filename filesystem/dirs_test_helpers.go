@@ -44,6 +44,30 @@ func SetupTestSpacemeshDataFolders(t *testing.T, n string) {
 
 }
 
+// UseMemoryFS points the active filesystem at a fresh in-memory one rooted in
+// a unique per-test data folder, and restores everything when the test ends.
+// Tests running on it never touch the real home directory, so they are safe
+// to run with t.Parallel.
+func UseMemoryFS(t *testing.T) *MemFS {
+	r, err := crypto.GetRandomBytes(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mem := NewMemFS()
+	prevActive := Active
+	prevPath := config.ConfigValues.DataFilePath
+	Active = mem
+	config.ConfigValues.DataFilePath = fmt.Sprintf("/spacemesh-test-%v", binary.BigEndian.Uint32(r))
+
+	t.Cleanup(func() {
+		Active = prevActive
+		config.ConfigValues.DataFilePath = prevPath
+	})
+
+	return mem
+}
+
 // DeleteSpacemeshDataFolders deletes all sub directories and files in the Spacemesh root data folder.
 func DeleteSpacemeshDataFolders(t *testing.T) {
 
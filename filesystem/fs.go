@@ -0,0 +1,116 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// FS abstracts the file operations node components perform, so tests can run
+// against an isolated in-memory filesystem instead of the developer's real
+// home directory. Both implementations honor the same semantics - see the
+// conformance suite in fs_conformance_test.go.
+type FS interface {
+	// MkdirAll creates a directory and any missing parents.
+	MkdirAll(path string, perm os.FileMode) error
+	// WriteFileAtomic writes data to path so a crash mid-write can never
+	// leave a partial or world-readable file behind - readers observe either
+	// the previous content or the full new content.
+	WriteFileAtomic(path string, data []byte, perm os.FileMode) error
+	// ReadFile returns the file's content. A missing file satisfies
+	// os.IsNotExist.
+	ReadFile(path string) ([]byte, error)
+	// Remove removes a file or an empty directory.
+	Remove(path string) error
+	// Exists reports whether the path exists and is accessible.
+	Exists(path string) bool
+	// ReadDirNames returns the names of the entries in a directory.
+	ReadDirNames(path string) ([]string, error)
+	// Lock takes an advisory lock on a directory. A second Lock on the same
+	// directory fails until the first is unlocked.
+	Lock(dir string) (Unlocker, error)
+	// TempDir creates a fresh temporary directory with the given name prefix.
+	TempDir(prefix string) (string, error)
+}
+
+// Unlocker releases a directory lock taken with FS.Lock.
+type Unlocker interface {
+	Unlock() error
+}
+
+// OS is the operating-system-backed filesystem.
+var OS FS = osFS{}
+
+// Active is the filesystem the package's path helpers and the node store
+// operate on. Production code leaves it as OS - tests swap in a MemFS, see
+// UseMemoryFS.
+var Active = OS
+
+// osFS implements FS on the real filesystem.
+type osFS struct{}
+
+func (osFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// WriteFileAtomic writes to a temp file in the target directory, syncs it and
+// renames it into place, the same scheme the node id file always used.
+func (osFS) WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+func (osFS) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+func (osFS) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (osFS) Exists(path string) bool {
+	return PathExists(path)
+}
+
+func (osFS) ReadDirNames(path string) ([]string, error) {
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+func (osFS) Lock(dir string) (Unlocker, error) {
+	return LockDirectory(dir)
+}
+
+func (osFS) TempDir(prefix string) (string, error) {
+	return ioutil.TempDir("", prefix)
+}
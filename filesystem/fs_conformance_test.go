@@ -0,0 +1,194 @@
+package filesystem
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fsFixture provides a named FS implementation and a fresh root directory to
+// run a conformance subtest in.
+type fsFixture struct {
+	name string
+	fs   FS
+	root func(t *testing.T) string
+}
+
+// fsFixtures returns the implementations the conformance suite runs against.
+// Both must honor the same atomic write and lock semantics - tests written
+// against one have to behave identically on the other.
+func fsFixtures(t *testing.T) []fsFixture {
+	mem := NewMemFS()
+	return []fsFixture{
+		{
+			name: "os",
+			fs:   OS,
+			root: func(t *testing.T) string {
+				dir, err := ioutil.TempDir("", "fs-conformance")
+				assert.NoError(t, err, "failed to create temp dir")
+				t.Cleanup(func() { os.RemoveAll(dir) })
+				return dir
+			},
+		},
+		{
+			name: "memory",
+			fs:   mem,
+			root: func(t *testing.T) string {
+				dir, err := mem.TempDir("fs-conformance")
+				assert.NoError(t, err, "failed to create temp dir")
+				return dir
+			},
+		},
+	}
+}
+
+func TestFSWriteFileAtomic(t *testing.T) {
+	for _, f := range fsFixtures(t) {
+		t.Run(f.name, func(t *testing.T) {
+			root := f.root(t)
+			path := filepath.Join(root, "data.json")
+
+			err := f.fs.WriteFileAtomic(path, []byte("first"), OwnerReadWrite)
+			assert.NoError(t, err, "failed to write file")
+
+			data, err := f.fs.ReadFile(path)
+			assert.NoError(t, err, "failed to read file back")
+			assert.Equal(t, "first", string(data), "expected written contents")
+
+			// an atomic write leaves no temp file behind
+			names, err := f.fs.ReadDirNames(root)
+			assert.NoError(t, err, "failed to list dir")
+			assert.Equal(t, []string{"data.json"}, names, "expected only the target file")
+
+			// overwriting replaces contents in full
+			err = f.fs.WriteFileAtomic(path, []byte("second"), OwnerReadWrite)
+			assert.NoError(t, err, "failed to overwrite file")
+			data, err = f.fs.ReadFile(path)
+			assert.NoError(t, err, "failed to read file back")
+			assert.Equal(t, "second", string(data), "expected overwritten contents")
+		})
+	}
+}
+
+func TestFSWriteMissingParent(t *testing.T) {
+	for _, f := range fsFixtures(t) {
+		t.Run(f.name, func(t *testing.T) {
+			root := f.root(t)
+			path := filepath.Join(root, "missing", "data.json")
+
+			err := f.fs.WriteFileAtomic(path, []byte("data"), OwnerReadWrite)
+			assert.Error(t, err, "expected a write to a missing directory to fail")
+			assert.False(t, f.fs.Exists(path), "no file should exist after a failed write")
+		})
+	}
+}
+
+func TestFSReadMissing(t *testing.T) {
+	for _, f := range fsFixtures(t) {
+		t.Run(f.name, func(t *testing.T) {
+			root := f.root(t)
+			path := filepath.Join(root, "missing.json")
+
+			assert.False(t, f.fs.Exists(path), "file should not exist")
+
+			_, err := f.fs.ReadFile(path)
+			assert.True(t, os.IsNotExist(err), "expected a not-exist error, got %v", err)
+		})
+	}
+}
+
+func TestFSRemove(t *testing.T) {
+	for _, f := range fsFixtures(t) {
+		t.Run(f.name, func(t *testing.T) {
+			root := f.root(t)
+			path := filepath.Join(root, "data.json")
+
+			err := f.fs.WriteFileAtomic(path, []byte("data"), OwnerReadWrite)
+			assert.NoError(t, err, "failed to write file")
+
+			err = f.fs.Remove(path)
+			assert.NoError(t, err, "failed to remove file")
+			assert.False(t, f.fs.Exists(path), "file should be gone")
+
+			_, err = f.fs.ReadFile(path)
+			assert.True(t, os.IsNotExist(err), "expected a not-exist error, got %v", err)
+		})
+	}
+}
+
+func TestFSMkdirAllAndReadDirNames(t *testing.T) {
+	for _, f := range fsFixtures(t) {
+		t.Run(f.name, func(t *testing.T) {
+			root := f.root(t)
+			nested := filepath.Join(root, "a", "b", "c")
+
+			err := f.fs.MkdirAll(nested, OwnerReadWriteExec)
+			assert.NoError(t, err, "failed to create nested dirs")
+			assert.True(t, f.fs.Exists(nested), "nested dir should exist")
+
+			// creating an existing dir is a no-op
+			err = f.fs.MkdirAll(nested, OwnerReadWriteExec)
+			assert.NoError(t, err, "recreating an existing dir should succeed")
+
+			err = f.fs.WriteFileAtomic(filepath.Join(nested, "z.json"), []byte("z"), OwnerReadWrite)
+			assert.NoError(t, err, "failed to write file")
+			err = f.fs.WriteFileAtomic(filepath.Join(nested, "a.json"), []byte("a"), OwnerReadWrite)
+			assert.NoError(t, err, "failed to write file")
+
+			// names come back sorted and cover immediate children only
+			names, err := f.fs.ReadDirNames(filepath.Join(root, "a", "b"))
+			assert.NoError(t, err, "failed to list dir")
+			assert.Equal(t, []string{"c"}, names, "expected the immediate child dir")
+
+			names, err = f.fs.ReadDirNames(nested)
+			assert.NoError(t, err, "failed to list dir")
+			assert.Equal(t, []string{"a.json", "z.json"}, names, "expected sorted file names")
+		})
+	}
+}
+
+func TestFSLock(t *testing.T) {
+	for _, f := range fsFixtures(t) {
+		t.Run(f.name, func(t *testing.T) {
+			root := f.root(t)
+
+			lock, err := f.fs.Lock(root)
+			assert.NoError(t, err, "failed to take the lock")
+
+			// the lock is exclusive while held
+			_, err = f.fs.Lock(root)
+			assert.Error(t, err, "expected a second lock on the same dir to fail")
+
+			err = lock.Unlock()
+			assert.NoError(t, err, "failed to release the lock")
+
+			// and free again once released
+			lock, err = f.fs.Lock(root)
+			assert.NoError(t, err, "failed to retake a released lock")
+			assert.NoError(t, lock.Unlock(), "failed to release the lock")
+		})
+	}
+}
+
+func TestFSTempDir(t *testing.T) {
+	for _, f := range fsFixtures(t) {
+		t.Run(f.name, func(t *testing.T) {
+			first, err := f.fs.TempDir("conformance")
+			assert.NoError(t, err, "failed to create temp dir")
+			second, err := f.fs.TempDir("conformance")
+			assert.NoError(t, err, "failed to create temp dir")
+
+			assert.NotEqual(t, first, second, "temp dirs must be unique")
+			assert.True(t, f.fs.Exists(first), "temp dir should exist")
+			assert.True(t, f.fs.Exists(second), "temp dir should exist")
+
+			if f.name == "os" {
+				os.RemoveAll(first)
+				os.RemoveAll(second)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package filesystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LockFileName is the name of the advisory lock file placed in a locked directory.
+const LockFileName = "LOCK"
+
+// DirLock is an advisory lock on a directory, preventing two processes from
+// using the same data directory - and thus the same node identity - at once.
+type DirLock struct {
+	path string
+	file *os.File
+}
+
+// LockDirectory acquires an advisory lock on the given directory and records
+// our PID in the lock file. If another live process holds the lock an error
+// naming that process is returned. A lock file left behind by a crashed
+// process is reclaimed - the OS releases the underlying lock when its holder
+// dies, so a leftover file alone doesn't block us.
+func LockDirectory(dir string) (*DirLock, error) {
+
+	path := filepath.Join(dir, LockFileName)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, OwnerReadWrite)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		pid := readLockPid(path)
+		f.Close()
+		if pid != "" {
+			return nil, fmt.Errorf("directory %v is locked by process %v", dir, pid)
+		}
+		return nil, fmt.Errorf("directory %v is locked by another process", dir)
+	}
+
+	// the lock is ours - stamp it with our PID so a competing process can
+	// report who holds it
+	if err := f.Truncate(0); err == nil {
+		_, err = f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+		if err == nil {
+			err = f.Sync()
+		}
+	}
+
+	return &DirLock{path: path, file: f}, nil
+}
+
+// Unlock releases the directory lock and removes the lock file.
+func (l *DirLock) Unlock() error {
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := unlockFile(l.file)
+
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	l.file = nil
+	os.Remove(l.path)
+
+	return err
+}
+
+// readLockPid returns the PID recorded in a lock file, or an empty string when
+// it can't be read.
+func readLockPid(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,57 @@
+package filesystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirlock_test")
+	assert.NoError(t, err, "failed to create temp dir")
+	defer os.RemoveAll(dir)
+
+	lock, err := LockDirectory(dir)
+	assert.NoError(t, err, "failed to acquire directory lock")
+	assert.True(t, PathExists(filepath.Join(dir, LockFileName)), "expected a lock file")
+
+	// a second acquisition fails while the lock is held and names our PID
+	_, err = LockDirectory(dir)
+	assert.Error(t, err, "expected the second lock to fail")
+	assert.Contains(t, err.Error(), fmt.Sprintf("process %d", os.Getpid()), "expected the holder pid in the error")
+
+	// releasing the lock removes the file and frees the directory
+	err = lock.Unlock()
+	assert.NoError(t, err, "failed to release directory lock")
+	assert.False(t, PathExists(filepath.Join(dir, LockFileName)), "expected the lock file to be removed")
+
+	relock, err := LockDirectory(dir)
+	assert.NoError(t, err, "failed to re-acquire a released lock")
+	assert.NoError(t, relock.Unlock(), "failed to release directory lock")
+}
+
+func TestLockDirectoryReclaimsStaleLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirlock_stale_test")
+	assert.NoError(t, err, "failed to create temp dir")
+	defer os.RemoveAll(dir)
+
+	// fabricate a lock file left behind by a crashed process - the file exists
+	// but nothing holds the underlying lock
+	path := filepath.Join(dir, LockFileName)
+	err = ioutil.WriteFile(path, []byte("99999999"), OwnerReadWrite)
+	assert.NoError(t, err, "failed to write stale lock file")
+
+	lock, err := LockDirectory(dir)
+	assert.NoError(t, err, "expected a stale lock to be reclaimed")
+
+	// the reclaimed lock is stamped with our PID
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err, "failed to read lock file")
+	assert.Equal(t, fmt.Sprintf("%d", os.Getpid()), string(data), "expected our pid in the lock file")
+
+	assert.NoError(t, lock.Unlock(), "failed to release directory lock")
+}
@@ -0,0 +1,189 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemFS is an in-memory FS for tests. Every instance is a fully isolated
+// root, so parallel tests can't see each other's files, and nothing ever
+// touches the real filesystem. Writes are atomic by construction - all state
+// changes happen under one mutex.
+type MemFS struct {
+	mu     sync.Mutex
+	files  map[string][]byte
+	perms  map[string]os.FileMode
+	dirs   map[string]bool
+	locks  map[string]bool
+	tmpSeq int
+}
+
+// NewMemFS creates an empty in-memory filesystem.
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string][]byte),
+		perms: make(map[string]os.FileMode),
+		dirs:  map[string]bool{"/": true},
+		locks: make(map[string]bool),
+	}
+}
+
+// notExist builds an error satisfying os.IsNotExist, so callers branch the
+// same way they do on the real filesystem.
+func notExist(op, path string) error {
+	return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+}
+
+// MkdirAll creates a directory and any missing parents.
+func (m *MemFS) MkdirAll(path string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mkdirAllLocked(path)
+	return nil
+}
+
+func (m *MemFS) mkdirAllLocked(path string) {
+	path = filepath.Clean(path)
+	for path != "/" && path != "." {
+		m.dirs[path] = true
+		path = filepath.Dir(path)
+	}
+}
+
+// WriteFileAtomic stores the file in one step. Like the OS implementation it
+// refuses to write into a directory that doesn't exist.
+func (m *MemFS) WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if dir := filepath.Dir(path); !m.dirs[dir] {
+		return notExist("open", path)
+	}
+	m.files[path] = append([]byte(nil), data...)
+	m.perms[path] = perm
+	return nil
+}
+
+// ReadFile returns a copy of the file's content.
+func (m *MemFS) ReadFile(path string) ([]byte, error) {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[path]
+	if !ok {
+		return nil, notExist("open", path)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Remove removes a file or an empty directory.
+func (m *MemFS) Remove(path string) error {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[path]; ok {
+		delete(m.files, path)
+		delete(m.perms, path)
+		return nil
+	}
+	if m.dirs[path] {
+		delete(m.dirs, path)
+		return nil
+	}
+	return notExist("remove", path)
+}
+
+// Exists reports whether a file or directory exists.
+func (m *MemFS) Exists(path string) bool {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[path]; ok {
+		return true
+	}
+	return m.dirs[path]
+}
+
+// ReadDirNames returns the sorted names of a directory's immediate entries.
+func (m *MemFS) ReadDirNames(path string) ([]string, error) {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirs[path] {
+		return nil, notExist("open", path)
+	}
+	seen := make(map[string]bool)
+	collect := func(p string) {
+		if p == path {
+			return
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return
+		}
+		seen[strings.SplitN(rel, string(filepath.Separator), 2)[0]] = true
+	}
+	for p := range m.files {
+		collect(p)
+	}
+	for p := range m.dirs {
+		collect(p)
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// memLock releases an in-memory directory lock.
+type memLock struct {
+	fs  *MemFS
+	dir string
+}
+
+// Unlock implements Unlocker.
+func (l *memLock) Unlock() error {
+	l.fs.mu.Lock()
+	delete(l.fs.locks, l.dir)
+	l.fs.mu.Unlock()
+	return nil
+}
+
+// Lock takes an advisory lock on a directory, mirroring LockDirectory's
+// semantics - a held lock makes a second Lock fail with an error naming the
+// directory.
+func (m *MemFS) Lock(dir string) (Unlocker, error) {
+	dir = filepath.Clean(dir)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.locks[dir] {
+		return nil, fmt.Errorf("directory %v is locked by another process", dir)
+	}
+	m.locks[dir] = true
+	m.mkdirAllLocked(dir)
+	return &memLock{fs: m, dir: dir}, nil
+}
+
+// TempDir creates a fresh uniquely named directory under /tmp.
+func (m *MemFS) TempDir(prefix string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tmpSeq++
+	dir := filepath.Join("/tmp", fmt.Sprintf("%s%d", prefix, m.tmpSeq))
+	m.mkdirAllLocked(dir)
+	return dir, nil
+}
+
+// Perm returns the mode a file was written with, for test assertions.
+func (m *MemFS) Perm(path string) os.FileMode {
+	path = filepath.Clean(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.perms[path]
+}
@@ -0,0 +1,15 @@
+package config
+
+// Config defines the configuration options of the hare protocol
+type Config struct {
+	// ExposeEligibility enables serving the local node's role proofs to
+	// remote peers. Off by default since it leaks participation info
+	ExposeEligibility bool `mapstructure:"expose-eligibility"`
+}
+
+// DefaultConfig returns the default values of the hare configuration
+func DefaultConfig() Config {
+	return Config{
+		ExposeEligibility: false,
+	}
+}
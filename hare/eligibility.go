@@ -0,0 +1,115 @@
+package hare
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/hare/config"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/p2p"
+)
+
+// EligibilityProtocol is the name of the eligibility query protocol
+const EligibilityProtocol = "hare/eligibility"
+
+// RoleRequestMessage is the message type of a role query
+const RoleRequestMessage = p2p.MessageType(1)
+
+// pubKeySize is the size of a compressed public key
+const pubKeySize = 33
+
+// ErrNotOwnIdentity is returned when a role proof is requested for an
+// identity other than the local node
+var ErrNotOwnIdentity = errors.New("role proofs are only served for the local identity")
+
+// SerializeRoleRequest returns the wire format of a role request
+func SerializeRoleRequest(rq RoleRequest) []byte {
+	return rq.bytes()
+}
+
+// DeserializeRoleRequest parses a role request from its wire format
+func DeserializeRoleRequest(buff []byte) (RoleRequest, error) {
+	var layer LayerId
+
+	if len(buff) != pubKeySize+len(layer)+4 {
+		return RoleRequest{}, errors.New("role request has wrong length")
+	}
+
+	pubKey, err := crypto.NewPublicKey(buff[:pubKeySize])
+	if err != nil {
+		return RoleRequest{}, err
+	}
+
+	copy(layer[:], buff[pubKeySize:])
+	k := binary.BigEndian.Uint32(buff[pubKeySize+len(layer):])
+
+	return NewRoleRequest(pubKey, layer, k), nil
+}
+
+// EligibilityService serves the local node's role proofs to remote peers.
+// Serving is enabled by the expose-eligibility config flag, queries of other
+// nodes are always available
+type EligibilityService struct {
+	oracle   Rolacle
+	pubKey   crypto.PublicKey
+	protocol *p2p.Protocol
+}
+
+// NewEligibilityService creates the service for the local identity pubKey.
+// The request handler is registered only when enabled in cfg
+func NewEligibilityService(network p2p.Service, oracle Rolacle, pubKey crypto.PublicKey, cfg config.Config) *EligibilityService {
+	service := new(EligibilityService)
+	service.oracle = oracle
+	service.pubKey = pubKey
+	service.protocol = p2p.NewProtocol(network, EligibilityProtocol)
+
+	if cfg.ExposeEligibility {
+		service.protocol.RegisterMsgHandler(RoleRequestMessage, service.handleRequest)
+	}
+
+	return service
+}
+
+// roleProof returns the local node's role for the request. Requests about
+// other identities are refused with ErrNotOwnIdentity
+func (service *EligibilityService) roleProof(rq RoleRequest) (Role, RoleProof, error) {
+	if !bytes.Equal(rq.pubKey.Bytes(), service.pubKey.Bytes()) {
+		return Passive, nil, ErrNotOwnIdentity
+	}
+
+	return service.oracle.Role(rq)
+}
+
+func (service *EligibilityService) handleRequest(msg []byte) []byte {
+	rq, err := DeserializeRoleRequest(msg)
+	if err != nil {
+		log.Error("Could not deserialize role request: ", err)
+		return nil
+	}
+
+	role, proof, err := service.roleProof(rq)
+	if err != nil {
+		log.Error("Refusing role request: ", err)
+		return nil
+	}
+
+	return append([]byte{byte(role)}, proof...)
+}
+
+// Query asks the peer at address for its claimed role matching the request
+func (service *EligibilityService) Query(address string, rq RoleRequest, timeout time.Duration) (Role, RoleProof, error) {
+	resp, err := service.protocol.SendRequest(RoleRequestMessage, SerializeRoleRequest(rq), address, timeout)
+	if err != nil {
+		return Passive, nil, err
+	}
+
+	buff := resp.([]byte)
+	if len(buff) < 1 {
+		return Passive, nil, errors.New("empty eligibility response")
+	}
+
+	return Role(buff[0]), RoleProof(buff[1:]), nil
+}
@@ -0,0 +1,67 @@
+package hare
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/hare/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+func enabledConfig() config.Config {
+	cfg := config.DefaultConfig()
+	cfg.ExposeEligibility = true
+	return cfg
+}
+
+func newEligibilityNode(t *testing.T, sim *simulator.Simulator, cfg config.Config) (*EligibilityService, crypto.PublicKey) {
+	priv, pub := generateSigner(t)
+	n := sim.NewNodeFrom(node.New(pub, "127.0.0.1:0"))
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 100)
+	oracle := newTestWeightedOracle(t, provider, priv, committeeSize)
+
+	return NewEligibilityService(n, oracle, pub, cfg), pub
+}
+
+func TestEligibilityService_Query(t *testing.T) {
+	sim := simulator.New()
+	_, responderKey := newEligibilityNode(t, sim, enabledConfig())
+	querier, _ := newEligibilityNode(t, sim, config.DefaultConfig())
+
+	rq := NewRoleRequest(responderKey, LayerId{1}, 2)
+	role, proof, err := querier.Query(responderKey.String(), rq, time.Second*10)
+	assert.Nil(t, err)
+	assert.NotEqual(t, Passive, role)
+
+	verified, err := responderKey.Verify(rq.bytes(), proof)
+	assert.Nil(t, err)
+	assert.True(t, verified)
+}
+
+func TestEligibilityService_RefusesOtherIdentity(t *testing.T) {
+	sim := simulator.New()
+	responder, _ := newEligibilityNode(t, sim, enabledConfig())
+
+	_, other := generateSigner(t)
+	_, _, err := responder.roleProof(NewRoleRequest(other, LayerId{1}, 2))
+	assert.Equal(t, ErrNotOwnIdentity, err)
+
+	assert.Nil(t, responder.handleRequest(SerializeRoleRequest(NewRoleRequest(other, LayerId{1}, 2))))
+}
+
+func TestDeserializeRoleRequest(t *testing.T) {
+	_, pub := generateSigner(t)
+	rq := NewRoleRequest(pub, LayerId{5}, 7)
+
+	parsed, err := DeserializeRoleRequest(SerializeRoleRequest(rq))
+	assert.Nil(t, err)
+	assert.Equal(t, rq.Id(), parsed.Id())
+
+	_, err = DeserializeRoleRequest([]byte{1, 2, 3})
+	assert.NotNil(t, err)
+}
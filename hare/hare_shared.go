@@ -1,6 +1,7 @@
 package hare
 
 import (
+	"encoding/binary"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
 	"hash/fnv"
 	"time"
@@ -40,4 +41,10 @@ func (layerId *LayerId) Id() uint32 {
 
 func (layerId *LayerId) Bytes() []byte {
 	return layerId[:]
+}
+
+// Ord returns the numeric interpretation of the layer id. Layers are numbered
+// sequentially so the id doubles as an ordinal
+func (layerId *LayerId) Ord() uint64 {
+	return binary.LittleEndian.Uint64(layerId[:8])
 }
\ No newline at end of file
@@ -0,0 +1,964 @@
+package hare
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+)
+
+// DefaultEvictionHorizon is the number of layers of oracle state retained
+// when no explicit horizon is configured
+const DefaultEvictionHorizon = 100
+
+// DefaultLookaheadLayers is the number of layers ahead of the current layer
+// the oracle answers for when no explicit lookahead is configured
+const DefaultLookaheadLayers = 10
+
+// ErrLayerTooOld is returned for requests about layers that fell out of the
+// eviction horizon
+var ErrLayerTooOld = errors.New("layer is older than the eviction horizon")
+
+// ErrLayerTooFarAhead is returned for requests about layers beyond the
+// lookahead window
+var ErrLayerTooFarAhead = errors.New("layer is too far ahead of the current layer")
+
+// ErrInvalidPubKey is returned for requests carrying a missing or wrongly
+// sized public key
+var ErrInvalidPubKey = errors.New("role request carries an invalid public key")
+
+// ErrRoundTooHigh is returned for requests about rounds beyond MaxRound
+var ErrRoundTooHigh = errors.New("round number is beyond the round horizon")
+
+// MaxRound is the highest round number the oracle answers for. Requests come
+// from remote peers, so rounds far beyond any plausible protocol progress are
+// rejected instead of computed
+const MaxRound = uint32(1 << 16)
+
+// maxProofSize bounds the size of a proof accepted for validation
+const maxProofSize = 128
+
+// checkRoleRequest screens a request arriving from the network before any
+// computation is done on it
+func checkRoleRequest(rq RoleRequest) error {
+	if rq.pubKey == nil || len(rq.pubKey.Bytes()) != pubKeySize {
+		return ErrInvalidPubKey
+	}
+
+	if rq.k > MaxRound {
+		return ErrRoundTooHigh
+	}
+
+	return nil
+}
+
+// checkRoleProof screens a proof arriving from the network
+func checkRoleProof(proof RoleProof) error {
+	if len(proof) > maxProofSize {
+		return errors.New("role proof is oversized")
+	}
+
+	return nil
+}
+
+// layerWindow tracks the current layer and rejects layers that fell out of
+// the retention horizon
+type layerWindow struct {
+	horizon      uint64
+	maxAhead     uint64
+	currentLayer uint64
+	hasCurrent   bool
+	mutex        sync.RWMutex
+}
+
+// advance moves the window to the given layer
+func (window *layerWindow) advance(layerId LayerId) {
+	window.mutex.Lock()
+	window.currentLayer = layerId.Ord()
+	window.hasCurrent = true
+	window.mutex.Unlock()
+}
+
+// retention returns the configured horizon
+func (window *layerWindow) retention() uint64 {
+	if window.horizon == 0 {
+		return DefaultEvictionHorizon
+	}
+	return window.horizon
+}
+
+// lookahead returns the configured lookahead
+func (window *layerWindow) lookahead() uint64 {
+	if window.maxAhead == 0 {
+		return DefaultLookaheadLayers
+	}
+	return window.maxAhead
+}
+
+// tooOld checks whether the given layer fell out of the horizon
+func (window *layerWindow) tooOld(layerId LayerId) bool {
+	window.mutex.RLock()
+	defer window.mutex.RUnlock()
+
+	if !window.hasCurrent {
+		return false
+	}
+
+	return layerId.Ord()+window.retention() <= window.currentLayer
+}
+
+// tooNew checks whether the given layer is beyond the lookahead window
+func (window *layerWindow) tooNew(layerId LayerId) bool {
+	window.mutex.RLock()
+	defer window.mutex.RUnlock()
+
+	if !window.hasCurrent {
+		return false
+	}
+
+	return layerId.Ord() > window.currentLayer+window.lookahead()
+}
+
+// Role is the role the oracle assigns to an identity for a given round
+type Role byte
+
+const (
+	// Passive identities just listen to the protocol
+	Passive = Role(0)
+	// Active identities are eligible to participate in the round
+	Active = Role(1)
+	// Leader identities are eligible to propose in the round
+	Leader = Role(2)
+)
+
+// PubKey is the public identity a role is requested for
+type PubKey crypto.PublicKey
+
+// RoleProof is the evidence attached to a role claim
+type RoleProof []byte
+
+// RoleRequest is a request for the role of pubKey in round k of layerId
+type RoleRequest struct {
+	pubKey  PubKey
+	layerId LayerId
+	k       uint32
+}
+
+// NewRoleRequest creates a role request for the given identity, layer and round
+func NewRoleRequest(pubKey PubKey, layerId LayerId, k uint32) RoleRequest {
+	return RoleRequest{pubKey, layerId, k}
+}
+
+// bytes returns the canonical binary representation of the request
+func (roleRequest *RoleRequest) bytes() []byte {
+	var w bytes.Buffer
+	w.Write(roleRequest.pubKey.Bytes())
+	w.Write(roleRequest.layerId.Bytes())
+	binary.Write(&w, binary.BigEndian, roleRequest.k)
+
+	return w.Bytes()
+}
+
+// Id returns a unique id for the request
+func (roleRequest *RoleRequest) Id() uint32 {
+	h := fnv.New32()
+	h.Write(roleRequest.bytes())
+	return h.Sum32()
+}
+
+// hash returns the eligibility hash of the request
+func (roleRequest *RoleRequest) hash() uint64 {
+	h := fnv.New64()
+	h.Write(roleRequest.bytes())
+	return h.Sum64()
+}
+
+// Rolacle is the roles oracle
+type Rolacle interface {
+	Role(rq RoleRequest) (Role, RoleProof, error)
+	Validate(rq RoleRequest, role Role, proof RoleProof) (bool, ValidationResult)
+	IsEligible(rq RoleRequest) bool
+	RankedLeaders(layer LayerId, k uint32, n int) ([]PubKey, error)
+	ValidateLeaderRank(rq RoleRequest, rank int) (bool, error)
+}
+
+// ParticipantProvider supplies the identities registered for a layer. It is
+// the base set leader ranking is computed over
+type ParticipantProvider interface {
+	Participants(layer LayerId) ([]PubKey, error)
+}
+
+// WeightProvider supplies the weight committed by an identity for a layer and
+// the total weight committed for that layer
+type WeightProvider interface {
+	Weight(pubKey PubKey, layer LayerId) (uint64, uint64, error)
+}
+
+// Beacon provides the shared per-layer randomness seed the eligibility hash
+// is based on
+type Beacon interface {
+	Value(layer LayerId) ([]byte, error)
+}
+
+// LayerClock notifies the oracle of layer boundaries. CurrentLayer returns
+// the layer currently in progress, Subscribe returns a channel receiving
+// every layer change
+type LayerClock interface {
+	CurrentLayer() LayerId
+	Subscribe() <-chan LayerId
+}
+
+// OracleConfig holds the configuration shared by the oracle implementations
+type OracleConfig struct {
+	CommitteeSize    uint32
+	CacheSize        int
+	Horizon          uint64
+	Lookahead        uint64
+	PrecomputeRounds uint32
+}
+
+// DefaultOracleConfig returns the default oracle configuration
+func DefaultOracleConfig() OracleConfig {
+	return OracleConfig{
+		CommitteeSize:    10,
+		CacheSize:        DefaultRoleCacheSize,
+		Horizon:          DefaultEvictionHorizon,
+		Lookahead:        DefaultLookaheadLayers,
+		PrecomputeRounds: 0,
+	}
+}
+
+// Option overrides a single value of the oracle configuration
+type Option func(cfg *OracleConfig)
+
+// WithCommitteeSize overrides the expected committee size
+func WithCommitteeSize(committeeSize uint32) Option {
+	return func(cfg *OracleConfig) {
+		cfg.CommitteeSize = committeeSize
+	}
+}
+
+// WithCacheSize overrides the result cache size
+func WithCacheSize(cacheSize int) Option {
+	return func(cfg *OracleConfig) {
+		cfg.CacheSize = cacheSize
+	}
+}
+
+// WithHorizon overrides the layer retention horizon
+func WithHorizon(horizon uint64) Option {
+	return func(cfg *OracleConfig) {
+		cfg.Horizon = horizon
+	}
+}
+
+// WithLookahead overrides the number of future layers the oracle answers for
+func WithLookahead(lookahead uint64) Option {
+	return func(cfg *OracleConfig) {
+		cfg.Lookahead = lookahead
+	}
+}
+
+// WithPrecomputeRounds enables self-eligibility precomputation of the first
+// rounds of every layer the clock announces
+func WithPrecomputeRounds(rounds uint32) Option {
+	return func(cfg *OracleConfig) {
+		cfg.PrecomputeRounds = rounds
+	}
+}
+
+// WeightedOracle assigns roles with probability proportional to the weight
+// share an identity committed for the layer
+type WeightedOracle struct {
+	weights          WeightProvider
+	beacon           Beacon
+	priv             crypto.PrivateKey
+	participants     ParticipantProvider
+	committeeSize    uint32
+	precomputeRounds uint32
+	cache            *roleCache
+	window           layerWindow
+	metrics          oracleMetrics
+	selfCancel       chan struct{}
+	selfMutex        sync.Mutex
+}
+
+var _ Rolacle = (*WeightedOracle)(nil)
+
+// NewWeightedOracle creates a weighted oracle deriving roles from the layer
+// seed of beacon and the weight share reported by weights. priv is used to
+// generate role proofs for the local identity. A non-nil clock drives the
+// layer window, eviction and self-precomputation; a nil clock leaves layer
+// tracking to explicit AdvanceLayer calls
+func NewWeightedOracle(cfg OracleConfig, weights WeightProvider, beacon Beacon, priv crypto.PrivateKey, clock LayerClock, opts ...Option) (*WeightedOracle, error) {
+	if weights == nil {
+		return nil, errors.New("weighted oracle requires a weight provider")
+	}
+
+	if beacon == nil {
+		return nil, errors.New("weighted oracle requires a beacon source")
+	}
+
+	if priv == nil {
+		return nil, errors.New("weighted oracle requires a signer")
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	w := new(WeightedOracle)
+	w.weights = weights
+	w.beacon = beacon
+	w.priv = priv
+	w.committeeSize = cfg.CommitteeSize
+	w.precomputeRounds = cfg.PrecomputeRounds
+	w.cache = newRoleCache(cfg.CacheSize)
+	w.window.horizon = cfg.Horizon
+	w.window.maxAhead = cfg.Lookahead
+
+	if clock != nil {
+		// subscribe before reading the current layer so no boundary in
+		// between is missed
+		updates := clock.Subscribe()
+		w.window.advance(clock.CurrentLayer())
+		go w.watchClock(updates)
+	}
+
+	return w, nil
+}
+
+// watchClock applies layer boundary notifications until the subscription is
+// closed
+func (oracle *WeightedOracle) watchClock(updates <-chan LayerId) {
+	for layer := range updates {
+		oracle.AdvanceLayer(layer)
+
+		if oracle.precomputeRounds > 0 {
+			oracle.PrecomputeSelf(layer, 0, oracle.precomputeRounds-1)
+		}
+	}
+}
+
+// eligibilityHash mixes the layer seed of the beacon into the request hash
+func (oracle *WeightedOracle) eligibilityHash(rq RoleRequest) (uint64, error) {
+	seed, err := oracle.beacon.Value(rq.layerId)
+	if err != nil {
+		return 0, err
+	}
+
+	h := fnv.New64()
+	h.Write(seed)
+	h.Write(rq.bytes())
+	return h.Sum64(), nil
+}
+
+// computeRole derives the role of the request from its eligibility hash and
+// the weight share of the identity
+func (oracle *WeightedOracle) computeRole(rq RoleRequest) (Role, error) {
+	weight, total, err := oracle.weights.Weight(rq.pubKey, rq.layerId)
+	if err != nil {
+		return Passive, err
+	}
+
+	if weight == 0 || total == 0 {
+		return Passive, nil
+	}
+
+	hash, err := oracle.eligibilityHash(rq)
+	if err != nil {
+		return Passive, err
+	}
+
+	// a node is active with probability committeeSize * weight / total
+	p := float64(oracle.committeeSize) * float64(weight) / float64(total)
+	if float64(hash) >= p*float64(math.MaxUint64) {
+		return Passive, nil
+	}
+
+	// dividing the hash by the weight favors heavier identities. the leader
+	// threshold is set so a single leader is expected per round
+	if hash/weight < math.MaxUint64/total {
+		return Leader, nil
+	}
+
+	return Active, nil
+}
+
+// roleResult is the cached outcome of a Role computation
+type roleResult struct {
+	role  Role
+	proof RoleProof
+}
+
+// roleKey returns the cache key of a Role computation
+func roleKey(rq RoleRequest) string {
+	return "role " + string(rq.bytes())
+}
+
+// validateKey returns the cache key of a Validate computation. The proof is
+// part of the key so a rejected proof is not confused with an accepted one
+func validateKey(rq RoleRequest, role Role, proof RoleProof) string {
+	h := fnv.New64()
+	h.Write(proof)
+	h.Write([]byte{byte(role)})
+	return "valid " + string(h.Sum(nil)) + string(rq.bytes())
+}
+
+// Role returns the role matching the request and a proof binding the identity
+// to the claim
+func (oracle *WeightedOracle) Role(rq RoleRequest) (Role, RoleProof, error) {
+	if err := checkRoleRequest(rq); err != nil {
+		return Passive, nil, err
+	}
+
+	if oracle.window.tooOld(rq.layerId) {
+		return Passive, nil, ErrLayerTooOld
+	}
+
+	if oracle.window.tooNew(rq.layerId) {
+		return Passive, nil, ErrLayerTooFarAhead
+	}
+
+	if value, exist := oracle.cache.get(roleKey(rq)); exist {
+		result := value.(*roleResult)
+		return result.role, result.proof, nil
+	}
+
+	role, err := oracle.computeRole(rq)
+	if err != nil {
+		return Passive, nil, err
+	}
+
+	// non-participants carry no proof and leave no state behind
+	if role == Passive {
+		oracle.metrics.countRole(role)
+		return Passive, nil, nil
+	}
+
+	proof, err := oracle.priv.Sign(rq.bytes())
+	if err != nil {
+		return Passive, nil, err
+	}
+
+	oracle.metrics.countRole(role)
+	oracle.cache.put(roleKey(rq), rq.layerId, &roleResult{role, proof})
+
+	return role, proof, nil
+}
+
+// IsEligible reports whether the identity of the request may participate in
+// its round
+func (oracle *WeightedOracle) IsEligible(rq RoleRequest) bool {
+	role, _, err := oracle.Role(rq)
+	return err == nil && role != Passive
+}
+
+// Validate checks that the claimed role matches the recomputed role and that
+// the proof was created by the identity of the request. The returned result
+// explains why a claim was rejected
+func (oracle *WeightedOracle) Validate(rq RoleRequest, role Role, proof RoleProof) (bool, ValidationResult) {
+	if checkRoleRequest(rq) != nil || checkRoleProof(proof) != nil {
+		oracle.metrics.countValidation(InvalidRequest)
+		return false, InvalidRequest
+	}
+
+	if oracle.window.tooOld(rq.layerId) {
+		oracle.metrics.countValidation(StaleLayer)
+		return false, StaleLayer
+	}
+
+	if oracle.window.tooNew(rq.layerId) {
+		oracle.metrics.countValidation(FutureLayer)
+		return false, FutureLayer
+	}
+
+	key := validateKey(rq, role, proof)
+	if value, exist := oracle.cache.get(key); exist {
+		result := value.(ValidationResult)
+		oracle.metrics.countValidation(result)
+		return result == Valid, result
+	}
+
+	result := oracle.doValidate(rq, role, proof)
+	oracle.metrics.countValidation(result)
+	oracle.cache.put(key, rq.layerId, result)
+
+	return result == Valid, result
+}
+
+func (oracle *WeightedOracle) doValidate(rq RoleRequest, role Role, proof RoleProof) ValidationResult {
+	// Passive claims carry no proof. they are accepted only when the
+	// recomputed threshold confirms the identity is indeed not eligible,
+	// so an eligible node cannot hide its role behind a Passive claim
+	if role == Passive {
+		if len(proof) != 0 {
+			return InvalidSignature
+		}
+
+		expected, err := oracle.computeRole(rq)
+		if err != nil {
+			return InternalError
+		}
+
+		if expected != Passive {
+			return WrongThreshold
+		}
+
+		return Valid
+	}
+
+	verified, err := rq.pubKey.Verify(rq.bytes(), proof)
+	if err != nil || !verified {
+		return InvalidSignature
+	}
+
+	expected, err := oracle.computeRole(rq)
+	if err != nil {
+		return InternalError
+	}
+
+	if expected != role {
+		return WrongThreshold
+	}
+
+	return Valid
+}
+
+// Metrics returns a snapshot of the counters of the current layer
+func (oracle *WeightedOracle) Metrics() OracleMetrics {
+	return oracle.metrics.snapshot()
+}
+
+// SelfRole returns the local node's role for round k of the given layer. It
+// is served from the cache when the round was precomputed and falls back to
+// synchronous computation otherwise
+func (oracle *WeightedOracle) SelfRole(layer LayerId, k uint32) (Role, RoleProof, error) {
+	return oracle.Role(NewRoleRequest(oracle.priv.GetPublicKey(), layer, k))
+}
+
+// PrecomputeSelf computes and caches the local node's role proofs for rounds
+// [fromK, toK] of the given layer in the background. Precomputation goes
+// through the regular Role path so the results are identical to on demand
+// computation. A newer call or a layer advancement cancels an in-flight
+// computation
+func (oracle *WeightedOracle) PrecomputeSelf(layer LayerId, fromK uint32, toK uint32) {
+	cancel := make(chan struct{})
+
+	oracle.selfMutex.Lock()
+	if oracle.selfCancel != nil {
+		close(oracle.selfCancel)
+	}
+	oracle.selfCancel = cancel
+	oracle.selfMutex.Unlock()
+
+	pubKey := oracle.priv.GetPublicKey()
+
+	go func() {
+		for k := fromK; k <= toK; k++ {
+			select {
+			case <-cancel:
+				return
+			default:
+			}
+
+			oracle.Role(NewRoleRequest(pubKey, layer, k))
+		}
+	}()
+}
+
+// cancelPrecompute stops an in-flight self precomputation
+func (oracle *WeightedOracle) cancelPrecompute() {
+	oracle.selfMutex.Lock()
+	if oracle.selfCancel != nil {
+		close(oracle.selfCancel)
+		oracle.selfCancel = nil
+	}
+	oracle.selfMutex.Unlock()
+}
+
+// SetParticipantProvider supplies the oracle with the participant set leader
+// ranking is computed over
+func (oracle *WeightedOracle) SetParticipantProvider(participants ParticipantProvider) {
+	oracle.participants = participants
+}
+
+// rankedLeaders sorts the participants of round k by their eligibility hash
+func (oracle *WeightedOracle) rankedLeaders(layer LayerId, k uint32) ([]PubKey, error) {
+	if oracle.participants == nil {
+		return nil, errors.New("weighted oracle has no participant provider")
+	}
+
+	participants, err := oracle.participants.Participants(layer)
+	if err != nil {
+		return nil, err
+	}
+
+	ranked := make([]PubKey, len(participants))
+	copy(ranked, participants)
+
+	hashes := make(map[string]uint64, len(ranked))
+	for _, pubKey := range ranked {
+		rq := NewRoleRequest(pubKey, layer, k)
+		hash, err := oracle.eligibilityHash(rq)
+		if err != nil {
+			return nil, err
+		}
+
+		hashes[pubKey.String()] = hash
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		hi, hj := hashes[ranked[i].String()], hashes[ranked[j].String()]
+		if hi != hj {
+			return hi < hj
+		}
+
+		// hash ties are broken by the identity so the order stays total
+		return ranked[i].String() < ranked[j].String()
+	})
+
+	return ranked, nil
+}
+
+// RankedLeaders returns the n participants of round k with the lowest
+// eligibility hashes. When the primary leader stays silent the next ranked
+// identity takes over deterministically
+func (oracle *WeightedOracle) RankedLeaders(layer LayerId, k uint32, n int) ([]PubKey, error) {
+	ranked, err := oracle.rankedLeaders(layer, k)
+	if err != nil {
+		return nil, err
+	}
+
+	if n < len(ranked) {
+		ranked = ranked[:n]
+	}
+
+	return ranked, nil
+}
+
+// LeaderRank returns the position of the identity among the ranked leaders
+// of its round, or -1 when the identity is not a participant
+func (oracle *WeightedOracle) LeaderRank(rq RoleRequest) (int, error) {
+	ranked, err := oracle.rankedLeaders(rq.layerId, rq.k)
+	if err != nil {
+		return -1, err
+	}
+
+	for rank, pubKey := range ranked {
+		if bytes.Equal(pubKey.Bytes(), rq.pubKey.Bytes()) {
+			return rank, nil
+		}
+	}
+
+	return -1, nil
+}
+
+// ValidateLeaderRank confirms the identity of the request holds the claimed
+// leader rank in its round
+func (oracle *WeightedOracle) ValidateLeaderRank(rq RoleRequest, rank int) (bool, error) {
+	actual, err := oracle.LeaderRank(rq)
+	if err != nil {
+		return false, err
+	}
+
+	return actual >= 0 && actual == rank, nil
+}
+
+// EvictLayer drops all cached results for the given layer
+func (oracle *WeightedOracle) EvictLayer(layerId LayerId) {
+	oracle.cache.evictLayer(layerId)
+}
+
+// SetHorizon overrides the retention horizon of the oracle
+func (oracle *WeightedOracle) SetHorizon(horizon uint64) {
+	oracle.window.horizon = horizon
+}
+
+// AdvanceLayer informs the oracle the given layer has started. All state of
+// layers that fell out of the horizon is dropped
+func (oracle *WeightedOracle) AdvanceLayer(layerId LayerId) {
+	oracle.cancelPrecompute()
+	oracle.window.advance(layerId)
+	oracle.cache.evictIf(oracle.window.tooOld)
+	oracle.metrics.reset()
+}
+
+// CacheCounters returns the number of cache hits and misses served so far
+func (oracle *WeightedOracle) CacheCounters() (uint64, uint64) {
+	return oracle.cache.counters()
+}
+
+// pubKeyId returns a unique id for an identity
+func pubKeyId(pubKey PubKey) uint32 {
+	h := fnv.New32()
+	h.Write(pubKey.Bytes())
+	return h.Sum32()
+}
+
+// roundKey identifies round k of a layer
+type roundKey struct {
+	layerId LayerId
+	k       uint32
+}
+
+// MockOracle is a naive oracle for tests. Per (layer, k) the querying
+// identity with the lowest id is the leader and all other queriers are
+// Active, so all nodes of a simulation agree on a single leader per round
+type MockOracle struct {
+	queriers map[roundKey]map[uint32]PubKey
+	window   layerWindow
+	metrics  oracleMetrics
+	mutex    sync.Mutex
+}
+
+var _ Rolacle = (*MockOracle)(nil)
+
+// NewMockOracle creates an initialized mock oracle. A non-nil clock drives
+// the layer window like it does for the weighted oracle
+func NewMockOracle(cfg OracleConfig, clock LayerClock, opts ...Option) *MockOracle {
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	mock := new(MockOracle)
+	mock.queriers = make(map[roundKey]map[uint32]PubKey)
+	mock.window.horizon = cfg.Horizon
+	mock.window.maxAhead = cfg.Lookahead
+
+	if clock != nil {
+		// subscribe before reading the current layer so no boundary in
+		// between is missed
+		updates := clock.Subscribe()
+		mock.window.advance(clock.CurrentLayer())
+		go mock.watchClock(updates)
+	}
+
+	return mock
+}
+
+// watchClock applies layer boundary notifications until the subscription is
+// closed
+func (mockOracle *MockOracle) watchClock(updates <-chan LayerId) {
+	for layer := range updates {
+		mockOracle.AdvanceLayer(layer)
+	}
+}
+
+// NewMockOracle initializes the mock internal state.
+// Deprecated: construct the mock with hare.NewMockOracle instead
+func (mockOracle *MockOracle) NewMockOracle() {
+	cfg := DefaultOracleConfig()
+	mockOracle.queriers = make(map[roundKey]map[uint32]PubKey)
+	mockOracle.window.horizon = cfg.Horizon
+	mockOracle.window.maxAhead = cfg.Lookahead
+}
+
+// register remembers that the identity of the request queried its round
+func (mockOracle *MockOracle) register(rq RoleRequest) {
+	key := roundKey{rq.layerId, rq.k}
+
+	if _, exist := mockOracle.queriers[key]; !exist {
+		mockOracle.queriers[key] = make(map[uint32]PubKey)
+	}
+
+	mockOracle.queriers[key][pubKeyId(rq.pubKey)] = rq.pubKey
+}
+
+// leader returns the registered identity with the lowest id
+func (mockOracle *MockOracle) leader(key roundKey) (PubKey, bool) {
+	var minId uint32
+	var leader PubKey
+
+	for id, pubKey := range mockOracle.queriers[key] {
+		if leader == nil || id < minId {
+			minId = id
+			leader = pubKey
+		}
+	}
+
+	return leader, leader != nil
+}
+
+// roleFor registers the querying identity and re-evaluates its role
+func (mockOracle *MockOracle) roleFor(rq RoleRequest) Role {
+	mockOracle.register(rq)
+
+	leader, _ := mockOracle.leader(roundKey{rq.layerId, rq.k})
+	if pubKeyId(leader) == pubKeyId(rq.pubKey) {
+		return Leader
+	}
+
+	return Active
+}
+
+// Role assigns a role to the request. The assignment of a round may change
+// as long as new identities query it, late queriers with a lower id take
+// the leadership over
+func (mockOracle *MockOracle) Role(rq RoleRequest) (Role, RoleProof, error) {
+	if err := checkRoleRequest(rq); err != nil {
+		return Passive, nil, err
+	}
+
+	if mockOracle.window.tooOld(rq.layerId) {
+		return Passive, nil, ErrLayerTooOld
+	}
+
+	if mockOracle.window.tooNew(rq.layerId) {
+		return Passive, nil, ErrLayerTooFarAhead
+	}
+
+	mockOracle.mutex.Lock()
+	role := mockOracle.roleFor(rq)
+	mockOracle.mutex.Unlock()
+
+	mockOracle.metrics.countRole(role)
+
+	return role, rq.bytes(), nil
+}
+
+// IsEligible reports whether the identity of the request may participate in
+// its round
+func (mockOracle *MockOracle) IsEligible(rq RoleRequest) bool {
+	role, _, err := mockOracle.Role(rq)
+	return err == nil && role != Passive
+}
+
+// Validate checks the claimed role against the current assignment. The
+// returned result explains why a claim was rejected
+func (mockOracle *MockOracle) Validate(rq RoleRequest, role Role, proof RoleProof) (bool, ValidationResult) {
+	if checkRoleRequest(rq) != nil || checkRoleProof(proof) != nil {
+		mockOracle.metrics.countValidation(InvalidRequest)
+		return false, InvalidRequest
+	}
+
+	result := mockOracle.doValidate(rq, role, proof)
+	mockOracle.metrics.countValidation(result)
+
+	return result == Valid, result
+}
+
+func (mockOracle *MockOracle) doValidate(rq RoleRequest, role Role, proof RoleProof) ValidationResult {
+	if mockOracle.window.tooOld(rq.layerId) {
+		return StaleLayer
+	}
+
+	if mockOracle.window.tooNew(rq.layerId) {
+		return FutureLayer
+	}
+
+	// every querier of a round is eligible in the mock, so a Passive claim
+	// holds only for identities that did not query the round. checking the
+	// claim does not register the claimant
+	if role == Passive {
+		if len(proof) != 0 {
+			return InvalidSignature
+		}
+
+		mockOracle.mutex.Lock()
+		defer mockOracle.mutex.Unlock()
+
+		if _, exist := mockOracle.queriers[roundKey{rq.layerId, rq.k}][pubKeyId(rq.pubKey)]; exist {
+			return WrongThreshold
+		}
+
+		return Valid
+	}
+
+	if !bytes.Equal(proof, rq.bytes()) {
+		return InvalidSignature
+	}
+
+	mockOracle.mutex.Lock()
+	defer mockOracle.mutex.Unlock()
+
+	if mockOracle.roleFor(rq) != role {
+		return WrongThreshold
+	}
+
+	return Valid
+}
+
+// Metrics returns a snapshot of the counters of the current layer
+func (mockOracle *MockOracle) Metrics() OracleMetrics {
+	return mockOracle.metrics.snapshot()
+}
+
+// SetHorizon overrides the retention horizon of the oracle
+func (mockOracle *MockOracle) SetHorizon(horizon uint64) {
+	mockOracle.window.horizon = horizon
+}
+
+// AdvanceLayer informs the oracle the given layer has started. All state of
+// layers that fell out of the horizon is dropped
+func (mockOracle *MockOracle) AdvanceLayer(layerId LayerId) {
+	mockOracle.window.advance(layerId)
+
+	mockOracle.mutex.Lock()
+	for key := range mockOracle.queriers {
+		if mockOracle.window.tooOld(key.layerId) {
+			delete(mockOracle.queriers, key)
+		}
+	}
+	mockOracle.mutex.Unlock()
+
+	mockOracle.metrics.reset()
+}
+
+// Leader returns the current leader of round k in the given layer and
+// whether a leader is already known
+func (mockOracle *MockOracle) Leader(layer LayerId, k uint32) (PubKey, bool) {
+	mockOracle.mutex.Lock()
+	defer mockOracle.mutex.Unlock()
+
+	return mockOracle.leader(roundKey{layer, k})
+}
+
+// rankedLeaders sorts the registered queriers of a round by their id,
+// holding the mutex
+func (mockOracle *MockOracle) rankedLeaders(key roundKey) []PubKey {
+	ranked := make([]PubKey, 0, len(mockOracle.queriers[key]))
+	for _, pubKey := range mockOracle.queriers[key] {
+		ranked = append(ranked, pubKey)
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		return pubKeyId(ranked[i]) < pubKeyId(ranked[j])
+	})
+
+	return ranked
+}
+
+// RankedLeaders returns up to n registered queriers of round k ordered by
+// their id, so the first entry is the current leader
+func (mockOracle *MockOracle) RankedLeaders(layer LayerId, k uint32, n int) ([]PubKey, error) {
+	mockOracle.mutex.Lock()
+	defer mockOracle.mutex.Unlock()
+
+	ranked := mockOracle.rankedLeaders(roundKey{layer, k})
+	if n < len(ranked) {
+		ranked = ranked[:n]
+	}
+
+	return ranked, nil
+}
+
+// ValidateLeaderRank confirms the identity of the request holds the claimed
+// leader rank in its round
+func (mockOracle *MockOracle) ValidateLeaderRank(rq RoleRequest, rank int) (bool, error) {
+	mockOracle.mutex.Lock()
+	defer mockOracle.mutex.Unlock()
+
+	for actual, pubKey := range mockOracle.rankedLeaders(roundKey{rq.layerId, rq.k}) {
+		if bytes.Equal(pubKey.Bytes(), rq.pubKey.Bytes()) {
+			return actual == rank, nil
+		}
+	}
+
+	return false, nil
+}
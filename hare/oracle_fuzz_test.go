@@ -0,0 +1,129 @@
+package hare
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// badSizeKey truncates the serialized form of a public key
+type badSizeKey struct {
+	crypto.PublicKey
+}
+
+func (key *badSizeKey) Bytes() []byte {
+	return key.PublicKey.Bytes()[:16]
+}
+
+func TestOracle_RejectsHostileRequests(t *testing.T) {
+	priv, pub := generateSigner(t)
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 100)
+	oracle := newTestWeightedOracle(t, provider, priv, committeeSize)
+	mock := NewMockOracle(DefaultOracleConfig(), nil)
+
+	// rounds far beyond any plausible protocol progress
+	_, _, err := oracle.Role(NewRoleRequest(pub, LayerId{1}, MaxRound+1))
+	assert.Equal(t, ErrRoundTooHigh, err)
+	_, _, err = mock.Role(NewRoleRequest(pub, LayerId{1}, MaxRound+1))
+	assert.Equal(t, ErrRoundTooHigh, err)
+
+	// truncated public key
+	truncated := NewRoleRequest(&badSizeKey{pub}, LayerId{1}, 1)
+	_, _, err = oracle.Role(truncated)
+	assert.Equal(t, ErrInvalidPubKey, err)
+	valid, result := oracle.Validate(truncated, Active, RoleProof{1})
+	assert.False(t, valid)
+	assert.Equal(t, InvalidRequest, result)
+	valid, result = mock.Validate(truncated, Active, RoleProof{1})
+	assert.False(t, valid)
+	assert.Equal(t, InvalidRequest, result)
+
+	// oversized proof
+	valid, result = oracle.Validate(NewRoleRequest(pub, LayerId{1}, 1), Active, make(RoleProof, maxProofSize+1))
+	assert.False(t, valid)
+	assert.Equal(t, InvalidRequest, result)
+
+	// extreme layer ids are valid input
+	var maxLayer LayerId
+	for i := range maxLayer {
+		maxLayer[i] = 0xff
+	}
+	_, _, err = oracle.Role(NewRoleRequest(pub, LayerId{}, 1))
+	assert.Nil(t, err)
+	_, _, err = oracle.Role(NewRoleRequest(pub, maxLayer, 1))
+	assert.Nil(t, err)
+}
+
+func TestWeightedOracle_ValidateRoleProperty(t *testing.T) {
+	priv, pub := generateSigner(t)
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 100)
+	oracle := newTestWeightedOracle(t, provider, priv, committeeSize)
+
+	// for any valid request a self computed role validates, and flipping
+	// any single byte of the proof invalidates it
+	for i := uint64(0); i < 10; i++ {
+		rq := NewRoleRequest(pub, testLayer(i), uint32(i))
+		role, proof, err := oracle.Role(rq)
+		assert.Nil(t, err)
+
+		valid, _ := oracle.Validate(rq, role, proof)
+		assert.True(t, valid)
+
+		for j := range proof {
+			flipped := make(RoleProof, len(proof))
+			copy(flipped, proof)
+			flipped[j] ^= 1
+
+			valid, _ := oracle.Validate(rq, role, flipped)
+			assert.False(t, valid, "proof with byte %v flipped was accepted", j)
+		}
+	}
+}
+
+func FuzzDeserializeRoleRequest(f *testing.F) {
+	priv, pub, err := crypto.GenerateKeyPair()
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 100)
+	oracle, err := NewWeightedOracle(DefaultOracleConfig(), provider, &testBeacon{[]byte{1, 2, 3}}, priv, nil)
+	if err != nil {
+		f.Fatal(err)
+	}
+	mock := NewMockOracle(DefaultOracleConfig(), nil)
+
+	f.Add(SerializeRoleRequest(NewRoleRequest(pub, LayerId{1}, 2)))
+	f.Add([]byte{})
+	f.Add(bytes.Repeat([]byte{0xff}, 1024))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		rq, err := DeserializeRoleRequest(data)
+		if err != nil {
+			return
+		}
+
+		// a parsed request serializes back to the exact input
+		if !bytes.Equal(SerializeRoleRequest(rq), data) {
+			t.Fatalf("role request did not round trip: %v", data)
+		}
+
+		role := Passive
+		if len(data) > 0 {
+			role = Role(data[0] % 3)
+		}
+
+		// hostile input is rejected, never panics
+		oracle.Role(rq)
+		oracle.Validate(rq, role, data)
+		mock.Role(rq)
+		mock.Validate(rq, role, data)
+	})
+}
@@ -0,0 +1,493 @@
+package hare
+
+import (
+	"encoding/binary"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const committeeSize = 10
+
+// testWeightProvider assigns a fixed weight per identity
+type testWeightProvider struct {
+	weights map[string]uint64
+	total   uint64
+}
+
+func newTestWeightProvider(total uint64) *testWeightProvider {
+	return &testWeightProvider{make(map[string]uint64), total}
+}
+
+func (provider *testWeightProvider) setWeight(pubKey PubKey, weight uint64) {
+	provider.weights[pubKey.String()] = weight
+}
+
+func (provider *testWeightProvider) Weight(pubKey PubKey, layer LayerId) (uint64, uint64, error) {
+	return provider.weights[pubKey.String()], provider.total, nil
+}
+
+func generateSigner(t *testing.T) (crypto.PrivateKey, crypto.PublicKey) {
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+	return priv, pub
+}
+
+// testBeacon returns a fixed seed for every layer
+type testBeacon struct {
+	seed []byte
+}
+
+func (beacon *testBeacon) Value(layer LayerId) ([]byte, error) {
+	return beacon.seed, nil
+}
+
+func newTestWeightedOracle(t *testing.T, provider WeightProvider, priv crypto.PrivateKey, committee uint32) *WeightedOracle {
+	oracle, err := NewWeightedOracle(DefaultOracleConfig(), provider, &testBeacon{[]byte{1, 2, 3}}, priv, nil, WithCommitteeSize(committee))
+	assert.Nil(t, err)
+	return oracle
+}
+
+// count in how many (layer, k) samples the identity is elected non-Passive
+func countElected(t *testing.T, oracle *WeightedOracle, pubKey PubKey, samples int) int {
+	elected := 0
+	for i := 0; i < samples; i++ {
+		layer := LayerId{byte(i), byte(i >> 8)}
+		role, _, err := oracle.Role(NewRoleRequest(pubKey, layer, uint32(i)))
+		assert.Nil(t, err)
+		if role != Passive {
+			elected++
+		}
+	}
+
+	return elected
+}
+
+func TestWeightedOracle_WeightShare(t *testing.T) {
+	priv, pub := generateSigner(t)
+	_, minor := generateSigner(t)
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 50)
+	provider.setWeight(minor, 1)
+
+	// committee size 1 so the election probability equals the weight share
+	oracle := newTestWeightedOracle(t, provider, priv, 1)
+
+	const samples = 1000
+	elected := countElected(t, oracle, pub, samples)
+	assert.True(t, elected > samples*4/10, "expected ~half the samples, got %v", elected)
+	assert.True(t, elected < samples*6/10, "expected ~half the samples, got %v", elected)
+
+	rarely := countElected(t, oracle, minor, samples)
+	assert.True(t, rarely < samples/10, "expected rare election, got %v", rarely)
+}
+
+func TestWeightedOracle_Validate(t *testing.T) {
+	priv, pub := generateSigner(t)
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 50)
+	oracle := newTestWeightedOracle(t, provider, priv, committeeSize)
+
+	rq := NewRoleRequest(pub, LayerId{1}, 2)
+	role, proof, err := oracle.Role(rq)
+	assert.Nil(t, err)
+	valid, result := oracle.Validate(rq, role, proof)
+	assert.True(t, valid)
+	assert.Equal(t, Valid, result)
+
+	// a validator seeing a different weight for the identity rejects the claim
+	mismatched := newTestWeightProvider(100)
+	mismatched.setWeight(pub, 1)
+	validator := newTestWeightedOracle(t, mismatched, priv, committeeSize)
+	valid, result = validator.Validate(rq, Leader, proof)
+	assert.False(t, valid)
+	assert.Equal(t, WrongThreshold, result)
+}
+
+func TestWeightedOracle_ValidateRejectsForgedProof(t *testing.T) {
+	priv, pub := generateSigner(t)
+	otherPriv, _ := generateSigner(t)
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 100)
+	oracle := newTestWeightedOracle(t, provider, priv, committeeSize)
+
+	rq := NewRoleRequest(pub, LayerId{1}, 2)
+	role, _, err := oracle.Role(rq)
+	assert.Nil(t, err)
+
+	forged, err := otherPriv.Sign(rq.bytes())
+	assert.Nil(t, err)
+	valid, result := oracle.Validate(rq, role, forged)
+	assert.False(t, valid)
+	assert.Equal(t, InvalidSignature, result)
+}
+
+func TestNewWeightedOracle_MissingDependencies(t *testing.T) {
+	priv, _ := generateSigner(t)
+	provider := newTestWeightProvider(100)
+	beacon := &testBeacon{[]byte{1}}
+
+	_, err := NewWeightedOracle(DefaultOracleConfig(), nil, beacon, priv, nil)
+	assert.NotNil(t, err)
+
+	_, err = NewWeightedOracle(DefaultOracleConfig(), provider, nil, priv, nil)
+	assert.NotNil(t, err)
+
+	_, err = NewWeightedOracle(DefaultOracleConfig(), provider, beacon, nil, nil)
+	assert.NotNil(t, err)
+
+	oracle, err := NewWeightedOracle(DefaultOracleConfig(), provider, beacon, priv, nil, WithCacheSize(16), WithHorizon(5))
+	assert.Nil(t, err)
+	assert.Equal(t, 16, oracle.cache.size)
+	assert.Equal(t, uint64(5), oracle.window.horizon)
+}
+
+func TestNewMockOracle(t *testing.T) {
+	_, pub := generateSigner(t)
+
+	oracle := NewMockOracle(DefaultOracleConfig(), nil, WithHorizon(7))
+	assert.Equal(t, uint64(7), oracle.window.horizon)
+
+	role, _, err := oracle.Role(NewRoleRequest(pub, LayerId{1}, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, Leader, role)
+
+	// the deprecated initializer still yields a usable oracle
+	deprecated := &MockOracle{}
+	deprecated.NewMockOracle()
+	role, _, err = deprecated.Role(NewRoleRequest(pub, LayerId{1}, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, Leader, role)
+}
+
+func TestMockOracle_Role(t *testing.T) {
+	oracle := &MockOracle{}
+	oracle.NewMockOracle()
+
+	_, pub1 := generateSigner(t)
+
+	rq1 := NewRoleRequest(pub1, LayerId{1}, 1)
+	role1, proof1, err := oracle.Role(rq1)
+	assert.Nil(t, err)
+	assert.Equal(t, Leader, role1)
+
+	leader, exist := oracle.Leader(LayerId{1}, 1)
+	assert.True(t, exist)
+	assert.Equal(t, pub1.String(), leader.String())
+
+	valid, _ := oracle.Validate(rq1, role1, proof1)
+	assert.True(t, valid)
+	valid, _ = oracle.Validate(rq1, Active, proof1)
+	assert.False(t, valid)
+}
+
+func testLayer(i uint64) LayerId {
+	var layer LayerId
+	binary.LittleEndian.PutUint64(layer[:8], i)
+	return layer
+}
+
+func TestMockOracle_AdvanceLayer(t *testing.T) {
+	oracle := &MockOracle{}
+	oracle.NewMockOracle()
+	oracle.SetHorizon(5)
+
+	_, pub := generateSigner(t)
+
+	const layers = 50
+	for i := uint64(1); i <= layers; i++ {
+		_, _, err := oracle.Role(NewRoleRequest(pub, testLayer(i), 1))
+		assert.Nil(t, err)
+		oracle.AdvanceLayer(testLayer(i))
+
+		// only rounds within the horizon are remembered
+		assert.True(t, len(oracle.queriers) <= 5, "state not bounded: %v", len(oracle.queriers))
+	}
+
+	// queries about evicted layers are rejected
+	_, _, err := oracle.Role(NewRoleRequest(pub, testLayer(1), 1))
+	assert.Equal(t, ErrLayerTooOld, err)
+	valid, result := oracle.Validate(NewRoleRequest(pub, testLayer(1), 1), Active, nil)
+	assert.False(t, valid)
+	assert.Equal(t, StaleLayer, result)
+
+	_, _, err = oracle.Role(NewRoleRequest(pub, testLayer(layers), 1))
+	assert.Nil(t, err)
+}
+
+func TestWeightedOracle_AdvanceLayer(t *testing.T) {
+	priv, pub := generateSigner(t)
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 100)
+	oracle := newTestWeightedOracle(t, provider, priv, committeeSize)
+	oracle.SetHorizon(5)
+
+	const layers = 50
+	for i := uint64(1); i <= layers; i++ {
+		_, _, err := oracle.Role(NewRoleRequest(pub, testLayer(i), 1))
+		assert.Nil(t, err)
+		oracle.AdvanceLayer(testLayer(i))
+
+		assert.True(t, len(oracle.cache.layers) <= 5, "cache not bounded: %v", len(oracle.cache.layers))
+	}
+
+	_, _, err := oracle.Role(NewRoleRequest(pub, testLayer(1), 1))
+	assert.Equal(t, ErrLayerTooOld, err)
+}
+
+// slowSigner delays every signature to make precomputation observable
+type slowSigner struct {
+	crypto.PrivateKey
+	delay time.Duration
+}
+
+func (signer *slowSigner) Sign(data []byte) ([]byte, error) {
+	time.Sleep(signer.delay)
+	return signer.PrivateKey.Sign(data)
+}
+
+func TestWeightedOracle_PrecomputeSelf(t *testing.T) {
+	priv, pub := generateSigner(t)
+	signer := &slowSigner{priv, 20 * time.Millisecond}
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 100)
+	oracle := newTestWeightedOracle(t, provider, signer, committeeSize)
+
+	layer := testLayer(1)
+	oracle.PrecomputeSelf(layer, 0, 9)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for oracle.cache.len() < 10 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, oracle.cache.len() >= 10, "precomputation did not finish")
+
+	start := time.Now()
+	role, proof, err := oracle.SelfRole(layer, 5)
+	assert.Nil(t, err)
+	assert.True(t, time.Since(start) < signer.delay, "SelfRole was not served from the cache")
+
+	// precomputed results match on demand computation
+	onDemand := newTestWeightedOracle(t, provider, priv, committeeSize)
+	expectedRole, expectedProof, err := onDemand.SelfRole(layer, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, expectedRole, role)
+	assert.Equal(t, expectedProof, proof)
+
+	// rounds outside the precomputed window fall back to computation
+	_, _, err = oracle.SelfRole(layer, 20)
+	assert.Nil(t, err)
+}
+
+func TestWeightedOracle_PassiveClaims(t *testing.T) {
+	priv, pub := generateSigner(t)
+	_, outsider := generateSigner(t)
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 100)
+	oracle := newTestWeightedOracle(t, provider, priv, committeeSize)
+
+	// an identity with no weight is Passive. its role carries no proof and
+	// leaves no cached state
+	rq := NewRoleRequest(outsider, LayerId{1}, 1)
+	role, proof, err := oracle.Role(rq)
+	assert.Nil(t, err)
+	assert.Equal(t, Passive, role)
+	assert.Empty(t, proof)
+	assert.Equal(t, 0, oracle.cache.len())
+	assert.False(t, oracle.IsEligible(rq))
+
+	valid, result := oracle.Validate(rq, Passive, nil)
+	assert.True(t, valid)
+	assert.Equal(t, Valid, result)
+
+	// find a round in which the weighted identity leads
+	var leaderRq RoleRequest
+	found := false
+	for k := uint32(0); k < 100 && !found; k++ {
+		leaderRq = NewRoleRequest(pub, LayerId{1}, k)
+		role, _, err := oracle.Role(leaderRq)
+		assert.Nil(t, err)
+		found = role == Leader
+	}
+	assert.True(t, found, "no leader round found")
+	assert.True(t, oracle.IsEligible(leaderRq))
+
+	// a leader cannot hide its role behind a Passive claim
+	valid, result = oracle.Validate(leaderRq, Passive, nil)
+	assert.False(t, valid)
+	assert.Equal(t, WrongThreshold, result)
+
+	// a Passive claim must carry no proof
+	valid, result = oracle.Validate(rq, Passive, RoleProof{1})
+	assert.False(t, valid)
+	assert.Equal(t, InvalidSignature, result)
+}
+
+func TestMockOracle_PassiveClaims(t *testing.T) {
+	oracle := NewMockOracle(DefaultOracleConfig(), nil)
+
+	_, pub := generateSigner(t)
+	rq := NewRoleRequest(pub, LayerId{1}, 1)
+
+	// identities that did not query the round are not eligible. checking
+	// the claim leaves no state behind
+	valid, result := oracle.Validate(rq, Passive, nil)
+	assert.True(t, valid)
+	assert.Equal(t, Valid, result)
+	assert.Equal(t, 0, len(oracle.queriers))
+
+	role, _, err := oracle.Role(rq)
+	assert.Nil(t, err)
+	assert.Equal(t, Leader, role)
+	assert.True(t, oracle.IsEligible(rq))
+
+	// a leader cannot hide its role behind a Passive claim
+	valid, result = oracle.Validate(rq, Passive, nil)
+	assert.False(t, valid)
+	assert.Equal(t, WrongThreshold, result)
+
+	// a Passive claim must carry no proof
+	_, other := generateSigner(t)
+	valid, result = oracle.Validate(NewRoleRequest(other, LayerId{1}, 1), Passive, RoleProof{1})
+	assert.False(t, valid)
+	assert.Equal(t, InvalidSignature, result)
+}
+
+// testParticipantProvider serves a fixed participant set for every layer
+type testParticipantProvider struct {
+	participants []PubKey
+}
+
+func (provider *testParticipantProvider) Participants(layer LayerId) ([]PubKey, error) {
+	return provider.participants, nil
+}
+
+func TestWeightedOracle_RankedLeaders(t *testing.T) {
+	const identityCount = 10
+
+	weights := newTestWeightProvider(uint64(identityCount))
+	participants := &testParticipantProvider{}
+	for i := 0; i < identityCount; i++ {
+		_, pub := generateSigner(t)
+		weights.setWeight(pub, 1)
+		participants.participants = append(participants.participants, pub)
+	}
+
+	priv, _ := generateSigner(t)
+	otherPriv, _ := generateSigner(t)
+	oracle := newTestWeightedOracle(t, weights, priv, committeeSize)
+	oracle.SetParticipantProvider(participants)
+
+	layer := testLayer(1)
+	ranked, err := oracle.RankedLeaders(layer, 2, identityCount)
+	assert.Nil(t, err)
+	assert.Equal(t, identityCount, len(ranked))
+
+	// the ranking is stable across calls and across oracle instances
+	again, err := oracle.RankedLeaders(layer, 2, identityCount)
+	assert.Nil(t, err)
+	assert.Equal(t, ranked, again)
+
+	other := newTestWeightedOracle(t, weights, otherPriv, committeeSize)
+	other.SetParticipantProvider(participants)
+	independent, err := other.RankedLeaders(layer, 2, identityCount)
+	assert.Nil(t, err)
+	assert.Equal(t, ranked, independent)
+
+	// n bounds the returned list
+	top, err := oracle.RankedLeaders(layer, 2, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, ranked[:3], top)
+
+	// rank claims are confirmed only for the actual position
+	valid, err := oracle.ValidateLeaderRank(NewRoleRequest(ranked[1], layer, 2), 1)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+	valid, err = oracle.ValidateLeaderRank(NewRoleRequest(ranked[1], layer, 2), 0)
+	assert.Nil(t, err)
+	assert.False(t, valid)
+
+	// non-participants hold no rank
+	_, outsider := generateSigner(t)
+	rank, err := oracle.LeaderRank(NewRoleRequest(outsider, layer, 2))
+	assert.Nil(t, err)
+	assert.Equal(t, -1, rank)
+
+	// without a participant provider ranking is unavailable
+	bare := newTestWeightedOracle(t, weights, priv, committeeSize)
+	_, err = bare.RankedLeaders(layer, 2, 1)
+	assert.NotNil(t, err)
+}
+
+func TestMockOracle_RankedLeaders(t *testing.T) {
+	oracle := NewMockOracle(DefaultOracleConfig(), nil)
+
+	layer := LayerId{1}
+	const identityCount = 5
+	for i := 0; i < identityCount; i++ {
+		_, pub := generateSigner(t)
+		_, _, err := oracle.Role(NewRoleRequest(pub, layer, 1))
+		assert.Nil(t, err)
+	}
+
+	ranked, err := oracle.RankedLeaders(layer, 1, identityCount)
+	assert.Nil(t, err)
+	assert.Equal(t, identityCount, len(ranked))
+
+	// the first ranked identity is the current leader
+	leader, exist := oracle.Leader(layer, 1)
+	assert.True(t, exist)
+	assert.Equal(t, leader.String(), ranked[0].String())
+
+	valid, err := oracle.ValidateLeaderRank(NewRoleRequest(ranked[2], layer, 1), 2)
+	assert.Nil(t, err)
+	assert.True(t, valid)
+	valid, err = oracle.ValidateLeaderRank(NewRoleRequest(ranked[2], layer, 1), 0)
+	assert.Nil(t, err)
+	assert.False(t, valid)
+}
+
+func TestMockOracle_LeaderPerRound(t *testing.T) {
+	oracle := &MockOracle{}
+	oracle.NewMockOracle()
+
+	const identityCount = 20
+	const rounds = 5
+
+	identities := make([]PubKey, identityCount)
+	for i := 0; i < identityCount; i++ {
+		_, identities[i] = generateSigner(t)
+	}
+
+	layer := LayerId{1}
+	for k := uint32(0); k < rounds; k++ {
+		// query in a round specific order
+		for i := 0; i < identityCount; i++ {
+			identity := identities[(i+int(k)*7)%identityCount]
+			_, _, err := oracle.Role(NewRoleRequest(identity, layer, k))
+			assert.Nil(t, err)
+		}
+
+		leader, exist := oracle.Leader(layer, k)
+		assert.True(t, exist)
+
+		// once all identities queried, everyone agrees on a single leader
+		leaderCount := 0
+		for _, identity := range identities {
+			role, _, err := oracle.Role(NewRoleRequest(identity, layer, k))
+			assert.Nil(t, err)
+			if role == Leader {
+				leaderCount++
+				assert.Equal(t, leader.String(), identity.String())
+			}
+		}
+		assert.Equal(t, 1, leaderCount)
+	}
+}
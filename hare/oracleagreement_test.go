@@ -0,0 +1,100 @@
+package hare
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// soakEnv switches the agreement harness to the longer CI soak run
+const soakEnv = "HARE_ORACLE_SOAK"
+
+// agreementParams sizes the multi-oracle agreement harness
+type agreementParams struct {
+	oracles    int
+	identities int
+	layers     uint64
+	rounds     uint32
+}
+
+func agreementParamsFromEnv() agreementParams {
+	if os.Getenv(soakEnv) != "" {
+		return agreementParams{oracles: 5, identities: 20, layers: 10, rounds: 10}
+	}
+
+	return agreementParams{oracles: 3, identities: 5, layers: 3, rounds: 4}
+}
+
+// TestOracleAgreement checks the core safety property of the weighted
+// oracle: independently constructed instances over the same beacon and
+// weights agree on every eligibility decision and accept each other's
+// proofs. Setting the HARE_ORACLE_SOAK environment variable widens the
+// identity set and the (layer, round) grid for soak runs
+func TestOracleAgreement(t *testing.T) {
+	params := agreementParamsFromEnv()
+
+	// the oracle identities participate like any other identity
+	privs := make([]crypto.PrivateKey, params.oracles)
+	identities := make([]PubKey, 0, params.oracles+params.identities)
+	for i := 0; i < params.oracles; i++ {
+		priv, pub := generateSigner(t)
+		privs[i] = priv
+		identities = append(identities, pub)
+	}
+	for i := 0; i < params.identities; i++ {
+		_, pub := generateSigner(t)
+		identities = append(identities, pub)
+	}
+
+	total := uint64(0)
+	for i := range identities {
+		total += uint64(i + 1)
+	}
+
+	provider := newTestWeightProvider(total)
+	for i, identity := range identities {
+		provider.setWeight(identity, uint64(i+1))
+	}
+
+	oracles := make([]*WeightedOracle, params.oracles)
+	for i := range oracles {
+		oracles[i] = newTestWeightedOracle(t, provider, privs[i], committeeSize)
+	}
+
+	for layer := uint64(1); layer <= params.layers; layer++ {
+		for k := uint32(0); k < params.rounds; k++ {
+			// every instance computes the same role for every identity
+			for _, identity := range identities {
+				rq := NewRoleRequest(identity, testLayer(layer), k)
+				role, _, err := oracles[0].Role(rq)
+				assert.Nil(t, err)
+
+				for i := 1; i < len(oracles); i++ {
+					other, _, err := oracles[i].Role(rq)
+					assert.Nil(t, err)
+					if other != role {
+						t.Fatalf("oracle %v diverges at layer %v round %v identity %v: role %v, oracle 0 computed %v",
+							i, layer, k, identity.String(), other, role)
+					}
+				}
+			}
+
+			// every instance's self proof validates on all instances
+			for i, oracle := range oracles {
+				role, proof, err := oracle.SelfRole(testLayer(layer), k)
+				assert.Nil(t, err)
+
+				rq := NewRoleRequest(identities[i], testLayer(layer), k)
+				for j, validator := range oracles {
+					valid, result := validator.Validate(rq, role, proof)
+					if !valid {
+						t.Fatalf("oracle %v rejects the proof of oracle %v at layer %v round %v identity %v role %v: %v",
+							j, i, layer, k, identities[i].String(), role, result)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package hare_test
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/hare"
+	"github.com/spacemeshos/go-spacemesh/hare/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// clockTestWeights makes every identity hold the full weight
+type clockTestWeights struct{}
+
+func (weights *clockTestWeights) Weight(pubKey hare.PubKey, layer hare.LayerId) (uint64, uint64, error) {
+	return 100, 100, nil
+}
+
+// clockTestBeacon returns a fixed seed for every layer
+type clockTestBeacon struct{}
+
+func (beacon *clockTestBeacon) Value(layer hare.LayerId) ([]byte, error) {
+	return []byte{1, 2, 3}, nil
+}
+
+func clockLayer(i uint64) hare.LayerId {
+	var layer hare.LayerId
+	binary.LittleEndian.PutUint64(layer[:8], i)
+	return layer
+}
+
+// waitFor polls the condition until it holds or the deadline expires
+func waitFor(t *testing.T, condition func() bool, msg string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, condition(), msg)
+}
+
+func TestWeightedOracle_ClockWindows(t *testing.T) {
+	priv, pub, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	clock := testutil.NewManualClock(clockLayer(100))
+	defer clock.Close()
+
+	oracle, err := hare.NewWeightedOracle(hare.DefaultOracleConfig(), &clockTestWeights{}, &clockTestBeacon{}, priv, clock,
+		hare.WithHorizon(5), hare.WithLookahead(3))
+	assert.Nil(t, err)
+
+	// requests within the window are answered
+	_, _, err = oracle.Role(hare.NewRoleRequest(pub, clockLayer(100), 1))
+	assert.Nil(t, err)
+	_, _, err = oracle.Role(hare.NewRoleRequest(pub, clockLayer(103), 1))
+	assert.Nil(t, err)
+
+	// layers beyond the lookahead are rejected
+	_, _, err = oracle.Role(hare.NewRoleRequest(pub, clockLayer(104), 1))
+	assert.Equal(t, hare.ErrLayerTooFarAhead, err)
+	valid, result := oracle.Validate(hare.NewRoleRequest(pub, clockLayer(104), 1), hare.Active, nil)
+	assert.False(t, valid)
+	assert.Equal(t, hare.FutureLayer, result)
+
+	// layers behind the horizon are rejected
+	_, _, err = oracle.Role(hare.NewRoleRequest(pub, clockLayer(95), 1))
+	assert.Equal(t, hare.ErrLayerTooOld, err)
+
+	// advancing the clock moves both boundaries
+	clock.Advance(clockLayer(110))
+	waitFor(t, func() bool {
+		_, _, err := oracle.Role(hare.NewRoleRequest(pub, clockLayer(105), 1))
+		return err == hare.ErrLayerTooOld
+	}, "horizon did not follow the clock")
+
+	_, _, err = oracle.Role(hare.NewRoleRequest(pub, clockLayer(113), 1))
+	assert.Nil(t, err)
+	_, _, err = oracle.Role(hare.NewRoleRequest(pub, clockLayer(114), 1))
+	assert.Equal(t, hare.ErrLayerTooFarAhead, err)
+}
+
+func TestWeightedOracle_ClockPrecompute(t *testing.T) {
+	priv, _, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	clock := testutil.NewManualClock(clockLayer(100))
+	defer clock.Close()
+
+	oracle, err := hare.NewWeightedOracle(hare.DefaultOracleConfig(), &clockTestWeights{}, &clockTestBeacon{}, priv, clock,
+		hare.WithPrecomputeRounds(3))
+	assert.Nil(t, err)
+
+	// a layer change triggers self-precomputation of the first rounds
+	clock.Advance(clockLayer(101))
+	waitFor(t, func() bool {
+		_, misses := oracle.CacheCounters()
+		return misses >= 3
+	}, "precomputation did not run")
+
+	hitsBefore, _ := oracle.CacheCounters()
+	_, _, err = oracle.SelfRole(clockLayer(101), 1)
+	assert.Nil(t, err)
+	hitsAfter, _ := oracle.CacheCounters()
+	assert.True(t, hitsAfter > hitsBefore, "SelfRole was not served from the cache")
+}
+
+func TestMockOracle_Clock(t *testing.T) {
+	_, pub, err := crypto.GenerateKeyPair()
+	assert.Nil(t, err)
+
+	clock := testutil.NewManualClock(clockLayer(100))
+	defer clock.Close()
+
+	oracle := hare.NewMockOracle(hare.DefaultOracleConfig(), clock, hare.WithHorizon(5), hare.WithLookahead(3))
+
+	_, _, err = oracle.Role(hare.NewRoleRequest(pub, clockLayer(100), 1))
+	assert.Nil(t, err)
+	_, _, err = oracle.Role(hare.NewRoleRequest(pub, clockLayer(104), 1))
+	assert.Equal(t, hare.ErrLayerTooFarAhead, err)
+
+	clock.Advance(clockLayer(110))
+	waitFor(t, func() bool {
+		_, _, err := oracle.Role(hare.NewRoleRequest(pub, clockLayer(105), 1))
+		return err == hare.ErrLayerTooOld
+	}, "horizon did not follow the clock")
+}
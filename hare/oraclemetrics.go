@@ -0,0 +1,98 @@
+package hare
+
+import "sync"
+
+// ValidationResult explains the outcome of a Validate call
+type ValidationResult byte
+
+const (
+	// Valid marks an accepted claim
+	Valid = ValidationResult(0)
+	// InvalidSignature marks a claim whose proof was not signed by the
+	// identity of the request
+	InvalidSignature = ValidationResult(1)
+	// WrongThreshold marks a claim whose role does not match the
+	// recomputed eligibility threshold
+	WrongThreshold = ValidationResult(2)
+	// StaleLayer marks a claim about a layer outside the horizon
+	StaleLayer = ValidationResult(3)
+	// InternalError marks a claim that could not be checked
+	InternalError = ValidationResult(4)
+	// InvalidRequest marks a claim whose request or proof failed the input
+	// screening
+	InvalidRequest = ValidationResult(5)
+	// FutureLayer marks a claim about a layer beyond the lookahead window
+	FutureLayer = ValidationResult(6)
+)
+
+// OracleMetrics is a snapshot of the election outcomes and validation
+// results counted since the last layer advancement
+type OracleMetrics struct {
+	LeaderCount           uint64
+	ActiveCount           uint64
+	PassiveCount          uint64
+	ValidCount            uint64
+	InvalidSignatureCount uint64
+	WrongThresholdCount   uint64
+	StaleLayerCount       uint64
+	InternalErrorCount    uint64
+	InvalidRequestCount   uint64
+	FutureLayerCount      uint64
+}
+
+// oracleMetrics is the concurrent counter behind OracleMetrics snapshots
+type oracleMetrics struct {
+	current OracleMetrics
+	mutex   sync.Mutex
+}
+
+// countRole counts a computed election outcome
+func (metrics *oracleMetrics) countRole(role Role) {
+	metrics.mutex.Lock()
+	switch role {
+	case Leader:
+		metrics.current.LeaderCount++
+	case Active:
+		metrics.current.ActiveCount++
+	case Passive:
+		metrics.current.PassiveCount++
+	}
+	metrics.mutex.Unlock()
+}
+
+// countValidation counts the result of a validated claim
+func (metrics *oracleMetrics) countValidation(result ValidationResult) {
+	metrics.mutex.Lock()
+	switch result {
+	case Valid:
+		metrics.current.ValidCount++
+	case InvalidSignature:
+		metrics.current.InvalidSignatureCount++
+	case WrongThreshold:
+		metrics.current.WrongThresholdCount++
+	case StaleLayer:
+		metrics.current.StaleLayerCount++
+	case InternalError:
+		metrics.current.InternalErrorCount++
+	case InvalidRequest:
+		metrics.current.InvalidRequestCount++
+	case FutureLayer:
+		metrics.current.FutureLayerCount++
+	}
+	metrics.mutex.Unlock()
+}
+
+// snapshot returns a copy of the current counters
+func (metrics *oracleMetrics) snapshot() OracleMetrics {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	return metrics.current
+}
+
+// reset clears all counters
+func (metrics *oracleMetrics) reset() {
+	metrics.mutex.Lock()
+	metrics.current = OracleMetrics{}
+	metrics.mutex.Unlock()
+}
@@ -0,0 +1,73 @@
+package hare
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWeightedOracle_Metrics(t *testing.T) {
+	priv, pub := generateSigner(t)
+	otherPriv, _ := generateSigner(t)
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 100)
+	oracle := newTestWeightedOracle(t, provider, priv, committeeSize)
+	oracle.SetHorizon(5)
+	oracle.AdvanceLayer(testLayer(10))
+
+	rq := NewRoleRequest(pub, testLayer(10), 1)
+	role, proof, err := oracle.Role(rq)
+	assert.Nil(t, err)
+
+	// one valid claim and one of each failure category
+	oracle.Validate(rq, role, proof)
+
+	forged, err := otherPriv.Sign(rq.bytes())
+	assert.Nil(t, err)
+	oracle.Validate(rq, role, forged)
+
+	wrong := Active
+	if role == Active {
+		wrong = Leader
+	}
+	oracle.Validate(rq, wrong, proof)
+
+	oracle.Validate(NewRoleRequest(pub, testLayer(1), 1), Active, proof)
+
+	metrics := oracle.Metrics()
+	assert.Equal(t, uint64(1), metrics.ValidCount)
+	assert.Equal(t, uint64(1), metrics.InvalidSignatureCount)
+	assert.Equal(t, uint64(1), metrics.WrongThresholdCount)
+	assert.Equal(t, uint64(1), metrics.StaleLayerCount)
+	assert.Equal(t, uint64(1), metrics.LeaderCount+metrics.ActiveCount+metrics.PassiveCount)
+
+	// counters reset on layer advancement
+	oracle.AdvanceLayer(testLayer(11))
+	assert.Equal(t, OracleMetrics{}, oracle.Metrics())
+}
+
+func TestMockOracle_Metrics(t *testing.T) {
+	oracle := NewMockOracle(DefaultOracleConfig(), nil, WithHorizon(5))
+	oracle.AdvanceLayer(testLayer(10))
+
+	_, pub := generateSigner(t)
+
+	rq := NewRoleRequest(pub, testLayer(10), 1)
+	role, proof, err := oracle.Role(rq)
+	assert.Nil(t, err)
+
+	oracle.Validate(rq, role, proof)
+	oracle.Validate(rq, role, RoleProof{1})
+	oracle.Validate(rq, Active, proof)
+	oracle.Validate(NewRoleRequest(pub, testLayer(1), 1), Active, nil)
+
+	metrics := oracle.Metrics()
+	assert.Equal(t, uint64(1), metrics.ValidCount)
+	assert.Equal(t, uint64(1), metrics.InvalidSignatureCount)
+	assert.Equal(t, uint64(1), metrics.WrongThresholdCount)
+	assert.Equal(t, uint64(1), metrics.StaleLayerCount)
+	assert.Equal(t, uint64(1), metrics.LeaderCount)
+
+	oracle.AdvanceLayer(testLayer(11))
+	assert.Equal(t, OracleMetrics{}, oracle.Metrics())
+}
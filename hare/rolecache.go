@@ -0,0 +1,134 @@
+package hare
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultRoleCacheSize is the number of oracle results cached when no
+// explicit size is configured
+const DefaultRoleCacheSize = 2048
+
+// cacheValue is a single cached oracle computation
+type cacheValue struct {
+	key     string
+	layerId LayerId
+	value   interface{}
+}
+
+// roleCache is an LRU cache of oracle computations keyed by the canonical
+// request bytes. Entries are also indexed by layer so the whole layer can be
+// evicted when it falls out of the horizon
+type roleCache struct {
+	size   int
+	items  map[string]*list.Element
+	order  *list.List // front is the most recently used entry
+	layers map[LayerId]map[string]struct{}
+	hits   uint64
+	misses uint64
+	mutex  sync.Mutex
+}
+
+func newRoleCache(size int) *roleCache {
+	if size <= 0 {
+		size = DefaultRoleCacheSize
+	}
+
+	cache := new(roleCache)
+	cache.size = size
+	cache.items = make(map[string]*list.Element)
+	cache.order = list.New()
+	cache.layers = make(map[LayerId]map[string]struct{})
+
+	return cache
+}
+
+// get returns the cached value for key and whether it exists
+func (cache *roleCache) get(key string) (interface{}, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	element, exist := cache.items[key]
+	if !exist {
+		cache.misses++
+		return nil, false
+	}
+
+	cache.hits++
+	cache.order.MoveToFront(element)
+
+	return element.Value.(*cacheValue).value, true
+}
+
+// put caches value under key, evicting the least recently used entry when
+// the cache is full
+func (cache *roleCache) put(key string, layerId LayerId, value interface{}) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if element, exist := cache.items[key]; exist {
+		cache.order.MoveToFront(element)
+		element.Value.(*cacheValue).value = value
+		return
+	}
+
+	if cache.order.Len() >= cache.size {
+		oldest := cache.order.Back().Value.(*cacheValue)
+		cache.order.Remove(cache.order.Back())
+		delete(cache.items, oldest.key)
+		delete(cache.layers[oldest.layerId], oldest.key)
+	}
+
+	cache.items[key] = cache.order.PushFront(&cacheValue{key, layerId, value})
+	if _, exist := cache.layers[layerId]; !exist {
+		cache.layers[layerId] = make(map[string]struct{})
+	}
+	cache.layers[layerId][key] = struct{}{}
+}
+
+// evictLayer drops all entries cached for the given layer
+func (cache *roleCache) evictLayer(layerId LayerId) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for key := range cache.layers[layerId] {
+		if element, exist := cache.items[key]; exist {
+			cache.order.Remove(element)
+			delete(cache.items, key)
+		}
+	}
+
+	delete(cache.layers, layerId)
+}
+
+// evictIf drops all entries of layers matching the given predicate
+func (cache *roleCache) evictIf(pred func(LayerId) bool) {
+	cache.mutex.Lock()
+	evict := make([]LayerId, 0)
+	for layerId := range cache.layers {
+		if pred(layerId) {
+			evict = append(evict, layerId)
+		}
+	}
+	cache.mutex.Unlock()
+
+	for _, layerId := range evict {
+		cache.evictLayer(layerId)
+	}
+}
+
+// len returns the number of cached entries
+func (cache *roleCache) len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return cache.order.Len()
+}
+
+// counters returns the number of cache hits and misses
+func (cache *roleCache) counters() (uint64, uint64) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return cache.hits, cache.misses
+}
@@ -0,0 +1,93 @@
+package hare
+
+import (
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// countingSigner wraps a private key and counts Sign calls
+type countingSigner struct {
+	crypto.PrivateKey
+	signCount int
+}
+
+func (signer *countingSigner) Sign(data []byte) ([]byte, error) {
+	signer.signCount++
+	return signer.PrivateKey.Sign(data)
+}
+
+func TestWeightedOracle_RoleIsCached(t *testing.T) {
+	priv, pub := generateSigner(t)
+	signer := &countingSigner{PrivateKey: priv}
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 100)
+	oracle := newTestWeightedOracle(t, provider, signer, committeeSize)
+
+	rq1 := NewRoleRequest(pub, LayerId{1}, 1)
+	rq2 := NewRoleRequest(pub, LayerId{1}, 2)
+
+	for i := 0; i < 1000; i++ {
+		_, _, err := oracle.Role(rq1)
+		assert.Nil(t, err)
+		_, _, err = oracle.Role(rq2)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, 2, signer.signCount)
+
+	hits, misses := oracle.CacheCounters()
+	assert.Equal(t, uint64(2*1000-2), hits)
+	assert.Equal(t, uint64(2), misses)
+}
+
+func TestWeightedOracle_ValidateIsCached(t *testing.T) {
+	priv, pub := generateSigner(t)
+
+	provider := newTestWeightProvider(100)
+	provider.setWeight(pub, 100)
+	oracle := newTestWeightedOracle(t, provider, priv, committeeSize)
+
+	rq := NewRoleRequest(pub, LayerId{1}, 1)
+	role, proof, err := oracle.Role(rq)
+	assert.Nil(t, err)
+
+	// both positive and negative outcomes are served from the cache
+	for i := 0; i < 1000; i++ {
+		valid, _ := oracle.Validate(rq, role, proof)
+		assert.True(t, valid)
+		valid, _ = oracle.Validate(rq, role, RoleProof{1, 2, 3})
+		assert.False(t, valid)
+	}
+
+	hits, _ := oracle.CacheCounters()
+	assert.True(t, hits >= 2*1000-2, "expected cached validations, hits=%v", hits)
+}
+
+func TestRoleCache_SizeIsBounded(t *testing.T) {
+	cache := newRoleCache(16)
+
+	for i := 0; i < 1000; i++ {
+		cache.put(string(rune(i)), LayerId{byte(i)}, i)
+	}
+
+	assert.Equal(t, 16, cache.len())
+}
+
+func TestRoleCache_EvictLayer(t *testing.T) {
+	cache := newRoleCache(16)
+
+	cache.put("a", LayerId{1}, 1)
+	cache.put("b", LayerId{1}, 2)
+	cache.put("c", LayerId{2}, 3)
+
+	cache.evictLayer(LayerId{1})
+
+	_, exist := cache.get("a")
+	assert.False(t, exist)
+	_, exist = cache.get("b")
+	assert.False(t, exist)
+	_, exist = cache.get("c")
+	assert.True(t, exist)
+}
@@ -0,0 +1,61 @@
+// Package testutil provides test doubles for the hare package.
+package testutil
+
+import (
+	"sync"
+
+	"github.com/spacemeshos/go-spacemesh/hare"
+)
+
+// ManualClock is a hare.LayerClock driven explicitly by tests
+type ManualClock struct {
+	current     hare.LayerId
+	subscribers []chan hare.LayerId
+	mutex       sync.Mutex
+}
+
+// NewManualClock creates a manual clock starting at the given layer
+func NewManualClock(current hare.LayerId) *ManualClock {
+	return &ManualClock{current: current}
+}
+
+// CurrentLayer returns the layer the clock was last advanced to
+func (clock *ManualClock) CurrentLayer() hare.LayerId {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return clock.current
+}
+
+// Subscribe returns a channel receiving every Advance call
+func (clock *ManualClock) Subscribe() <-chan hare.LayerId {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	subscriber := make(chan hare.LayerId, 16)
+	clock.subscribers = append(clock.subscribers, subscriber)
+
+	return subscriber
+}
+
+// Advance moves the clock to the given layer and notifies all subscribers
+func (clock *ManualClock) Advance(layer hare.LayerId) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.current = layer
+	for _, subscriber := range clock.subscribers {
+		subscriber <- layer
+	}
+}
+
+// Close ends all subscriptions
+func (clock *ManualClock) Close() {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	for _, subscriber := range clock.subscribers {
+		close(subscriber)
+	}
+	clock.subscribers = nil
+}
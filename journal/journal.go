@@ -0,0 +1,364 @@
+// Package journal persists node lifecycle events - peer churn, bootstrap
+// attempts, health transitions - to an append-only, size-capped binary log
+// in the node's data directory, so "why did my node lose all its peers at
+// 3am" can be answered from history instead of current state. Writes are
+// asynchronous, the hot path never blocks on disk.
+package journal
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/log"
+)
+
+// The journaled event types.
+const (
+	EventPeerConnected     = "peer/connected"
+	EventPeerDisconnected  = "peer/disconnected"
+	EventBootstrapStarted  = "bootstrap/started"
+	EventBootstrapFinished = "bootstrap/finished"
+	EventConfigReloaded    = "config/reloaded"
+	EventHealthChanged     = "health/changed"
+	EventFeatureMismatch   = "peer/feature-mismatch"
+)
+
+// fileName is the name of the current journal file. Rotated predecessors
+// carry a numeric suffix, journal.log.1 being the most recent.
+const fileName = "journal.log"
+
+// Event is one journal entry.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Peer   string    `json:"peer,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Config holds the journal's size and queue bounds.
+type Config struct {
+	MaxFileSize int // bytes per journal file before rotation
+	MaxFiles    int // files kept on disk - the current one plus rotated predecessors
+	QueueSize   int // events buffered on the asynchronous write path
+}
+
+// DefaultConfig defines the default journal bounds - a few megabytes of
+// history, which is plenty for peer churn rates measured in events per
+// minute.
+func DefaultConfig() Config {
+	return Config{
+		MaxFileSize: 1 << 20,
+		MaxFiles:    3,
+		QueueSize:   1024,
+	}
+}
+
+// Journal is an append-only event log. All methods are safe on a nil
+// journal - components record unconditionally and a node running without a
+// journal drops the events.
+type Journal struct {
+	dir string
+	cfg Config
+
+	queue   chan Event
+	flushes chan chan struct{}
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+
+	dropped uint64
+
+	// writer-goroutine state
+	file *os.File
+	size int64
+}
+
+// Open opens or creates the journal in the given directory. Zero config
+// fields take their defaults.
+func Open(dir string, cfg Config) (*Journal, error) {
+	def := DefaultConfig()
+	if cfg.MaxFileSize == 0 {
+		cfg.MaxFileSize = def.MaxFileSize
+	}
+	if cfg.MaxFiles == 0 {
+		cfg.MaxFiles = def.MaxFiles
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = def.QueueSize
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	j := &Journal{
+		dir:     dir,
+		cfg:     cfg,
+		queue:   make(chan Event, cfg.QueueSize),
+		flushes: make(chan chan struct{}),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if err := j.openFile(); err != nil {
+		return nil, err
+	}
+
+	go j.writeLoop()
+	return j, nil
+}
+
+// openFile opens the current journal file for appending. A torn record at
+// the tail - what a crash mid-write leaves behind - is truncated away so new
+// records don't land behind unreadable bytes.
+func (j *Journal) openFile() error {
+	path := filepath.Join(j.dir, fileName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	size := info.Size()
+	if size > 0 {
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if valid := validPrefix(raw); valid < size {
+			log.Warning("journal %v has a torn tail, truncating %v bytes", path, size-valid)
+			if err := f.Truncate(valid); err != nil {
+				f.Close()
+				return err
+			}
+			size = valid
+		}
+	}
+	j.file = f
+	j.size = size
+	return nil
+}
+
+// validPrefix returns the length of raw's longest prefix of whole, decodable
+// records.
+func validPrefix(raw []byte) int64 {
+	var offset int64
+	for len(raw) > 0 {
+		length, n := binary.Uvarint(raw)
+		if n <= 0 || uint64(len(raw)-n) < length {
+			break
+		}
+		if err := proto.Unmarshal(raw[n:n+int(length)], &record{}); err != nil {
+			break
+		}
+		offset += int64(n) + int64(length)
+		raw = raw[n+int(length):]
+	}
+	return offset
+}
+
+// Record queues an event for writing. It never blocks - when the queue is
+// full the event is dropped and counted. A zero time is stamped with now.
+func (j *Journal) Record(e Event) {
+	if j == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case j.queue <- e:
+	default:
+		atomic.AddUint64(&j.dropped, 1)
+	}
+}
+
+// Note records an event of the given type, stamped with now.
+func (j *Journal) Note(eventType, peer, reason string) {
+	j.Record(Event{Type: eventType, Peer: peer, Reason: reason})
+}
+
+// Dropped reports how many events were dropped because the write queue was
+// full.
+func (j *Journal) Dropped() uint64 {
+	if j == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&j.dropped)
+}
+
+// Read returns the journaled events at or after since, oldest first, across
+// all retained files. Pending writes are flushed first, so an event recorded
+// before Read is visible to it.
+func (j *Journal) Read(since time.Time) ([]Event, error) {
+	if j == nil {
+		return nil, nil
+	}
+	j.flush()
+
+	var events []Event
+	for i := j.cfg.MaxFiles - 1; i >= 0; i-- {
+		path := filepath.Join(j.dir, fileName)
+		if i > 0 {
+			path = filepath.Join(j.dir, rotatedName(i))
+		}
+		raw, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, decode(path, raw, since)...)
+	}
+	return events, nil
+}
+
+// decode parses one journal file's framed records. A torn or corrupt record
+// - the tail a crash mid-write leaves behind - ends the file, everything
+// before it is recovered.
+func decode(path string, raw []byte, since time.Time) []Event {
+	var events []Event
+	for len(raw) > 0 {
+		length, n := binary.Uvarint(raw)
+		if n <= 0 || uint64(len(raw)-n) < length {
+			log.Warning("journal %v has a truncated record at its tail, %v bytes dropped", path, len(raw))
+			break
+		}
+		var rec record
+		if err := proto.Unmarshal(raw[n:n+int(length)], &rec); err != nil {
+			log.Warning("journal %v has a corrupt record at its tail: %v", path, err)
+			break
+		}
+		raw = raw[n+int(length):]
+
+		if rec.Version > RecordVersion {
+			// written by a newer node - skip rather than misread
+			continue
+		}
+		e := Event{Time: time.Unix(0, rec.Time), Type: rec.Type, Peer: rec.Peer, Reason: rec.Reason}
+		if e.Time.Before(since) {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// flush waits until every event queued before it is written.
+func (j *Journal) flush() {
+	done := make(chan struct{})
+	select {
+	case j.flushes <- done:
+		<-done
+	case <-j.done:
+		// writer already exited, everything queued was drained by Close
+	}
+}
+
+// Close flushes the queue and closes the journal. Events recorded after
+// Close are dropped.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.once.Do(func() { close(j.closing) })
+	<-j.done
+	return nil
+}
+
+// writeLoop is the single writer - it owns the file, so writes and rotation
+// need no locking.
+func (j *Journal) writeLoop() {
+	defer close(j.done)
+	for {
+		select {
+		case e := <-j.queue:
+			j.write(e)
+		case done := <-j.flushes:
+			j.drain()
+			close(done)
+		case <-j.closing:
+			j.drain()
+			j.file.Close()
+			return
+		}
+	}
+}
+
+// drain writes everything currently queued.
+func (j *Journal) drain() {
+	for {
+		select {
+		case e := <-j.queue:
+			j.write(e)
+		default:
+			return
+		}
+	}
+}
+
+// write appends one framed record, rotating first when it would push the
+// current file over its size cap.
+func (j *Journal) write(e Event) {
+	rec := &record{Version: RecordVersion, Time: e.Time.UnixNano(), Type: e.Type, Peer: e.Peer, Reason: e.Reason}
+	encoded, err := proto.Marshal(rec)
+	if err != nil {
+		log.Error("journal failed to encode an event: %v", err)
+		return
+	}
+
+	frame := make([]byte, binary.MaxVarintLen64+len(encoded))
+	n := binary.PutUvarint(frame, uint64(len(encoded)))
+	n += copy(frame[n:], encoded)
+	frame = frame[:n]
+
+	if j.size > 0 && j.size+int64(len(frame)) > int64(j.cfg.MaxFileSize) {
+		j.rotate()
+	}
+
+	written, err := j.file.Write(frame)
+	if err != nil {
+		log.Error("journal write failed: %v", err)
+	}
+	j.size += int64(written)
+}
+
+// rotate shifts the retained files one slot back, dropping the oldest, and
+// starts a fresh current file.
+func (j *Journal) rotate() {
+	j.file.Close()
+
+	os.Remove(filepath.Join(j.dir, rotatedName(j.cfg.MaxFiles-1)))
+	for i := j.cfg.MaxFiles - 2; i >= 1; i-- {
+		os.Rename(filepath.Join(j.dir, rotatedName(i)), filepath.Join(j.dir, rotatedName(i+1)))
+	}
+	os.Rename(filepath.Join(j.dir, fileName), filepath.Join(j.dir, rotatedName(1)))
+
+	if err := j.openFile(); err != nil {
+		log.Error("journal rotation failed to open a fresh file: %v", err)
+	}
+}
+
+// rotatedName is the file name of the i-th rotated predecessor.
+func rotatedName(i int) string {
+	return fileName + "." + itoa(i)
+}
+
+// itoa avoids pulling strconv in for single-digit rotation indices.
+func itoa(i int) string {
+	if i < 10 {
+		return string('0' + rune(i))
+	}
+	return itoa(i/10) + itoa(i%10)
+}
@@ -0,0 +1,21 @@
+package journal
+
+// RecordVersion is the schema version stamped on every written record.
+// Readers skip records from a newer schema instead of misreading them.
+const RecordVersion = 1
+
+// record mirrors the Record message in journal.proto. The struct is tagged
+// by hand rather than generated - keep it in sync with the .proto file when
+// the schema changes, and bump RecordVersion for any change an old reader
+// would misread.
+type record struct {
+	Version uint32 `protobuf:"varint,1,opt,name=version"`
+	Time    int64  `protobuf:"varint,2,opt,name=time"`
+	Type    string `protobuf:"bytes,3,opt,name=type"`
+	Peer    string `protobuf:"bytes,4,opt,name=peer"`
+	Reason  string `protobuf:"bytes,5,opt,name=reason"`
+}
+
+func (r *record) Reset()         { *r = record{} }
+func (r *record) String() string { return r.Type }
+func (*record) ProtoMessage()    {}
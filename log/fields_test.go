@@ -0,0 +1,72 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldsConsoleEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewEncoded("fields-console-test", ConsoleEncoder, &buf)
+
+	l.With(NodeID("n1"), String("peer_id", "p1"), Int("count", 3), Duration("took", 1500*time.Millisecond)).Info("connected to peer")
+
+	out := buf.String()
+	assert.Contains(t, out, "connected to peer")
+	assert.Contains(t, out, "node_id=n1")
+	assert.Contains(t, out, "peer_id=p1")
+	assert.Contains(t, out, "count=3")
+	assert.Contains(t, out, "took=1.5s")
+	assert.Contains(t, out, "info")
+}
+
+func TestFieldsJSONEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewEncoded("fields-json-test", JSONEncoder, &buf)
+
+	l.With(NodeID("n1"), String("peer_id", "p1"), Int("count", 3)).Warning("peer disconnected")
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry), "each entry must be one well-formed json object")
+	assert.Equal(t, "warning", entry["level"])
+	assert.Equal(t, "peer disconnected", entry["msg"])
+	assert.Equal(t, "n1", entry["node_id"])
+	assert.Equal(t, "p1", entry["peer_id"])
+	assert.Equal(t, float64(3), entry["count"])
+}
+
+func TestWithInheritsParentFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewEncoded("fields-inherit-test", JSONEncoder, &buf)
+
+	parent := l.With(NodeID("n1"))
+	child := parent.With(String("peer_id", "p1"))
+
+	child.Info("child entry")
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "n1", entry["node_id"], "a derived logger inherits its parent's fields")
+	assert.Equal(t, "p1", entry["peer_id"])
+
+	// the parent is untouched by the derivation
+	buf.Reset()
+	parent.Info("parent entry")
+	entry = nil
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "n1", entry["node_id"])
+	_, leaked := entry["peer_id"]
+	assert.False(t, leaked, "fields added to a child must not leak into the parent")
+}
+
+func TestPrintfStyleStillWorks(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewEncoded("fields-printf-test", JSONEncoder, &buf)
+
+	// the classic formatted methods keep going through the module backends
+	assert.NotPanics(t, func() { l.Info("plain %v message", 1) })
+	assert.Empty(t, buf.String(), "printf-style entries are not redirected to the sink")
+}
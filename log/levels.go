@@ -0,0 +1,146 @@
+package log
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/op/go-logging.v1"
+)
+
+// Level is a log severity used by the runtime level registry.
+type Level int32
+
+const (
+	// DebugLevel enables everything including debug entries.
+	DebugLevel Level = iota
+	// InfoLevel is the default level.
+	InfoLevel
+	// WarningLevel keeps only warnings and errors.
+	WarningLevel
+	// ErrorLevel keeps only errors.
+	ErrorLevel
+)
+
+// moduleEntry is the registry's per-module state. The level is read on every
+// entry through an atomic so flipping it takes effect immediately on every
+// logger sharing the module, without a lock on the hot path.
+type moduleEntry struct {
+	level   int32 // atomic Level
+	backend logging.LeveledBackend
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]*moduleEntry)
+)
+
+// NewWithName creates a logger registered under a module name, so its level
+// can be flipped at runtime with SetLevel. Loggers created under the same
+// name share one dynamic level, and loggers derived from them via With
+// inherit it.
+func NewWithName(module string, enc Encoder, sink io.Writer) Log {
+	logger := logging.MustGetLogger(module)
+	logger.ExtraCalldepth = 1
+	logFormat := ` %{color:reset}%{color}%{level:.4s} %{id:03x} %{time:15:04:05.000} %{shortpkg}.%{shortfunc} ▶%{color:reset} %{message}`
+	backend := getBackendLevel(module, module, logFormat)
+	logger.SetBackend(backend)
+
+	registryMu.Lock()
+	entry, ok := registry[module]
+	if !ok {
+		entry = &moduleEntry{}
+		initial := InfoLevel
+		if debugMode {
+			initial = DebugLevel
+		}
+		atomic.StoreInt32(&entry.level, int32(initial))
+		registry[module] = entry
+	}
+	entry.backend = backend
+	registryMu.Unlock()
+
+	return Log{Logger: logger, encoder: enc, sink: sink, level: &entry.level}
+}
+
+// SetLevel sets a module's level, effective immediately on every logger
+// already created under that name. The module "*" sets every registered
+// module at once. Setting a module that is not registered yet pre-creates
+// its entry so the level is in force once its logger appears.
+func SetLevel(module string, level Level) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if module == "*" {
+		for name, entry := range registry {
+			applyLevel(name, entry, level)
+		}
+		return
+	}
+
+	entry, ok := registry[module]
+	if !ok {
+		entry = &moduleEntry{}
+		registry[module] = entry
+	}
+	applyLevel(module, entry, level)
+}
+
+// applyLevel stores the dynamic level and pushes it into the module's
+// console backend so the printf-style methods follow it too.
+func applyLevel(module string, entry *moduleEntry, level Level) {
+	atomic.StoreInt32(&entry.level, int32(level))
+	if entry.backend != nil {
+		entry.backend.SetLevel(backendLevel(level), module)
+	}
+}
+
+// RegisteredModules returns the names of all registered modules, sorted,
+// for enumeration by an admin interface.
+func RegisteredModules() []string {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// enabled reports whether an entry of the given level should be emitted by
+// this logger. A logger without a registered module keeps its construction
+// time backend gating only.
+func (l Log) enabled(level Level) bool {
+	if l.level == nil {
+		return true
+	}
+	return level >= Level(atomic.LoadInt32(l.level))
+}
+
+func backendLevel(level Level) logging.Level {
+	switch level {
+	case DebugLevel:
+		return logging.DEBUG
+	case WarningLevel:
+		return logging.WARNING
+	case ErrorLevel:
+		return logging.ERROR
+	default:
+		return logging.INFO
+	}
+}
+
+func levelName(level Level) string {
+	switch level {
+	case DebugLevel:
+		return "debug"
+	case WarningLevel:
+		return "warning"
+	case ErrorLevel:
+		return "error"
+	default:
+		return "info"
+	}
+}
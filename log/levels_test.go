@@ -0,0 +1,58 @@
+package log
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLevelAffectsOnlyNamedModule(t *testing.T) {
+	var bufA, bufB bytes.Buffer
+	a := NewWithName("levels-test-a", ConsoleEncoder, &bufA)
+	b := NewWithName("levels-test-b", ConsoleEncoder, &bufB)
+
+	// a child created before the level flip must still pick it up
+	childA := a.With(String("peer_id", "p1"))
+
+	a.With().Debug("a before")
+	b.With().Debug("b before")
+	childA.Debug("child before")
+	assert.Empty(t, bufA.String(), "debug is filtered at the default level")
+	assert.Empty(t, bufB.String())
+
+	SetLevel("levels-test-a", DebugLevel)
+
+	a.With().Debug("a after")
+	b.With().Debug("b after")
+	childA.Debug("child after")
+
+	assert.Contains(t, bufA.String(), "a after")
+	assert.Contains(t, bufA.String(), "child after", "loggers derived before the flip follow the module's dynamic level")
+	assert.Empty(t, bufB.String(), "flipping one module must not enable debug on another")
+}
+
+func TestSetLevelWildcard(t *testing.T) {
+	var bufA, bufB bytes.Buffer
+	a := NewWithName("levels-wild-a", ConsoleEncoder, &bufA)
+	b := NewWithName("levels-wild-b", ConsoleEncoder, &bufB)
+
+	SetLevel("*", ErrorLevel)
+
+	a.With().Warning("a warning")
+	b.With().Warning("b warning")
+	assert.Empty(t, bufA.String())
+	assert.Empty(t, bufB.String())
+
+	a.With().Error("a error")
+	assert.Contains(t, bufA.String(), "a error")
+
+	SetLevel("*", InfoLevel)
+}
+
+func TestRegisteredModulesEnumeration(t *testing.T) {
+	var buf bytes.Buffer
+	NewWithName("levels-enum-test", ConsoleEncoder, &buf)
+
+	assert.Contains(t, RegisteredModules(), "levels-enum-test")
+}
@@ -4,6 +4,7 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
@@ -15,6 +16,9 @@ import (
 // logging library can be replaced as long as it implements same functionality used across the project.
 type Log struct {
 	*logging.Logger
+	encoder Encoder   // rendering for field-based entries derived via With
+	sink    io.Writer // destination for field-based entries, nil routes console form through the backends
+	level   *int32    // module's dynamic level shared through the registry, nil when unregistered
 }
 
 // smlogger is the local app singleton logger.
@@ -68,7 +72,7 @@ func New(module string, dataFolderPath string, logFileName string) Log {
 
 	log.SetBackend(logging.MultiLogger(backends...))
 
-	return Log{log}
+	return Log{Logger: log}
 }
 
 // getBackendLevelWithFileBackend returns backends level including log file backend
@@ -109,7 +113,7 @@ func InitSpacemeshLoggingSystem(dataFolderPath string, logFileName string) {
 
 	log.SetBackend(logging.MultiLogger(backends...))
 
-	AppLog = Log{log}
+	AppLog = Log{Logger: log}
 }
 
 // public wrappers abstracting away logging lib impl
@@ -0,0 +1,42 @@
+package merkle
+
+import (
+	"errors"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// KV is a user (key, value) pair for batch insertion. A slice keeps the
+// application order deterministic.
+type KV struct {
+	K []byte
+	V []byte
+}
+
+// PutBatch stores all entries in the tree. The structural changes are applied
+// in memory and every resulting tree node is committed to the tree db in a
+// single leveldb batch - intermediate nodes superseded within the batch never
+// reach the disk at all. The resulting root hash is identical to applying the
+// same entries with sequential Puts. When an entry fails, the batch is
+// discarded and no tree nodes are written.
+func (mt *merkleTreeImp) PutBatch(entries []KV) error {
+
+	if mt.batch != nil {
+		return errors.New("a batch is already being applied")
+	}
+
+	mt.batch = new(leveldb.Batch)
+	mt.treeData.pending = make(map[string][]byte)
+	defer func() {
+		mt.batch = nil
+		mt.treeData.pending = nil
+	}()
+
+	for _, e := range entries {
+		err := mt.Put(e.K, e.V)
+		if err != nil {
+			return err
+		}
+	}
+
+	return mt.treeData.DB.Write(mt.batch, nil)
+}
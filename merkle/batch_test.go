@@ -0,0 +1,140 @@
+package merkle
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/spacemeshos/go-spacemesh/filesystem"
+	"github.com/stretchr/testify/assert"
+)
+
+// deterministic pseudo-random entries with unique keys
+func batchEntries(n int) []KV {
+	rnd := rand.New(rand.NewSource(42))
+	entries := make([]KV, n)
+	for i := range entries {
+		k := make([]byte, 32)
+		v := make([]byte, 32)
+		rnd.Read(k)
+		rnd.Read(v)
+		entries[i] = KV{K: k, V: v}
+	}
+	return entries
+}
+
+func TestPutBatchMatchesSequentialPut(t *testing.T) {
+
+	err := filesystem.DeleteAllTempFiles()
+	assert.NoError(t, err, "failed to clean temp folder")
+
+	entries := batchEntries(200)
+
+	// one tree built with sequential puts
+	userDb, treeDb := getDbPaths(t)
+	seq, err := NewEmptyTree(userDb, treeDb)
+	assert.NoError(t, err, "failed to create new Merkle tree")
+	defer seq.CloseDataStores()
+
+	for _, e := range entries {
+		tryPut(t, seq, e.K, e.V)
+	}
+
+	// one tree built with a single batch
+	userDb1, treeDb1 := getNamedDbPaths(t, "batched")
+	batched, err := NewEmptyTree(userDb1, treeDb1)
+	assert.NoError(t, err, "failed to create new Merkle tree")
+	defer batched.CloseDataStores()
+
+	err = batched.PutBatch(entries)
+	assert.NoError(t, err, "failed to put batch")
+
+	assert.True(t, bytes.Equal(seq.GetRootHash(), batched.GetRootHash()), "expected the same root hash for batched and sequential puts")
+
+	r, err := batched.ValidateStructure(batched.GetRootNode())
+	assert.NoError(t, err, "invalid tree structure")
+	assert.True(t, bytes.Equal(r, batched.GetRootHash()), "unexpected root hash")
+
+	// reads interleaved with batching, before the stores are closed
+	for _, e := range entries {
+		validateGet(t, batched, e.K, e.V)
+	}
+
+	// a second batch on top of the first
+	more := batchEntries(50)
+	for i := range more {
+		more[i].K[0] ^= 0xff // steer clear of the first key set
+	}
+	err = batched.PutBatch(more)
+	assert.NoError(t, err, "failed to put batch")
+
+	for _, e := range more {
+		validateGet(t, batched, e.K, e.V)
+	}
+	validateGet(t, batched, entries[0].K, entries[0].V)
+
+	// the reopened tree must serve the batched data too
+	err = batched.CloseDataStores()
+	assert.NoError(t, err, "failed to close data stores")
+
+	reopened, err := NewTreeFromDb(batched.GetRootHash(), userDb1, treeDb1)
+	assert.NoError(t, err, "failed to reopen tree from db")
+	defer reopened.CloseDataStores()
+
+	validateGet(t, reopened, entries[0].K, entries[0].V)
+	validateGet(t, reopened, more[0].K, more[0].V)
+}
+
+const benchmarkBatchSize = 10000
+
+func BenchmarkSequentialPut(b *testing.B) {
+	entries := batchEntries(benchmarkBatchSize)
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		m := newBenchmarkTree(b, fmt.Sprintf("seq-%d", i))
+		b.StartTimer()
+
+		for _, e := range entries {
+			if err := m.Put(e.K, e.V); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.StopTimer()
+		m.CloseDataStores()
+		b.StartTimer()
+	}
+}
+
+func BenchmarkPutBatch(b *testing.B) {
+	entries := batchEntries(benchmarkBatchSize)
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		m := newBenchmarkTree(b, fmt.Sprintf("batch-%d", i))
+		b.StartTimer()
+
+		if err := m.PutBatch(entries); err != nil {
+			b.Fatal(err)
+		}
+
+		b.StopTimer()
+		m.CloseDataStores()
+		b.StartTimer()
+	}
+}
+
+func newBenchmarkTree(b *testing.B, name string) Tree {
+	b.Helper()
+	tempDir, err := filesystem.GetSpacemeshTempDirectoryPath()
+	if err != nil {
+		b.Fatal(err)
+	}
+	m, err := NewEmptyTree(tempDir+"/"+name+"-userdata.db", tempDir+"/"+name+"-tree.db")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return m
+}
@@ -1,98 +1,165 @@
 package merkle
 
 import (
+	"bytes"
+	"encoding/hex"
 	"errors"
+	"github.com/spacemeshos/go-spacemesh/log"
 )
 
-// remove v keyed by k from the tree
+// Delete removes the user value keyed by k from the tree, collapses any branch
+// node left with a single child back into a short node and recomputes hashes
+// up to the root. Deleting a key that is not in the tree is a no-op and
+// returns nil.
 func (mt *merkleTreeImp) Delete(k []byte) error {
 
-	return errors.New("not implemented yet")
-
-	//	if len(k) == 0 {
-	//		return ErrorInvalidUserData
-	//	}
-	//
-	//	// first, attempt to find the value in the tree and return path to where value should be added
-	//	// in the case it is not already in the tree
-	//	res, stack, err := mt.Get(k)
-	//
-	//	if len(res) == 0 || err != nil {
-	//		return nil
-	//	}
-	//
-	//	mt.deleteUserValueFromStorge(res)
-	//
-	//	hexKey := hex.EncodeToString(k)
-	//	log.Info("m Deleting user data for key: %s...", hexKey)
-	//
-	//	err = mt.delete(hexKey, stack)
-	//	if err != nil {
-	//		return err
-	//	}
-	//
-	//	return nil
+	if len(k) == 0 {
+		return ErrorInvalidUserData
+	}
+
+	// attempt to find the value in the tree and the tree path leading to it
+	res, s, err := mt.Get(k)
+	if err != nil {
+		return err
+	}
+
+	if len(res) == 0 {
+		// deleting a non-existent key is a no-op
+		return nil
+	}
+
+	if len(res) > 32 {
+		// a long value lives in the user db keyed by its hash - remove it there
+		err = mt.deleteUserValueFromStore(res)
+		if err != nil {
+			return err
+		}
+	}
+
+	hexKey := hex.EncodeToString(k)
+	log.Debug("Merkle deleting user data for key: %s...", hexKey)
+
+	return mt.delete(hexKey, s)
 }
 
-// Deletes value from node at top of the stack from the tree
-// Stack contains a path to a node to be deleted
-// eg. ext -> branch -> ext -> branch
-// k: path to path last node (top of stack)
-// s: path to node to be deleted from tree root
+// Removes the value held by the node at the top of the stack from the tree
+// structure and rebalances the path above it.
+// k: full hex-encoded path to the node at the top of the stack
+// s: stack of nodes from the value's node (top) back to the tree root
 func (mt *merkleTreeImp) delete(k string, s *stack) error {
 
 	lastNode := s.pop()
-
 	if lastNode == nil {
 		return nil
 	}
 
-	parentNode := s.pop()
-	if parentNode == nil {
-		// tree with 1 leaf - remove leaf and set to empty tree
-		mt.removeNodeFromStore(lastNode)
-		mt.root = nil
-		return nil
-	}
+	// pos: # of path chars consumed by the nodes above lastNode
+	var pos int
 
 	if lastNode.isBranch() {
-		// last node is a branch - clear its value
+		// the value terminated at a branch - clear it
 		mt.removeNodeFromStore(lastNode)
 		lastNode.getBranchNode().setValue(nil)
-		mt.persistNode(lastNode)
+		pos = len(k)
 	} else {
-		// last node is a leaf and leaf parent must be a branch
+		// the value is held by a leaf - remove it from its parent branch
 		path := lastNode.getNodeEmbeddedPath()
-
-		k = k[:len(k)-len(path)]
 		mt.removeNodeFromStore(lastNode)
 
-		// get the removed leaf idx from the key
-		idx := string(k[len(k)-1])
-
-		// remove the leaf from the branch
-		parentNode.removeBranchChild(idx)
-
-		// update keys, last node and parent node
-		k = k[:len(k)-2]
+		parentNode := s.pop()
+		if parentNode == nil {
+			// the leaf was the whole tree - back to an empty tree
+			mt.root = nil
+			return nil
+		}
+
+		// a leaf's parent is always a branch, its index char precedes the leaf path
+		pos = len(k) - len(path) - 1
+		mt.removeNodeFromStore(parentNode)
+		err := parentNode.removeBranchChild(string(k[pos]))
+		if err != nil {
+			return err
+		}
 		lastNode = parentNode
-		parentNode = s.pop()
 	}
 
-	// last node is a branch. if there's only 1 child we need
-	// to collapse it to an ext node
+	// lastNode is now a branch that may have dropped to a single child or to
+	// just its own value - collapse it back into a short node in that case
+	childrenCount := lastNode.getChildrenCount()
+	branchValue := lastNode.getBranchNode().getValue()
+
+	var collapsed Node
+
+	if childrenCount == 1 && len(branchValue) == 0 {
+		child := lastNode.getAllChildren()[0]
+		idx, err := mt.branchChildIdx(lastNode, child)
+		if err != nil {
+			return err
+		}
+
+		mt.removeNodeFromStore(lastNode)
+
+		if child.isBranch() {
+			// a branch child stays a branch - point an ext node at it
+			collapsed, err = newExtNodeContainer(idx, child.getNodeHash())
+			if err != nil {
+				return err
+			}
+		} else {
+			// pull the short child up, prefixing its path with its branch index
+			mt.removeNodeFromStore(child)
+			child.getShortNode().setPath(idx + child.getShortNode().getPath())
+			collapsed = child
+		}
+	} else if childrenCount == 0 && len(branchValue) > 0 {
+		// only the branch's own value is left - fold it into a leaf
+		mt.removeNodeFromStore(lastNode)
+		var err error
+		collapsed, err = newLeafNodeContainer("", branchValue)
+		if err != nil {
+			return err
+		}
+	}
 
-	if lastNode.getChildrenCount() == 1 {
-		// we need to add the node below current branch node
-		// to the node above it
+	if collapsed == nil {
+		// no collapse - the modified branch stays in place
+		s.push(lastNode)
+		k = k[:pos]
 	} else {
-		s.push(parentNode)
+		// an ext node above a collapsed branch merges into the result
+		parentNode := s.pop()
+		if parentNode != nil && parentNode.isExt() {
+			mt.removeNodeFromStore(parentNode)
+			extPath := parentNode.getNodeEmbeddedPath()
+			pos -= len(extPath)
+			collapsed.getShortNode().setPath(extPath + collapsed.getShortNode().getPath())
+		} else if parentNode != nil {
+			s.push(parentNode)
+		}
+		s.push(collapsed)
+		k = k[:pos] + collapsed.getNodeEmbeddedPath()
 	}
 
-	s.push(lastNode)
+	// update all pointers and hashes on the path specified by the stack
+	err := mt.update(k, s)
+	if err != nil {
+		return err
+	}
 
-	// update all pointers in the path specified by stack
-	mt.update(k, s)
+	nodes := s.toSlice()
+	mt.root = nodes[s.len()-1]
 
 	return nil
 }
+
+// Returns the branch index (hex char) pointing to child in a branch node
+func (mt *merkleTreeImp) branchChildIdx(branch Node, child Node) (string, error) {
+	hash := child.getNodeHash()
+	for i := byte(0); i < 16; i++ {
+		idx, _ := toHexChar(i)
+		if bytes.Equal(branch.getBranchNode().getPointer(idx), hash) {
+			return idx, nil
+		}
+	}
+	return "", errors.New("child not found in branch node")
+}
@@ -0,0 +1,214 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/filesystem"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+// db paths for an extra tree living next to the one from getDbPaths
+func getNamedDbPaths(t *testing.T, name string) (string, string) {
+	t.Helper()
+	tempDir, err := filesystem.GetSpacemeshTempDirectoryPath()
+	assert.NoError(t, err, "failed to get temp dir")
+	userDb := filepath.Join(tempDir, name+"-userdata.db")
+	treeDb := filepath.Join(tempDir, name+"-tree.db")
+	return userDb, treeDb
+}
+
+// helper method - validate a key is not in the tree
+func validateAbsent(t *testing.T, tree Tree, k []byte) {
+	t.Helper()
+	res, _, err := tree.Get(k)
+	assert.NoError(t, err, "failed to get data")
+	assert.True(t, len(res) == 0, "expected no data for deleted key")
+}
+
+func TestDeleteLastKey(t *testing.T) {
+
+	err := filesystem.DeleteAllTempFiles()
+	assert.NoError(t, err, "failed to clean temp folder")
+
+	userDb, treeDb := getDbPaths(t)
+	m, err := NewEmptyTree(userDb, treeDb)
+	assert.NoError(t, err, "failed to create new Merkle tree")
+	defer m.CloseDataStores()
+
+	k := []byte("the-name-of-my-cat")
+	v := []byte("zifton-the-immortal")
+
+	tryPut(t, m, k, v)
+	validateGet(t, m, k, v)
+
+	err = m.Delete(k)
+	assert.NoError(t, err, "failed to delete key")
+
+	assert.Nil(t, m.GetRootNode(), "expected empty tree")
+	assert.True(t, bytes.Equal(EmptyTreeRootHash, m.GetRootHash()), "expected empty tree root hash")
+	validateAbsent(t, m, k)
+
+	// deleting a non-existent key is a no-op
+	err = m.Delete(k)
+	assert.NoError(t, err, "expected deleting a missing key to be a no-op")
+
+	err = m.Delete([]byte{})
+	assert.Error(t, err, "expected an error for an empty key")
+}
+
+func TestDeleteCollapsesBranch(t *testing.T) {
+
+	err := filesystem.DeleteAllTempFiles()
+	assert.NoError(t, err, "failed to clean temp folder")
+
+	// the keys share a long prefix - an ext node over a branch with 2 leaves
+	k1, err := hex.DecodeString("123456")
+	assert.NoError(t, err, "invalid hex str")
+	v1 := []byte("zifton")
+
+	k2, err := hex.DecodeString("123457")
+	assert.NoError(t, err, "invalid hex str")
+	v2 := []byte("tantalus")
+
+	userDb, treeDb := getDbPaths(t)
+	m, err := NewEmptyTree(userDb, treeDb)
+	assert.NoError(t, err, "failed to create new Merkle tree")
+	defer m.CloseDataStores()
+
+	tryPut(t, m, k1, v1)
+	tryPut(t, m, k2, v2)
+
+	err = m.Delete(k2)
+	assert.NoError(t, err, "failed to delete key")
+
+	r, err := m.ValidateStructure(m.GetRootNode())
+	assert.NoError(t, err, "invalid tree structure")
+	assert.True(t, bytes.Equal(r, m.GetRootHash()), "unexpected root hash")
+
+	validateGet(t, m, k1, v1)
+	validateAbsent(t, m, k2)
+
+	// the branch must have collapsed - the tree should be indistinguishable
+	// from one that never held the deleted key
+	userDb1, treeDb1 := getNamedDbPaths(t, "expected")
+	m1, err := NewEmptyTree(userDb1, treeDb1)
+	assert.NoError(t, err, "failed to create new Merkle tree")
+	defer m1.CloseDataStores()
+
+	tryPut(t, m1, k1, v1)
+	assert.True(t, bytes.Equal(m1.GetRootHash(), m.GetRootHash()), "expected same root hash as a tree without the deleted key")
+}
+
+func TestDeletePrefixKeys(t *testing.T) {
+
+	err := filesystem.DeleteAllTempFiles()
+	assert.NoError(t, err, "failed to clean temp folder")
+
+	// k1 is a prefix of k2 - v1 is stored at a branch node
+	k1, err := hex.DecodeString("12")
+	assert.NoError(t, err, "invalid hex str")
+	v1 := []byte("zifton")
+
+	k2, err := hex.DecodeString("1234")
+	assert.NoError(t, err, "invalid hex str")
+	v2 := []byte("tantalus")
+
+	userDb, treeDb := getDbPaths(t)
+	m, err := NewEmptyTree(userDb, treeDb)
+	assert.NoError(t, err, "failed to create new Merkle tree")
+	defer m.CloseDataStores()
+
+	tryPut(t, m, k1, v1)
+	tryPut(t, m, k2, v2)
+
+	// delete the value stored at the branch node
+	err = m.Delete(k1)
+	assert.NoError(t, err, "failed to delete key")
+
+	r, err := m.ValidateStructure(m.GetRootNode())
+	assert.NoError(t, err, "invalid tree structure")
+	assert.True(t, bytes.Equal(r, m.GetRootHash()), "unexpected root hash")
+
+	validateGet(t, m, k2, v2)
+	validateAbsent(t, m, k1)
+
+	// put it back and delete the leaf below the branch instead
+	tryPut(t, m, k1, v1)
+	err = m.Delete(k2)
+	assert.NoError(t, err, "failed to delete key")
+
+	r, err = m.ValidateStructure(m.GetRootNode())
+	assert.NoError(t, err, "invalid tree structure")
+	assert.True(t, bytes.Equal(r, m.GetRootHash()), "unexpected root hash")
+
+	validateGet(t, m, k1, v1)
+	validateAbsent(t, m, k2)
+}
+
+func TestDeleteInterleavedWithPut(t *testing.T) {
+
+	err := filesystem.DeleteAllTempFiles()
+	assert.NoError(t, err, "failed to clean temp folder")
+
+	// same key set as TestComplexTreeOps - exts, branches and a long value
+	k1, _ := hex.DecodeString("123456")
+	v1 := []byte("zifton")
+
+	k2, _ := hex.DecodeString("112456")
+	v2 := []byte("tantalus")
+
+	k3, _ := hex.DecodeString("112457")
+	v3, err := crypto.GetRandomBytes(100)
+	assert.NoError(t, err, "failed to get random data")
+
+	k4, _ := hex.DecodeString("123457")
+	v4, err := crypto.GetRandomBytes(100)
+	assert.NoError(t, err, "failed to get random data")
+
+	userDb, treeDb := getDbPaths(t)
+	m, err := NewEmptyTree(userDb, treeDb)
+	assert.NoError(t, err, "failed to create new Merkle tree")
+	defer m.CloseDataStores()
+
+	tryPut(t, m, k1, v1)
+	tryPut(t, m, k2, v2)
+	tryPut(t, m, k3, v3)
+	tryPut(t, m, k4, v4)
+
+	// k3 shares the ext prefix 245 with k2 - deleting it must collapse the
+	// branch below the ext back into its sibling's leaf
+	err = m.Delete(k3)
+	assert.NoError(t, err, "failed to delete key")
+
+	r, err := m.ValidateStructure(m.GetRootNode())
+	assert.NoError(t, err, "invalid tree structure")
+	assert.True(t, bytes.Equal(r, m.GetRootHash()), "unexpected root hash")
+
+	validateGet(t, m, k1, v1)
+	validateGet(t, m, k2, v2)
+	validateAbsent(t, m, k3)
+	validateGet(t, m, k4, v4)
+
+	// keep interleaving - re-insert and remove the rest one by one
+	tryPut(t, m, k3, v3)
+	validateGet(t, m, k3, v3)
+
+	for _, k := range [][]byte{k4, k1, k3} {
+		err = m.Delete(k)
+		assert.NoError(t, err, "failed to delete key")
+
+		r, err = m.ValidateStructure(m.GetRootNode())
+		assert.NoError(t, err, "invalid tree structure")
+		assert.True(t, bytes.Equal(r, m.GetRootHash()), "unexpected root hash")
+	}
+
+	validateGet(t, m, k2, v2)
+
+	// deleting the last key restores the empty tree hash
+	err = m.Delete(k2)
+	assert.NoError(t, err, "failed to delete key")
+	assert.True(t, bytes.Equal(EmptyTreeRootHash, m.GetRootHash()), "expected empty tree root hash")
+}
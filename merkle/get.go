@@ -107,8 +107,10 @@ func (mt *merkleTreeImp) findValue(root Node, k string, pos int, s *stack) ([]by
 
 	case pb.NodeType_leaf:
 
+		// a leaf must consume the whole remainder of k - a partial match
+		// means the key is not in the tree
 		p := root.getLeafNode().getPath()
-		if len(k)-pos < len(p) || p != k[pos:pos+len(p)] {
+		if p != k[pos:] {
 			return nil, nil
 		}
 
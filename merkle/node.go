@@ -191,7 +191,7 @@ func (n *nodeImp) removeBranchChild(idx string) error {
 		delete(n.children, hex.EncodeToString(p))
 	}
 
-	return nil
+	return n.getBranchNode().removeChild(idx)
 }
 
 func (n *nodeImp) getChild(pointer []byte) Node {
@@ -29,6 +29,14 @@ func (mt *merkleTreeImp) GetRootNode() Node {
 
 func (mt *merkleTreeImp) removeNodeFromStore(node Node) error {
 	nodeKey := node.getNodeHash()
+
+	if mt.batch != nil {
+		// deletion joins the pending batch
+		mt.batch.Delete(nodeKey)
+		delete(mt.treeData.pending, string(nodeKey))
+		return nil
+	}
+
 	err := mt.treeData.Delete(nodeKey, nil)
 	if err != nil {
 		log.Error("Failed to delete node from db", err)
@@ -48,6 +56,13 @@ func (mt *merkleTreeImp) persistNode(node Node) error {
 		return err
 	}
 
+	if mt.batch != nil {
+		// the write joins the pending batch and stays readable for traversal
+		mt.batch.Put(nodeKey, nodeData)
+		mt.treeData.pending[string(nodeKey)] = nodeData
+		return nil
+	}
+
 	err = mt.treeData.Put(nodeKey, nodeData, nil)
 	if err != nil {
 		log.Error("Failed to write tree data to db", err)
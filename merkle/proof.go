@@ -0,0 +1,152 @@
+package merkle
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/merkle/pb"
+)
+
+// Proof is an ordered list of serialized tree nodes, root first, down to the
+// node that decides a key's presence or absence. It reuses the tree's binary
+// node encoding, so a verifier only needs the node unmarshaling code and the
+// tree's root hash - not the backing db.
+type Proof struct {
+	Nodes [][]byte
+}
+
+// GenerateProof returns a proof for key - the serialized nodes on the path
+// from the root down to the node holding the key's value, or down to the node
+// where the key's path diverges from the tree (a proof of absence).
+func (mt *merkleTreeImp) GenerateProof(key []byte) (*Proof, error) {
+
+	if len(key) == 0 {
+		return nil, ErrorInvalidUserData
+	}
+
+	proof := &Proof{Nodes: [][]byte{}}
+
+	if mt.root == nil {
+		// an empty proof proves absence in an empty tree
+		return proof, nil
+	}
+
+	// the stack contains the path down to the key's node, or to the node
+	// where the key's path leaves the tree
+	_, s, err := mt.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := s.toSlice()
+
+	// the deepest node is first on the stack - emit the root first
+	for i := len(nodes) - 1; i >= 0; i-- {
+		data, err := nodes[i].marshal()
+		if err != nil {
+			return nil, err
+		}
+		proof.Nodes = append(proof.Nodes, data)
+	}
+
+	return proof, nil
+}
+
+// VerifyProof replays proof against rootHash and reports whether it proves
+// that key maps to value in the tree committed to by rootHash. Every node
+// must hash to the pointer its parent commits to. A nil value verifies a
+// proof of absence - a path terminating at a branch with no entry for the
+// key's next nibble, or diverging at an extension or leaf.
+func VerifyProof(rootHash []byte, key, value []byte, proof *Proof) (bool, error) {
+
+	if len(key) == 0 {
+		return false, ErrorInvalidUserData
+	}
+
+	if proof == nil {
+		return false, errors.New("expected a non-nil proof")
+	}
+
+	if len(proof.Nodes) == 0 {
+		// only the empty tree is proven by an empty proof - and it holds no keys
+		return bytes.Equal(rootHash, EmptyTreeRootHash) && len(value) == 0, nil
+	}
+
+	k := hex.EncodeToString(key)
+	expected := rootHash
+	pos := 0
+
+	for i, data := range proof.Nodes {
+
+		// the node must hash to the pointer committed to by its parent
+		if !bytes.Equal(crypto.Sha256(data), expected) {
+			return false, nil
+		}
+
+		n, err := newNodeFromData(data)
+		if err != nil {
+			return false, err
+		}
+
+		last := i == len(proof.Nodes)-1
+
+		switch n.getNodeType() {
+
+		case pb.NodeType_branch:
+
+			if pos == len(k) {
+				// the key's path terminates at this branch
+				return last && matchesUserValue(n.getBranchNode().getValue(), value), nil
+			}
+
+			p := n.getBranchNode().getPointer(string(k[pos]))
+			if len(p) == 0 {
+				// no entry for the key's next nibble - proof of absence
+				return last && len(value) == 0, nil
+			}
+
+			expected = p
+			pos++
+
+		case pb.NodeType_extension:
+
+			path := n.getExtNode().getPath()
+			if len(k)-pos < len(path) || path != k[pos:pos+len(path)] {
+				// the key diverges from the extension - proof of absence
+				return last && len(value) == 0, nil
+			}
+
+			pos += len(path)
+			expected = n.getExtNode().getValue()
+
+		case pb.NodeType_leaf:
+
+			path := n.getLeafNode().getPath()
+			if path != k[pos:] {
+				// the key diverges from the leaf - proof of absence
+				return last && len(value) == 0, nil
+			}
+
+			return last && len(value) > 0 && matchesUserValue(n.getLeafNode().getValue(), value), nil
+
+		default:
+			return false, errors.New("unexpected node type")
+		}
+	}
+
+	// the path did not terminate - the proof is truncated
+	return false, nil
+}
+
+// Compares a value stored in the tree with a user value.
+// The tree stores short values inline and long values by their hash.
+func matchesUserValue(stored, v []byte) bool {
+	if len(v) == 0 {
+		return len(stored) == 0
+	}
+	if len(v) > 32 {
+		return bytes.Equal(stored, crypto.Sha256(v))
+	}
+	return bytes.Equal(stored, v)
+}
@@ -0,0 +1,135 @@
+package merkle
+
+import (
+	"encoding/hex"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/filesystem"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// builds a tree shaped like the one in TestComplexTreeOps
+func buildProofTestTree(t *testing.T) (Tree, [][]byte, [][]byte) {
+	t.Helper()
+
+	err := filesystem.DeleteAllTempFiles()
+	assert.NoError(t, err, "failed to clean temp folder")
+
+	k1, _ := hex.DecodeString("123456")
+	v1 := []byte("zifton")
+
+	k2, _ := hex.DecodeString("112456")
+	v2 := []byte("tantalus")
+
+	k3, _ := hex.DecodeString("112457")
+	v3, err := crypto.GetRandomBytes(100)
+	assert.NoError(t, err, "failed to get random data")
+
+	k4, _ := hex.DecodeString("123457")
+	v4, err := crypto.GetRandomBytes(100)
+	assert.NoError(t, err, "failed to get random data")
+
+	userDb, treeDb := getDbPaths(t)
+	m, err := NewEmptyTree(userDb, treeDb)
+	assert.NoError(t, err, "failed to create new Merkle tree")
+
+	keys := [][]byte{k1, k2, k3, k4}
+	values := [][]byte{v1, v2, v3, v4}
+	for i := range keys {
+		tryPut(t, m, keys[i], values[i])
+	}
+
+	return m, keys, values
+}
+
+func TestProofRoundTrip(t *testing.T) {
+
+	m, keys, values := buildProofTestTree(t)
+	defer m.CloseDataStores()
+
+	root := m.GetRootHash()
+
+	for i := range keys {
+		proof, err := m.GenerateProof(keys[i])
+		assert.NoError(t, err, "failed to generate proof")
+		assert.True(t, len(proof.Nodes) > 0, "expected a non-empty proof")
+
+		ok, err := VerifyProof(root, keys[i], values[i], proof)
+		assert.NoError(t, err, "failed to verify proof")
+		assert.True(t, ok, "expected the proof to verify")
+
+		// the proof must not verify a different value
+		ok, err = VerifyProof(root, keys[i], []byte("wrong-value"), proof)
+		assert.NoError(t, err, "failed to verify proof")
+		assert.False(t, ok, "expected the proof to reject a wrong value")
+
+		// nor verify against a different root
+		ok, err = VerifyProof(EmptyTreeRootHash, keys[i], values[i], proof)
+		assert.NoError(t, err, "failed to verify proof")
+		assert.False(t, ok, "expected the proof to reject a wrong root")
+	}
+}
+
+func TestProofOfAbsence(t *testing.T) {
+
+	m, keys, _ := buildProofTestTree(t)
+	defer m.CloseDataStores()
+
+	root := m.GetRootHash()
+
+	// keys diverging at a branch nibble, inside an extension and at the root
+	missing := []string{"112458", "119456", "123458", "ffffff"}
+
+	for _, ks := range missing {
+		k, err := hex.DecodeString(ks)
+		assert.NoError(t, err, "invalid hex str")
+
+		proof, err := m.GenerateProof(k)
+		assert.NoError(t, err, "failed to generate proof")
+
+		ok, err := VerifyProof(root, k, nil, proof)
+		assert.NoError(t, err, "failed to verify proof")
+		assert.True(t, ok, "expected a valid proof of absence for %s", ks)
+
+		// an absence proof must not back a presence claim
+		ok, err = VerifyProof(root, k, []byte("phantom"), proof)
+		assert.NoError(t, err, "failed to verify proof")
+		assert.False(t, ok, "expected the absence proof to reject a value claim")
+	}
+
+	// a present key can't be proven absent
+	proof, err := m.GenerateProof(keys[0])
+	assert.NoError(t, err, "failed to generate proof")
+	ok, err := VerifyProof(root, keys[0], nil, proof)
+	assert.NoError(t, err, "failed to verify proof")
+	assert.False(t, ok, "expected absence claim for a present key to fail")
+}
+
+func TestTamperedProof(t *testing.T) {
+
+	m, keys, values := buildProofTestTree(t)
+	defer m.CloseDataStores()
+
+	root := m.GetRootHash()
+
+	proof, err := m.GenerateProof(keys[0])
+	assert.NoError(t, err, "failed to generate proof")
+	assert.True(t, len(proof.Nodes) > 1, "expected a multi-node proof")
+
+	// flip a byte in a mid-path node
+	tampered := &Proof{Nodes: make([][]byte, len(proof.Nodes))}
+	for i, n := range proof.Nodes {
+		tampered.Nodes[i] = append([]byte{}, n...)
+	}
+	mid := len(tampered.Nodes) / 2
+	tampered.Nodes[mid][0] ^= 0xff
+
+	ok, _ := VerifyProof(root, keys[0], values[0], tampered)
+	assert.False(t, ok, "expected a tampered proof to fail verification")
+
+	// a truncated proof must fail too
+	truncated := &Proof{Nodes: proof.Nodes[:len(proof.Nodes)-1]}
+	ok, err = VerifyProof(root, keys[0], values[0], truncated)
+	assert.NoError(t, err, "failed to verify proof")
+	assert.False(t, ok, "expected a truncated proof to fail verification")
+}
@@ -49,6 +49,19 @@ func (mt *merkleTreeImp) Put(k, v []byte) error {
 	// todo - optimize this to avoid iteration over path
 	pos := mt.getPathLength(stack)
 
+	// getPathLength assumes every non-leaf node on the stack matched part of k.
+	// The node the lookup stopped at matched nothing when it isn't a leaf - a
+	// branch with no child for the next nibble or an ext node that diverged
+	// from the key - so its length must not be counted
+	if stack.len() > 0 {
+		deepest := stack.toSlice()[0]
+		if deepest.isBranch() {
+			pos--
+		} else if deepest.isExt() {
+			pos -= len(deepest.getShortNode().getPath())
+		}
+	}
+
 	// Use the branch to insert or update the value generated by the Get() op above
 	err = mt.upsert(pos, hexKey, userValue, stack)
 	if err != nil {
@@ -160,13 +173,12 @@ func (mt *merkleTreeImp) upsert(pos int, k string, v []byte, s *stack) error {
 		leafPath := lastNode.getShortNode().getPath()
 		cp := commonPrefix(leafPath, k[l:])
 
-		if len(cp) == len(leafPath) && pos == len(k) {
+		if len(cp) == len(leafPath) && l+len(leafPath) == len(k) {
 			// update leaf value to this value and return
 			mt.removeNodeFromStore(lastNode)
 			lastNode.setExtChild(v)
 			s.push(lastNode)
-			mt.update(k, s)
-			return nil
+			return mt.update(k, s)
 		}
 	}
 
@@ -185,12 +197,11 @@ func (mt *merkleTreeImp) upsert(pos int, k string, v []byte, s *stack) error {
 
 		} else {
 			// whole path matched - value should be stored at branch
-			lastNode.getBranchNode().setValue(v)
 			mt.removeNodeFromStore(lastNode)
+			lastNode.getBranchNode().setValue(v)
 		}
 
-		mt.update(k, s)
-		return nil
+		return mt.update(k, s)
 	}
 
 	// case 4 - matched a leaf or ext node
@@ -236,9 +247,10 @@ func (mt *merkleTreeImp) upsert(pos int, k string, v []byte, s *stack) error {
 				return err
 			}
 		} else {
-			// remove ext and set as branch's value
+			// the ext node shrunk to a single nibble - its child hangs off
+			// the new branch directly
 			mt.removeNodeFromStore(lastNode)
-			newBranch.getBranchNode().setValue(lastNode.getShortNode().getValue())
+			newBranch.getBranchNode().addChild(branchChildKey, lastNode.getShortNode().getValue())
 		}
 	} else {
 		// removed ext or leaf node
@@ -258,9 +270,10 @@ func (mt *merkleTreeImp) upsert(pos int, k string, v []byte, s *stack) error {
 			return err
 		}
 		s.push(newLeaf)
+	} else {
+		// the key terminates at the new branch - it carries the new value
+		newBranch.getBranchNode().setValue(v)
 	}
 
-	mt.update(k, s)
-
-	return nil
+	return mt.update(k, s)
 }
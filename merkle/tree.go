@@ -5,6 +5,7 @@ package merkle
 import (
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
 )
 
 // Tree is a general-purpose merkle tree used to store user (k,v) data.
@@ -13,11 +14,14 @@ import (
 // Tree space pointers and paths are internal only.
 type Tree interface {
 	Put(k, v []byte) error                // store user key, value
+	PutBatch(entries []KV) error          // store many user (k,v) with one tree db write
 	Delete(k []byte) error                // delete user value indexed by key
 	Get(k []byte) ([]byte, *stack, error) // get user value indexed by key
 	GetRootHash() []byte                  // get tree root hash
 	GetRootNode() Node                    // get root node
 
+	GenerateProof(k []byte) (*Proof, error) // proof of k's presence or absence
+
 	CloseDataStores() error // call when done w the tree
 
 	Print() string
@@ -31,6 +35,21 @@ type userDb struct {
 
 type treeDb struct {
 	*leveldb.DB
+
+	// nodes written into a still-unflushed batch, readable by tree traversal
+	// before the batch is committed. nil outside of PutBatch.
+	pending map[string][]byte
+}
+
+// Get returns a node's data by its hash, consulting the pending batch writes
+// before the backing store.
+func (db *treeDb) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	if db.pending != nil {
+		if v, ok := db.pending[string(key)]; ok {
+			return v, nil
+		}
+	}
+	return db.DB.Get(key, ro)
 }
 
 // internal implementation
@@ -38,6 +57,10 @@ type merkleTreeImp struct {
 	userData *userDb
 	treeData *treeDb
 	root     Node
+
+	// tree db writes accumulate here while a PutBatch is applying. nil
+	// otherwise - writes then go straight to the db.
+	batch *leveldb.Batch
 }
 
 // NewEmptyTree creates a new empty Merkle tree with the provided paths to user and tree data db files.
@@ -59,7 +82,7 @@ func NewEmptyTree(userDataFileName string, treeDataFileName string) (Tree, error
 
 	mt := &merkleTreeImp{
 		userData: &userDb{userData},
-		treeData: &treeDb{treeData},
+		treeData: &treeDb{DB: treeData},
 	}
 
 	return mt, nil
@@ -83,7 +106,7 @@ func NewTreeFromDb(rootHash []byte, userDataFileName string, treeDataFileName st
 
 	mt := &merkleTreeImp{
 		userData: &userDb{userData},
-		treeData: &treeDb{treeData},
+		treeData: &treeDb{DB: treeData},
 	}
 
 	// load the tree from the db
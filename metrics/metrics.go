@@ -0,0 +1,81 @@
+// Package metrics provides lightweight counter, gauge and histogram metrics
+// for node components, and renders them in the Prometheus text exposition
+// format. Components register metrics through the package-level constructors;
+// unless a node installs a Registry with Enable, those return no-op
+// implementations, so library users pay nothing for instrumentation they
+// don't scrape.
+package metrics
+
+// Counter is a monotonically increasing value, e.g. messages relayed.
+type Counter interface {
+	Inc()
+	Add(delta float64)
+}
+
+// Gauge is a value that can go up and down, e.g. current peer count.
+type Gauge interface {
+	Set(val float64)
+	Inc()
+	Dec()
+}
+
+// Histogram samples observations into cumulative buckets, e.g. request
+// latencies.
+type Histogram interface {
+	Observe(val float64)
+}
+
+// Labels attach bounded-cardinality dimensions to a metric, e.g. the protocol
+// name. Callers must not use unbounded values (message ids, addresses of
+// transient peers) as label values.
+type Labels map[string]string
+
+// global is the installed registry; nil keeps all package-level constructors
+// returning no-ops.
+var global *Registry
+
+// Enable installs a registry behind the package-level constructors and
+// returns it, so the node can expose it for scraping. Metrics created before
+// Enable stay no-ops; components register theirs at construction time after
+// the node enables metrics.
+func Enable() *Registry {
+	global = NewRegistry()
+	return global
+}
+
+// NewCounter registers a counter on the installed registry, or returns a
+// no-op when metrics are disabled.
+func NewCounter(name, help string, labels Labels) Counter {
+	if global == nil {
+		return noop{}
+	}
+	return global.NewCounter(name, help, labels)
+}
+
+// NewGauge registers a gauge on the installed registry, or returns a no-op
+// when metrics are disabled.
+func NewGauge(name, help string, labels Labels) Gauge {
+	if global == nil {
+		return noop{}
+	}
+	return global.NewGauge(name, help, labels)
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds on
+// the installed registry, or returns a no-op when metrics are disabled. Nil
+// buckets get DefaultBuckets.
+func NewHistogram(name, help string, buckets []float64, labels Labels) Histogram {
+	if global == nil {
+		return noop{}
+	}
+	return global.NewHistogram(name, help, buckets, labels)
+}
+
+// noop implements all metric interfaces with no state.
+type noop struct{}
+
+func (noop) Inc()            {}
+func (noop) Dec()            {}
+func (noop) Add(float64)     {}
+func (noop) Set(float64)     {}
+func (noop) Observe(float64) {}
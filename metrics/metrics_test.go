@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scrape fetches and parses an exposition into metric lines keyed by the full
+// "name{labels}" series id, failing the test on any line that does not follow
+// the text format.
+func scrape(t *testing.T, handler http.Handler) map[string]string {
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	out := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimRight(string(body), "\n"), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			assert.True(t, len(strings.SplitN(line, " ", 4)) == 4, "malformed comment line: %q", line)
+			continue
+		}
+		idx := strings.LastIndex(line, " ")
+		assert.True(t, idx > 0, "malformed sample line: %q", line)
+		id, val := line[:idx], line[idx+1:]
+		_, err := strconv.ParseFloat(val, 64)
+		assert.NoError(t, err, "sample value must be a float: %q", line)
+		out[id] = val
+	}
+	return out
+}
+
+func TestCounterAndGaugeExposition(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("gossip_messages_relayed_total", "Messages relayed by the gossip protocol.", Labels{"protocol": "gossip/1.0"})
+	g := r.NewGauge("p2p_peers", "Current connected peers.", nil)
+
+	c.Inc()
+	c.Add(2)
+	g.Set(7)
+	g.Inc()
+	g.Dec()
+
+	samples := scrape(t, r.Handler())
+	assert.Equal(t, "3", samples[`gossip_messages_relayed_total{protocol="gossip/1.0"}`])
+	assert.Equal(t, "7", samples["p2p_peers"])
+
+	exposed := r.Expose()
+	assert.Contains(t, exposed, "# TYPE gossip_messages_relayed_total counter")
+	assert.Contains(t, exposed, "# TYPE p2p_peers gauge")
+}
+
+func TestHistogramBuckets(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("dht_lookup_seconds", "DHT lookup latency.", []float64{0.1, 1, 10}, Labels{"protocol": "find-node"})
+
+	for _, v := range []float64{0.05, 0.5, 0.5, 5, 50} {
+		h.Observe(v)
+	}
+
+	samples := scrape(t, r.Handler())
+	labels := `protocol="find-node"`
+	assert.Equal(t, "1", samples[`dht_lookup_seconds_bucket{`+labels+`,le="0.1"}`])
+	assert.Equal(t, "3", samples[`dht_lookup_seconds_bucket{`+labels+`,le="1"}`], "buckets are cumulative")
+	assert.Equal(t, "4", samples[`dht_lookup_seconds_bucket{`+labels+`,le="10"}`])
+	assert.Equal(t, "5", samples[`dht_lookup_seconds_bucket{`+labels+`,le="+Inf"}`], "+Inf bucket equals the total count")
+	assert.Equal(t, "5", samples[`dht_lookup_seconds_count{`+labels+`}`])
+	assert.Equal(t, "56.05", samples[`dht_lookup_seconds_sum{`+labels+`}`])
+	assert.Contains(t, r.Expose(), "# TYPE dht_lookup_seconds histogram")
+}
+
+func TestDisabledDefaultIsNoop(t *testing.T) {
+	// without Enable the package constructors hand out no-ops
+	c := NewCounter("unused_total", "", nil)
+	g := NewGauge("unused", "", nil)
+	h := NewHistogram("unused_seconds", "", nil, nil)
+	assert.NotPanics(t, func() {
+		c.Inc()
+		c.Add(3)
+		g.Set(1)
+		g.Dec()
+		h.Observe(0.2)
+	})
+}
+
+func TestSeriesCapDropsToNoop(t *testing.T) {
+	r := NewRegistry()
+	for i := 0; i < MaxSeries; i++ {
+		r.NewCounter(fmt.Sprintf("c%d_total", i), "", nil)
+	}
+	over := r.NewCounter("overflow_total", "", nil)
+	assert.NotPanics(t, func() { over.Inc() })
+	assert.NotContains(t, r.Expose(), "overflow_total", "series past the cap are dropped, not half-registered")
+}
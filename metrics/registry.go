@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets covers latencies from a millisecond to ten seconds, which
+// fits the p2p request paths these metrics were built for.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+// MaxSeries bounds how many label combinations one registry holds. Past the
+// bound new series are silently dropped to no-ops, so a bug interpolating an
+// unbounded value into a label can't eat the node's memory.
+const MaxSeries = 1024
+
+// Registry holds registered metrics and renders them for scraping.
+type Registry struct {
+	mu     sync.Mutex
+	series []*series
+}
+
+// series is one named metric with a fixed label set.
+type series struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	kind    string // "counter", "gauge" or "histogram"
+	labels  string // pre-rendered {k="v",...} or empty
+	value   float64
+	buckets []float64 // histogram upper bounds, ascending
+	counts  []uint64  // histogram per-bucket cumulative counts
+	sum     float64
+	count   uint64
+}
+
+// NewRegistry creates an empty registry. Most callers go through Enable and
+// the package-level constructors instead; a private registry is for tests and
+// embedded tools.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter registers a counter.
+func (r *Registry) NewCounter(name, help string, labels Labels) Counter {
+	return r.register(name, help, "counter", labels, nil)
+}
+
+// NewGauge registers a gauge.
+func (r *Registry) NewGauge(name, help string, labels Labels) Gauge {
+	return r.register(name, help, "gauge", labels, nil)
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, or
+// DefaultBuckets when nil.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels Labels) Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return r.register(name, help, "histogram", labels, sorted)
+}
+
+// register adds a series, or returns a no-op once the registry is full.
+func (r *Registry) register(name, help, kind string, labels Labels, buckets []float64) *series {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.series) >= MaxSeries {
+		return nil // nil *series methods are no-ops
+	}
+	s := &series{name: name, help: help, kind: kind, labels: renderLabels(labels)}
+	if kind == "histogram" {
+		s.buckets = buckets
+		s.counts = make([]uint64, len(buckets))
+	}
+	r.series = append(r.series, s)
+	return s
+}
+
+// renderLabels renders a sorted, escaped {k="v",...} block.
+func renderLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%q", k, labels[k]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Inc adds one to a counter or gauge.
+func (s *series) Inc() { s.Add(1) }
+
+// Dec subtracts one from a gauge.
+func (s *series) Dec() { s.Add(-1) }
+
+// Add adds delta to a counter or gauge.
+func (s *series) Add(delta float64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.value += delta
+	s.mu.Unlock()
+}
+
+// Set sets a gauge.
+func (s *series) Set(val float64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.value = val
+	s.mu.Unlock()
+}
+
+// Observe records a histogram observation.
+func (s *series) Observe(val float64) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	for i, le := range s.buckets {
+		if val <= le {
+			s.counts[i]++
+		}
+	}
+	s.sum += val
+	s.count++
+	s.mu.Unlock()
+}
+
+// Expose renders all metrics in the text exposition format, series sorted by
+// name so scrapes are stable.
+func (r *Registry) Expose() string {
+	r.mu.Lock()
+	all := append([]*series(nil), r.series...)
+	r.mu.Unlock()
+	sort.SliceStable(all, func(i, j int) bool { return all[i].name < all[j].name })
+
+	var b strings.Builder
+	lastName := ""
+	for _, s := range all {
+		s.mu.Lock()
+		if s.name != lastName {
+			fmt.Fprintf(&b, "# HELP %s %s\n", s.name, s.help)
+			fmt.Fprintf(&b, "# TYPE %s %s\n", s.name, s.kind)
+			lastName = s.name
+		}
+		switch s.kind {
+		case "histogram":
+			for i, le := range s.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", s.name, mergeLabel(s.labels, "le", formatFloat(le)), s.counts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", s.name, mergeLabel(s.labels, "le", "+Inf"), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", s.name, s.labels, formatFloat(s.sum))
+			fmt.Fprintf(&b, "%s_count%s %d\n", s.name, s.labels, s.count)
+		default:
+			fmt.Fprintf(&b, "%s%s %s\n", s.name, s.labels, formatFloat(s.value))
+		}
+		s.mu.Unlock()
+	}
+	return b.String()
+}
+
+// Handler serves the exposition for a Prometheus scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, r.Expose())
+	})
+}
+
+// mergeLabel splices one more label pair into a pre-rendered label block.
+func mergeLabel(block, key, val string) string {
+	pair := fmt.Sprintf("%s=%q", key, val)
+	if block == "" {
+		return "{" + pair + "}"
+	}
+	return strings.TrimSuffix(block, "}") + "," + pair + "}"
+}
+
+// formatFloat renders a value the way Prometheus expects, integers without a
+// decimal point.
+func formatFloat(val float64) string {
+	if val == math.Trunc(val) && math.Abs(val) < 1e15 {
+		return fmt.Sprintf("%d", int64(val))
+	}
+	return fmt.Sprintf("%g", val)
+}
@@ -0,0 +1,146 @@
+package p2p
+
+import (
+	"errors"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/p2p/message"
+	"github.com/spacemeshos/go-spacemesh/p2p/pb"
+)
+
+// Batching bounds for signature verification on the ingress path. A burst is
+// verified as one batch of up to batchVerifyMax signatures fanned out across
+// cores - a lone message waits at most batchVerifyWait before it is verified
+// on its own, which bounds the latency cost when there is no burst to batch.
+const (
+	batchVerifyMax  = 64
+	batchVerifyWait = 2 * time.Millisecond
+)
+
+// errVerifierClosed is returned for requests caught in shutdown.
+var errVerifierClosed = errors.New("signature verifier is shutting down")
+
+// errInvalidSignature is how the verifier reports a failed check.
+var errInvalidSignature = errors.New("message signature verification failed")
+
+type verifyRequest struct {
+	pm  *pb.ProtocolMessage
+	res chan error
+}
+
+// batchVerifier collects author-signature checks from the concurrent ingress
+// queues and verifies them in batches. Each caller blocks for its own result,
+// so per-queue message ordering is untouched - only the verification work of
+// concurrent queues is pooled.
+type batchVerifier struct {
+	requests chan verifyRequest
+	shutdown chan struct{}
+}
+
+func newBatchVerifier(shutdown chan struct{}) *batchVerifier {
+	bv := &batchVerifier{
+		requests: make(chan verifyRequest, batchVerifyMax),
+		shutdown: shutdown,
+	}
+	go bv.collectLoop()
+	return bv
+}
+
+// verify checks that pm was signed by its claimed author, batching the check
+// with whatever else is in flight. It blocks until the verdict is in.
+func (bv *batchVerifier) verify(pm *pb.ProtocolMessage) error {
+	select {
+	case <-bv.shutdown:
+		return errVerifierClosed
+	default:
+	}
+	res := make(chan error, 1)
+	select {
+	case bv.requests <- verifyRequest{pm: pm, res: res}:
+	case <-bv.shutdown:
+		return errVerifierClosed
+	}
+	select {
+	case err := <-res:
+		return err
+	case <-bv.shutdown:
+		return errVerifierClosed
+	}
+}
+
+// collectLoop gathers requests into batches - a batch closes when it is full
+// or when its oldest request has waited batchVerifyWait.
+func (bv *batchVerifier) collectLoop() {
+	for {
+		var batch []verifyRequest
+		select {
+		case req := <-bv.requests:
+			batch = append(batch, req)
+		case <-bv.shutdown:
+			return
+		}
+
+		deadline := time.NewTimer(batchVerifyWait)
+	gather:
+		for len(batch) < batchVerifyMax {
+			select {
+			case req := <-bv.requests:
+				batch = append(batch, req)
+			case <-deadline.C:
+				break gather
+			case <-bv.shutdown:
+				deadline.Stop()
+				return
+			}
+		}
+		deadline.Stop()
+
+		bv.flush(batch)
+	}
+}
+
+// flush verifies a batch and answers every caller. Requests whose triple
+// can't even be extracted fail up front and are left out of the batch.
+func (bv *batchVerifier) flush(batch []verifyRequest) {
+	pubs := make([][]byte, 0, len(batch))
+	msgs := make([][]byte, 0, len(batch))
+	sigs := make([][]byte, 0, len(batch))
+	checked := make([]verifyRequest, 0, len(batch))
+
+	for _, req := range batch {
+		pub, data, sig, err := message.AuthParts(req.pm)
+		if err != nil {
+			req.res <- err
+			continue
+		}
+		pubs = append(pubs, pub)
+		msgs = append(msgs, data)
+		sigs = append(sigs, sig)
+		checked = append(checked, req)
+	}
+
+	allValid, invalid, err := crypto.VerifyBatch(pubs, msgs, sigs)
+	if err != nil {
+		for _, req := range checked {
+			req.res <- err
+		}
+		return
+	}
+
+	if allValid {
+		for _, req := range checked {
+			req.res <- nil
+		}
+		return
+	}
+
+	for i, req := range checked {
+		if len(invalid) > 0 && invalid[0] == i {
+			invalid = invalid[1:]
+			req.res <- errInvalidSignature
+			continue
+		}
+		req.res <- nil
+	}
+}
@@ -0,0 +1,73 @@
+package p2p
+
+import (
+	"encoding/hex"
+	"sync"
+	"testing"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/message"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func signedProtocolMessage(t *testing.T, payload string) *pb.ProtocolMessage {
+	n, _ := node.GenerateTestNode(t)
+	pm := &pb.ProtocolMessage{
+		Metadata: message.NewProtocolMessageMetadata(n.PublicKey(), exampleProtocol, false),
+		Payload:  []byte(payload),
+	}
+	assert.NoError(t, message.SignMessage(n.PrivateKey(), pm))
+	return pm
+}
+
+func TestBatchVerifier(t *testing.T) {
+	shutdown := make(chan struct{})
+	defer close(shutdown)
+	bv := newBatchVerifier(shutdown)
+
+	good := signedProtocolMessage(t, "an honest message")
+	assert.NoError(t, bv.verify(good))
+
+	// a forged signature must fail while honest messages in the same burst pass
+	bad := signedProtocolMessage(t, "a forged message")
+	rawSig, err := hex.DecodeString(bad.Metadata.AuthorSign)
+	assert.NoError(t, err)
+	rawSig[len(rawSig)-1] ^= 1
+	bad.Metadata.AuthorSign = hex.EncodeToString(rawSig)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 9)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == 4 {
+				errs[i] = bv.verify(bad)
+				return
+			}
+			errs[i] = bv.verify(signedProtocolMessage(t, "an honest message"))
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if i == 4 {
+			assert.Equal(t, errInvalidSignature, err, "the forged message must fail")
+			continue
+		}
+		assert.NoError(t, err, "honest message %d must pass", i)
+	}
+
+	// a message without metadata fails up front instead of poisoning a batch
+	assert.Error(t, bv.verify(&pb.ProtocolMessage{Payload: []byte("no metadata")}))
+}
+
+func TestBatchVerifierShutdown(t *testing.T) {
+	shutdown := make(chan struct{})
+	bv := newBatchVerifier(shutdown)
+	close(shutdown)
+
+	err := bv.verify(signedProtocolMessage(t, "late message"))
+	assert.Equal(t, errVerifierClosed, err)
+}
@@ -27,13 +27,87 @@ func duration(duration string) (dur time.Duration) {
 
 // Config defines the configuration options for the Spacemesh peer-to-peer networking layer
 type Config struct {
-	SecurityParam   int           `mapstructure:"security-param"`
-	FastSync        bool          `mapstructure:"fast-sync"`
-	TCPPort         int           `mapstructure:"tcp-port"`
-	NodeID          string        `mapstructure:"node-id"`
-	NewNode         bool          `mapstructure:"new-node"`
-	DialTimeout     time.Duration `mapstructure:"dial-timeout"`
-	ConnKeepAlive   time.Duration `mapstructure:"conn-keepalive"`
+	SecurityParam  int           `mapstructure:"security-param"`
+	FastSync       bool          `mapstructure:"fast-sync"`
+	TCPPort        int           `mapstructure:"tcp-port"`
+	AutoSelectPort bool          `mapstructure:"auto-port"`
+	NodeID         string        `mapstructure:"node-id"`
+	NewNode        bool          `mapstructure:"new-node"`
+	DialTimeout    time.Duration `mapstructure:"dial-timeout"`
+	ConnKeepAlive  time.Duration `mapstructure:"conn-keepalive"`
+
+	// transport-level keepalive - ping an idle connection and drop it when
+	// the pong is late or nothing arrives at all. Zero disables a check.
+	KeepAlivePingInterval time.Duration `mapstructure:"keepalive-ping-interval"`
+	KeepAlivePongTimeout  time.Duration `mapstructure:"keepalive-pong-timeout"`
+	IdleConnTimeout       time.Duration `mapstructure:"idle-conn-timeout"`
+
+	// session rekeying - refresh a session's enc key after this much sent
+	// traffic or time, whichever comes first. Zero disables a trigger.
+	SessionRekeyBytes int64         `mapstructure:"session-rekey-bytes"`
+	SessionRekeyTime  time.Duration `mapstructure:"session-rekey-time"`
+
+	// cap on pooled connections - beyond it the least recently used
+	// connections are closed. Non-positive means unbounded.
+	MaxOpenConnections int `mapstructure:"max-open-connections"`
+
+	// largest wire frame accepted or sent on a connection - must stay above
+	// the protocol-level payload limits so legitimate messages still fit.
+	// Non-positive disables the check.
+	MaxFrameSize int `mapstructure:"max-frame-size"`
+
+	// incoming connection limits - concurrent connections per source IP and
+	// in total, new connections per IP within the rate window, and concurrent
+	// handshakes in progress. Non-positive values disable a limit. The per-IP
+	// limits are off by default since a NATed site looks like a single IP.
+	MaxIncomingConns     int           `mapstructure:"max-incoming-conns"`
+	MaxConnsPerIP        int           `mapstructure:"max-conns-per-ip"`
+	IncomingIPRateLimit  int           `mapstructure:"incoming-ip-rate-limit"`
+	IncomingIPRateWindow time.Duration `mapstructure:"incoming-ip-rate-window"`
+	MaxPendingHandshakes int           `mapstructure:"max-pending-handshakes"`
+
+	// weighted fair sharing of a connection between protocols - tagged sends
+	// are drained by weight so bulk transfers can't starve control traffic.
+	// An empty weights map disables the scheduler. Depth bounds each
+	// protocol's queue; a full queue fails fast.
+	ProtocolQoSWeights map[string]int `mapstructure:"protocol-qos-weights"`
+	ProtocolQueueDepth int            `mapstructure:"protocol-queue-depth"`
+
+	// replay protection window - a message whose signed timestamp is more
+	// than msg-max-age behind or msg-max-skew ahead of the local clock is
+	// rejected, with msg-skew-grace of tolerance on both edges so two
+	// slightly-off clocks keep talking. Identical messages are deduped
+	// within the window.
+	MsgMaxAge    time.Duration `mapstructure:"msg-max-age"`
+	MsgMaxSkew   time.Duration `mapstructure:"msg-max-skew"`
+	MsgSkewGrace time.Duration `mapstructure:"msg-skew-grace"`
+
+	// offer whole-connection frame compression in the handshake capabilities
+	// exchange - used only when both sides enable it
+	FrameCompression bool `mapstructure:"frame-compression"`
+
+	// capabilities a peer must advertise in its handshake - a peer missing
+	// one still connects, but the mismatch is surfaced as a specific log
+	// event instead of failing silently later
+	RequiredCapabilities []string `mapstructure:"required-capabilities"`
+
+	// how long a connection may stay without an established session before
+	// the half-open sweep reclaims it. Generous by default to accommodate
+	// slow links. Non-positive disables the sweep.
+	HandshakeTimeout time.Duration `mapstructure:"handshake-timeout"`
+
+	// extra addresses to listen on besides the node's own address, e.g. an
+	// IPv6 or management-network listener. When AllowPartialBind is set a
+	// failure to bind one of them is logged as a warning instead of failing
+	// node startup.
+	ListenAddresses  []string `mapstructure:"listen-addresses"`
+	AllowPartialBind bool     `mapstructure:"allow-partial-bind"`
+
+	// emit node id strings with a checksum appended, so a transcription typo
+	// is caught instead of resolving to a different valid-looking key. The
+	// parser accepts both forms regardless, this only gates what we emit.
+	ChecksummedIDs bool `mapstructure:"checksummed-ids"`
+
 	NetworkID       int8          `mapstructure:"network-id"`
 	ResponseTimeout time.Duration `mapstructure:"response-timeout"`
 	SwarmConfig     SwarmConfig   `mapstructure:"swarm"`
@@ -47,6 +121,109 @@ type SwarmConfig struct {
 	RoutingTableAlpha      int      `mapstructure:"alpha"`
 	RandomConnections      int      `mapstructure:"randcon"`
 	BootstrapNodes         []string `mapstructure:"bootnodes"`
+
+	// bootstrap and refresh timing - parsed from duration strings like "30s"
+	// or "2m" when loaded from a config file. Zero means the default; use the
+	// OrDefault accessors so an unset field never becomes a zero wait.
+	BootstrapTimeout time.Duration `mapstructure:"bootstrap-timeout"`
+	LookupInterval   time.Duration `mapstructure:"lookup-interval"`
+	RefreshInterval  time.Duration `mapstructure:"refresh-interval"`
+
+	// how often the dht refreshes one random routing table bucket with a
+	// lookup, so sparsely used buckets stay populated
+	BucketRefreshInterval time.Duration `mapstructure:"bucket-refresh-interval"`
+
+	// retention of the gossip dedupe cache - an entry older than the ttl no
+	// longer blocks a re-broadcast, and the cache never holds more than the
+	// max entries
+	OldMessageCacheTTL  time.Duration `mapstructure:"old-message-ttl"`
+	OldMessageCacheSize int           `mapstructure:"old-message-cache-size"`
+
+	// how long a pinged peer has to pong before it is considered dead - used
+	// when a full routing table bucket challenges its least-recently seen peer
+	PingTimeout time.Duration `mapstructure:"ping-timeout"`
+
+	// when set, the routing table is persisted to this file and reloaded on
+	// the next start, sparing a long-running node the cold-start bootstrap
+	RoutingTableFile string `mapstructure:"routing-table-file"`
+}
+
+// default timing for the swarm section, applied when a duration field is unset
+const (
+	defaultBootstrapTimeout = 5 * time.Minute
+	defaultLookupInterval   = 3 * time.Second
+	defaultRefreshInterval  = 5 * time.Minute
+
+	defaultBucketRefreshInterval = 60 * time.Minute
+
+	defaultOldMessageCacheTTL  = 10 * time.Minute
+	defaultOldMessageCacheSize = 100000
+
+	defaultPingTimeout = 5 * time.Second
+)
+
+// BootstrapTimeoutOrDefault returns the maximum time the bootstrap process
+// may take, falling back to the default when the field was left unset.
+func (sc SwarmConfig) BootstrapTimeoutOrDefault() time.Duration {
+	if sc.BootstrapTimeout > 0 {
+		return sc.BootstrapTimeout
+	}
+	return defaultBootstrapTimeout
+}
+
+// LookupIntervalOrDefault returns the wait between kad lookup retries during
+// bootstrap, falling back to the default when the field was left unset.
+func (sc SwarmConfig) LookupIntervalOrDefault() time.Duration {
+	if sc.LookupInterval > 0 {
+		return sc.LookupInterval
+	}
+	return defaultLookupInterval
+}
+
+// RefreshIntervalOrDefault returns the wait between routing table refreshes,
+// falling back to the default when the field was left unset.
+func (sc SwarmConfig) RefreshIntervalOrDefault() time.Duration {
+	if sc.RefreshInterval > 0 {
+		return sc.RefreshInterval
+	}
+	return defaultRefreshInterval
+}
+
+// BucketRefreshIntervalOrDefault returns the wait between random bucket
+// refreshes, falling back to the default when the field was left unset.
+func (sc SwarmConfig) BucketRefreshIntervalOrDefault() time.Duration {
+	if sc.BucketRefreshInterval > 0 {
+		return sc.BucketRefreshInterval
+	}
+	return defaultBucketRefreshInterval
+}
+
+// OldMessageCacheTTLOrDefault returns how long a broadcast message hash
+// blocks re-broadcasts, falling back to the default when the field was left
+// unset.
+func (sc SwarmConfig) OldMessageCacheTTLOrDefault() time.Duration {
+	if sc.OldMessageCacheTTL > 0 {
+		return sc.OldMessageCacheTTL
+	}
+	return defaultOldMessageCacheTTL
+}
+
+// OldMessageCacheSizeOrDefault returns the dedupe cache's entry cap, falling
+// back to the default when the field was left unset.
+func (sc SwarmConfig) OldMessageCacheSizeOrDefault() int {
+	if sc.OldMessageCacheSize > 0 {
+		return sc.OldMessageCacheSize
+	}
+	return defaultOldMessageCacheSize
+}
+
+// PingTimeoutOrDefault returns how long to wait for a pong, falling back to
+// the default when the field was left unset.
+func (sc SwarmConfig) PingTimeoutOrDefault() time.Duration {
+	if sc.PingTimeout > 0 {
+		return sc.PingTimeout
+	}
+	return defaultPingTimeout
 }
 
 // TimeConfig specifies the timesync params for ntp.
@@ -76,16 +253,62 @@ func DefaultConfig() Config {
 		RandomConnections:      5,
 		BootstrapNodes:         []string{ // these should be the spacemesh foundation bootstrap nodes
 		},
+		BootstrapTimeout: defaultBootstrapTimeout,
+		LookupInterval:   defaultLookupInterval,
+		RefreshInterval:  defaultRefreshInterval,
+
+		BucketRefreshInterval: defaultBucketRefreshInterval,
+
+		OldMessageCacheTTL:  defaultOldMessageCacheTTL,
+		OldMessageCacheSize: defaultOldMessageCacheSize,
+
+		PingTimeout: defaultPingTimeout,
 	}
 
 	return Config{
-		SecurityParam:   20,
-		FastSync:        true,
-		TCPPort:         7513,
-		NodeID:          "",
-		NewNode:         false,
-		DialTimeout:     duration("1m"),
-		ConnKeepAlive:   duration("48h"),
+		SecurityParam:  20,
+		FastSync:       true,
+		TCPPort:        7513,
+		AutoSelectPort: false,
+		NodeID:         "",
+		NewNode:        false,
+		DialTimeout:    duration("1m"),
+		ConnKeepAlive:  duration("48h"),
+
+		KeepAlivePingInterval: duration("4m"),
+		KeepAlivePongTimeout:  duration("30s"),
+		IdleConnTimeout:       duration("2h"),
+
+		SessionRekeyBytes: int64(1) << 30,
+		SessionRekeyTime:  duration("30m"),
+
+		MaxOpenConnections: 1024,
+		MaxFrameSize:       16 << 20, // 16MB, matches delimited.DefaultMaxFrameSize
+
+		MaxIncomingConns:     512,
+		MaxConnsPerIP:        0,
+		IncomingIPRateLimit:  0,
+		IncomingIPRateWindow: duration("10s"),
+		MaxPendingHandshakes: 64,
+
+		ProtocolQoSWeights: nil,
+		ProtocolQueueDepth: 256,
+
+		MsgMaxAge:    duration("10m"),
+		MsgMaxSkew:   duration("5m"),
+		MsgSkewGrace: duration("1m"),
+
+		FrameCompression: false,
+
+		RequiredCapabilities: nil,
+
+		HandshakeTimeout: duration("1m"),
+
+		ListenAddresses:  nil,
+		AllowPartialBind: false,
+
+		ChecksummedIDs: false,
+
 		NetworkID:       TestNet,
 		ResponseTimeout: duration("15s"),
 		SwarmConfig:     SwarmConfigValues,
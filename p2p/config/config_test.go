@@ -0,0 +1,52 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func unmarshalTOML(t *testing.T, snippet string) (Config, error) {
+	vip := viper.New()
+	vip.SetConfigType("toml")
+	assert.NoError(t, vip.ReadConfig(bytes.NewBufferString(snippet)))
+	conf := DefaultConfig()
+	err := vip.Unmarshal(&conf)
+	return conf, err
+}
+
+func TestSwarmDurationsFromFile(t *testing.T) {
+	conf, err := unmarshalTOML(t, `
+[swarm]
+bootstrap-timeout = "2m"
+lookup-interval = "500ms"
+`)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, conf.SwarmConfig.BootstrapTimeout)
+	assert.Equal(t, 500*time.Millisecond, conf.SwarmConfig.LookupInterval)
+	// an omitted field keeps its default
+	assert.Equal(t, defaultRefreshInterval, conf.SwarmConfig.RefreshInterval)
+}
+
+func TestSwarmDurationBadString(t *testing.T) {
+	_, err := unmarshalTOML(t, `
+[swarm]
+bootstrap-timeout = "2 parsecs"
+`)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bootstrap-timeout", "the error should name the broken field")
+}
+
+func TestSwarmDurationDefaulting(t *testing.T) {
+	// a zero-value config, as tests often construct, still yields usable waits
+	var sc SwarmConfig
+	assert.Equal(t, defaultBootstrapTimeout, sc.BootstrapTimeoutOrDefault())
+	assert.Equal(t, defaultLookupInterval, sc.LookupIntervalOrDefault())
+	assert.Equal(t, defaultRefreshInterval, sc.RefreshIntervalOrDefault())
+
+	sc.BootstrapTimeout = time.Second
+	assert.Equal(t, time.Second, sc.BootstrapTimeoutOrDefault())
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spf13/viper"
+)
+
+// The section registry lets a package own its config section instead of
+// growing SwarmConfig further: the package registers its section's name and
+// defaults, loading populates each section from the file's nested keys, and
+// the component receives only its own section.
+
+type sectionSpec struct {
+	defaults func() interface{}
+	aliases  map[string]string
+}
+
+var (
+	sectionsMutex sync.Mutex
+	sections      = make(map[string]sectionSpec)
+)
+
+// RegisterSection registers a config section under name. defaults must
+// return a pointer to a freshly allocated section struct carrying the
+// section's default values. aliases maps legacy flat keys onto the section's
+// keys so existing config files keep working for at least one release; pass
+// nil when there are none. Registering a name twice is a programming error.
+func RegisterSection(name string, defaults func() interface{}, aliases map[string]string) {
+	sectionsMutex.Lock()
+	defer sectionsMutex.Unlock()
+	if _, ok := sections[name]; ok {
+		panic(fmt.Sprintf("config: section %q registered twice", name))
+	}
+	sections[name] = sectionSpec{defaults: defaults, aliases: aliases}
+}
+
+// LoadSections populates every registered section from vip and returns the
+// sections by name. A section absent from the file keeps its defaults. A
+// legacy flat key is honored with a deprecation warning, and loses to the
+// section's own key when both are set.
+func LoadSections(vip *viper.Viper) (map[string]interface{}, error) {
+	sectionsMutex.Lock()
+	snapshot := make(map[string]sectionSpec, len(sections))
+	for name, spec := range sections {
+		snapshot[name] = spec
+	}
+	sectionsMutex.Unlock()
+
+	out := make(map[string]interface{}, len(snapshot))
+	for name, spec := range snapshot {
+		target := spec.defaults()
+		sub := vip.Sub(name)
+		for flat, key := range spec.aliases {
+			if !vip.IsSet(flat) {
+				continue
+			}
+			if sub != nil && sub.IsSet(key) {
+				continue // the section's own key wins over its legacy alias
+			}
+			log.Warning("config: flat key %q is deprecated, use %s.%s", flat, name, key)
+			alias := viper.New()
+			alias.Set(key, vip.Get(flat))
+			if err := alias.Unmarshal(target); err != nil {
+				return nil, fmt.Errorf("config key %q: %v", flat, err)
+			}
+		}
+		if sub != nil {
+			if err := sub.Unmarshal(target); err != nil {
+				return nil, fmt.Errorf("config section %q: %v", name, err)
+			}
+		}
+		out[name] = target
+	}
+	return out, nil
+}
+
+func init() {
+	// the swarm section's historical keys lived at the top level - keep them
+	// working as aliases while they phase out
+	RegisterSection("swarm", func() interface{} {
+		sc := DefaultConfig().SwarmConfig
+		return &sc
+	}, map[string]string{
+		"bootstrap":  "bootstrap",
+		"bucketsize": "bucketsize",
+		"alpha":      "alpha",
+		"randcon":    "randcon",
+		"bootnodes":  "bootnodes",
+	})
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeGossipSection struct {
+	Fanout  int           `mapstructure:"fanout"`
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+type fakeNetSection struct {
+	Fanout int `mapstructure:"fanout"`
+}
+
+func sectionViper(t *testing.T, snippet string) *viper.Viper {
+	vip := viper.New()
+	vip.SetConfigType("toml")
+	assert.NoError(t, vip.ReadConfig(bytes.NewBufferString(snippet)))
+	return vip
+}
+
+func TestSectionRegistry(t *testing.T) {
+	RegisterSection("fakegossip", func() interface{} {
+		return &fakeGossipSection{Fanout: 3, Timeout: time.Minute}
+	}, map[string]string{"fakegossip-fanout": "fanout"})
+	RegisterSection("fakenet", func() interface{} {
+		return &fakeNetSection{Fanout: 8}
+	}, nil)
+
+	vip := sectionViper(t, `
+fakegossip-fanout = 7
+
+[fakegossip]
+timeout = "30s"
+
+[fakenet]
+fanout = 2
+`)
+	loaded, err := LoadSections(vip)
+	assert.NoError(t, err)
+
+	// each component receives exactly its own populated section
+	gs := loaded["fakegossip"].(*fakeGossipSection)
+	assert.Equal(t, 7, gs.Fanout, "the legacy flat key should populate the section")
+	assert.Equal(t, 30*time.Second, gs.Timeout, "the nested key should populate the section")
+	ns := loaded["fakenet"].(*fakeNetSection)
+	assert.Equal(t, 2, ns.Fanout, "the other section's fanout must not leak over")
+}
+
+func TestSectionNestedKeyWinsOverAlias(t *testing.T) {
+	RegisterSection("fakewins", func() interface{} {
+		return &fakeNetSection{Fanout: 1}
+	}, map[string]string{"fakewins-fanout": "fanout"})
+
+	vip := sectionViper(t, `
+fakewins-fanout = 5
+
+[fakewins]
+fanout = 9
+`)
+	loaded, err := LoadSections(vip)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, loaded["fakewins"].(*fakeNetSection).Fanout)
+}
+
+func TestSectionDefaultsWhenAbsent(t *testing.T) {
+	loaded, err := LoadSections(viper.New())
+	assert.NoError(t, err)
+
+	// the swarm section registers itself with this package
+	sc := loaded["swarm"].(*SwarmConfig)
+	assert.Equal(t, DefaultConfig().SwarmConfig, *sc)
+}
+
+func TestSwarmSectionLegacyFlatKeys(t *testing.T) {
+	vip := sectionViper(t, "randcon = 9\n")
+	loaded, err := LoadSections(vip)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, loaded["swarm"].(*SwarmConfig).RandomConnections)
+}
+
+func TestSectionDuplicateRegistrationPanics(t *testing.T) {
+	RegisterSection("fakedup", func() interface{} { return &fakeNetSection{} }, nil)
+	assert.Panics(t, func() {
+		RegisterSection("fakedup", func() interface{} { return &fakeNetSection{} }, nil)
+	})
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+
+	"github.com/spacemeshos/go-spacemesh/log"
+)
+
+// Section names used by SubscribeChanges.
+const (
+	SectionP2P   = "p2p"
+	SectionSwarm = "swarm"
+)
+
+// Source provides the desired config on each reload - a config file loader,
+// or an in-memory source in tests.
+type Source func() (Config, error)
+
+// ConfigDelta describes a single field that changed on a config reload.
+type ConfigDelta struct {
+	Section string // section the field belongs to
+	Field   string // the field's mapstructure key
+	Old     interface{}
+	New     interface{}
+}
+
+// mutableFields are the fields that may change at runtime. Anything else is
+// wired too deep to swap under a running node - a reload touching it is
+// refused with a warning and takes effect on the next restart.
+var mutableFields = map[string]bool{
+	SectionSwarm + "/randcon":               true,
+	SectionP2P + "/max-incoming-conns":      true,
+	SectionP2P + "/max-conns-per-ip":        true,
+	SectionP2P + "/incoming-ip-rate-limit":  true,
+	SectionP2P + "/incoming-ip-rate-window": true,
+	SectionP2P + "/max-pending-handshakes":  true,
+	SectionP2P + "/protocol-qos-weights":    true,
+}
+
+// Watcher owns a live config. It re-reads the source on SIGHUP or an
+// explicit Reload call, applies the changes that are safe to take at runtime
+// and hands the resulting deltas to subscribers.
+type Watcher struct {
+	source Source
+
+	mutex       sync.Mutex
+	current     Config
+	subscribers map[string][]chan ConfigDelta
+
+	sigChan  chan os.Signal
+	stopChan chan struct{}
+}
+
+// NewWatcher creates a watcher serving initial until the first reload.
+func NewWatcher(initial Config, source Source) *Watcher {
+	return &Watcher{
+		source:      source,
+		current:     initial,
+		subscribers: make(map[string][]chan ConfigDelta),
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Current returns the live config.
+func (w *Watcher) Current() Config {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.current
+}
+
+// SubscribeChanges returns a channel delivering the deltas applied to the
+// given section on each reload. A slow subscriber loses deltas rather than
+// holding up the reload.
+func (w *Watcher) SubscribeChanges(section string) <-chan ConfigDelta {
+	ch := make(chan ConfigDelta, 16)
+	w.mutex.Lock()
+	w.subscribers[section] = append(w.subscribers[section], ch)
+	w.mutex.Unlock()
+	return ch
+}
+
+// WatchSignals reloads the config whenever the process receives SIGHUP,
+// until Close is called.
+func (w *Watcher) WatchSignals() {
+	w.sigChan = make(chan os.Signal, 1)
+	signal.Notify(w.sigChan, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-w.sigChan:
+				if err := w.Reload(); err != nil {
+					log.Error("config reload failed: %v", err)
+				}
+			case <-w.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the signal watcher. Subscriptions stay valid but no further
+// deltas arrive.
+func (w *Watcher) Close() {
+	if w.sigChan != nil {
+		signal.Stop(w.sigChan)
+	}
+	close(w.stopChan)
+}
+
+// Reload re-reads the config source, applies the mutable changes and
+// notifies subscribers. A change to an immutable field is refused with a
+// warning, keeping the running value. An invalid config is rejected
+// wholesale.
+func (w *Watcher) Reload() error {
+	next, err := w.source()
+	if err != nil {
+		return fmt.Errorf("failed to re-read config: %v", err)
+	}
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	var deltas []ConfigDelta
+	deltas = append(deltas, diffSection(SectionP2P, reflect.ValueOf(&w.current).Elem(), reflect.ValueOf(&next).Elem())...)
+	deltas = append(deltas, diffSection(SectionSwarm, reflect.ValueOf(&w.current.SwarmConfig).Elem(), reflect.ValueOf(&next.SwarmConfig).Elem())...)
+	w.current = next
+	subscribers := make(map[string][]chan ConfigDelta, len(w.subscribers))
+	for section, chans := range w.subscribers {
+		subscribers[section] = chans
+	}
+	w.mutex.Unlock()
+
+	for _, d := range deltas {
+		for _, ch := range subscribers[d.Section] {
+			select {
+			case ch <- d:
+			default:
+				log.Warning("config: subscriber of section %s is not draining, dropping delta for %s", d.Section, d.Field)
+			}
+		}
+	}
+	return nil
+}
+
+// diffSection walks a section's fields by mapstructure key, collecting the
+// mutable changes as deltas and reverting the immutable ones in next.
+func diffSection(section string, old, next reflect.Value) []ConfigDelta {
+	var deltas []ConfigDelta
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := f.Tag.Get("mapstructure")
+		if key == "" || f.Type.Kind() == reflect.Struct {
+			continue // sections are diffed separately
+		}
+		if reflect.DeepEqual(old.Field(i).Interface(), next.Field(i).Interface()) {
+			continue
+		}
+		if !mutableFields[section+"/"+key] {
+			log.Warning("config: %s.%s cannot change at runtime, keeping %v - restart to apply %v",
+				section, key, old.Field(i).Interface(), next.Field(i).Interface())
+			next.Field(i).Set(old.Field(i))
+			continue
+		}
+		deltas = append(deltas, ConfigDelta{Section: section, Field: key, Old: old.Field(i).Interface(), New: next.Field(i).Interface()})
+	}
+	return deltas
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcherReloadDelta(t *testing.T) {
+	cur := DefaultConfig()
+	w := NewWatcher(DefaultConfig(), func() (Config, error) { return cur, nil })
+	ch := w.SubscribeChanges(SectionSwarm)
+
+	cur.SwarmConfig.RandomConnections = 8
+	assert.NoError(t, w.Reload())
+	assert.Equal(t, 8, w.Current().SwarmConfig.RandomConnections)
+
+	select {
+	case d := <-ch:
+		assert.Equal(t, SectionSwarm, d.Section)
+		assert.Equal(t, "randcon", d.Field)
+		assert.Equal(t, 5, d.Old)
+		assert.Equal(t, 8, d.New)
+	case <-time.After(time.Second):
+		t.Fatal("no delta was delivered")
+	}
+}
+
+func TestWatcherRefusesImmutable(t *testing.T) {
+	cur := DefaultConfig()
+	w := NewWatcher(DefaultConfig(), func() (Config, error) { return cur, nil })
+	ch := w.SubscribeChanges(SectionP2P)
+
+	cur.TCPPort = 9999         // immutable - needs a restart
+	cur.MaxIncomingConns = 100 // mutable, applied alongside
+	assert.NoError(t, w.Reload())
+
+	assert.Equal(t, DefaultConfig().TCPPort, w.Current().TCPPort, "an immutable field must keep its running value")
+	assert.Equal(t, 100, w.Current().MaxIncomingConns)
+
+	select {
+	case d := <-ch:
+		assert.Equal(t, "max-incoming-conns", d.Field, "only the mutable change should be announced")
+	case <-time.After(time.Second):
+		t.Fatal("no delta was delivered")
+	}
+	select {
+	case d := <-ch:
+		t.Fatalf("unexpected extra delta for %s", d.Field)
+	default:
+	}
+}
+
+// fakeNeighborhood adjusts its peer target from reload deltas, standing in
+// for the gossip Neighborhood.
+type fakeNeighborhood struct {
+	target chan int
+}
+
+func (f *fakeNeighborhood) follow(ch <-chan ConfigDelta) {
+	go func() {
+		for d := range ch {
+			if d.Field == "randcon" {
+				f.target <- d.New.(int)
+			}
+		}
+	}()
+}
+
+func TestWatcherNotifiesNeighborhood(t *testing.T) {
+	cur := DefaultConfig()
+	w := NewWatcher(DefaultConfig(), func() (Config, error) { return cur, nil })
+
+	fake := &fakeNeighborhood{target: make(chan int, 1)}
+	fake.follow(w.SubscribeChanges(SectionSwarm))
+
+	cur.SwarmConfig.RandomConnections = 12
+	assert.NoError(t, w.Reload())
+
+	select {
+	case got := <-fake.target:
+		assert.Equal(t, 12, got)
+	case <-time.After(time.Second):
+		t.Fatal("the neighborhood never saw the new peer target")
+	}
+}
+
+func TestWatcherRejectsInvalidReload(t *testing.T) {
+	cur := DefaultConfig()
+	w := NewWatcher(DefaultConfig(), func() (Config, error) { return cur, nil })
+
+	cur.SwarmConfig.RoutingTableBucketSize = 0
+	assert.Error(t, w.Reload(), "an invalid config must be rejected wholesale")
+	assert.Equal(t, DefaultConfig().SwarmConfig.RoutingTableBucketSize, w.Current().SwarmConfig.RoutingTableBucketSize)
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NodeStringParser checks that a string is a parsable node descriptor
+// (address/base58-id). The node package registers its parser here on init -
+// it cannot be imported from this package without a dependency cycle. A nil
+// parser skips the check.
+var NodeStringParser func(data string) error
+
+// ValidationError lists every violation found in a config, so a broken
+// config can be fixed in one pass instead of one restart per field.
+type ValidationError struct {
+	Violations []string
+}
+
+func (v *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config: %s", strings.Join(v.Violations, "; "))
+}
+
+// collect turns a violation list into an error, nil when the list is empty.
+func collect(violations []string) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: violations}
+}
+
+// Validate checks the swarm section for values that would hang bootstrap or
+// crash the routing table, reporting every violation at once.
+func (sc SwarmConfig) Validate() error {
+	return collect(sc.violations())
+}
+
+func (sc SwarmConfig) violations() []string {
+	var v []string
+	if sc.RoutingTableBucketSize <= 0 {
+		v = append(v, fmt.Sprintf("swarm.bucketsize: must be positive, got %d", sc.RoutingTableBucketSize))
+	}
+	if sc.RoutingTableAlpha <= 0 {
+		v = append(v, fmt.Sprintf("swarm.alpha: must be positive, got %d", sc.RoutingTableAlpha))
+	} else if sc.RoutingTableBucketSize > 0 && sc.RoutingTableAlpha > sc.RoutingTableBucketSize {
+		v = append(v, fmt.Sprintf("swarm.alpha: must not exceed swarm.bucketsize, got %d > %d",
+			sc.RoutingTableAlpha, sc.RoutingTableBucketSize))
+	}
+	if sc.RandomConnections < 0 {
+		v = append(v, fmt.Sprintf("swarm.randcon: must not be negative, got %d", sc.RandomConnections))
+	}
+	if sc.BootstrapTimeout < 0 {
+		v = append(v, fmt.Sprintf("swarm.bootstrap-timeout: must not be negative, got %v", sc.BootstrapTimeout))
+	}
+	if sc.LookupInterval < 0 {
+		v = append(v, fmt.Sprintf("swarm.lookup-interval: must not be negative, got %v", sc.LookupInterval))
+	}
+	if sc.RefreshInterval < 0 {
+		v = append(v, fmt.Sprintf("swarm.refresh-interval: must not be negative, got %v", sc.RefreshInterval))
+	}
+	if sc.Bootstrap && sc.RandomConnections == 0 {
+		v = append(v, "swarm.randcon: must be positive when swarm.bootstrap is set, bootstrap would wait forever")
+	}
+	for i, bn := range sc.BootstrapNodes {
+		if bn == "" {
+			v = append(v, fmt.Sprintf("swarm.bootnodes[%d]: empty node string", i))
+			continue
+		}
+		if NodeStringParser != nil {
+			if err := NodeStringParser(bn); err != nil {
+				v = append(v, fmt.Sprintf("swarm.bootnodes[%d]: %v", i, err))
+			}
+		}
+	}
+	return v
+}
+
+// Validate checks the whole p2p config, aggregating the violations of every
+// section into a single error.
+func (c Config) Validate() error {
+	var v []string
+	if c.TCPPort < 0 || c.TCPPort > 65535 {
+		v = append(v, fmt.Sprintf("tcp-port: must be in [0, 65535], got %d", c.TCPPort))
+	}
+	if c.SecurityParam <= 0 {
+		v = append(v, fmt.Sprintf("security-param: must be positive, got %d", c.SecurityParam))
+	}
+	v = append(v, c.SwarmConfig.violations()...)
+	return collect(v)
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// the node package registers the real parser on init - these tests run
+// without it, so install a stand-in with the same failure mode
+func stubNodeStringParser() func() {
+	prev := NodeStringParser
+	NodeStringParser = func(data string) error {
+		if !strings.Contains(data, "/") {
+			return errors.New("missing '/' separator in node string")
+		}
+		return nil
+	}
+	return func() { NodeStringParser = prev }
+}
+
+func TestConfigValidate(t *testing.T) {
+	defer stubNodeStringParser()()
+
+	cases := []struct {
+		name   string
+		mutate func(c *Config)
+		fields []string // substrings the error must mention, empty for a valid config
+	}{
+		{"default config", func(c *Config) {}, nil},
+		{"zero bucket size", func(c *Config) { c.SwarmConfig.RoutingTableBucketSize = 0 }, []string{"swarm.bucketsize"}},
+		{"negative bucket size", func(c *Config) { c.SwarmConfig.RoutingTableBucketSize = -3 }, []string{"swarm.bucketsize"}},
+		{"zero alpha", func(c *Config) { c.SwarmConfig.RoutingTableAlpha = 0 }, []string{"swarm.alpha"}},
+		{"alpha over bucket size", func(c *Config) { c.SwarmConfig.RoutingTableAlpha = 25 }, []string{"swarm.alpha"}},
+		{"negative random connections", func(c *Config) { c.SwarmConfig.RandomConnections = -1 }, []string{"swarm.randcon"}},
+		{"negative bootstrap timeout", func(c *Config) { c.SwarmConfig.BootstrapTimeout = -time.Second }, []string{"swarm.bootstrap-timeout"}},
+		{"negative lookup interval", func(c *Config) { c.SwarmConfig.LookupInterval = -time.Second }, []string{"swarm.lookup-interval"}},
+		{"negative refresh interval", func(c *Config) { c.SwarmConfig.RefreshInterval = -time.Second }, []string{"swarm.refresh-interval"}},
+		{"bootstrap with no connections", func(c *Config) {
+			c.SwarmConfig.Bootstrap = true
+			c.SwarmConfig.RandomConnections = 0
+		}, []string{"swarm.randcon"}},
+		{"empty bootstrap entry", func(c *Config) { c.SwarmConfig.BootstrapNodes = []string{""} }, []string{"swarm.bootnodes[0]"}},
+		{"unparsable bootstrap entry", func(c *Config) { c.SwarmConfig.BootstrapNodes = []string{"no-separator"} }, []string{"swarm.bootnodes[0]"}},
+		{"port out of range", func(c *Config) { c.TCPPort = 70000 }, []string{"tcp-port"}},
+		{"zero security param", func(c *Config) { c.SecurityParam = 0 }, []string{"security-param"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := DefaultConfig()
+			tc.mutate(&c)
+			err := c.Validate()
+			if len(tc.fields) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			for _, field := range tc.fields {
+				assert.Contains(t, err.Error(), field, "the error should name the broken field")
+			}
+		})
+	}
+}
+
+func TestConfigValidateAggregates(t *testing.T) {
+	defer stubNodeStringParser()()
+
+	c := DefaultConfig()
+	c.TCPPort = -1
+	c.SwarmConfig.RoutingTableAlpha = 0
+	c.SwarmConfig.BootstrapNodes = []string{"no-separator"}
+
+	err := c.Validate()
+	assert.Error(t, err)
+	verr, ok := err.(*ValidationError)
+	assert.True(t, ok, "expected a *ValidationError, got %T", err)
+	assert.Equal(t, 3, len(verr.Violations), "every violation should be reported at once: %v", verr.Violations)
+	for _, field := range []string{"tcp-port", "swarm.alpha", "swarm.bootnodes[0]"} {
+		assert.Contains(t, err.Error(), field)
+	}
+}
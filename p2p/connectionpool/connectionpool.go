@@ -5,11 +5,24 @@ import (
 	"github.com/spacemeshos/go-spacemesh/p2p/net"
 
 	"bytes"
+	"context"
 	"errors"
 	"gopkg.in/op/go-logging.v1"
 	"sync"
+	"time"
 )
 
+// Reconnect policy defaults - how many background re-dial attempts are made
+// for a dropped connection and the initial backoff, doubled every attempt.
+const (
+	DefaultReconnectAttempts = 3
+	DefaultReconnectBackoff  = 500 * time.Millisecond
+)
+
+// ErrReconnecting is returned while the pool is re-establishing a dropped
+// connection in the background - callers can retry shortly.
+var ErrReconnecting = errors.New("connection to the peer is being re-established")
+
 type dialResult struct {
 	conn net.Connection
 	err  error
@@ -17,9 +30,11 @@ type dialResult struct {
 
 type networker interface {
 	Dial(address string, remotePublicKey crypto.PublicKey) (net.Connection, error) // Connect to a remote node. Can send when no error.
+	DialWithContext(ctx context.Context, address string, remotePublicKey crypto.PublicKey) (net.Connection, error)
 	SubscribeOnNewRemoteConnections() chan net.NewConnectionEvent
 	NetworkID() int8
 	ClosingConnections() chan net.Connection
+	RejectionCounts() map[string]int64
 	Logger() *logging.Logger
 }
 
@@ -37,23 +52,54 @@ type ConnectionPool struct {
 	dialWait    sync.WaitGroup
 	shutdown    bool
 
+	maxConns int
+	lastUse  map[string]time.Time
+	useMutex sync.Mutex
+
+	// transfer totals of closed connections per peer and routed totals per
+	// protocol - live connections are folded in on snapshot
+	peerTotals  map[string]net.ConnStats
+	protoTotals map[string]net.ConnStats
+	statsMutex  sync.Mutex
+
+	// background reconnection state - last known dial address per peer,
+	// peers with a reconnect in flight, and dead-peer subscribers
+	addresses         map[string]string
+	reconnecting      map[string]bool
+	deadPeerSubs      []chan string
+	reconnectAttempts int
+	reconnectBackoff  time.Duration
+	reconMutex        sync.Mutex
+
 	newRemoteConn chan net.NewConnectionEvent
+	closedConn    chan net.Connection
 	teardown      chan struct{}
+	stopped       chan struct{}
 }
 
 // NewConnectionPool creates new ConnectionPool
 func NewConnectionPool(network networker, lPub crypto.PublicKey) *ConnectionPool {
 	cPool := &ConnectionPool{
-		localPub:      lPub,
-		net:           network,
-		connections:   make(map[string]net.Connection),
-		connMutex:     sync.RWMutex{},
-		pending:       make(map[string][]chan dialResult),
-		pendMutex:     sync.Mutex{},
-		dialWait:      sync.WaitGroup{},
-		shutdown:      false,
-		newRemoteConn: network.SubscribeOnNewRemoteConnections(),
-		teardown:      make(chan struct{}),
+		localPub:     lPub,
+		net:          network,
+		connections:  make(map[string]net.Connection),
+		connMutex:    sync.RWMutex{},
+		pending:      make(map[string][]chan dialResult),
+		pendMutex:    sync.Mutex{},
+		dialWait:     sync.WaitGroup{},
+		shutdown:     false,
+		lastUse:      make(map[string]time.Time),
+		peerTotals:   make(map[string]net.ConnStats),
+		protoTotals:  make(map[string]net.ConnStats),
+		addresses:    make(map[string]string),
+		reconnecting: make(map[string]bool),
+
+		reconnectAttempts: DefaultReconnectAttempts,
+		reconnectBackoff:  DefaultReconnectBackoff,
+		newRemoteConn:     network.SubscribeOnNewRemoteConnections(),
+		closedConn:        make(chan net.Connection, 20),
+		teardown:          make(chan struct{}),
+		stopped:           make(chan struct{}),
 	}
 	go cPool.beginEventProcessing()
 	return cPool
@@ -74,6 +120,7 @@ func (cp *ConnectionPool) Shutdown() {
 
 	cp.dialWait.Wait()
 	cp.teardown <- struct{}{}
+	close(cp.stopped)
 	// we won't handle the closing connection events for these connections since we exit the loop once the teardown is done
 	cp.closeConnections()
 }
@@ -87,6 +134,65 @@ func (cp *ConnectionPool) closeConnections() {
 	cp.connMutex.Unlock()
 }
 
+// SetMaxConnections caps the number of pooled connections. When a new
+// connection pushes the pool over the cap, the least recently used
+// connections are closed - their owners learn of it through the network's
+// closing-connections subscription like any other closure. A non-positive
+// max leaves the pool unbounded.
+func (cp *ConnectionPool) SetMaxConnections(max int) {
+	cp.connMutex.Lock()
+	cp.maxConns = max
+	cp.connMutex.Unlock()
+	cp.enforceCap("")
+}
+
+// touch records that the connection with the remote peer was just used.
+func (cp *ConnectionPool) touch(rPub string) {
+	cp.useMutex.Lock()
+	cp.lastUse[rPub] = time.Now()
+	cp.useMutex.Unlock()
+}
+
+// enforceCap closes least-recently-used connections until the pool is within
+// its cap again. keep is never evicted - it is the connection whose arrival
+// triggered the enforcement and its user didn't get a chance to use it yet.
+func (cp *ConnectionPool) enforceCap(keep string) {
+	for {
+		cp.connMutex.Lock()
+		if cp.maxConns <= 0 || len(cp.connections) <= cp.maxConns {
+			cp.connMutex.Unlock()
+			return
+		}
+		var victimKey string
+		var victimTime time.Time
+		cp.useMutex.Lock()
+		for key := range cp.connections {
+			if key == keep {
+				continue
+			}
+			used := cp.lastUse[key]
+			if victimKey == "" || used.Before(victimTime) {
+				victimKey = key
+				victimTime = used
+			}
+		}
+		if victimKey != "" {
+			delete(cp.lastUse, victimKey)
+		}
+		cp.useMutex.Unlock()
+		if victimKey == "" {
+			cp.connMutex.Unlock()
+			return
+		}
+		victim := cp.connections[victimKey]
+		delete(cp.connections, victimKey)
+		cp.connMutex.Unlock()
+		cp.net.Logger().Info("connection pool is over %d connections, closing the least recently used. remote=%s", cp.maxConns, victimKey)
+		victim.Close()
+		cp.foldConnStats(victim)
+	}
+}
+
 func (cp *ConnectionPool) handleDialResult(rPub crypto.PublicKey, result dialResult) {
 	cp.pendMutex.Lock()
 	for _, p := range cp.pending[rPub.String()] {
@@ -133,8 +239,15 @@ func (cp *ConnectionPool) handleNewConnection(rPub crypto.PublicKey, newConn net
 			closeConn = newConn
 		}
 		cp.connMutex.Unlock()
+		cp.touch(rPub.String())
 		if closeConn != nil {
 			closeConn.Close()
+			// the duplicate never reaches handleClosedConnection's removal
+			// path, so its transfer counters are folded here
+			cp.foldConnStats(closeConn)
+		}
+		if closeConn != newConn {
+			go cp.watchConnection(newConn)
 		}
 
 		// we don't need to update on the new connection since there were already a connection in the table and there shouldn't be any registered channel waiting for updates
@@ -142,12 +255,94 @@ func (cp *ConnectionPool) handleNewConnection(rPub crypto.PublicKey, newConn net
 	}
 	cp.connections[rPub.String()] = newConn
 	cp.connMutex.Unlock()
+	cp.touch(rPub.String())
+	go cp.watchConnection(newConn)
 
 	// update all registered channels
 	res := dialResult{newConn, nil}
 	cp.handleDialResult(rPub, res)
+	cp.enforceCap(rPub.String())
 }
 
+// watchConnection waits on the connection's close notification and feeds it
+// into the pool's event loop. The network's closing-connections feed covers
+// connections it runs the event loop for, but closures it never reports -
+// such as an evicted or mocked connection - are only visible here; the two
+// sources may overlap and handleClosedConnection tolerates that.
+func (cp *ConnectionPool) watchConnection(conn net.Connection) {
+	select {
+	case <-conn.Closed():
+		select {
+		case cp.closedConn <- conn:
+		case <-cp.stopped:
+		}
+	case <-cp.stopped:
+	}
+}
+
+// foldConnStats accumulates a closed connection's transfer counters into the
+// per-peer totals.
+func (cp *ConnectionPool) foldConnStats(conn net.Connection) {
+	cp.statsMutex.Lock()
+	s := cp.peerTotals[conn.RemotePublicKey().String()]
+	s.Add(conn.Stats())
+	cp.peerTotals[conn.RemotePublicKey().String()] = s
+	cp.statsMutex.Unlock()
+}
+
+// CountProtocolMessage attributes a routed wire frame to a protocol. The
+// demux is the only layer that knows which protocol a frame belongs to, so
+// it reports here while routing.
+func (cp *ConnectionPool) CountProtocolMessage(protocol string, wireBytes int64, sent bool) {
+	cp.statsMutex.Lock()
+	s := cp.protoTotals[protocol]
+	if sent {
+		s.BytesSent += wireBytes
+		s.MessagesSent++
+	} else {
+		s.BytesReceived += wireBytes
+		s.MessagesReceived++
+	}
+	cp.protoTotals[protocol] = s
+	cp.statsMutex.Unlock()
+}
+
+// Metrics returns a transfer totals snapshot - closed connections' counters
+// plus the live ones', keyed by remote peer, and the per-protocol totals the
+// demux reported.
+func (cp *ConnectionPool) Metrics() net.Metrics {
+	m := net.Metrics{
+		Peers:     make(map[string]net.ConnStats),
+		Protocols: make(map[string]net.ConnStats),
+		Rejected:  cp.net.RejectionCounts(),
+	}
+	cp.statsMutex.Lock()
+	for peer, stats := range cp.peerTotals {
+		m.Peers[peer] = stats
+	}
+	for protocol, stats := range cp.protoTotals {
+		m.Protocols[protocol] = stats
+	}
+	cp.statsMutex.Unlock()
+
+	cp.connMutex.RLock()
+	for peer, conn := range cp.connections {
+		s := m.Peers[peer]
+		s.Add(conn.Stats())
+		m.Peers[peer] = s
+	}
+	cp.connMutex.RUnlock()
+
+	for _, stats := range m.Peers {
+		m.Total.Add(stats)
+	}
+	return m
+}
+
+// handleClosedConnection reacts to a connection going down. It may be called
+// more than once for the same connection - the close watcher and the
+// network's closing-connections feed can both report it - so everything it
+// does is keyed on the connection still being the cached one.
 func (cp *ConnectionPool) handleClosedConnection(conn net.Connection) {
 	cp.net.Logger().Debug("connection %v with %v was closed", conn.String())
 	cp.connMutex.Lock()
@@ -156,12 +351,105 @@ func (cp *ConnectionPool) handleClosedConnection(conn net.Connection) {
 	// only delete if the closed connection is the same as the cached one (it is possible that the closed connection is a duplication and therefore was closed)
 	if ok && cur.ID() == conn.ID() {
 		delete(cp.connections, rPub)
+		cp.useMutex.Lock()
+		delete(cp.lastUse, rPub)
+		cp.useMutex.Unlock()
+		cp.foldConnStats(conn)
+		// the pooled connection dropped from under its users - re-establish
+		// it in the background if we know where to dial
+		if !cp.shutdown {
+			cp.reconMutex.Lock()
+			addr, known := cp.addresses[rPub]
+			if known && !cp.reconnecting[rPub] {
+				cp.reconnecting[rPub] = true
+				go cp.reconnect(conn.RemotePublicKey(), addr)
+			}
+			cp.reconMutex.Unlock()
+		}
 	}
 	cp.connMutex.Unlock()
 }
 
+// SetReconnectPolicy sets the number of background re-dial attempts made for
+// a dropped connection and the initial backoff between attempts - the backoff
+// doubles after every failure.
+func (cp *ConnectionPool) SetReconnectPolicy(attempts int, backoff time.Duration) {
+	cp.reconMutex.Lock()
+	cp.reconnectAttempts = attempts
+	cp.reconnectBackoff = backoff
+	cp.reconMutex.Unlock()
+}
+
+// SubscribeOnDeadPeers returns a channel that is notified with the public key
+// of a peer the pool gave up reconnecting to.
+func (cp *ConnectionPool) SubscribeOnDeadPeers() chan string {
+	ch := make(chan string, 20)
+	cp.reconMutex.Lock()
+	cp.deadPeerSubs = append(cp.deadPeerSubs, ch)
+	cp.reconMutex.Unlock()
+	return ch
+}
+
+// reconnect re-establishes a dropped connection in the background. A single
+// reconnect runs per peer; GetConnection calls made meanwhile fail fast with
+// ErrReconnecting instead of each user dialing on its own. When all attempts
+// fail the peer is declared dead and the dead-peer subscribers are notified.
+func (cp *ConnectionPool) reconnect(rPub crypto.PublicKey, address string) {
+	cp.dialWait.Add(1)
+	defer cp.dialWait.Done()
+	cp.reconMutex.Lock()
+	attempts := cp.reconnectAttempts
+	backoff := cp.reconnectBackoff
+	cp.reconMutex.Unlock()
+	for attempt := 1; attempt <= attempts; attempt++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		cp.connMutex.RLock()
+		shuttingDown := cp.shutdown
+		cp.connMutex.RUnlock()
+		if shuttingDown {
+			cp.reconMutex.Lock()
+			delete(cp.reconnecting, rPub.String())
+			cp.reconMutex.Unlock()
+			return
+		}
+		conn, err := cp.net.Dial(address, rPub)
+		if err == nil {
+			cp.net.Logger().Info("reconnected to %v on attempt %d", rPub.String(), attempt)
+			// cache the connection before clearing the reconnecting mark so
+			// there is no window where callers would start a dial of their own
+			cp.handleNewConnection(rPub, conn, net.Local)
+			cp.reconMutex.Lock()
+			delete(cp.reconnecting, rPub.String())
+			cp.reconMutex.Unlock()
+			return
+		}
+		cp.net.Logger().Warning("reconnect attempt %d to %v failed: %v", attempt, rPub.String(), err)
+	}
+	cp.net.Logger().Warning("giving up on %v after %d reconnect attempts, reporting it dead", rPub.String(), attempts)
+	cp.reconMutex.Lock()
+	delete(cp.reconnecting, rPub.String())
+	delete(cp.addresses, rPub.String())
+	subs := make([]chan string, len(cp.deadPeerSubs))
+	copy(subs, cp.deadPeerSubs)
+	cp.reconMutex.Unlock()
+	for _, sub := range subs {
+		sub <- rPub.String()
+	}
+}
+
 // GetConnection fetchs or creates if don't exist a connection to the address which is associated with the remote public key
 func (cp *ConnectionPool) GetConnection(address string, remotePub crypto.PublicKey) (net.Connection, error) {
+	return cp.GetConnectionWithContext(context.Background(), address, remotePub)
+}
+
+// GetConnectionWithContext is GetConnection under the given context's deadline -
+// the dial and handshake are aborted once the context is done. Concurrent calls
+// for the same peer coalesce into the first caller's dial; a caller whose
+// context expires stops waiting without aborting the shared dial. While a
+// background reconnect to the peer is in flight the call fails fast with
+// ErrReconnecting so callers can retry once it is done.
+func (cp *ConnectionPool) GetConnectionWithContext(ctx context.Context, address string, remotePub crypto.PublicKey) (net.Connection, error) {
 	cp.connMutex.RLock()
 	if cp.shutdown {
 		cp.connMutex.RUnlock()
@@ -171,8 +459,19 @@ func (cp *ConnectionPool) GetConnection(address string, remotePub crypto.PublicK
 	conn, found := cp.connections[remotePub.String()]
 	if found {
 		cp.connMutex.RUnlock()
+		cp.touch(remotePub.String())
 		return conn, nil
 	}
+	cp.reconMutex.Lock()
+	if cp.reconnecting[remotePub.String()] {
+		cp.reconMutex.Unlock()
+		cp.connMutex.RUnlock()
+		return nil, ErrReconnecting
+	}
+	// remember where the peer was dialed so a dropped connection can be
+	// re-established without the callers' involvement
+	cp.addresses[remotePub.String()] = address
+	cp.reconMutex.Unlock()
 	// register for signal when connection is established - must be called under the connMutex otherwise there is a race
 	// where it is possible that the connection will be established and all registered channels will be notified before
 	// the current registration
@@ -182,7 +481,7 @@ func (cp *ConnectionPool) GetConnection(address string, remotePub crypto.PublicK
 		// No one is waiting for a connection with the remote peer, need to call Dial
 		go func() {
 			cp.dialWait.Add(1)
-			conn, err := cp.net.Dial(address, remotePub)
+			conn, err := cp.net.DialWithContext(ctx, address, remotePub)
 			if err != nil {
 				cp.handleDialResult(remotePub, dialResult{nil, err})
 			} else {
@@ -191,13 +490,18 @@ func (cp *ConnectionPool) GetConnection(address string, remotePub crypto.PublicK
 			cp.dialWait.Done()
 		}()
 	}
-	pendChan := make(chan dialResult)
+	// buffered so an abandoning waiter doesn't block the dial result delivery
+	pendChan := make(chan dialResult, 1)
 	cp.pending[remotePub.String()] = append(cp.pending[remotePub.String()], pendChan)
 	cp.pendMutex.Unlock()
 	cp.connMutex.RUnlock()
 	// wait for the connection to be established, if the channel is closed (in case of dialing error) will return nil
-	res := <-pendChan
-	return res.conn, res.err
+	select {
+	case res := <-pendChan:
+		return res.conn, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 }
 
 func (cp *ConnectionPool) beginEventProcessing() {
@@ -210,6 +514,9 @@ Loop:
 		case conn := <-cp.net.ClosingConnections():
 			cp.handleClosedConnection(conn)
 
+		case conn := <-cp.closedConn:
+			cp.handleClosedConnection(conn)
+
 		case <-cp.teardown:
 			break Loop
 		}
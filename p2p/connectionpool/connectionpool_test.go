@@ -1,6 +1,7 @@
 package connectionpool
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/spacemeshos/go-spacemesh/crypto"
@@ -8,6 +9,9 @@ import (
 	"github.com/spacemeshos/go-spacemesh/p2p/node"
 	"github.com/stretchr/testify/assert"
 	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -48,6 +52,26 @@ func TestGetConnectionWithConnection(t *testing.T) {
 	assert.Equal(t, int32(1), net.DialCount())
 }
 
+func TestGetConnectionWithContextCancel(t *testing.T) {
+	n := net.NewNetworkMock()
+	n.SetDialDelayMs(100)
+	n.SetDialResult(nil)
+	cPool := NewConnectionPool(n, generatePublicKey())
+	remotePub := generatePublicKey()
+	addr := "1.1.1.1"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	conn, err := cPool.GetConnectionWithContext(ctx, addr, remotePub)
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, conn)
+	assert.True(t, time.Since(start) < 80*time.Millisecond, "cancellation did not abort the wait promptly")
+}
+
 func TestGetConnectionWithError(t *testing.T) {
 	net := net.NewNetworkMock()
 	net.SetDialDelayMs(50)
@@ -138,8 +162,8 @@ func TestRemoteConnectionWithExistingConnection(t *testing.T) {
 	time.Sleep(20 * time.Millisecond)
 	assert.Equal(t, remotePub.String(), lConn.RemotePublicKey().String())
 	assert.Equal(t, int32(1), n.DialCount())
-	assert.False(t, rConn.Closed())
-	assert.True(t, lConn.Closed())
+	assert.False(t, rConn.IsClosed())
+	assert.True(t, lConn.(*net.ConnectionMock).IsClosed())
 
 	// local connection has session ID > remote's session ID
 	remotePub = generatePublicKey()
@@ -152,8 +176,8 @@ func TestRemoteConnectionWithExistingConnection(t *testing.T) {
 	time.Sleep(20 * time.Millisecond)
 	assert.Equal(t, remotePub.String(), lConn.RemotePublicKey().String())
 	assert.Equal(t, int32(2), n.DialCount())
-	assert.True(t, rConn.Closed())
-	assert.False(t, lConn.Closed())
+	assert.True(t, rConn.IsClosed())
+	assert.False(t, lConn.(*net.ConnectionMock).IsClosed())
 }
 
 func TestShutdown(t *testing.T) {
@@ -173,7 +197,7 @@ func TestShutdown(t *testing.T) {
 	cPool.Shutdown()
 	conn := <-newConns
 	cMock := conn.(*net.ConnectionMock)
-	assert.True(t, cMock.Closed())
+	assert.True(t, cMock.IsClosed())
 }
 
 func TestGetConnectionAfterShutdown(t *testing.T) {
@@ -213,7 +237,7 @@ func TestShutdownWithMultipleDials(t *testing.T) {
 	var cnt int
 	for conn := range newConns {
 		cMock := conn.(*net.ConnectionMock)
-		assert.True(t, cMock.Closed(), "connection %s is still open", cMock.ID())
+		assert.True(t, cMock.IsClosed(), "connection %s is still open", cMock.ID())
 		cnt++
 		if cnt == iterCnt {
 			break
@@ -226,6 +250,7 @@ func TestClosedConnection(t *testing.T) {
 	nMock.SetDialDelayMs(50)
 	nMock.SetDialResult(nil)
 	cPool := NewConnectionPool(nMock, generatePublicKey())
+	cPool.SetReconnectPolicy(3, 100*time.Millisecond)
 	remotePub := generatePublicKey()
 	addr := "1.1.1.1"
 
@@ -236,12 +261,187 @@ func TestClosedConnection(t *testing.T) {
 	nMock.PublishClosingConnection(conn)
 	time.Sleep(20 * time.Millisecond)
 
-	// query same connection and assert that it's a new instance
-	conn2, _ := cPool.GetConnection(addr, remotePub)
+	// the pool is re-dialing in the background - callers get a retryable error
+	conn2, err := cPool.GetConnection(addr, remotePub)
+	assert.Equal(t, ErrReconnecting, err)
+	assert.Nil(t, conn2)
+
+	// once the re-dial is done a new instance is handed out
+	time.Sleep(300 * time.Millisecond)
+	conn2, err = cPool.GetConnection(addr, remotePub)
+	assert.Nil(t, err)
+	assert.NotEqual(t, conn.ID(), conn2.ID())
+	assert.Equal(t, int32(2), nMock.DialCount())
+}
+
+func TestReconnectSingleDial(t *testing.T) {
+	nMock := net.NewNetworkMock()
+	nMock.SetDialResult(nil)
+	cPool := NewConnectionPool(nMock, generatePublicKey())
+	cPool.SetReconnectPolicy(3, 100*time.Millisecond)
+	remotePub := generatePublicKey()
+	addr := "1.1.1.1"
+
+	conn, err := cPool.GetConnection(addr, remotePub)
+	assert.Nil(t, err)
+
+	// the remote side drops the connection
+	nMock.PublishClosingConnection(conn)
+	time.Sleep(20 * time.Millisecond)
+
+	// many senders notice the failure at once - none of them triggers a dial
+	var reconnecting int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cPool.GetConnection(addr, remotePub)
+			if err == ErrReconnecting {
+				atomic.AddInt32(&reconnecting, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(50), reconnecting)
+
+	// the pool performed a single re-dial on everyone's behalf
+	time.Sleep(300 * time.Millisecond)
+	conn2, err := cPool.GetConnection(addr, remotePub)
+	assert.Nil(t, err)
 	assert.NotEqual(t, conn.ID(), conn2.ID())
 	assert.Equal(t, int32(2), nMock.DialCount())
 }
 
+func TestReconnectDeadPeer(t *testing.T) {
+	nMock := net.NewNetworkMock()
+	nMock.SetDialResult(nil)
+	cPool := NewConnectionPool(nMock, generatePublicKey())
+	cPool.SetReconnectPolicy(2, 10*time.Millisecond)
+	deadPeers := cPool.SubscribeOnDeadPeers()
+	remotePub := generatePublicKey()
+	addr := "1.1.1.1"
+
+	conn, err := cPool.GetConnection(addr, remotePub)
+	assert.Nil(t, err)
+
+	// the peer goes away for good - every re-dial fails
+	nMock.SetDialResult(errors.New("connection refused"))
+	nMock.PublishClosingConnection(conn)
+
+	select {
+	case dead := <-deadPeers:
+		assert.Equal(t, remotePub.String(), dead)
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no dead-peer notification after exhausting the reconnect attempts")
+	}
+	assert.Equal(t, int32(3), nMock.DialCount(), "one initial dial and two reconnect attempts")
+
+	// an explicit GetConnection afterwards starts from scratch
+	nMock.SetDialResult(nil)
+	conn2, err := cPool.GetConnection(addr, remotePub)
+	assert.Nil(t, err)
+	assert.NotEqual(t, conn.ID(), conn2.ID())
+}
+
+func TestPoolMetrics(t *testing.T) {
+	nMock := net.NewNetworkMock()
+	nMock.SetDialResult(nil)
+	cPool := NewConnectionPool(nMock, generatePublicKey())
+	remotePub := generatePublicKey()
+
+	conn, err := cPool.GetConnection("1.1.1.1", remotePub)
+	assert.Nil(t, err)
+	msg := make([]byte, 100)
+	assert.NoError(t, conn.Send(msg))
+	assert.NoError(t, conn.Send(msg))
+	cPool.CountProtocolMessage("test/proto/1", net.FramedSize(len(msg)), true)
+	cPool.CountProtocolMessage("test/proto/1", 50, false)
+
+	m := cPool.Metrics()
+	peerStats := m.Peers[remotePub.String()]
+	assert.Equal(t, 2*net.FramedSize(len(msg)), peerStats.BytesSent)
+	assert.Equal(t, int64(2), peerStats.MessagesSent)
+	assert.Equal(t, peerStats, m.Total, "a single peer should account for the total")
+	protoStats := m.Protocols["test/proto/1"]
+	assert.Equal(t, net.FramedSize(len(msg)), protoStats.BytesSent)
+	assert.Equal(t, int64(1), protoStats.MessagesSent)
+	assert.Equal(t, int64(50), protoStats.BytesReceived)
+	assert.Equal(t, int64(1), protoStats.MessagesReceived)
+
+	// closing folds the connection's counters into the totals instead of losing them
+	nMock.PublishClosingConnection(conn)
+	time.Sleep(20 * time.Millisecond)
+	m = cPool.Metrics()
+	assert.Equal(t, 2*net.FramedSize(len(msg)), m.Peers[remotePub.String()].BytesSent)
+}
+
+func TestConnectionCapLRU(t *testing.T) {
+	type Peer struct {
+		key  crypto.PublicKey
+		addr string
+	}
+
+	peerCnt := 50
+	maxConns := 10
+	goroutines := 200
+
+	peers := make([]Peer, 0, peerCnt)
+	for i := 0; i < peerCnt; i++ {
+		peers = append(peers, Peer{generatePublicKey(), generateIpAddress()})
+	}
+
+	nMock := net.NewNetworkMock()
+	nMock.SetDialResult(nil)
+	before := runtime.NumGoroutine()
+	cPool := NewConnectionPool(nMock, generatePublicKey())
+	cPool.SetMaxConnections(maxConns)
+
+	var mtx sync.Mutex
+	returned := make(map[int][]net.Connection)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 10; i++ {
+				p := r.Intn(peerCnt)
+				conn, err := cPool.GetConnection(peers[p].addr, peers[p].key)
+				assert.Nil(t, err)
+				mtx.Lock()
+				returned[p] = append(returned[p], conn)
+				mtx.Unlock()
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+
+	cPool.connMutex.RLock()
+	poolSize := len(cPool.connections)
+	cPool.connMutex.RUnlock()
+	assert.True(t, poolSize <= maxConns, "pool holds %d connections, cap is %d", poolSize, maxConns)
+
+	// evicted connections were closed, so each peer has at most one live one
+	for p, conns := range returned {
+		live := make(map[string]bool)
+		for _, conn := range conns {
+			if !conn.(*net.ConnectionMock).IsClosed() {
+				live[conn.ID()] = true
+			}
+		}
+		assert.True(t, len(live) <= 1, "peer %d has %d live connections", p, len(live))
+	}
+
+	cPool.Shutdown()
+	// everything the pool spawned should wind down
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= before+2, "goroutines leaked: %d before, %d after", before, runtime.NumGoroutine())
+}
+
 func TestRandom(t *testing.T) {
 	type Peer struct {
 		key  crypto.PublicKey
@@ -258,6 +458,7 @@ func TestRandom(t *testing.T) {
 	nMock.SetDialDelayMs(50)
 	nMock.SetDialResult(nil)
 	cPool := NewConnectionPool(nMock, generatePublicKey())
+	cPool.SetReconnectPolicy(2, 20*time.Millisecond)
 	rand.Seed(time.Now().UnixNano())
 	for {
 		r := rand.Int31n(3)
@@ -274,14 +475,20 @@ func TestRandom(t *testing.T) {
 			go func() {
 				peer := peers[rand.Int31n(int32(peerCnt))]
 				conn, err := cPool.GetConnection(peer.addr, peer.key)
-				assert.Nil(t, err)
+				if err != nil {
+					// the pool may be re-establishing the connection itself
+					assert.Equal(t, ErrReconnecting, err)
+					return
+				}
 				nMock.PublishClosingConnection(conn)
 			}()
 		} else {
 			go func() {
 				peer := peers[rand.Int31n(int32(peerCnt))]
 				_, err := cPool.GetConnection(peer.addr, peer.key)
-				assert.Nil(t, err)
+				if err != nil {
+					assert.Equal(t, ErrReconnecting, err)
+				}
 			}()
 		}
 		time.Sleep(10 * time.Millisecond)
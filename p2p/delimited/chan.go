@@ -12,6 +12,9 @@ type Chan struct {
 	connection io.ReadWriteCloser
 	closeOnce  sync.Once
 
+	maxFrameSize int
+	readErr      error // why the incoming side shut down, set before inMsgChan closes
+
 	outMsgChan chan outMessage
 	inMsgChan  chan []byte
 	CloseChan  chan struct{}
@@ -51,12 +54,26 @@ func (om outMessage) Result() chan error {
 // NewChan constructs a Chan with a given buffer size.
 func NewChan(chanSize int) *Chan {
 	return &Chan{
-		outMsgChan: make(chan outMessage, chanSize),
-		inMsgChan:  make(chan []byte, chanSize),
-		CloseChan:  make(chan struct{}),
+		maxFrameSize: DefaultMaxFrameSize,
+		outMsgChan:   make(chan outMessage, chanSize),
+		inMsgChan:    make(chan []byte, chanSize),
+		CloseChan:    make(chan struct{}),
 	}
 }
 
+// SetMaxFrameSize overrides the frame size limit enforced on both incoming
+// and outgoing records. A non-positive limit disables the check. Must be
+// called before Pipe.
+func (s *Chan) SetMaxFrameSize(maxFrameSize int) {
+	s.maxFrameSize = maxFrameSize
+}
+
+// Err reports why the incoming message channel was closed, if the reason was
+// a framing violation rather than a plain stream end.
+func (s *Chan) Err() error {
+	return s.readErr
+}
+
 // Pipe invokes the reader and writer flows, once it's ran Chan can start serving incoming/outgoing messages
 func (s *Chan) Pipe(rwc io.ReadWriteCloser) {
 	s.connection = rwc
@@ -68,12 +85,15 @@ func (s *Chan) Pipe(rwc io.ReadWriteCloser) {
 // messages, ands sends them down the channel.
 func (s *Chan) readFromReader(r io.Reader) {
 
-	mr := NewReader(r)
+	mr := NewReaderMaxSize(r, s.maxFrameSize)
 	// single reader, no need for Mutex
 Loop:
 	for {
 		buf, err := mr.Next()
 		if err != nil {
+			if frameErr, ok := err.(*FrameTooLargeError); ok {
+				s.readErr = frameErr
+			}
 			break Loop
 		}
 
@@ -99,7 +119,7 @@ Loop:
 func (s *Chan) writeToWriter(w io.Writer) {
 	// new buffer per message
 	// if bottleneck, cycle around a set of buffers
-	mw := NewWriter(w)
+	mw := NewWriterMaxSize(w, s.maxFrameSize)
 
 	// single writer, no need for Mutex
 Loop:
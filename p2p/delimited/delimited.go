@@ -31,6 +31,23 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
+// DefaultMaxFrameSize bounds how large a single frame may be, on both the
+// encode and decode side. A frame larger than this is refused before any
+// allocation happens - legitimate payloads approaching this size should be
+// split into smaller records and streamed instead.
+const DefaultMaxFrameSize = 16 << 20 // 16MB
+
+// FrameTooLargeError is returned when a frame's declared length exceeds the
+// configured maximum frame size.
+type FrameTooLargeError struct {
+	Size  uint64
+	Limit uint64
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("frame of %d bytes exceeds the %d bytes limit", e.Size, e.Limit)
+}
+
 // Reader consumes length-delimited records from a byte source.
 //
 // Usage:
@@ -46,8 +63,9 @@ import (
 //   }
 //
 type Reader struct {
-	buf  *bufio.Reader
-	data []byte
+	buf     *bufio.Reader
+	data    []byte
+	maxSize uint64
 }
 
 // Next returns the next length-delimited record from the input, or io.EOF if
@@ -62,6 +80,11 @@ func (r *Reader) Next() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	// compare against the limit before allocating - the length prefix is
+	// attacker-controlled
+	if r.maxSize > 0 && size > r.maxSize {
+		return nil, &FrameTooLargeError{Size: size, Limit: r.maxSize}
+	}
 	if cap(r.data) < int(size) {
 		r.data = make([]byte, size)
 	} else {
@@ -84,8 +107,22 @@ func (r *Reader) NextProto(pb proto.Message) error {
 	return proto.Unmarshal(rec, pb)
 }
 
-// NewReader constructs a new delimited Reader for the records in r.
-func NewReader(r io.Reader) *Reader { return &Reader{buf: bufio.NewReader(r)} }
+// NewReader constructs a new delimited Reader for the records in r, refusing
+// frames larger than DefaultMaxFrameSize.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{buf: bufio.NewReader(r), maxSize: DefaultMaxFrameSize}
+}
+
+// NewReaderMaxSize constructs a delimited Reader with a custom frame size
+// limit. A non-positive limit disables the check.
+func NewReaderMaxSize(r io.Reader, maxSize int) *Reader {
+	rd := NewReader(r)
+	rd.maxSize = uint64(0)
+	if maxSize > 0 {
+		rd.maxSize = uint64(maxSize)
+	}
+	return rd
+}
 
 // A Writer outputs delimited records to an io.Writer.
 //
@@ -98,7 +135,8 @@ func NewReader(r io.Reader) *Reader { return &Reader{buf: bufio.NewReader(r)} }
 //   }
 //
 type Writer struct {
-	w io.Writer
+	w       io.Writer
+	maxSize uint64
 }
 
 // Put writes the specified record to the writer.  It equivalent to
@@ -120,6 +158,9 @@ func (w Writer) PutProto(msg proto.Message) error {
 // WriteRecord writes the specified record to the underlying writer, returning
 // the total number of bytes written including the length tag.
 func (w Writer) WriteRecord(record []byte) (int, error) {
+	if w.maxSize > 0 && uint64(len(record)) > w.maxSize {
+		return 0, &FrameTooLargeError{Size: uint64(len(record)), Limit: w.maxSize}
+	}
 	var buf [binary.MaxVarintLen64]byte
 	v := binary.PutUvarint(buf[:], uint64(len(record)))
 
@@ -134,5 +175,17 @@ func (w Writer) WriteRecord(record []byte) (int, error) {
 	return nw + dw, nil
 }
 
-// NewWriter constructs a new delimited Writer that writes records to w.
-func NewWriter(w io.Writer) *Writer { return &Writer{w: w} }
+// NewWriter constructs a new delimited Writer that writes records to w,
+// refusing records larger than DefaultMaxFrameSize.
+func NewWriter(w io.Writer) *Writer { return &Writer{w: w, maxSize: DefaultMaxFrameSize} }
+
+// NewWriterMaxSize constructs a delimited Writer with a custom frame size
+// limit. A non-positive limit disables the check.
+func NewWriterMaxSize(w io.Writer, maxSize int) *Writer {
+	wr := NewWriter(w)
+	wr.maxSize = uint64(0)
+	if maxSize > 0 {
+		wr.maxSize = uint64(maxSize)
+	}
+	return wr
+}
@@ -18,9 +18,11 @@ package delimited
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"io"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -59,6 +61,66 @@ func TestCorruptReader(t *testing.T) {
 	t.Logf("Next record gave expected error: %v", err)
 }
 
+func TestReaderFrameTooLarge(t *testing.T) {
+	// a crafted prefix declaring a 2GB frame, followed by a few bytes
+	var prefix [binary.MaxVarintLen64]byte
+	v := binary.PutUvarint(prefix[:], 2<<30)
+	crafted := append(prefix[:v], 'A', 'B', 'C')
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	rd := NewReader(bytes.NewReader(crafted))
+	got, err := rd.Next()
+	runtime.ReadMemStats(&after)
+
+	frameErr, ok := err.(*FrameTooLargeError)
+	if !ok {
+		t.Fatalf("Next record: got %q [%v], want a FrameTooLargeError", string(got), err)
+	}
+	if frameErr.Size != 2<<30 || frameErr.Limit != DefaultMaxFrameSize {
+		t.Errorf("FrameTooLargeError: got size %d limit %d, want size %d limit %d", frameErr.Size, frameErr.Limit, 2<<30, DefaultMaxFrameSize)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("refusing the frame allocated %d bytes, want a bounded amount", allocated)
+	}
+}
+
+func TestReaderCustomLimit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).Put(bytes.Repeat([]byte{'x'}, 8)); err != nil {
+		t.Fatalf("Put: unexpected error: %v", err)
+	}
+
+	// a record exactly at the limit passes
+	rd := NewReaderMaxSize(bytes.NewReader(buf.Bytes()), 8)
+	if _, err := rd.Next(); err != nil {
+		t.Errorf("Next record at the limit: unexpected error: %v", err)
+	}
+
+	// one byte over it does not
+	rd = NewReaderMaxSize(bytes.NewReader(buf.Bytes()), 7)
+	if _, err := rd.Next(); err == nil {
+		t.Error("Next record over the limit: got nil, want a FrameTooLargeError")
+	}
+}
+
+func TestWriterFrameTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterMaxSize(&buf, 10)
+
+	err := w.Put(make([]byte, 11))
+	if _, ok := err.(*FrameTooLargeError); !ok {
+		t.Fatalf("Put oversized record: got %v, want a FrameTooLargeError", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("oversized record wrote %d bytes, want none", buf.Len())
+	}
+	if err := w.Put(make([]byte, 10)); err != nil {
+		t.Errorf("Put record at the limit: unexpected error: %v", err)
+	}
+}
+
 func TestGoodWriter(t *testing.T) {
 	var w bytes.Buffer
 	wr := NewWriter(&w)
@@ -0,0 +1,66 @@
+package dht
+
+import (
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+)
+
+// Ban marks the node with the given base-58 id as banned. It is evicted from
+// the routing table and will not be re-admitted, selected or queried until
+// Unban is called.
+func (d *KadDHT) Ban(id string) {
+	d.banMutex.Lock()
+	d.banned[id] = time.Time{}
+	d.banMutex.Unlock()
+
+	d.evictBanned(id)
+}
+
+// BanFor bans a node like Ban, with the ban lifting itself after dur.
+func (d *KadDHT) BanFor(id string, dur time.Duration) {
+	d.banMutex.Lock()
+	d.banned[id] = d.clock.Now().Add(dur)
+	d.banMutex.Unlock()
+
+	d.evictBanned(id)
+}
+
+// Unban lifts a ban, making the node eligible for the routing table again.
+func (d *KadDHT) Unban(id string) {
+	d.banMutex.Lock()
+	delete(d.banned, id)
+	d.banMutex.Unlock()
+}
+
+// isBanned reports whether the node with the given base-58 id is currently
+// banned, forgetting bans whose expiry has passed.
+func (d *KadDHT) isBanned(id string) bool {
+	d.banMutex.Lock()
+	defer d.banMutex.Unlock()
+
+	until, ok := d.banned[id]
+	if !ok {
+		return false
+	}
+	if until.IsZero() || d.clock.Now().Before(until) {
+		return true
+	}
+
+	// the ban expired - forget it
+	delete(d.banned, id)
+	return false
+}
+
+// removes a freshly banned peer from the routing table
+func (d *KadDHT) evictBanned(id string) {
+	pub, err := crypto.NewPublicKeyFromString(id)
+	if err != nil {
+		// an id that doesn't parse can't match a table entry anyway
+		d.local.Log.With(log.NodeID(d.local.String()), log.String("err", err.Error())).Warning("banned an unparsable node id")
+		return
+	}
+	d.rt.Remove(node.New(pub, ""))
+}
@@ -0,0 +1,91 @@
+package dht
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+// block until the routing table reports the expected size, or fail
+func waitForSize(t *testing.T, d DHT, size int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for d.Size() != size {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected table size %v, have %v", size, d.Size())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDHT_Ban(t *testing.T) {
+	sim := simulator.New()
+	ln, _ := node.GenerateTestNode(t)
+	dht, err := New(ln, config.DefaultConfig().SwarmConfig, sim.NewNodeFrom(ln.Node))
+	assert.NoError(t, err)
+
+	peers := node.GenerateRandomNodesData(5)
+	for _, p := range peers {
+		dht.Update(p)
+	}
+	waitForSize(t, dht, len(peers))
+
+	outlaw := peers[0]
+	dht.Ban(outlaw.String())
+
+	// the ban evicts the peer from the table
+	waitForSize(t, dht, len(peers)-1)
+
+	// a banned peer never comes out of SelectPeers
+	for i := 0; i < 20; i++ {
+		for _, p := range dht.SelectPeers(len(peers)) {
+			assert.NotEqual(t, outlaw.String(), p.String(), "expected the banned peer to never be selected")
+		}
+	}
+
+	// updates for a banned peer are rejected before reaching the table
+	dht.Update(outlaw)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, len(peers)-1, dht.Size(), "expected the banned peer to stay out of the table")
+
+	// unbanning restores eligibility
+	dht.Unban(outlaw.String())
+	dht.Update(outlaw)
+	waitForSize(t, dht, len(peers))
+
+	selected := false
+	for i := 0; i < 100 && !selected; i++ {
+		for _, p := range dht.SelectPeers(len(peers)) {
+			if p.String() == outlaw.String() {
+				selected = true
+			}
+		}
+	}
+	assert.True(t, selected, "expected the unbanned peer to be selectable again")
+}
+
+func TestDHT_BanFor(t *testing.T) {
+	sim := simulator.New()
+	ln, _ := node.GenerateTestNode(t)
+	dht, err := New(ln, config.DefaultConfig().SwarmConfig, sim.NewNodeFrom(ln.Node))
+	assert.NoError(t, err)
+
+	clock := simulator.NewVirtualClock(time.Now())
+	dht.SetClock(clock)
+
+	outlaw := node.GenerateRandomNodeData()
+	dht.BanFor(outlaw.String(), time.Hour)
+
+	dht.Update(outlaw)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, dht.Size(), "expected the banned peer to be rejected")
+
+	// once the ban expires the peer is admitted again
+	clock.AdvanceTime(2 * time.Hour)
+	dht.Update(outlaw)
+	waitForSize(t, dht, 1)
+}
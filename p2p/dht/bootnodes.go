@@ -0,0 +1,70 @@
+package dht
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+)
+
+// Resolver resolves a hostname to its addresses. Production code uses
+// net.LookupHost, tests inject a stub.
+type Resolver func(host string) ([]string, error)
+
+// bootstrapEntry tracks a single configured bootstrap node - the entry as
+// written in the config, which may carry a DNS name, plus its current
+// resolution state. Keeping the name around lets the bootstrap loop
+// re-resolve entries that failed or went stale.
+type bootstrapEntry struct {
+	configured node.Node
+	candidates []node.Node // one candidate per resolved address, the node itself for a literal ip
+	err        error       // last resolution failure, nil once resolution succeeds
+}
+
+// resolve (re)computes the entry's candidates through r. A literal ip needs
+// no lookup. A DNS name may fan out into several candidates when it carries
+// multiple A records - each shares the configured key and port. A lookup
+// that yields no address at all counts as a failure.
+func (e *bootstrapEntry) resolve(r Resolver) {
+	parsed := e.configured.ParsedAddress()
+
+	if ip := net.ParseIP(parsed.Host); ip != nil {
+		e.candidates = []node.Node{e.configured}
+		e.err = nil
+		return
+	}
+
+	addrs, err := r(parsed.Host)
+	if err == nil && len(addrs) == 0 {
+		err = fmt.Errorf("host %v resolved to no addresses", parsed.Host)
+	}
+	if err != nil {
+		e.candidates = nil
+		e.err = err
+		return
+	}
+
+	candidates := make([]node.Node, 0, len(addrs))
+	for _, ip := range addrs {
+		addr := node.Address{Scheme: parsed.Scheme, Host: ip, Port: parsed.Port}
+		candidates = append(candidates, node.New(e.configured.PublicKey(), addr.String()))
+	}
+	e.candidates = candidates
+	e.err = nil
+}
+
+// unresolvedError reports the entries still stuck on dns resolution, or nil
+// when there are none.
+func unresolvedError(entries []*bootstrapEntry) error {
+	var failed []string
+	for _, e := range entries {
+		if e.err != nil {
+			failed = append(failed, fmt.Sprintf("%v (%v)", e.configured.Address(), e.err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("unresolved bootstrap nodes: %v", strings.Join(failed, "; "))
+}
@@ -0,0 +1,96 @@
+package dht
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubResolver resolves only the hosts in its table, anything else is an
+// nxdomain-style error.
+func stubResolver(hosts map[string][]string) Resolver {
+	return func(host string) ([]string, error) {
+		addrs, ok := hosts[host]
+		if !ok {
+			return nil, fmt.Errorf("no such host %v", host)
+		}
+		return addrs, nil
+	}
+}
+
+func TestBootstrapEntryResolveLiteralIP(t *testing.T) {
+	n := node.GenerateRandomNodeData()
+
+	e := &bootstrapEntry{configured: n}
+	e.resolve(stubResolver(nil)) // a literal ip must not hit the resolver
+
+	assert.NoError(t, e.err)
+	assert.Equal(t, []node.Node{n}, e.candidates)
+}
+
+func TestBootstrapEntryResolveMultipleARecords(t *testing.T) {
+	key := node.GenerateRandomNodeData().PublicKey()
+	configured := node.New(key, "boot.spacemesh.io:3572")
+
+	e := &bootstrapEntry{configured: configured}
+	e.resolve(stubResolver(map[string][]string{
+		"boot.spacemesh.io": {"10.0.0.1", "10.0.0.2"},
+	}))
+
+	assert.NoError(t, e.err)
+	assert.Equal(t, 2, len(e.candidates), "each A record should yield a candidate")
+	assert.Equal(t, "10.0.0.1:3572", e.candidates[0].Address())
+	assert.Equal(t, "10.0.0.2:3572", e.candidates[1].Address())
+	for _, c := range e.candidates {
+		assert.Equal(t, key.String(), c.PublicKey().String(), "candidates share the configured identity")
+	}
+}
+
+func TestBootstrapEntryResolveFailureAndRecovery(t *testing.T) {
+	configured := node.New(node.GenerateRandomNodeData().PublicKey(), "boot.spacemesh.io:3572")
+
+	e := &bootstrapEntry{configured: configured}
+	e.resolve(stubResolver(nil))
+	assert.Error(t, e.err, "nxdomain is tracked on the entry")
+	assert.Equal(t, 0, len(e.candidates))
+
+	// the record shows up later - a re-resolve clears the failure
+	e.resolve(stubResolver(map[string][]string{"boot.spacemesh.io": {"10.0.0.7"}}))
+	assert.NoError(t, e.err)
+	assert.Equal(t, 1, len(e.candidates))
+	assert.Equal(t, "10.0.0.7:3572", e.candidates[0].Address())
+}
+
+func TestBootstrapEntryResolveEmptyAnswer(t *testing.T) {
+	configured := node.New(node.GenerateRandomNodeData().PublicKey(), "boot.spacemesh.io:3572")
+
+	e := &bootstrapEntry{configured: configured}
+	e.resolve(stubResolver(map[string][]string{"boot.spacemesh.io": {}}))
+
+	assert.Error(t, e.err, "a lookup with no addresses counts as a failure")
+	assert.Equal(t, 0, len(e.candidates))
+}
+
+func TestDHT_BootstrapReportsUnresolvedNames(t *testing.T) {
+	ln, _ := node.GenerateTestNode(t)
+
+	cfg := config.DefaultConfig().SwarmConfig
+	cfg.RandomConnections = 1
+	cfg.BootstrapNodes = []string{"boot.spacemesh.io:3572/" + node.GenerateRandomNodeData().PublicKey().String()}
+
+	sim := simulator.New()
+	n1 := sim.NewNodeFrom(ln.Node)
+
+	d, err := New(ln, cfg, n1)
+	assert.NoError(t, err)
+	d.SetResolver(stubResolver(nil))
+
+	err = d.Bootstrap(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "boot.spacemesh.io", "the error should name the unresolved host")
+}
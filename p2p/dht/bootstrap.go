@@ -3,16 +3,23 @@ package dht
 import (
 	"context"
 	"errors"
+	"fmt"
+	"github.com/spacemeshos/go-spacemesh/journal"
+	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	// BootstrapTimeout is the maximum time we allow the bootstrap process to extend
+	// BootstrapTimeout is the default maximum time we allow the bootstrap
+	// process to extend, overridable with the swarm.bootstrap-timeout config.
 	BootstrapTimeout = 5 * time.Minute
-	// LookupIntervals is the time we wait between another kad lookup if bootstrap failed.
+	// LookupIntervals is the default time we wait between another kad lookup
+	// if bootstrap failed, overridable with the swarm.lookup-interval config.
 	LookupIntervals = 3 * time.Second
-	// RefreshInterval is the time we wait between dht refreshes
+	// RefreshInterval is the default time we wait between dht refreshes,
+	// overridable with the swarm.refresh-interval config.
 	RefreshInterval = 5 * time.Minute
 )
 
@@ -23,8 +30,6 @@ var (
 	ErrConnectToBootNode = errors.New("failed to read or connect to any boot node")
 	// ErrFoundOurself is returned when a node sends us ourselves.
 	ErrFoundOurself = errors.New("found ourselves in the routing table")
-	// ErrFailedToBoot is returned when we exceed the BootstrapTimeout
-	ErrFailedToBoot = errors.New("failed to bootstrap within time limit")
 	// ErrBootAbort is returned when when bootstrap is canceled by context cancel
 	ErrBootAbort = errors.New("Bootstrap canceled by signal")
 )
@@ -32,49 +37,87 @@ var (
 // Bootstrap issues a bootstrap by inserting the preloaded nodes to the routing table then querying them with our
 // ID with a FindNode (using `dht.Lookup`). the process involves updating all returned nodes to the routing table
 // while all the nodes that receive our query will add us to their routing tables and send us as response to a `FindNode`.
+// Cancelling ctx aborts the process with ErrBootAbort; once ctx's deadline or,
+// for callers that didn't set one, the configured bootstrap timeout passes, it
+// returns context.DeadlineExceeded.
 func (d *KadDHT) Bootstrap(ctx context.Context) error {
+	d.local.Journal().Note(journal.EventBootstrapStarted, "", "")
+	err := d.bootstrap(ctx)
+	outcome := "succeeded"
+	if err != nil {
+		outcome = err.Error()
+	} else {
+		atomic.AddInt64(&d.bootstrapCount, 1)
+	}
+	d.local.Journal().Note(journal.EventBootstrapFinished, "", outcome)
+	return err
+}
 
-	d.local.Debug("Starting node bootstrap ", d.local.String())
+func (d *KadDHT) bootstrap(ctx context.Context) error {
+
+	d.local.Log.With(log.NodeID(d.local.String())).Debug("starting node bootstrap")
 
 	c := d.config.RandomConnections
 	if c <= 0 {
 		return ErrZeroConnections
 	}
-	// register bootstrap nodes
+	// register bootstrap nodes, resolving dns names to addresses as we go.
+	// an entry that fails to resolve is only a warning - the node may have
+	// come up before dns - but it is kept around for re-resolution below.
+	entries := make([]*bootstrapEntry, 0, len(d.config.BootstrapNodes))
 	bn := 0
-	for _, n := range d.config.BootstrapNodes {
-		node, err := node.NewNodeFromString(n)
+	for i, n := range d.config.BootstrapNodes {
+		parsed, err := node.NewNodeFromString(n)
 		if err != nil {
-			// TODO : handle errors
-			continue
+			// a bad entry is a config mistake - point at it instead of silently skipping it
+			return fmt.Errorf("invalid bootstrap node at entry %d (%v): %v", i, n, err)
+		}
+		e := &bootstrapEntry{configured: parsed}
+		e.resolve(d.resolver)
+		if e.err != nil {
+			d.local.Log.With(log.NodeID(d.local.String()), log.String("address", parsed.Address()), log.String("err", e.err.Error())).Warning("failed resolving bootstrap node")
 		}
-		d.rt.Update(node)
-		bn++
+		for _, c := range e.candidates {
+			d.rt.Update(c)
+			bn++
+		}
+		entries = append(entries, e)
 	}
 
 	if bn == 0 {
+		if err := unresolvedError(entries); err != nil {
+			return err
+		}
 		return ErrConnectToBootNode
 	}
 
-	d.local.Debug("lookup using %d preloaded bootnodes ", bn)
+	d.local.Log.With(log.NodeID(d.local.String()), log.Int("bootnodes", bn)).Debug("lookup using preloaded bootnodes")
 
-	timeout := time.NewTimer(BootstrapTimeout)
+	// the configured bootstrap timeout serves as the deadline for callers that
+	// didn't put one on their context
+	timeout := d.clock.After(d.config.BootstrapTimeoutOrDefault())
 	i := 0
 	// TODO: Issue a healthcheck / refresh loop every x interval.
 BOOTLOOP:
 	for {
-		reschan := make(chan error)
+		// buffered, so an abandoned lookup doesn't leak its goroutine
+		reschan := make(chan error, 1)
 
 		go func() {
-			_, err := d.Lookup(d.local.PublicKey().String())
+			// passing the caller's context down aborts the iterative walk and
+			// its pending findnode requests as soon as bootstrap gives up
+			_, err := d.LookupContext(ctx, d.local.PublicKey().String())
 			reschan <- err
 		}()
 
 		select {
 		case <-ctx.Done():
+			if err := ctx.Err(); err == context.DeadlineExceeded {
+				return err
+			}
 			return ErrBootAbort
-		case <-timeout.C:
-			return ErrFailedToBoot
+		case <-timeout:
+			return context.DeadlineExceeded
 		case err := <-reschan:
 			i++
 			if err == nil {
@@ -88,20 +131,31 @@ BOOTLOOP:
 			if (size - bn) >= c { // Don't count bootstrap nodes
 				break BOOTLOOP
 			}
-			d.local.Warning("%d lookup didn't bootstrap the routing table", i)
-			d.local.Warning("RT now has %d peers", size-bn)
-			time.Sleep(LookupIntervals)
+			d.local.Log.With(log.NodeID(d.local.String()), log.Int("lookups", i), log.Int("table_size", size-bn)).Warning("lookup didn't bootstrap the routing table")
+			// a failed round may be down to bootstrap names that didn't
+			// resolve at load time - retry those before the next lookup
+			for _, e := range entries {
+				if e.err == nil {
+					continue
+				}
+				e.resolve(d.resolver)
+				for _, c := range e.candidates {
+					d.rt.Update(c)
+					bn++
+				}
+			}
+			d.clock.Sleep(d.config.LookupIntervalOrDefault())
 		}
 	}
 	return nil // succeed
 }
 
 func (d *KadDHT) healthLoop() {
-	tick := time.NewTicker(RefreshInterval)
+	tick := time.NewTicker(d.config.RefreshIntervalOrDefault())
 	for range tick.C {
 		err := d.refresh()
 		if err != nil {
-			d.local.Error("DHT Refresh failed, trying again")
+			d.local.Log.With(log.NodeID(d.local.String())).Error("dht refresh failed, trying again")
 		}
 	}
 }
@@ -10,6 +10,7 @@ import (
 type Bucket interface {
 	Peers() []node.Node
 	Front() node.Node
+	Back() node.Node
 	Has(n node.Node) bool
 	Remove(n node.Node) bool
 	MoveToFront(n node.Node)
@@ -37,6 +38,16 @@ func (b *bucketimpl) Front() node.Node {
 	return b.list.Front().Value.(node.Node)
 }
 
+// Back returns the identity at the back of the bucket - the least-recently
+// seen one - without removing it, or node.EmptyNode for an empty bucket.
+func (b *bucketimpl) Back() node.Node {
+	last := b.list.Back()
+	if last == nil {
+		return node.EmptyNode
+	}
+	return last.Value.(node.Node)
+}
+
 // Peers returns a slice of RemoteNodeData for the peers stored in the bucket.
 func (b *bucketimpl) Peers() []node.Node {
 	ps := make([]node.Node, 0, b.list.Len())
@@ -7,7 +7,13 @@ import (
 
 	"context"
 	"errors"
+	"github.com/spacemeshos/go-spacemesh/filesystem"
+	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/timesync"
+	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,11 +21,19 @@ import (
 type DHT interface {
 	Update(node node.Node)
 	Lookup(pubkey string) (node.Node, error)
+	LookupContext(ctx context.Context, pubkey string) (node.Node, error)
 
 	SelectPeers(qty int) []node.Node
 	Bootstrap(ctx context.Context) error
 
+	Ping(target node.Node) error
+
+	Ban(id string)
+	Unban(id string)
+
 	Size() int
+
+	Shutdown()
 }
 
 // LookupTimeout is the timelimit we give to a single lookup operation
@@ -39,10 +53,31 @@ type KadDHT struct {
 
 	local *node.LocalNode
 
-	rt  RoutingTable
-	fnp *findNodeProtocol
+	rt   RoutingTable
+	fnp  *findNodeProtocol
+	ping *pingProtocol
 
 	service service.Service
+
+	// clock drives the bootstrap timers - tests inject a virtual clock here
+	clock timesync.Clock
+
+	// resolver turns bootstrap node hostnames into addresses - tests inject a stub
+	resolver Resolver
+
+	// banned peers by base-58 id - a zero time means banned until Unban,
+	// anything else is the ban's expiry
+	banned   map[string]time.Time
+	banMutex sync.Mutex
+
+	// health counters behind Stats, updated atomically
+	lookupCount    int64
+	lookupErrors   int64
+	lookupLatency  int64 // accumulated lookup wall time, in nanoseconds
+	bootstrapCount int64
+
+	// closed on Shutdown, stopping the background goroutines
+	shutdown chan struct{}
 }
 
 func (d *KadDHT) Size() int {
@@ -52,29 +87,111 @@ func (d *KadDHT) Size() int {
 }
 
 func (d *KadDHT) SelectPeers(qty int) []node.Node {
-	return d.rt.SelectPeers(qty)
+	peers := d.rt.SelectPeers(qty)
+
+	filtered := make([]node.Node, 0, len(peers))
+	for _, p := range peers {
+		if d.isBanned(p.String()) {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+	return filtered
 }
 
-// New creates a new dht
-func New(node *node.LocalNode, config config.SwarmConfig, service service.Service) *KadDHT {
+// New creates a new dht. It refuses a swarm config that would crash the
+// routing table or hang bootstrap.
+func New(node *node.LocalNode, config config.SwarmConfig, service service.Service) (*KadDHT, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	d := &KadDHT{
-		config:  config,
-		local:   node,
-		rt:      NewRoutingTable(config.RoutingTableBucketSize, node.DhtID(), node.Logger),
-		service: service,
+		config:   config,
+		local:    node,
+		rt:       NewRoutingTable(config.RoutingTableBucketSize, node.DhtID(), node.Logger),
+		service:  service,
+		clock:    timesync.RealClock{},
+		resolver: net.LookupHost,
+		banned:   make(map[string]time.Time),
+		shutdown: make(chan struct{}),
 	}
 	d.fnp = newFindNodeProtocol(service, d.rt)
-	return d
+	d.ping = newPingProtocol(service, config.PingTimeoutOrDefault())
+
+	// the routing table challenges the least-recently seen peer of a full
+	// bucket with a ping before evicting it
+	d.rt.SetPinger(d)
+
+	// a persisted routing table spares the cold-start bootstrap - a missing
+	// or unreadable snapshot is only worth a warning, the node boots cold
+	if config.RoutingTableFile != "" && filesystem.Active.Exists(config.RoutingTableFile) {
+		if err := d.Load(config.RoutingTableFile); err != nil {
+			node.Log.With(log.NodeID(node.String()), log.String("err", err.Error())).Warning("failed loading the persisted routing table")
+		}
+	}
+	// keep sparsely used buckets populated with periodic lookups
+	d.startBucketRefresh(config.BucketRefreshIntervalOrDefault())
+
+	return d, nil
+}
+
+// Shutdown stops the dht's background goroutines, such as the periodic bucket
+// refresh. It must be called at most once.
+func (d *KadDHT) Shutdown() {
+	close(d.shutdown)
+}
+
+// SetClock replaces the clock driving the bootstrap timers, so simulations
+// can put the dht on a virtual clock.
+func (d *KadDHT) SetClock(c timesync.Clock) {
+	d.clock = c
+}
+
+// SetResolver replaces the dns resolver used for bootstrap node entries, so
+// tests can stub resolution.
+func (d *KadDHT) SetResolver(r Resolver) {
+	d.resolver = r
 }
 
 // Update insert or update a node in the routing table.
+// A banned node is silently rejected before reaching the table.
 func (d *KadDHT) Update(node node.Node) {
+	if d.isBanned(node.String()) {
+		return
+	}
 	d.rt.Update(node)
 }
 
+// Ping checks the liveness of target by sending it a ping message and waiting
+// for the pong up to the configured ping timeout.
+func (d *KadDHT) Ping(target node.Node) error {
+	return d.ping.Ping(target)
+}
+
 // Lookup finds a node in the dht by its public key, it issues a search inside the local routing table,
 // if the node can't be found there it sends a query to the network.
 func (d *KadDHT) Lookup(pubkey string) (node.Node, error) {
+	return d.LookupContext(context.Background(), pubkey)
+}
+
+// LookupContext is Lookup with a cancellation path - the iterative walk is
+// abandoned as soon as ctx is cancelled or its deadline expires, and the
+// context's error is returned.
+func (d *KadDHT) LookupContext(ctx context.Context, pubkey string) (node.Node, error) {
+	start := time.Now()
+	n, err := d.lookupContext(ctx, pubkey)
+	atomic.AddInt64(&d.lookupCount, 1)
+	atomic.AddInt64(&d.lookupLatency, int64(time.Since(start)))
+	if err != nil {
+		atomic.AddInt64(&d.lookupErrors, 1)
+	}
+	return n, err
+}
+
+func (d *KadDHT) lookupContext(ctx context.Context, pubkey string) (node.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return node.EmptyNode, err
+	}
 	dhtid := node.NewDhtIDFromBase58(pubkey)
 	poc := make(PeersOpChannel)
 	d.rt.NearestPeers(NearestPeersReq{dhtid, d.config.RoutingTableAlpha, poc})
@@ -87,7 +204,7 @@ func (d *KadDHT) Lookup(pubkey string) (node.Node, error) {
 		return res[0], nil
 	}
 
-	return d.kadLookup(pubkey, res)
+	return d.kadLookup(ctx, pubkey, res)
 }
 
 // Implements the kad algo for locating a remote node
@@ -95,13 +212,18 @@ func (d *KadDHT) Lookup(pubkey string) (node.Node, error) {
 // nodeId: - base58 node id string
 // Returns requested node via the callback or nil if not found
 // Also used as a bootstrap function to populate the routing table with the results.
-func (d *KadDHT) kadLookup(id string, searchList []node.Node) (node.Node, error) {
+func (d *KadDHT) kadLookup(ctx context.Context, id string, searchList []node.Node) (node.Node, error) {
 	// save queried node ids for the operation
 	queried := map[string]struct{}{}
 
 	// iterative lookups for nodeId using searchList
 
 	for {
+		// the caller gave up - stop walking
+		if err := ctx.Err(); err != nil {
+			return node.EmptyNode, err
+		}
+
 		// if no new nodes found
 		if len(searchList) == 0 {
 			break
@@ -115,7 +237,7 @@ func (d *KadDHT) kadLookup(id string, searchList []node.Node) (node.Node, error)
 
 		// pick up to alpha servers to query from the search list
 		// servers that have been recently queried will not be returned
-		servers := filterFindNodeServers(searchList, queried, d.config.RoutingTableAlpha)
+		servers := d.filterFindNodeServers(searchList, queried, d.config.RoutingTableAlpha)
 
 		if len(servers) == 0 {
 			// no more servers to query
@@ -124,7 +246,7 @@ func (d *KadDHT) kadLookup(id string, searchList []node.Node) (node.Node, error)
 		}
 
 		// lookup nodeId using the target servers
-		res := d.findNodeOp(servers, queried, id, closestNode)
+		res := d.findNodeOp(ctx, servers, queried, id, closestNode)
 		if len(res) > 0 {
 
 			// merge newly found nodes
@@ -138,23 +260,19 @@ func (d *KadDHT) kadLookup(id string, searchList []node.Node) (node.Node, error)
 	return node.EmptyNode, ErrLookupFailed
 }
 
-// filterFindNodeServers picks up to count server who haven't been queried recently.
-func filterFindNodeServers(nodes []node.Node, queried map[string]struct{}, alpha int) []node.Node {
-
-	// If no server have been queried already, just make sure the list len is alpha
-	if len(queried) == 0 {
-		if len(nodes) > alpha {
-			nodes = nodes[:alpha]
-		}
-		return nodes
-	}
+// filterFindNodeServers picks up to alpha servers who haven't been queried
+// recently and aren't banned.
+func (d *KadDHT) filterFindNodeServers(nodes []node.Node, queried map[string]struct{}, alpha int) []node.Node {
 
-	// filter out queried servers.
+	// filter out queried and banned servers.
 	i := 0
 	for _, v := range nodes {
 		if _, exist := queried[v.String()]; exist {
 			continue
 		}
+		if d.isBanned(v.String()) {
+			continue
+		}
 
 		nodes[i] = v
 		i++
@@ -170,15 +288,15 @@ func filterFindNodeServers(nodes []node.Node, queried map[string]struct{}, alpha
 // findNodeOp a target node on one or more servers
 // returns closest nodes which are closers than closestNode to targetId
 // if node found it will be in top of results list
-func (d *KadDHT) findNodeOp(servers []node.Node, queried map[string]struct{}, id string, closestNode node.Node) []node.Node {
+func (d *KadDHT) findNodeOp(ctx context.Context, servers []node.Node, queried map[string]struct{}, id string, closestNode node.Node) []node.Node {
 	l := len(servers)
 
 	if l == 0 {
 		return []node.Node{}
 	}
 
-	// results channel
-	results := make(chan []node.Node)
+	// results channel, buffered so stragglers can finish after we stop waiting
+	results := make(chan []node.Node, l)
 
 	// Issue a parallel FindNode op to all servers on the list
 	for i := 0; i < l; i++ {
@@ -188,7 +306,7 @@ func (d *KadDHT) findNodeOp(servers []node.Node, queried map[string]struct{}, id
 		// find node protocol adds found nodes to the local routing table
 		// populates queried node's routing table with us and return.
 		go func(server node.Node, id string) {
-			fnd, err := d.fnp.FindNode(server, id)
+			fnd, err := d.fnp.FindNode(ctx, server, id)
 			if err != nil {
 				//TODO: handle errors
 				return
@@ -215,15 +333,24 @@ Loop:
 				}
 				idSet[n.PublicKey().String()] = struct{}{}
 
+				// a banned peer reported by others stays out of the table
+				// and out of the walk
+				if d.isBanned(n.PublicKey().String()) {
+					continue
+				}
+
 				d.rt.Update(n)
 				out = append(out, n)
 			}
 
 			done++
 			if done == l {
-				close(results)
 				break Loop
 			}
+		case <-ctx.Done():
+			// the caller cancelled the lookup - return what we have, the
+			// walk above notices the cancellation and stops.
+			break Loop
 		case <-timeout.C:
 			// we expected nodes to return results within a reasonable time frame
 			// we return what we have now.
@@ -65,3 +65,6 @@ func (m *MockDHT) Size() int {
 	//todo: set size
 	return m.updateCount
 }
+
+// Shutdown does nothing on the mock
+func (m *MockDHT) Shutdown() {}
@@ -2,6 +2,9 @@ package dht
 
 import (
 	"context"
+	"path/filepath"
+
+	"github.com/spacemeshos/go-spacemesh/filesystem"
 	"github.com/spacemeshos/go-spacemesh/p2p/config"
 	"github.com/spacemeshos/go-spacemesh/p2p/node"
 	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
@@ -18,7 +21,8 @@ func TestNew(t *testing.T) {
 
 	n1 := sim.NewNodeFrom(ln.Node)
 
-	d := New(ln, cfg.SwarmConfig, n1)
+	d, err := New(ln, cfg.SwarmConfig, n1)
+	assert.NoError(t, err)
 	assert.NotNil(t, d, "D is not nil")
 }
 
@@ -30,7 +34,8 @@ func TestDHT_Update(t *testing.T) {
 
 	n1 := sim.NewNodeFrom(ln.Node)
 
-	dht := New(ln, cfg.SwarmConfig, n1)
+	dht, err := New(ln, cfg.SwarmConfig, n1)
+	assert.NoError(t, err)
 
 	randnode := node.GenerateRandomNodeData()
 	dht.Update(randnode)
@@ -82,7 +87,8 @@ func TestDHT_Lookup(t *testing.T) {
 
 	n1 := sim.NewNodeFrom(ln.Node)
 
-	dht := New(ln, cfg.SwarmConfig, n1)
+	dht, err := New(ln, cfg.SwarmConfig, n1)
+	assert.NoError(t, err)
 
 	randnode := node.GenerateRandomNodeData()
 
@@ -92,7 +98,7 @@ func TestDHT_Lookup(t *testing.T) {
 
 	assert.NoError(t, err, "Should not return an error")
 
-	assert.True(t, node.String() == randnode.String(), "should return the same node")
+	assert.True(t, node.Equal(randnode), "should return the same node")
 }
 
 func TestDHT_Lookup2(t *testing.T) {
@@ -103,7 +109,8 @@ func TestDHT_Lookup2(t *testing.T) {
 
 	n1 := sim.NewNodeFrom(ln.Node)
 
-	dht := New(ln, cfg.SwarmConfig, n1)
+	dht, err := New(ln, cfg.SwarmConfig, n1)
+	assert.NoError(t, err)
 
 	randnode := node.GenerateRandomNodeData()
 
@@ -113,7 +120,8 @@ func TestDHT_Lookup2(t *testing.T) {
 
 	n2 := sim.NewNodeFrom(ln2.Node)
 
-	dht2 := New(ln2, cfg.SwarmConfig, n2)
+	dht2, err := New(ln2, cfg.SwarmConfig, n2)
+	assert.NoError(t, err)
 
 	dht2.Update(dht.local.Node)
 
@@ -122,12 +130,17 @@ func TestDHT_Lookup2(t *testing.T) {
 
 	assert.Equal(t, node.String(), randnode.String(), "not the same node")
 
+	// the lookup dedups servers - one query to the only peer, one response back
+	assert.Equal(t, 1, sim.CountBetween(ln2.Node.String(), ln.Node.String(), protocol), "expected a single find-node query")
+	assert.Equal(t, 1, sim.CountBetween(ln.Node.String(), ln2.Node.String(), protocol), "expected a single find-node response")
 }
 
 func simNodeWithDHT(t *testing.T, sc config.SwarmConfig, sim *simulator.Simulator) (*simulator.Node, DHT) {
 	ln, _ := node.GenerateTestNode(t)
 	n := sim.NewNodeFrom(ln.Node)
-	dht := New(ln, sc, n)
+	dht, err := New(ln, sc, n)
+	assert.NoError(t, err)
+	dht.SetClock(sim.Clock()) // share the simulation's clock, virtual or real
 	n.AttachDHT(dht)
 
 	return n, dht
@@ -210,30 +223,155 @@ func TestDHT_Bootstrap2(t *testing.T) {
 	}
 }
 
-func TestDHT_BootstrapAbort(t *testing.T) {
-	// Create a bootstrap node
+func TestDHT_Partition(t *testing.T) {
+
+	const nodesNum = 20
+	const groupSize = nodesNum / 2
+
 	sim := simulator.New()
+
+	// Create a bootstrap node
+	cfg := config.DefaultConfig()
+	bn, _ := simNodeWithDHT(t, cfg.SwarmConfig, sim)
+
+	cfg2 := config.DefaultConfig()
+	cfg2.SwarmConfig.RandomConnections = 3
+	cfg2.SwarmConfig.BootstrapNodes = []string{node.StringFromNode(bn.Node)}
+
+	nodes := make([]*simulator.Node, nodesNum)
+	dhts := make([]DHT, nodesNum)
+	booted := make(chan error)
+	for i := 0; i < nodesNum; i++ {
+		nodes[i], dhts[i] = simNodeWithDHT(t, cfg2.SwarmConfig, sim)
+		go bootAndWait(t, dhts[i], booted)
+	}
+	for i := 0; i < nodesNum; i++ {
+		assert.NoError(t, <-booted, "should be able to bootstrap a node")
+	}
+
+	// split the network 10/10, bootstrap node on the first side
+	groupA := []string{bn.String()}
+	groupB := make([]string, 0, groupSize)
+	for i := 0; i < nodesNum; i++ {
+		if i < groupSize {
+			groupA = append(groupA, nodes[i].String())
+		} else {
+			groupB = append(groupB, nodes[i].String())
+		}
+	}
+	sim.Partition(groupA, groupB)
+
+	// a node known only on the far side can't be found across the partition
+	target := node.GenerateRandomNodeData()
+	for i := groupSize; i < nodesNum; i++ {
+		dhts[i].Update(target)
+	}
+
+	found := make(chan node.Node)
+	go func() {
+		n, _ := dhts[0].Lookup(target.PublicKey().String())
+		found <- n
+	}()
+	select {
+	case n := <-found:
+		assert.True(t, n.IsEmpty(), "cross-partition lookup should not find the target")
+	case <-time.After(2 * time.Second):
+		// the lookup is still blocked on unanswerable queries, close enough
+	}
+	assert.True(t, sim.PartitionDrops() > 0, "expected dropped cross-partition messages")
+
+	// heal, refresh and look the target up again
+	sim.Heal()
+	assert.NoError(t, dhts[0].(*KadDHT).refresh(), "refresh should succeed after healing")
+
+	n, err := dhts[0].Lookup(target.PublicKey().String())
+	assert.NoError(t, err, "lookup should succeed after healing")
+	assert.True(t, n.Equal(target), "expected to find the far side target")
+}
+
+func TestDHT_BootstrapAbort(t *testing.T) {
+	// Create a bootstrap node, on a virtual clock so the retry sleeps don't
+	// cost wall time
+	sim := simulator.New(simulator.WithVirtualClock())
 	bn, _ := simNodeWithDHT(t, config.DefaultConfig().SwarmConfig, sim)
 	// config for other nodes
 	cfg2 := config.DefaultConfig()
 	cfg2.SwarmConfig.RandomConnections = 2
 	cfg2.SwarmConfig.BootstrapNodes = []string{node.StringFromNode(bn.Node)}
 	_, dht := simNodeWithDHT(t, cfg2.SwarmConfig, sim)
-	// Create a bootstrap node to abort
+
+	start := time.Now()
 	Ctx, Cancel := context.WithCancel(context.Background())
-	// Abort bootstrap after 2 Seconds
+	booted := make(chan error)
 	go func() {
-		time.Sleep(2 * time.Second)
-		Cancel()
+		booted <- dht.Bootstrap(Ctx)
 	}()
-	// Should return error after 2 seconds
-	err := dht.Bootstrap(Ctx)
+
+	// let the bootstrap loop retry through a few multi-second virtual sleeps,
+	// then abort it
+	for i := 0; i < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		sim.AdvanceTime(LookupIntervals)
+	}
+	Cancel()
+
+	var err error
+waiting:
+	for {
+		select {
+		case err = <-booted:
+			break waiting
+		case <-time.After(5 * time.Millisecond):
+			// wake the loop if it was mid-sleep when we canceled
+			sim.AdvanceTime(LookupIntervals)
+		}
+	}
 	assert.EqualError(t, err, ErrBootAbort.Error(), "Should be able to abort bootstrap")
+	assert.True(t, time.Since(start) < time.Second, "bootstrap on a virtual clock should not take wall time, took %v", time.Since(start))
+}
+
+func TestDHT_BootstrapTimeout(t *testing.T) {
+	// an unreachable bootstrap node - its identity exists but no simulator
+	// node ever answers for it
+	sim := simulator.New(simulator.WithVirtualClock())
+	unreachable := node.GenerateRandomNodeData()
+
+	cfg := config.DefaultConfig().SwarmConfig
+	cfg.RandomConnections = 2
+	cfg.BootstrapNodes = []string{node.StringFromNode(unreachable)}
+	cfg.BootstrapTimeout = 30 * time.Second
+	_, dht := simNodeWithDHT(t, cfg, sim)
+
+	start := time.Now()
+	booted := make(chan error)
+	go func() {
+		// no deadline on the context - the configured timeout is the deadline
+		booted <- dht.Bootstrap(context.Background())
+	}()
+
+	// push the virtual clock through the retry sleeps until the timeout hits
+	var err error
+waiting:
+	for {
+		select {
+		case err = <-booted:
+			break waiting
+		case <-time.After(5 * time.Millisecond):
+			sim.AdvanceTime(LookupIntervals)
+		}
+	}
+	assert.Equal(t, context.DeadlineExceeded, err, "expected the configured bootstrap timeout to expire the run")
+	assert.True(t, time.Since(start) < 2*time.Second, "bootstrap should time out on the virtual clock, took %v", time.Since(start))
 }
 
 func Test_filterFindNodeServers(t *testing.T) {
 	//func filterFindNodeServers(nodes []node.Node, queried map[string]struct{}, alpha int) []node.Node {
 
+	sim := simulator.New()
+	ln, _ := node.GenerateTestNode(t)
+	dht, err := New(ln, config.DefaultConfig().SwarmConfig, sim.NewNodeFrom(ln.Node))
+	assert.NoError(t, err)
+
 	nodes := node.GenerateRandomNodesData(20)
 
 	q := make(map[string]struct{})
@@ -241,7 +379,11 @@ func Test_filterFindNodeServers(t *testing.T) {
 	q[nodes[1].String()] = struct{}{}
 	q[nodes[2].String()] = struct{}{}
 
-	filtered := filterFindNodeServers(nodes, q, 5)
+	banned := nodes[3].String()
+	dht.Ban(banned)
+
+	// the filter compacts nodes in place - don't index it after the call
+	filtered := dht.filterFindNodeServers(nodes, q, 5)
 
 	assert.Equal(t, 5, len(filtered))
 
@@ -249,6 +391,126 @@ func Test_filterFindNodeServers(t *testing.T) {
 		if _, ok := q[filtered[n].String()]; ok {
 			t.Error("It was in the filtered")
 		}
+		if filtered[n].String() == banned {
+			t.Error("A banned server was not filtered out")
+		}
+	}
+
+}
+
+func TestDHT_SaveLoad(t *testing.T) {
+	mem := filesystem.UseMemoryFS(t)
+	dir, err := mem.TempDir("dht_persist")
+	assert.NoError(t, err)
+	path := filepath.Join(dir, "routing_table.json")
+
+	sim := simulator.New()
+
+	// a dht with a few live peers and one that is gone by the time we reload
+	ln, _ := node.GenerateTestNode(t)
+	n1 := sim.NewNodeFrom(ln.Node)
+	dht, err := New(ln, config.DefaultConfig().SwarmConfig, n1)
+	assert.NoError(t, err)
+
+	live := make([]node.Node, 3)
+	for i := range live {
+		// full dht nodes, so they answer the restoration probes
+		ns, _ := simNodeWithDHT(t, config.DefaultConfig().SwarmConfig, sim)
+		live[i] = ns.Node
+		dht.Update(ns.Node)
+	}
+	dead := node.GenerateRandomNodeData() // never attached to the simulator
+	dht.Update(dead)
+
+	assert.NoError(t, dht.Save(path))
+	assert.True(t, mem.Exists(path))
+
+	// a fresh dht on the same identity restores the table through the config
+	cfg := config.DefaultConfig().SwarmConfig
+	cfg.RoutingTableFile = path
+	ln2, _ := node.GenerateTestNode(t)
+	dht2, err := New(ln2, cfg, sim.NewNodeFrom(ln2.Node))
+	assert.NoError(t, err)
+
+	// restoration probes run in the background - wait for the live peers
+	deadline := time.Now().Add(5 * time.Second)
+	for dht2.Size() < len(live) {
+		if time.Now().After(deadline) {
+			t.Fatalf("restored only %v of %v live peers", dht2.Size(), len(live))
+		}
+		time.Sleep(10 * time.Millisecond)
 	}
 
+	// the unresponsive peer must not have been trusted back in
+	assert.Equal(t, len(live), dht2.Size(), "expected the dead snapshot entry to be evicted")
+	for _, p := range live {
+		found, err := dht2.Lookup(p.PublicKey().String())
+		assert.NoError(t, err)
+		assert.True(t, found.Equal(p))
+	}
+}
+
+func TestDHT_LookupContextCancel(t *testing.T) {
+	sim := simulator.New()
+	ln, _ := node.GenerateTestNode(t)
+	dht, err := New(ln, config.DefaultConfig().SwarmConfig, sim.NewNodeFrom(ln.Node))
+	assert.NoError(t, err)
+
+	// a peer that is reachable but never answers find-node - the walk can
+	// only end through the context
+	mute, _ := node.GenerateTestNode(t)
+	sim.NewNodeFrom(mute.Node)
+	dht.Update(mute.Node)
+
+	target := node.GenerateRandomNodeData().PublicKey().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errchan := make(chan error)
+	go func() {
+		_, err := dht.LookupContext(ctx, target)
+		errchan <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond) // let the walk block on the mute peer
+	start := time.Now()
+	cancel()
+
+	select {
+	case err := <-errchan:
+		assert.Equal(t, context.Canceled, err)
+		assert.True(t, time.Since(start) < 2*time.Second, "cancellation should abort the walk promptly, not wait out the lookup timeout")
+	case <-time.After(5 * time.Second):
+		t.Fatal("lookup did not return after its context was cancelled")
+	}
+
+	// a context that is already cancelled fails without touching the network
+	_, err = dht.LookupContext(ctx, target)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestDHT_LoadRejectsBadSnapshots(t *testing.T) {
+	mem := filesystem.UseMemoryFS(t)
+	dir, err := mem.TempDir("dht_persist")
+	assert.NoError(t, err)
+
+	ln, _ := node.GenerateTestNode(t)
+	sim := simulator.New()
+	dht, err := New(ln, config.DefaultConfig().SwarmConfig, sim.NewNodeFrom(ln.Node))
+	assert.NoError(t, err)
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	assert.NoError(t, mem.WriteFileAtomic(corrupt, []byte("{not json"), filesystem.OwnerReadWrite))
+	assert.Error(t, dht.Load(corrupt))
+
+	newer := filepath.Join(dir, "newer.json")
+	assert.NoError(t, mem.WriteFileAtomic(newer, []byte(`{"version":99,"peers":[]}`), filesystem.OwnerReadWrite))
+	assert.Error(t, dht.Load(newer), "a snapshot from a newer node must be refused, not misread")
+
+	// a bad snapshot behind the config must not fail construction
+	cfg := config.DefaultConfig().SwarmConfig
+	cfg.RoutingTableFile = corrupt
+	ln2, _ := node.GenerateTestNode(t)
+	dht2, err := New(ln2, cfg, sim.NewNodeFrom(ln2.Node))
+	assert.NoError(t, err)
+	assert.NotNil(t, dht2)
 }
@@ -1,7 +1,9 @@
 package dht
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/btcsuite/btcutil/base58"
 	"github.com/gogo/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/crypto"
@@ -32,7 +34,7 @@ type findNodeResults struct {
 type findNodeProtocol struct {
 	service service.Service
 
-	pending      map[crypto.UUID]chan findNodeResults
+	pending      map[crypto.RequestID]chan findNodeResults
 	pendingMutex sync.RWMutex
 
 	ingressChannel chan service.Message
@@ -51,7 +53,7 @@ func newFindNodeProtocol(service service.Service, rt RoutingTable) *findNodeProt
 
 	p := &findNodeProtocol{
 		rt:             rt,
-		pending:        make(map[crypto.UUID]chan findNodeResults),
+		pending:        make(map[crypto.RequestID]chan findNodeResults),
 		ingressChannel: service.RegisterProtocol(protocol),
 		service:        service,
 	}
@@ -67,10 +69,10 @@ func newFindNodeProtocol(service service.Service, rt RoutingTable) *findNodeProt
 	return p
 }
 
-func (p *findNodeProtocol) sendRequestMessage(server crypto.PublicKey, payload []byte, reqID crypto.UUID, responseChan chan findNodeResults) (bool, error) {
+func (p *findNodeProtocol) sendRequestMessage(server crypto.PublicKey, payload []byte, reqID crypto.RequestID, responseChan chan findNodeResults) (bool, error) {
 	findnode := &pb.FindNode{}
 	findnode.Req = true
-	findnode.ReqID = reqID[:]
+	findnode.ReqID = reqID.Bytes()
 	findnode.Payload = payload
 
 	msg, err := proto.Marshal(findnode)
@@ -98,9 +100,10 @@ func (p *findNodeProtocol) sendResponseMessage(server crypto.PublicKey, reqID, p
 	return p.service.SendMessage(server.String(), protocol, msg)
 }
 
-// FindNode Send a single find node request to a remote node
+// FindNode Send a single find node request to a remote node, waiting until
+// the response arrives, the protocol timeout expires or ctx is cancelled.
 // id: base58 encoded remote node id
-func (p *findNodeProtocol) FindNode(serverNode node.Node, target string) ([]node.Node, error) {
+func (p *findNodeProtocol) FindNode(ctx context.Context, serverNode node.Node, target string) ([]node.Node, error) {
 
 	var err error
 
@@ -115,8 +118,13 @@ func (p *findNodeProtocol) FindNode(serverNode node.Node, target string) ([]node
 		return nil, err
 	}
 
-	reqID := crypto.NewUUID()
-	respc := make(chan findNodeResults)
+	reqID, err := crypto.NewRequestID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to draw a find-node request id: %v", err)
+	}
+	// buffered, so a response that loses the race against the timeout or a
+	// cancellation doesn't block the ingress goroutine delivering it
+	respc := make(chan findNodeResults, 1)
 
 	pending, err := p.sendRequestMessage(serverNode.PublicKey(), payload, reqID, respc)
 
@@ -142,10 +150,17 @@ func (p *findNodeProtocol) FindNode(serverNode node.Node, target string) ([]node
 		}
 
 		return response.results, nil
+	case <-ctx.Done():
+		err = ctx.Err()
 	case <-timeout.C:
 		err = errors.New("findnode took too long to respond")
 	}
 
+	// nobody is waiting on this request anymore
+	p.pendingMutex.Lock()
+	delete(p.pending, reqID)
+	p.pendingMutex.Unlock()
+
 	return nil, err
 }
 
@@ -170,10 +185,7 @@ func (p *findNodeProtocol) readLoop() {
 				p.handleIncomingRequest(msg.Sender().PublicKey(), headers.ReqID, headers.Payload)
 				return
 			}
-			reqid := headers.ReqID
-			var creqid crypto.UUID
-			copy(creqid[:], reqid) // ugly way to copy slice to array. todo : find better way ?
-			p.handleIncomingResponse(creqid, headers.Payload)
+			p.handleIncomingResponse(crypto.RequestIDFromBytes(headers.ReqID), headers.Payload)
 		}(msg)
 	}
 
@@ -228,7 +240,7 @@ func (p *findNodeProtocol) handleIncomingRequest(sender crypto.PublicKey, reqID,
 }
 
 // Handle an incoming pong message from a remote node
-func (p *findNodeProtocol) handleIncomingResponse(reqID crypto.UUID, msg []byte) {
+func (p *findNodeProtocol) handleIncomingResponse(reqID crypto.RequestID, msg []byte) {
 	// process request
 	data := &pb.FindNodeResp{}
 	err := proto.Unmarshal(msg, data)
@@ -243,7 +255,7 @@ func (p *findNodeProtocol) handleIncomingResponse(reqID crypto.UUID, msg []byte)
 	p.sendResponse(reqID, findNodeResults{nodes, nil})
 }
 
-func (p *findNodeProtocol) sendResponse(reqID crypto.UUID, results findNodeResults) {
+func (p *findNodeProtocol) sendResponse(reqID crypto.RequestID, results findNodeResults) {
 	p.pendingMutex.RLock()
 	pend, ok := p.pending[reqID]
 	p.pendingMutex.RUnlock()
@@ -279,14 +291,18 @@ func toNodeInfo(nodes []node.Node, filterID string) []*pb.NodeInfo {
 func fromNodeInfos(nodes []*pb.NodeInfo) []node.Node {
 	res := make([]node.Node, len(nodes))
 	for i, n := range nodes {
-		pubk, err := crypto.NewPublicKey(n.NodeId)
+		pubk, err := crypto.NewPublicKeyCached(n.NodeId)
 		if err != nil {
 			// TODO Error handling, problem : don't break everything because one messed up nodeinfo
 			log.Error("There was an error parsing nodeid : ", n.NodeId, ", skipping it. err: ", err)
 			continue
 		}
-		node := node.New(pubk, n.Address)
-		res[i] = node
+		if _, err := node.ParseAddress(n.Address); err != nil {
+			log.Error("There was an error parsing node address : ", n.Address, ", skipping it. err: ", err)
+			continue
+		}
+		nd := node.New(pubk, n.Address)
+		res[i] = nd
 
 	}
 	return res
@@ -1,6 +1,7 @@
 package dht
 
 import (
+	"context"
 	"fmt"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/config"
@@ -27,7 +28,7 @@ func TestFindNodeProtocol_FindNode(t *testing.T) {
 	rt2 := NewRoutingTable(cfg.SwarmConfig.RoutingTableBucketSize, n2.DhtID(), getTestLogger("FindNode - ").Logger)
 	_ = newFindNodeProtocol(n2, rt2)
 
-	idarr, err := fnd1.FindNode(n2.Node, node.GenerateRandomNodeData().String())
+	idarr, err := fnd1.FindNode(context.TODO(), n2.Node, node.GenerateRandomNodeData().String())
 
 	assert.NoError(t, err, "Should not return error")
 	assert.Equal(t, []node.Node{}, idarr, "Should be an empty array")
@@ -49,7 +50,7 @@ func TestFindNodeProtocol_FindNode2(t *testing.T) {
 
 	fnd2.rt.Update(randnode)
 
-	idarr, err := fnd1.FindNode(n2.Node, randnode.String())
+	idarr, err := fnd1.FindNode(context.TODO(), n2.Node, randnode.String())
 
 	expected := []node.Node{randnode}
 
@@ -64,12 +65,12 @@ func TestFindNodeProtocol_FindNode2(t *testing.T) {
 	// sort because this is how its returned
 	expected = node.SortByDhtID(expected, randnode.DhtID())
 
-	idarr, err = fnd1.FindNode(n2.Node, randnode.String())
+	idarr, err = fnd1.FindNode(context.TODO(), n2.Node, randnode.String())
 
 	assert.NoError(t, err, "Should not return error")
 	assert.Equal(t, expected, idarr, "Should be same array")
 
-	idarr, err = fnd2.FindNode(n1.Node, randnode.String())
+	idarr, err = fnd2.FindNode(context.TODO(), n1.Node, randnode.String())
 
 	assert.NoError(t, err, "Should not return error")
 	assert.Equal(t, expected, idarr, "Should be array that contains the node")
@@ -0,0 +1,91 @@
+package dht
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spacemeshos/go-spacemesh/filesystem"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+)
+
+// routingTableSnapshotVersion is the schema version stamped on persisted
+// routing tables. A snapshot from a newer node is refused rather than
+// misread.
+const routingTableSnapshotVersion = 1
+
+// routingTableSnapshot is the on-disk form of the routing table - the
+// version envelope plus the peers in their public JSON form, including the
+// client version each peer reported.
+type routingTableSnapshot struct {
+	Version int         `json:"version"`
+	Peers   []node.Node `json:"peers"`
+}
+
+// Save serializes the current routing table contents to path, atomically so
+// a crash mid-write can't leave a torn snapshot behind.
+func (d *KadDHT) Save(path string) error {
+	poc := make(PeersOpChannel)
+	d.rt.ListPeers(poc)
+	peers := (<-poc).Peers
+
+	data, err := json.Marshal(&routingTableSnapshot{Version: routingTableSnapshotVersion, Peers: peers})
+	if err != nil {
+		return fmt.Errorf("failed to serialize the routing table: %v", err)
+	}
+	if err := filesystem.Active.WriteFileAtomic(path, data, filesystem.OwnerReadWrite); err != nil {
+		return fmt.Errorf("failed to write the routing table to %v: %v", path, err)
+	}
+	d.local.Log.With(log.NodeID(d.local.String()), log.Int("peers", len(peers))).Debug("persisted routing table")
+	return nil
+}
+
+// Load reads a routing table snapshot from path and re-admits its peers in
+// the background. A restored peer is probed before it is trusted - an entry
+// that doesn't answer is evicted, so a stale snapshot can't seed the table
+// with dead addresses. Load itself returns once the snapshot is parsed and
+// never stalls startup on the probes.
+func (d *KadDHT) Load(path string) error {
+	data, err := filesystem.Active.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var snap routingTableSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("corrupt routing table snapshot at %v: %v", path, err)
+	}
+	if snap.Version > routingTableSnapshotVersion {
+		return fmt.Errorf("routing table snapshot at %v has version %v, newer than this node understands", path, snap.Version)
+	}
+	go d.restorePeers(snap.Peers)
+	return nil
+}
+
+// restorePeers probes each snapshot peer and admits the responders into the
+// routing table. The find-node probe doubles as a liveness check - a
+// dedicated ping protocol would do, but asking a peer for our own neighbors
+// costs one round trip either way.
+func (d *KadDHT) restorePeers(peers []node.Node) {
+	var live uint32
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		if p.PublicKey().String() == d.local.PublicKey().String() {
+			continue
+		}
+		wg.Add(1)
+		go func(p node.Node) {
+			defer wg.Done()
+			if _, err := d.fnp.FindNode(context.Background(), p, d.local.PublicKey().String()); err != nil {
+				d.local.Log.With(log.NodeID(d.local.String()), log.String("peer_id", p.String()), log.String("err", err.Error())).Debug("dropping unresponsive peer from restored routing table")
+				return
+			}
+			d.rt.Update(p)
+			atomic.AddUint32(&live, 1)
+		}(p)
+	}
+	wg.Wait()
+	d.local.Log.With(log.NodeID(d.local.String()), log.Int("restored", int(atomic.LoadUint32(&live))), log.Int("snapshot", len(peers))).Info("restored routing table from disk")
+}
@@ -0,0 +1,158 @@
+package dht
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/p2p/dht/pb"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+)
+
+// Ping protocol name
+const pingProtocolName = "/dht/1.0/ping/"
+
+// ErrPingTimedOut is returned when a peer did not pong within the ping timeout.
+var ErrPingTimedOut = errors.New("ping took too long to respond")
+
+// pingProtocol implements a minimal request/response liveness check.
+// It reuses the pb.FindNode envelope with an empty payload - all a ping
+// carries is the request id that pairs a pong with its ping.
+type pingProtocol struct {
+	service service.Service
+	timeout time.Duration
+
+	pending      map[crypto.RequestID]chan error
+	pendingMutex sync.RWMutex
+
+	ingressChannel chan service.Message
+
+	log log.Log
+}
+
+// newPingProtocol creates the ping protocol instance and starts serving pongs.
+func newPingProtocol(service service.Service, timeout time.Duration) *pingProtocol {
+
+	p := &pingProtocol{
+		service:        service,
+		timeout:        timeout,
+		pending:        make(map[crypto.RequestID]chan error),
+		ingressChannel: service.RegisterProtocol(pingProtocolName),
+	}
+
+	if srv, ok := service.(localService); ok {
+		p.log = srv.LocalNode().Log
+	} else {
+		p.log = log.AppLog
+	}
+
+	go p.readLoop()
+
+	return p
+}
+
+// Ping sends a ping message to target and blocks until its pong arrives or
+// the configured timeout expires.
+func (p *pingProtocol) Ping(target node.Node) error {
+
+	reqID, err := crypto.NewRequestID()
+	if err != nil {
+		return fmt.Errorf("failed to draw a ping request id: %v", err)
+	}
+
+	ping := &pb.FindNode{Req: true, ReqID: reqID.Bytes()}
+	msg, err := proto.Marshal(ping)
+	if err != nil {
+		return err
+	}
+
+	// buffered, so a pong that loses the race against the timeout doesn't
+	// block the ingress goroutine delivering it
+	respc := make(chan error, 1)
+	p.pendingMutex.Lock()
+	p.pending[reqID] = respc
+	p.pendingMutex.Unlock()
+
+	if err := p.service.SendMessage(target.String(), pingProtocolName, msg); err != nil {
+		p.pendingMutex.Lock()
+		delete(p.pending, reqID)
+		p.pendingMutex.Unlock()
+		return err
+	}
+
+	timeout := time.NewTimer(p.timeout)
+
+	select {
+	case err := <-respc:
+		return err
+	case <-timeout.C:
+	}
+
+	// nobody is waiting on this request anymore
+	p.pendingMutex.Lock()
+	delete(p.pending, reqID)
+	p.pendingMutex.Unlock()
+
+	return ErrPingTimedOut
+}
+
+func (p *pingProtocol) readLoop() {
+	for {
+		msg, ok := <-p.ingressChannel
+		if !ok {
+			// Channel is closed.
+			break
+		}
+
+		go func(msg service.Message) {
+
+			headers := &pb.FindNode{}
+			err := proto.Unmarshal(msg.Data(), headers)
+			if err != nil {
+				p.log.Error("Error handling incoming ping ", err)
+				return
+			}
+
+			if headers.Req {
+				p.handleIncomingPing(msg.Sender().PublicKey(), headers.ReqID)
+				return
+			}
+			p.handleIncomingPong(crypto.RequestIDFromBytes(headers.ReqID))
+		}(msg)
+	}
+}
+
+// Answers a ping from a remote node by echoing its request id back
+func (p *pingProtocol) handleIncomingPing(sender crypto.PublicKey, reqID []byte) {
+
+	pong := &pb.FindNode{Req: false, ReqID: reqID}
+	data, err := proto.Marshal(pong)
+	if err != nil {
+		return
+	}
+
+	err = p.service.SendMessage(sender.String(), pingProtocolName, data)
+	if err != nil {
+		p.log.Error("failed sending pong to %v, err:%v", sender.String(), err)
+	}
+}
+
+// Handles an incoming pong - releases the pinger waiting on the request id
+func (p *pingProtocol) handleIncomingPong(reqID crypto.RequestID) {
+
+	p.pendingMutex.Lock()
+	pend, ok := p.pending[reqID]
+	if ok {
+		delete(p.pending, reqID)
+	}
+	p.pendingMutex.Unlock()
+
+	if ok {
+		pend <- nil
+	}
+}
@@ -0,0 +1,115 @@
+package dht
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPingProtocol(t *testing.T) {
+	sim := simulator.New()
+
+	cfg := config.DefaultConfig().SwarmConfig
+	cfg.PingTimeout = 100 * time.Millisecond
+
+	_, d1 := simNodeWithDHT(t, cfg, sim)
+	n2, _ := simNodeWithDHT(t, cfg, sim)
+
+	err := d1.Ping(n2.Node)
+	assert.NoError(t, err, "expected a live peer to pong")
+
+	// a peer that is not reachable must fail the ping
+	dead := node.GenerateRandomNodeData()
+	err = d1.Ping(dead)
+	assert.Error(t, err, "expected pinging a dead peer to fail")
+}
+
+// a Pinger stub with a scripted answer
+type fakePinger struct {
+	err error
+
+	mutex  sync.Mutex
+	pinged []string
+}
+
+func (f *fakePinger) Ping(target node.Node) error {
+	f.mutex.Lock()
+	f.pinged = append(f.pinged, target.String())
+	f.mutex.Unlock()
+	return f.err
+}
+
+func (f *fakePinger) pingCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.pinged)
+}
+
+// find n in the table, blocking on the callback
+func tableHas(rt RoutingTable, n node.Node) bool {
+	cb := make(PeerOpChannel)
+	rt.Find(PeerByIDRequest{ID: n.DhtID(), Callback: cb})
+	res := <-cb
+	return res.Peer.PublicKey() != nil && res.Peer.String() == n.String()
+}
+
+// generate a random node falling in local's bucket 0 (cpl 0)
+func generateBucketZeroNode(local node.DhtID) node.Node {
+	for {
+		n := node.GenerateRandomNodeData()
+		if n.DhtID().CommonPrefixLen(local) == 0 {
+			return n
+		}
+	}
+}
+
+func TestTableBucketFullChallenge(t *testing.T) {
+	local := node.GenerateRandomNodeData()
+	localID := local.DhtID()
+
+	// both nodes fall in the same single-slot bucket
+	n1 := generateBucketZeroNode(localID)
+	n2 := generateBucketZeroNode(localID)
+
+	// a live oldest peer keeps its slot and the candidate is dropped
+	rt := NewRoutingTable(1, localID, GetTestLogger(localID.Pretty()))
+	alive := &fakePinger{}
+	rt.SetPinger(alive)
+
+	rt.Update(n1)
+	rt.Update(n2)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for alive.pingCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the full bucket to challenge its oldest peer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.True(t, tableHas(rt, n1), "expected the live peer to keep its slot")
+	assert.False(t, tableHas(rt, n2), "expected the candidate to be dropped")
+
+	// an unresponsive oldest peer is evicted in favor of the candidate
+	rt2 := NewRoutingTable(1, localID, GetTestLogger(localID.Pretty()))
+	dead := &fakePinger{err: errors.New("no pong")}
+	rt2.SetPinger(dead)
+
+	rt2.Update(n1)
+	rt2.Update(n2)
+
+	deadline = time.Now().Add(5 * time.Second)
+	for !tableHas(rt2, n2) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the candidate to displace the unresponsive peer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, tableHas(rt2, n1), "expected the unresponsive peer to be evicted")
+}
@@ -0,0 +1,84 @@
+package dht
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+)
+
+// a bucket refresh gives up on drawing an exact-depth target after this many
+// key draws and settles for the closest depth it saw - only relevant for the
+// deepest buckets, whose keyspace slices are exponentially small
+const maxRefreshKeyDraws = 1024
+
+// startBucketRefresh spawns a goroutine that, every interval, picks one random
+// routing table bucket and looks up a synthetic target inside its keyspace
+// range. A freshly bootstrapped node fills the buckets nearest its own id but
+// leaves distant ones sparse - the periodic walks pull fresh peers for them.
+// The goroutine stops when the dht is Shutdown.
+func (d *KadDHT) startBucketRefresh(interval time.Duration) {
+	go func() {
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				d.refreshBucket(rand.Intn(BucketCount))
+			case <-d.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// refreshBucket runs a lookup for a synthetic target in bucket b's range. The
+// target virtually never exists, so the lookup itself fails - its value is in
+// the peers the walk inserts into the table along the way.
+func (d *KadDHT) refreshBucket(b int) {
+	target, err := d.randomKeyInBucket(b)
+	if err != nil {
+		d.local.Log.With(log.NodeID(d.local.String()), log.String("err", err.Error())).Warning("failed drawing a bucket refresh target")
+		return
+	}
+	_, _ = d.Lookup(target)
+}
+
+// randomKeyInBucket draws a public key whose dht id shares exactly b prefix
+// bits with the local id, so a lookup for it walks toward bucket b. Ids are
+// hashes of keys, so the draw is a rejection sample; after maxRefreshKeyDraws
+// the closest depth seen wins.
+func (d *KadDHT) randomKeyInBucket(b int) (string, error) {
+	local := d.local.DhtID()
+
+	best := ""
+	bestDelta := -1
+
+	for i := 0; i < maxRefreshKeyDraws; i++ {
+		_, pub, err := crypto.GenerateKeyPair()
+		if err != nil {
+			return "", err
+		}
+
+		cpl := node.NewDhtID(pub.Bytes()).CommonPrefixLen(local)
+		if cpl >= BucketCount {
+			cpl = BucketCount - 1 // deep ids share the last bucket
+		}
+		if cpl == b {
+			return pub.String(), nil
+		}
+
+		delta := cpl - b
+		if delta < 0 {
+			delta = -delta
+		}
+		if bestDelta == -1 || delta < bestDelta {
+			best = pub.String()
+			bestDelta = delta
+		}
+	}
+
+	return best, nil
+}
@@ -0,0 +1,52 @@
+package dht
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DHTStats is a point-in-time snapshot of dht health, meant for monitoring
+// systems that want a structured data point instead of parsing log text.
+type DHTStats struct {
+	TotalPeers     int   // peers currently held in the routing table
+	BucketCount    int   // routing table buckets holding at least one peer
+	LookupCount    int64 // lookups issued since the dht was created
+	LookupErrors   int64 // lookups that ended with an error
+	BootstrapCount int64 // successful bootstrap runs
+
+	// AverageLookupLatency is the mean wall time of a lookup, zero before the
+	// first one completes.
+	AverageLookupLatency time.Duration
+}
+
+// Stats returns a snapshot of the dht's health counters and routing table
+// occupancy. It is safe to call from any goroutine.
+func (d *KadDHT) Stats() DHTStats {
+	callback := make(PeersOpChannel)
+	d.rt.ListPeers(callback)
+	peers := (<-callback).Peers
+
+	// bucket occupancy mirrors the table's placement rule - peers deeper than
+	// the last bucket share it
+	occupied := map[int]struct{}{}
+	localID := d.local.DhtID()
+	for _, p := range peers {
+		cpl := p.DhtID().CommonPrefixLen(localID)
+		if cpl >= BucketCount {
+			cpl = BucketCount - 1
+		}
+		occupied[cpl] = struct{}{}
+	}
+
+	s := DHTStats{
+		TotalPeers:     len(peers),
+		BucketCount:    len(occupied),
+		LookupCount:    atomic.LoadInt64(&d.lookupCount),
+		LookupErrors:   atomic.LoadInt64(&d.lookupErrors),
+		BootstrapCount: atomic.LoadInt64(&d.bootstrapCount),
+	}
+	if s.LookupCount > 0 {
+		s.AverageLookupLatency = time.Duration(atomic.LoadInt64(&d.lookupLatency) / s.LookupCount)
+	}
+	return s
+}
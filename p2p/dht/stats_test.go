@@ -0,0 +1,82 @@
+package dht
+
+import (
+	"context"
+	"testing"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDHT_Stats(t *testing.T) {
+	sim := simulator.New()
+	_, d := simNodeWithDHT(t, config.DefaultConfig().SwarmConfig, sim)
+	kad := d.(*KadDHT)
+
+	s := kad.Stats()
+	assert.Equal(t, 0, s.TotalPeers, "a fresh table holds no peers")
+	assert.Equal(t, 0, s.BucketCount, "a fresh table has no occupied buckets")
+	assert.EqualValues(t, 0, s.LookupCount)
+
+	peers := node.GenerateRandomNodesData(20)
+	for _, p := range peers {
+		d.Update(p)
+	}
+	waitForSize(t, d, len(peers))
+
+	s = kad.Stats()
+	assert.Equal(t, d.Size(), s.TotalPeers, "TotalPeers should match the Size channel response")
+	assert.True(t, s.BucketCount > 0, "populated table should occupy at least one bucket")
+	assert.True(t, s.BucketCount <= s.TotalPeers, "can't occupy more buckets than peers")
+
+	// a lookup for an unknown target fails and is counted as an error
+	_, err := d.Lookup(node.GenerateRandomNodeData().PublicKey().String())
+	assert.Error(t, err)
+
+	s = kad.Stats()
+	assert.EqualValues(t, 1, s.LookupCount)
+	assert.EqualValues(t, 1, s.LookupErrors)
+	assert.True(t, s.AverageLookupLatency > 0, "a completed lookup should register latency")
+
+	// a lookup answered from the local table succeeds and leaves the error
+	// count alone
+	found, err := d.Lookup(peers[0].PublicKey().String())
+	assert.NoError(t, err)
+	assert.Equal(t, peers[0].String(), found.String())
+
+	s = kad.Stats()
+	assert.EqualValues(t, 2, s.LookupCount)
+	assert.EqualValues(t, 1, s.LookupErrors)
+}
+
+func TestDHT_StatsBootstrapCount(t *testing.T) {
+	sim := simulator.New()
+	bn, _ := simNodeWithDHT(t, config.DefaultConfig().SwarmConfig, sim)
+
+	cfg := config.DefaultConfig()
+	cfg.SwarmConfig.RandomConnections = 2
+	cfg.SwarmConfig.BootstrapNodes = []string{node.StringFromNode(bn.Node)}
+
+	_, d2 := simNodeWithDHT(t, cfg.SwarmConfig, sim)
+	_, d3 := simNodeWithDHT(t, cfg.SwarmConfig, sim)
+	_, d4 := simNodeWithDHT(t, cfg.SwarmConfig, sim)
+
+	assert.EqualValues(t, 0, d2.(*KadDHT).Stats().BootstrapCount)
+
+	booted := make(chan error)
+	go bootAndWait(t, d2, booted)
+	go bootAndWait(t, d3, booted)
+	go bootAndWait(t, d4, booted)
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, <-booted, "should be able to bootstrap a node")
+	}
+
+	assert.EqualValues(t, 1, d2.(*KadDHT).Stats().BootstrapCount, "a successful bootstrap should be counted once")
+
+	err := d2.Bootstrap(context.Background())
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, d2.(*KadDHT).Stats().BootstrapCount)
+}
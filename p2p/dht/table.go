@@ -35,13 +35,22 @@ type RoutingTable interface {
 	NearestPeer(req PeerByIDRequest)  // nearest peer to a node.DhtID
 	NearestPeers(req NearestPeersReq) // ip to n nearest peers to a node.DhtID
 
-	SelectPeers(qty int) []node.Node // Get a list of random peers
+	SelectPeers(qty int) []node.Node   // Get a list of random peers
+	ListPeers(callback PeersOpChannel) // all peers in the table, for persistence
+
+	SetPinger(p Pinger) // liveness checks for the bucket-full eviction path
 
 	Size(callback chan int) // total # of peers in the table
 
 	Print()
 }
 
+// Pinger checks whether a peer is still alive. The routing table uses it to
+// challenge the least-recently seen peer of a full bucket before evicting it.
+type Pinger interface {
+	Ping(target node.Node) error
+}
+
 // exported helper types
 
 // PeerOpResult is used as a result of a method that returns nil or one peer.
@@ -84,6 +93,13 @@ type randomPeersReq struct {
 	cb    chan []node.Node
 }
 
+// outcome of pinging the least-recently seen peer of a full bucket
+type challengeResult struct {
+	oldest    node.Node
+	candidate node.Node
+	alive     bool
+}
+
 // RoutingTable defines the routing table.
 // Most recently network active nodes are placed in beginning of buckets.
 // Least active nodes are the back of each bucket.
@@ -116,6 +132,12 @@ type routingTableImpl struct {
 	updateReqs chan node.Node
 	removeReqs chan node.Node
 
+	// liveness challenges for full buckets - a nil pinger disables them
+	pinger           Pinger
+	setPingerReqs    chan Pinger
+	challengeResults chan challengeResult
+	challenges       map[string]struct{} // ids of peers being challenged
+
 	// latency metrics
 	//metrics pstore.Metrics
 
@@ -151,11 +173,16 @@ func NewRoutingTable(bucketsize int, localID node.DhtID, log *logging.Logger) Ro
 		randomPeersReq:   make(chan *randomPeersReq),
 		nearestPeerReqs:  make(chan PeerByIDRequest, 3),
 		nearestPeersReqs: make(chan NearestPeersReq, 3),
+		listPeersReqs:    make(chan PeersOpChannel, 3),
 		sizeReqs:         make(chan chan int, 3),
 
 		updateReqs: make(chan node.Node),
 		removeReqs: make(chan node.Node, 3),
 
+		setPingerReqs:    make(chan Pinger),
+		challengeResults: make(chan challengeResult),
+		challenges:       make(map[string]struct{}),
+
 		peerRemovedCallbacks: make(map[string]PeerChannel),
 		peerAddedCallbacks:   make(map[string]PeerChannel),
 
@@ -188,7 +215,17 @@ func (rt *routingTableImpl) NearestPeers(req NearestPeersReq) {
 	rt.nearestPeersReqs <- req
 }
 
+// ListPeers returns every peer in the table through the callback.
+func (rt *routingTableImpl) ListPeers(callback PeersOpChannel) {
+	rt.listPeersReqs <- callback
+}
+
 func (rt *routingTableImpl) Update(peer node.Node) {
+	// a peer with an unusable address can't be dialed and must not enter the table
+	if _, err := node.ParseAddress(peer.Address()); err != nil {
+		rt.log.Warningf("routing table rejecting node %v with invalid address %v : %v", peer.String(), peer.Address(), err)
+		return
+	}
 	rt.updateReqs <- peer
 }
 
@@ -196,6 +233,13 @@ func (rt *routingTableImpl) Remove(peer node.Node) {
 	rt.removeReqs <- peer
 }
 
+// SetPinger arms the bucket-full eviction path with a liveness check.
+// Without one the table keeps displacing the least-recently seen peer
+// unconditionally.
+func (rt *routingTableImpl) SetPinger(p Pinger) {
+	rt.setPingerReqs <- p
+}
+
 func (rt *routingTableImpl) SelectPeers(qty int) []node.Node {
 	cb := make(chan []node.Node)
 	rpq := &randomPeersReq{
@@ -219,6 +263,12 @@ func (rt *routingTableImpl) processEvents() {
 		case p := <-rt.removeReqs:
 			rt.remove(p)
 
+		case p := <-rt.setPingerReqs:
+			rt.pinger = p
+
+		case r := <-rt.challengeResults:
+			rt.onChallengeResult(r)
+
 		case r := <-rt.sizeReqs:
 			rt.size(r)
 
@@ -237,6 +287,10 @@ func (rt *routingTableImpl) processEvents() {
 		case r := <-rt.randomPeersReq:
 			r.cb <- rt.randomPeers(r.count)
 
+		case r := <-rt.listPeersReqs:
+			peers := rt.listPeers()
+			go func() { r <- &PeersOpResult{Peers: peers} }()
+
 		case <-rt.printReq:
 			rt.onPrintReq()
 		}
@@ -273,7 +327,7 @@ func (rt *routingTableImpl) randomPeers(qty int) []node.Node {
 	}
 
 	bufSize := qty
-	if (size < qty) {
+	if size < qty {
 		bufSize = size
 	}
 	buf := make([]node.Node, bufSize)
@@ -329,17 +383,51 @@ func (rt *routingTableImpl) update(p node.Node) {
 
 	// todo: consider connection metrics
 	if bucket.Len() >= rt.bucketsize { // bucket overflows
-		// TODO: if bucket is full ping oldest node and replace if it fails to answer
-		// TODO: check latency metrics and replace if new node is better then oldest one.
-		// Fresh, recent contacted (alive), low latency nodes should be kept at top of the bucket.
-		bucket.PopBack() // todo :ping them.
-		bucket.PushFront(p)
+
+		if rt.pinger == nil {
+			// no liveness checks available - displace the least-recently
+			// seen peer unconditionally
+			bucket.PopBack()
+			bucket.PushFront(p)
+			return
+		}
+
+		// the kad design spec says to ping the least-recently seen peer
+		// before evicting it - a peer that answers keeps its slot and the
+		// candidate is dropped
+		oldest := bucket.Back()
+		if _, ok := rt.challenges[oldest.String()]; ok {
+			// already being challenged - drop the candidate
+			return
+		}
+		rt.challenges[oldest.String()] = struct{}{}
+
+		go func(oldest, candidate node.Node) {
+			err := rt.pinger.Ping(oldest)
+			rt.challengeResults <- challengeResult{oldest: oldest, candidate: candidate, alive: err == nil}
+		}(oldest, p)
 		return
 	}
 
 	bucket.PushFront(p)
 }
 
+// Applies the outcome of a bucket-full liveness challenge.
+// Only call from the processEvents event loop.
+func (rt *routingTableImpl) onChallengeResult(r challengeResult) {
+	delete(rt.challenges, r.oldest.String())
+
+	if r.alive {
+		// the old peer answered - refresh its slot, the candidate is dropped
+		rt.update(r.oldest)
+		return
+	}
+
+	rt.log.Debug("evicting unresponsive peer %v in favor of %v", r.oldest.String(), r.candidate.String())
+	rt.remove(r.oldest)
+	rt.update(r.candidate)
+}
+
 // Remove a node from the routing table.
 // Callback to peerRemoved will be called if node was in table and was removed
 // If node wasn't in the table then remove doesn't have any side effects on the table
@@ -434,6 +522,15 @@ func (rt *routingTableImpl) nearestPeers(id node.DhtID, count int) []node.Node {
 	return sorted
 }
 
+// listPeers collects every peer across the buckets.
+func (rt *routingTableImpl) listPeers() []node.Node {
+	var peers []node.Node
+	for _, buck := range rt.buckets {
+		peers = append(peers, buck.Peers()...)
+	}
+	return peers
+}
+
 func (rt *routingTableImpl) size(callback chan int) {
 	tot := 0
 	for _, buck := range rt.buckets {
@@ -87,6 +87,30 @@ func TestTableUpdate(t *testing.T) {
 	}
 }
 
+func TestTableUpdateRejectsInvalidAddress(t *testing.T) {
+
+	local := node.GenerateRandomNodeData()
+	localID := local.DhtID()
+
+	rt := NewRoutingTable(10, localID, GetTestLogger(localID.Pretty()))
+
+	valid := node.GenerateRandomNodeData()
+
+	// nodes with unusable addresses never enter the table
+	rt.Update(node.New(valid.PublicKey(), "garbage"))
+	rt.Update(node.New(valid.PublicKey(), "127.0.0.1:0"))
+	rt.Update(node.New(valid.PublicKey(), ":1234"))
+
+	sizeChan := make(chan int)
+	rt.Size(sizeChan)
+	assert.Equal(t, 0, <-sizeChan, "expected an empty routing table")
+
+	rt.Update(valid)
+
+	rt.Size(sizeChan)
+	assert.Equal(t, 1, <-sizeChan, "expected the valid node in the routing table")
+}
+
 func TestTableFind(t *testing.T) {
 
 	const n = 100
@@ -113,7 +137,7 @@ func TestTableFind(t *testing.T) {
 
 		select {
 		case c := <-callback:
-			if c.Peer == node.EmptyNode || c.Peer != n {
+			if c.Peer.IsEmpty() || !c.Peer.Equal(n) {
 				t.Fatalf("Failed to lookup known identity...")
 			}
 		case <-time.After(time.Second * 5):
@@ -125,7 +149,7 @@ func TestTableFind(t *testing.T) {
 
 		select {
 		case c := <-callback1:
-			if c.Peer == node.EmptyNode || c.Peer != n {
+			if c.Peer.IsEmpty() || !c.Peer.Equal(n) {
 				t.Fatalf("Failed to find identity...")
 			}
 		case <-time.After(time.Second * 5):
@@ -306,13 +330,13 @@ func TestRoutingTableImpl_Remove(t *testing.T) {
 	cnode := make(PeerOpChannel)
 	rt.Find(PeerByIDRequest{rnode.DhtID(), cnode})
 	n := <-cnode
-	assert.NotEqual(t, n.Peer, node.EmptyNode)
+	assert.False(t, n.Peer.IsEmpty())
 
 	rt.Remove(rnode)
 
 	rt.Find(PeerByIDRequest{rnode.DhtID(), cnode})
 	n = <-cnode
-	assert.Equal(t, n.Peer, node.EmptyNode)
+	assert.True(t, n.Peer.IsEmpty())
 }
 
 func BenchmarkUpdates(b *testing.B) {
@@ -0,0 +1,115 @@
+package p2p
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/p2p/message"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/pb"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+// validateGossip runs the author check a gossip receiver applies to relayed
+// messages.
+func validateGossip(data []byte) error {
+	pm := &pb.ProtocolMessage{}
+	if err := proto.Unmarshal(data, pm); err != nil {
+		return err
+	}
+	return message.AuthAuthor(pm)
+}
+
+func TestGossipValidationRejectsCorruptedRelay(t *testing.T) {
+	sim := simulator.New()
+
+	author, _ := node.GenerateTestNode(t)
+	receiver := sim.NewNode()
+	in := receiver.RegisterProtocol(protocol)
+
+	// a message signed by its author
+	pm := &pb.ProtocolMessage{
+		Metadata: message.NewProtocolMessageMetadata(author.PublicKey(), protocol, true),
+		Payload:  []byte("gossiped payload"),
+	}
+	assert.NoError(t, message.SignMessage(author.PrivateKey(), pm), "failed to sign message")
+	data, err := proto.Marshal(pm)
+	assert.NoError(t, err, "failed to marshal message")
+
+	// delivery is synchronous, so consume before sending
+	received := make(chan []byte, 2)
+	go func() {
+		for m := range in {
+			received <- m.Data()
+		}
+	}()
+
+	// relayed by an honest node it passes validation
+	honest := sim.NewNode()
+	assert.NoError(t, honest.SendMessage(receiver.String(), protocol, data))
+	assert.NoError(t, validateGossip(<-received), "an honest relay should validate")
+
+	// relayed by a corrupting node it must be rejected, unless the flipped
+	// bit was benign - e.g. a case flip inside the hex-encoded signature
+	// decodes to the very same signature
+	faulty := sim.NewFaultyNodeFrom(node.GenerateRandomNodeData(), simulator.FaultyBehavior{CorruptProb: 1, Seed: 1})
+	rejected := 0
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, faulty.SendMessage(receiver.String(), protocol, data))
+		corrupted := <-received
+		if validateGossip(corrupted) != nil {
+			rejected++
+			continue
+		}
+		got := &pb.ProtocolMessage{}
+		assert.NoError(t, proto.Unmarshal(corrupted, got))
+		assert.Equal(t, pm.Payload, got.Payload, "a validated relay must carry the original payload")
+		assert.Equal(t, pm.Metadata.AuthPubKey, got.Metadata.AuthPubKey, "a validated relay must carry the original author")
+	}
+	assert.True(t, rejected > 0, "expected corrupted relays to be rejected")
+}
+
+func TestProtocol_SendRequestFaultyPeers(t *testing.T) {
+	sim := simulator.New()
+
+	// an honest responder
+	honest := sim.NewNode()
+	honestProto := NewProtocol(honest, protocol)
+	honestProto.RegisterMsgHandler(1, func(msg []byte) []byte { return []byte("some value to return") })
+
+	// a peer that accepts requests and never answers
+	muted := sim.NewFaultyNodeFrom(node.GenerateRandomNodeData(), simulator.FaultyBehavior{Mute: true, Seed: 2})
+	muted.Listen(protocol)
+
+	// a peer that answers with an oversized malformed wrapper
+	garbler := sim.NewFaultyNodeFrom(node.GenerateRandomNodeData(), simulator.FaultyBehavior{
+		Responder: func(msg []byte) []byte { return bytes.Repeat([]byte{0xff}, 1<<20) },
+		Seed:      3,
+	})
+	garbler.Listen(protocol)
+
+	requester := sim.NewNode()
+	reqProto := NewProtocol(requester, protocol)
+
+	// requests to the byzantine peers time out
+	_, err := reqProto.SendRequest(1, nil, muted.PublicKey().String(), 200*time.Millisecond)
+	assert.Error(t, err, "expected a timeout against a muted peer")
+	_, err = reqProto.SendRequest(1, nil, garbler.PublicKey().String(), 200*time.Millisecond)
+	assert.Error(t, err, "expected a timeout against a malformed responder")
+
+	// scatter to both, gather the honest answer
+	results := make(chan string, 2)
+	callback := func(msg []byte) { results <- string(msg) }
+	assert.NoError(t, reqProto.SendAsyncRequest(1, nil, muted.PublicKey().String(), callback))
+	assert.NoError(t, reqProto.SendAsyncRequest(1, nil, honest.PublicKey().String(), callback))
+
+	select {
+	case res := <-results:
+		assert.Equal(t, "some value to return", res, "unexpected scatter-gather result")
+	case <-time.After(time.Second):
+		t.Fatal("scatter-gather did not complete via the honest peer")
+	}
+}
@@ -1,24 +1,57 @@
 package gossip
 
 import (
+	"context"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/davecgh/go-spew/spew"
 	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/journal"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/config"
 	"github.com/spacemeshos/go-spacemesh/p2p/message"
 	"github.com/spacemeshos/go-spacemesh/p2p/net"
 	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/trace"
+	"github.com/spacemeshos/go-spacemesh/taskgroup"
+	"github.com/spacemeshos/go-spacemesh/timesync"
 	"sync"
 	"time"
 )
 
 const PeerMessageQueueSize = 100
 
+// PeerSendDeadline bounds a single send to a peer - a peer that stops
+// draining its socket is disconnected instead of wedging its goroutine.
+const PeerSendDeadline = 30 * time.Second
+
+// maintenanceRestarts is how many times the peer maintenance loop is
+// restarted after an unexpected failure before the neighborhood gives up.
+const maintenanceRestarts = 3
+
+// shutdownTimeout bounds how long Shutdown waits for the neighborhood's
+// supervised loops to exit.
+const shutdownTimeout = 5 * time.Second
+
+var (
+	// ErrNoPeers is returned when a broadcast reached no peer at all - not
+	// the current neighbors and none of the sampled fallback candidates
+	// either. The message is not marked old, so it may be retried.
+	ErrNoPeers = errors.New("no peers received the message")
+	// ErrOldMessage is returned when the message was broadcast recently and
+	// the dedupe cache dropped it.
+	ErrOldMessage = errors.New("old message")
+)
+
 type Protocol interface {
-	Broadcast(payload []byte) error
+	// Broadcast queues the message to the neighborhood and returns the number
+	// of peers that actually received it.
+	Broadcast(protocol string, payload []byte) (int, error)
+	// BroadcastTraced is Broadcast for a message carrying a trace id, so the
+	// neighborhood can emit per-peer trace events. Broadcast is the same as
+	// BroadcastTraced with the empty id.
+	BroadcastTraced(protocol string, payload []byte, tid trace.ID) (int, error)
 	Start() error
 	Peer(pubkey string) (node.Node, net.Connection)
 	Shutdown()
@@ -37,31 +70,55 @@ type Neighborhood struct {
 
 	config config.SwarmConfig
 
+	// localID tags this node's trace events, set by the swarm after
+	// construction
+	localID string
+
 	peers        map[string]*peer
 	morePeersReq chan struct{}
 
+	// the broadcast dedupe cache - message hash to insertion time. Entries
+	// older than the configured ttl no longer block a re-broadcast and are
+	// swept in the background, so the cache stays bounded on long-running
+	// nodes.
 	oldMessageMu sync.RWMutex
-	oldMessageQ  map[string]struct{}
+	oldMessageQ  map[string]time.Time
+
+	// clock drives the dedupe cache's timestamps and sweeper - tests inject
+	// a virtual clock here
+	clock timesync.Clock
 
 	ps PeerSampler
 
 	cp ConnectionFactory
 
-	shutdown chan struct{}
+	// supervises the maintenance loop and the per-peer send loops
+	tasks *taskgroup.Group
+
+	// peer churn is recorded here - nil (the journal package is nil-safe)
+	// unless the swarm wires the local node's journal in
+	journal *journal.Journal
 
 	peersMutex sync.RWMutex
 }
 
-func NewNeighborhood(config config.SwarmConfig, ps PeerSampler, cp ConnectionFactory, log2 log.Log) *Neighborhood {
+// NewNeighborhood creates a gossip neighborhood. It refuses a swarm config
+// whose peer counts would make the protocol misbehave.
+func NewNeighborhood(config config.SwarmConfig, ps PeerSampler, cp ConnectionFactory, log2 log.Log) (*Neighborhood, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	return &Neighborhood{
 		Log:          log2,
 		config:       config,
 		morePeersReq: make(chan struct{}, config.RandomConnections),
 		peers:        make(map[string]*peer, config.RandomConnections),
-		oldMessageQ:  make(map[string]struct{}), // todo : remember to drain this
+		oldMessageQ:  make(map[string]time.Time),
+		clock:        timesync.RealClock{},
 		ps:           ps,
 		cp:           cp,
-	}
+		tasks:        taskgroup.New(),
+	}, nil
 }
 
 var _ Protocol = new(Neighborhood)
@@ -92,10 +149,27 @@ func (p *peer) send(message []byte) error {
 	if p.conn == nil || p.conn.Session() == nil {
 		return fmt.Errorf("the connection does not exist for this peer")
 	}
-	return p.conn.Send(message)
+	return p.conn.SendWithDeadline(message, PeerSendDeadline)
+}
+
+// SetLocalID sets the node id stamped on this neighborhood's trace events.
+func (s *Neighborhood) SetLocalID(id string) {
+	s.localID = id
+}
+
+// SetJournal wires the local node's event journal in so peer churn is
+// recorded to it.
+func (s *Neighborhood) SetJournal(j *journal.Journal) {
+	s.journal = j
+}
+
+// SetClock replaces the clock driving the dedupe cache, so tests can expire
+// entries without waiting out the ttl.
+func (s *Neighborhood) SetClock(c timesync.Clock) {
+	s.clock = c
 }
 
-func (p *peer) addMessage(msg []byte) error {
+func (p *peer) addMessage(protocol string, msg []byte) error {
 	// dont do anything if this peer know this msg
 	if _, ok := p.knownMessages[hex.EncodeToString(msg)]; ok {
 		return errors.New("already got this msg")
@@ -109,7 +183,7 @@ func (p *peer) addMessage(msg []byte) error {
 		return errors.New("no session")
 	}
 
-	data, err := message.PrepareMessage(session, msg)
+	data, err := message.PrepareMessage(session, protocol, msg)
 
 	if err != nil {
 		return err
@@ -126,26 +200,46 @@ func (p *peer) addMessage(msg []byte) error {
 	return nil
 }
 
-func (p *peer) start(dischann chan struct{}) {
+// start runs the peer's send loop until the peer disconnects or the
+// neighborhood shuts down. It returns the disconnect reason, empty on a
+// shutdown.
+func (p *peer) start(ctx context.Context, dischann chan struct{}) string {
 	// check on new peers if they need something we have
 	//c := make(chan []string)
 	//t := time.NewTicker(time.Second * 5)
 	for {
 		select {
+		case <-ctx.Done():
+			// neighborhood is shutting down
+			return ""
 		case m := <-p.msgQ:
 			err := p.send(m)
 			if err != nil {
 				// todo: handle errors
-				log.Error("Failed sending message to this peer %v", p.Node.PublicKey().String())
+				p.With(log.String("peer_id", p.Node.PublicKey().String()), log.String("err", err.Error())).Error("failed sending message to peer")
+				if err == net.ErrSendTimeout {
+					// the stream may hold a partial frame - drop the
+					// connection so a fresh one can be established
+					p.conn.Close()
+				}
 				p.disc <- err
 			}
 		case d := <-p.disc:
-			log.Error("peer disconnected %v", d)
+			p.With(log.String("peer_id", p.Node.PublicKey().String()), log.String("err", d.Error())).Error("peer disconnected")
 			//p.conn.Close()
 			if dischann != nil {
 				dischann <- struct{}{}
 			}
-			return
+			return d.Error()
+		case <-p.conn.Closed():
+			// the connection went down under us - no need to wait for a
+			// failed send to find out
+			reason := fmt.Sprintf("%v", p.conn.CloseReason())
+			p.With(log.String("peer_id", p.Node.PublicKey().String()), log.String("reason", reason)).Error("connection with peer closed")
+			if dischann != nil {
+				dischann <- struct{}{}
+			}
+			return reason
 		}
 
 	}
@@ -153,7 +247,40 @@ func (p *peer) start(dischann chan struct{}) {
 
 func (s *Neighborhood) Shutdown() {
 	// no need to shutdown con, conpool will do so in a shutdown. the morepeerreq won't work
-	close(s.shutdown)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.tasks.Shutdown(ctx); err != nil {
+		s.Error("gossip shutdown incomplete: %v", err)
+	}
+}
+
+// Tasks reports the supervision state of the neighborhood's loops, for the
+// status endpoint.
+func (s *Neighborhood) Tasks() taskgroup.Reporter {
+	return s.tasks
+}
+
+// removePeer drops a disconnected peer from the neighborhood.
+func (s *Neighborhood) removePeer(key string) {
+	s.peersMutex.Lock()
+	delete(s.peers, key)
+	s.peersMutex.Unlock()
+}
+
+// Peers lists the connected gossip peers, each entry the peer's id followed
+// by the client version it reported in its handshake, when known.
+func (s *Neighborhood) Peers() []string {
+	s.peersMutex.RLock()
+	defer s.peersMutex.RUnlock()
+	out := make([]string, 0, len(s.peers))
+	for _, p := range s.peers {
+		entry := p.Node.PublicKey().String()
+		if v := p.Node.Version(); v != "" {
+			entry += " (" + v + ")"
+		}
+		out = append(out, entry)
+	}
+	return out
 }
 
 func (s *Neighborhood) Peer(pubkey string) (node.Node, net.Connection) {
@@ -167,40 +294,204 @@ func (s *Neighborhood) Peer(pubkey string) (node.Node, net.Connection) {
 
 }
 
-// the actual broadcast procedure, loop on peers and add the message to their queues
-func (s *Neighborhood) Broadcast(msg []byte) error {
+// the actual broadcast procedure, loop on peers and add the message to their queues.
+// protocol is the protocol the message is addressed to, it seals the message on aead sessions
+func (s *Neighborhood) Broadcast(protocol string, msg []byte) (int, error) {
+	return s.BroadcastTraced(protocol, msg, trace.EmptyID)
+}
 
-	if len(s.peers) == 0 {
-		return errors.New("No peers in neighborhood")
-	}
+// BroadcastTraced broadcasts a message carrying a trace id, emitting a trace
+// event per peer the message is queued to. It returns the number of peers the
+// message was actually queued to; when the neighborhood delivers to fewer
+// than the configured connection count, fallback peers are pulled from the
+// sampler and tried as well. A message that reached nobody yields ErrNoPeers.
+func (s *Neighborhood) BroadcastTraced(protocol string, msg []byte, tid trace.ID) (int, error) {
 
-	s.oldMessageMu.RLock()
-	if _, ok := s.oldMessageQ[string(msg)]; ok {
+	if s.markOldMessage(string(msg)) {
 		// todo : - have some more metrics for termination
 		// todo	: - maybe tell the peer weg ot this message already?
-		return errors.New("old message")
+		return 0, ErrOldMessage
 	}
-	s.oldMessageMu.RUnlock()
-
-	s.oldMessageMu.Lock()
-	s.oldMessageQ[string(msg)] = struct{}{}
-	s.oldMessageMu.Unlock()
 
+	queued := 0
 	s.peersMutex.RLock()
 	for p := range s.peers {
 		peer := s.peers[p]
-		err := peer.addMessage(msg)
+		err := peer.addMessage(protocol, msg)
 		if err != nil {
 			// report error and maybe replace this peer
-			s.Errorf("Err adding message err=", err)
+			s.With(log.String("peer_id", peer.String()), log.String("err", err.Error())).Error("err adding message to peer queue")
 			continue
 		}
-		s.Debug("adding message to peer %v", peer.Pretty())
+		queued++
+		s.With(log.String("peer_id", peer.String())).Debug("adding message to peer")
+		trace.Emit(trace.Event{TraceID: tid, Node: s.localID, Layer: trace.LayerGossip, Op: "enqueue", Peer: peer.String(), Protocol: protocol})
 	}
 	s.peersMutex.RUnlock()
 
-	//TODO: if we didn't send to RandomConnections then try to other peers.
-	return nil
+	// fewer takers than the configured fan-out - try peers outside the
+	// current neighborhood
+	if queued < s.config.RandomConnections {
+		queued += s.broadcastToFallbacks(protocol, msg, tid, s.config.RandomConnections-queued)
+	}
+
+	if queued == 0 {
+		// nobody got the message - forget it so a retry isn't deduped
+		s.forgetMessage(string(msg))
+		return 0, ErrNoPeers
+	}
+	return queued, nil
+}
+
+// broadcastToFallbacks queues msg to up to missing peers outside the current
+// neighborhood, sampled from the peer sampler. A candidate that connects
+// joins the neighborhood, nudging it back toward the configured connection
+// count. Returns the number of fallback peers the message was queued to.
+func (s *Neighborhood) broadcastToFallbacks(protocol string, msg []byte, tid trace.ID, missing int) int {
+	if s.ps == nil {
+		return 0
+	}
+
+	// oversample - some candidates are neighbors already or won't connect
+	candidates := s.ps.SelectPeers(missing + s.config.RandomConnections)
+
+	queued := 0
+	for _, nd := range candidates {
+		if queued == missing {
+			break
+		}
+		s.peersMutex.RLock()
+		_, ok := s.peers[nd.String()]
+		s.peersMutex.RUnlock()
+		if ok {
+			continue
+		}
+
+		c, err := s.cp.GetConnection(nd.Address(), nd.PublicKey())
+		if err != nil {
+			s.With(log.String("peer_id", nd.String()), log.String("err", err.Error())).Warning("failed connecting to fallback peer")
+			continue
+		}
+
+		peer := s.addPeer(nd, c)
+		if err := peer.addMessage(protocol, msg); err != nil {
+			s.With(log.String("peer_id", peer.String()), log.String("err", err.Error())).Error("err adding message to fallback peer queue")
+			continue
+		}
+		queued++
+		s.With(log.String("peer_id", peer.String())).Debug("adding message to fallback peer")
+		trace.Emit(trace.Event{TraceID: tid, Node: s.localID, Layer: trace.LayerGossip, Op: "enqueue", Peer: peer.String(), Protocol: protocol})
+	}
+	return queued
+}
+
+// markOldMessage records msg in the dedupe cache and reports whether it was
+// already known and still fresh. An expired entry no longer counts - the
+// message is accepted again and its timestamp refreshed.
+func (s *Neighborhood) markOldMessage(msg string) bool {
+	now := s.clock.Now()
+	ttl := s.config.OldMessageCacheTTLOrDefault()
+
+	s.oldMessageMu.Lock()
+	defer s.oldMessageMu.Unlock()
+
+	if seen, ok := s.oldMessageQ[msg]; ok && now.Sub(seen) <= ttl {
+		return true
+	}
+	if max := s.config.OldMessageCacheSizeOrDefault(); len(s.oldMessageQ) >= max {
+		s.evictLocked(now, ttl, max-1)
+	}
+	s.oldMessageQ[msg] = now
+	return false
+}
+
+// forgetMessage drops msg from the dedupe cache, so a broadcast that reached
+// nobody can be retried.
+func (s *Neighborhood) forgetMessage(msg string) {
+	s.oldMessageMu.Lock()
+	delete(s.oldMessageQ, msg)
+	s.oldMessageMu.Unlock()
+}
+
+// sweepOldMessages drops every dedupe entry older than the ttl.
+func (s *Neighborhood) sweepOldMessages() {
+	now := s.clock.Now()
+	ttl := s.config.OldMessageCacheTTLOrDefault()
+	s.oldMessageMu.Lock()
+	s.evictLocked(now, ttl, s.config.OldMessageCacheSizeOrDefault())
+	s.oldMessageMu.Unlock()
+}
+
+// evictLocked drops expired dedupe entries, then - if the cache is still
+// above target - the oldest remaining ones. Called with oldMessageMu held.
+func (s *Neighborhood) evictLocked(now time.Time, ttl time.Duration, target int) {
+	for k, seen := range s.oldMessageQ {
+		if now.Sub(seen) > ttl {
+			delete(s.oldMessageQ, k)
+		}
+	}
+	for len(s.oldMessageQ) > target {
+		var oldest string
+		var oldestAt time.Time
+		first := true
+		for k, seen := range s.oldMessageQ {
+			if first || seen.Before(oldestAt) {
+				oldest, oldestAt, first = k, seen, false
+			}
+		}
+		delete(s.oldMessageQ, oldest)
+	}
+}
+
+// ReportGauges registers the Neighborhood's resource gauges - the dedupe
+// map size and the total and deepest per-peer send queue - with a reporter
+// such as a simulator node's ReportGauge.
+func (s *Neighborhood) ReportGauges(report func(name string, f func() int64)) {
+	report("gossip.old_messages", func() int64 {
+		s.oldMessageMu.RLock()
+		defer s.oldMessageMu.RUnlock()
+		return int64(len(s.oldMessageQ))
+	})
+	report("gossip.peer_queue_total", func() int64 {
+		s.peersMutex.RLock()
+		defer s.peersMutex.RUnlock()
+		var total int64
+		for _, p := range s.peers {
+			total += int64(len(p.msgQ))
+		}
+		return total
+	})
+	report("gossip.peer_queue_max", func() int64 {
+		s.peersMutex.RLock()
+		defer s.peersMutex.RUnlock()
+		var max int64
+		for _, p := range s.peers {
+			if depth := int64(len(p.msgQ)); depth > max {
+				max = depth
+			}
+		}
+		return max
+	})
+}
+
+// addPeer registers a connected node with the neighborhood and starts its
+// send loop under the task group.
+func (s *Neighborhood) addPeer(n node.Node, c net.Connection) *peer {
+	peer := makePeer(n, c, s.Log)
+	s.peersMutex.Lock()
+	s.peers[n.String()] = peer
+	s.peersMutex.Unlock()
+	s.With(log.String("peer_id", n.String())).Debug("added peer to peer list")
+	s.journal.Note(journal.EventPeerConnected, n.PublicKey().String(), "")
+	s.tasks.Go("gossip/peer/"+n.String(), func(ctx context.Context) error {
+		reason := peer.start(ctx, s.morePeersReq)
+		s.removePeer(peer.Node.String())
+		if reason != "" {
+			s.journal.Note(journal.EventPeerDisconnected, peer.Node.PublicKey().String(), reason)
+		}
+		return nil
+	})
+	return peer
 }
 
 func (s *Neighborhood) getMorePeers(numpeers int) {
@@ -228,7 +519,7 @@ func (s *Neighborhood) getMorePeers(numpeers int) {
 	// TODO: try splitting the load and don't connect to more than X at a time
 	for i := 0; i < ndsLen; i++ {
 		go func(nd node.Node, reportChan chan cnErr) {
-			spew.Dump(nd)
+			s.With(log.String("peer_id", nd.String())).Debug("connecting to peer")
 			c, err := s.cp.GetConnection(nd.Address(), nd.PublicKey())
 			reportChan <- cnErr{nd, c, err}
 		}(nds[i], res)
@@ -251,22 +542,26 @@ func (s *Neighborhood) getMorePeers(numpeers int) {
 			s.morePeersReq <- struct{}{}
 			continue
 		}
-		peer := makePeer(cne.n, cne.c, s.Log)
-		s.peersMutex.Lock()
-		s.peers[cne.n.String()] = peer
-		s.peersMutex.Unlock()
-		s.Debug("Neighborhood: Added peer to peer list %v", cne.n.Pretty())
-		go peer.start(s.morePeersReq)
+		s.addPeer(cne.n, cne.c)
 
 		if i == numpeers {
 			close(res)
 		}
 	}
-	s.Info("Connected to %d/%d peers", i-j, s.config.RandomConnections)
+	s.With(log.Int("connected", i-j), log.Int("target", s.config.RandomConnections)).Info("connected to peers")
 	if i-j < s.config.RandomConnections {
 		s.morePeersReq <- struct{}{}
 	}
-	s.Info(spew.Sdump(s.peers))
+	s.peersMutex.RLock()
+	peerList := make([]node.Node, 0, len(s.peers))
+	for _, p := range s.peers {
+		peerList = append(peerList, p.Node)
+	}
+	s.peersMutex.RUnlock()
+
+	if data, err := json.Marshal(peerList); err == nil {
+		s.Info("Neighborhood peers: %s", data)
+	}
 
 }
 
@@ -281,9 +576,22 @@ func (s *Neighborhood) Start() error {
 	s.morePeersReq <- struct{}{}
 	ret := make(chan struct{})
 
-	go func() {
-		var o sync.Once
-	loop:
+	// the dedupe cache sweeper - expired entries are also ignored lazily on
+	// lookup, this reclaims their memory
+	s.tasks.Go("gossip/oldmsg-sweep", func(ctx context.Context) error {
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-s.clock.After(s.config.OldMessageCacheTTLOrDefault()):
+				s.sweepOldMessages()
+			}
+		}
+	})
+
+	// o lives outside the task so a restarted loop can't signal ret twice
+	var o sync.Once
+	s.tasks.GoWithPolicy("gossip/maintenance", taskgroup.Backoff(maintenanceRestarts, time.Second), func(ctx context.Context) error {
 		for {
 			select {
 			case <-s.morePeersReq:
@@ -297,11 +605,11 @@ func (s *Neighborhood) Start() error {
 				if len(s.peers) == s.config.RandomConnections {
 					o.Do(func() { ret <- struct{}{} })
 				}
-			case <-s.shutdown:
-				break loop // maybe error ?
+			case <-ctx.Done():
+				return nil // maybe error ?
 			}
 		}
-	}()
+	})
 
 	<-ret
 
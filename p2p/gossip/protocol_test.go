@@ -1,12 +1,19 @@
 package gossip
 
 import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/config"
 	"github.com/spacemeshos/go-spacemesh/p2p/net"
 	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 func TestNeighborhood_Peer(t *testing.T) {
@@ -21,7 +28,239 @@ func TestNeighborhood_Peer(t *testing.T) {
 }
 
 func TestNeighborhood_Broadcast(t *testing.T) {
-	n := NewNeighborhood(config.DefaultConfig().SwarmConfig, nil, nil, log.New("tesT", "", ""))
-	err := n.Broadcast([]byte("msg"))
-	assert.Error(t, err)
+	n, err := NewNeighborhood(config.DefaultConfig().SwarmConfig, nil, nil, log.New("tesT", "", ""))
+	assert.NoError(t, err)
+	queued, err := n.Broadcast("EX", []byte("msg"))
+	assert.Equal(t, 0, queued)
+	assert.Equal(t, ErrNoPeers, err)
+	// the undelivered message wasn't marked old - a retry isn't mistaken for
+	// a duplicate
+	_, err = n.Broadcast("EX", []byte("msg"))
+	assert.Equal(t, ErrNoPeers, err)
+}
+
+func TestNeighborhood_ReportGauges(t *testing.T) {
+	n, err := NewNeighborhood(config.DefaultConfig().SwarmConfig, nil, nil, log.New("test", "", ""))
+	assert.NoError(t, err)
+
+	gauges := make(map[string]func() int64)
+	n.ReportGauges(func(name string, f func() int64) { gauges[name] = f })
+	assert.Equal(t, 3, len(gauges))
+
+	assert.Equal(t, int64(0), gauges["gossip.old_messages"]())
+	n.oldMessageMu.Lock()
+	n.oldMessageQ["a"] = time.Now()
+	n.oldMessageQ["b"] = time.Now()
+	n.oldMessageMu.Unlock()
+	assert.Equal(t, int64(2), gauges["gossip.old_messages"]())
+
+	// two peers with queued messages - three in one queue, one in the other
+	for i, depth := range []int{3, 1} {
+		ni := node.GenerateRandomNodeData()
+		cn := &net.ConnectionMock{}
+		cn.SetRemotePublicKey(ni.PublicKey())
+		p := makePeer(ni, cn, log.New("test", "", ""))
+		for j := 0; j < depth; j++ {
+			p.msgQ <- []byte{byte(i), byte(j)}
+		}
+		n.peersMutex.Lock()
+		n.peers[ni.String()] = p
+		n.peersMutex.Unlock()
+	}
+	assert.Equal(t, int64(4), gauges["gossip.peer_queue_total"]())
+	assert.Equal(t, int64(3), gauges["gossip.peer_queue_max"]())
+}
+
+func TestNeighborhood_PeerRemovedOnConnClose(t *testing.T) {
+	n, err := NewNeighborhood(config.DefaultConfig().SwarmConfig, nil, nil, log.New("test", "", ""))
+	assert.NoError(t, err)
+	ni := node.GenerateRandomNodeData()
+	cn := net.NewConnectionMock(ni.PublicKey())
+	cn.SetSession(net.NewSessionMock([]byte("s")))
+	p := makePeer(ni, cn, log.New("test", "", ""))
+	n.peersMutex.Lock()
+	n.peers[ni.String()] = p
+	n.peersMutex.Unlock()
+	go func() {
+		p.start(context.Background(), nil)
+		n.removePeer(p.Node.String())
+	}()
+
+	// the connection drops - the peer notices without an outgoing message
+	cn.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, c := n.Peer(ni.String()); c == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("peer was not removed after its connection closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, int32(0), cn.SendCount())
+}
+
+func TestNeighborhood_StalledPeerEvicted(t *testing.T) {
+	n, err := NewNeighborhood(config.DefaultConfig().SwarmConfig, nil, nil, log.New("test", "", ""))
+	assert.NoError(t, err)
+	ni := node.GenerateRandomNodeData()
+	cn := net.NewConnectionMock(ni.PublicKey())
+	cn.SetSession(net.NewSessionMock([]byte("s")))
+	cn.SetSendResult(net.ErrSendTimeout)
+	p := makePeer(ni, cn, log.New("test", "", ""))
+	n.peersMutex.Lock()
+	n.peers[ni.String()] = p
+	n.peersMutex.Unlock()
+	go func() {
+		p.start(context.Background(), nil)
+		n.removePeer(p.Node.String())
+	}()
+
+	// a send that times out drops the peer and its connection
+	p.msgQ <- []byte("data")
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, c := n.Peer(ni.String()); c == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stalled peer was not evicted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.True(t, cn.IsClosed())
+}
+
+func TestNeighborhood_OldMessageExpiry(t *testing.T) {
+	cfg := config.DefaultConfig().SwarmConfig
+	cfg.OldMessageCacheTTL = time.Minute
+	n, err := NewNeighborhood(cfg, nil, nil, log.New("test", "", ""))
+	assert.NoError(t, err)
+	vc := simulator.NewVirtualClock(time.Now())
+	n.SetClock(vc)
+
+	// a peer to broadcast to - the dedupe check runs before any send
+	ni := node.GenerateRandomNodeData()
+	cn := net.NewConnectionMock(ni.PublicKey())
+	cn.SetSession(net.NewSessionMock([]byte("s")))
+	n.peersMutex.Lock()
+	n.peers[ni.String()] = makePeer(ni, cn, log.New("test", "", ""))
+	n.peersMutex.Unlock()
+
+	_, err = n.Broadcast("EX", []byte("msg"))
+	assert.NoError(t, err)
+	_, err = n.Broadcast("EX", []byte("msg"))
+	assert.Equal(t, ErrOldMessage, err, "a fresh duplicate must be rejected")
+
+	// past the ttl the entry no longer blocks a re-broadcast
+	vc.AdvanceTime(2 * time.Minute)
+	_, err = n.Broadcast("EX", []byte("msg"))
+	assert.NoError(t, err)
+	_, err = n.Broadcast("EX", []byte("msg"))
+	assert.Equal(t, ErrOldMessage, err, "the re-accepted message is deduped again")
+
+	// the sweeper reclaims the expired entries' memory
+	vc.AdvanceTime(2 * time.Minute)
+	n.sweepOldMessages()
+	n.oldMessageMu.RLock()
+	assert.Equal(t, 0, len(n.oldMessageQ))
+	n.oldMessageMu.RUnlock()
+}
+
+func TestNeighborhood_OldMessageCacheCap(t *testing.T) {
+	cfg := config.DefaultConfig().SwarmConfig
+	cfg.OldMessageCacheSize = 3
+	n, err := NewNeighborhood(cfg, nil, nil, log.New("test", "", ""))
+	assert.NoError(t, err)
+	vc := simulator.NewVirtualClock(time.Now())
+	n.SetClock(vc)
+
+	for i := 0; i < 10; i++ {
+		assert.False(t, n.markOldMessage(string(rune('a'+i))))
+		vc.AdvanceTime(time.Second)
+	}
+	n.oldMessageMu.RLock()
+	size := len(n.oldMessageQ)
+	n.oldMessageMu.RUnlock()
+	assert.True(t, size <= 3, "cache held %v entries, cap is 3", size)
+
+	// the newest entry survived the evictions, the oldest did not
+	assert.True(t, n.markOldMessage(string(rune('a'+9))))
+	assert.False(t, n.markOldMessage("a"))
+}
+
+// a PeerSampler serving from a fixed candidate list
+type samplerMock struct {
+	peers []node.Node
+}
+
+func (s *samplerMock) SelectPeers(count int) []node.Node {
+	if count > len(s.peers) {
+		count = len(s.peers)
+	}
+	return s.peers[:count]
+}
+
+// a ConnectionFactory that refuses to dial a configured set of peers
+type factoryMock struct {
+	mutex   sync.Mutex
+	failFor map[string]struct{}
+}
+
+func (f *factoryMock) GetConnection(address string, pk crypto.PublicKey) (net.Connection, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.failFor[pk.String()]; ok {
+		return nil, errors.New("dial failed")
+	}
+	cn := net.NewConnectionMock(pk)
+	cn.SetSession(net.NewSessionMock([]byte("s")))
+	return cn, nil
+}
+
+func TestNeighborhood_BroadcastFallback(t *testing.T) {
+	cfg := config.DefaultConfig().SwarmConfig
+	cfg.RandomConnections = 4
+
+	// twice the fan-out in candidates, connections to half of them fail
+	candidates := node.GenerateRandomNodesData(2 * cfg.RandomConnections)
+	factory := &factoryMock{failFor: map[string]struct{}{}}
+	for _, c := range candidates[:cfg.RandomConnections] {
+		factory.failFor[c.PublicKey().String()] = struct{}{}
+	}
+
+	n, err := NewNeighborhood(cfg, &samplerMock{peers: candidates}, factory, log.New("test", "", ""))
+	assert.NoError(t, err)
+
+	// the neighborhood starts empty - every delivery goes through fallbacks
+	queued, err := n.Broadcast("EX", []byte("msg"))
+	assert.NoError(t, err)
+	assert.Equal(t, cfg.RandomConnections, queued, "broadcast should reach the configured fan-out via fallback peers")
+
+	// the reachable candidates joined the neighborhood along the way
+	n.peersMutex.RLock()
+	assert.Equal(t, cfg.RandomConnections, len(n.peers))
+	n.peersMutex.RUnlock()
+}
+
+func TestNeighborhood_BroadcastNoPeers(t *testing.T) {
+	cfg := config.DefaultConfig().SwarmConfig
+	cfg.RandomConnections = 4
+
+	// every dial fails - nobody can receive the message
+	candidates := node.GenerateRandomNodesData(2 * cfg.RandomConnections)
+	factory := &factoryMock{failFor: map[string]struct{}{}}
+	for _, c := range candidates {
+		factory.failFor[c.PublicKey().String()] = struct{}{}
+	}
+
+	n, err := NewNeighborhood(cfg, &samplerMock{peers: candidates}, factory, log.New("test", "", ""))
+	assert.NoError(t, err)
+
+	queued, err := n.Broadcast("EX", []byte("msg"))
+	assert.Equal(t, 0, queued)
+	assert.Equal(t, ErrNoPeers, err)
 }
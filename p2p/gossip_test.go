@@ -81,7 +81,7 @@ func TestSwarm_GossipRoundTrip(t *testing.T) {
 	//var wg sync.WaitGroup
 	assert.Len(t, chans, numPeers)
 	for c := range chans {
-		if nodes[c].lNode.PublicKey().String() == bn.lNode.PublicKey().String() {
+		if nodes[c].lNode.Equal(bn.lNode.Node) {
 			t.Error("WTF HAPE")
 		}
 		var resp service.Message
@@ -170,7 +170,7 @@ func TestSwarm_GossipRoundTrip2(t *testing.T) {
 		for j := range nodes {
 			ono := nodes[j]
 			n, _ := ono.gossip.Peer(no.lNode.PublicKey().String())
-			if n == node.EmptyNode {
+			if n.IsEmpty() {
 				continue
 			}
 			found = true
@@ -11,19 +11,31 @@ import (
 	"time"
 )
 
-// prepares a message for sending on a given session, session must be checked first
-func PrepareMessage(ns net.NetworkSession, data []byte) ([]byte, error) {
-	encPayload, err := ns.Encrypt(data)
-	if err != nil {
-		return nil, fmt.Errorf("aborting send - failed to encrypt payload: %v", err)
-	}
-
+// PrepareMessage seals a message for sending on a given session, session must
+// be checked first. A session that negotiated the aead capability seals with
+// it, binding the protocol name - older peers get the legacy cbc encryption.
+func PrepareMessage(ns net.NetworkSession, protocol string, data []byte) ([]byte, error) {
 	cmd := &pb.CommonMessageData{
 		SessionId: ns.ID(),
-		Payload:   encPayload,
 		Timestamp: time.Now().Unix(),
 	}
 
+	if ns.AEADEnabled() {
+		nonce, sealed, err := ns.SealMessage(protocol, data)
+		if err != nil {
+			return nil, fmt.Errorf("aborting send - failed to seal payload: %v", err)
+		}
+		cmd.Payload = sealed
+		cmd.AeadNonce = nonce
+		cmd.Protocol = protocol
+	} else {
+		encPayload, err := ns.Encrypt(data)
+		if err != nil {
+			return nil, fmt.Errorf("aborting send - failed to encrypt payload: %v", err)
+		}
+		cmd.Payload = encPayload
+	}
+
 	final, err := proto.Marshal(cmd)
 	if err != nil {
 		e := fmt.Errorf("aborting send - invalid msg format %v", err)
@@ -33,6 +45,23 @@ func PrepareMessage(ns net.NetworkSession, data []byte) ([]byte, error) {
 	return final, nil
 }
 
+// OpenPayload is the inverse of PrepareMessage - it authenticates and
+// decrypts an incoming message's payload. Tampering fails here, before any
+// payload byte is parsed. A legacy cbc frame on a session that negotiated
+// the aead is refused as a downgrade, and vice versa.
+func OpenPayload(ns net.NetworkSession, cmd *pb.CommonMessageData) ([]byte, error) {
+	if ns.AEADEnabled() {
+		if cmd.AeadNonce == 0 {
+			return nil, fmt.Errorf("refusing legacy frame on a session with negotiated aead")
+		}
+		return ns.OpenMessage(cmd.Protocol, cmd.AeadNonce, cmd.Payload)
+	}
+	if cmd.AeadNonce != 0 {
+		return nil, fmt.Errorf("unexpected aead frame on a session without negotiated aead")
+	}
+	return ns.Decrypt(cmd.Payload)
+}
+
 // newProtocolMessageMetadata creates meta-data for an outgoing protocol message authored by this node.
 func NewProtocolMessageMetadata(author crypto.PublicKey, protocol string, gossip bool) *pb.Metadata {
 	return &pb.Metadata{
@@ -62,6 +91,31 @@ func SignMessage(pv crypto.PrivateKey, pm *pb.ProtocolMessage) error {
 	return nil
 }
 
+// AuthParts extracts the author verification triple from a signed message -
+// the claimed author's key, the signed corpus and the raw signature. It lets
+// a caller verify many messages as one batch through crypto.VerifyBatch;
+// AuthAuthor stays the one-shot path.
+func AuthParts(pm *pb.ProtocolMessage) (pub, data, sig []byte, err error) {
+	if pm == nil || pm.Metadata == nil {
+		return nil, nil, nil, fmt.Errorf("can't extract auth parts from a message without metadata")
+	}
+
+	sign := pm.Metadata.AuthorSign
+	pm.Metadata.AuthorSign = "" // the corpus is the message without its sign
+	data, err = proto.Marshal(pm)
+	pm.Metadata.AuthorSign = sign
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sig, err = hex.DecodeString(sign)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return pm.Metadata.AuthPubKey, data, sig, nil
+}
+
 // authAuthor authorizes that a message is signed by its claimed author
 func AuthAuthor(pm *pb.ProtocolMessage) error {
 	// TODO: consider getting pubkey from outside. attackar coul'd just manipulate the whole message pubkey and sign.
@@ -73,7 +127,9 @@ func AuthAuthor(pm *pb.ProtocolMessage) error {
 	sign := pm.Metadata.AuthorSign
 	sPubkey := pm.Metadata.AuthPubKey
 
-	pubkey, err := crypto.NewPublicKey(sPubkey)
+	// every envelope re-parses its author's key - the cache serves the
+	// parsed object for the peers we keep hearing from
+	pubkey, err := crypto.NewPublicKeyCached(sPubkey)
 	if err != nil {
 		return fmt.Errorf("could'nt create public key from %v, err: %v", hex.EncodeToString(sPubkey), err)
 	}
@@ -1,6 +1,7 @@
 package net
 
 import (
+	"bytes"
 	"errors"
 	"time"
 
@@ -20,6 +21,26 @@ var (
 	ErrClosedIncomingChannel = errors.New("unexpected closed incoming channel")
 	// ErrConnectionClosed is sent when the connection is closed after Close was called
 	ErrConnectionClosed = errors.New("connections was intentionally closed")
+	// ErrKeepAliveTimeout is sent when the remote peer did not answer a keepalive ping in time
+	ErrKeepAliveTimeout = errors.New("no pong within the keepalive timeout")
+	// ErrIdleTimeout is sent when nothing arrived on the connection for longer than the idle timeout
+	ErrIdleTimeout = errors.New("connection was idle for too long")
+	// ErrSendTimeout is returned when a send did not complete within its deadline.
+	// Unlike a permanent connection error the peer may just be slow to drain its
+	// socket, so callers can decide whether to retry or to disconnect.
+	ErrSendTimeout = errors.New("send did not complete within its deadline")
+	// ErrSendBusy is returned by TrySend when a previous send has not completed yet
+	ErrSendBusy = errors.New("a previous send is still in progress")
+	// ErrHandshakeTimeout is sent when the peer completed the TCP connect but
+	// did not finish the session handshake within the configured deadline
+	ErrHandshakeTimeout = errors.New("handshake did not complete in time")
+)
+
+// keepalive frames travel below the protocol layer. Protocol traffic is
+// always a protobuf envelope longer than two bytes, so they can't collide.
+var (
+	pingFrame = []byte{0xbe, 0x50}
+	pongFrame = []byte{0xbe, 0x51}
 )
 
 // ConnectionSource specifies the connection originator - local or remote node.
@@ -45,8 +66,51 @@ type Connection interface {
 	SetSession(session NetworkSession)
 
 	Send(m []byte) error
+	SendTagged(protocol string, m []byte) error
+	SendWithDeadline(m []byte, d time.Duration) error
+	TrySend(m []byte) error
 	Close()
-	Closed() bool
+	Closed() <-chan struct{}
+	CloseReason() error
+	Stats() ConnStats
+}
+
+// ConnStats is a snapshot of a connection's transfer counters. Byte counts
+// are of the actual wire frames - the (possibly encrypted) payload plus its
+// length prefix.
+type ConnStats struct {
+	BytesSent        int64
+	BytesReceived    int64
+	MessagesSent     int64
+	MessagesReceived int64
+}
+
+// Add accumulates another snapshot's counters into this one.
+func (s *ConnStats) Add(other ConnStats) {
+	s.BytesSent += other.BytesSent
+	s.BytesReceived += other.BytesReceived
+	s.MessagesSent += other.MessagesSent
+	s.MessagesReceived += other.MessagesReceived
+}
+
+// Metrics is a snapshot of transfer totals - overall, per remote peer and per
+// protocol - plus the rejected-connection counters, suitable for a status
+// report.
+type Metrics struct {
+	Total     ConnStats
+	Peers     map[string]ConnStats
+	Protocols map[string]ConnStats
+	Rejected  map[string]int64
+}
+
+// FramedSize is the on-wire size of a message - the payload plus its uvarint
+// length prefix, matching the delimited formatter's framing.
+func FramedSize(payload int) int64 {
+	prefix := 1
+	for n := uint64(payload); n >= 0x80; n >>= 7 {
+		prefix++
+	}
+	return int64(payload + prefix)
 }
 
 // FormattedConnection is an io.Writer and an io.Closer
@@ -64,6 +128,34 @@ type FormattedConnection struct {
 	session    NetworkSession
 	closeOnce  sync.Once
 	closed     int32
+
+	// keepalive state - zero durations disable the respective check
+	pingInterval time.Duration
+	pongTimeout  time.Duration
+	idleTimeout  time.Duration
+	lastRecv     int64 // unix nanos of the last incoming frame
+	closeReason  error // why the connection went down, guarded by reasonMutex
+	reasonMutex  sync.Mutex
+	done         chan struct{}
+
+	// transfer counters, accessed atomically
+	bytesSent int64
+	bytesRecv int64
+	msgsSent  int64
+	msgsRecv  int64
+
+	// bounded sends - the socket's write deadline support (nil when the
+	// underlying stream has none) and the number of sends in flight
+	deadliner     writeDeadliner
+	deadlineMutex sync.Mutex
+	sendsInFlight int32
+
+	// negotiated frame transform (e.g. compression), nil for plain frames
+	transform      frameTransform
+	transformMutex sync.RWMutex
+
+	// optional weighted fair scheduler for tagged sends, nil when disabled
+	qos *qosScheduler
 }
 
 type networker interface {
@@ -78,6 +170,12 @@ type readWriteCloseAddresser interface {
 	RemoteAddr() net.Addr
 }
 
+// writeDeadliner is implemented by sockets supporting write deadlines - a raw
+// TCP connection does, test mocks may not.
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
 // Create a new connection wrapping a net.Conn with a provided connection manager
 func newConnection(conn readWriteCloseAddresser, netw networker, formatter wire.Formatter, remotePub crypto.PublicKey, log *logging.Logger) *FormattedConnection {
 
@@ -92,8 +190,13 @@ func newConnection(conn readWriteCloseAddresser, netw networker, formatter wire.
 		networker:  netw,
 		closeChan:  make(chan struct{}),
 		closed:     0,
+		lastRecv:   time.Now().UnixNano(),
+		done:       make(chan struct{}),
 	}
 
+	if wd, ok := conn.(writeDeadliner); ok {
+		connection.deadliner = wd
+	}
 	connection.formatter.Pipe(conn)
 	return connection
 }
@@ -142,29 +245,252 @@ func (c *FormattedConnection) incomingChannel() chan []byte {
 	return c.formatter.In()
 }
 
+// setFrameTransform installs the frame transform negotiated during the
+// handshake. The handshake frames themselves always travel plain - this is
+// called once the session is established, before protocol traffic starts.
+func (c *FormattedConnection) setFrameTransform(t frameTransform) {
+	c.transformMutex.Lock()
+	c.transform = t
+	c.transformMutex.Unlock()
+}
+
+func (c *FormattedConnection) currentTransform() frameTransform {
+	c.transformMutex.RLock()
+	defer c.transformMutex.RUnlock()
+	return c.transform
+}
+
 // Send binary data to a connection
 // data is copied over so caller can get rid of the data
 // Concurrency: can be called from any go routine
 func (c *FormattedConnection) Send(m []byte) error {
-	return c.formatter.Out(m)
+	if t := c.currentTransform(); t != nil {
+		var err error
+		if m, err = t.encode(m); err != nil {
+			return err
+		}
+	}
+	atomic.AddInt32(&c.sendsInFlight, 1)
+	err := c.formatter.Out(m)
+	atomic.AddInt32(&c.sendsInFlight, -1)
+	if err == nil {
+		atomic.AddInt64(&c.bytesSent, FramedSize(len(m)))
+		atomic.AddInt64(&c.msgsSent, 1)
+	}
+	return err
+}
+
+// setQoS installs the weighted fair scheduler for tagged sends. Must be
+// called before the connection is handed out, like setKeepAlive.
+func (c *FormattedConnection) setQoS(weights map[string]int, depth int) {
+	if len(weights) == 0 || depth <= 0 {
+		return
+	}
+	c.qos = newQoSScheduler(c, weights, depth)
+}
+
+// SendTagged sends a frame tagged with the protocol it belongs to. With the
+// QoS scheduler enabled and several protocols sharing the connection, frames
+// are queued and drained by protocol weight, and a full protocol queue fails
+// fast with ErrProtocolQueueFull. Otherwise it behaves exactly like Send.
+// Note that reordering frames is only safe for traffic whose encryption does
+// not chain messages together - session traffic must keep using Send until
+// that holds.
+func (c *FormattedConnection) SendTagged(protocol string, m []byte) error {
+	if c.qos == nil {
+		return c.Send(m)
+	}
+	return c.qos.send(protocol, m)
+}
+
+// SendWithDeadline is Send bounded by d - when the peer stops draining its
+// socket the write fails with ErrSendTimeout instead of blocking forever. A
+// timed-out frame may have been partially written, so the caller should
+// consider the stream suspect and close it rather than retry on the spot. On
+// streams without write deadline support it falls back to a plain Send.
+func (c *FormattedConnection) SendWithDeadline(m []byte, d time.Duration) error {
+	if c.deadliner == nil {
+		return c.Send(m)
+	}
+	c.deadlineMutex.Lock()
+	defer c.deadlineMutex.Unlock()
+	if err := c.deadliner.SetWriteDeadline(time.Now().Add(d)); err != nil {
+		return err
+	}
+	err := c.Send(m)
+	c.deadliner.SetWriteDeadline(time.Time{})
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return ErrSendTimeout
+	}
+	return err
+}
+
+// TrySend is Send that fails fast with ErrSendBusy while a previous send has
+// not completed, instead of queueing behind it.
+func (c *FormattedConnection) TrySend(m []byte) error {
+	if atomic.LoadInt32(&c.sendsInFlight) > 0 {
+		return ErrSendBusy
+	}
+	return c.Send(m)
+}
+
+// Stats returns a snapshot of the connection's transfer counters.
+func (c *FormattedConnection) Stats() ConnStats {
+	return ConnStats{
+		BytesSent:        atomic.LoadInt64(&c.bytesSent),
+		BytesReceived:    atomic.LoadInt64(&c.bytesRecv),
+		MessagesSent:     atomic.LoadInt64(&c.msgsSent),
+		MessagesReceived: atomic.LoadInt64(&c.msgsRecv),
+	}
+}
+
+// setKeepAlive configures the connection's liveness checks - a ping after
+// pingInterval of incoming silence expecting a pong within pongTimeout, and
+// a hard close after idleTimeout with nothing incoming at all. Zero values
+// disable the respective check. Must be called before beginEventProcessing.
+func (c *FormattedConnection) setKeepAlive(pingInterval, pongTimeout, idleTimeout time.Duration) {
+	c.pingInterval = pingInterval
+	c.pongTimeout = pongTimeout
+	c.idleTimeout = idleTimeout
+}
+
+// noteActivity remembers when the last frame arrived.
+func (c *FormattedConnection) noteActivity() {
+	atomic.StoreInt64(&c.lastRecv, time.Now().UnixNano())
+}
+
+// lastActivity returns when the last frame arrived.
+func (c *FormattedConnection) lastActivity() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastRecv))
 }
 
-// Close closes the connection (implements io.Closer). It is go safe.
+// keepalive watches the connection's incoming activity, pinging an idle
+// peer and closing the connection when the pong is late or the idle timeout
+// passes. Closure is reported through the usual close/err signaling.
+func (c *FormattedConnection) keepalive() {
+	var pingAt time.Time
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(c.keepaliveStep()):
+		}
+
+		now := time.Now()
+		last := c.lastActivity()
+		if c.idleTimeout > 0 && now.Sub(last) >= c.idleTimeout {
+			c.logger.Warning("(%v) idle for %v, closing. id=%s", c.remotePub, now.Sub(last), c.id)
+			c.closeWith(ErrIdleTimeout)
+			return
+		}
+		if c.pingInterval <= 0 {
+			continue
+		}
+		if !pingAt.IsZero() && last.Before(pingAt) {
+			// a ping is outstanding and nothing arrived since
+			if now.Sub(pingAt) >= c.pongTimeout {
+				c.logger.Warning("(%v) no pong within %v, closing. id=%s", c.remotePub, c.pongTimeout, c.id)
+				c.closeWith(ErrKeepAliveTimeout)
+				return
+			}
+			continue
+		}
+		if now.Sub(last) >= c.pingInterval {
+			if err := c.Send(pingFrame); err != nil {
+				c.closeWith(ErrKeepAliveTimeout)
+				return
+			}
+			pingAt = now
+		}
+	}
+}
+
+// keepaliveStep returns how often the keepalive monitor wakes up - a
+// quarter of the tightest enabled deadline.
+func (c *FormattedConnection) keepaliveStep() time.Duration {
+	step := c.idleTimeout
+	if c.pingInterval > 0 && (step == 0 || c.pingInterval < step) {
+		step = c.pingInterval
+	}
+	if c.pingInterval > 0 && c.pongTimeout > 0 && c.pongTimeout < step {
+		step = c.pongTimeout
+	}
+	return step / 4
+}
+
+// setCloseReason records why the connection went down. The first recorded
+// reason wins - a Close racing with a remote closure must not rewrite it.
+func (c *FormattedConnection) setCloseReason(reason error) {
+	c.reasonMutex.Lock()
+	if c.closeReason == nil {
+		c.closeReason = reason
+	}
+	c.reasonMutex.Unlock()
+}
+
+func (c *FormattedConnection) closeWith(reason error) {
+	c.closeOnce.Do(func() {
+		atomic.AddInt32(&c.closed, 1)
+		c.setCloseReason(reason)
+		select {
+		case c.closeChan <- struct{}{}:
+		case <-c.done: // the event loop is already gone
+		}
+	})
+}
+
+// Close closes the connection (implements io.Closer). It is go safe and safe
+// to call more than once.
 func (c *FormattedConnection) Close() {
+	c.closeWith(ErrConnectionClosed)
+}
+
+// closeUnstarted tears down a connection whose event loop was never started.
+// Nothing is reading closeChan yet so Close would block - the socket is
+// closed directly and no closing event is published since the connection was
+// never handed out.
+func (c *FormattedConnection) closeUnstarted(reason error) {
 	c.closeOnce.Do(func() {
 		atomic.AddInt32(&c.closed, 1)
-		c.closeChan <- struct{}{}
+		c.setCloseReason(reason)
+		c.formatter.Close()
+		if c.session != nil {
+			c.session.Close()
+		}
+		close(c.done)
 	})
 }
 
-// Closed Reports whether the connection was closed. It is go safe.
-func (c *FormattedConnection) Closed() bool {
+// Closed returns a channel that is closed exactly once when the connection
+// shuts down, whether locally, by the remote side or by the keepalive
+// monitor. Once it fires CloseReason reports why.
+func (c *FormattedConnection) Closed() <-chan struct{} {
+	return c.done
+}
+
+// CloseReason reports why the connection was closed. It is meaningful once
+// the Closed channel fired.
+func (c *FormattedConnection) CloseReason() error {
+	c.reasonMutex.Lock()
+	defer c.reasonMutex.Unlock()
+	return c.closeReason
+}
+
+// IsClosed reports whether the connection was closed. It is go safe.
+func (c *FormattedConnection) IsClosed() bool {
 	return atomic.LoadInt32(&c.closed) > 0
 }
 
 func (c *FormattedConnection) shutdown(err error) {
-	c.logger.Info("(%v) shutdown. id=%s err=%v", c.remotePub.String(), c.id, err)
+	// incoming connections have no remote key before the handshake completes
+	c.logger.Info("(%v) shutdown. id=%s err=%v", c.remotePub, c.id, err)
+	atomic.StoreInt32(&c.closed, 1)
+	c.setCloseReason(err)
+	close(c.done)
 	c.formatter.Close()
+	if c.session != nil {
+		c.session.Close() // wipe the session's key material with the connection
+	}
 	c.networker.ClosingConnections() <- c
 }
 
@@ -172,6 +498,11 @@ func (c *FormattedConnection) shutdown(err error) {
 // Read from the incoming new messages and send down the connection
 func (c *FormattedConnection) beginEventProcessing() {
 
+	c.noteActivity()
+	if c.pingInterval > 0 || c.idleTimeout > 0 {
+		go c.keepalive()
+	}
+
 	var err error
 
 Loop:
@@ -181,9 +512,28 @@ Loop:
 
 			if !ok { // chan closed
 				err = ErrClosedIncomingChannel
+				if formatterErr := c.formatter.Err(); formatterErr != nil {
+					err = formatterErr
+				}
 				break Loop
 			}
 
+			c.noteActivity()
+			atomic.AddInt64(&c.bytesRecv, FramedSize(len(msg)))
+			atomic.AddInt64(&c.msgsRecv, 1)
+			if t := c.currentTransform(); t != nil {
+				if msg, err = t.decode(msg); err != nil {
+					break Loop
+				}
+			}
+			if bytes.Equal(msg, pingFrame) {
+				c.Send(pongFrame)
+				continue
+			}
+			if bytes.Equal(msg, pongFrame) {
+				continue
+			}
+
 			if c.session == nil {
 				err = c.networker.HandlePreSessionIncomingMessage(c, msg)
 				if err != nil {
@@ -194,7 +544,7 @@ Loop:
 			}
 
 		case <-c.closeChan:
-			err = ErrConnectionClosed
+			err = c.closeReason
 			break Loop
 		}
 	}
@@ -16,8 +16,11 @@ type ConnectionMock struct {
 	sendDelayMs int
 	sendRes     error
 	sendCnt     int32
+	sentBytes   int64
 
-	closed bool
+	closed      bool
+	closeReason error
+	closeChan   chan struct{}
 }
 
 func NewConnectionMock(key crypto.PublicKey) *ConnectionMock {
@@ -25,6 +28,7 @@ func NewConnectionMock(key crypto.PublicKey) *ConnectionMock {
 		id:        crypto.UUIDString(),
 		remotePub: key,
 		closed:    false,
+		closeChan: make(chan struct{}),
 	}
 }
 
@@ -78,16 +82,58 @@ func (cm ConnectionMock) SendCount() int32 {
 
 func (cm *ConnectionMock) Send(m []byte) error {
 	atomic.AddInt32(&cm.sendCnt, int32(1))
+	atomic.AddInt64(&cm.sentBytes, FramedSize(len(m)))
 	time.Sleep(time.Duration(cm.sendDelayMs) * time.Millisecond)
 	return cm.sendRes
 }
 
-func (cm ConnectionMock) Closed() bool {
+func (cm *ConnectionMock) SendTagged(protocol string, m []byte) error {
+	return cm.Send(m)
+}
+
+func (cm *ConnectionMock) SendWithDeadline(m []byte, d time.Duration) error {
+	return cm.Send(m)
+}
+
+func (cm *ConnectionMock) TrySend(m []byte) error {
+	return cm.Send(m)
+}
+
+func (cm *ConnectionMock) Stats() ConnStats {
+	return ConnStats{
+		BytesSent:    atomic.LoadInt64(&cm.sentBytes),
+		MessagesSent: int64(atomic.LoadInt32(&cm.sendCnt)),
+	}
+}
+
+func (cm *ConnectionMock) Closed() <-chan struct{} {
+	// a zero-value mock has no channel - lazily create one
+	if cm.closeChan == nil {
+		cm.closeChan = make(chan struct{})
+		if cm.closed {
+			close(cm.closeChan)
+		}
+	}
+	return cm.closeChan
+}
+
+func (cm *ConnectionMock) CloseReason() error {
+	return cm.closeReason
+}
+
+func (cm *ConnectionMock) IsClosed() bool {
 	return cm.closed
 }
 
 func (cm *ConnectionMock) Close() {
+	if cm.closed {
+		return
+	}
 	cm.closed = true
+	cm.closeReason = ErrConnectionClosed
+	if cm.closeChan != nil {
+		close(cm.closeChan)
+	}
 }
 
 func (cm *ConnectionMock) beginEventProcessing() {
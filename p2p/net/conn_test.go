@@ -1,12 +1,15 @@
 package net
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
 	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/p2p/delimited"
 	"github.com/stretchr/testify/assert"
 	"math/rand"
 	"net"
+	"sync"
 	"testing"
 	"time"
 )
@@ -148,3 +151,322 @@ func TestGettersToBoostCoverage(t *testing.T) {
 	assert.Equal(t, addr.String(), conn.RemoteAddr().String())
 
 }
+
+func TestOversizedFrameClosesConnection(t *testing.T) {
+	netw := NewNetworkMock()
+	rwcam := NewReadWriteCloseAddresserMock()
+	rPub := generatePublicKey()
+	formatter := delimited.NewChan(10)
+	formatter.SetMaxFrameSize(1024)
+	conn := newConnection(rwcam, netw, formatter, rPub, netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+	go conn.beginEventProcessing()
+
+	// a crafted prefix declaring a 2GB frame
+	var prefix [binary.MaxVarintLen64]byte
+	v := binary.PutUvarint(prefix[:], 2<<30)
+	rwcam.SetReadResult(prefix[:v], nil)
+
+	select {
+	case closedConn := <-netw.ClosingConnections():
+		assert.Equal(t, conn.id, closedConn.ID())
+	case <-time.After(time.Second):
+		t.Fatal("oversized frame did not close the connection")
+	}
+	frameErr, ok := formatter.Err().(*delimited.FrameTooLargeError)
+	assert.True(t, ok, "expected a FrameTooLargeError, got %v", formatter.Err())
+	assert.Equal(t, uint64(1024), frameErr.Limit)
+}
+
+func TestConnectionStats(t *testing.T) {
+	netw := NewNetworkMock()
+	rwcam := NewReadWriteCloseAddresserMock()
+	rPub := generatePublicKey()
+	formatter := delimited.NewChan(10)
+	conn := newConnection(rwcam, netw, formatter, rPub, netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+	go conn.beginEventProcessing()
+
+	msg := []byte("hello world")
+	err := conn.Send(msg)
+	assert.NoError(t, err)
+	stats := conn.Stats()
+	assert.Equal(t, FramedSize(len(msg)), stats.BytesSent, "sent bytes should count the frame, prefix included")
+	assert.Equal(t, int64(1), stats.MessagesSent)
+	assert.Equal(t, int64(len(rwcam.WriteOut())), stats.BytesSent, "counted bytes should match what hit the wire")
+
+	// feed the frame back in
+	rwcam.SetReadResult(rwcam.WriteOut(), nil)
+	<-netw.IncomingMessages()[0]
+	stats = conn.Stats()
+	assert.Equal(t, FramedSize(len(msg)), stats.BytesReceived)
+	assert.Equal(t, int64(1), stats.MessagesReceived)
+}
+
+func TestConnectionStatsConcurrent(t *testing.T) {
+	netw := NewNetworkMock()
+	rwcam := NewReadWriteCloseAddresserMock()
+	rPub := generatePublicKey()
+	formatter := delimited.NewChan(10)
+	conn := newConnection(rwcam, netw, formatter, rPub, netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+	go conn.beginEventProcessing()
+
+	const workers = 10
+	const perWorker = 20
+	msg := []byte("0123456789")
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				assert.NoError(t, conn.Send(msg))
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := conn.Stats()
+	assert.Equal(t, int64(workers*perWorker)*FramedSize(len(msg)), stats.BytesSent)
+	assert.Equal(t, int64(workers*perWorker), stats.MessagesSent)
+}
+
+func TestFramedSize(t *testing.T) {
+	assert.Equal(t, int64(6), FramedSize(5), "small frames carry a one byte prefix")
+	assert.Equal(t, int64(128), FramedSize(127), "127 still fits a one byte prefix")
+	assert.Equal(t, int64(130), FramedSize(128), "128 needs a two byte prefix")
+	assert.Equal(t, int64(16387), FramedSize(16384), "16384 needs a three byte prefix")
+}
+
+func TestKeepAliveClosesUnresponsivePeer(t *testing.T) {
+	netw := NewNetworkMock()
+	rwcam := NewReadWriteCloseAddresserMock()
+	rPub := generatePublicKey()
+	formatter := delimited.NewChan(10)
+	conn := newConnection(rwcam, netw, formatter, rPub, netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+	conn.setKeepAlive(50*time.Millisecond, 50*time.Millisecond, 0)
+
+	start := time.Now()
+	go conn.beginEventProcessing()
+
+	select {
+	case closedConn := <-netw.ClosingConnections():
+		assert.Equal(t, conn.id, closedConn.ID())
+	case <-time.After(time.Second):
+		t.Fatal("unresponsive connection was not closed")
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 50*time.Millisecond, "closed before the ping was even due, after %v", elapsed)
+	assert.True(t, elapsed < 500*time.Millisecond, "expected closure within interval+timeout, took %v", elapsed)
+	assert.True(t, bytes.Contains(rwcam.WriteOut(), pingFrame), "expected a ping on the wire")
+	assert.Equal(t, ErrKeepAliveTimeout, conn.closeReason)
+}
+
+func TestKeepAliveSuppressedByTraffic(t *testing.T) {
+	netw := NewNetworkMock()
+	rwcam := NewReadWriteCloseAddresserMock()
+	rPub := generatePublicKey()
+	formatter := delimited.NewChan(10)
+	conn := newConnection(rwcam, netw, formatter, rPub, netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+	conn.setKeepAlive(80*time.Millisecond, 80*time.Millisecond, 0)
+
+	go conn.beginEventProcessing()
+
+	// steady incoming traffic for longer than the ping interval
+	for i := 0; i < 8; i++ {
+		rwcam.SetReadResult([]byte{1, 'x'}, nil)
+		time.Sleep(25 * time.Millisecond)
+	}
+
+	select {
+	case <-netw.ClosingConnections():
+		t.Fatal("active connection was closed")
+	default:
+	}
+	assert.Equal(t, 0, rwcam.WriteCount(), "active traffic should suppress pings")
+	conn.Close()
+	<-netw.ClosingConnections()
+}
+
+func TestIdleTimeoutClosesConnection(t *testing.T) {
+	netw := NewNetworkMock()
+	rwcam := NewReadWriteCloseAddresserMock()
+	rPub := generatePublicKey()
+	formatter := delimited.NewChan(10)
+	conn := newConnection(rwcam, netw, formatter, rPub, netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+	conn.setKeepAlive(0, 0, 60*time.Millisecond)
+
+	go conn.beginEventProcessing()
+
+	select {
+	case closedConn := <-netw.ClosingConnections():
+		assert.Equal(t, conn.id, closedConn.ID())
+	case <-time.After(time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+	assert.Equal(t, 0, rwcam.WriteCount(), "the idle check alone should not ping")
+	assert.Equal(t, ErrIdleTimeout, conn.closeReason)
+	assert.True(t, conn.IsClosed())
+}
+
+func TestPingAnsweredWithPong(t *testing.T) {
+	netw := NewNetworkMock()
+	rwcam := NewReadWriteCloseAddresserMock()
+	rPub := generatePublicKey()
+	formatter := delimited.NewChan(10)
+	conn := newConnection(rwcam, netw, formatter, rPub, netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+
+	go conn.beginEventProcessing()
+
+	// a ping frame on the wire, length-prefixed by the delimited format
+	rwcam.SetReadResult(append([]byte{byte(len(pingFrame))}, pingFrame...), nil)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, append([]byte{byte(len(pongFrame))}, pongFrame...), rwcam.WriteOut(), "expected a pong answer")
+
+	// the keepalive frames never surface as protocol messages
+	select {
+	case <-netw.IncomingMessages()[0]:
+		t.Fatal("keepalive frame was published as a protocol message")
+	default:
+	}
+}
+
+func TestCloseNotificationLocal(t *testing.T) {
+	netw := NewNetworkMock()
+	rwcam := NewReadWriteCloseAddresserMock()
+	rPub := generatePublicKey()
+	formatter := delimited.NewChan(10)
+	conn := newConnection(rwcam, netw, formatter, rPub, netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+	go conn.beginEventProcessing()
+
+	conn.Close()
+	conn.Close() // closing twice must be a no-op
+	<-netw.ClosingConnections()
+
+	select {
+	case <-conn.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("close notification did not fire")
+	}
+	assert.Equal(t, ErrConnectionClosed, conn.CloseReason())
+}
+
+func TestCloseNotificationRemote(t *testing.T) {
+	netw := NewNetworkMock()
+	rwcam := NewReadWriteCloseAddresserMock()
+	rPub := generatePublicKey()
+	formatter := delimited.NewChan(10)
+	conn := newConnection(rwcam, netw, formatter, rPub, netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+	go conn.beginEventProcessing()
+
+	// the remote side goes away
+	rwcam.SetReadResult([]byte{}, fmt.Errorf("fail"))
+	<-netw.ClosingConnections()
+
+	select {
+	case <-conn.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("close notification did not fire")
+	}
+	assert.Equal(t, ErrClosedIncomingChannel, conn.CloseReason())
+}
+
+func TestCloseNotificationKeepalive(t *testing.T) {
+	netw := NewNetworkMock()
+	rwcam := NewReadWriteCloseAddresserMock()
+	rPub := generatePublicKey()
+	formatter := delimited.NewChan(10)
+	conn := newConnection(rwcam, netw, formatter, rPub, netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+	conn.setKeepAlive(0, 0, 60*time.Millisecond)
+	go conn.beginEventProcessing()
+
+	select {
+	case <-conn.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("close notification did not fire for the keepalive closure")
+	}
+	assert.Equal(t, ErrIdleTimeout, conn.CloseReason())
+	<-netw.ClosingConnections()
+}
+
+// stalledPeer makes a real TCP pair whose remote side never reads, with
+// buffers small enough that a few large writes fill them up.
+func stalledPeer(t *testing.T) (*net.TCPConn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+	raw, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	tcp := raw.(*net.TCPConn)
+	tcp.SetWriteBuffer(1 << 10)
+	server := <-accepted
+	server.(*net.TCPConn).SetReadBuffer(1 << 10)
+	return tcp, server
+}
+
+func TestSendWithDeadlineStalledPeer(t *testing.T) {
+	tcp, server := stalledPeer(t)
+	defer server.Close()
+
+	netw := NewNetworkMock()
+	formatter := delimited.NewChan(10)
+	conn := newConnection(tcp, netw, formatter, generatePublicKey(), netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+
+	// keep writing until the socket buffers fill up and the deadline hits
+	payload := make([]byte, 64<<10)
+	var sendErr error
+	var callStart time.Time
+	for i := 0; i < 1000; i++ {
+		callStart = time.Now()
+		sendErr = conn.SendWithDeadline(payload, 100*time.Millisecond)
+		if sendErr != nil {
+			break
+		}
+	}
+	assert.Equal(t, ErrSendTimeout, sendErr)
+	assert.True(t, time.Since(callStart) < time.Second, "the send did not respect its deadline")
+	conn.closeUnstarted(ErrConnectionClosed)
+}
+
+func TestTrySendBusy(t *testing.T) {
+	tcp, server := stalledPeer(t)
+	defer server.Close()
+
+	netw := NewNetworkMock()
+	formatter := delimited.NewChan(10)
+	conn := newConnection(tcp, netw, formatter, generatePublicKey(), netw.logger)
+	conn.SetSession(&NetworkSessionImpl{})
+
+	// a background sender gets stuck once the socket buffers are full
+	payload := make([]byte, 64<<10)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 1000; i++ {
+			if err := conn.Send(payload); err != nil {
+				break
+			}
+		}
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	err := conn.TrySend([]byte("hello"))
+	assert.Equal(t, ErrSendBusy, err)
+
+	conn.closeUnstarted(ErrConnectionClosed)
+	<-done
+}
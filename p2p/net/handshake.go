@@ -4,12 +4,10 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
-	"crypto/sha512"
 	"encoding/hex"
 	"io"
 	"time"
 
-	"bytes"
 	"errors"
 	"fmt"
 	"github.com/btcsuite/btcd/btcec"
@@ -31,8 +29,10 @@ const HandshakeResp = "/handshake/1.0/handshake-resp/"
 // Returns handshake data to send to removeNode and a network session data object that includes the session enc/dec sym key and iv
 // Node that NetworkSession is not yet authenticated - this happens only when the handshake response is processed and authenticated
 // This is called by node1 (initiator)
+// capabilities lists the framing features the local node supports, offered to
+// the remote node for negotiation
 func GenerateHandshakeRequestData(localPublicKey crypto.PublicKey, localPrivateKey crypto.PrivateKey, remotePublicKey crypto.PublicKey,
-	networkID int8, port uint16) (*pb.HandshakeData, NetworkSession, error) {
+	networkID int8, port uint16, capabilities []string) (*pb.HandshakeData, NetworkSession, error) {
 
 	// we use the Elliptic Curve Encryption Scheme
 	// https://en.wikipedia.org/wiki/Integrated_Encryption_Scheme
@@ -62,9 +62,13 @@ func GenerateHandshakeRequestData(localPublicKey crypto.PublicKey, localPrivateK
 
 	// start shared key generation
 	ecdhKey := btcec.GenerateSharedSecret(ephemeral, remotePublicKey.InternalKey())
-	derivedKey := sha512.Sum512(ecdhKey)
-	keyE := derivedKey[:32] // used for aes enc/dec
-	keyM := derivedKey[32:] // used for hmac
+	keys, err := crypto.DeriveKeys(ecdhKey, iv, LabelSessionEnc, LabelSessionMAC)
+	crypto.Zeroize(ecdhKey) // the shared secret served its purpose
+	if err != nil {
+		return nil, nil, err
+	}
+	keyE := keys[LabelSessionEnc] // used for aes enc/dec
+	keyM := keys[LabelSessionMAC] // used for hmac
 
 	data.PubKey = ephemeral.PubKey().SerializeUncompressed()
 
@@ -75,6 +79,7 @@ func GenerateHandshakeRequestData(localPublicKey crypto.PublicKey, localPrivateK
 	data.Sign = ""
 
 	data.Port = uint32(port)
+	data.Capabilities = capabilities
 
 	// sign corpus - marshall data without the signature to protobufs3 binary format
 	bin, err := proto.Marshal(data)
@@ -102,8 +107,10 @@ func GenerateHandshakeRequestData(localPublicKey crypto.PublicKey, localPrivateK
 
 // ProcessHandshakeRequest Process a session handshake request data from remoteNode r
 // Returns Handshake data to send to r and a network session data object that includes the session sym  enc/dec key
+// The response carries the intersection of the request's capabilities and
+// capabilities - features only one side knows are dropped silently
 // This is called by responder in the handshake protocol (node2)
-func ProcessHandshakeRequest(networkID int8, lPub crypto.PublicKey, lPri crypto.PrivateKey, rPub crypto.PublicKey, req *pb.HandshakeData) (*pb.HandshakeData, NetworkSession, error) {
+func ProcessHandshakeRequest(networkID int8, lPub crypto.PublicKey, lPri crypto.PrivateKey, rPub crypto.PublicKey, req *pb.HandshakeData, capabilities []string) (*pb.HandshakeData, NetworkSession, error) {
 	// check that received clientversion is valid client string
 	reqVersion := strings.Split(req.ClientVersion, "/")
 	if len(reqVersion) != 2 {
@@ -133,9 +140,13 @@ func ProcessHandshakeRequest(networkID int8, lPub crypto.PublicKey, lPri crypto.
 
 	// generate shared secret
 	ecdhKey := btcec.GenerateSharedSecret(lPri.InternalKey(), pubkey)
-	derivedKey := sha512.Sum512(ecdhKey)
-	keyE := derivedKey[:32] // this is the encryption key
-	keyM := derivedKey[32:]
+	keys, err := crypto.DeriveKeys(ecdhKey, req.Iv, LabelSessionEnc, LabelSessionMAC)
+	crypto.Zeroize(ecdhKey) // the shared secret served its purpose
+	if err != nil {
+		return nil, nil, err
+	}
+	keyE := keys[LabelSessionEnc] // this is the encryption key
+	keyM := keys[LabelSessionMAC]
 
 	// verify mac
 	hm := hmac.New(sha256.New, keyM)
@@ -193,6 +204,7 @@ func ProcessHandshakeRequest(networkID int8, lPub crypto.PublicKey, lPri crypto.
 		Hmac:          hmac1,
 		Protocol:      HandshakeResp,
 		Sign:          "",
+		Capabilities:  negotiateCapabilities(capabilities, req.GetCapabilities()),
 	}
 
 	// sign corpus - marshall data without the signature to protobufs3 binary format and sign it
@@ -216,13 +228,14 @@ func ProcessHandshakeRequest(networkID int8, lPub crypto.PublicKey, lPri crypto.
 // and to establish the session
 func ProcessHandshakeResponse(remotePub crypto.PublicKey, s NetworkSession, resp *pb.HandshakeData) error {
 
-	// verified shared public secret
-	if !bytes.Equal(resp.PubKey, s.PubKey()) {
+	// verified shared public secret - compared in constant time like every
+	// value tied to the session's secrets
+	if !crypto.ConstantTimeEqual(resp.PubKey, s.PubKey()) {
 		return errors.New("shared secret mismatch")
 	}
 
 	// verify response is for the expected session id
-	if !bytes.Equal(s.ID(), resp.SessionId) {
+	if !crypto.ConstantTimeEqual(s.ID(), resp.SessionId) {
 		return errors.New("expected same session id")
 	}
 
@@ -22,7 +22,7 @@ func TestGenerateHandshakeRequestData(t *testing.T) {
 	remoteNet, _ := NewNet(config.ConfigValues, remoteNode)
 
 	//outchan := remoteNet.SubscribeOnNewRemoteConnections()
-	_, _, er := GenerateHandshakeRequestData(localNode.PublicKey(), localNode.PrivateKey(), con.RemotePublicKey(), remoteNet.NetworkID(), getPort(t, remoteNode.Node))
+	_, _, er := GenerateHandshakeRequestData(localNode.PublicKey(), localNode.PrivateKey(), con.RemotePublicKey(), remoteNet.NetworkID(), getPort(t, remoteNode.Node), nil)
 	assert.NoError(t, er, "Sanity failed")
 
 }
@@ -35,34 +35,34 @@ func getPort(t *testing.T, remote node.Node) uint16 {
 	return uint16(portint)
 }
 
-func generateRequestData(t *testing.T) (*pb.HandshakeData, node.LocalNode, node.LocalNode, int8) {
+func generateRequestData(t *testing.T) (*pb.HandshakeData, *node.LocalNode, *node.LocalNode, int8) {
 
 	localNode, _ := node.GenerateTestNode(t)
 	remoteNode, _ := node.GenerateTestNode(t)
 	netId := int8(1)
-	out, _, err := GenerateHandshakeRequestData(localNode.PublicKey(), localNode.PrivateKey(), remoteNode.PublicKey(), netId, getPort(t, remoteNode.Node))
+	out, _, err := GenerateHandshakeRequestData(localNode.PublicKey(), localNode.PrivateKey(), remoteNode.PublicKey(), netId, getPort(t, remoteNode.Node), nil)
 	assert.NoError(t, err, "Failed to generate request")
-	return out, *localNode, *remoteNode, netId
+	return out, localNode, remoteNode, netId
 }
 
 func TestProcessHandshakeRequest(t *testing.T) {
 	//Sanity
 	data, localNode, remoteNet, netId := generateRequestData(t)
 	//processing request in remoteNet from local node
-	_, _, err := ProcessHandshakeRequest(netId, remoteNet.PublicKey(), remoteNet.PrivateKey(), localNode.PublicKey(), data)
+	_, _, err := ProcessHandshakeRequest(netId, remoteNet.PublicKey(), remoteNet.PrivateKey(), localNode.PublicKey(), data, nil)
 	assert.NoError(t, err, "Sanity processing request failed", err)
 
-	_, _, err = ProcessHandshakeRequest(netId, remoteNet.PublicKey(), remoteNet.PrivateKey(), localNode.PublicKey(), data)
+	_, _, err = ProcessHandshakeRequest(netId, remoteNet.PublicKey(), remoteNet.PrivateKey(), localNode.PublicKey(), data, nil)
 	assert.NoError(t, err, "Data modified during test")
 
 	data.NetworkID = data.NetworkID + 1
-	_, _, err = ProcessHandshakeRequest(netId, remoteNet.PublicKey(), remoteNet.PrivateKey(), localNode.PublicKey(), data)
+	_, _, err = ProcessHandshakeRequest(netId, remoteNet.PublicKey(), remoteNet.PrivateKey(), localNode.PublicKey(), data, nil)
 	assert.Error(t, err, "Didnt receive error on network id incomaptible with request")
 	data.NetworkID = int32(netId)
 
 	//remoteNode, _ := node.GenerateTestNode(t)
 
-	_, _, err = ProcessHandshakeRequest(netId, remoteNet.PublicKey(), remoteNet.PrivateKey(), remoteNet.PublicKey(), data)
+	_, _, err = ProcessHandshakeRequest(netId, remoteNet.PublicKey(), remoteNet.PrivateKey(), remoteNet.PublicKey(), data, nil)
 	assert.Error(t, err, "Didnt receive error on remote public key incomaptible with request")
 
 }
@@ -70,7 +70,7 @@ func TestProcessHandshakeRequest(t *testing.T) {
 func TestProcessHandshakeResponse(t *testing.T) {
 	//Sanity
 	data, localNode, remoteNet, netId := generateRequestData(t)
-	reqMsg, session, err := ProcessHandshakeRequest(netId, remoteNet.PublicKey(), remoteNet.PrivateKey(), localNode.PublicKey(), data)
+	reqMsg, session, err := ProcessHandshakeRequest(netId, remoteNet.PublicKey(), remoteNet.PrivateKey(), localNode.PublicKey(), data, nil)
 	assert.NoError(t, err, "Sanity creating request failed")
 
 	er := ProcessHandshakeResponse(remoteNet.PublicKey(), session, reqMsg)
@@ -0,0 +1,151 @@
+package net
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+)
+
+// Reasons an incoming connection can be turned away with, as reported by
+// RejectionCounts.
+const (
+	RejectReasonTotalLimit    = "total-connections"
+	RejectReasonIPLimit       = "per-ip-connections"
+	RejectReasonIPRate        = "per-ip-rate"
+	RejectReasonHandshakeLoad = "pending-handshakes"
+
+	// counted by the half-open sweep rather than the admission checks
+	RejectReasonHandshakeTimeout = "handshake-timeout"
+)
+
+// acceptLimiter guards the accept path against a host opening connections
+// faster than we can afford. It tracks live incoming connections per source
+// IP and in total, recent arrivals per IP, and connections still waiting for
+// their handshake - everything is checked before any expensive crypto runs.
+type acceptLimiter struct {
+	maxTotal      int
+	maxPerIP      int
+	ratePerIP     int
+	rateWindow    time.Duration
+	maxHandshakes int
+
+	// ipFor extracts the source identity of a connection - overridable so
+	// tests can simulate several sources from the loopback address
+	ipFor func(addr net.Addr) string
+
+	mutex    sync.Mutex
+	total    int
+	perIP    map[string]int
+	arrivals map[string][]time.Time
+	pending  map[string]struct{} // remote addresses awaiting a handshake
+	rejected map[string]int64
+}
+
+func newAcceptLimiter(conf config.Config) *acceptLimiter {
+	return &acceptLimiter{
+		maxTotal:      conf.MaxIncomingConns,
+		maxPerIP:      conf.MaxConnsPerIP,
+		ratePerIP:     conf.IncomingIPRateLimit,
+		rateWindow:    conf.IncomingIPRateWindow,
+		maxHandshakes: conf.MaxPendingHandshakes,
+		ipFor:         ipOfAddr,
+		perIP:         make(map[string]int),
+		arrivals:      make(map[string][]time.Time),
+		pending:       make(map[string]struct{}),
+		rejected:      make(map[string]int64),
+	}
+}
+
+// ipOfAddr strips the port off a remote address.
+func ipOfAddr(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// admit decides whether an incoming connection may proceed, reserving its
+// connection and pending-handshake slots when it may. Rejections are counted
+// by reason. release must be called when an admitted connection goes down
+// and sessionDone once its handshake completes.
+func (l *acceptLimiter) admit(addr net.Addr) (string, bool) {
+	ip := l.ipFor(addr)
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.maxTotal > 0 && l.total >= l.maxTotal {
+		l.rejected[RejectReasonTotalLimit]++
+		return RejectReasonTotalLimit, false
+	}
+	if l.maxPerIP > 0 && l.perIP[ip] >= l.maxPerIP {
+		l.rejected[RejectReasonIPLimit]++
+		return RejectReasonIPLimit, false
+	}
+	now := time.Now()
+	if l.ratePerIP > 0 {
+		recent := l.arrivals[ip][:0]
+		for _, at := range l.arrivals[ip] {
+			if now.Sub(at) < l.rateWindow {
+				recent = append(recent, at)
+			}
+		}
+		l.arrivals[ip] = recent
+		if len(recent) >= l.ratePerIP {
+			l.rejected[RejectReasonIPRate]++
+			return RejectReasonIPRate, false
+		}
+	}
+	if l.maxHandshakes > 0 && len(l.pending) >= l.maxHandshakes {
+		l.rejected[RejectReasonHandshakeLoad]++
+		return RejectReasonHandshakeLoad, false
+	}
+	l.total++
+	l.perIP[ip]++
+	if l.ratePerIP > 0 {
+		l.arrivals[ip] = append(l.arrivals[ip], now)
+	}
+	l.pending[addr.String()] = struct{}{}
+	return "", true
+}
+
+// sessionDone frees the connection's pending-handshake slot.
+func (l *acceptLimiter) sessionDone(addr net.Addr) {
+	l.mutex.Lock()
+	delete(l.pending, addr.String())
+	l.mutex.Unlock()
+}
+
+// release frees the slots an admitted connection held.
+func (l *acceptLimiter) release(addr net.Addr) {
+	ip := l.ipFor(addr)
+	l.mutex.Lock()
+	delete(l.pending, addr.String())
+	l.total--
+	if l.perIP[ip] <= 1 {
+		delete(l.perIP, ip)
+	} else {
+		l.perIP[ip]--
+	}
+	l.mutex.Unlock()
+}
+
+// countRejection records a rejection decided outside the admission checks,
+// e.g. a half-open connection reclaimed by the handshake sweep.
+func (l *acceptLimiter) countRejection(reason string) {
+	l.mutex.Lock()
+	l.rejected[reason]++
+	l.mutex.Unlock()
+}
+
+// rejections returns a snapshot of the rejected-connection counters by reason.
+func (l *acceptLimiter) rejections() map[string]int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	counts := make(map[string]int64, len(l.rejected))
+	for reason, count := range l.rejected {
+		counts[reason] = count
+	}
+	return counts
+}
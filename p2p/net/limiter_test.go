@@ -0,0 +1,149 @@
+package net
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAddr lets the limiter tests simulate several source IPs without
+// opening sockets - "a:1" and "a:2" are two connections from source "a".
+type fakeAddr string
+
+func (f fakeAddr) Network() string { return "tcp" }
+func (f fakeAddr) String() string  { return string(f) }
+
+func limiterConfig() config.Config {
+	cfg := config.DefaultConfig()
+	cfg.MaxIncomingConns = 0
+	cfg.MaxConnsPerIP = 0
+	cfg.IncomingIPRateLimit = 0
+	cfg.MaxPendingHandshakes = 0
+	return cfg
+}
+
+func TestLimiterPerIPCap(t *testing.T) {
+	cfg := limiterConfig()
+	cfg.MaxConnsPerIP = 3
+	l := newAcceptLimiter(cfg)
+
+	for i := 0; i < 3; i++ {
+		_, ok := l.admit(fakeAddr(fmt.Sprintf("a:%d", i)))
+		assert.True(t, ok)
+	}
+	reason, ok := l.admit(fakeAddr("a:99"))
+	assert.False(t, ok)
+	assert.Equal(t, RejectReasonIPLimit, reason)
+
+	// another source is unaffected
+	_, ok = l.admit(fakeAddr("b:1"))
+	assert.True(t, ok)
+
+	// a closed connection frees its slot
+	l.release(fakeAddr("a:0"))
+	_, ok = l.admit(fakeAddr("a:100"))
+	assert.True(t, ok)
+
+	assert.Equal(t, int64(1), l.rejections()[RejectReasonIPLimit])
+}
+
+func TestLimiterTotalCap(t *testing.T) {
+	cfg := limiterConfig()
+	cfg.MaxIncomingConns = 2
+	l := newAcceptLimiter(cfg)
+
+	_, ok := l.admit(fakeAddr("a:1"))
+	assert.True(t, ok)
+	_, ok = l.admit(fakeAddr("b:1"))
+	assert.True(t, ok)
+	reason, ok := l.admit(fakeAddr("c:1"))
+	assert.False(t, ok)
+	assert.Equal(t, RejectReasonTotalLimit, reason)
+	assert.Equal(t, int64(1), l.rejections()[RejectReasonTotalLimit])
+}
+
+func TestLimiterIPRate(t *testing.T) {
+	cfg := limiterConfig()
+	cfg.IncomingIPRateLimit = 3
+	cfg.IncomingIPRateWindow = 100 * time.Millisecond
+	l := newAcceptLimiter(cfg)
+
+	for i := 0; i < 3; i++ {
+		addr := fakeAddr(fmt.Sprintf("a:%d", i))
+		_, ok := l.admit(addr)
+		assert.True(t, ok)
+		l.release(addr) // closing doesn't reset the arrival rate
+	}
+	reason, ok := l.admit(fakeAddr("a:99"))
+	assert.False(t, ok)
+	assert.Equal(t, RejectReasonIPRate, reason)
+
+	// another source is unaffected
+	_, ok = l.admit(fakeAddr("b:1"))
+	assert.True(t, ok)
+
+	// once the window passes the source may connect again
+	time.Sleep(cfg.IncomingIPRateWindow + 20*time.Millisecond)
+	_, ok = l.admit(fakeAddr("a:100"))
+	assert.True(t, ok)
+}
+
+func TestLimiterHandshakeCap(t *testing.T) {
+	cfg := limiterConfig()
+	cfg.MaxPendingHandshakes = 2
+	l := newAcceptLimiter(cfg)
+
+	_, ok := l.admit(fakeAddr("a:1"))
+	assert.True(t, ok)
+	_, ok = l.admit(fakeAddr("b:1"))
+	assert.True(t, ok)
+	reason, ok := l.admit(fakeAddr("c:1"))
+	assert.False(t, ok)
+	assert.Equal(t, RejectReasonHandshakeLoad, reason)
+
+	// a completed handshake frees its slot while the connection stays up
+	l.sessionDone(fakeAddr("a:1"))
+	_, ok = l.admit(fakeAddr("c:2"))
+	assert.True(t, ok)
+}
+
+func TestAcceptRejectsOverPerIPCap(t *testing.T) {
+	cfg := limiterConfig()
+	cfg.MaxConnsPerIP = 2
+	ln, err := node.NewNodeIdentity(cfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+	n, err := NewNet(cfg, ln)
+	assert.NoError(t, err)
+	defer n.Shutdown()
+
+	address := n.tcpListener.Addr().String()
+	var conns []net.Conn
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for i := 0; i < 4; i++ {
+		c, err := net.Dial("tcp", address)
+		assert.NoError(t, err)
+		conns = append(conns, c)
+	}
+
+	// the connections over the cap are closed before any handshake happens
+	closed := 0
+	one := make([]byte, 1)
+	for _, c := range conns {
+		c.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		if _, err := c.Read(one); err == io.EOF {
+			closed++
+		}
+	}
+	assert.Equal(t, 2, closed)
+	assert.Equal(t, int64(2), n.RejectionCounts()[RejectReasonIPLimit])
+}
@@ -1,14 +1,17 @@
 package net
 
 import (
+	"context"
 	"fmt"
 	"github.com/gogo/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/journal"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/config"
 	"github.com/spacemeshos/go-spacemesh/p2p/delimited"
 	"github.com/spacemeshos/go-spacemesh/p2p/node"
 	"github.com/spacemeshos/go-spacemesh/p2p/pb"
+	"github.com/spacemeshos/go-spacemesh/version"
 	"gopkg.in/op/go-logging.v1"
 	"net"
 	"strconv"
@@ -34,6 +37,7 @@ type ManagedConnection interface {
 	Connection
 	incomingChannel() chan []byte
 	beginEventProcessing()
+	closeUnstarted(reason error)
 }
 
 // Net is a connection factory able to dial remote endpoints
@@ -51,6 +55,7 @@ type Net struct {
 
 	tcpListener      net.Listener
 	tcpListenAddress *net.TCPAddr // Address to open connection: localhost:9999\
+	extraListeners   []net.Listener
 
 	isShuttingDown bool
 
@@ -62,6 +67,14 @@ type Net struct {
 
 	closingConnections chan Connection
 
+	limiter *acceptLimiter
+
+	// connections still waiting for their session handshake, inbound and
+	// outbound, with the time they were created - swept periodically
+	pendingSessions     map[*FormattedConnection]time.Time
+	pendingSessionMutex sync.Mutex
+	stopSweep           chan struct{}
+
 	config config.Config
 }
 
@@ -92,6 +105,9 @@ func NewNet(conf config.Config, localEntity *node.LocalNode) (*Net, error) {
 		queuesCount:           qcount,
 		incomingMessagesQueue: make([]chan IncomingMessageEvent, qcount, qcount),
 		closingConnections:    make(chan Connection, 20),
+		limiter:               newAcceptLimiter(conf),
+		pendingSessions:       make(map[*FormattedConnection]time.Time),
+		stopSweep:             make(chan struct{}),
 		config:                conf,
 	}
 
@@ -115,6 +131,19 @@ func (n *Net) Logger() *logging.Logger {
 	return n.logger
 }
 
+// checkRequiredCapabilities surfaces a peer whose handshake doesn't
+// advertise a capability the local config marks as required. The connection
+// proceeds - the mismatch is an operator signal, not a protocol failure.
+func (n *Net) checkRequiredCapabilities(peer crypto.PublicKey, advertised []string) {
+	missing := version.MissingFeatures(advertised, n.config.RequiredCapabilities)
+	if len(missing) == 0 {
+		return
+	}
+	reason := "missing required capabilities: " + strings.Join(missing, ",")
+	n.logger.Warning("peer %v %v", peer.String(), reason)
+	n.localNode.Journal().Note(journal.EventFeatureMismatch, peer.String(), reason)
+}
+
 // NetworkID retuers Net's network ID
 func (n *Net) NetworkID() int8 {
 	return n.networkID
@@ -155,17 +184,22 @@ func (n *Net) ClosingConnections() chan Connection {
 	return n.closingConnections
 }
 
-func (n *Net) createConnection(address string, remotePub crypto.PublicKey, timeOut time.Duration, keepAlive time.Duration) (ManagedConnection, error) {
+// RejectionCounts returns how many incoming connections were turned away on
+// the accept path, keyed by reason.
+func (n *Net) RejectionCounts() map[string]int64 {
+	return n.limiter.rejections()
+}
+
+func (n *Net) createConnection(ctx context.Context, address string, remotePub crypto.PublicKey, keepAlive time.Duration) (ManagedConnection, error) {
 	if n.isShuttingDown {
 		return nil, fmt.Errorf("can't dial because the connection is shutting down")
 	}
 	// connect via dialer so we can set tcp network params
 	dialer := &net.Dialer{}
 	dialer.KeepAlive = keepAlive // drop connections after a period of inactivity
-	dialer.Timeout = timeOut     // max time bef
 	n.logger.Debug("TCP dialing %s ...", address)
 
-	netConn, err := dialer.Dial("tcp", address)
+	netConn, err := dialer.DialContext(ctx, "tcp", address)
 
 	if err != nil {
 		return nil, err
@@ -173,32 +207,35 @@ func (n *Net) createConnection(address string, remotePub crypto.PublicKey, timeO
 
 	n.logger.Debug("Connected to %s...", address)
 	formatter := delimited.NewChan(10)
+	formatter.SetMaxFrameSize(n.config.MaxFrameSize)
 	c := newConnection(netConn, n, formatter, remotePub, n.logger)
+	c.setKeepAlive(n.config.KeepAlivePingInterval, n.config.KeepAlivePongTimeout, n.config.IdleConnTimeout)
+	c.setQoS(n.config.ProtocolQoSWeights, n.config.ProtocolQueueDepth)
 
 	return c, nil
 }
 
-func (n *Net) createSecuredConnection(address string, remotePublicKey crypto.PublicKey, timeOut time.Duration, keepAlive time.Duration) (ManagedConnection, error) {
+func (n *Net) createSecuredConnection(ctx context.Context, address string, remotePublicKey crypto.PublicKey, keepAlive time.Duration) (ManagedConnection, error) {
 	errMsg := "failed to establish secured connection."
-	conn, err := n.createConnection(address, remotePublicKey, timeOut, keepAlive)
+	conn, err := n.createConnection(ctx, address, remotePublicKey, keepAlive)
 	if err != nil {
 		return nil, err
 	}
-	data, session, err := GenerateHandshakeRequestData(n.localNode.PublicKey(), n.localNode.PrivateKey(), remotePublicKey, n.networkID, uint16(n.tcpListenAddress.Port))
+	data, session, err := GenerateHandshakeRequestData(n.localNode.PublicKey(), n.localNode.PrivateKey(), remotePublicKey, n.networkID, uint16(n.tcpListenAddress.Port), supportedCapabilities(n.config))
 	if err != nil {
-		conn.Close()
+		conn.closeUnstarted(err)
 		return nil, fmt.Errorf("%s err: %v", errMsg, err)
 	}
 	n.logger.Debug("Creating session handshake request session id: %s", session)
 	payload, err := proto.Marshal(data)
 	if err != nil {
-		conn.Close()
+		conn.closeUnstarted(err)
 		return nil, fmt.Errorf("%s err: %v", errMsg, err)
 	}
 
 	err = conn.Send(payload)
 	if err != nil {
-		conn.Close()
+		conn.closeUnstarted(err)
 		return nil, err
 	}
 
@@ -208,39 +245,78 @@ func (n *Net) createSecuredConnection(address string, remotePublicKey crypto.Pub
 	select {
 	case msg, ok = <-conn.incomingChannel():
 		if !ok {
-			conn.Close()
+			conn.closeUnstarted(ErrClosedIncomingChannel)
 			return nil, fmt.Errorf("%s err: incoming channel got closed with %v", errMsg, conn.RemotePublicKey())
 		}
+	case <-ctx.Done():
+		n.logger.Info("dial to %v aborted mid-handshake: %v", remotePublicKey, ctx.Err())
+		conn.closeUnstarted(ctx.Err())
+		return nil, ctx.Err()
 	case <-timer.C:
 		n.logger.Info("waiting for HS response timed-out. remoteKey=%v", remotePublicKey)
-		conn.Close()
-		return nil, fmt.Errorf("%s err: HS response timed-out", errMsg)
+		conn.closeUnstarted(ErrHandshakeTimeout)
+		return nil, ErrHandshakeTimeout
 	}
 
 	respData := &pb.HandshakeData{}
 	err = proto.Unmarshal(msg, respData)
 	if err != nil {
 		//n.logger.Warning("invalid incoming handshake resp bin data", err)
-		conn.Close()
+		conn.closeUnstarted(err)
 		return nil, fmt.Errorf("%s err: %v", errMsg, err)
 	}
 
 	err = ProcessHandshakeResponse(remotePublicKey, session, respData)
 	if err != nil {
-		conn.Close()
+		conn.closeUnstarted(err)
 		return nil, fmt.Errorf("%s err: %v", errMsg, err)
 	}
+	if impl, ok := session.(*NetworkSessionImpl); ok {
+		impl.SetRekeyThresholds(n.config.SessionRekeyBytes, n.config.SessionRekeyTime)
+	}
 	conn.SetSession(session)
+
+	// the response carries the negotiated framing features - apply them to
+	// everything after the handshake. The initiator only sees the
+	// intersection, which is enough - a required capability is one we offer,
+	// so its absence here means the peer lacks it.
+	n.checkRequiredCapabilities(remotePublicKey, respData.GetCapabilities())
+	caps := negotiateCapabilities(supportedCapabilities(n.config), respData.GetCapabilities())
+	if t := transformForCapabilities(caps, n.config.MaxFrameSize); t != nil {
+		if fc, ok := conn.(*FormattedConnection); ok {
+			fc.setFrameTransform(t)
+		}
+	}
+	if hasCapability(caps, CapabilityAEAD) {
+		if err := session.SetAEAD(true); err != nil {
+			conn.closeUnstarted(err)
+			return nil, fmt.Errorf("%s err: failed to enable session aead: %v", errMsg, err)
+		}
+	}
 	return conn, nil
 }
 
-// Dial a remote server with provided time out
+// Dial a remote server with the configured dial timeout
 // address:: ip:port
 // Returns established connection that local clients can send messages to or error if failed
 // to establish a connection, currently only secured connections are supported
 func (n *Net) Dial(address string, remotePublicKey crypto.PublicKey) (Connection, error) {
-	conn, err := n.createSecuredConnection(address, remotePublicKey, n.config.DialTimeout, n.config.ConnKeepAlive)
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.DialTimeout)
+	defer cancel()
+	return n.DialWithContext(ctx, address, remotePublicKey)
+}
+
+// DialWithContext dials a remote server like Dial under the given context's
+// deadline, covering both the TCP dial and the handshake. Cancelling the
+// context mid-handshake closes the socket.
+func (n *Net) DialWithContext(ctx context.Context, address string, remotePublicKey crypto.PublicKey) (Connection, error) {
+	conn, err := n.createSecuredConnection(ctx, address, remotePublicKey, n.config.ConnKeepAlive)
 	if err != nil {
+		if err == ErrHandshakeTimeout {
+			// keep the typed error so GetConnection callers can tell a
+			// stalled handshake from a failed dial
+			return nil, err
+		}
 		return nil, fmt.Errorf("failed to Dail. err: %v", err)
 	}
 	go conn.beginEventProcessing()
@@ -250,7 +326,89 @@ func (n *Net) Dial(address string, remotePublicKey crypto.PublicKey) (Connection
 // Shutdown initiate a graceful closing of the TCP listener and all other internal routines
 func (n *Net) Shutdown() {
 	n.isShuttingDown = true
+	close(n.stopSweep)
 	n.tcpListener.Close()
+	for _, l := range n.extraListeners {
+		l.Close()
+	}
+}
+
+// trackPendingSession registers an accepted connection until its handshake
+// completes, so the sweep can reclaim it if it never does. Outbound
+// connections don't need it - their handshake is bounded by the dial
+// deadline and surfaces ErrHandshakeTimeout to the caller.
+func (n *Net) trackPendingSession(c *FormattedConnection) {
+	n.pendingSessionMutex.Lock()
+	n.pendingSessions[c] = time.Now()
+	n.pendingSessionMutex.Unlock()
+}
+
+// sessionEstablished drops the connection from the half-open sweep's watch.
+func (n *Net) sessionEstablished(c Connection) {
+	fc, ok := c.(*FormattedConnection)
+	if !ok {
+		return
+	}
+	n.pendingSessionMutex.Lock()
+	delete(n.pendingSessions, fc)
+	n.pendingSessionMutex.Unlock()
+}
+
+// sweepHalfOpen periodically reclaims connections that completed the TCP
+// connect but never established a session within the handshake deadline.
+func (n *Net) sweepHalfOpen() {
+	interval := n.config.HandshakeTimeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			var expired []*FormattedConnection
+			n.pendingSessionMutex.Lock()
+			for c, since := range n.pendingSessions {
+				if c.IsClosed() || c.Session() != nil {
+					delete(n.pendingSessions, c)
+					continue
+				}
+				if now.Sub(since) >= n.config.HandshakeTimeout {
+					delete(n.pendingSessions, c)
+					expired = append(expired, c)
+				}
+			}
+			n.pendingSessionMutex.Unlock()
+			for _, c := range expired {
+				n.logger.Warning("reclaiming half-open connection from %v: no session within %v", c.RemoteAddr(), n.config.HandshakeTimeout)
+				n.limiter.countRejection(RejectReasonHandshakeTimeout)
+				c.closeWith(ErrHandshakeTimeout)
+			}
+		case <-n.stopSweep:
+			return
+		}
+	}
+}
+
+// ListenAddresses returns every address the network is bound on, with
+// ephemeral ports resolved to their actual value. The node's own address
+// comes first, so callers can advertise the appropriate one per peer.
+func (n *Net) ListenAddresses() []net.Addr {
+	addrs := []net.Addr{n.tcpListener.Addr()}
+	for _, l := range n.extraListeners {
+		addrs = append(addrs, l.Addr())
+	}
+	return addrs
+}
+
+// PortBindError is returned when the configured TCP port can't be bound.
+type PortBindError struct {
+	Port int
+	Err  error
+}
+
+func (e *PortBindError) Error() string {
+	return fmt.Sprintf("failed to bind TCP port %d: %v", e.Port, e.Err)
 }
 
 // Start network server
@@ -258,17 +416,63 @@ func (n *Net) listen() error {
 	n.logger.Info("Starting to listen on %v", n.tcpListenAddress)
 	tcpListener, err := net.Listen("tcp", n.tcpListenAddress.String())
 	if err != nil {
-		return err
+		if !n.config.AutoSelectPort {
+			return &PortBindError{Port: n.tcpListenAddress.Port, Err: err}
+		}
+
+		// the configured port is taken - fall back to an ephemeral port
+		n.logger.Warningf("TCP port %d is unavailable (%v) - binding an ephemeral port instead", n.tcpListenAddress.Port, err)
+		fallback := &net.TCPAddr{IP: n.tcpListenAddress.IP, Port: 0}
+		tcpListener, err = net.Listen("tcp", fallback.String())
+		if err != nil {
+			return &PortBindError{Port: 0, Err: err}
+		}
 	}
 	n.tcpListener = tcpListener
-	go n.acceptTCP()
+
+	// record the actually bound port on the local node so advertised addresses
+	// carry a dialable value - the configured port may have been 0 or taken
+	bound := tcpListener.Addr().(*net.TCPAddr)
+	if bound.Port != n.tcpListenAddress.Port {
+		n.tcpListenAddress = &net.TCPAddr{IP: n.tcpListenAddress.IP, Port: bound.Port}
+		n.localNode.UpdateAddress(n.tcpListenAddress.String())
+		n.logger.Info("Listening on %v", n.tcpListenAddress)
+	}
+
+	// extra listeners, e.g. an IPv6 or management-network address, feed the
+	// same demux as the main one
+	for _, address := range n.config.ListenAddresses {
+		extra, err := net.Listen("tcp", address)
+		if err != nil {
+			if !n.config.AllowPartialBind {
+				n.tcpListener.Close()
+				for _, l := range n.extraListeners {
+					l.Close()
+				}
+				return fmt.Errorf("failed to listen on %v: %v", address, err)
+			}
+			n.logger.Warningf("failed to listen on %v (%v) - continuing without it", address, err)
+			continue
+		}
+		n.logger.Info("Listening on %v", extra.Addr())
+		n.extraListeners = append(n.extraListeners, extra)
+	}
+
+	if n.config.HandshakeTimeout > 0 {
+		go n.sweepHalfOpen()
+	}
+
+	go n.acceptTCP(n.tcpListener)
+	for _, l := range n.extraListeners {
+		go n.acceptTCP(l)
+	}
 	return nil
 }
 
-func (n *Net) acceptTCP() {
+func (n *Net) acceptTCP(listener net.Listener) {
 	for {
 		n.logger.Debug("Waiting for incoming connections...")
-		netConn, err := n.tcpListener.Accept()
+		netConn, err := listener.Accept()
 		if err != nil {
 
 			if !n.isShuttingDown {
@@ -278,11 +482,28 @@ func (n *Net) acceptTCP() {
 			return
 		}
 
+		// cheap admission checks come before any allocation or crypto
+		if reason, ok := n.limiter.admit(netConn.RemoteAddr()); !ok {
+			n.logger.Warning("rejecting incoming connection from %v: %v", netConn.RemoteAddr(), reason)
+			netConn.Close()
+			continue
+		}
+
 		n.logger.Debug("Got new connection... Remote Address: %s", netConn.RemoteAddr())
 		formatter := delimited.NewChan(10)
+		formatter.SetMaxFrameSize(n.config.MaxFrameSize)
 		c := newConnection(netConn, n, formatter, nil, n.logger)
+		c.setKeepAlive(n.config.KeepAlivePingInterval, n.config.KeepAlivePongTimeout, n.config.IdleConnTimeout)
+		c.setQoS(n.config.ProtocolQoSWeights, n.config.ProtocolQueueDepth)
+		if n.config.HandshakeTimeout > 0 {
+			n.trackPendingSession(c)
+		}
 
 		go c.beginEventProcessing()
+		go func(addr net.Addr, conn *FormattedConnection) {
+			<-conn.Closed()
+			n.limiter.release(addr)
+		}(netConn.RemoteAddr(), c)
 		// network won't publish the connection before it the remote node had established a session
 	}
 }
@@ -324,7 +545,7 @@ func (n *Net) HandlePreSessionIncomingMessage(c Connection, message []byte) erro
 		}
 		c.SetRemotePublicKey(rPub)
 	}
-	respData, session, err := ProcessHandshakeRequest(n.NetworkID(), n.localNode.PublicKey(), n.localNode.PrivateKey(), c.RemotePublicKey(), data)
+	respData, session, err := ProcessHandshakeRequest(n.NetworkID(), n.localNode.PublicKey(), n.localNode.PrivateKey(), c.RemotePublicKey(), data, supportedCapabilities(n.config))
 	if err != nil {
 		return fmt.Errorf("%s. err: %v", errMsg, err)
 	}
@@ -338,11 +559,32 @@ func (n *Net) HandlePreSessionIncomingMessage(c Connection, message []byte) erro
 		return err
 	}
 
+	if impl, ok := session.(*NetworkSessionImpl); ok {
+		impl.SetRekeyThresholds(n.config.SessionRekeyBytes, n.config.SessionRekeyTime)
+	}
 	c.SetSession(session)
+	n.limiter.sessionDone(c.RemoteAddr())
+	n.sessionEstablished(c)
+
+	// the response frame itself went out plain - everything after it uses
+	// the negotiated framing features
+	if t := transformForCapabilities(respData.GetCapabilities(), n.config.MaxFrameSize); t != nil {
+		if fc, ok := c.(*FormattedConnection); ok {
+			fc.setFrameTransform(t)
+		}
+	}
+	if hasCapability(respData.GetCapabilities(), CapabilityAEAD) {
+		if err := session.SetAEAD(true); err != nil {
+			return fmt.Errorf("%s. err: failed to enable session aead: %v", errMsg, err)
+		}
+	}
+
+	n.checkRequiredCapabilities(c.RemotePublicKey(), data.GetCapabilities())
 
-	// update on new connection
+	// update on new connection, carrying the client version the peer
+	// reported so it shows up in the routing table and peer listings
 	addr := strings.Split(c.RemoteAddr().String(), ":")[0] // this should never be bad unless address is corrupted
-	anode := node.New(c.RemotePublicKey(), net.JoinHostPort(addr, strconv.Itoa(int(data.Port))))
+	anode := node.New(c.RemotePublicKey(), net.JoinHostPort(addr, strconv.Itoa(int(data.Port)))).WithVersion(data.ClientVersion)
 	n.publishNewRemoteConnectionEvent(c, anode)
 
 	return nil
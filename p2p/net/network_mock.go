@@ -1,6 +1,7 @@
 package net
 
 import (
+	"context"
 	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"gopkg.in/op/go-logging.v1"
@@ -89,15 +90,24 @@ func (n *NetworkMock) SetDialDelayMs(delay int8) {
 
 // Dial dials
 func (n *NetworkMock) Dial(address string, remotePublicKey crypto.PublicKey) (Connection, error) {
+	return n.DialWithContext(context.Background(), address, remotePublicKey)
+}
+
+// DialWithContext dials with a context
+func (n *NetworkMock) DialWithContext(ctx context.Context, address string, remotePublicKey crypto.PublicKey) (Connection, error) {
 	atomic.AddInt32(&n.dialCount, 1)
-	time.Sleep(time.Duration(n.dialDelayMs) * time.Millisecond)
+	select {
+	case <-time.After(time.Duration(n.dialDelayMs) * time.Millisecond):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
 	sID := n.dialSessionID
 	if sID == nil {
 		sID = make([]byte, 4)
 		rand.Read(sID)
 	}
 	conn := NewConnectionMock(remotePublicKey)
-	conn.SetSession(SessionMock{id: sID})
+	conn.SetSession(&SessionMock{id: sID})
 	return conn, n.dialErr
 }
 
@@ -106,6 +116,11 @@ func (n *NetworkMock) DialCount() int32 {
 	return atomic.LoadInt32(&n.dialCount)
 }
 
+// RejectionCounts mocks the accept-path rejection counters
+func (n *NetworkMock) RejectionCounts() map[string]int64 {
+	return make(map[string]int64)
+}
+
 // SubscribeOnNewRemoteConnections subscribes on new connections
 func (n *NetworkMock) SubscribeOnNewRemoteConnections() chan NewConnectionEvent {
 	ch := make(chan NewConnectionEvent, 20)
@@ -1,13 +1,16 @@
 package net
 
 import (
+	"context"
 	"fmt"
 	"github.com/gogo/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/config"
 	"github.com/spacemeshos/go-spacemesh/p2p/node"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"math/rand"
+	"net"
 	"sync"
 	"testing"
 	"time"
@@ -47,6 +50,214 @@ func Test_sumByteArray(t *testing.T) {
 	assert.Equal(t, bytez2, uint(100))
 }
 
+func TestNet_AutoSelectPort(t *testing.T) {
+	// occupy a port so the configured one is unavailable
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "failed to bind a port")
+	defer taken.Close()
+	takenPort := taken.Addr().(*net.TCPAddr).Port
+	address := fmt.Sprintf("127.0.0.1:%d", takenPort)
+
+	// without auto-select a taken port is a structured failure naming the port
+	cfg := config.DefaultConfig()
+	ln, err := node.NewNodeIdentity(cfg, address, false)
+	assert.NoError(t, err)
+
+	_, err = NewNet(cfg, ln)
+	assert.Error(t, err, "expected a bind failure on a taken port")
+	bindErr, ok := err.(*PortBindError)
+	assert.True(t, ok, "expected a PortBindError")
+	assert.Equal(t, takenPort, bindErr.Port, "expected the configured port in the error")
+
+	// with auto-select the node comes up on another port and advertises it
+	cfg.AutoSelectPort = true
+	ln2, err := node.NewNodeIdentity(cfg, address, false)
+	assert.NoError(t, err)
+
+	n, err := NewNet(cfg, ln2)
+	assert.NoError(t, err, "expected a fallback to an ephemeral port")
+	defer n.Shutdown()
+
+	boundPort := n.tcpListenAddress.Port
+	assert.NotEqual(t, takenPort, boundPort, "expected a different port")
+	assert.NotEqual(t, 0, boundPort, "expected a real bound port")
+	assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", boundPort), ln2.Address(), "expected the bound port to be advertised")
+	assert.Contains(t, node.StringFromNode(ln2.Node), fmt.Sprintf(":%d/", boundPort), "expected the bound port in the node string")
+}
+
+func TestNet_EphemeralPort(t *testing.T) {
+	// port 0 binds an ephemeral port and records it on the node
+	cfg := config.DefaultConfig()
+	ln, err := node.NewNodeIdentity(cfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+
+	n, err := NewNet(cfg, ln)
+	assert.NoError(t, err, "failed to bind an ephemeral port")
+	defer n.Shutdown()
+
+	assert.NotEqual(t, 0, n.tcpListenAddress.Port, "expected a real bound port")
+	assert.Equal(t, fmt.Sprintf("127.0.0.1:%d", n.tcpListenAddress.Port), ln.Address(), "expected the bound port to be advertised")
+}
+
+func TestNet_MultipleListeners(t *testing.T) {
+	// a node bound on both loopback families accepts sessions via each
+	cfg := config.DefaultConfig()
+	cfg.ListenAddresses = []string{"[::1]:0"}
+	ln, err := node.NewNodeIdentity(cfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+	n, err := NewNet(cfg, ln)
+	assert.NoError(t, err, "failed to bind both listeners")
+	defer n.Shutdown()
+
+	addrs := n.ListenAddresses()
+	assert.Equal(t, 2, len(addrs), "expected both bound addresses to be reported")
+	for _, address := range addrs {
+		assert.NotEqual(t, 0, address.(*net.TCPAddr).Port, "expected a real bound port")
+	}
+
+	dialerCfg := config.DefaultConfig()
+	for _, address := range addrs {
+		dln, err := node.NewNodeIdentity(dialerCfg, "127.0.0.1:0", false)
+		assert.NoError(t, err)
+		d, err := NewNet(dialerCfg, dln)
+		assert.NoError(t, err)
+		conn, err := d.Dial(address.String(), ln.PublicKey())
+		assert.NoError(t, err, "failed to dial %v", address)
+		assert.NotNil(t, conn.Session(), "expected a session via %v", address)
+		conn.Close()
+		d.Shutdown()
+	}
+}
+
+func TestNet_PartialBind(t *testing.T) {
+	// occupy a port so one of the extra addresses can't be bound
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "failed to bind a port")
+	defer taken.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.ListenAddresses = []string{taken.Addr().String()}
+	ln, err := node.NewNodeIdentity(cfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+
+	// by default a failed extra bind is fatal
+	_, err = NewNet(cfg, ln)
+	assert.Error(t, err, "expected a bind failure on a taken extra address")
+
+	// in partial-bind mode the node comes up without the failed listener
+	cfg.AllowPartialBind = true
+	ln2, err := node.NewNodeIdentity(cfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+	n, err := NewNet(cfg, ln2)
+	assert.NoError(t, err, "expected the node to come up without the extra listener")
+	defer n.Shutdown()
+	assert.Equal(t, 1, len(n.ListenAddresses()), "expected only the main listener")
+}
+
+// startBlackhole listens and accepts connections but never answers the
+// handshake - the worst kind of peer for a dialer without a deadline.
+func startBlackhole(t *testing.T) net.Listener {
+	blackhole, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "failed to bind the blackhole listener")
+	go func() {
+		for {
+			conn, err := blackhole.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	return blackhole
+}
+
+func TestNet_HandshakeTimeoutReclaim(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.HandshakeTimeout = 100 * time.Millisecond
+	ln, err := node.NewNodeIdentity(cfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+	n, err := NewNet(cfg, ln)
+	assert.NoError(t, err)
+	defer n.Shutdown()
+
+	// a client that completes the TCP connect and then never handshakes
+	stalled, err := net.Dial("tcp", n.tcpListener.Addr().String())
+	assert.NoError(t, err)
+	defer stalled.Close()
+
+	start := time.Now()
+	stalled.SetReadDeadline(time.Now().Add(2 * time.Second))
+	one := make([]byte, 1)
+	_, err = stalled.Read(one)
+	assert.Equal(t, io.EOF, err, "expected the server to reclaim the stalled connection")
+	assert.True(t, time.Since(start) < time.Second, "reclaim took %v", time.Since(start))
+	assert.Equal(t, int64(1), n.RejectionCounts()[RejectReasonHandshakeTimeout])
+}
+
+func TestDialHandshakeTimeoutTypedError(t *testing.T) {
+	blackhole := startBlackhole(t)
+	defer blackhole.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.ResponseTimeout = 100 * time.Millisecond
+	ln, err := node.NewNodeIdentity(cfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+	n, err := NewNet(cfg, ln)
+	assert.NoError(t, err)
+	defer n.Shutdown()
+
+	conn, err := n.Dial(blackhole.Addr().String(), generatePublicKey())
+	assert.Nil(t, conn)
+	assert.Equal(t, ErrHandshakeTimeout, err, "expected the typed handshake timeout")
+}
+
+func TestDialWithContextDeadline(t *testing.T) {
+	blackhole := startBlackhole(t)
+	defer blackhole.Close()
+
+	cfg := config.DefaultConfig()
+	ln, err := node.NewNodeIdentity(cfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+	n, err := NewNet(cfg, ln)
+	assert.NoError(t, err)
+	defer n.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	conn, err := n.DialWithContext(ctx, blackhole.Addr().String(), generatePublicKey())
+	assert.Error(t, err, "expected the deadline to abort the handshake")
+	assert.Nil(t, conn)
+	assert.True(t, time.Since(start) < time.Second, "dial did not respect the deadline, took %v", time.Since(start))
+}
+
+func TestDialWithContextCancelMidHandshake(t *testing.T) {
+	blackhole := startBlackhole(t)
+	defer blackhole.Close()
+
+	cfg := config.DefaultConfig()
+	ln, err := node.NewNodeIdentity(cfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+	n, err := NewNet(cfg, ln)
+	assert.NoError(t, err)
+	defer n.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := n.DialWithContext(ctx, blackhole.Addr().String(), generatePublicKey())
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond) // let the dial reach the handshake wait
+	cancel()
+	select {
+	case err := <-done:
+		assert.Error(t, err, "expected the cancelled dial to report an error")
+	case <-time.After(time.Second):
+		t.Fatal("cancellation did not abort the dial promptly")
+	}
+}
+
 func TestNet_EnqueueMessage(t *testing.T) {
 	testnodes := 100
 	cfg := config.DefaultConfig()
@@ -80,7 +291,7 @@ func TestHandlePreSessionIncomingMessage(t *testing.T) {
 	con := NewConnectionMock(localNode.PublicKey())
 	remoteNet, _ := NewNet(config.ConfigValues, remoteNode)
 	outchan := remoteNet.SubscribeOnNewRemoteConnections()
-	out, session, er := GenerateHandshakeRequestData(localNode.PublicKey(), localNode.PrivateKey(), remoteNode.PublicKey(), remoteNet.NetworkID(), getPort(t, remoteNode.Node))
+	out, session, er := GenerateHandshakeRequestData(localNode.PublicKey(), localNode.PrivateKey(), remoteNode.PublicKey(), remoteNet.NetworkID(), getPort(t, remoteNode.Node), nil)
 	assert.NoError(t, er, "cant generate handshake message")
 	data, err := proto.Marshal(out)
 	assert.NoError(t, err, "cannot marshal obj")
@@ -0,0 +1,123 @@
+package net
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrProtocolQueueFull is returned by a tagged send when the protocol's
+// outgoing queue hit its depth bound - typed backpressure so the protocol
+// can shed load instead of blocking the link.
+var ErrProtocolQueueFull = errors.New("protocol send queue is full")
+
+// qosScheduler shares one connection's socket between protocols with
+// weighted fairness, so a bulk transfer can't starve control traffic. It
+// sits below the protocol layer and above the socket: tagged frames are
+// queued per protocol and drained in weighted round-robin order.
+//
+// While a single protocol uses the connection frames go straight to the
+// socket - the scheduler engages only when a second protocol shows up, so
+// the common case pays one mutex and nothing else.
+type qosScheduler struct {
+	conn    *FormattedConnection
+	weights map[string]int
+	depth   int
+
+	mutex  sync.Mutex
+	queues map[string]chan []byte
+	order  []string // round-robin order, protocols by first use
+	active bool     // drain loop engaged
+
+	wake chan struct{}
+}
+
+func newQoSScheduler(conn *FormattedConnection, weights map[string]int, depth int) *qosScheduler {
+	return &qosScheduler{
+		conn:    conn,
+		weights: weights,
+		depth:   depth,
+		queues:  make(map[string]chan []byte),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+func (s *qosScheduler) weightOf(protocol string) int {
+	if w, ok := s.weights[protocol]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+// send queues a tagged frame, or passes it straight through while only one
+// protocol uses the connection. Queued sends return as soon as the frame is
+// accepted; a full queue fails fast with ErrProtocolQueueFull.
+func (s *qosScheduler) send(protocol string, m []byte) error {
+	s.mutex.Lock()
+	q, ok := s.queues[protocol]
+	if !ok {
+		q = make(chan []byte, s.depth)
+		s.queues[protocol] = q
+		s.order = append(s.order, protocol)
+		if len(s.queues) == 2 && !s.active {
+			s.active = true
+			go s.drain()
+		}
+	}
+	single := len(s.queues) == 1
+	s.mutex.Unlock()
+
+	if single {
+		return s.conn.Send(m)
+	}
+
+	select {
+	case q <- m:
+	default:
+		return ErrProtocolQueueFull
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// drain moves queued frames to the socket, giving each protocol up to its
+// weight in frames per round. A send failure closes the connection - the
+// socket is not coming back.
+func (s *qosScheduler) drain() {
+	for {
+		s.mutex.Lock()
+		order := append([]string(nil), s.order...)
+		s.mutex.Unlock()
+
+		sent := 0
+		for _, protocol := range order {
+			s.mutex.Lock()
+			q := s.queues[protocol]
+			s.mutex.Unlock()
+		turn:
+			for i := 0; i < s.weightOf(protocol); i++ {
+				select {
+				case m := <-q:
+					if err := s.conn.Send(m); err != nil {
+						s.conn.logger.Warning("(%v) qos send failed, closing. err=%v", s.conn.remotePub, err)
+						s.conn.closeWith(err)
+						return
+					}
+					sent++
+				default:
+					break turn // queue empty - next protocol
+				}
+			}
+		}
+
+		if sent == 0 {
+			select {
+			case <-s.wake:
+			case <-s.conn.Closed():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package net
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/delimited"
+	"github.com/stretchr/testify/assert"
+)
+
+// gatedRecorder is a tapping transport - it records every written frame and
+// can hold writes back so send queues build up behind the socket.
+type gatedRecorder struct {
+	gate    chan struct{} // closed to let writes through
+	waiting int32
+
+	mutex sync.Mutex
+	data  []byte
+
+	readBlock chan struct{}
+	closeOnce sync.Once
+}
+
+func newGatedRecorder() *gatedRecorder {
+	return &gatedRecorder{gate: make(chan struct{}), readBlock: make(chan struct{})}
+}
+
+func (g *gatedRecorder) Write(p []byte) (int, error) {
+	atomic.AddInt32(&g.waiting, 1)
+	<-g.gate
+	atomic.AddInt32(&g.waiting, -1)
+	g.mutex.Lock()
+	g.data = append(g.data, p...)
+	g.mutex.Unlock()
+	return len(p), nil
+}
+
+func (g *gatedRecorder) Read(p []byte) (int, error) {
+	<-g.readBlock
+	return 0, io.EOF
+}
+
+func (g *gatedRecorder) Close() error {
+	g.closeOnce.Do(func() { close(g.readBlock) })
+	return nil
+}
+
+func (g *gatedRecorder) RemoteAddr() net.Addr { return &net.TCPAddr{} }
+
+func (g *gatedRecorder) release() { close(g.gate) }
+
+func (g *gatedRecorder) waitBlocked(t *testing.T) {
+	assert.True(t, waitFor(time.Second, func() bool { return atomic.LoadInt32(&g.waiting) > 0 }),
+		"no write reached the gated socket")
+}
+
+// frames parses the recorded wire bytes back into individual frames.
+func (g *gatedRecorder) frames(t *testing.T) [][]byte {
+	g.mutex.Lock()
+	recorded := append([]byte(nil), g.data...)
+	g.mutex.Unlock()
+	var out [][]byte
+	reader := delimited.NewReader(bytes.NewReader(recorded))
+	for {
+		frame, err := reader.Next()
+		if err != nil {
+			return out
+		}
+		out = append(out, append([]byte(nil), frame...))
+	}
+}
+
+func qosConn(weights map[string]int, depth int) (*FormattedConnection, *gatedRecorder) {
+	rec := newGatedRecorder()
+	conn := newConnection(rec, NewNetworkMock(), delimited.NewChan(10), generatePublicKey(), getTestLogger("qos"))
+	conn.setQoS(weights, depth)
+	return conn, rec
+}
+
+func queuedFrames(s *qosScheduler, protocol string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.queues[protocol])
+}
+
+func schedulerActive(s *qosScheduler) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.active
+}
+
+func TestQoSSingleProtocolDirect(t *testing.T) {
+	conn, rec := qosConn(map[string]int{"hare": 5, "sync": 1}, 64)
+	defer conn.closeUnstarted(ErrConnectionClosed)
+	rec.release()
+
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, conn.SendTagged("sync", []byte{byte(i)}))
+	}
+	// a single protocol goes straight to the socket - no drain loop engaged
+	assert.False(t, schedulerActive(conn.qos), "scheduler engaged with a single protocol")
+	assert.Equal(t, 3, len(rec.frames(t)))
+}
+
+func TestQoSWeightedDrain(t *testing.T) {
+	conn, rec := qosConn(map[string]int{"hare": 5, "sync": 1}, 64)
+	defer conn.closeUnstarted(ErrConnectionClosed)
+
+	// occupy the socket so everything else queues behind it
+	firstDone := make(chan error, 1)
+	go func() { firstDone <- conn.SendTagged("sync", []byte("first")) }()
+	rec.waitBlocked(t)
+
+	// the second protocol engages the scheduler
+	assert.NoError(t, conn.SendTagged("hare", []byte("high-0")))
+	// wait for the drain loop to pick it up and block behind the socket
+	assert.True(t, waitFor(time.Second, func() bool { return queuedFrames(conn.qos, "hare") == 0 }),
+		"drain loop never picked up the first frame")
+
+	// a large burst of low-weight frames, then the remaining high ones
+	for i := 0; i < 40; i++ {
+		assert.NoError(t, conn.SendTagged("sync", []byte(fmt.Sprintf("low-%d", i))))
+	}
+	for i := 1; i < 5; i++ {
+		assert.NoError(t, conn.SendTagged("hare", []byte(fmt.Sprintf("high-%d", i))))
+	}
+
+	rec.release()
+	assert.NoError(t, <-firstDone)
+	assert.True(t, waitFor(2*time.Second, func() bool { return len(rec.frames(t)) == 46 }),
+		"expected all frames on the wire, got %d", len(rec.frames(t)))
+
+	// the weights put every high frame ahead of the low burst
+	frames := rec.frames(t)
+	assert.Equal(t, []byte("first"), frames[0])
+	for i := 1; i <= 5; i++ {
+		assert.True(t, bytes.HasPrefix(frames[i], []byte("high-")), "frame %d is %s, expected a high one", i, frames[i])
+	}
+	for i := 6; i < 46; i++ {
+		assert.Equal(t, []byte(fmt.Sprintf("low-%d", i-6)), frames[i], "low frames arrived out of order")
+	}
+}
+
+func TestQoSQueueFull(t *testing.T) {
+	conn, rec := qosConn(map[string]int{"hare": 5, "sync": 1}, 4)
+	defer conn.closeUnstarted(ErrConnectionClosed)
+
+	firstDone := make(chan error, 1)
+	go func() { firstDone <- conn.SendTagged("sync", []byte("first")) }()
+	rec.waitBlocked(t)
+	assert.NoError(t, conn.SendTagged("hare", []byte("high")))
+	assert.True(t, waitFor(time.Second, func() bool { return queuedFrames(conn.qos, "hare") == 0 }),
+		"drain loop never picked up the first frame")
+
+	// the bounded queue pushes back with a typed error once full
+	full := 0
+	for i := 0; i < 8; i++ {
+		if err := conn.SendTagged("sync", []byte{byte(i)}); err != nil {
+			assert.Equal(t, ErrProtocolQueueFull, err)
+			full++
+		}
+	}
+	assert.Equal(t, 4, full, "expected the sends over the queue depth to fail fast")
+
+	rec.release()
+	assert.NoError(t, <-firstDone)
+}
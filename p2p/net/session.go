@@ -3,6 +3,7 @@ package net
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"github.com/spacemeshos/go-spacemesh/crypto"
@@ -24,11 +25,59 @@ type NetworkSession interface {
 	Decrypt(in []byte) ([]byte, error) // decrypt data using session dec key
 	Encrypt(in []byte) ([]byte, error) // encrypt data using session enc key
 
+	// authenticated encryption, used instead of Encrypt/Decrypt on sessions
+	// that negotiated the aead capability in their handshake. The nonce goes
+	// on the wire and must increase monotonically per direction, the protocol
+	// name and sender id are bound as additional authenticated data.
+	SealMessage(protocol string, in []byte) (nonce uint64, out []byte, err error)
+	OpenMessage(protocol string, nonce uint64, in []byte) ([]byte, error)
+	AEADEnabled() bool
+	SetAEAD(on bool) error
+
 	EncryptGuard() *sync.Mutex // used for creating a per session transaction of data encryption and data delivery
+
+	Close() // wipes the session's key material - the session is unusable afterwards
 }
 
 // TODO: add support for idle session expiration
 
+// Session rekeying defaults. A session derives a fresh enc key from the
+// current one plus fresh randomness after this much sent traffic or time,
+// whichever comes first. Zero thresholds disable the respective trigger.
+const (
+	DefaultRekeyBytes = int64(1) << 30 // 1GB
+	DefaultRekeyTime  = 30 * time.Minute
+	RekeyOverlap      = 30 * time.Second // old key keeps decrypting this long
+	rekeySaltLen      = aes.BlockSize    // salt doubles as the new epoch's iv
+)
+
+// ErrUnknownKeyEpoch is returned when a message carries a key epoch the
+// session has no key for - neither current, next nor recently retired.
+var ErrUnknownKeyEpoch = errors.New("message for an unknown session key epoch")
+
+// ErrAEADNotEnabled is returned when an aead operation is attempted on a
+// session that didn't negotiate the capability.
+var ErrAEADNotEnabled = errors.New("session has no negotiated aead")
+
+// ErrNonceReplay is returned when an aead message reuses or regresses the
+// per-direction nonce - a replayed or reordered frame.
+var ErrNonceReplay = errors.New("aead nonce replayed or regressed")
+
+// Labels for the keys derived from the handshake's ecdh shared secret and
+// the session key ratchet - every purpose gets its own label so no two of
+// them can ever share key material. Changing a label is a breaking protocol
+// change.
+const (
+	// LabelSessionEnc derives the symmetric encryption key.
+	LabelSessionEnc = "session-enc"
+	// LabelSessionMAC derives the handshake mac key.
+	LabelSessionMAC = "session-mac"
+	// labelSessionRekey chains an epoch's key to the next one.
+	labelSessionRekey = "session-rekey"
+	// aeadKeyContext separates the aead key from the cbc key.
+	aeadKeyContext = "session-aead"
+)
+
 // NetworkSessionImpl implements NetworkSession.
 type NetworkSessionImpl struct {
 	id      []byte
@@ -43,6 +92,34 @@ type NetworkSessionImpl struct {
 	blockEncrypter cipher.BlockMode
 	encGuard       sync.Mutex
 	blockDecrypter cipher.BlockMode
+
+	// rekeying. Each direction ratchets its key independently - every frame
+	// carries its key epoch so the receiver selects the matching key, and the
+	// first frame of a new epoch carries the salt the key was derived with.
+	rekeyBytes int64
+	rekeyTime  time.Duration
+	overlap    time.Duration
+
+	sendMutex   sync.Mutex
+	sendEpoch   byte
+	sendKey     []byte
+	sendSince   time.Time
+	sentBytes   int64
+	pendingSalt []byte
+
+	recvMutex     sync.Mutex
+	recvEpoch     byte
+	recvKey       []byte
+	prevDecrypter cipher.BlockMode
+	prevEpoch     byte
+	prevUntil     time.Time
+
+	// aead, non-nil once the capability was negotiated. Each direction
+	// counts its own nonces, the receive side rejects reuse and regression.
+	aeadMutex     sync.Mutex
+	aead          cipher.AEAD
+	aeadSendNonce uint64
+	aeadRecvNonce uint64
 }
 
 //LocalNodeID returns the session's local node id.
@@ -85,27 +162,153 @@ func (n *NetworkSessionImpl) Created() time.Time {
 	return n.created
 }
 
+// SetRekeyThresholds sets how much sent traffic or elapsed time triggers a
+// key refresh on the sending side. Zero disables the respective trigger.
+func (n *NetworkSessionImpl) SetRekeyThresholds(bytes int64, interval time.Duration) {
+	n.sendMutex.Lock()
+	defer n.sendMutex.Unlock()
+	n.rekeyBytes = bytes
+	n.rekeyTime = interval
+}
+
+// SendKeyEpoch returns the key epoch outgoing messages are encrypted with.
+func (n *NetworkSessionImpl) SendKeyEpoch() byte {
+	n.sendMutex.Lock()
+	defer n.sendMutex.Unlock()
+	return n.sendEpoch
+}
+
+// RecvKeyEpoch returns the newest key epoch seen on incoming messages.
+func (n *NetworkSessionImpl) RecvKeyEpoch() byte {
+	n.recvMutex.Lock()
+	defer n.recvMutex.Unlock()
+	return n.recvEpoch
+}
+
+// deriveSessionKey ratchets a session key - the next key is derived from the
+// current one and the fresh salt carried on the first frame of the epoch.
+func deriveSessionKey(key, salt []byte) ([]byte, error) {
+	keys, err := crypto.DeriveKeys(key, salt, labelSessionRekey)
+	if err != nil {
+		return nil, err
+	}
+	return keys[labelSessionRekey], nil
+}
+
+// maybeRekeySend advances the send epoch when a threshold passed. Called with
+// sendMutex held. The salt is kept aside so the next frame can carry it.
+func (n *NetworkSessionImpl) maybeRekeySend() {
+	if n.sendEpoch == 255 {
+		return // epoch byte exhausted - keep using the last key
+	}
+	due := (n.rekeyBytes > 0 && n.sentBytes >= n.rekeyBytes) ||
+		(n.rekeyTime > 0 && time.Since(n.sendSince) >= n.rekeyTime)
+	if !due {
+		return
+	}
+	salt, err := crypto.GetRandomBytes(rekeySaltLen)
+	if err != nil {
+		log.Error("Failed to draw rekey salt, keeping current session key: %v", err)
+		return
+	}
+	key, err := deriveSessionKey(n.sendKey, salt)
+	if err != nil {
+		log.Error("Failed to derive rekeyed session key: %v", err)
+		return
+	}
+	blockCipher, err := aes.NewCipher(key)
+	if err != nil {
+		log.Error("Failed to create block cipher for rekey: %v", err)
+		return
+	}
+	crypto.Zeroize(n.sendKey) // the retired key must not linger in memory
+	n.sendEpoch++
+	n.sendKey = key
+	n.sentBytes = 0
+	n.sendSince = time.Now()
+	n.pendingSalt = salt
+	n.blockEncrypter = cipher.NewCBCEncrypter(blockCipher, salt)
+}
+
 // Encrypt encrypts in binary data with the session's sym enc key.
+// The output carries the key epoch and, on the first message of a fresh
+// epoch, the salt the new key was derived with.
 func (n *NetworkSessionImpl) Encrypt(in []byte) ([]byte, error) {
 	l := len(in)
 	if l == 0 {
 		return nil, errors.New("Invalid input buffer - 0 len")
 	}
+	n.sendMutex.Lock()
+	defer n.sendMutex.Unlock()
+	n.maybeRekeySend()
 	paddedIn := crypto.AddPKCSPadding(in)
-	out := make([]byte, len(paddedIn))
-	n.blockEncrypter.CryptBlocks(out, paddedIn)
+	out := make([]byte, 0, 1+len(n.pendingSalt)+len(paddedIn))
+	out = append(out, n.sendEpoch)
+	out = append(out, n.pendingSalt...)
+	n.pendingSalt = nil
+	cipherText := make([]byte, len(paddedIn))
+	n.blockEncrypter.CryptBlocks(cipherText, paddedIn)
+	out = append(out, cipherText...)
+	n.sentBytes += int64(len(paddedIn))
 	return out, nil
 }
 
 // Decrypt decrypts in binary data that was encrypted with the session's sym enc key.
+// The key is selected by the message's epoch byte - the current key, the next
+// one (derived from the carried salt), or the previous one for a short
+// overlap window so in-flight messages across a rekey still decrypt.
 func (n *NetworkSessionImpl) Decrypt(in []byte) ([]byte, error) {
-	l := len(in)
-	if l == 0 {
+	if len(in) < 2 {
 		return nil, errors.New("Invalid input buffer - 0 len")
 	}
+	epoch, body := in[0], in[1:]
 
-	out := make([]byte, l)
-	n.blockDecrypter.CryptBlocks(out, in)
+	n.recvMutex.Lock()
+	defer n.recvMutex.Unlock()
+
+	var decrypter cipher.BlockMode
+	switch {
+	case epoch == n.recvEpoch:
+		decrypter = n.blockDecrypter
+	case epoch == n.recvEpoch+1:
+		if len(body) < rekeySaltLen {
+			return nil, errors.New("rekeyed message is missing its salt")
+		}
+		salt := body[:rekeySaltLen]
+		body = body[rekeySaltLen:]
+		key, err := deriveSessionKey(n.recvKey, salt)
+		if err != nil {
+			return nil, err
+		}
+		blockCipher, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		overlap := n.overlap
+		if overlap == 0 {
+			overlap = RekeyOverlap
+		}
+		n.prevDecrypter = n.blockDecrypter
+		n.prevEpoch = n.recvEpoch
+		n.prevUntil = time.Now().Add(overlap)
+		// the overlap decrypter keeps its own key schedule - the retired key
+		// buffer itself can be wiped right away
+		crypto.Zeroize(n.recvKey)
+		n.recvEpoch = epoch
+		n.recvKey = key
+		n.blockDecrypter = cipher.NewCBCDecrypter(blockCipher, salt)
+		decrypter = n.blockDecrypter
+	case n.prevDecrypter != nil && epoch == n.prevEpoch && time.Now().Before(n.prevUntil):
+		decrypter = n.prevDecrypter
+	default:
+		return nil, ErrUnknownKeyEpoch
+	}
+
+	if len(body) == 0 || len(body)%aes.BlockSize != 0 {
+		return nil, errors.New("Invalid ciphertext - not block aligned")
+	}
+	out := make([]byte, len(body))
+	decrypter.CryptBlocks(out, body)
 	clearText, err := crypto.RemovePKCSPadding(out)
 	if err != nil {
 		return nil, err
@@ -113,11 +316,127 @@ func (n *NetworkSessionImpl) Decrypt(in []byte) ([]byte, error) {
 	return clearText, nil
 }
 
+// SetAEAD derives the session's aead key and enables SealMessage and
+// OpenMessage, or drops the state again when on is false. Called once the
+// handshake agreed on the aead capability.
+func (n *NetworkSessionImpl) SetAEAD(on bool) error {
+	n.aeadMutex.Lock()
+	defer n.aeadMutex.Unlock()
+	if !on {
+		n.aead = nil
+		return nil
+	}
+	keys, err := crypto.DeriveKeys(n.keyE, n.id, aeadKeyContext)
+	if err != nil {
+		return err
+	}
+	blockCipher, err := aes.NewCipher(keys[aeadKeyContext])
+	crypto.Zeroize(keys[aeadKeyContext]) // the cipher holds its own key schedule
+	if err != nil {
+		return err
+	}
+	n.aead, err = cipher.NewGCM(blockCipher)
+	return err
+}
+
+// AEADEnabled reports whether the session seals its messages with the aead.
+func (n *NetworkSessionImpl) AEADEnabled() bool {
+	n.aeadMutex.Lock()
+	defer n.aeadMutex.Unlock()
+	return n.aead != nil
+}
+
+// aeadDirection gives each direction of a session its own nonce space - both
+// ends order the node ids the same way, so sender and receiver agree on it.
+func aeadDirection(senderID, receiverID string) byte {
+	if senderID < receiverID {
+		return 1
+	}
+	return 2
+}
+
+// aeadNonceBytes lays the direction and the counter out as a gcm nonce.
+func aeadNonceBytes(direction byte, counter uint64) []byte {
+	nonce := make([]byte, 12)
+	nonce[0] = direction
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+// aeadAAD binds the protocol name and the sender's id into the seal - a
+// frame moved to another protocol or claimed by another sender won't open.
+func aeadAAD(protocol, senderID string) []byte {
+	aad := make([]byte, 0, len(protocol)+1+len(senderID))
+	aad = append(aad, protocol...)
+	aad = append(aad, 0)
+	aad = append(aad, senderID...)
+	return aad
+}
+
+// SealMessage encrypts and authenticates in with the session's aead key,
+// binding protocol and the local node id. The returned nonce goes on the
+// wire with the ciphertext.
+func (n *NetworkSessionImpl) SealMessage(protocol string, in []byte) (uint64, []byte, error) {
+	n.aeadMutex.Lock()
+	defer n.aeadMutex.Unlock()
+	if n.aead == nil {
+		return 0, nil, ErrAEADNotEnabled
+	}
+	n.aeadSendNonce++
+	nonce := n.aeadSendNonce
+	direction := aeadDirection(n.localNodeID, n.remoteNodeID)
+	out := n.aead.Seal(nil, aeadNonceBytes(direction, nonce), in, aeadAAD(protocol, n.localNodeID))
+	return nonce, out, nil
+}
+
+// OpenMessage verifies and decrypts an aead-sealed message. Any tampering -
+// with the ciphertext, the nonce, the protocol or the claimed sender - fails
+// before a single payload byte is parsed, and a nonce at or below the last
+// accepted one is rejected as a replay.
+func (n *NetworkSessionImpl) OpenMessage(protocol string, nonce uint64, in []byte) ([]byte, error) {
+	n.aeadMutex.Lock()
+	defer n.aeadMutex.Unlock()
+	if n.aead == nil {
+		return nil, ErrAEADNotEnabled
+	}
+	if nonce <= n.aeadRecvNonce {
+		return nil, ErrNonceReplay
+	}
+	direction := aeadDirection(n.remoteNodeID, n.localNodeID)
+	clearText, err := n.aead.Open(nil, aeadNonceBytes(direction, nonce), in, aeadAAD(protocol, n.remoteNodeID))
+	if err != nil {
+		return nil, err
+	}
+	n.aeadRecvNonce = nonce
+	return clearText, nil
+}
+
 // EncryptGuard returns a mutex that is used by clients of session to tie encryption and sending together.
 func (n *NetworkSessionImpl) EncryptGuard() *sync.Mutex {
 	return &n.encGuard
 }
 
+// Close wipes the session's key material so it doesn't linger in memory
+// after the connection carrying the session went down. Expanded key schedules
+// inside live cipher states are beyond reach until collected, but every
+// long-lived key buffer is zeroed. The session is unusable afterwards.
+func (n *NetworkSessionImpl) Close() {
+	n.sendMutex.Lock()
+	crypto.Zeroize(n.sendKey)
+	n.sendMutex.Unlock()
+
+	n.recvMutex.Lock()
+	crypto.Zeroize(n.recvKey)
+	n.recvMutex.Unlock()
+
+	n.aeadMutex.Lock()
+	n.aead = nil
+	n.aeadMutex.Unlock()
+
+	crypto.Zeroize(n.keyE)
+	crypto.Zeroize(n.keyM)
+}
+
 // NewNetworkSession creates a new network session based on provided data
 func NewNetworkSession(id, keyE, keyM, pubKey []byte, localNodeID, remoteNodeID string) (*NetworkSessionImpl, error) {
 	n := &NetworkSessionImpl{
@@ -128,6 +447,13 @@ func NewNetworkSession(id, keyE, keyM, pubKey []byte, localNodeID, remoteNodeID
 		created:      time.Now(),
 		localNodeID:  localNodeID,
 		remoteNodeID: remoteNodeID,
+		rekeyBytes:   DefaultRekeyBytes,
+		rekeyTime:    DefaultRekeyTime,
+		// each direction ratchets and zeroizes its key independently, so
+		// neither may alias keyE or the other's buffer
+		sendKey:   append([]byte(nil), keyE...),
+		recvKey:   append([]byte(nil), keyE...),
+		sendSince: time.Now(),
 	}
 
 	// create and store block enc/dec
@@ -12,6 +12,8 @@ type SessionMock struct {
 
 	pubkey []byte
 	keyM   []byte
+
+	aeadOn bool
 }
 
 func NewSessionMock(ID []byte) *SessionMock {
@@ -65,8 +67,33 @@ func (sm *SessionMock) SetDecrypt(res []byte, err error) {
 	sm.decError = err
 }
 
+// SealMessage is this
+func (sm SessionMock) SealMessage(protocol string, in []byte) (uint64, []byte, error) {
+	return 1, sm.encResult, sm.encError
+}
+
+// OpenMessage is this
+func (sm SessionMock) OpenMessage(protocol string, nonce uint64, in []byte) ([]byte, error) {
+	return sm.decResult, sm.decError
+}
+
+// AEADEnabled is this
+func (sm SessionMock) AEADEnabled() bool {
+	return sm.aeadOn
+}
+
+// SetAEAD is this
+func (sm *SessionMock) SetAEAD(on bool) error {
+	sm.aeadOn = on
+	return nil
+}
+
 func (n SessionMock) EncryptGuard() *sync.Mutex {
 	return nil
 }
 
+// Close is this
+func (sm SessionMock) Close() {
+}
+
 var _ NetworkSession = (*SessionMock)(nil)
@@ -0,0 +1,267 @@
+package net
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/stretchr/testify/assert"
+)
+
+// loopbackSessions runs the handshake request leg between two local nodes and
+// returns the resulting matched session pair - what each side of a live
+// connection would hold.
+func loopbackSessions(t *testing.T) (*NetworkSessionImpl, *NetworkSessionImpl) {
+	localNode, _ := node.GenerateTestNode(t)
+	remoteNode, _ := node.GenerateTestNode(t)
+	out, localSession, err := GenerateHandshakeRequestData(localNode.PublicKey(), localNode.PrivateKey(), remoteNode.PublicKey(), 1, getPort(t, remoteNode.Node), nil)
+	assert.NoError(t, err, "Failed to generate handshake request")
+	_, remoteSession, err := ProcessHandshakeRequest(1, remoteNode.PublicKey(), remoteNode.PrivateKey(), localNode.PublicKey(), out, nil)
+	assert.NoError(t, err, "Failed to process handshake request")
+	return localSession.(*NetworkSessionImpl), remoteSession.(*NetworkSessionImpl)
+}
+
+func TestSessionRekeyByBytes(t *testing.T) {
+	sender, receiver := loopbackSessions(t)
+	sender.SetRekeyThresholds(1024, 0)
+
+	for i := 0; i < 40; i++ {
+		plain := []byte(fmt.Sprintf("message number %02d riding the session", i))
+		enc, err := sender.Encrypt(plain)
+		assert.NoError(t, err)
+		if i == 0 {
+			assert.Equal(t, byte(0), enc[0], "the first message should carry epoch 0")
+		}
+		dec, err := receiver.Decrypt(enc)
+		assert.NoError(t, err, "message %d failed to decrypt", i)
+		assert.Equal(t, plain, dec, "message %d corrupted", i)
+	}
+
+	assert.True(t, sender.SendKeyEpoch() >= 1, "expected the byte threshold to advance the epoch")
+	assert.Equal(t, sender.SendKeyEpoch(), receiver.RecvKeyEpoch(), "receiver should have followed the ratchet")
+}
+
+func TestSessionRekeyByTime(t *testing.T) {
+	sender, receiver := loopbackSessions(t)
+	sender.SetRekeyThresholds(0, 20*time.Millisecond)
+
+	plain := []byte("before the interval elapsed")
+	enc, err := sender.Encrypt(plain)
+	assert.NoError(t, err)
+	dec, err := receiver.Decrypt(enc)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, dec)
+	assert.Equal(t, byte(0), sender.SendKeyEpoch())
+
+	time.Sleep(30 * time.Millisecond)
+
+	plain = []byte("after the interval elapsed")
+	enc, err = sender.Encrypt(plain)
+	assert.NoError(t, err)
+	dec, err = receiver.Decrypt(enc)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, dec)
+	assert.Equal(t, byte(1), sender.SendKeyEpoch())
+	assert.Equal(t, byte(1), receiver.RecvKeyEpoch())
+}
+
+func TestSessionRekeyOverlap(t *testing.T) {
+	sender, receiver := loopbackSessions(t)
+	// 64-byte messages pad to 80, so the third Encrypt crosses 100 and rekeys
+	sender.SetRekeyThresholds(100, 0)
+
+	first := make([]byte, 64)
+	second := make([]byte, 64)
+	third := make([]byte, 64)
+	for i := range first {
+		first[i], second[i], third[i] = byte(i), byte(i+1), byte(i+2)
+	}
+
+	encFirst, err := sender.Encrypt(first)
+	assert.NoError(t, err)
+	encSecond, err := sender.Encrypt(second)
+	assert.NoError(t, err)
+	encThird, err := sender.Encrypt(third)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(1), sender.SendKeyEpoch(), "expected the third message on a fresh epoch")
+	assert.Equal(t, byte(0), encSecond[0])
+	assert.Equal(t, byte(1), encThird[0])
+
+	// the second message arrives after the first of the new epoch - the
+	// overlap window keeps the old key around for it
+	dec, err := receiver.Decrypt(encFirst)
+	assert.NoError(t, err)
+	assert.Equal(t, first, dec)
+	dec, err = receiver.Decrypt(encThird)
+	assert.NoError(t, err)
+	assert.Equal(t, third, dec)
+	dec, err = receiver.Decrypt(encSecond)
+	assert.NoError(t, err, "in-flight old-epoch message should decrypt within the overlap")
+	assert.Equal(t, second, dec)
+}
+
+func TestSessionRekeyOverlapExpiry(t *testing.T) {
+	sender, receiver := loopbackSessions(t)
+	sender.SetRekeyThresholds(100, 0)
+	receiver.overlap = time.Millisecond
+
+	payload := make([]byte, 64)
+	encFirst, err := sender.Encrypt(payload)
+	assert.NoError(t, err)
+	encSecond, err := sender.Encrypt(payload)
+	assert.NoError(t, err)
+	encThird, err := sender.Encrypt(payload)
+	assert.NoError(t, err)
+
+	_, err = receiver.Decrypt(encFirst)
+	assert.NoError(t, err)
+	_, err = receiver.Decrypt(encThird)
+	assert.NoError(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+	_, err = receiver.Decrypt(encSecond)
+	assert.Equal(t, ErrUnknownKeyEpoch, err, "the retired key should be gone after the overlap window")
+
+	// an epoch the session never had is rejected outright
+	_, err = receiver.Decrypt(append([]byte{7}, encThird[1:]...))
+	assert.Equal(t, ErrUnknownKeyEpoch, err)
+}
+
+// aeadSessions returns a loopback session pair with the aead negotiated, as
+// after a handshake where both sides offered the capability.
+func aeadSessions(t *testing.T) (*NetworkSessionImpl, *NetworkSessionImpl) {
+	sender, receiver := loopbackSessions(t)
+	assert.NoError(t, sender.SetAEAD(true))
+	assert.NoError(t, receiver.SetAEAD(true))
+	return sender, receiver
+}
+
+func TestSessionAEADRoundTrip(t *testing.T) {
+	sender, receiver := aeadSessions(t)
+
+	plain := []byte("an authenticated message")
+	nonce, sealed, err := sender.SealMessage("EX", plain)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), nonce, "nonces start at 1, 0 marks legacy frames")
+	assert.NotEqual(t, plain, sealed)
+
+	dec, err := receiver.OpenMessage("EX", nonce, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, dec)
+
+	nonce, sealed, err = sender.SealMessage("EX", plain)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), nonce)
+	_, err = receiver.OpenMessage("EX", nonce, sealed)
+	assert.NoError(t, err)
+}
+
+func TestSessionAEADTamperDetection(t *testing.T) {
+	sender, receiver := aeadSessions(t)
+
+	plain := []byte("an authenticated message")
+	nonce, sealed, err := sender.SealMessage("EX", plain)
+	assert.NoError(t, err)
+
+	// a flipped ciphertext bit fails authentication
+	flipped := append([]byte(nil), sealed...)
+	flipped[3] ^= 0x01
+	_, err = receiver.OpenMessage("EX", nonce, flipped)
+	assert.Error(t, err)
+
+	// so does moving the frame to another protocol - the name is in the aad
+	_, err = receiver.OpenMessage("EY", nonce, sealed)
+	assert.Error(t, err)
+
+	// failures must not burn the nonce, the untampered frame still opens
+	dec, err := receiver.OpenMessage("EX", nonce, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, dec)
+}
+
+func TestSessionAEADNonceReplay(t *testing.T) {
+	sender, receiver := aeadSessions(t)
+
+	nonce1, sealed1, err := sender.SealMessage("EX", []byte("first"))
+	assert.NoError(t, err)
+	nonce2, sealed2, err := sender.SealMessage("EX", []byte("second"))
+	assert.NoError(t, err)
+
+	_, err = receiver.OpenMessage("EX", nonce1, sealed1)
+	assert.NoError(t, err)
+	_, err = receiver.OpenMessage("EX", nonce1, sealed1)
+	assert.Equal(t, ErrNonceReplay, err, "a replayed nonce must be rejected")
+
+	_, err = receiver.OpenMessage("EX", nonce2, sealed2)
+	assert.NoError(t, err)
+	_, err = receiver.OpenMessage("EX", nonce1, sealed1)
+	assert.Equal(t, ErrNonceReplay, err, "a regressed nonce must be rejected")
+}
+
+func TestSessionAEADCrossSessionIsolation(t *testing.T) {
+	sender, _ := aeadSessions(t)
+	_, otherReceiver := aeadSessions(t)
+
+	nonce, sealed, err := sender.SealMessage("EX", []byte("bound to one session"))
+	assert.NoError(t, err)
+	_, err = otherReceiver.OpenMessage("EX", nonce, sealed)
+	assert.Error(t, err, "a frame sealed on one session must not open on another")
+}
+
+func TestSessionAEADRequiresNegotiation(t *testing.T) {
+	sender, _ := loopbackSessions(t)
+	assert.False(t, sender.AEADEnabled())
+	_, _, err := sender.SealMessage("EX", []byte("payload"))
+	assert.Equal(t, ErrAEADNotEnabled, err)
+	_, err = sender.OpenMessage("EX", 1, []byte("payload"))
+	assert.Equal(t, ErrAEADNotEnabled, err)
+}
+
+func TestSessionCloseZeroizesKeys(t *testing.T) {
+	sender, receiver := aeadSessions(t)
+
+	// exercise the session so every key buffer is live
+	enc, err := sender.Encrypt([]byte("one last message"))
+	assert.NoError(t, err)
+	_, err = receiver.Decrypt(enc)
+	assert.NoError(t, err)
+
+	keyE := sender.KeyE()
+	keyM := sender.KeyM()
+	sendKey := sender.sendKey
+	recvKey := sender.recvKey
+	assert.NotEqual(t, make([]byte, len(keyE)), keyE, "sanity - keys start out non-zero")
+
+	sender.Close()
+
+	assert.Equal(t, make([]byte, len(keyE)), keyE, "enc key must be zeroed on close")
+	assert.Equal(t, make([]byte, len(keyM)), keyM, "mac key must be zeroed on close")
+	assert.Equal(t, make([]byte, len(sendKey)), sendKey, "send ratchet key must be zeroed on close")
+	assert.Equal(t, make([]byte, len(recvKey)), recvKey, "recv ratchet key must be zeroed on close")
+
+	// the aead state is dropped with the keys
+	_, _, err = sender.SealMessage("EX", []byte("after close"))
+	assert.Equal(t, ErrAEADNotEnabled, err)
+}
+
+func TestSessionRekeyZeroizesRetiredKey(t *testing.T) {
+	sender, receiver := loopbackSessions(t)
+	sender.SetRekeyThresholds(1, 0) // every message crosses the threshold
+
+	retiredSend := sender.sendKey
+	retiredRecv := receiver.recvKey
+
+	enc, err := sender.Encrypt([]byte("message crossing a rekey"))
+	assert.NoError(t, err)
+	_, err = receiver.Decrypt(enc)
+	assert.NoError(t, err)
+
+	enc, err = sender.Encrypt([]byte("first message of the new epoch"))
+	assert.NoError(t, err)
+	_, err = receiver.Decrypt(enc)
+	assert.NoError(t, err)
+
+	assert.Equal(t, make([]byte, len(retiredSend)), retiredSend, "retired send key must be zeroed")
+	assert.Equal(t, make([]byte, len(retiredRecv)), retiredRecv, "retired recv key must be zeroed")
+}
@@ -0,0 +1,121 @@
+package net
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/spacemeshos/go-spacemesh/version"
+)
+
+// CapabilityFlateCompression identifies whole-connection DEFLATE frame
+// compression in the handshake capabilities exchange.
+const CapabilityFlateCompression = "flate/1"
+
+// CapabilityAEAD identifies authenticated session encryption (aes-gcm with
+// explicit nonces) for protocol messages. Peers without it keep the legacy
+// cbc framing.
+const CapabilityAEAD = "aead/aes-gcm-1"
+
+// supportedCapabilities lists the features this node offers in its
+// handshakes - the framing features derived from the config, plus whatever
+// optional features other components registered as active.
+func supportedCapabilities(conf config.Config) []string {
+	// aead is always offered - it only engages when both sides know it
+	caps := []string{CapabilityAEAD}
+	if conf.FrameCompression {
+		caps = append(caps, CapabilityFlateCompression)
+	}
+	for _, f := range version.Features() {
+		if !hasCapability(caps, f) {
+			caps = append(caps, f)
+		}
+	}
+	return caps
+}
+
+// hasCapability reports whether a negotiated capability set contains c.
+func hasCapability(caps []string, c string) bool {
+	for _, have := range caps {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateCapabilities picks the features both sides support. Capabilities
+// we don't recognize - e.g. from a newer peer - simply never intersect with
+// ours and are ignored.
+func negotiateCapabilities(local, remote []string) []string {
+	var agreed []string
+	for _, l := range local {
+		for _, r := range remote {
+			if l == r {
+				agreed = append(agreed, l)
+				break
+			}
+		}
+	}
+	return agreed
+}
+
+// frameTransform transforms whole frames beneath the message layer - encode
+// runs on every outgoing frame, decode on every incoming one.
+type frameTransform interface {
+	encode(frame []byte) ([]byte, error)
+	decode(frame []byte) ([]byte, error)
+}
+
+// transformForCapabilities maps a negotiated capability set to the transform
+// implementing it, or nil when the frames stay plain. maxSize bounds the
+// decoded size so a malicious peer can't blow frames up past the frame size
+// limit (non-positive means unbounded).
+func transformForCapabilities(caps []string, maxSize int) frameTransform {
+	for _, c := range caps {
+		if c == CapabilityFlateCompression {
+			return &flateTransform{maxSize: maxSize}
+		}
+	}
+	return nil
+}
+
+// flateTransform compresses every frame with DEFLATE.
+type flateTransform struct {
+	maxSize int
+}
+
+func (t *flateTransform) encode(frame []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(frame); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (t *flateTransform) decode(frame []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(frame))
+	defer r.Close()
+	var src io.Reader = r
+	if t.maxSize > 0 {
+		src = io.LimitReader(r, int64(t.maxSize)+1)
+	}
+	out, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, err
+	}
+	if t.maxSize > 0 && len(out) > t.maxSize {
+		return nil, fmt.Errorf("decompressed frame exceeds the %d bytes limit", t.maxSize)
+	}
+	return out, nil
+}
@@ -0,0 +1,178 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/version"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateCapabilities(t *testing.T) {
+	// intersection of what both sides offer
+	agreed := negotiateCapabilities([]string{CapabilityFlateCompression}, []string{CapabilityFlateCompression})
+	assert.Equal(t, []string{CapabilityFlateCompression}, agreed)
+
+	// a disabled side never agrees to anything
+	assert.Nil(t, negotiateCapabilities(nil, []string{CapabilityFlateCompression}))
+	assert.Nil(t, negotiateCapabilities([]string{CapabilityFlateCompression}, nil))
+
+	// capabilities from a newer peer we don't recognize are ignored
+	agreed = negotiateCapabilities([]string{CapabilityFlateCompression}, []string{"cipher/42", CapabilityFlateCompression, "frobnicate/7"})
+	assert.Equal(t, []string{CapabilityFlateCompression}, agreed)
+}
+
+func TestFlateTransformRoundtrip(t *testing.T) {
+	tr := &flateTransform{maxSize: 1 << 20}
+	payload := bytes.Repeat([]byte("spacemesh"), 500)
+
+	encoded, err := tr.encode(payload)
+	assert.NoError(t, err)
+	assert.True(t, len(encoded) < len(payload), "expected the frame to shrink")
+	assert.False(t, bytes.Contains(encoded, []byte("spacemeshspacemesh")), "expected the payload to be unreadable on the wire")
+
+	decoded, err := tr.decode(encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+
+	// decoded frames over the size limit are refused
+	small := &flateTransform{maxSize: 16}
+	_, err = small.decode(encoded)
+	assert.Error(t, err, "expected the decode size limit to kick in")
+}
+
+// wireTap is a TCP proxy recording every byte that crosses it in either
+// direction, so tests can look at what actually went over the wire.
+type wireTap struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	recorded []byte
+}
+
+func startWireTap(t *testing.T, target string) *wireTap {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "failed to bind the tap listener")
+	tap := &wireTap{listener: listener}
+	go func() {
+		for {
+			client, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server, err := net.Dial("tcp", target)
+			if err != nil {
+				client.Close()
+				continue
+			}
+			pipe := func(dst, src net.Conn) {
+				io.Copy(dst, io.TeeReader(src, tap))
+				dst.Close()
+			}
+			go pipe(server, client)
+			go pipe(client, server)
+		}
+	}()
+	return tap
+}
+
+func (tap *wireTap) Write(p []byte) (int, error) {
+	tap.mutex.Lock()
+	tap.recorded = append(tap.recorded, p...)
+	tap.mutex.Unlock()
+	return len(p), nil
+}
+
+func (tap *wireTap) bytes() []byte {
+	tap.mutex.Lock()
+	defer tap.mutex.Unlock()
+	return append([]byte(nil), tap.recorded...)
+}
+
+func (tap *wireTap) Addr() string { return tap.listener.Addr().String() }
+func (tap *wireTap) Close()       { tap.listener.Close() }
+
+// runCompressionPair establishes a session between two nodes through a wire
+// tap, sends payload over the resulting connection and returns everything
+// the tap saw once the payload arrived.
+func runCompressionPair(t *testing.T, serverComp, clientComp bool, payload []byte) []byte {
+	serverCfg := config.DefaultConfig()
+	serverCfg.FrameCompression = serverComp
+	serverNode, err := node.NewNodeIdentity(serverCfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+	server, err := NewNet(serverCfg, serverNode)
+	assert.NoError(t, err)
+	defer server.Shutdown()
+
+	tap := startWireTap(t, server.tcpListener.Addr().String())
+	defer tap.Close()
+
+	clientCfg := config.DefaultConfig()
+	clientCfg.FrameCompression = clientComp
+	clientNode, err := node.NewNodeIdentity(clientCfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+	client, err := NewNet(clientCfg, clientNode)
+	assert.NoError(t, err)
+	defer client.Shutdown()
+
+	conn, err := client.Dial(tap.Addr(), serverNode.PublicKey())
+	assert.NoError(t, err, "failed to establish a session through the tap")
+	defer conn.Close()
+
+	received := make(chan []byte, 1)
+	for _, queue := range server.IncomingMessages() {
+		go func(c chan IncomingMessageEvent) {
+			ev := <-c
+			received <- ev.Message
+		}(queue)
+	}
+
+	assert.NoError(t, conn.Send(payload))
+	select {
+	case got := <-received:
+		assert.Equal(t, payload, got, "payload did not round-trip identically")
+	case <-time.After(2 * time.Second):
+		t.Fatal("payload never arrived")
+	}
+	return tap.bytes()
+}
+
+func TestFrameCompressionDisabledPeer(t *testing.T) {
+	// one side has compression off, so frames stay plain on the wire
+	payload := bytes.Repeat([]byte("spacemesh"), 200)
+	wire := runCompressionPair(t, false, true, payload)
+	assert.True(t, bytes.Contains(wire, payload), "expected plain frames when one side has compression disabled")
+}
+
+func TestFrameCompressionNegotiated(t *testing.T) {
+	// both sides enabled - the payload must not appear in clear on the wire
+	payload := bytes.Repeat([]byte("spacemesh"), 200)
+	wire := runCompressionPair(t, true, true, payload)
+	assert.False(t, bytes.Contains(wire, payload), "expected compressed frames when both sides enabled compression")
+}
+
+func TestFeatureFlagsInCapabilities(t *testing.T) {
+	version.RegisterFeature("test/udp-transport")
+
+	// a registered feature rides the handshake offer alongside the framing
+	// capabilities
+	caps := supportedCapabilities(config.DefaultConfig())
+	assert.True(t, hasCapability(caps, "test/udp-transport"))
+	assert.True(t, hasCapability(caps, CapabilityAEAD))
+
+	// two nodes with different feature sets - the initiator's view of the
+	// other side is the negotiated intersection
+	nodeA := []string{CapabilityAEAD, "test/udp-transport"}
+	nodeB := []string{CapabilityAEAD, CapabilityFlateCompression}
+	assert.Equal(t, []string{CapabilityAEAD}, negotiateCapabilities(nodeA, nodeB))
+
+	// the responder sees the requester's full advertised set - a required
+	// flag the peer doesn't carry is detectable on either side
+	assert.Equal(t, []string{"test/udp-transport"}, version.MissingFeatures(nodeB, []string{"test/udp-transport"}))
+	assert.Empty(t, version.MissingFeatures(nodeA, []string{"test/udp-transport"}))
+}
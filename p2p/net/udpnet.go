@@ -0,0 +1,229 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"gopkg.in/op/go-logging.v1"
+)
+
+// MaxUDPSize is the payload budget for a single UDP message. It is kept
+// under the common path MTU so discovery-class messages don't fragment -
+// anything larger must go over a TCP connection instead.
+const MaxUDPSize = 1200
+
+// udpProtocolVersion is the wire version of the UDP framing below.
+const udpProtocolVersion = 1
+
+// ErrUDPMsgOverBudget is returned when a message doesn't fit the UDP size
+// budget. Callers are expected to fall back to the TCP transport.
+var ErrUDPMsgOverBudget = errors.New("message exceeds the udp size budget")
+
+// ErrUDPShuttingDown is returned when sending on a closed UDP transport.
+var ErrUDPShuttingDown = errors.New("udp transport is shutting down")
+
+// UDPMessageEvent is the event reported on a new incoming UDP message after
+// its signature was verified.
+type UDPMessageEvent struct {
+	From     node.Node
+	Protocol string
+	Message  []byte
+}
+
+// UDPNet is a stateless transport for small, latency-sensitive and
+// loss-tolerant messages such as DHT pings and find_node. There is no
+// session - every packet carries the sender's public key and a signature
+// over its content, and forged or malformed packets are dropped.
+type UDPNet struct {
+	localNode *node.LocalNode
+	logger    *logging.Logger
+
+	conn *net.UDPConn
+
+	incoming chan UDPMessageEvent
+
+	isShuttingDown bool
+	dropped        int64
+}
+
+// NewUDPNet binds a UDP listener on the given address (e.g. the node's own
+// address, or a port-0 one for an ephemeral port) and starts serving it.
+func NewUDPNet(address string, localEntity *node.LocalNode) (*UDPNet, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve udp address: %v, err: %v", address, err)
+	}
+
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &UDPNet{
+		localNode: localEntity,
+		logger:    localEntity.Logger,
+		conn:      conn,
+		incoming:  make(chan UDPMessageEvent, 256),
+	}
+
+	n.logger.Info("Listening for udp on %v", conn.LocalAddr())
+	go n.listenLoop()
+
+	return n, nil
+}
+
+// LocalAddr returns the bound UDP address, with an ephemeral port resolved
+// to its actual value.
+func (n *UDPNet) LocalAddr() net.Addr {
+	return n.conn.LocalAddr()
+}
+
+// IncomingMessages returns the channel verified incoming messages are
+// delivered on.
+func (n *UDPNet) IncomingMessages() chan UDPMessageEvent {
+	return n.incoming
+}
+
+// DroppedPackets counts incoming packets discarded as malformed or carrying
+// a bad signature.
+func (n *UDPNet) DroppedPackets() int64 {
+	return atomic.LoadInt64(&n.dropped)
+}
+
+// Shutdown closes the UDP listener.
+func (n *UDPNet) Shutdown() {
+	n.isShuttingDown = true
+	n.conn.Close()
+}
+
+// Send signs and sends a single message to the given node. Messages over
+// MaxUDPSize are refused with ErrUDPMsgOverBudget so the caller can fall
+// back to TCP.
+func (n *UDPNet) Send(to node.Node, protocol string, payload []byte) error {
+	if n.isShuttingDown {
+		return ErrUDPShuttingDown
+	}
+	if len(payload) > MaxUDPSize {
+		return ErrUDPMsgOverBudget
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", to.Address())
+	if err != nil {
+		return fmt.Errorf("can't resolve udp address: %v, err: %v", to.Address(), err)
+	}
+
+	frame, err := n.frameMessage(protocol, payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = n.conn.WriteToUDP(frame, udpAddr)
+	return err
+}
+
+// frameMessage assembles the stateless wire frame - version, the sender's
+// public key, the protocol name and a signature over protocol and payload.
+func (n *UDPNet) frameMessage(protocol string, payload []byte) ([]byte, error) {
+	pub := n.localNode.PublicKey().Bytes()
+	if len(pub) > 255 || len(protocol) > 255 {
+		return nil, errors.New("public key or protocol name too long to frame")
+	}
+
+	signed := append([]byte(protocol), payload...)
+	sig, err := n.localNode.PrivateKey().Sign(signed)
+	if err != nil {
+		return nil, err
+	}
+	if len(sig) > 255 {
+		return nil, errors.New("signature too long to frame")
+	}
+
+	frame := make([]byte, 0, 4+len(pub)+len(protocol)+len(sig)+len(payload))
+	frame = append(frame, udpProtocolVersion, byte(len(pub)))
+	frame = append(frame, pub...)
+	frame = append(frame, byte(len(protocol)))
+	frame = append(frame, protocol...)
+	frame = append(frame, byte(len(sig)))
+	frame = append(frame, sig...)
+	frame = append(frame, payload...)
+	return frame, nil
+}
+
+// parseFrame is the inverse of frameMessage. It verifies the signature
+// against the embedded public key and errors on anything malformed.
+func parseFrame(frame []byte) (crypto.PublicKey, string, []byte, error) {
+	if len(frame) < 2 || frame[0] != udpProtocolVersion {
+		return nil, "", nil, errors.New("bad frame version")
+	}
+	rest := frame[1:]
+
+	readChunk := func() ([]byte, error) {
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, errors.New("truncated frame")
+		}
+		chunk := rest[1 : 1+int(rest[0])]
+		rest = rest[1+int(rest[0]):]
+		return chunk, nil
+	}
+
+	pubBytes, err := readChunk()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	protocol, err := readChunk()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	sig, err := readChunk()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	payload := rest
+
+	pub, err := crypto.NewPublicKey(pubBytes)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("bad public key in frame: %v", err)
+	}
+	signed := make([]byte, 0, len(protocol)+len(payload))
+	signed = append(append(signed, protocol...), payload...)
+	ok, err := pub.Verify(signed, sig)
+	if err != nil || !ok {
+		return nil, "", nil, errors.New("bad frame signature")
+	}
+	return pub, string(protocol), payload, nil
+}
+
+func (n *UDPNet) listenLoop() {
+	buf := make([]byte, 65536)
+	for {
+		size, from, err := n.conn.ReadFromUDP(buf)
+		if err != nil {
+			if !n.isShuttingDown {
+				n.logger.Error("Failed to read udp packet, err: %v", err)
+			}
+			return
+		}
+
+		frame := make([]byte, size)
+		copy(frame, buf[:size])
+
+		pub, protocol, payload, err := parseFrame(frame)
+		if err != nil {
+			atomic.AddInt64(&n.dropped, 1)
+			n.logger.Debug("dropping udp packet from %v: %v", from, err)
+			continue
+		}
+
+		sender := node.New(pub, from.String())
+		select {
+		case n.incoming <- UDPMessageEvent{From: sender, Protocol: protocol, Message: payload}:
+		default:
+			atomic.AddInt64(&n.dropped, 1)
+			n.logger.Warning("dropping udp packet from %v: incoming queue is full", from)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	dhtpb "github.com/spacemeshos/go-spacemesh/p2p/dht/pb"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/stretchr/testify/assert"
+)
+
+const testDHTProtocol = "/dht/1.0/find-node/"
+
+// udpPair is two in-process udp transports that know each other's address.
+func udpPair(t *testing.T) (*UDPNet, *UDPNet, node.Node, node.Node) {
+	cfg := config.DefaultConfig()
+	ln1, err := node.NewNodeIdentity(cfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+	ln2, err := node.NewNodeIdentity(cfg, "127.0.0.1:0", false)
+	assert.NoError(t, err)
+
+	u1, err := NewUDPNet("127.0.0.1:0", ln1)
+	assert.NoError(t, err)
+	u2, err := NewUDPNet("127.0.0.1:0", ln2)
+	assert.NoError(t, err)
+
+	n1 := node.New(ln1.PublicKey(), u1.LocalAddr().String())
+	n2 := node.New(ln2.PublicKey(), u2.LocalAddr().String())
+	return u1, u2, n1, n2
+}
+
+func TestUDPNetPing(t *testing.T) {
+	u1, u2, n1, n2 := udpPair(t)
+	defer u1.Shutdown()
+	defer u2.Shutdown()
+
+	ping := []byte("ping")
+	assert.NoError(t, u1.Send(n2, testDHTProtocol, ping))
+
+	select {
+	case ev := <-u2.IncomingMessages():
+		assert.Equal(t, n1.PublicKey().String(), ev.From.PublicKey().String())
+		assert.Equal(t, testDHTProtocol, ev.Protocol)
+		assert.Equal(t, ping, ev.Message)
+		// pong back the other way
+		assert.NoError(t, u2.Send(node.New(ev.From.PublicKey(), ev.From.Address()), testDHTProtocol, []byte("pong")))
+	case <-time.After(time.Second):
+		t.Fatal("no ping arrived over udp")
+	}
+
+	select {
+	case ev := <-u1.IncomingMessages():
+		assert.Equal(t, n2.PublicKey().String(), ev.From.PublicKey().String())
+		assert.Equal(t, []byte("pong"), ev.Message)
+	case <-time.After(time.Second):
+		t.Fatal("no pong arrived over udp")
+	}
+}
+
+func TestUDPServiceFindNode(t *testing.T) {
+	u1, u2, n1, n2 := udpPair(t)
+
+	directory := map[string]node.Node{n1.String(): n1, n2.String(): n2}
+	lookup := func(nodeID string) (node.Node, error) {
+		if n, ok := directory[nodeID]; ok {
+			return n, nil
+		}
+		return node.EmptyNode, fmt.Errorf("unknown node %v", nodeID)
+	}
+
+	s1 := NewUDPService(u1, nil, lookup)
+	s2 := NewUDPService(u2, nil, lookup)
+	defer s1.Shutdown()
+	defer s2.Shutdown()
+
+	c1 := s1.RegisterProtocol(testDHTProtocol)
+	c2 := s2.RegisterProtocol(testDHTProtocol)
+	assert.NoError(t, s1.Start())
+	assert.NoError(t, s2.Start())
+
+	// the responder answers a find_node request with an empty response
+	go func() {
+		msg := <-c2
+		headers := &dhtpb.FindNode{}
+		if err := proto.Unmarshal(msg.Data(), headers); err != nil || !headers.Req {
+			return
+		}
+		resp, err := proto.Marshal(&dhtpb.FindNode{Req: false, ReqID: headers.ReqID})
+		if err != nil {
+			return
+		}
+		s2.SendMessage(msg.Sender().String(), testDHTProtocol, resp)
+	}()
+
+	reqID := []byte("req-1")
+	req, err := proto.Marshal(&dhtpb.FindNode{Req: true, ReqID: reqID, Payload: []byte("target")})
+	assert.NoError(t, err)
+	assert.NoError(t, s1.SendMessage(n2.String(), testDHTProtocol, req))
+
+	select {
+	case msg := <-c1:
+		headers := &dhtpb.FindNode{}
+		assert.NoError(t, proto.Unmarshal(msg.Data(), headers))
+		assert.False(t, headers.Req)
+		assert.Equal(t, reqID, headers.ReqID)
+	case <-time.After(time.Second):
+		t.Fatal("no find_node response arrived over udp")
+	}
+}
+
+// recordingService counts what was routed to the TCP fallback.
+type recordingService struct {
+	sent chan []byte
+}
+
+func (r *recordingService) Start() error { return nil }
+func (r *recordingService) RegisterProtocol(protocol string) chan service.Message {
+	return make(chan service.Message, 1)
+}
+func (r *recordingService) SendMessage(nodeID string, protocol string, payload []byte) error {
+	r.sent <- payload
+	return nil
+}
+func (r *recordingService) Shutdown() {}
+
+func TestUDPServiceFallbackOversized(t *testing.T) {
+	u1, u2, _, n2 := udpPair(t)
+	defer u2.Shutdown()
+
+	directory := func(nodeID string) (node.Node, error) { return n2, nil }
+	fallback := &recordingService{sent: make(chan []byte, 1)}
+	s1 := NewUDPService(u1, fallback, directory)
+	defer s1.Shutdown()
+	assert.NoError(t, s1.Start())
+
+	oversized := make([]byte, MaxUDPSize+1)
+	assert.NoError(t, s1.SendMessage(n2.String(), testDHTProtocol, oversized))
+
+	select {
+	case payload := <-fallback.sent:
+		assert.Equal(t, len(oversized), len(payload))
+	case <-time.After(time.Second):
+		t.Fatal("oversized message was not routed to the fallback transport")
+	}
+}
+
+func TestUDPNetDropsForgedPackets(t *testing.T) {
+	u1, u2, _, n2 := udpPair(t)
+	defer u1.Shutdown()
+	defer u2.Shutdown()
+
+	// a well-formed frame whose payload was tampered with after signing
+	forged, err := u1.frameMessage(testDHTProtocol, []byte("original"))
+	assert.NoError(t, err)
+	forged[len(forged)-1] ^= 0xff
+
+	raw, err := net.Dial("udp", u2.LocalAddr().String())
+	assert.NoError(t, err)
+	defer raw.Close()
+
+	_, err = raw.Write(forged)
+	assert.NoError(t, err)
+	_, err = raw.Write([]byte("garbage"))
+	assert.NoError(t, err)
+
+	assert.True(t, waitFor(2*time.Second, func() bool { return u2.DroppedPackets() == 2 }),
+		"expected both packets to be dropped, got %v", u2.DroppedPackets())
+
+	// a genuine message still goes through
+	assert.NoError(t, u1.Send(n2, testDHTProtocol, []byte("real")))
+	select {
+	case ev := <-u2.IncomingMessages():
+		assert.Equal(t, []byte("real"), ev.Message)
+	case <-time.After(time.Second):
+		t.Fatal("genuine message did not arrive")
+	}
+}
+
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
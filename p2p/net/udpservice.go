@@ -0,0 +1,127 @@
+package net
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+)
+
+// udpIncomingMessage is the service.Message delivered for verified UDP
+// packets.
+type udpIncomingMessage struct {
+	sender node.Node
+	data   []byte
+}
+
+func (m udpIncomingMessage) Sender() node.Node { return m.sender }
+func (m udpIncomingMessage) Data() []byte      { return m.data }
+
+// UDPService adapts a UDPNet to the service.Service interface, so protocols
+// like the DHT can be pointed at UDP without knowing about the transport.
+// Messages that don't fit the UDP budget, peers we can't resolve an address
+// for, and send failures all fall back to the given TCP-backed service, and
+// messages arriving over either transport are delivered on the same
+// per-protocol channel.
+type UDPService struct {
+	udp      *UDPNet
+	fallback service.Service
+
+	// resolves a node id to a dialable node, typically backed by the DHT
+	// or a static table in tests
+	lookup func(nodeID string) (node.Node, error)
+
+	protocols     map[string]chan service.Message
+	protocolMutex sync.RWMutex
+
+	shutdown chan struct{}
+}
+
+// NewUDPService creates a UDPService around an already listening UDPNet.
+// fallback may be nil when there is no TCP transport to fall back to.
+func NewUDPService(udp *UDPNet, fallback service.Service, lookup func(nodeID string) (node.Node, error)) *UDPService {
+	return &UDPService{
+		udp:       udp,
+		fallback:  fallback,
+		lookup:    lookup,
+		protocols: make(map[string]chan service.Message),
+		shutdown:  make(chan struct{}),
+	}
+}
+
+// Start begins demultiplexing incoming UDP messages to the registered
+// protocol channels. The fallback service's lifecycle stays with its owner.
+func (s *UDPService) Start() error {
+	go s.demuxLoop()
+	return nil
+}
+
+// RegisterProtocol returns the channel messages for `protocol` are delivered
+// on, whether they arrived over UDP or over the fallback transport.
+func (s *UDPService) RegisterProtocol(protocol string) chan service.Message {
+	mchan := make(chan service.Message, 100)
+	s.protocolMutex.Lock()
+	s.protocols[protocol] = mchan
+	s.protocolMutex.Unlock()
+
+	if s.fallback != nil {
+		fchan := s.fallback.RegisterProtocol(protocol)
+		go func() {
+			for {
+				select {
+				case msg, ok := <-fchan:
+					if !ok {
+						return
+					}
+					mchan <- msg
+				case <-s.shutdown:
+					return
+				}
+			}
+		}()
+	}
+	return mchan
+}
+
+// SendMessage sends a message preferring the UDP transport, falling back to
+// the TCP-backed service when the peer can't be resolved, the payload is
+// over the UDP budget or the send fails.
+func (s *UDPService) SendMessage(nodeID string, protocol string, payload []byte) error {
+	to, err := s.lookup(nodeID)
+	if err == nil {
+		err = s.udp.Send(to, protocol, payload)
+		if err == nil {
+			return nil
+		}
+	}
+	if s.fallback == nil {
+		return fmt.Errorf("failed to send udp message to %v: %v", nodeID, err)
+	}
+	return s.fallback.SendMessage(nodeID, protocol, payload)
+}
+
+// Shutdown stops the demux loop and the underlying UDP listener. The
+// fallback service is left to its owner.
+func (s *UDPService) Shutdown() {
+	close(s.shutdown)
+	s.udp.Shutdown()
+}
+
+func (s *UDPService) demuxLoop() {
+	for {
+		select {
+		case ev := <-s.udp.IncomingMessages():
+			s.protocolMutex.RLock()
+			mchan, ok := s.protocols[ev.Protocol]
+			s.protocolMutex.RUnlock()
+			if !ok {
+				s.udp.logger.Debug("dropping udp message for unregistered protocol %v", ev.Protocol)
+				continue
+			}
+			mchan <- udpIncomingMessage{sender: ev.From, data: ev.Message}
+		case <-s.shutdown:
+			return
+		}
+	}
+}
@@ -10,4 +10,5 @@ type Formatter interface {
 	In() chan []byte
 	Out(message []byte) error
 	Close()
+	Err() error // why the incoming channel was closed, nil for a plain stream end
 }
@@ -0,0 +1,76 @@
+package node
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrMissingHost is returned when an address has no host part.
+var ErrMissingHost = errors.New("node address is missing a host")
+
+// ErrInvalidPort is returned when an address port is missing, not a number or zero.
+var ErrInvalidPort = errors.New("node address has an invalid port")
+
+// ErrMalformedAddress is returned when an address can't be split into host and port.
+var ErrMalformedAddress = errors.New("node address is malformed")
+
+// Address is the parsed form of a node network address.
+// It is kept on Node next to the raw string so dial errors surface here,
+// at parse time, and not deep inside the networking stack.
+type Address struct {
+	Scheme string // optional, e.g. "tcp", empty when the address carries no scheme
+	Host   string // hostname or ip, IPv6 addresses are unbracketed
+	Port   uint16
+}
+
+// String returns the address in host:port form, bracketing IPv6 hosts and
+// prefixing the scheme when one is set.
+func (a Address) String() string {
+	s := net.JoinHostPort(a.Host, strconv.Itoa(int(a.Port)))
+	if a.Scheme != "" {
+		return a.Scheme + "://" + s
+	}
+	return s
+}
+
+// IsValid reports whether the address carries a usable host and port.
+func (a Address) IsValid() bool {
+	return a.Host != "" && a.Port != 0
+}
+
+// ParseAddress parses a node address of the form [scheme://]host:port.
+// IPv6 hosts must be bracketed, e.g. [::1]:7513. An empty host, a zero or
+// non-numeric port and unsplittable garbage are rejected with typed errors.
+func ParseAddress(s string) (Address, error) {
+
+	var addr Address
+
+	if i := strings.Index(s, "://"); i >= 0 {
+		addr.Scheme = s[:i]
+		if addr.Scheme == "" {
+			return Address{}, ErrMalformedAddress
+		}
+		s = s[i+len("://"):]
+	}
+
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil {
+		return Address{}, ErrMalformedAddress
+	}
+
+	if host == "" {
+		return Address{}, ErrMissingHost
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return Address{}, ErrInvalidPort
+	}
+
+	addr.Host = host
+	addr.Port = uint16(port)
+
+	return addr, nil
+}
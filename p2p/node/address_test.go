@@ -0,0 +1,74 @@
+package node
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+
+	cases := []struct {
+		input  string
+		parsed Address
+	}{
+		{"127.0.0.1:3572", Address{Host: "127.0.0.1", Port: 3572}},
+		{"0.0.0.0:7513", Address{Host: "0.0.0.0", Port: 7513}},
+		{"somehost.example.com:80", Address{Host: "somehost.example.com", Port: 80}},
+		{"[::1]:7513", Address{Host: "::1", Port: 7513}},
+		{"[2001:db8::1]:443", Address{Host: "2001:db8::1", Port: 443}},
+		{"tcp://127.0.0.1:3572", Address{Scheme: "tcp", Host: "127.0.0.1", Port: 3572}},
+		{"udp://[::1]:7513", Address{Scheme: "udp", Host: "::1", Port: 7513}},
+	}
+
+	for _, c := range cases {
+		addr, err := ParseAddress(c.input)
+		assert.NoError(t, err, "failed to parse %v", c.input)
+		assert.Equal(t, c.parsed, addr, "unexpected parse result for %v", c.input)
+		assert.True(t, addr.IsValid(), "expected a valid address for %v", c.input)
+		assert.Equal(t, c.input, addr.String(), "expected round-trip formatting for %v", c.input)
+	}
+
+	badCases := []struct {
+		input string
+		err   error
+	}{
+		{"", ErrMalformedAddress},
+		{"garbage", ErrMalformedAddress},
+		{"://127.0.0.1:3572", ErrMalformedAddress},
+		{"::1:7513", ErrMalformedAddress}, // unbracketed ipv6
+		{":3572", ErrMissingHost},
+		{"tcp://:3572", ErrMissingHost},
+		{"127.0.0.1:", ErrInvalidPort},
+		{"127.0.0.1:0", ErrInvalidPort},
+		{"127.0.0.1:port", ErrInvalidPort},
+		{"127.0.0.1:99999", ErrInvalidPort},
+	}
+
+	for _, c := range badCases {
+		addr, err := ParseAddress(c.input)
+		assert.Equal(t, c.err, err, "unexpected error for %v", c.input)
+		assert.False(t, addr.IsValid(), "expected an invalid address for %v", c.input)
+	}
+}
+
+func TestNodeParsedAddress(t *testing.T) {
+
+	n := GenerateRandomNodeData()
+	assert.True(t, n.ParsedAddress().IsValid(), "expected a valid parsed address")
+	assert.Equal(t, n.Address(), n.ParsedAddress().String(), "expected matching raw and parsed addresses")
+
+	// a node built from an unparsable address carries a zero parsed form
+	bad := New(n.PublicKey(), "garbage")
+	assert.False(t, bad.ParsedAddress().IsValid(), "expected an invalid parsed address")
+
+	// parsing from string rejects bad addresses
+	_, err := NewNodeFromString("garbage/" + n.PublicKey().String())
+	assert.Error(t, err, "expected an address error")
+
+	_, err = NewNodeFromString("127.0.0.1:0/" + n.PublicKey().String())
+	assert.Error(t, err, "expected a port error")
+
+	parsed, err := NewNodeFromString(n.Address() + "/" + n.PublicKey().String())
+	assert.NoError(t, err, "failed to parse a valid node string")
+	assert.True(t, parsed.ParsedAddress().IsValid(), "expected a valid parsed address")
+}
@@ -5,7 +5,6 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"math/big"
 	"math/bits"
 	"sort"
 
@@ -63,10 +62,11 @@ func (id DhtID) Equals(other DhtID) bool {
 	return bytes.Equal(id, other)
 }
 
-// Distance returns the distance between DhtID and id1 encoded as a big int.
-func (id DhtID) Distance(id1 DhtID) *big.Int {
-	id2 := id.Xor(id1)
-	return big.NewInt(0).SetBytes(id2)
+// Distance returns the XOR distance between the two IDs as a DhtID.
+// Distances order with Less - a smaller distance means closer. The distance
+// between an id and itself is the zero id.
+func (id DhtID) Distance(id1 DhtID) DhtID {
+	return id.Xor(id1)
 }
 
 // Less returns true iff the binary number represented by DhtID is less than the number represented by o.
@@ -115,6 +115,24 @@ func (id DhtID) Closer(id1 DhtID, id2 DhtID) bool {
 	return dist1.Less(dist2)
 }
 
+// CloserTo returns true iff a is closer to target than b in the XOR keyspace.
+// For distinct a and b exactly one direction holds - XOR distances from a
+// fixed target never tie.
+func CloserTo(target, a, b DhtID) bool {
+	return target.Closer(a, b)
+}
+
+// CommonPrefixLen returns the length in bits of the common prefix of a and b.
+func CommonPrefixLen(a, b DhtID) int {
+	return a.CommonPrefixLen(b)
+}
+
+// SortByDistance returns a copy of ids sorted by XOR distance from target,
+// closest first. The input slice is not modified.
+func SortByDistance(target DhtID, ids []DhtID) []DhtID {
+	return target.SortByDistance(ids)
+}
+
 // XOR is a helper method used to return a byte slice which is the XOR of 2 provided byte slices.
 func XOR(a, b []byte) []byte {
 	c := make([]byte, len(a))
@@ -144,5 +162,5 @@ func (s idsByDistanceToCenter) Swap(i, j int) {
 func (s idsByDistanceToCenter) Less(i, j int) bool {
 	a := s.Center.Distance(s.Ids[i])
 	b := s.Center.Distance(s.Ids[j])
-	return a.Cmp(b) == -1
+	return a.Less(b)
 }
@@ -3,6 +3,7 @@ package node
 import (
 	"encoding/hex"
 	"math/big"
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -58,7 +59,7 @@ func TestDhtIds(t *testing.T) {
 	assert.False(t, id1.Equals(id3), "expected non-equal ids")
 
 	d := id1.Distance(id1)
-	assert.True(t, d.Cmp(big.NewInt(0)) == 0, "expected 0 distance from same id")
+	assert.True(t, d.Equals(make(DhtID, len(id1))), "expected 0 distance from same id")
 
 	pl := id6.ZeroPrefixLen()
 	//16 nullbytes means 16*8 zeros ( rest of string is FF means no more zeros )
@@ -99,3 +100,67 @@ func TestDhtIds(t *testing.T) {
 
 	// todo: test DhtID.sortByDistance
 }
+
+// randomDhtID returns a dht id derived from the given RNG.
+func randomDhtID(rnd *rand.Rand) DhtID {
+	data := make([]byte, 32)
+	rnd.Read(data)
+	return NewDhtID(data)
+}
+
+// refDistance is a big.Int based reference for XOR distance comparisons.
+func refDistance(a, b DhtID) *big.Int {
+	return big.NewInt(0).SetBytes(XOR(a, b))
+}
+
+func TestDhtIDMetricAxioms(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+
+	for i := 0; i < 100; i++ {
+		a, b, target := randomDhtID(rnd), randomDhtID(rnd), randomDhtID(rnd)
+
+		// d(a,a) = 0
+		assert.True(t, a.Distance(a).Equals(make(DhtID, len(a))), "expected zero distance from self")
+
+		// d(a,b) = d(b,a)
+		assert.True(t, a.Distance(b).Equals(b.Distance(a)), "expected a symmetric distance")
+
+		// for distinct a and b exactly one is closer to target
+		if !a.Equals(b) {
+			assert.NotEqual(t, CloserTo(target, a, b), CloserTo(target, b, a), "expected exactly one closer direction")
+		}
+
+		// distances compare consistently with a big.Int reference
+		ref := refDistance(target, a).Cmp(refDistance(target, b)) == -1
+		assert.Equal(t, ref, CloserTo(target, a, b), "closer disagrees with the big.Int reference")
+
+		// common prefix length matches the reference: 256 - bitlen(xor)
+		refCpl := 256 - refDistance(a, b).BitLen()
+		assert.Equal(t, refCpl, CommonPrefixLen(a, b), "cpl disagrees with the big.Int reference")
+	}
+}
+
+func TestSortByDistance(t *testing.T) {
+	rnd := rand.New(rand.NewSource(7))
+	target := randomDhtID(rnd)
+
+	ids := make([]DhtID, 20)
+	for i := range ids {
+		ids[i] = randomDhtID(rnd)
+	}
+	original := make([]DhtID, len(ids))
+	copy(original, ids)
+
+	sorted := SortByDistance(target, ids)
+
+	// the result is ordered closest first, consistent with the reference
+	for i := 0; i < len(sorted)-1; i++ {
+		assert.True(t, refDistance(target, sorted[i]).Cmp(refDistance(target, sorted[i+1])) == -1,
+			"unexpected sort order")
+	}
+
+	// the input slice is untouched
+	for i := range original {
+		assert.True(t, original[i].Equals(ids[i]), "input slice was modified")
+	}
+}
@@ -0,0 +1,238 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthState is the node's overall lifecycle state, aggregated from the
+// readiness probes the subsystems register.
+type HealthState int
+
+// The lifecycle states and their transition rules:
+//
+//	Starting - no evaluation has completed yet, or no probes are registered.
+//	Syncing  - probes are failing and the node has never been Ready. This is
+//	           the normal state while bootstrapping and catching up.
+//	Ready    - the latest evaluation passed every probe.
+//	Degraded - probes are failing after the node has been Ready. A probe that
+//	           doesn't answer within the probe timeout counts as failing.
+//	Stopping - the node is shutting down. Terminal, entered only via Stop.
+const (
+	Starting HealthState = iota
+	Syncing
+	Ready
+	Degraded
+	Stopping
+)
+
+// String implements fmt.Stringer.
+func (s HealthState) String() string {
+	switch s {
+	case Starting:
+		return "starting"
+	case Syncing:
+		return "syncing"
+	case Ready:
+		return "ready"
+	case Degraded:
+		return "degraded"
+	case Stopping:
+		return "stopping"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// HealthProbe reports whether one subsystem is ready to participate. A nil
+// return means ready. Probes must respect ctx - the manager cancels it when
+// the probe timeout expires and counts the probe as failing.
+type HealthProbe func(ctx context.Context) error
+
+// HealthChange is delivered to subscribers whenever the overall state moves.
+type HealthChange struct {
+	From   HealthState
+	To     HealthState
+	Reason string
+	Time   time.Time
+}
+
+// DefaultProbeTimeout bounds a single readiness probe. A stuck subsystem
+// makes the node Degraded instead of hanging the aggregator.
+const DefaultProbeTimeout = 2 * time.Second
+
+// healthSubscriberBuffer is the event buffer per subscriber. A subscriber
+// that stops draining loses events rather than blocking state transitions.
+const healthSubscriberBuffer = 16
+
+// HealthManager aggregates subsystem readiness probes into one lifecycle
+// state. Subsystems register probes as they start, callers evaluate
+// periodically and read State, orchestrators subscribe for transitions.
+type HealthManager struct {
+	mu          sync.Mutex
+	probes      map[string]HealthProbe
+	names       []string // registration order, keeps evaluation deterministic
+	state       HealthState
+	everReady   bool
+	lastResults map[string]string
+	subscribers []chan HealthChange
+	timeout     time.Duration
+}
+
+// NewHealthManager creates a health manager in the Starting state. A zero
+// timeout selects DefaultProbeTimeout.
+func NewHealthManager(timeout time.Duration) *HealthManager {
+	if timeout == 0 {
+		timeout = DefaultProbeTimeout
+	}
+	return &HealthManager{
+		probes:      make(map[string]HealthProbe),
+		lastResults: make(map[string]string),
+		state:       Starting,
+		timeout:     timeout,
+	}
+}
+
+// RegisterProbe registers a subsystem's readiness probe under a unique name.
+// Registering the same name twice replaces the probe, so a restarting
+// subsystem can re-register itself.
+func (h *HealthManager) RegisterProbe(name string, f HealthProbe) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.probes[name]; !ok {
+		h.names = append(h.names, name)
+	}
+	h.probes[name] = f
+}
+
+// Subscribe returns a channel delivering state changes. Subscribers that
+// don't keep up lose events - a transition never blocks on a slow listener.
+func (h *HealthManager) Subscribe() <-chan HealthChange {
+	ch := make(chan HealthChange, healthSubscriberBuffer)
+	h.mu.Lock()
+	h.subscribers = append(h.subscribers, ch)
+	h.mu.Unlock()
+	return ch
+}
+
+// State returns the current lifecycle state.
+func (h *HealthManager) State() HealthState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
+}
+
+// HealthStatus returns the state name and the outcome of each probe from the
+// latest evaluation, for the status endpoint.
+func (h *HealthManager) HealthStatus() (string, map[string]string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	probes := make(map[string]string, len(h.lastResults))
+	for name, result := range h.lastResults {
+		probes[name] = result
+	}
+	return h.state.String(), probes
+}
+
+// Evaluate runs all registered probes and moves the state machine. Each probe
+// is bounded by the probe timeout on its own goroutine, so one stuck
+// subsystem costs one timeout and a leaked goroutine, not a hung aggregator.
+// It returns the resulting state.
+func (h *HealthManager) Evaluate(ctx context.Context) HealthState {
+
+	h.mu.Lock()
+	if h.state == Stopping {
+		h.mu.Unlock()
+		return Stopping
+	}
+	names := append([]string(nil), h.names...)
+	probes := make(map[string]HealthProbe, len(h.probes))
+	for name, f := range h.probes {
+		probes[name] = f
+	}
+	timeout := h.timeout
+	h.mu.Unlock()
+
+	results := make(map[string]string, len(names))
+	var failed []string
+
+	for _, name := range names {
+		if err := runProbe(ctx, probes[name], timeout); err != nil {
+			results[name] = err.Error()
+			failed = append(failed, name)
+		} else {
+			results[name] = "ok"
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.state == Stopping { // Stop raced the evaluation
+		return Stopping
+	}
+
+	h.lastResults = results
+
+	switch {
+	case len(names) == 0:
+		// nothing has registered yet - the node hasn't finished starting
+		return h.state
+	case len(failed) == 0:
+		h.everReady = true
+		h.setStateLocked(Ready, "all probes passing")
+	case h.everReady:
+		sort.Strings(failed)
+		h.setStateLocked(Degraded, fmt.Sprintf("probes failing: %v", strings.Join(failed, ", ")))
+	default:
+		sort.Strings(failed)
+		h.setStateLocked(Syncing, fmt.Sprintf("probes failing: %v", strings.Join(failed, ", ")))
+	}
+
+	return h.state
+}
+
+// Stop moves the state machine to Stopping. The state is terminal - later
+// evaluations won't leave it.
+func (h *HealthManager) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.setStateLocked(Stopping, "node is shutting down")
+}
+
+// setStateLocked moves to a new state and notifies subscribers. Callers hold
+// the mutex.
+func (h *HealthManager) setStateLocked(to HealthState, reason string) {
+	if h.state == to {
+		return
+	}
+	change := HealthChange{From: h.state, To: to, Reason: reason, Time: time.Now()}
+	h.state = to
+	for _, ch := range h.subscribers {
+		select {
+		case ch <- change:
+		default: // subscriber isn't draining - drop rather than block
+		}
+	}
+}
+
+// runProbe runs one probe bounded by timeout. The probe runs on its own
+// goroutine since a probe ignoring its context can't be interrupted.
+func runProbe(ctx context.Context, f HealthProbe, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- f(ctx) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/filesystem"
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProbe is a switchable readiness probe for driving the state machine.
+type fakeProbe struct {
+	err error
+}
+
+func (f *fakeProbe) probe(ctx context.Context) error {
+	return f.err
+}
+
+func TestHealthTransitions(t *testing.T) {
+	h := NewHealthManager(0)
+	events := h.Subscribe()
+
+	// nothing registered - an evaluation keeps the node Starting
+	assert.Equal(t, Starting, h.State())
+	assert.Equal(t, Starting, h.Evaluate(context.TODO()))
+
+	dht := &fakeProbe{err: errors.New("not bootstrapped")}
+	peers := &fakeProbe{}
+	h.RegisterProbe("dht", dht.probe)
+	h.RegisterProbe("peers", peers.probe)
+
+	// failing probes before ever reaching Ready mean Syncing
+	assert.Equal(t, Syncing, h.Evaluate(context.TODO()))
+
+	// all probes passing means Ready
+	dht.err = nil
+	assert.Equal(t, Ready, h.Evaluate(context.TODO()))
+
+	// a failure after Ready means Degraded, not Syncing
+	peers.err = errors.New("no peers connected")
+	assert.Equal(t, Degraded, h.Evaluate(context.TODO()))
+
+	// recovery goes back to Ready
+	peers.err = nil
+	assert.Equal(t, Ready, h.Evaluate(context.TODO()))
+
+	// Stop is terminal - later evaluations don't leave it
+	h.Stop()
+	assert.Equal(t, Stopping, h.Evaluate(context.TODO()))
+
+	// subscribers see every transition, in order
+	expected := []struct {
+		from HealthState
+		to   HealthState
+	}{
+		{Starting, Syncing},
+		{Syncing, Ready},
+		{Ready, Degraded},
+		{Degraded, Ready},
+		{Ready, Stopping},
+	}
+	for _, e := range expected {
+		select {
+		case change := <-events:
+			assert.Equal(t, e.from, change.From, "expected transition from %v", e.from)
+			assert.Equal(t, e.to, change.To, "expected transition to %v", e.to)
+			assert.NotEmpty(t, change.Reason, "expected a transition reason")
+		default:
+			t.Fatalf("missing transition %v -> %v", e.from, e.to)
+		}
+	}
+	select {
+	case change := <-events:
+		t.Fatalf("unexpected extra transition %v -> %v", change.From, change.To)
+	default:
+	}
+}
+
+func TestHealthStuckProbe(t *testing.T) {
+	h := NewHealthManager(50 * time.Millisecond)
+
+	hang := make(chan struct{})
+	defer close(hang)
+
+	h.RegisterProbe("ok", (&fakeProbe{}).probe)
+	assert.Equal(t, Ready, h.Evaluate(context.TODO()))
+
+	// a probe ignoring its context can't hang the aggregator - it times out
+	// and the node reports Degraded
+	h.RegisterProbe("stuck", func(ctx context.Context) error {
+		<-hang
+		return nil
+	})
+
+	start := time.Now()
+	assert.Equal(t, Degraded, h.Evaluate(context.TODO()))
+	assert.True(t, time.Since(start) < time.Second, "a stuck probe must not hang Evaluate")
+
+	state, probes := h.HealthStatus()
+	assert.Equal(t, "degraded", state)
+	assert.Equal(t, "ok", probes["ok"])
+	assert.Equal(t, context.DeadlineExceeded.Error(), probes["stuck"])
+}
+
+func TestHealthStatusReport(t *testing.T) {
+	h := NewHealthManager(0)
+
+	state, probes := h.HealthStatus()
+	assert.Equal(t, "starting", state)
+	assert.Empty(t, probes)
+
+	h.RegisterProbe("dht", (&fakeProbe{err: errors.New("not bootstrapped")}).probe)
+	h.Evaluate(context.TODO())
+
+	state, probes = h.HealthStatus()
+	assert.Equal(t, "syncing", state)
+	assert.Equal(t, "not bootstrapped", probes["dht"])
+}
+
+func TestLocalNodeHealth(t *testing.T) {
+	filesystem.UseMemoryFS(t)
+
+	node, err := NewNodeIdentity(config.DefaultConfig(), "0.0.0.0:1234", false)
+	assert.NoError(t, err, "failed to create new local node")
+
+	h := node.Health()
+	assert.NotNil(t, h, "expected a health manager")
+	assert.Equal(t, Starting, h.State())
+
+	h.RegisterProbe("dht", (&fakeProbe{}).probe)
+	assert.Equal(t, Ready, h.Evaluate(context.TODO()))
+
+	// shutdown moves the node to Stopping
+	assert.NoError(t, node.Shutdown(context.TODO()))
+	assert.Equal(t, Stopping, h.State())
+}
@@ -15,8 +15,36 @@ import (
 var ErrFailedToCreate = errors.New("Failed to create local test node")
 
 // GenerateTestNode generates a local test node without persisting data to local store and with default config value.
+// The identity is derived from a logged random seed so a failing run can be replayed with GenerateTestNodeWithSeed.
 func GenerateTestNode(t *testing.T) (*LocalNode, Node) {
-	return GenerateTestNodeWithConfig(t, config.DefaultConfig())
+	seed := time.Now().UnixNano()
+	t.Logf("generating test node from seed %v", seed)
+	return GenerateTestNodeWithSeed(t, seed)
+}
+
+// GenerateTestNodeWithSeed generates a local test node whose identity is derived from the given seed.
+// The same seed always yields the same node identity.
+func GenerateTestNodeWithSeed(t *testing.T, seed int64) (*LocalNode, Node) {
+
+	port, err := GetUnboundedPort()
+	if err != nil {
+		t.Error("Failed to get a port to bind", err)
+	}
+
+	address := fmt.Sprintf("0.0.0.0:%d", port)
+
+	rnd := rand.New(rand.NewSource(seed))
+	priv, pub, err := generateSeededKeyPair(rnd)
+	if err != nil {
+		t.Error(ErrFailedToCreate, err)
+	}
+
+	localNode, err := newLocalNodeWithKeys(pub, priv, address, config.DefaultConfig().NetworkID, false)
+	if err != nil {
+		t.Error(ErrFailedToCreate, err)
+	}
+
+	return localNode, New(localNode.pubKey, address)
 }
 
 // GenerateTestNodeWithConfig creates a local test node without persisting data to local store.
@@ -36,7 +64,7 @@ func GenerateTestNodeWithConfig(t *testing.T, config config.Config) (*LocalNode,
 		if err != nil {
 			t.Error(ErrFailedToCreate)
 		}
-		return localNode, Node{localNode.pubKey, address}
+		return localNode, New(localNode.pubKey, address)
 	}
 
 	localNode, err = NewNodeIdentity(config, address, false)
@@ -44,24 +72,49 @@ func GenerateTestNodeWithConfig(t *testing.T, config config.Config) (*LocalNode,
 		t.Error(ErrFailedToCreate, err)
 	}
 
-	return localNode, Node{localNode.pubKey, address}
+	return localNode, New(localNode.pubKey, address)
 }
 
-// GenerateRandomNodeData generates a remote random node data for testing.
-func GenerateRandomNodeData() Node {
-	rand.Seed(time.Now().UnixNano())
-	port := rand.Int31n(48127) + 1024
+// generateSeededKeyPair derives a key pair from the given RNG.
+func generateSeededKeyPair(rnd *rand.Rand) (crypto.PrivateKey, crypto.PublicKey, error) {
+	data := make([]byte, 32)
+	rnd.Read(data)
+
+	priv, err := crypto.NewPrivateKey(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, priv.GetPublicKey(), nil
+}
+
+// generateNodeDataFromRand generates a remote node data from the given RNG.
+func generateNodeDataFromRand(rnd *rand.Rand) Node {
+	port := rnd.Int31n(48127) + 1024
 
 	address := fmt.Sprintf("0.0.0.0:%d", port)
-	_, pub, _ := crypto.GenerateKeyPair()
-	return Node{pub, address}
+	_, pub, _ := generateSeededKeyPair(rnd)
+	return New(pub, address)
+}
+
+// GenerateRandomNodeData generates a remote random node data for testing.
+func GenerateRandomNodeData() Node {
+	return GenerateRandomNodesData(1)[0]
 }
 
 // GenerateRandomNodesData generates remote nodes data for testing.
 func GenerateRandomNodesData(n int) []Node {
+	return GenerateRandomNodesDataSeeded(n, time.Now().UnixNano())
+}
+
+// GenerateRandomNodesDataSeeded generates remote nodes data derived from the given seed.
+// The same seed always yields the same node set.
+func GenerateRandomNodesDataSeeded(n int, seed int64) []Node {
+	rnd := rand.New(rand.NewSource(seed))
+
 	res := make([]Node, n)
 	for i := 0; i < n; i++ {
-		res[i] = GenerateRandomNodeData()
+		res[i] = generateNodeDataFromRand(rnd)
 	}
 	return res
 }
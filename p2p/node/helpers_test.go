@@ -0,0 +1,32 @@
+package node
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGenerateRandomNodesDataSeeded(t *testing.T) {
+	first := GenerateRandomNodesDataSeeded(10, 42)
+	second := GenerateRandomNodesDataSeeded(10, 42)
+
+	// the same seed yields byte-identical node ids and addresses
+	for i := range first {
+		assert.Equal(t, first[i].PublicKey().Bytes(), second[i].PublicKey().Bytes(), "expected identical node ids")
+		assert.Equal(t, first[i].Address(), second[i].Address(), "expected identical addresses")
+	}
+
+	// a different seed yields a different node set
+	other := GenerateRandomNodesDataSeeded(10, 43)
+	assert.NotEqual(t, first[0].String(), other[0].String(), "expected distinct node ids")
+}
+
+func TestGenerateTestNodeWithSeed(t *testing.T) {
+	node1, _ := GenerateTestNodeWithSeed(t, 42)
+	node2, _ := GenerateTestNodeWithSeed(t, 42)
+
+	assert.Equal(t, node1.PublicKey().Bytes(), node2.PublicKey().Bytes(), "expected identical node ids")
+	assert.Equal(t, node1.PrivateKey().String(), node2.PrivateKey().String(), "expected identical private keys")
+
+	node3, _ := GenerateTestNodeWithSeed(t, 7)
+	assert.NotEqual(t, node1.String(), node3.String(), "expected distinct node ids")
+}
@@ -0,0 +1,112 @@
+package node
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+)
+
+// identityExportVersion is the current identity export format version. The
+// format is self-describing and independent of the on-disk node store layout,
+// so old backups keep importing after layout changes.
+const identityExportVersion = 1
+
+// ErrEmptyPassphrase is returned when an identity export is requested without
+// a passphrase. Exports always travel encrypted.
+var ErrEmptyPassphrase = errors.New("identity export requires a passphrase")
+
+// ErrIdentityExists is returned when an imported identity is already present
+// in the local store and no force flag was set.
+var ErrIdentityExists = errors.New("an identity with this id already exists in the local store")
+
+// ErrCorruptIdentityExport is returned when an identity export blob can't be
+// parsed or its key material doesn't match its node id.
+var ErrCorruptIdentityExport = errors.New("identity export data is corrupt")
+
+// identityExportData is the self-describing identity export blob - encrypted
+// key material plus the node metadata needed to recreate the node.
+type identityExportData struct {
+	Version   int              `json:"version"`
+	PubKey    string           `json:"pubKey"`
+	Crypto    *nodeCryptoData  `json:"crypto"`
+	KDParams  *crypto.KDParams `json:"kd"`
+	NetworkID int8             `json:"network"`
+}
+
+// ExportIdentity writes the node's identity to w as an encrypted,
+// self-describing blob for backup or migration to another machine.
+func (n *LocalNode) ExportIdentity(w io.Writer, passphrase string) error {
+
+	if passphrase == "" {
+		return ErrEmptyPassphrase
+	}
+
+	cryptoData, kdParams, err := encryptPrivKey(n.privKey, passphrase)
+	if err != nil {
+		return err
+	}
+
+	data := identityExportData{
+		Version:   identityExportVersion,
+		PubKey:    n.pubKey.String(),
+		Crypto:    cryptoData,
+		KDParams:  kdParams,
+		NetworkID: n.networkID,
+	}
+
+	blob, err := json.MarshalIndent(&data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(blob)
+	return err
+}
+
+// ImportIdentity reads an identity export blob from r, unlocks it with the
+// passphrase and installs it in the local node store. An identity already
+// present in the store is not overwritten unless force is set.
+func ImportIdentity(r io.Reader, passphrase string, address string, force bool) (*LocalNode, error) {
+
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var data identityExportData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, ErrCorruptIdentityExport
+	}
+
+	if data.Version > identityExportVersion {
+		return nil, fmt.Errorf("identity export version %d is newer than the supported version %d - upgrade the node software",
+			data.Version, identityExportVersion)
+	}
+
+	priv, err := privateKeyFromFileData(&nodeFileData{Crypto: data.Crypto, KDParams: data.KDParams}, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := crypto.NewPublicKeyFromString(data.PubKey)
+	if err != nil {
+		return nil, ErrCorruptIdentityExport
+	}
+
+	if !bytes.Equal(pub.Bytes(), priv.GetPublicKey().Bytes()) {
+		return nil, ErrCorruptIdentityExport
+	}
+
+	if !force {
+		if _, err := readNodeData(pub.String()); err == nil {
+			return nil, ErrIdentityExists
+		}
+	}
+
+	return newLocalNodeWithKeys(pub, priv, address, data.NetworkID, true)
+}
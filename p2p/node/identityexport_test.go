@@ -0,0 +1,59 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/spacemeshos/go-spacemesh/filesystem"
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityExportImport(t *testing.T) {
+	// start clean
+	filesystem.SetupTestSpacemeshDataFolders(t, "identity_export")
+
+	cfg := config.DefaultConfig()
+	address := "0.0.0.0:1234"
+
+	node, err := NewNodeIdentity(cfg, address, false)
+	assert.NoError(t, err, "failed to create new local node")
+
+	// exports always travel encrypted
+	var blob bytes.Buffer
+	err = node.ExportIdentity(&blob, "")
+	assert.Equal(t, ErrEmptyPassphrase, err, "expected an empty passphrase error")
+
+	err = node.ExportIdentity(&blob, "backup-pass")
+	assert.NoError(t, err, "failed to export identity")
+
+	// a wrong passphrase is rejected with a specific error
+	_, err = ImportIdentity(bytes.NewReader(blob.Bytes()), "wrong", address, false)
+	assert.Equal(t, ErrWrongPassphrase, err, "expected a wrong passphrase error")
+
+	// importing restores the same identity
+	restored, err := ImportIdentity(bytes.NewReader(blob.Bytes()), "backup-pass", address, false)
+	assert.NoError(t, err, "failed to import identity")
+	assert.Equal(t, node.String(), restored.String(), "expected the same node id")
+	assert.Equal(t, node.PrivateKey().String(), restored.PrivateKey().String(), "expected the same private key")
+	assert.Equal(t, node.NetworkID(), restored.NetworkID(), "expected the same network id")
+
+	// an identity already in the store is not overwritten without force
+	_, err = ImportIdentity(bytes.NewReader(blob.Bytes()), "backup-pass", address, false)
+	assert.Equal(t, ErrIdentityExists, err, "expected an existing identity error")
+
+	// force overwrites the stored identity
+	assert.NoError(t, restored.Shutdown(context.Background()), "failed to shut down restored node")
+	forced, err := ImportIdentity(bytes.NewReader(blob.Bytes()), "backup-pass", address, true)
+	assert.NoError(t, err, "failed to force import identity")
+	assert.Equal(t, node.String(), forced.String(), "expected the same node id")
+	assert.NoError(t, forced.Shutdown(context.Background()), "failed to shut down imported node")
+
+	// garbage blobs are rejected
+	_, err = ImportIdentity(bytes.NewReader([]byte("{ not json")), "backup-pass", address, false)
+	assert.Equal(t, ErrCorruptIdentityExport, err, "expected a corrupt export error")
+
+	// cleanup
+	filesystem.DeleteSpacemeshDataFolders(t)
+}
@@ -1,10 +1,19 @@
 package node
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
 	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/filesystem"
+	"github.com/spacemeshos/go-spacemesh/journal"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/spacemeshos/go-spacemesh/version"
 )
 
 // LocalNode implementation.
@@ -14,9 +23,59 @@ type LocalNode struct {
 
 	networkID int8
 
+	// advisory lock on the node's data directory, held by persisted nodes so
+	// two processes can't run with the same identity
+	dirLock filesystem.Unlocker
+
+	// services attached to this node, closed in reverse registration order on
+	// Shutdown
+	closers      []namedCloser
+	closersMutex sync.Mutex
+
+	// aggregated readiness of the node's subsystems
+	health *HealthManager
+
+	// persistent event journal in the node's data directory, nil when the
+	// node runs on an in-memory filesystem
+	journal *journal.Journal
+
 	log.Log
 }
 
+// namedCloser pairs a registered component with a name for error reporting.
+type namedCloser struct {
+	name   string
+	closer io.Closer
+}
+
+// RegisterCloser registers a component to be closed when the node shuts down.
+// Components are closed in reverse registration order, so a service should be
+// registered right after the services it depends on.
+func (n *LocalNode) RegisterCloser(name string, c io.Closer) {
+	n.closersMutex.Lock()
+	defer n.closersMutex.Unlock()
+	n.closers = append(n.closers, namedCloser{name, c})
+}
+
+// Health returns the node's health manager. Subsystems register readiness
+// probes on it, orchestration code reads the aggregated lifecycle state.
+func (n *LocalNode) Health() *HealthManager {
+	return n.health
+}
+
+// BuildInfo returns what this binary was built from and the features it
+// runs with, for the status endpoint and interop debugging.
+func (n *LocalNode) BuildInfo() version.BuildInfo {
+	return version.Info()
+}
+
+// Journal returns the node's event journal. It is nil when the node runs on
+// an in-memory filesystem - the journal package is nil-safe, so callers
+// record unconditionally.
+func (n *LocalNode) Journal() *journal.Journal {
+	return n.journal
+}
+
 // NetworkID returns the local node's network id (testnet/mainnet, etc..)
 func (n *LocalNode) NetworkID() int8 {
 	return n.networkID
@@ -27,10 +86,46 @@ func (n *LocalNode) PrivateKey() crypto.PrivateKey {
 	return n.privKey
 }
 
+// UpdateAddress records the address the node actually listens on. When an
+// ephemeral port is bound, the advertised address must carry the real port
+// and not the configured one.
+func (n *LocalNode) UpdateAddress(address string) {
+	n.Node = New(n.pubKey, address)
+}
+
+// MarshalJSON returns the node's public form plus its network id. Private key
+// material is explicitly redacted - local nodes end up in debug output and
+// their keys must never reach the logs.
+func (n *LocalNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		nodeJSON
+		NetworkID int8   `json:"networkID"`
+		PrivKey   string `json:"privKey"`
+	}{
+		nodeJSON: nodeJSON{
+			ID:      n.pubKey.String(),
+			Address: n.address,
+			Version: config.ClientVersion,
+		},
+		NetworkID: n.networkID,
+		PrivKey:   "[redacted]",
+	})
+}
+
 // NewLocalNode creates a local node with a provided ip address.
 // Attempts to set node node from persisted data in local store.
 // Creates a new node if none was loaded.
 func NewLocalNode(config config.Config, address string, persist bool) (*LocalNode, error) {
+	return NewLocalNodeWithPassphrase(config, address, persist, "")
+}
+
+// NewLocalNodeWithPassphrase creates a local node like NewLocalNode, unlocking
+// an encrypted identity file with the provided passphrase.
+func NewLocalNodeWithPassphrase(config config.Config, address string, persist bool, passphrase string) (*LocalNode, error) {
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 
 	if len(config.NodeID) > 0 {
 		// user provided node id/pubkey via the cli - attempt to start that node w persisted data
@@ -39,7 +134,7 @@ func NewLocalNode(config config.Config, address string, persist bool) (*LocalNod
 			return nil, err
 		}
 
-		return newLocalNodeFromFile(address, data, persist)
+		return newLocalNodeFromFile(address, data, persist, passphrase)
 	}
 
 	// look for persisted node data in the nodes directory
@@ -51,7 +146,7 @@ func NewLocalNode(config config.Config, address string, persist bool) (*LocalNod
 
 	if nodeData != nil {
 		// create node using persisted node data
-		return newLocalNodeFromFile(address, nodeData, persist)
+		return newLocalNodeFromFile(address, nodeData, persist, passphrase)
 	}
 
 	// generate new node
@@ -60,6 +155,9 @@ func NewLocalNode(config config.Config, address string, persist bool) (*LocalNod
 
 // NewNodeIdentity creates a new local node without attempting to restore node from local store.
 func NewNodeIdentity(config config.Config, address string, persist bool) (*LocalNode, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 	priv, pub, err := crypto.GenerateKeyPair()
 	if err != nil {
 		return nil, err
@@ -70,12 +168,10 @@ func NewNodeIdentity(config config.Config, address string, persist bool) (*Local
 func newLocalNodeWithKeys(pubKey crypto.PublicKey, privKey crypto.PrivateKey, address string, networkID int8, persist bool) (*LocalNode, error) {
 
 	n := &LocalNode{
-		Node: Node{
-			pubKey:  pubKey,
-			address: address,
-		},
+		Node:      New(pubKey, address),
 		networkID: networkID,
 		privKey:   privKey,
+		health:    NewHealthManager(DefaultProbeTimeout),
 	}
 
 	dataDir, err := filesystem.EnsureNodesDataDirectory(config.NodesDirectoryName)
@@ -88,14 +184,48 @@ func newLocalNodeWithKeys(pubKey crypto.PublicKey, privKey crypto.PrivateKey, ad
 		return nil, err
 	}
 
-	// setup logging
-	n.Log = log.New(n.pubKey.Pretty(), nodeDir, "node.log")
+	// setup logging - file logs can only go to the real filesystem, so a
+	// test running on an in-memory one keeps console logging only
+	logDir, logFile := nodeDir, "node.log"
+	if filesystem.Active != filesystem.OS {
+		logDir, logFile = "", ""
+	}
+	n.Log = log.New(n.pubKey.Pretty(), logDir, logFile)
+
+	// the journal, like file logs, only goes to the real filesystem
+	if filesystem.Active == filesystem.OS {
+		n.journal, err = journal.Open(nodeDir, journal.DefaultConfig())
+		if err != nil {
+			// a node without history is still a working node
+			n.Error("failed to open the node event journal", err)
+		}
+	}
+
+	// mirror health transitions into the journal so lifecycle history
+	// survives restarts
+	healthc := n.health.Subscribe()
+	go func() {
+		for change := range healthc {
+			n.journal.Note(journal.EventHealthChanged, "", fmt.Sprintf("%v -> %v: %v", change.From, change.To, change.Reason))
+			if change.To == Stopping {
+				return
+			}
+		}
+	}()
 
 	n.Info("Local node identity >> %v", n.String())
 
 	if persist {
+		// a persisted node owns its on-disk identity - lock the data dir so a
+		// second process can't come up with the same key
+		n.dirLock, err = filesystem.Active.Lock(nodeDir)
+		if err != nil {
+			n.Error("failed to lock node data directory", err)
+			return nil, err
+		}
+
 		// persist store data so we can start it on future app sessions
-		err = n.persistData()
+		err = n.persistData("")
 		if err != nil { // no much use of starting if we can't store node private key in store
 			n.Error("failed to persist node data to local store", err)
 			return nil, err
@@ -105,10 +235,64 @@ func newLocalNodeWithKeys(pubKey crypto.PublicKey, privKey crypto.PrivateKey, ad
 	return n, nil
 }
 
+// Shutdown tears down the node - the health state moves to Stopping so
+// orchestrators see the shutdown, then registered components are closed in reverse
+// registration order, then the advisory lock on the data directory is
+// released. A component that doesn't finish before the context deadline is
+// abandoned and reported, it doesn't hold up the rest of the shutdown. All
+// failures are aggregated into the returned error.
+func (n *LocalNode) Shutdown(ctx context.Context) error {
+
+	n.health.Stop()
+
+	n.closersMutex.Lock()
+	closers := n.closers
+	n.closers = nil
+	n.closersMutex.Unlock()
+
+	var failures []string
+
+	for i := len(closers) - 1; i >= 0; i-- {
+		c := closers[i]
+
+		// close in a goroutine so a stuck component can't outlive the deadline
+		done := make(chan error, 1)
+		go func() { done <- c.closer.Close() }()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%v: %v", c.name, err))
+			}
+		case <-ctx.Done():
+			failures = append(failures, fmt.Sprintf("%v: %v", c.name, ctx.Err()))
+		}
+	}
+
+	// closed last so component teardown events still make it to disk
+	if err := n.journal.Close(); err != nil {
+		failures = append(failures, fmt.Sprintf("event journal: %v", err))
+	}
+	n.journal = nil
+
+	if n.dirLock != nil {
+		if err := n.dirLock.Unlock(); err != nil {
+			failures = append(failures, fmt.Sprintf("data directory lock: %v", err))
+		}
+		n.dirLock = nil
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("node %v shutdown completed with errors: %v", n.String(), strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
 // Creates a new node from persisted NodeData.
-func newLocalNodeFromFile(address string, d *nodeFileData, persist bool) (*LocalNode, error) {
+func newLocalNodeFromFile(address string, d *nodeFileData, persist bool, passphrase string) (*LocalNode, error) {
 
-	priv, err := crypto.NewPrivateKeyFromString(d.PrivKey)
+	priv, err := privateKeyFromFileData(d, passphrase)
 	if err != nil {
 		return nil, err
 	}
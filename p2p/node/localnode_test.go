@@ -0,0 +1,104 @@
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCloser records when it was closed and can fail or stall on demand.
+type fakeCloser struct {
+	name  string
+	order *[]string
+	err   error
+	delay time.Duration
+}
+
+func (f *fakeCloser) Close() error {
+	if f.delay > 0 {
+		time.Sleep(f.delay)
+	}
+	*f.order = append(*f.order, f.name)
+	return f.err
+}
+
+func TestLocalNodeMarshalRedactsPrivateKey(t *testing.T) {
+	ln, _ := GenerateTestNode(t)
+
+	data, err := json.Marshal(ln)
+	assert.NoError(t, err, "failed to marshal local node")
+
+	// the public identity is present, the private key is explicitly redacted
+	assert.Contains(t, string(data), ln.String(), "expected the node id")
+	assert.Contains(t, string(data), `"privKey":"[redacted]"`, "expected a redaction marker")
+
+	// no form of the private key appears in marshaled or stringified output
+	for name, s := range map[string]string{
+		"json":   string(data),
+		"string": ln.String(),
+		"pretty": ln.Pretty(),
+	} {
+		assert.NotContains(t, s, ln.PrivateKey().String(), "%v output leaks the private key", name)
+		assert.NotContains(t, s, hex.EncodeToString(ln.PrivateKey().Bytes()), "%v output leaks the private key", name)
+	}
+}
+
+func TestLocalNodeShutdownOrder(t *testing.T) {
+	ln, _ := GenerateTestNode(t)
+
+	var order []string
+	ln.RegisterCloser("first", &fakeCloser{name: "first", order: &order})
+	ln.RegisterCloser("second", &fakeCloser{name: "second", order: &order})
+	ln.RegisterCloser("third", &fakeCloser{name: "third", order: &order})
+
+	err := ln.Shutdown(context.Background())
+	assert.NoError(t, err, "expected a clean shutdown")
+
+	// components close in reverse registration order
+	assert.Equal(t, []string{"third", "second", "first"}, order, "unexpected shutdown order")
+
+	// a second shutdown has nothing left to close
+	order = order[:0]
+	err = ln.Shutdown(context.Background())
+	assert.NoError(t, err, "expected an idempotent shutdown")
+	assert.Empty(t, order, "expected no closers to run twice")
+}
+
+func TestLocalNodeShutdownErrors(t *testing.T) {
+	ln, _ := GenerateTestNode(t)
+
+	var order []string
+	ln.RegisterCloser("dht", &fakeCloser{name: "dht", order: &order, err: errors.New("boom")})
+	ln.RegisterCloser("gossip", &fakeCloser{name: "gossip", order: &order})
+
+	err := ln.Shutdown(context.Background())
+	assert.Error(t, err, "expected an aggregated shutdown error")
+	assert.Contains(t, err.Error(), "dht: boom", "expected the failing component in the error")
+
+	// the failure didn't stop the other component from closing
+	assert.Equal(t, []string{"gossip", "dht"}, order, "expected all closers to run")
+}
+
+func TestLocalNodeShutdownDeadline(t *testing.T) {
+	ln, _ := GenerateTestNode(t)
+
+	var order []string
+	ln.RegisterCloser("fast", &fakeCloser{name: "fast", order: &order})
+	ln.RegisterCloser("slow", &fakeCloser{name: "slow", order: &order, delay: 5 * time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := ln.Shutdown(ctx)
+	assert.True(t, time.Since(start) < time.Second, "shutdown did not respect the deadline")
+
+	// the stuck component is reported, the rest are still reached
+	assert.Error(t, err, "expected a deadline error")
+	assert.Contains(t, err.Error(), "slow: context deadline exceeded", "expected the stuck component in the error")
+}
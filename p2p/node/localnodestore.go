@@ -1,67 +1,222 @@
 package node
 
 import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"os"
 	"strings"
 
-	"bytes"
-	"io"
-
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/filesystem"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/config"
 )
 
+// ErrNodeDataNotFound is returned when there is no persisted data file for the
+// requested node id.
+var ErrNodeDataNotFound = errors.New("node data file not found")
+
+// ErrCorruptNodeData is returned when a persisted node data file exists but
+// can't be parsed.
+var ErrCorruptNodeData = errors.New("node data file is corrupt")
+
+// ErrWrongPassphrase is returned when an encrypted node data file can't be
+// unlocked with the provided passphrase.
+var ErrWrongPassphrase = errors.New("cannot unlock node identity with the provided passphrase")
+
+// nodeFileDataVersion is the current node data file format version. Older
+// files are upgraded through the migration chain when loaded, legacy files
+// carrying no version count as version 0.
+const nodeFileDataVersion = 1
+
+// nodeCryptoData holds the encrypted private key of a locked id file.
+type nodeCryptoData struct {
+	Cipher     string `json:"cipher"`
+	CipherText string `json:"cipherText"` // hex encoded
+	CipherIv   string `json:"cipherIv"`   // hex encoded
+	Mac        string `json:"mac"`        // hex encoded
+}
+
 // nodeFileData defines persistent node data.
 type nodeFileData struct {
-	PubKey     string `json:"pubKey"`
-	PrivKey    string `json:"priKey"`
-	CoinBaseID string `json:"coinbase"` // coinbase account id
-	NetworkID  int8   `json:"network"`  // network that the node lives in
+	Version    int              `json:"version,omitempty"`
+	PubKey     string           `json:"pubKey"`
+	PrivKey    string           `json:"priKey,omitempty"`
+	Crypto     *nodeCryptoData  `json:"crypto,omitempty"`
+	KDParams   *crypto.KDParams `json:"kd,omitempty"`
+	CoinBaseID string           `json:"coinbase"` // coinbase account id
+	NetworkID  int8             `json:"network"`  // network that the node lives in
+}
+
+// nodeFileMigration upgrades node file data by one format version.
+type nodeFileMigration func(d *nodeFileData) error
+
+// nodeFileMigrations is the migration chain - entry i upgrades a file of
+// version i to version i+1. Migrations are applied in order on load.
+var nodeFileMigrations = []nodeFileMigration{
+	migrateNodeFileV0,
+}
+
+// migrateNodeFileV0 upgrades a legacy unversioned file. The data layout is
+// unchanged, the file just gains a version field.
+func migrateNodeFileV0(d *nodeFileData) error {
+	if d.PrivKey == "" && d.Crypto == nil {
+		return ErrCorruptNodeData
+	}
+	return nil
+}
+
+// migrateNodeData upgrades node data to the current format version and
+// rewrites the file, keeping the pre-migration file as a backup.
+func migrateNodeData(path string, raw []byte, d *nodeFileData) error {
+
+	if err := filesystem.Active.WriteFileAtomic(path+".bak", raw, filesystem.OwnerReadWrite); err != nil {
+		return err
+	}
+
+	for v := d.Version; v < nodeFileDataVersion; v++ {
+		if err := nodeFileMigrations[v](d); err != nil {
+			return err
+		}
+		d.Version = v + 1
+	}
+
+	log.Info("Migrated node data file %s to version %d", path, d.Version)
+
+	return writeNodeData(path, d)
 }
 
 // Node store - local node data persistence functionality
 
-// Persist node's data to local store.
-func (n *LocalNode) persistData() error {
+// encryptPrivKey locks a private key with a passphrase-derived key, following
+// the same scheme the accounts store uses.
+func encryptPrivKey(privKey crypto.PrivateKey, passphrase string) (*nodeCryptoData, *crypto.KDParams, error) {
 
-	data := nodeFileData{
-		PubKey:    n.pubKey.String(),
-		PrivKey:   n.privKey.String(),
-		NetworkID: n.networkID,
+	kdfParams := crypto.DefaultCypherParams
+
+	saltData, err := crypto.GetRandomBytes(kdfParams.SaltLen)
+	if err != nil {
+		return nil, nil, errors.New("failed to generate random salt")
 	}
+	kdfParams.Salt = hex.EncodeToString(saltData)
 
-	bytes, err := json.MarshalIndent(data, "", "  ")
+	dk, err := crypto.DeriveKeyFromPassword(passphrase, kdfParams)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	nodeDataPath, err := filesystem.EnsureNodesDataDirectory(config.NodesDirectoryName)
+	nonce, err := crypto.GetRandomBytes(aes.BlockSize)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	path := filesystem.NodeDataFile(nodeDataPath, config.NodeDataFileName, n.String())
+	// extract 16 bytes aes-128-ctr key from the derived key
+	cipherText, err := crypto.AesCTRXOR(dk[:16], privKey.Bytes(), nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// use last 16 bytes from derived key and cipher text to create a mac
+	mac := crypto.Sha256(dk[16:32], cipherText)
+
+	cryptoData := &nodeCryptoData{
+		Cipher:     "AES-128-CTR", // 16 bytes key
+		CipherText: hex.EncodeToString(cipherText),
+		CipherIv:   hex.EncodeToString(nonce),
+		Mac:        hex.EncodeToString(mac),
+	}
+
+	return cryptoData, &kdfParams, nil
+}
+
+// privateKeyFromFileData extracts the private key from persisted node data,
+// unlocking encrypted files with the provided passphrase.
+func privateKeyFromFileData(d *nodeFileData, passphrase string) (crypto.PrivateKey, error) {
+
+	if d.Crypto == nil {
+		// legacy plaintext id file
+		return crypto.NewPrivateKeyFromString(d.PrivKey)
+	}
+
+	if d.KDParams == nil {
+		return nil, ErrCorruptNodeData
+	}
 
-	// make sure our node file is written to the os filesystem.
-	f, err := os.Create(path)
+	dk, err := crypto.DeriveKeyFromPassword(passphrase, *d.KDParams)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = f.Write(bytes)
+	cipherText, err := hex.DecodeString(d.Crypto.CipherText)
 	if err != nil {
-		return err
+		return nil, ErrCorruptNodeData
+	}
+
+	nonce, err := hex.DecodeString(d.Crypto.CipherIv)
+	if err != nil {
+		return nil, ErrCorruptNodeData
 	}
 
-	err = f.Sync()
+	mac, err := hex.DecodeString(d.Crypto.Mac)
+	if err != nil {
+		return nil, ErrCorruptNodeData
+	}
+
+	// authenticate cipherText using mac
+	expectedMac := crypto.Sha256(dk[16:32], cipherText)
+
+	if subtle.ConstantTimeCompare(mac, expectedMac) != 1 {
+		return nil, ErrWrongPassphrase
+	}
+
+	privKeyData, err := crypto.AesCTRXOR(dk[:16], cipherText, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return crypto.NewPrivateKey(privKeyData)
+}
+
+// PersistData persists the node's identity to the local store, encrypting the
+// private key with the given passphrase. An empty passphrase stores the key
+// in plaintext.
+func (n *LocalNode) PersistData(passphrase string) error {
+	return n.persistData(passphrase)
+}
+
+// Persist node's data to local store.
+func (n *LocalNode) persistData(passphrase string) error {
+
+	data := nodeFileData{
+		Version:   nodeFileDataVersion,
+		PubKey:    n.pubKey.String(),
+		NetworkID: n.networkID,
+	}
+
+	if passphrase == "" {
+		data.PrivKey = n.privKey.String()
+	} else {
+		cryptoData, kdParams, err := encryptPrivKey(n.privKey, passphrase)
+		if err != nil {
+			return err
+		}
+
+		data.Crypto = cryptoData
+		data.KDParams = kdParams
+	}
+
+	nodeDataPath, err := filesystem.EnsureNodesDataDirectory(config.NodesDirectoryName)
 	if err != nil {
 		return err
 	}
 
-	err = f.Close()
+	path := filesystem.NodeDataFile(nodeDataPath, config.NodeDataFileName, n.String())
+
+	err = writeNodeData(path, &data)
 	if err != nil {
 		return err
 	}
@@ -71,6 +226,20 @@ func (n *LocalNode) persistData() error {
 	return nil
 }
 
+// writeNodeData atomically writes node data to path with owner-only
+// permissions. The file holds the node's private key, so the atomic write
+// matters - an interrupted write can't leave a partial or world-readable id
+// file behind.
+func writeNodeData(path string, data *nodeFileData) error {
+
+	bytes, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return filesystem.Active.WriteFileAtomic(path, bytes, filesystem.OwnerReadWrite)
+}
+
 // Read node persisted data based on node id.
 func readNodeData(nodeID string) (*nodeFileData, error) {
 
@@ -81,29 +250,30 @@ func readNodeData(nodeID string) (*nodeFileData, error) {
 
 	path := filesystem.NodeDataFile(nodeDataPath, config.NodeDataFileName, nodeID)
 
-	data := bytes.NewBuffer(nil)
-
-	f, err := os.Open(path)
+	raw, err := filesystem.Active.ReadFile(path)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNodeDataNotFound
+		}
 		return nil, err
 	}
 
-	_, err = io.Copy(data, f)
-
+	var nodeData nodeFileData
+	err = json.Unmarshal(raw, &nodeData)
 	if err != nil {
-		return nil, err
+		return nil, ErrCorruptNodeData
 	}
 
-	err = f.Close()
-
-	if err != nil {
-		return nil, err
+	if nodeData.Version > nodeFileDataVersion {
+		return nil, fmt.Errorf("node data file version %d is newer than the supported version %d - upgrade the node software",
+			nodeData.Version, nodeFileDataVersion)
 	}
 
-	var nodeData nodeFileData
-	err = json.Unmarshal(data.Bytes(), &nodeData)
-	if err != nil {
-		return nil, err
+	if nodeData.Version < nodeFileDataVersion {
+		err = migrateNodeData(path, raw, &nodeData)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	log.Debug("loaded persisted node data for node id: %s", nodeID)
@@ -120,18 +290,16 @@ func readFirstNodeData() (*nodeFileData, error) {
 		return nil, err
 	}
 
-	files, err := ioutil.ReadDir(nodeDataPath)
+	names, err := filesystem.Active.ReadDirNames(nodeDataPath)
 	if err != nil {
 		return nil, err
 	}
 
-	// only consider json files
-	for _, f := range files {
-		n := f.Name()
-		// make sure we get only a real node file
-		if f.IsDir() && !strings.HasPrefix(n, ".") {
+	for _, n := range names {
+		// make sure we get only a real node folder
+		if !strings.HasPrefix(n, ".") {
 			p := filesystem.NodeDataFile(nodeDataPath, config.NodeDataFileName, n)
-			if filesystem.PathExists(p) {
+			if filesystem.Active.Exists(p) {
 				return readNodeData(n)
 			}
 		}
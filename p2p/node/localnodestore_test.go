@@ -2,21 +2,24 @@ package node
 
 import (
 	"fmt"
+	"os"
+	"testing"
+
 	"github.com/spacemeshos/go-spacemesh/filesystem"
 	"github.com/spacemeshos/go-spacemesh/p2p/config"
 	"github.com/stretchr/testify/assert"
-	"testing"
 )
 
 func TestNodeLocalStore(t *testing.T) {
-	// start clean
-	filesystem.SetupTestSpacemeshDataFolders(t, "localnode_store")
+	// the whole test runs on an isolated in-memory filesystem
+	mem := filesystem.UseMemoryFS(t)
 
 	p, err := filesystem.EnsureNodesDataDirectory(config.NodesDirectoryName)
 	assert.NoError(t, err, "failed to create or verify nodes data dir")
 
-	err = filesystem.TestEmptyFolder(p)
-	assert.NoError(t, err, "There should be no files in the node folder now")
+	names, err := mem.ReadDirNames(p)
+	assert.NoError(t, err, "failed to list the nodes data dir")
+	assert.Empty(t, names, "There should be no files in the node folder now")
 
 	port1, err := GetUnboundedPort()
 	assert.NoError(t, err, "Should be able to establish a connection on a port")
@@ -28,7 +31,7 @@ func TestNodeLocalStore(t *testing.T) {
 	node, err := NewNodeIdentity(cfg, address, false)
 	assert.NoError(t, err, "failed to create new local node")
 
-	err = node.persistData()
+	err = node.persistData("")
 	assert.NoError(t, err, "failed to persist node data")
 
 	_, err = filesystem.EnsureNodeDataDirectory(p, node.String())
@@ -36,8 +39,7 @@ func TestNodeLocalStore(t *testing.T) {
 	assert.NoError(t, err, "could'nt get node path")
 
 	file := filesystem.NodeDataFile(p, config.NodeDataFileName, node.String())
-	fmt.Println(file)
-	exists := filesystem.PathExists(file)
+	exists := mem.Exists(file)
 
 	assert.True(t, exists, "File should exist")
 
@@ -47,7 +49,7 @@ func TestNodeLocalStore(t *testing.T) {
 	assert.Equal(t, data.PubKey, node.String(), "expected same node id")
 	assert.Equal(t, data.NetworkID, cfg.NetworkID, "Expected same network id")
 
-	// as we deleted all dirs - first node data in nodes folder should be this  node's data
+	// as we started from an empty fs - first node data in nodes folder should be this node's data
 	data1, err := readFirstNodeData()
 	assert.NoError(t, err, "failed to ensure node data directory")
 	assert.NotNil(t, data1, "expected node data")
@@ -59,8 +61,127 @@ func TestNodeLocalStore(t *testing.T) {
 	assert.NoError(t, err, "local node creation error")
 	assert.Equal(t, node.String(), node1.String(), "expected restored node")
 	assert.Equal(t, node.NetworkID(), cfg.NetworkID, "Expected same network id")
+}
+
+func TestNodeDataErrors(t *testing.T) {
+	mem := filesystem.UseMemoryFS(t)
+
+	p, err := filesystem.EnsureNodesDataDirectory(config.NodesDirectoryName)
+	assert.NoError(t, err, "failed to create or verify nodes data dir")
+
+	// loading an unknown node id
+	_, err = readNodeData("unknown-node-id")
+	assert.Equal(t, ErrNodeDataNotFound, err, "expected a not found error")
+
+	node, err := NewNodeIdentity(config.DefaultConfig(), "0.0.0.0:1234", false)
+	assert.NoError(t, err, "failed to create new local node")
+
+	err = node.persistData("")
+	assert.NoError(t, err, "failed to persist node data")
+
+	// a complete file with owner-only permissions and no temp leftovers
+	file := filesystem.NodeDataFile(p, config.NodeDataFileName, node.String())
+	assert.True(t, mem.Exists(file), "expected node data file")
+	assert.False(t, mem.Exists(file+".tmp"), "temp file was left behind")
+	assert.Equal(t, os.FileMode(0600), mem.Perm(file), "expected owner-only permissions")
+
+	// persisted data loads back to the same identity
+	data, err := readNodeData(node.String())
+	assert.NoError(t, err, "failed to read node data")
+	assert.Equal(t, node.String(), data.PubKey, "expected same node id")
+
+	// corrupt data is rejected with a distinct error
+	err = mem.WriteFileAtomic(file, []byte("{ not json"), 0600)
+	assert.NoError(t, err, "failed to corrupt node data file")
+	_, err = readNodeData(node.String())
+	assert.Equal(t, ErrCorruptNodeData, err, "expected a corrupt data error")
+}
+
+func TestNodeDataMigration(t *testing.T) {
+	mem := filesystem.UseMemoryFS(t)
+
+	p, err := filesystem.EnsureNodesDataDirectory(config.NodesDirectoryName)
+	assert.NoError(t, err, "failed to create or verify nodes data dir")
 
-	// cleanup
-	filesystem.DeleteSpacemeshDataFolders(t)
+	cfg := config.DefaultConfig()
+	address := "0.0.0.0:1234"
+
+	node, err := NewNodeIdentity(cfg, address, false)
+	assert.NoError(t, err, "failed to create new local node")
+
+	_, err = filesystem.EnsureNodeDataDirectory(p, node.String())
+	assert.NoError(t, err, "failed to create node dir")
+
+	file := filesystem.NodeDataFile(p, config.NodeDataFileName, node.String())
+
+	// fabricate a legacy v0 file - plaintext data with no version field
+	v0 := fmt.Sprintf(`{"pubKey":%q,"priKey":%q,"coinbase":"","network":0}`, node.String(), node.PrivateKey().String())
+	err = mem.WriteFileAtomic(file, []byte(v0), 0600)
+	assert.NoError(t, err, "failed to write v0 fixture")
+
+	// loading walks the migration chain and rewrites the file
+	data, err := readNodeData(node.String())
+	assert.NoError(t, err, "failed to load v0 node data")
+	assert.Equal(t, nodeFileDataVersion, data.Version, "expected migrated version")
+	assert.Equal(t, node.String(), data.PubKey, "expected same node id")
+
+	// the pre-migration file is backed up
+	assert.True(t, mem.Exists(file+".bak"), "expected a backup of the v0 file")
+
+	// the rewritten file is already in the current format
+	rewritten, err := readNodeData(node.String())
+	assert.NoError(t, err, "failed to re-read migrated node data")
+	assert.Equal(t, nodeFileDataVersion, rewritten.Version, "expected current version on disk")
+
+	// a current version file loads as is
+	restored, err := NewLocalNode(cfg, address, false)
+	assert.NoError(t, err, "failed to load migrated node data")
+	assert.Equal(t, node.String(), restored.String(), "expected restored node")
+
+	// files from a future version are refused
+	future := fmt.Sprintf(`{"version":99,"pubKey":%q,"priKey":%q}`, node.String(), node.PrivateKey().String())
+	err = mem.WriteFileAtomic(file, []byte(future), 0600)
+	assert.NoError(t, err, "failed to write future fixture")
+	_, err = readNodeData(node.String())
+	assert.Error(t, err, "expected a version error")
+	assert.Contains(t, err.Error(), "newer than the supported version", "expected a clear version message")
+}
+
+func TestNodeEncryptedStore(t *testing.T) {
+	filesystem.UseMemoryFS(t)
+
+	_, err := filesystem.EnsureNodesDataDirectory(config.NodesDirectoryName)
+	assert.NoError(t, err, "failed to create or verify nodes data dir")
+
+	cfg := config.DefaultConfig()
+	address := "0.0.0.0:1234"
 
+	node, err := NewNodeIdentity(cfg, address, false)
+	assert.NoError(t, err, "failed to create new local node")
+
+	err = node.persistData("s3cret")
+	assert.NoError(t, err, "failed to persist encrypted node data")
+
+	// the private key is not stored in plaintext
+	data, err := readNodeData(node.String())
+	assert.NoError(t, err, "failed to read node data")
+	assert.Empty(t, data.PrivKey, "expected no plaintext private key")
+	assert.NotNil(t, data.Crypto, "expected encrypted key data")
+	assert.Equal(t, nodeFileDataVersion, data.Version, "expected versioned file format")
+
+	// the encrypted identity loads back with the right passphrase
+	restored, err := NewLocalNodeWithPassphrase(cfg, address, false, "s3cret")
+	assert.NoError(t, err, "failed to load encrypted node data")
+	assert.Equal(t, node.String(), restored.String(), "expected restored node")
+
+	// a wrong passphrase is rejected with a specific error
+	_, err = NewLocalNodeWithPassphrase(cfg, address, false, "wrong")
+	assert.Equal(t, ErrWrongPassphrase, err, "expected a wrong passphrase error")
+
+	// a legacy plaintext file loads with no passphrase
+	err = node.persistData("")
+	assert.NoError(t, err, "failed to persist plaintext node data")
+	legacy, err := NewLocalNode(cfg, address, false)
+	assert.NoError(t, err, "failed to load plaintext node data")
+	assert.Equal(t, node.String(), legacy.String(), "expected restored node")
 }
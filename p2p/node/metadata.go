@@ -0,0 +1,147 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+
+	"github.com/spacemeshos/go-spacemesh/filesystem"
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+)
+
+// metadataFileName is the name of the metadata store file in the node's data directory.
+const metadataFileName = "metadata.json"
+
+// MaxMetadataValueSize is the maximum size of a single metadata value. The
+// store is for small node-scoped facts - anything bigger belongs in its own
+// file or database.
+const MaxMetadataValueSize = 64 << 10
+
+// ErrMetadataNotFound is returned when no value is stored under the requested key.
+var ErrMetadataNotFound = errors.New("no metadata stored under this key")
+
+// ErrInvalidMetadataKey is returned when a metadata key is empty.
+var ErrInvalidMetadataKey = errors.New("metadata key must not be empty")
+
+// ErrMetadataValueTooLarge is returned when a metadata value exceeds MaxMetadataValueSize.
+var ErrMetadataValueTooLarge = errors.New("metadata value exceeds the size limit")
+
+// metadataMutex serializes access to the metadata files. It is package-level
+// because two LocalNode instances of the same identity share one store.
+var metadataMutex sync.Mutex
+
+// Store persists a small node-scoped value in the node's data directory.
+// Keys must be namespaced per subsystem with a path-like prefix, e.g.
+// "dht/last-bootstrap", so subsystems can't collide. Writes are atomic -
+// a crash can't leave a torn store behind.
+func (n *LocalNode) Store(key string, value []byte) error {
+
+	if key == "" {
+		return ErrInvalidMetadataKey
+	}
+
+	if len(value) > MaxMetadataValueSize {
+		return ErrMetadataValueTooLarge
+	}
+
+	metadataMutex.Lock()
+	defer metadataMutex.Unlock()
+
+	path, err := n.metadataPath()
+	if err != nil {
+		return err
+	}
+
+	values, err := readMetadata(path)
+	if err != nil {
+		return err
+	}
+
+	values[key] = hex.EncodeToString(value)
+
+	return writeMetadata(path, values)
+}
+
+// Retrieve returns the value stored under key, or ErrMetadataNotFound when
+// nothing was stored under it.
+func (n *LocalNode) Retrieve(key string) ([]byte, error) {
+
+	if key == "" {
+		return nil, ErrInvalidMetadataKey
+	}
+
+	metadataMutex.Lock()
+	defer metadataMutex.Unlock()
+
+	path, err := n.metadataPath()
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := readMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, ok := values[key]
+	if !ok {
+		return nil, ErrMetadataNotFound
+	}
+
+	value, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrCorruptNodeData
+	}
+
+	return value, nil
+}
+
+// metadataPath returns the path to this node's metadata store file.
+func (n *LocalNode) metadataPath() (string, error) {
+
+	dataDir, err := filesystem.EnsureNodesDataDirectory(config.NodesDirectoryName)
+	if err != nil {
+		return "", err
+	}
+
+	nodeDir, err := filesystem.EnsureNodeDataDirectory(dataDir, n.String())
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(nodeDir, metadataFileName), nil
+}
+
+// readMetadata loads the metadata map from path. A missing file is an empty store.
+func readMetadata(path string) (map[string]string, error) {
+
+	values := map[string]string{}
+
+	if !filesystem.Active.Exists(path) {
+		return values, nil
+	}
+
+	raw, err := filesystem.Active.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(raw, &values); err != nil {
+		return nil, ErrCorruptNodeData
+	}
+
+	return values, nil
+}
+
+// writeMetadata atomically writes the metadata map to path, like the id file.
+func writeMetadata(path string, values map[string]string) error {
+
+	raw, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return filesystem.Active.WriteFileAtomic(path, raw, filesystem.OwnerReadWrite)
+}
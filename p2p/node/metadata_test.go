@@ -0,0 +1,100 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/spacemeshos/go-spacemesh/filesystem"
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeMetadataStore(t *testing.T) {
+	// start clean
+	filesystem.SetupTestSpacemeshDataFolders(t, "metadata_store")
+
+	cfg := config.DefaultConfig()
+	address := "0.0.0.0:1234"
+
+	node, err := NewNodeIdentity(cfg, address, false)
+	assert.NoError(t, err, "failed to create new local node")
+
+	// round trip
+	err = node.Store("dht/last-bootstrap", []byte("some-timestamp"))
+	assert.NoError(t, err, "failed to store metadata")
+
+	value, err := node.Retrieve("dht/last-bootstrap")
+	assert.NoError(t, err, "failed to retrieve metadata")
+	assert.Equal(t, []byte("some-timestamp"), value, "expected the stored value")
+
+	// overwrites are visible
+	err = node.Store("dht/last-bootstrap", []byte("newer"))
+	assert.NoError(t, err, "failed to overwrite metadata")
+	value, err = node.Retrieve("dht/last-bootstrap")
+	assert.NoError(t, err, "failed to retrieve metadata")
+	assert.Equal(t, []byte("newer"), value, "expected the overwritten value")
+
+	// missing keys and invalid input are rejected with typed errors
+	_, err = node.Retrieve("swarm/unknown")
+	assert.Equal(t, ErrMetadataNotFound, err, "expected a not found error")
+
+	err = node.Store("", []byte("x"))
+	assert.Equal(t, ErrInvalidMetadataKey, err, "expected an invalid key error")
+
+	err = node.Store("big", make([]byte, MaxMetadataValueSize+1))
+	assert.Equal(t, ErrMetadataValueTooLarge, err, "expected a size limit error")
+
+	// values survive a node reload
+	err = node.persistData("")
+	assert.NoError(t, err, "failed to persist node data")
+
+	restored, err := NewLocalNode(cfg, address, false)
+	assert.NoError(t, err, "failed to reload node")
+	assert.Equal(t, node.String(), restored.String(), "expected the same node")
+
+	value, err = restored.Retrieve("dht/last-bootstrap")
+	assert.NoError(t, err, "failed to retrieve metadata after reload")
+	assert.Equal(t, []byte("newer"), value, "expected the stored value after reload")
+
+	// cleanup
+	filesystem.DeleteSpacemeshDataFolders(t)
+}
+
+func TestNodeMetadataConcurrency(t *testing.T) {
+	// start clean
+	filesystem.SetupTestSpacemeshDataFolders(t, "metadata_concurrency")
+
+	node, err := NewNodeIdentity(config.DefaultConfig(), "0.0.0.0:1234", false)
+	assert.NoError(t, err, "failed to create new local node")
+
+	const writers = 8
+	const rounds = 10
+
+	var wg sync.WaitGroup
+	wg.Add(writers)
+
+	for w := 0; w < writers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			key := fmt.Sprintf("test/writer-%d", w)
+			for i := 0; i < rounds; i++ {
+				if err := node.Store(key, []byte{byte(i)}); err != nil {
+					t.Errorf("failed to store metadata: %v", err)
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+
+	// every writer's last value is visible
+	for w := 0; w < writers; w++ {
+		value, err := node.Retrieve(fmt.Sprintf("test/writer-%d", w))
+		assert.NoError(t, err, "failed to retrieve metadata")
+		assert.Equal(t, []byte{byte(rounds - 1)}, value, "expected the writer's last value")
+	}
+
+	// cleanup
+	filesystem.DeleteSpacemeshDataFolders(t)
+}
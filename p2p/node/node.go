@@ -1,20 +1,56 @@
 package node
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/btcsuite/btcutil/base58"
 	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
 	"strings"
 )
 
+func init() {
+	// the config package validates bootstrap node strings but can't import
+	// this package without a cycle, so the parser is registered from here
+	config.NodeStringParser = func(data string) error {
+		_, err := NewNodeFromString(data)
+		return err
+	}
+}
+
 // Node is the basic node identity struct
 type Node struct {
 	pubKey  crypto.PublicKey
 	address string
+	parsed  Address
+
+	// the client version the peer reported in its handshake, empty when it
+	// is unknown - identity comparison ignores it
+	version string
 }
 
-// EmptyNode represents an uninitialized node
+// EmptyNode is a sentinel returned where no node was found. It carries no
+// identity and must never reach the wire - check for it with IsEmpty rather
+// than comparing structs.
 var EmptyNode Node
 
+// Equal returns true iff both nodes carry the same identity. The comparison
+// is by id bytes only - two nodes with the same key but different addresses
+// are the same node, observed at different addresses.
+func (n Node) Equal(other Node) bool {
+	if n.pubKey == nil || other.pubKey == nil {
+		return n.pubKey == nil && other.pubKey == nil
+	}
+	return bytes.Equal(n.pubKey.Bytes(), other.pubKey.Bytes())
+}
+
+// IsEmpty returns true iff the node is the EmptyNode sentinel or a zero value.
+func (n Node) IsEmpty() bool {
+	return n.pubKey == nil
+}
+
 // PublicKey returns the public key of the node
 func (n Node) PublicKey() crypto.PublicKey {
 	return n.pubKey
@@ -30,6 +66,24 @@ func (n Node) Address() string {
 	return n.address
 }
 
+// Version returns the client version the peer reported, empty when unknown.
+func (n Node) Version() string {
+	return n.version
+}
+
+// WithVersion returns a copy of the node carrying the client version the
+// peer reported in its handshake.
+func (n Node) WithVersion(v string) Node {
+	n.version = v
+	return n
+}
+
+// ParsedAddress returns the structured form of the node's address.
+// It is the zero Address when the raw address string doesn't parse.
+func (n Node) ParsedAddress() Address {
+	return n.parsed
+}
+
 // DhtID creates a dhtid from the public key
 func (n Node) DhtID() DhtID {
 	return NewDhtID(n.pubKey.Bytes())
@@ -40,6 +94,44 @@ func (n Node) Pretty() string {
 	return fmt.Sprintf("Node : %v , Address: %v, DhtID: %v", n.pubKey.Pretty(), n.address, n.DhtID().Pretty())
 }
 
+// nodeJSON is the public wire form of a node, also used by the routing table
+// export format.
+type nodeJSON struct {
+	ID      string `json:"id"`      // base58 node id
+	Address string `json:"address"` // the node's advertised address
+	Version string `json:"version"` // protocol version
+}
+
+// MarshalJSON returns the public form of the node - its base58 id, address
+// and client version. No key material beyond the public key is included. A
+// remote node carries the version its peer reported, the local fallback is
+// our own client version.
+func (n Node) MarshalJSON() ([]byte, error) {
+	v := n.version
+	if v == "" {
+		v = config.ClientVersion
+	}
+	return json.Marshal(&nodeJSON{
+		ID:      n.pubKey.String(),
+		Address: n.address,
+		Version: v,
+	})
+}
+
+// UnmarshalJSON parses the public node form written by MarshalJSON.
+func (n *Node) UnmarshalJSON(data []byte) error {
+	var d nodeJSON
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+	pub, err := crypto.NewPublicKeyFromString(d.ID)
+	if err != nil {
+		return err
+	}
+	*n = New(pub, d.Address).WithVersion(d.Version)
+	return nil
+}
+
 // Union returns a union of 2 lists of nodes.
 func Union(list1 []Node, list2 []Node) []Node {
 
@@ -78,25 +170,56 @@ func SortByDhtID(nodes []Node, id DhtID) []Node {
 	return nodes
 }
 
-// New creates a new remotenode identity from a public key and an address
+// New creates a new remotenode identity from a public key and an address.
+// The address is parsed on a best effort basis - an unparsable address leaves
+// the structured form zero, callers at trust boundaries should validate with
+// ParseAddress first.
 func New(key crypto.PublicKey, address string) Node {
-	return Node{key, address}
+	parsed, _ := ParseAddress(address)
+	return Node{pubKey: key, address: address, parsed: parsed}
 }
 
+// ErrMissingSeparator is returned when a node string has no address/id separator.
+var ErrMissingSeparator = errors.New("missing '/' separator in node string")
+
 // NewNodeFromString creates a remote identity from a string in the following format: 126.0.0.1:3572/r9gJRWVB9JVPap2HKnduoFySvHtVTfJdQ4WG8DriUD82 .
+// The part after the last separator is the node id, so addresses carrying a
+// scheme round-trip through StringFromNode. Each failure mode reports what was
+// wrong with the input - a missing separator, a bad base58 id or a bad address.
 func NewNodeFromString(data string) (Node, error) {
-	items := strings.Split(data, "/")
-	if len(items) != 2 {
-		return EmptyNode, fmt.Errorf("could'nt create node from string, wrong format")
+	sep := strings.LastIndex(data, "/")
+	if sep < 0 {
+		return EmptyNode, ErrMissingSeparator
 	}
-	pubk, err := crypto.NewPublicKeyFromString(items[1])
+	address, id := data[:sep], data[sep+1:]
+	pubk, err := crypto.NewPublicKeyCached(base58.Decode(id)) // legacy raw base58 form
 	if err != nil {
-		return EmptyNode, err
+		// not a raw key - it may carry a checksum. A typo'd checksummed id
+		// fails right here with a checksum mismatch instead of silently
+		// becoming a different valid-looking key.
+		b, cerr := crypto.DecodeID(id)
+		if cerr != nil {
+			return EmptyNode, fmt.Errorf("invalid node id %v: %v", id, cerr)
+		}
+		pubk, err = crypto.NewPublicKeyCached(b)
+		if err != nil {
+			return EmptyNode, fmt.Errorf("invalid node id %v: %v", id, err)
+		}
 	}
-	return Node{pubk, items[0]}, nil
+	parsed, err := ParseAddress(address)
+	if err != nil {
+		return EmptyNode, fmt.Errorf("invalid node address %v: %v", address, err)
+	}
+	return Node{pubKey: pubk, address: address, parsed: parsed}, nil
 }
 
 // StringFromNode generates a string that represent a node in the network in following format: 126.0.0.1:3572/r9gJRWVB9JVPap2HKnduoFySvHtVTfJdQ4WG8DriUD82.
+// With checksummed ids enabled the id part carries a checksum - the parser
+// accepts both forms, so checksummed strings can roll out gradually.
 func StringFromNode(n Node) string {
-	return strings.Join([]string{n.Address(), n.PublicKey().String()}, "/")
+	id := n.PublicKey().String()
+	if config.ConfigValues.ChecksummedIDs {
+		id = crypto.EncodeID(n.PublicKey().Bytes())
+	}
+	return strings.Join([]string{n.Address(), id}, "/")
 }
@@ -0,0 +1,33 @@
+package node
+
+import (
+	"testing"
+)
+
+// FuzzNewNodeFromString feeds arbitrary strings to the node string parser.
+// Whatever the input, the parser must return a clean error rather than panic,
+// and anything it accepts must survive a format/parse round trip.
+func FuzzNewNodeFromString(f *testing.F) {
+	f.Add(StringFromNode(GenerateRandomNodeData()))
+	f.Add("126.0.0.1:3572/r9gJRWVB9JVPap2HKnduoFySvHtVTfJdQ4WG8DriUD82")
+	f.Add("")
+	f.Add("///")
+	f.Add("tcp://[::1]:7513/r9gJRWVB9JVPap2HKnduoFySvHtVTfJdQ4WG8DriUD82")
+	f.Add("garbage without separator")
+	f.Add(":0/not-base58-0OIl")
+
+	f.Fuzz(func(t *testing.T, data string) {
+		n, err := NewNodeFromString(data)
+		if err != nil {
+			return
+		}
+
+		reparsed, err := NewNodeFromString(StringFromNode(n))
+		if err != nil {
+			t.Fatalf("accepted node %v did not re-parse: %v", data, err)
+		}
+		if n.String() != reparsed.String() || n.Address() != reparsed.Address() {
+			t.Fatalf("node %v did not survive a round trip", data)
+		}
+	})
+}
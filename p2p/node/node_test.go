@@ -1,8 +1,10 @@
 package node
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
 	"github.com/stretchr/testify/assert"
 	"strings"
 	"testing"
@@ -34,6 +36,28 @@ func TestNewNodeFromString(t *testing.T) {
 	data = fmt.Sprintf("%v/%v", address, pubkey)
 	node, err = NewNodeFromString(data)
 	assert.Error(t, err)
+
+	// each failure mode names what was wrong with the input
+	_, err = NewNodeFromString("126.0.0.1:3572")
+	assert.Equal(t, ErrMissingSeparator, err, "expected a missing separator error")
+
+	_, err = NewNodeFromString("126.0.0.1:3572/not-valid-base58-0OIl")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid node id", "expected a node id error")
+
+	_, err = NewNodeFromString("126.0.0.1:0/r9gJRWVB9JVPap2HKnduoFySvHtVTfJdQ4WG8DriUD82")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid node address", "expected an address error")
+}
+
+func TestStringFromNodeRoundTrip(t *testing.T) {
+	// StringFromNode output must always parse back to an equal node
+	for _, n := range GenerateRandomNodesData(100) {
+		parsed, err := NewNodeFromString(StringFromNode(n))
+		assert.NoError(t, err, "failed to parse %v", StringFromNode(n))
+		assert.Equal(t, n.PublicKey().Bytes(), parsed.PublicKey().Bytes(), "expected the same node id")
+		assert.Equal(t, n.Address(), parsed.Address(), "expected the same address")
+	}
 }
 
 func TestStringFromNode(t *testing.T) {
@@ -46,6 +70,113 @@ func TestStringFromNode(t *testing.T) {
 	assert.Equal(t, splt[1], n.PublicKey().String())
 }
 
+func TestNewNodeFromChecksummedString(t *testing.T) {
+	n := GenerateRandomNodeData()
+	checksummed := n.Address() + "/" + crypto.EncodeID(n.PublicKey().Bytes())
+
+	// the checksummed form parses to the same node as the legacy raw form
+	parsed, err := NewNodeFromString(checksummed)
+	assert.NoError(t, err)
+	assert.Equal(t, n.PublicKey().Bytes(), parsed.PublicKey().Bytes())
+
+	legacy, err := NewNodeFromString(StringFromNode(n))
+	assert.NoError(t, err)
+	assert.Equal(t, parsed.PublicKey().Bytes(), legacy.PublicKey().Bytes())
+
+	// a typo in a checksummed id is reported as a checksum mismatch
+	// naming the entry, not parsed as some other key
+	typod := checksummed[:len(checksummed)-1]
+	if checksummed[len(checksummed)-1] == 'x' {
+		typod += "y"
+	} else {
+		typod += "x"
+	}
+	_, err = NewNodeFromString(typod)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch", "expected a checksum error, got: %v", err)
+}
+
+func TestStringFromNodeChecksummed(t *testing.T) {
+	config.ConfigValues.ChecksummedIDs = true
+	defer func() { config.ConfigValues.ChecksummedIDs = false }()
+
+	n := GenerateRandomNodeData()
+	str := StringFromNode(n)
+	splt := strings.Split(str, "/")
+	assert.Equal(t, crypto.EncodeID(n.PublicKey().Bytes()), splt[1])
+
+	// and it round-trips through the parser
+	parsed, err := NewNodeFromString(str)
+	assert.NoError(t, err)
+	assert.Equal(t, n.PublicKey().Bytes(), parsed.PublicKey().Bytes())
+}
+
+func TestNodeEqual(t *testing.T) {
+	_, pu, _ := crypto.GenerateKeyPair()
+
+	// equality is by node id - the same key at different addresses is the
+	// same node, observed at different addresses
+	n1 := New(pu, "0.0.0.0:1234")
+	n2 := New(pu, "10.0.0.1:7513")
+	assert.True(t, n1.Equal(n2), "expected equal nodes")
+	assert.True(t, n2.Equal(n1), "expected a symmetric equality")
+
+	other := GenerateRandomNodeData()
+	assert.False(t, n1.Equal(other), "expected distinct nodes")
+
+	// zero values are only equal to each other
+	assert.True(t, EmptyNode.Equal(Node{}), "expected equal sentinels")
+	assert.False(t, n1.Equal(EmptyNode), "expected a real node to differ from the sentinel")
+	assert.False(t, EmptyNode.Equal(n1), "expected the sentinel to differ from a real node")
+}
+
+func TestNodeIsEmpty(t *testing.T) {
+	assert.True(t, EmptyNode.IsEmpty(), "expected the sentinel to be empty")
+	assert.True(t, Node{}.IsEmpty(), "expected a zero value to be empty")
+	assert.False(t, GenerateRandomNodeData().IsEmpty(), "expected a real node to not be empty")
+}
+
+func TestNodeJSON(t *testing.T) {
+	n := GenerateRandomNodeData()
+
+	data, err := json.Marshal(n)
+	assert.NoError(t, err, "failed to marshal node")
+
+	// the public form carries the id, address and protocol version
+	var fields map[string]string
+	err = json.Unmarshal(data, &fields)
+	assert.NoError(t, err, "failed to unmarshal node fields")
+	assert.Equal(t, n.String(), fields["id"], "expected the base58 node id")
+	assert.Equal(t, n.Address(), fields["address"], "expected the node address")
+	assert.Equal(t, config.ClientVersion, fields["version"], "expected the protocol version")
+
+	// the public form parses back to an equal node
+	var parsed Node
+	err = json.Unmarshal(data, &parsed)
+	assert.NoError(t, err, "failed to unmarshal node")
+	assert.Equal(t, n.PublicKey().Bytes(), parsed.PublicKey().Bytes(), "expected the same node id")
+	assert.Equal(t, n.Address(), parsed.Address(), "expected the same address")
+}
+
+func TestNodePeerVersion(t *testing.T) {
+	n := GenerateRandomNodeData()
+	assert.Empty(t, n.Version(), "a fresh node carries no peer-reported version")
+
+	versioned := n.WithVersion("go-spacemesh/0.19.4")
+	assert.Equal(t, "go-spacemesh/0.19.4", versioned.Version())
+	assert.True(t, n.Equal(versioned), "the version is not part of the node's identity")
+
+	// the peer-reported version survives the JSON round trip
+	data, err := json.Marshal(versioned)
+	assert.NoError(t, err, "failed to marshal node")
+	var fields map[string]string
+	assert.NoError(t, json.Unmarshal(data, &fields))
+	assert.Equal(t, "go-spacemesh/0.19.4", fields["version"], "expected the peer-reported version over our own")
+	var parsed Node
+	assert.NoError(t, json.Unmarshal(data, &parsed))
+	assert.Equal(t, "go-spacemesh/0.19.4", parsed.Version())
+}
+
 func TestUnion(t *testing.T) {
 	nodes := GenerateRandomNodesData(10)
 	nodes2 := GenerateRandomNodesData(10)
@@ -1,52 +1,100 @@
 package p2p
 
 import (
+	"context"
 	"errors"
 	"github.com/gogo/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/log"
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
 	"github.com/spacemeshos/go-spacemesh/p2p/pb"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/p2p/trace"
+	"github.com/spacemeshos/go-spacemesh/taskgroup"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// readLoopRestarts is how many times a protocol's read loop is restarted
+// after an unexpected failure before it gives up.
+const readLoopRestarts = 3
+
+// protocolShutdownTimeout bounds how long Shutdown waits for the read loop.
+const protocolShutdownTimeout = 5 * time.Second
+
 type MessageType uint32
 
+// RequestContext carries per-request information into a message handler - the
+// requesting peer and the trace id the request arrived under, when the sender
+// traced it.
+type RequestContext struct {
+	Sender  crypto.PublicKey
+	TraceID trace.ID
+}
+
 type Protocol struct {
-	count              uint32
 	name               string
 	network            Service
+	localID            string
 	pendMutex          sync.RWMutex
-	pending            map[uint32]chan interface{}
-	resHandlers        map[uint32]func(msg []byte)
-	msgRequestHandlers map[MessageType]func(msg []byte) []byte
+	pending            map[crypto.RequestID]chan interface{}
+	resHandlers        map[crypto.RequestID]func(msg []byte)
+	msgRequestHandlers map[MessageType]func(ctx RequestContext, msg []byte) []byte
 	ingressChannel     chan service.Message
+	replay             *replayGuard
+	genRequestID       func() (crypto.RequestID, error)
+	idCollisions       uint64
+
+	// supervises the read loop so a panic there can't kill the process
+	tasks *taskgroup.Group
 }
 
 func NewProtocol(network Service, name string) *Protocol {
+	cv := config.ConfigValues
 	p := &Protocol{
 		name:               name,
-		pending:            make(map[uint32]chan interface{}),
-		resHandlers:        make(map[uint32]func(msg []byte)),
+		pending:            make(map[crypto.RequestID]chan interface{}),
+		resHandlers:        make(map[crypto.RequestID]func(msg []byte)),
+		genRequestID:       crypto.NewRequestID,
 		network:            network,
 		ingressChannel:     network.RegisterProtocol(name),
-		msgRequestHandlers: make(map[MessageType]func(msg []byte) []byte),
+		msgRequestHandlers: make(map[MessageType]func(ctx RequestContext, msg []byte) []byte),
+		replay:             newReplayGuard(cv.MsgMaxAge, cv.MsgMaxSkew, cv.MsgSkewGrace, replaySeenCacheSize),
+		tasks:              taskgroup.New(),
 	}
-	go p.readLoop()
+	p.tasks.GoWithPolicy("protocol/"+name+"/read", taskgroup.Backoff(readLoopRestarts, time.Second), p.readLoop)
 	return p
 }
 
-func (p *Protocol) readLoop() {
+// Shutdown stops the protocol's read loop, waiting briefly for it to exit.
+func (p *Protocol) Shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), protocolShutdownTimeout)
+	defer cancel()
+	if err := p.tasks.Shutdown(ctx); err != nil {
+		log.Error("protocol %v shutdown incomplete: %v", p.name, err)
+	}
+}
+
+// Tasks reports the supervision state of the protocol's loops, for the
+// status endpoint.
+func (p *Protocol) Tasks() taskgroup.Reporter {
+	return p.tasks
+}
+
+func (p *Protocol) readLoop(ctx context.Context) error {
 	for {
-		msg, ok := <-p.ingressChannel
-		if !ok {
-			log.Error("read loop channel was closed")
-			break
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-p.ingressChannel:
+			if !ok {
+				log.Error("read loop channel was closed")
+				return nil
+			}
+			//todo add buffer and option to limit number of concurrent goroutines
+			go p.handleMessage(msg)
 		}
-		//todo add buffer and option to limit number of concurrent goroutines
-		go p.handleMessage(msg)
 	}
 }
 
@@ -59,18 +107,32 @@ func (p *Protocol) handleMessage(msg service.Message) {
 		return
 	}
 
+	// a captured frame replayed after the validity window is stale, and
+	// inside it the seen-cache dedupes - either way a re-sent request can't
+	// trigger its handler twice
+	if err := p.replay.check(msg.Sender().PublicKey().String(), msg.Data(), headers.Timestamp); err != nil {
+		log.Warning("Dropping incoming ", p.name, " message from ", msg.Sender().Pretty(), ", err: ", err)
+		return
+	}
+
+	tid := trace.IDFromBytes(headers.TraceID)
 	if headers.Req {
-		p.handleRequestMessage(msg.Sender().PublicKey(), headers)
+		trace.Emit(trace.Event{TraceID: tid, Node: p.localID, Layer: trace.LayerProtocol, Op: "handle_request", Peer: msg.Sender().PublicKey().String(), Protocol: p.name})
+		p.handleRequestMessage(msg.Sender().PublicKey(), headers, tid)
 	} else {
+		trace.Emit(trace.Event{TraceID: tid, Node: p.localID, Layer: trace.LayerProtocol, Op: "handle_response", Peer: msg.Sender().PublicKey().String(), Protocol: p.name})
 		p.handleResponseMessage(headers)
 	}
 
 }
 
-func (p *Protocol) handleRequestMessage(sender crypto.PublicKey, headers *pb.MessageWrapper) {
+func (p *Protocol) handleRequestMessage(sender crypto.PublicKey, headers *pb.MessageWrapper, tid trace.ID) {
 
-	if payload := p.msgRequestHandlers[MessageType(headers.Type)](headers.Payload); payload != nil {
-		rmsg, fParseErr := proto.Marshal(&pb.MessageWrapper{Req: false, ReqID: headers.ReqID, Type: headers.Type, Payload: payload})
+	ctx := RequestContext{Sender: sender, TraceID: tid}
+	if payload := p.msgRequestHandlers[MessageType(headers.Type)](ctx, headers.Payload); payload != nil {
+		// the response answers under the request's trace id, closing the loop
+		// for the originator
+		rmsg, fParseErr := proto.Marshal(&pb.MessageWrapper{Req: false, ReqID: headers.ReqID, Type: headers.Type, Payload: payload, Timestamp: time.Now().Unix(), TraceID: tid.Bytes()})
 		if fParseErr != nil {
 			log.Error("Error Parsing Protocol message, err:", fParseErr)
 			return
@@ -84,12 +146,15 @@ func (p *Protocol) handleRequestMessage(sender crypto.PublicKey, headers *pb.Mes
 
 func (p *Protocol) handleResponseMessage(headers *pb.MessageWrapper) {
 
+	// the raw wire bytes are the key - no parsing round trip
+	reqID := crypto.RequestIDFromBytes(headers.ReqID)
+
 	//get and remove from pending
 	p.pendMutex.Lock()
-	pend, okPend := p.pending[headers.ReqID]
-	foo, okFoo := p.resHandlers[headers.ReqID]
-	delete(p.pending, headers.ReqID)
-	delete(p.resHandlers, headers.ReqID)
+	pend, okPend := p.pending[reqID]
+	foo, okFoo := p.resHandlers[reqID]
+	delete(p.pending, reqID)
+	delete(p.resHandlers, reqID)
 	p.pendMutex.Unlock()
 
 	if okPend {
@@ -101,65 +166,103 @@ func (p *Protocol) handleResponseMessage(headers *pb.MessageWrapper) {
 	}
 }
 
-func (p *Protocol) removeFromPending(reqID uint32) {
+func (p *Protocol) removeFromPending(reqID crypto.RequestID) {
 	p.pendMutex.Lock()
 	delete(p.pending, reqID)
 	delete(p.resHandlers, reqID)
 	p.pendMutex.Unlock()
 }
 
+// SetLocalID sets the node id stamped on this protocol's trace events.
+func (p *Protocol) SetLocalID(id string) {
+	p.localID = id
+}
+
 func (p *Protocol) RegisterMsgHandler(msgType MessageType, reqHandler func(msg []byte) []byte) {
+	p.msgRequestHandlers[msgType] = func(_ RequestContext, msg []byte) []byte { return reqHandler(msg) }
+}
+
+// RegisterMsgHandlerWithContext registers a handler that also receives the
+// request's context - sender and trace id.
+func (p *Protocol) RegisterMsgHandlerWithContext(msgType MessageType, reqHandler func(ctx RequestContext, msg []byte) []byte) {
 	p.msgRequestHandlers[msgType] = reqHandler
 }
 
 func (p *Protocol) SendAsyncRequest(msgType MessageType, payload []byte, address string, resHandler func(msg []byte)) error {
 
-	reqID := p.newRequestId()
-	pbsp := &pb.MessageWrapper{Req: true, ReqID: reqID, Type: uint32(msgType), Payload: payload}
-	msg, err := proto.Marshal(pbsp)
+	respc := make(chan interface{})
+	reqID, err := p.registerPending(respc, resHandler)
 	if err != nil {
 		return err
 	}
 
-	respc := make(chan interface{})
-	p.pendMutex.Lock()
-	p.pending[reqID] = respc
-	p.resHandlers[reqID] = resHandler
-	p.pendMutex.Unlock()
+	tid := trace.MaybeNewID()
+	pbsp := &pb.MessageWrapper{Req: true, ReqID: reqID.Bytes(), Type: uint32(msgType), Payload: payload, Timestamp: time.Now().Unix(), TraceID: tid.Bytes()}
+	msg, err := proto.Marshal(pbsp)
+	if err != nil {
+		p.removeFromPending(reqID)
+		return err
+	}
 
 	if sendErr := p.network.SendMessage(address, p.name, msg); sendErr != nil {
 		p.removeFromPending(reqID)
 		return sendErr
 	}
 
+	trace.Emit(trace.Event{TraceID: tid, Node: p.localID, Layer: trace.LayerProtocol, Op: "send_request", Peer: address, Protocol: p.name})
 	return nil
 }
 
-func (p *Protocol) newRequestId() uint32 {
-	return atomic.AddUint32(&p.count, 1)
+// registerPending draws a random request id and registers the response
+// channel under it. A collision with an in-flight request is astronomically
+// unlikely but cheap to rule out - the id is redrawn and the counter bumped.
+func (p *Protocol) registerPending(respc chan interface{}, resHandler func(msg []byte)) (crypto.RequestID, error) {
+	p.pendMutex.Lock()
+	defer p.pendMutex.Unlock()
+	for {
+		reqID, err := p.genRequestID()
+		if err != nil {
+			return crypto.RequestID{}, err
+		}
+		if _, busy := p.pending[reqID]; busy {
+			atomic.AddUint64(&p.idCollisions, 1)
+			continue
+		}
+		p.pending[reqID] = respc
+		if resHandler != nil {
+			p.resHandlers[reqID] = resHandler
+		}
+		return reqID, nil
+	}
+}
+
+// requestIDCollisions reports how many times a drawn request id collided
+// with a pending one and had to be redrawn.
+func (p *Protocol) requestIDCollisions() uint64 {
+	return atomic.LoadUint64(&p.idCollisions)
 }
 
 func (p *Protocol) SendRequest(msgType MessageType, payload []byte, address string, timeout time.Duration) (interface{}, error) {
-	reqID := p.newRequestId()
+	respc := make(chan interface{})
+	reqID, err := p.registerPending(respc, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer p.removeFromPending(reqID)
 
-	pbsp := &pb.MessageWrapper{Req: true, ReqID: reqID, Type: uint32(msgType), Payload: payload}
+	tid := trace.MaybeNewID()
+	pbsp := &pb.MessageWrapper{Req: true, ReqID: reqID.Bytes(), Type: uint32(msgType), Payload: payload, Timestamp: time.Now().Unix(), TraceID: tid.Bytes()}
 	msg, err := proto.Marshal(pbsp)
 	if err != nil {
 		return nil, err
 	}
 
-	respc := make(chan interface{})
-
-	p.pendMutex.Lock()
-	p.pending[reqID] = respc
-	p.pendMutex.Unlock()
-
-	defer p.removeFromPending(reqID)
-
 	if sendErr := p.network.SendMessage(address, p.name, msg); sendErr != nil {
 		return nil, sendErr
 	}
 
+	trace.Emit(trace.Event{TraceID: tid, Node: p.localID, Layer: trace.LayerProtocol, Op: "send_request", Peer: address, Protocol: p.name})
+
 	timer := time.NewTimer(timeout)
 	select {
 	case response := <-respc:
@@ -2,6 +2,7 @@ package p2p
 
 import (
 	"fmt"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
 	"github.com/stretchr/testify/assert"
 	"testing"
@@ -59,3 +60,34 @@ func TestProtocol_SendAsyncRequestRequest(t *testing.T) {
 	assert.EqualValues(t, "some value to return", string(msg), "value received did not match correct value")
 	assert.NoError(t, err, "Should not return error")
 }
+
+func TestProtocol_RequestIDCollisionRegenerates(t *testing.T) {
+	sim := simulator.New()
+	n1 := sim.NewNode()
+	fnd1 := NewProtocol(n1, protocol)
+
+	colliding, err := crypto.NewRequestID()
+	assert.NoError(t, err)
+	fresh, err := crypto.NewRequestID()
+	assert.NoError(t, err)
+
+	// a generator that keeps drawing the same id until the third draw
+	draws := 0
+	fnd1.genRequestID = func() (crypto.RequestID, error) {
+		draws++
+		if draws <= 2 {
+			return colliding, nil
+		}
+		return fresh, nil
+	}
+
+	first, err := fnd1.registerPending(make(chan interface{}), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, colliding, first)
+
+	// the second draw collides with the in-flight id and is redrawn
+	second, err := fnd1.registerPending(make(chan interface{}), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, fresh, second)
+	assert.Equal(t, uint64(1), fnd1.requestIDCollisions())
+}
@@ -0,0 +1,126 @@
+package p2p
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+)
+
+// replayGuard check errors
+var (
+	// ErrReplayedMsg - an identical message from the same sender was already handled within the validity window
+	ErrReplayedMsg = errors.New("replayed message, already handled within the validity window")
+	// ErrStaleMsg - message timestamp is older than the validity window allows
+	ErrStaleMsg = errors.New("stale message, timestamp is beyond the validity window")
+	// ErrFutureMsg - message timestamp is further in the future than the allowed clock skew
+	ErrFutureMsg = errors.New("message timestamp is too far in the future")
+)
+
+// replaySeenCacheSize bounds the number of remembered (sender, hash) pairs.
+// When full the oldest entry is evicted - a replay of an evicted message is
+// accepted again, which is the accepted cost of keeping memory bounded.
+const replaySeenCacheSize = 10000
+
+type replaySeenKey struct {
+	sender string
+	hash   string
+}
+
+type replaySeenEntry struct {
+	key    replaySeenKey
+	expiry int64 // unix time after which the window check rejects the message anyway
+}
+
+// replayGuard rejects replayed and stale envelopes. A message is acceptable
+// when its timestamp is at most maxAge behind or maxSkew ahead of the local
+// clock; within that window an identical (sender, payload hash) pair is
+// handled at most once. A grace margin is tolerated on both edges so two
+// slightly-off clocks can't brick communication between their nodes - an
+// accept that needed the grace is counted as a near-miss, giving operators a
+// warning before drift turns into rejections. Safe for concurrent use.
+type replayGuard struct {
+	maxAge  time.Duration
+	maxSkew time.Duration
+	grace   time.Duration
+
+	mu      sync.Mutex
+	seen    map[replaySeenKey]struct{}
+	entries []replaySeenEntry // insertion order, for pruning and the size bound
+	maxSize int
+
+	nearMisses uint64 // messages accepted only thanks to the grace margin
+
+	now func() time.Time // tests inject a fake clock
+}
+
+func newReplayGuard(maxAge, maxSkew, grace time.Duration, maxSize int) *replayGuard {
+	return &replayGuard{
+		maxAge:  maxAge,
+		maxSkew: maxSkew,
+		grace:   grace,
+		seen:    make(map[replaySeenKey]struct{}),
+		maxSize: maxSize,
+		now:     time.Now,
+	}
+}
+
+// check validates a message against the replay window. sender identifies who
+// authored the payload, timestamp is its claimed authoring unix time. nil is
+// returned exactly once per (sender, payload) within the validity window.
+func (rg *replayGuard) check(sender string, payload []byte, timestamp int64) error {
+	now := rg.now()
+	age := now.Sub(time.Unix(timestamp, 0)) // positive when the message is from the past
+
+	if age > rg.maxAge+rg.grace {
+		return ErrStaleMsg
+	}
+	if -age > rg.maxSkew+rg.grace {
+		return ErrFutureMsg
+	}
+	if age > rg.maxAge || -age > rg.maxSkew {
+		atomic.AddUint64(&rg.nearMisses, 1)
+	}
+
+	key := replaySeenKey{sender: sender, hash: string(crypto.Sha256(payload))}
+	// once the timestamp falls out of the window the age check rejects any
+	// replay by itself, so the entry is no longer worth remembering
+	expiry := time.Unix(timestamp, 0).Add(rg.maxAge + rg.grace).Unix()
+
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+
+	rg.prune(now.Unix())
+
+	if _, ok := rg.seen[key]; ok {
+		return ErrReplayedMsg
+	}
+
+	if rg.maxSize > 0 && len(rg.entries) >= rg.maxSize {
+		delete(rg.seen, rg.entries[0].key)
+		rg.entries = rg.entries[1:]
+	}
+
+	rg.seen[key] = struct{}{}
+	rg.entries = append(rg.entries, replaySeenEntry{key: key, expiry: expiry})
+	return nil
+}
+
+// prune drops leading entries whose validity window has passed. Entries are
+// roughly expiry-ordered since they arrive in near real time - a future-skewed
+// entry at the head just delays pruning until the size bound evicts it.
+func (rg *replayGuard) prune(now int64) {
+	for len(rg.entries) > 0 && rg.entries[0].expiry <= now {
+		delete(rg.seen, rg.entries[0].key)
+		rg.entries = rg.entries[1:]
+	}
+}
+
+// nearMissCount reports how many messages were accepted only because of the
+// grace margin - a rising count means some peer's clock is drifting toward
+// the edge of the window.
+func (rg *replayGuard) nearMissCount() uint64 {
+	return atomic.LoadUint64(&rg.nearMisses)
+}
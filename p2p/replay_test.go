@@ -0,0 +1,88 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// a guard with a controllable clock - maxAge 10m, maxSkew 5m, grace 1m
+func testReplayGuard(maxSize int) (*replayGuard, *time.Time) {
+	now := time.Unix(1000000, 0)
+	rg := newReplayGuard(10*time.Minute, 5*time.Minute, time.Minute, maxSize)
+	rg.now = func() time.Time { return now }
+	return rg, &now
+}
+
+func TestReplayGuardWindow(t *testing.T) {
+	rg, now := testReplayGuard(100)
+
+	// fresh, slightly old and slightly ahead are all in the window
+	assert.NoError(t, rg.check("sender", []byte("a"), now.Unix()))
+	assert.NoError(t, rg.check("sender", []byte("b"), now.Add(-9*time.Minute).Unix()))
+	assert.NoError(t, rg.check("sender", []byte("c"), now.Add(4*time.Minute).Unix()))
+	assert.Equal(t, uint64(0), rg.nearMissCount())
+
+	// beyond maxAge+grace is stale, beyond maxSkew+grace is from the future
+	err := rg.check("sender", []byte("d"), now.Add(-12*time.Minute).Unix())
+	assert.Equal(t, ErrStaleMsg, err)
+	err = rg.check("sender", []byte("e"), now.Add(7*time.Minute).Unix())
+	assert.Equal(t, ErrFutureMsg, err)
+}
+
+func TestReplayGuardGraceNearMisses(t *testing.T) {
+	rg, now := testReplayGuard(100)
+
+	// inside the grace margin on both edges - accepted but counted, so a
+	// drifting peer clock is visible before it starts losing messages
+	assert.NoError(t, rg.check("sender", []byte("old"), now.Add(-10*time.Minute-30*time.Second).Unix()))
+	assert.Equal(t, uint64(1), rg.nearMissCount())
+	assert.NoError(t, rg.check("sender", []byte("new"), now.Add(5*time.Minute+30*time.Second).Unix()))
+	assert.Equal(t, uint64(2), rg.nearMissCount())
+}
+
+func TestReplayGuardDedupe(t *testing.T) {
+	rg, now := testReplayGuard(100)
+
+	payload := []byte("captured frame")
+	ts := now.Unix()
+
+	assert.NoError(t, rg.check("sender", payload, ts))
+
+	// a replay within the window is deduped
+	assert.Equal(t, ErrReplayedMsg, rg.check("sender", payload, ts))
+
+	// the same payload from another sender is a different message
+	assert.NoError(t, rg.check("other", payload, ts))
+}
+
+func TestReplayGuardReplayAfterWindow(t *testing.T) {
+	rg, now := testReplayGuard(100)
+
+	payload := []byte("captured frame")
+	ts := now.Unix()
+	assert.NoError(t, rg.check("sender", payload, ts))
+
+	// once the window has passed the seen entry is gone, but the stale
+	// timestamp check rejects the replay on its own
+	*now = now.Add(12 * time.Minute)
+	assert.Equal(t, ErrStaleMsg, rg.check("sender", payload, ts))
+
+	// the next accepted message prunes the now-useless entry
+	assert.NoError(t, rg.check("sender", []byte("fresh"), now.Unix()))
+	assert.Equal(t, 1, len(rg.seen), "expired entries should have been pruned")
+}
+
+func TestReplayGuardBounded(t *testing.T) {
+	rg, now := testReplayGuard(2)
+
+	assert.NoError(t, rg.check("sender", []byte("a"), now.Unix()))
+	assert.NoError(t, rg.check("sender", []byte("b"), now.Unix()))
+	assert.NoError(t, rg.check("sender", []byte("c"), now.Unix()))
+	assert.Equal(t, 2, len(rg.seen), "cache must stay within its bound")
+
+	// the oldest entry was evicted to make room
+	assert.NoError(t, rg.check("sender", []byte("a"), now.Unix()))
+	assert.Equal(t, ErrReplayedMsg, rg.check("sender", []byte("c"), now.Unix()))
+}
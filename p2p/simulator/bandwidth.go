@@ -0,0 +1,87 @@
+package simulator
+
+import (
+	"time"
+)
+
+// SetBandwidth limits the byte rate between two nodes, in both directions.
+// Deliveries are delayed according to message size and the backlog already
+// occupying the link, on whichever clock the simulation runs.
+func (s *Simulator) SetBandwidth(from, to string, bytesPerSec int) {
+	s.mutex.Lock()
+	s.bandwidth[link{from, to}] = bytesPerSec
+	s.bandwidth[link{to, from}] = bytesPerSec
+	s.mutex.Unlock()
+}
+
+// SetBandwidthOneWay limits the byte rate from one node to another in a
+// single direction, allowing asymmetric links.
+func (s *Simulator) SetBandwidthOneWay(from, to string, bytesPerSec int) {
+	s.mutex.Lock()
+	s.bandwidth[link{from, to}] = bytesPerSec
+	s.mutex.Unlock()
+}
+
+// SetNodeBandwidth caps the aggregate ingress byte rate of a node across all
+// of its links.
+func (s *Simulator) SetNodeBandwidth(id string, bytesPerSec int) {
+	s.mutex.Lock()
+	s.nodeBandwidth[id] = bytesPerSec
+	s.mutex.Unlock()
+}
+
+// WithPriorityLane lets messages of at most maxSize bytes bypass the
+// bandwidth backlog - they still pay their own transmission time but don't
+// queue behind bulk transfers already occupying the link.
+func (s *Simulator) WithPriorityLane(maxSize int) {
+	s.mutex.Lock()
+	s.priorityMax = maxSize
+	s.mutex.Unlock()
+}
+
+// transmission returns how long size bytes occupy a line of the given rate.
+func transmission(size, bytesPerSec int) time.Duration {
+	return time.Duration(float64(size) / float64(bytesPerSec) * float64(time.Second))
+}
+
+// bandwidthDelay returns how long a message of the given size must wait on
+// the link's and the receiver's rate limits, advancing their backlogs.
+func (s *Simulator) bandwidthDelay(from, to string, size int) time.Duration {
+	now := s.Clock().Now()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	priority := s.priorityMax > 0 && size <= s.priorityMax
+	var delay time.Duration
+
+	if rate, ok := s.bandwidth[link{from, to}]; ok && rate > 0 {
+		delay = s.reserve(s.linkBusy, linkKey(from, to), now, size, rate, priority)
+	}
+	if rate, ok := s.nodeBandwidth[to]; ok && rate > 0 {
+		if d := s.reserve(s.nodeBusy, to, now, size, rate, priority); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// reserve books transmission time on the keyed line, returning the wait until
+// the message clears it. Priority messages start immediately instead of
+// behind the backlog. Callers must hold the mutex.
+func (s *Simulator) reserve(busy map[string]time.Time, key string, now time.Time, size, rate int, priority bool) time.Duration {
+	start := now
+	if until, ok := busy[key]; ok && until.After(now) && !priority {
+		start = until
+	}
+	finish := start.Add(transmission(size, rate))
+	if finish.After(busy[key]) {
+		busy[key] = finish
+	}
+	return finish.Sub(now)
+}
+
+// linkKey flattens a directed link into a map key for the busy table.
+func linkKey(from, to string) string {
+	return from + ">" + to
+}
@@ -0,0 +1,78 @@
+package simulator
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// virtualTimer is a pending timer on a VirtualClock.
+type virtualTimer struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// VirtualClock is a timesync.Clock that only moves when AdvanceTime is
+// called, so tests can exercise multi-second timeouts in microseconds of
+// wall time. Timers fire deterministically in deadline order.
+type VirtualClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*virtualTimer
+}
+
+// NewVirtualClock creates a virtual clock starting at the given time.
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+// Now returns the current virtual time.
+func (v *VirtualClock) Now() time.Time {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.now
+}
+
+// After returns a channel that receives the virtual time once the clock has
+// been advanced past d.
+func (v *VirtualClock) After(d time.Duration) <-chan time.Time {
+	c := make(chan time.Time, 1)
+	v.mutex.Lock()
+	if d <= 0 {
+		c <- v.now
+	} else {
+		v.timers = append(v.timers, &virtualTimer{deadline: v.now.Add(d), c: c})
+	}
+	v.mutex.Unlock()
+	return c
+}
+
+// Sleep blocks until the clock has been advanced past d.
+func (v *VirtualClock) Sleep(d time.Duration) {
+	<-v.After(d)
+}
+
+// AdvanceTime moves the clock forward by d and fires every timer that came
+// due, earliest deadline first.
+func (v *VirtualClock) AdvanceTime(d time.Duration) {
+	v.mutex.Lock()
+	v.now = v.now.Add(d)
+
+	due := make([]*virtualTimer, 0, len(v.timers))
+	pending := v.timers[:0]
+	for _, timer := range v.timers {
+		if !timer.deadline.After(v.now) {
+			due = append(due, timer)
+		} else {
+			pending = append(pending, timer)
+		}
+	}
+	v.timers = pending
+	now := v.now
+	v.mutex.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].deadline.Before(due[j].deadline) })
+	for _, timer := range due {
+		timer.c <- now
+	}
+}
@@ -0,0 +1,104 @@
+package simulator
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+)
+
+// FaultyBehavior describes how a byzantine node mangles traffic. Behaviors
+// compose - a node can both corrupt and replay - and are driven by an RNG
+// seeded with Seed so runs are reproducible.
+type FaultyBehavior struct {
+	// CorruptProb is the probability an outgoing payload gets a random bit
+	// flipped.
+	CorruptProb float64
+	// ReplayProb is the probability an outgoing message is followed by a
+	// replay of a previously seen message.
+	ReplayProb float64
+	// Mute silently discards everything the node would send while keeping
+	// it reachable, so peers see a live connection that never answers.
+	Mute bool
+	// Responder, when set, answers messages arriving on protocols the node
+	// listens on - use it to reply with oversized or malformed payloads.
+	// Returning nil swallows the request.
+	Responder func(msg []byte) []byte
+	// Seed seeds the behavior's RNG.
+	Seed int64
+}
+
+// FaultyNode wraps a simulator node with adversarial behavior for testing
+// robustness features against byzantine peers.
+type FaultyNode struct {
+	*Node
+	behavior FaultyBehavior
+
+	mutex sync.Mutex
+	rng   *rand.Rand
+	seen  [][]byte
+}
+
+// NewFaultyNodeFrom creates a byzantine node with the given identity and
+// behavior.
+func (s *Simulator) NewFaultyNodeFrom(n node.Node, behavior FaultyBehavior) *FaultyNode {
+	return &FaultyNode{
+		Node:     s.NewNodeFrom(n),
+		behavior: behavior,
+		rng:      rand.New(rand.NewSource(behavior.Seed)),
+	}
+}
+
+// SendMessage runs the outgoing payload through the configured mutators
+// before handing it to the network. A muted node discards it instead.
+func (fn *FaultyNode) SendMessage(nodeID string, protocol string, payload []byte) error {
+	if fn.behavior.Mute {
+		return nil
+	}
+
+	fn.mutex.Lock()
+	out := append([]byte(nil), payload...)
+	if len(out) > 0 && fn.behavior.CorruptProb > 0 && fn.rng.Float64() < fn.behavior.CorruptProb {
+		bit := fn.rng.Intn(len(out) * 8)
+		out[bit/8] ^= 1 << uint(bit%8)
+	}
+	var replay []byte
+	if fn.behavior.ReplayProb > 0 && len(fn.seen) > 0 && fn.rng.Float64() < fn.behavior.ReplayProb {
+		replay = fn.seen[fn.rng.Intn(len(fn.seen))]
+	}
+	fn.seen = append(fn.seen, out)
+	fn.mutex.Unlock()
+
+	if err := fn.Node.SendMessage(nodeID, protocol, out); err != nil {
+		return err
+	}
+	if replay != nil {
+		return fn.Node.SendMessage(nodeID, protocol, replay)
+	}
+	return nil
+}
+
+// Listen consumes messages arriving on the protocol, remembering them for
+// replay and answering each one through the behavior's Responder when one is
+// set. Without a Responder requests are swallowed - the sender just waits.
+func (fn *FaultyNode) Listen(protocol string) {
+	c := fn.RegisterProtocol(protocol)
+	go func() {
+		for msg := range c {
+			fn.mutex.Lock()
+			fn.seen = append(fn.seen, msg.Data())
+			fn.mutex.Unlock()
+
+			if fn.behavior.Responder == nil {
+				continue
+			}
+			if resp := fn.behavior.Responder(msg.Data()); resp != nil {
+				fn.SendMessage(msg.Sender().String(), protocol, resp)
+			}
+		}
+	}()
+}
+
+// the faulty node must remain usable wherever a service is expected
+var _ service.Service = (*FaultyNode)(nil)
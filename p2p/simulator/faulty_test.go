@@ -0,0 +1,79 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveFaultyPayload(t *testing.T, seed int64) []byte {
+	sim := New()
+	fn := sim.NewFaultyNodeFrom(node.GenerateRandomNodeData(), FaultyBehavior{CorruptProb: 1, Seed: seed})
+	b := sim.NewNode()
+	bIn := b.RegisterProtocol(testProtocol)
+
+	received := make(chan []byte, 1)
+	go func() {
+		msg := <-bIn
+		received <- msg.Data()
+	}()
+
+	err := fn.SendMessage(b.String(), testProtocol, []byte("payload to corrupt"))
+	assert.NoError(t, err, "failed to send message")
+
+	select {
+	case data := <-received:
+		return data
+	case <-time.After(time.Second):
+		t.Fatal("corrupted message was not delivered")
+		return nil
+	}
+}
+
+func TestFaultyNodeCorruption(t *testing.T) {
+	original := []byte("payload to corrupt")
+
+	first := receiveFaultyPayload(t, 99)
+	assert.NotEqual(t, original, first, "expected a corrupted payload")
+
+	// the same seed flips the same bit, a different seed doesn't
+	second := receiveFaultyPayload(t, 99)
+	assert.Equal(t, first, second, "expected the same seed to corrupt identically")
+	third := receiveFaultyPayload(t, 100)
+	assert.NotEqual(t, first, third, "expected a different seed to corrupt differently")
+}
+
+func TestFaultyNodeReplay(t *testing.T) {
+	sim := New()
+	fn := sim.NewFaultyNodeFrom(node.GenerateRandomNodeData(), FaultyBehavior{ReplayProb: 1, Seed: 7})
+	b := sim.NewNode()
+	drain(b.RegisterProtocol(testProtocol))
+
+	// the first send has nothing to replay, the second replays the first
+	assert.NoError(t, fn.SendMessage(b.String(), testProtocol, []byte("one")))
+	assert.NoError(t, fn.SendMessage(b.String(), testProtocol, []byte("two")))
+
+	assert.Equal(t, 3, sim.CountBetween(fn.String(), b.String(), testProtocol), "expected a replayed message")
+	assert.Len(t, sim.Delivered(testProtocol, []byte("one")), 2, "expected the first message replayed")
+}
+
+func TestFaultyNodeMute(t *testing.T) {
+	sim := New()
+	fn := sim.NewFaultyNodeFrom(node.GenerateRandomNodeData(), FaultyBehavior{Mute: true})
+	fn.Listen(testProtocol)
+	b := sim.NewNode()
+	bIn := b.RegisterProtocol(testProtocol)
+
+	// peers can send to a muted node without error, it just never answers
+	err := b.SendMessage(fn.String(), testProtocol, []byte("request"))
+	assert.NoError(t, err, "a muted node should stay reachable")
+
+	assert.NoError(t, fn.SendMessage(b.String(), testProtocol, []byte("response")))
+	select {
+	case <-bIn:
+		t.Fatal("a muted node sent a message")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
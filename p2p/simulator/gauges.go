@@ -0,0 +1,116 @@
+package simulator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// ReportGauge registers a named resource gauge on the node - a function the
+// simulator samples whenever a gauge dump is requested. Components report
+// their map and queue sizes here so memory blowups in big simulations can be
+// attributed to a node and a structure.
+func (sn *Node) ReportGauge(name string, f func() int64) {
+	sn.servicesMutex.Lock()
+	if sn.gauges == nil {
+		sn.gauges = make(map[string]func() int64)
+	}
+	sn.gauges[name] = f
+	sn.servicesMutex.Unlock()
+}
+
+// gaugeSnapshot samples all the node's gauges.
+func (sn *Node) gaugeSnapshot() map[string]int64 {
+	sn.servicesMutex.RLock()
+	fns := make(map[string]func() int64, len(sn.gauges))
+	for name, f := range sn.gauges {
+		fns[name] = f
+	}
+	sn.servicesMutex.RUnlock()
+
+	out := make(map[string]int64, len(fns))
+	for name, f := range fns {
+		out[name] = f()
+	}
+	return out
+}
+
+// GaugeValue samples one gauge of one node.
+func (s *Simulator) GaugeValue(nodeID, name string) (int64, bool) {
+	s.mutex.RLock()
+	n, ok := s.nodes[nodeID]
+	s.mutex.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	n.servicesMutex.RLock()
+	f, ok := n.gauges[name]
+	n.servicesMutex.RUnlock()
+	if !ok {
+		return 0, false
+	}
+	return f(), true
+}
+
+// GaugeTable samples every gauge on every node and renders them as a table -
+// gauges sorted by their network-wide total, largest first, and under each
+// gauge the nodes sorted by value, largest first. The worst offender is
+// always the first line under the first gauge.
+func (s *Simulator) GaugeTable() string {
+	s.mutex.RLock()
+	nodes := make([]*Node, 0, len(s.nodes))
+	for _, n := range s.nodes {
+		nodes = append(nodes, n)
+	}
+	s.mutex.RUnlock()
+
+	type sample struct {
+		id    string
+		value int64
+	}
+	byGauge := make(map[string][]sample)
+	totals := make(map[string]int64)
+	for _, n := range nodes {
+		for name, value := range n.gaugeSnapshot() {
+			byGauge[name] = append(byGauge[name], sample{n.String(), value})
+			totals[name] += value
+		}
+	}
+
+	names := make([]string, 0, len(byGauge))
+	for name := range byGauge {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if totals[names[i]] != totals[names[j]] {
+			return totals[names[i]] > totals[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	var b strings.Builder
+	for _, name := range names {
+		samples := byGauge[name]
+		sort.Slice(samples, func(i, j int) bool {
+			if samples[i].value != samples[j].value {
+				return samples[i].value > samples[j].value
+			}
+			return samples[i].id < samples[j].id
+		})
+		fmt.Fprintf(&b, "gauge %v total %v across %v nodes\n", name, totals[name], len(samples))
+		for _, smp := range samples {
+			fmt.Fprintf(&b, "  %v %v\n", smp.id, smp.value)
+		}
+	}
+	return b.String()
+}
+
+// DumpGaugesOnFailure logs the gauge table when the test has failed, so a
+// blowup's offender is in the failure output. Meant to be deferred right
+// after the simulator is created.
+func (s *Simulator) DumpGaugesOnFailure(t *testing.T) {
+	if t.Failed() {
+		t.Logf("resource gauges:\n%s", s.GaugeTable())
+	}
+}
@@ -0,0 +1,131 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/stretchr/testify/assert"
+)
+
+// sizedDHT is a dht stub whose routing table size the gauge wiring can read.
+type sizedDHT struct {
+	countingDHT
+}
+
+func (s *sizedDHT) Size() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.updates
+}
+
+func TestNodeGauges(t *testing.T) {
+	sim := New()
+	n := sim.NewNode()
+
+	n.ReportGauge("test.constant", func() int64 { return 42 })
+	value, ok := sim.GaugeValue(n.String(), "test.constant")
+	assert.True(t, ok, "expected the gauge registered")
+	assert.Equal(t, int64(42), value)
+
+	_, ok = sim.GaugeValue(n.String(), "test.unknown")
+	assert.False(t, ok, "an unknown gauge should not resolve")
+
+	// attaching a dht with a readable size wires its gauge automatically
+	d := &sizedDHT{}
+	n.AttachDHT(d)
+	d.Update(node.GenerateRandomNodeData())
+	d.Update(node.GenerateRandomNodeData())
+	value, ok = sim.GaugeValue(n.String(), "dht.routing_table")
+	assert.True(t, ok, "expected the dht gauge wired on attach")
+	assert.Equal(t, int64(2), value)
+}
+
+// dedupeCache is the dedupe map a gossip-style consumer keeps - entries
+// expire after ttl, pruned on insert. A ttl of zero keeps entries forever,
+// which is exactly what a sub-millisecond TTL truncated to zero by a config
+// in whole milliseconds does.
+type dedupeCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]time.Time
+}
+
+func newDedupeCache(ttl time.Duration) *dedupeCache {
+	return &dedupeCache{ttl: ttl, entries: make(map[string]time.Time)}
+}
+
+func (d *dedupeCache) add(msg []byte) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.ttl > 0 {
+		cutoff := time.Now().Add(-d.ttl)
+		for key, added := range d.entries {
+			if added.Before(cutoff) {
+				delete(d.entries, key)
+			}
+		}
+	}
+	d.entries[string(msg)] = time.Now()
+}
+
+func (d *dedupeCache) size() int64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return int64(len(d.entries))
+}
+
+func TestGaugeTablePinpointsLeak(t *testing.T) {
+	const nodesNum = 20
+	const leaky = 13
+
+	sim := New()
+	defer sim.DumpGaugesOnFailure(t)
+
+	nodes := make([]*Node, nodesNum)
+	for i := range nodes {
+		nodes[i] = sim.NewNode()
+
+		ttl := 25 * time.Millisecond
+		if i == leaky {
+			ttl = 0 // the truncated-to-zero TTL misconfiguration
+		}
+		cache := newDedupeCache(ttl)
+		nodes[i].ReportGauge("dedupe.entries", cache.size)
+
+		in := nodes[i].RegisterProtocol(testProtocol)
+		go func() {
+			for msg := range in {
+				cache.add(msg.Data())
+			}
+		}()
+	}
+
+	// two bursts far enough apart that healthy caches prune the first one
+	for burst := 0; burst < 2; burst++ {
+		for i := 0; i < 100; i++ {
+			payload := []byte(fmt.Sprintf("burst %d msg %d", burst, i))
+			assert.NoError(t, nodes[0].Broadcast(testProtocol, payload))
+		}
+		time.Sleep(60 * time.Millisecond)
+	}
+
+	leakyValue, ok := sim.GaugeValue(nodes[leaky].String(), "dedupe.entries")
+	assert.True(t, ok)
+	assert.Equal(t, int64(200), leakyValue, "the misconfigured cache should keep everything")
+	for i := range nodes {
+		if i == leaky {
+			continue
+		}
+		value, _ := sim.GaugeValue(nodes[i].String(), "dedupe.entries")
+		assert.True(t, value <= 110, "node %d should have pruned the first burst, has %d entries", i, value)
+	}
+
+	// the offender tops the dump - first node line under the first gauge
+	lines := strings.Split(sim.GaugeTable(), "\n")
+	assert.True(t, strings.HasPrefix(lines[0], "gauge dedupe.entries"), "expected the leaking gauge first, got %q", lines[0])
+	assert.True(t, strings.Contains(lines[1], nodes[leaky].String()), "expected the leaking node first, got %q", lines[1])
+}
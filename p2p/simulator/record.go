@@ -0,0 +1,260 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+)
+
+// traffic log binary format - a fixed magic and version header followed by
+// one record per delivery. Strings are uint16-length-prefixed, payloads
+// uint32-length-prefixed and optionally truncated, in which case the full
+// size and a sha256 digest of the full payload are kept instead.
+var logMagic = [4]byte{'s', 'm', 't', 'l'}
+
+// logVersion is bumped whenever the record encoding changes.
+const logVersion uint8 = 1
+
+// ErrLogVersion is returned when reading a traffic log written by an
+// incompatible simulator version.
+var ErrLogVersion = errors.New("unsupported traffic log version")
+
+// LoggedMessage is one delivery read back from a traffic log.
+type LoggedMessage struct {
+	From     string
+	To       string
+	Protocol string
+	When     time.Time // simulation-clock delivery time
+	Payload  []byte    // possibly truncated, see Truncated
+	Size     int       // full payload size
+	Digest   []byte    // sha256 of the full payload, set when truncated
+}
+
+// Truncated reports whether the payload was cut at the recording cap.
+func (m LoggedMessage) Truncated() bool {
+	return m.Size > len(m.Payload)
+}
+
+// logWriter serializes deliveries to the log, guarding the writer against
+// concurrent deliveries. The first write error sticks and stops recording.
+type logWriter struct {
+	mutex      sync.Mutex
+	w          io.Writer
+	truncateAt int
+	err        error
+}
+
+// WithRecording makes the simulator write every delivered message to w.
+// Payloads larger than truncateAt bytes are truncated and logged with their
+// full size and digest - truncateAt 0 keeps payloads whole.
+func WithRecording(w io.Writer, truncateAt int) Option {
+	return func(s *Simulator) {
+		lw := &logWriter{w: w, truncateAt: truncateAt}
+		lw.err = writeLogHeader(w)
+		s.recording = lw
+	}
+}
+
+// RecordingError returns the first error hit while writing the traffic log,
+// or nil. Recording stops at the first error.
+func (s *Simulator) RecordingError() error {
+	if s.recording == nil {
+		return nil
+	}
+	s.recording.mutex.Lock()
+	defer s.recording.mutex.Unlock()
+	return s.recording.err
+}
+
+// record appends one delivery to the log.
+func (lw *logWriter) record(from, to, protocol string, payload []byte, when time.Time) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+	if lw.err != nil {
+		return
+	}
+
+	stored := payload
+	var digest []byte
+	if lw.truncateAt > 0 && len(payload) > lw.truncateAt {
+		stored = payload[:lw.truncateAt]
+		digest = crypto.Sha256(payload)
+	}
+
+	lw.err = writeString(lw.w, from)
+	if lw.err == nil {
+		lw.err = writeString(lw.w, to)
+	}
+	if lw.err == nil {
+		lw.err = writeString(lw.w, protocol)
+	}
+	if lw.err == nil {
+		lw.err = binary.Write(lw.w, binary.LittleEndian, when.UnixNano())
+	}
+	if lw.err == nil {
+		lw.err = binary.Write(lw.w, binary.LittleEndian, uint32(len(payload)))
+	}
+	if lw.err == nil {
+		lw.err = binary.Write(lw.w, binary.LittleEndian, uint32(len(stored)))
+	}
+	if lw.err == nil {
+		_, lw.err = lw.w.Write(stored)
+	}
+	if lw.err == nil && digest != nil {
+		_, lw.err = lw.w.Write(digest)
+	}
+}
+
+// writeLogHeader writes the magic and version that open every traffic log.
+func writeLogHeader(w io.Writer) error {
+	if _, err := w.Write(logMagic[:]); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, logVersion)
+}
+
+// writeString writes a uint16-length-prefixed string.
+func writeString(w io.Writer, s string) error {
+	if err := binary.Write(w, binary.LittleEndian, uint16(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// readString reads a uint16-length-prefixed string.
+func readString(r io.Reader) (string, error) {
+	var n uint16
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// ReadLog parses a recorded traffic log back into its deliveries, verifying
+// the header. It fails with ErrLogVersion on a log from an incompatible
+// format version.
+func ReadLog(r io.Reader) ([]LoggedMessage, error) {
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil {
+		return nil, err
+	}
+	if magic != logMagic {
+		return nil, errors.New("not a traffic log")
+	}
+	var version uint8
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, err
+	}
+	if version != logVersion {
+		return nil, ErrLogVersion
+	}
+
+	var out []LoggedMessage
+	for {
+		msg, err := readRecord(r)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, msg)
+	}
+}
+
+// readRecord reads one delivery, returning io.EOF cleanly at the log's end.
+func readRecord(r io.Reader) (LoggedMessage, error) {
+	var msg LoggedMessage
+	var err error
+	if msg.From, err = readString(r); err != nil {
+		return msg, err
+	}
+	if msg.To, err = readString(r); err != nil {
+		return msg, unexpectedEOF(err)
+	}
+	if msg.Protocol, err = readString(r); err != nil {
+		return msg, unexpectedEOF(err)
+	}
+	var nanos int64
+	if err = binary.Read(r, binary.LittleEndian, &nanos); err != nil {
+		return msg, unexpectedEOF(err)
+	}
+	msg.When = time.Unix(0, nanos)
+	var full, stored uint32
+	if err = binary.Read(r, binary.LittleEndian, &full); err != nil {
+		return msg, unexpectedEOF(err)
+	}
+	if err = binary.Read(r, binary.LittleEndian, &stored); err != nil {
+		return msg, unexpectedEOF(err)
+	}
+	msg.Size = int(full)
+	msg.Payload = make([]byte, stored)
+	if _, err = io.ReadFull(r, msg.Payload); err != nil {
+		return msg, unexpectedEOF(err)
+	}
+	if stored < full {
+		msg.Digest = make([]byte, 32)
+		if _, err = io.ReadFull(r, msg.Digest); err != nil {
+			return msg, unexpectedEOF(err)
+		}
+	}
+	return msg, nil
+}
+
+// unexpectedEOF turns a mid-record EOF into an error ReadLog won't swallow.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Replay feeds the recorded deliveries addressed to originalID into the
+// target node's registered protocol channels, under the recorded senders'
+// identities and paced at the recorded relative times on the simulation
+// clock. The other recorded nodes don't need to exist - this lets a single
+// node under test reprocess the exact traffic of a failed run. It blocks
+// until the last message is delivered; records whose protocol the target
+// never registered are skipped.
+func (s *Simulator) Replay(records []LoggedMessage, originalID string, target *Node) error {
+	var start time.Time
+	began := s.Clock().Now()
+
+	for _, rec := range records {
+		if rec.To != originalID {
+			continue
+		}
+		if start.IsZero() {
+			start = rec.When
+		}
+
+		pub, err := crypto.NewPublicKeyFromString(rec.From)
+		if err != nil {
+			return fmt.Errorf("bad sender in traffic log: %v", err)
+		}
+
+		s.mutex.RLock()
+		c, ok := s.protocolHandler[target.String()][rec.Protocol]
+		s.mutex.RUnlock()
+		if !ok {
+			continue
+		}
+
+		if wait := rec.When.Sub(start) - s.Clock().Now().Sub(began); wait > 0 {
+			s.Clock().Sleep(wait)
+		}
+		s.ingress(target.String(), rec.Protocol, c, simMessage{rec.Payload, node.New(pub, "")})
+	}
+	return nil
+}
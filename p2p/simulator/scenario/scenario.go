@@ -0,0 +1,211 @@
+// Package scenario builds common multi-node simulator setups, replacing the
+// boilerplate every big dht test hand-rolls - bootstrap nodes, per-node
+// swarm configs, dht attachment and the bootstrap wait loop. Handles expose
+// each node's LocalNode and DHT; gossip's Neighborhood runs on real network
+// connections and can't ride the simulator yet.
+package scenario
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/dht"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+)
+
+const (
+	star = iota
+	line
+	random
+)
+
+// Topology decides which peers each node is pointed at for bootstrap.
+type Topology struct {
+	kind int
+	k    int
+}
+
+// Star points every node at the scenario's bootstrap nodes.
+var Star = Topology{kind: star}
+
+// Line points every node at the node created just before it, chaining off
+// the last bootstrap node.
+var Line = Topology{kind: line}
+
+// Random points every node at up to k distinct nodes created before it.
+func Random(k int) Topology {
+	return Topology{kind: random, k: k}
+}
+
+// Handle exposes one scenario node's components to the test.
+type Handle struct {
+	Index  int
+	Local  *node.LocalNode
+	Sim    *simulator.Node
+	DHT    dht.DHT
+	Config config.SwarmConfig
+}
+
+// Builder accumulates a scenario's shape before Start brings it up.
+type Builder struct {
+	sim            *simulator.Simulator
+	bootstrapCount int
+	nodeCount      int
+	mutate         func(*config.SwarmConfig)
+	topology       Topology
+}
+
+// New starts describing a scenario on the given simulator. The defaults are
+// one bootstrap node and a star topology.
+func New(sim *simulator.Simulator) *Builder {
+	return &Builder{sim: sim, bootstrapCount: 1, topology: Star}
+}
+
+// WithBootstrapNodes sets how many bootstrap nodes the scenario creates.
+func (b *Builder) WithBootstrapNodes(n int) *Builder {
+	b.bootstrapCount = n
+	return b
+}
+
+// WithNodes sets how many regular nodes the scenario creates. The mutator,
+// when not nil, adjusts each node's swarm config before its dht is built.
+func (b *Builder) WithNodes(n int, mutate func(*config.SwarmConfig)) *Builder {
+	b.nodeCount = n
+	b.mutate = mutate
+	return b
+}
+
+// WithTopology sets which peers each node bootstraps against.
+func (b *Builder) WithTopology(t Topology) *Builder {
+	b.topology = t
+	return b
+}
+
+// indexedErr carries a node's bootstrap result back with its index.
+type indexedErr struct {
+	index int
+	err   error
+}
+
+// Scenario is a running set of simulated nodes built by a Builder.
+type Scenario struct {
+	Bootstrap []*Handle
+	Nodes     []*Handle
+	booted    chan indexedErr
+}
+
+// Start creates the scenario's nodes and launches every regular node's
+// bootstrap under ctx. Node creation failures abort with the failing node's
+// index; bootstrap results are collected by WaitBootstrapped.
+func (b *Builder) Start(ctx context.Context) (*Scenario, error) {
+	sc := &Scenario{booted: make(chan indexedErr, b.nodeCount)}
+
+	for i := 0; i < b.bootstrapCount; i++ {
+		h, err := b.newHandle(i, config.DefaultConfig().SwarmConfig)
+		if err != nil {
+			return nil, fmt.Errorf("bootstrap node %d: %v", i, err)
+		}
+		sc.Bootstrap = append(sc.Bootstrap, h)
+	}
+
+	for i := 0; i < b.nodeCount; i++ {
+		cfg := config.DefaultConfig().SwarmConfig
+		if b.mutate != nil {
+			b.mutate(&cfg)
+		}
+		cfg.BootstrapNodes = b.bootstrapPeers(sc, i)
+
+		h, err := b.newHandle(b.bootstrapCount+i, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("node %d: %v", i, err)
+		}
+		sc.Nodes = append(sc.Nodes, h)
+	}
+
+	for i, h := range sc.Nodes {
+		go func(i int, h *Handle) {
+			sc.booted <- indexedErr{i, h.DHT.Bootstrap(ctx)}
+		}(i, h)
+	}
+	return sc, nil
+}
+
+// newHandle creates one node with a fresh identity, builds its dht on the
+// simulation's clock and attaches it for routing table updates.
+func (b *Builder) newHandle(index int, cfg config.SwarmConfig) (*Handle, error) {
+	address := fmt.Sprintf("0.0.0.0:%d", 10000+index)
+	ln, err := node.NewNodeIdentity(config.DefaultConfig(), address, false)
+	if err != nil {
+		return nil, err
+	}
+
+	sn := b.sim.NewNodeFrom(ln.Node)
+	d, err := dht.New(ln, cfg, sn)
+	if err != nil {
+		return nil, err
+	}
+	d.SetClock(b.sim.Clock())
+	sn.AttachDHT(d)
+
+	return &Handle{Index: index, Local: ln, Sim: sn, DHT: d, Config: cfg}, nil
+}
+
+// bootstrapPeers returns the bootstrap entries for the i-th regular node,
+// according to the scenario's topology.
+func (b *Builder) bootstrapPeers(sc *Scenario, i int) []string {
+	earlier := make([]node.Node, 0, len(sc.Bootstrap)+i)
+	for _, h := range sc.Bootstrap {
+		earlier = append(earlier, h.Sim.Node)
+	}
+	for _, h := range sc.Nodes {
+		earlier = append(earlier, h.Sim.Node)
+	}
+
+	var peers []node.Node
+	switch b.topology.kind {
+	case line:
+		peers = earlier[len(earlier)-1:]
+	case random:
+		k := b.topology.k
+		if k > len(earlier) {
+			k = len(earlier)
+		}
+		for _, j := range rand.Perm(len(earlier))[:k] {
+			peers = append(peers, earlier[j])
+		}
+	default: // star
+		peers = earlier[:len(sc.Bootstrap)]
+	}
+
+	entries := make([]string, len(peers))
+	for j, p := range peers {
+		entries[j] = node.StringFromNode(p)
+	}
+	return entries
+}
+
+// WaitBootstrapped waits for every regular node's bootstrap to finish,
+// aggregating individual failures with their node index. It may only be
+// called once - results are consumed as they arrive.
+func (sc *Scenario) WaitBootstrapped(ctx context.Context) error {
+	var failures []string
+	for pending := len(sc.Nodes); pending > 0; pending-- {
+		select {
+		case res := <-sc.booted:
+			if res.err != nil {
+				failures = append(failures, fmt.Sprintf("node %d: %v", res.index, res.err))
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("bootstrap incomplete with %d of %d nodes pending: %v", pending, len(sc.Nodes), ctx.Err())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package scenario
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/config"
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestScenario_Bootstrap2 is TestDHT_Bootstrap2 ported to the builder - a
+// hundred nodes bootstrapping off one bootstrap node in a star.
+func TestScenario_Bootstrap2(t *testing.T) {
+
+	const timeout = 10 * time.Second
+	const nodesNum = 100
+	const minToBoot = 25
+
+	sim := simulator.New()
+
+	sc, err := New(sim).
+		WithBootstrapNodes(1).
+		WithNodes(nodesNum, func(c *config.SwarmConfig) { c.RandomConnections = minToBoot }).
+		WithTopology(Star).
+		Start(context.TODO())
+	assert.NoError(t, err, "the scenario should come up")
+	assert.Equal(t, nodesNum, len(sc.Nodes))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	assert.NoError(t, sc.WaitBootstrapped(ctx), "all nodes should boot within time")
+}
+
+func TestScenario_Topologies(t *testing.T) {
+	sim := simulator.New()
+
+	// line - every node chains off the one before it
+	lineSc, err := New(sim).WithNodes(5, nil).WithTopology(Line).Start(context.TODO())
+	assert.NoError(t, err)
+	prev := lineSc.Bootstrap[0]
+	for _, h := range lineSc.Nodes {
+		assert.Equal(t, []string{node.StringFromNode(prev.Sim.Node)}, h.Config.BootstrapNodes, "node %d should chain off its predecessor", h.Index)
+		prev = h
+	}
+
+	// random(k) - every node gets k distinct earlier peers, fewer when fewer exist
+	randSc, err := New(sim).WithNodes(6, nil).WithTopology(Random(3)).Start(context.TODO())
+	assert.NoError(t, err)
+	for i, h := range randSc.Nodes {
+		expected := 3
+		if i+1 < expected { // bootstrap node plus i predecessors
+			expected = i + 1
+		}
+		assert.Equal(t, expected, len(h.Config.BootstrapNodes), "node %d has the wrong peer count", h.Index)
+		seen := make(map[string]struct{})
+		for _, p := range h.Config.BootstrapNodes {
+			seen[p] = struct{}{}
+		}
+		assert.Equal(t, len(h.Config.BootstrapNodes), len(seen), "node %d has duplicate peers", h.Index)
+	}
+}
+
+func TestScenario_WaitBootstrappedFailures(t *testing.T) {
+	sim := simulator.New()
+
+	// three nodes that can never reach five peers - aborting the context
+	// must surface each failure with its index
+	ctx, cancel := context.WithCancel(context.Background())
+	sc, err := New(sim).
+		WithNodes(3, func(c *config.SwarmConfig) { c.RandomConnections = 5 }).
+		Start(ctx)
+	assert.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	err = sc.WaitBootstrapped(context.Background())
+	assert.Error(t, err, "aborted bootstraps should be reported")
+	for _, part := range []string{"node 0:", "node 1:", "node 2:"} {
+		assert.True(t, strings.Contains(err.Error(), part), "expected %q in %q", part, err.Error())
+	}
+}
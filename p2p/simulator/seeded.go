@@ -0,0 +1,104 @@
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+)
+
+// NewSeeded creates a simulator whose randomness - latency jitter, fault
+// decisions and broadcast ordering - all flows from one source seeded with
+// seed, and whose delayed deliveries are strict FIFO per link. Two runs of a
+// single-goroutine scenario with the same seed produce the same per-link
+// traffic. What stays nondeterministic is scheduling across links and inside
+// the components under test - their goroutines are not under the
+// simulator's control.
+func NewSeeded(seed int64, opts ...Option) *Simulator {
+	s := New(opts...)
+	s.seeded = true
+	s.seed = seed
+	shared := rand.New(rand.NewSource(seed))
+	s.rng = shared
+	s.faultRng = shared
+	s.queues = make(map[string]chan delivery)
+	return s
+}
+
+// Seed returns the seed a seeded simulator was created with.
+func (s *Simulator) Seed() int64 {
+	return s.seed
+}
+
+// RunSeeded runs the scenario against a seeded simulator and logs the seed
+// when the test fails, so the exact run can be reproduced.
+func RunSeeded(t *testing.T, seed int64, scenario func(sim *Simulator)) {
+	sim := NewSeeded(seed)
+	defer func() {
+		if t.Failed() {
+			t.Logf("seeded simulation failed - reproduce with seed %d", seed)
+		}
+	}()
+	scenario(sim)
+}
+
+// delivery is a message queued on a link's FIFO lane.
+type delivery struct {
+	at       time.Time
+	to       string
+	protocol string
+	c        chan service.Message
+	msg      simMessage
+}
+
+// enqueueFIFO hands a delayed delivery to the link's lane, creating the lane
+// on first use. Each lane delivers one message at a time, in order.
+func (s *Simulator) enqueueFIFO(key string, del delivery) {
+	s.mutex.Lock()
+	q, ok := s.queues[key]
+	if !ok {
+		q = make(chan delivery, 1024)
+		s.queues[key] = q
+		go s.lane(q)
+	}
+	s.mutex.Unlock()
+	q <- del
+}
+
+// lane delivers queued messages for one link in FIFO order, waiting out each
+// message's remaining delay on the simulation clock.
+func (s *Simulator) lane(q chan delivery) {
+	for del := range q {
+		if wait := del.at.Sub(s.Clock().Now()); wait > 0 {
+			s.Clock().Sleep(wait)
+		}
+		s.ingress(del.to, del.protocol, del.c, del.msg)
+	}
+}
+
+// Transcript returns the recorded traffic as one line per delivery, grouped
+// by link and in per-link delivery order, so two runs can be compared. Lines
+// look like "from>to protocol size". Grouping is necessary because ordering
+// across links depends on goroutine scheduling even in seeded mode.
+func (s *Simulator) Transcript() []string {
+	records := s.traffic.snapshot()
+	byLink := make(map[string][]string)
+	keys := make([]string, 0)
+	for _, rec := range records {
+		key := linkKey(rec.from, rec.to)
+		if _, ok := byLink[key]; !ok {
+			keys = append(keys, key)
+		}
+		byLink[key] = append(byLink[key], fmt.Sprintf("%v %v %v", key, rec.protocol, rec.size))
+	}
+	sort.Strings(keys)
+
+	var out []string
+	for _, key := range keys {
+		out = append(out, byLink[key]...)
+	}
+	return out
+}
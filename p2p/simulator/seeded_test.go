@@ -0,0 +1,109 @@
+package simulator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// seededBootstrapTranscript runs a 30-node bootstrap-style scenario on a
+// seeded simulator and returns its traffic transcript with node ids
+// normalized to their creation index, so runs with different identities can
+// be compared.
+func seededBootstrapTranscript(t *testing.T, seed int64) []string {
+	sim := NewSeeded(seed, WithDefaultLatency(time.Millisecond), WithJitter(2*time.Millisecond, 0))
+
+	nodes := make([]*Node, 30)
+	names := make(map[string]string)
+	for i := range nodes {
+		nodes[i] = sim.NewNode()
+		names[nodes[i].String()] = fmt.Sprintf("n%02d", i)
+		drain(nodes[i].RegisterProtocol(testProtocol))
+	}
+
+	// every third node joins over a lossy link
+	for i := 1; i < len(nodes); i++ {
+		if i%3 == 0 {
+			sim.SetFaultsOneWay(nodes[i].String(), nodes[0].String(), Faults{DropProb: 0.5})
+		}
+	}
+
+	// all nodes join via node 0 and get a response per attempt
+	for i := 1; i < len(nodes); i++ {
+		for attempt := 0; attempt < 3; attempt++ {
+			payload := []byte(fmt.Sprintf("join %02d attempt %d", i, attempt))
+			assert.NoError(t, nodes[i].SendMessage(nodes[0].String(), testProtocol, payload))
+			assert.NoError(t, nodes[0].SendMessage(nodes[i].String(), testProtocol, []byte("peers")))
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond) // let the delayed deliveries settle
+
+	transcript := sim.Transcript()
+	for i, line := range transcript {
+		fields := strings.SplitN(line, " ", 2)
+		ids := strings.SplitN(fields[0], ">", 2)
+		transcript[i] = names[ids[0]] + ">" + names[ids[1]] + " " + fields[1]
+	}
+	// group by normalized link, keeping per-link delivery order
+	sort.SliceStable(transcript, func(i, j int) bool {
+		return strings.Fields(transcript[i])[0] < strings.Fields(transcript[j])[0]
+	})
+	return transcript
+}
+
+func TestSeededSimulatorReproducible(t *testing.T) {
+	first := seededBootstrapTranscript(t, 1234)
+	assert.NotEmpty(t, first, "expected traffic in the scenario")
+
+	second := seededBootstrapTranscript(t, 1234)
+	assert.Equal(t, first, second, "the same seed should reproduce the same transcript")
+
+	third := seededBootstrapTranscript(t, 1235)
+	assert.NotEqual(t, first, third, "a different seed should change the drop pattern")
+}
+
+func TestRunSeeded(t *testing.T) {
+	RunSeeded(t, 42, func(sim *Simulator) {
+		assert.Equal(t, int64(42), sim.Seed(), "the scenario should see its seed")
+
+		a := sim.NewNode()
+		b := sim.NewNode()
+		drain(b.RegisterProtocol(testProtocol))
+
+		assert.NoError(t, a.SendMessage(b.String(), testProtocol, []byte("ping")))
+		assert.Equal(t, 1, sim.Count(testProtocol), "expected the message recorded")
+	})
+}
+
+func TestSeededFIFOOrder(t *testing.T) {
+	sim := NewSeeded(7, WithDefaultLatency(time.Millisecond), WithJitter(5*time.Millisecond, 0))
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+	bIn := b.RegisterProtocol(testProtocol)
+
+	received := make(chan []byte, 20)
+	go func() {
+		for msg := range bIn {
+			received <- msg.Data()
+		}
+	}()
+
+	// jittered delays must not reorder messages on one link
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, a.SendMessage(b.String(), testProtocol, []byte(fmt.Sprintf("%02d", i))))
+	}
+	for i := 0; i < 20; i++ {
+		select {
+		case data := <-received:
+			assert.Equal(t, fmt.Sprintf("%02d", i), string(data), "delivery out of order")
+		case <-time.After(time.Second):
+			t.Fatal("missing delivery")
+		}
+	}
+}
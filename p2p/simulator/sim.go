@@ -2,14 +2,38 @@ package simulator
 
 import (
 	"errors"
+	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/log"
 	"github.com/spacemeshos/go-spacemesh/p2p/node"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/timesync"
 	"io"
+	"math/rand"
+	"sort"
 	"sync"
+	"time"
 )
 
-// TODO : implmement delays?
+// link identifies a directed connection between two simulated nodes.
+type link struct {
+	from string
+	to   string
+}
+
+// Faults describes the message faults injected on a link.
+type Faults struct {
+	DropProb   float64 // probability a message is dropped
+	DupProb    float64 // probability a message is delivered twice
+	DropEveryN int     // deterministically drop every Nth message, 0 disables
+}
+
+// LinkStats counts the messages, bytes and faults seen on a directed link.
+type LinkStats struct {
+	Sent       uint64 // messages handed to the link
+	Dropped    uint64 // messages dropped by fault injection
+	Duplicated uint64 // messages delivered twice
+	Bytes      uint64 // payload bytes that went through, counting duplicates
+}
 
 // Simulator is a p2p node factory and message bridge
 type Simulator struct {
@@ -17,31 +41,407 @@ type Simulator struct {
 	mutex           sync.RWMutex
 	protocolHandler map[string]map[string]chan service.Message // maps peerPubkey -> protocol -> handler
 	nodes           map[string]*Node
+
+	// simulated link latency - per-link values override the default
+	defaultLatency time.Duration
+	latency        map[link]time.Duration
+
+	// optional per-delivery jitter drawn from a seeded RNG
+	jitterMax time.Duration
+	rng       *rand.Rand
+	rngMutex  sync.Mutex
+
+	// per-link fault injection - drops and duplications between Send and
+	// the receiver's ingress channel
+	faults   map[link]Faults
+	stats    map[link]*LinkStats
+	faultRng *rand.Rand
+
+	// network partition state - while partitioned, messages only flow
+	// between nodes assigned to the same group
+	partitioned    bool
+	groups         map[string]int
+	partitionDrops uint64
+
+	// optional virtual clock - when set, latency is scheduled on it and
+	// components built by test helpers can share it
+	vclock *VirtualClock
+
+	// churn state - offline nodes accept nothing and messages reaching
+	// them are dropped
+	offline      map[string]bool
+	offlineDrops map[string]uint64
+
+	// traffic records every delivered message for test assertions
+	traffic trafficRecorder
+
+	// recording, when set, writes every delivered message to a binary log
+	// that Replay can feed back later
+	recording *logWriter
+
+	// bandwidth limits - per-link and per-node byte rates with their
+	// accumulated backlogs, and the priority lane size threshold
+	bandwidth     map[link]int
+	nodeBandwidth map[string]int
+	linkBusy      map[string]time.Time
+	nodeBusy      map[string]time.Time
+	priorityMax   int
+
+	// seeded mode - one RNG behind all randomness and per-link FIFO lanes
+	// for delayed deliveries
+	seeded bool
+	seed   int64
+	queues map[string]chan delivery
+}
+
+// Option configures a Simulator.
+type Option func(*Simulator)
+
+// WithDefaultLatency sets the latency applied on every link that has no
+// explicit per-link latency.
+func WithDefaultLatency(d time.Duration) Option {
+	return func(s *Simulator) {
+		s.defaultLatency = d
+	}
+}
+
+// WithJitter adds up to max extra random delay per delivery, drawn from an
+// RNG seeded with seed so runs are reproducible.
+func WithJitter(max time.Duration, seed int64) Option {
+	return func(s *Simulator) {
+		s.jitterMax = max
+		s.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithVirtualClock puts the simulation on a virtual clock. Time only moves
+// when AdvanceTime is called, making timer-driven behavior deterministic.
+func WithVirtualClock() Option {
+	return func(s *Simulator) {
+		s.vclock = NewVirtualClock(time.Now())
+	}
+}
+
+// WithFaultSeed seeds the RNG driving probabilistic fault injection so runs
+// are reproducible. Without it the fault RNG uses a fixed default seed.
+func WithFaultSeed(seed int64) Option {
+	return func(s *Simulator) {
+		s.faultRng = rand.New(rand.NewSource(seed))
+	}
 }
 
 type dht interface {
 	Update(node2 node.Node)
 }
 
+// ErrNodeOffline is returned when sending to or from a stopped or crashed node.
+var ErrNodeOffline = errors.New("node is offline")
+
 // Node is a simulated p2p node that can be used as a p2p service
 type Node struct {
 	sim *Simulator
 	node.Node
-	dht dht
+
+	servicesMutex sync.RWMutex
+	services      map[string]interface{}
+	gauges        map[string]func() int64
 }
 
+// dhtServiceName is the registry slot the AttachDHT shim uses.
+const dhtServiceName = "dht"
+
 // New Creates a p2p simulation by providing nodes as p2p services and bridge them.
-func New() *Simulator {
+func New(opts ...Option) *Simulator {
 	s := &Simulator{
 		protocolHandler: make(map[string]map[string]chan service.Message),
 		nodes:           make(map[string]*Node),
+		latency:         make(map[link]time.Duration),
+		faults:          make(map[link]Faults),
+		stats:           make(map[link]*LinkStats),
+		faultRng:        rand.New(rand.NewSource(0)),
+		offline:         make(map[string]bool),
+		offlineDrops:    make(map[string]uint64),
+		bandwidth:       make(map[link]int),
+		nodeBandwidth:   make(map[string]int),
+		linkBusy:        make(map[string]time.Time),
+		nodeBusy:        make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 	return s
 }
 
+// SetLatency sets the delivery latency between two nodes, in both directions.
+func (s *Simulator) SetLatency(from, to string, d time.Duration) {
+	s.mutex.Lock()
+	s.latency[link{from, to}] = d
+	s.latency[link{to, from}] = d
+	s.mutex.Unlock()
+}
+
+// SetLatencyOneWay sets the delivery latency from one node to another in a
+// single direction, allowing asymmetric links.
+func (s *Simulator) SetLatencyOneWay(from, to string, d time.Duration) {
+	s.mutex.Lock()
+	s.latency[link{from, to}] = d
+	s.mutex.Unlock()
+}
+
+// Clock returns the clock the simulation runs on - the virtual clock when
+// one was requested, the system clock otherwise. Components under test
+// should take their time from it.
+func (s *Simulator) Clock() timesync.Clock {
+	if s.vclock != nil {
+		return s.vclock
+	}
+	return timesync.RealClock{}
+}
+
+// AdvanceTime moves the virtual clock forward, firing due timers. It panics
+// when the Simulator was not created with WithVirtualClock.
+func (s *Simulator) AdvanceTime(d time.Duration) {
+	if s.vclock == nil {
+		panic("AdvanceTime called without a virtual clock")
+	}
+	s.vclock.AdvanceTime(d)
+}
+
+// Partition splits the network into the given groups of node ids. Messages
+// between nodes in different groups - or nodes in no group - are dropped, not
+// queued, until Heal is called. Intra-group traffic is unaffected.
+func (s *Simulator) Partition(groups ...[]string) {
+	s.mutex.Lock()
+	s.partitioned = true
+	s.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, id := range group {
+			s.groups[id] = i
+		}
+	}
+	s.mutex.Unlock()
+}
+
+// AssignGroup adds a node to one of the partition groups, so nodes created
+// after Partition can participate in an existing partition.
+func (s *Simulator) AssignGroup(id string, group int) {
+	s.mutex.Lock()
+	if s.groups == nil {
+		s.groups = make(map[string]int)
+	}
+	s.groups[id] = group
+	s.mutex.Unlock()
+}
+
+// Heal removes the partition and restores full connectivity.
+func (s *Simulator) Heal() {
+	s.mutex.Lock()
+	s.partitioned = false
+	s.groups = nil
+	s.mutex.Unlock()
+}
+
+// PartitionDrops returns the number of messages dropped for crossing a
+// partition boundary.
+func (s *Simulator) PartitionDrops() uint64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.partitionDrops
+}
+
+// crossesPartition reports whether a message between the two nodes would
+// cross the current partition boundary, counting it as dropped when it does.
+func (s *Simulator) crossesPartition(from, to string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.partitioned {
+		return false
+	}
+	gf, fok := s.groups[from]
+	gt, tok := s.groups[to]
+	if fok && tok && gf == gt {
+		return false
+	}
+	s.partitionDrops++
+	return true
+}
+
+// SetFaults sets the fault configuration between two nodes, in both directions.
+func (s *Simulator) SetFaults(from, to string, f Faults) {
+	s.mutex.Lock()
+	s.faults[link{from, to}] = f
+	s.faults[link{to, from}] = f
+	s.mutex.Unlock()
+}
+
+// SetFaultsOneWay sets the fault configuration from one node to another in a
+// single direction, allowing asymmetric links.
+func (s *Simulator) SetFaultsOneWay(from, to string, f Faults) {
+	s.mutex.Lock()
+	s.faults[link{from, to}] = f
+	s.mutex.Unlock()
+}
+
+// LinkStats returns a snapshot of the counters for a directed link.
+func (s *Simulator) LinkStats(from, to string) LinkStats {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if st, ok := s.stats[link{from, to}]; ok {
+		return *st
+	}
+	return LinkStats{}
+}
+
+// applyFaults counts a send on the link and rolls its configured faults,
+// returning how many copies of the message to deliver - 0 for a drop, 2 for
+// a duplication and 1 otherwise. Bytes of surviving copies are accounted to
+// the link's stats.
+func (s *Simulator) applyFaults(from, to string, size int) int {
+	l := link{from, to}
+
+	s.mutex.Lock()
+	f, configured := s.faults[l]
+	st, ok := s.stats[l]
+	if !ok {
+		st = &LinkStats{}
+		s.stats[l] = st
+	}
+	st.Sent++
+	sent := st.Sent
+	s.mutex.Unlock()
+
+	if !configured {
+		s.mutex.Lock()
+		st.Bytes += uint64(size)
+		s.mutex.Unlock()
+		return 1
+	}
+
+	drop := f.DropEveryN > 0 && sent%uint64(f.DropEveryN) == 0
+	dup := false
+	s.rngMutex.Lock()
+	if !drop && f.DropProb > 0 {
+		drop = s.faultRng.Float64() < f.DropProb
+	}
+	if !drop && f.DupProb > 0 {
+		dup = s.faultRng.Float64() < f.DupProb
+	}
+	s.rngMutex.Unlock()
+
+	s.mutex.Lock()
+	if drop {
+		st.Dropped++
+	} else {
+		st.Bytes += uint64(size)
+		if dup {
+			st.Duplicated++
+			st.Bytes += uint64(size)
+		}
+	}
+	s.mutex.Unlock()
+
+	if drop {
+		return 0
+	}
+	if dup {
+		return 2
+	}
+	return 1
+}
+
+// linkLatency returns the delivery delay for a message on the given link.
+func (s *Simulator) linkLatency(from, to string) time.Duration {
+	s.mutex.RLock()
+	d, ok := s.latency[link{from, to}]
+	if !ok {
+		d = s.defaultLatency
+	}
+	s.mutex.RUnlock()
+
+	if s.jitterMax > 0 {
+		s.rngMutex.Lock()
+		d += time.Duration(s.rng.Int63n(int64(s.jitterMax)))
+		s.rngMutex.Unlock()
+	}
+
+	return d
+}
+
+// deliver hands a message to the receiver's ingress channel, enforcing the
+// partition and applying the link's faults and latency. Delayed deliveries don't block the sender.
+// Zero-latency links deliver synchronously, exactly as they did before
+// latency existed.
+func (s *Simulator) deliver(to string, protocol string, c chan service.Message, msg simMessage) {
+	if s.crossesPartition(msg.sender.String(), to) {
+		return
+	}
+
+	copies := s.applyFaults(msg.sender.String(), to, len(msg.msg))
+	if copies == 0 {
+		return
+	}
+
+	latency := s.linkLatency(msg.sender.String(), to)
+	for i := 0; i < copies; i++ {
+		d := latency + s.bandwidthDelay(msg.sender.String(), to, len(msg.msg))
+		if d == 0 {
+			s.ingress(to, protocol, c, msg)
+			continue
+		}
+		if s.seeded {
+			s.enqueueFIFO(linkKey(msg.sender.String(), to), delivery{
+				at: s.Clock().Now().Add(d), to: to, protocol: protocol, c: c, msg: msg,
+			})
+			continue
+		}
+		go func(d time.Duration) {
+			s.Clock().Sleep(d)
+			s.ingress(to, protocol, c, msg)
+		}(d)
+	}
+}
+
+// ingress finally hands a message to the receiver, dropping it when the
+// receiver went offline while it was in flight. Deliveries are recorded for
+// traffic assertions.
+func (s *Simulator) ingress(to string, protocol string, c chan service.Message, msg simMessage) {
+	s.mutex.Lock()
+	if s.offline[to] {
+		s.offlineDrops[to]++
+		s.mutex.Unlock()
+		return
+	}
+	s.mutex.Unlock()
+	c <- msg
+	when := s.Clock().Now()
+	s.traffic.record(trafficRecord{
+		from:     msg.sender.String(),
+		to:       to,
+		protocol: protocol,
+		size:     len(msg.msg),
+		digest:   crypto.Sha256(msg.msg),
+		when:     when,
+	})
+	if s.recording != nil {
+		s.recording.record(msg.sender.String(), to, protocol, msg.msg, when)
+	}
+}
+
+// OfflineDrops returns how many messages were dropped because the given node
+// was offline when they arrived.
+func (s *Simulator) OfflineDrops(id string) uint64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.offlineDrops[id]
+}
+
 func (s *Simulator) createdNode(n *Node) {
 	s.mutex.Lock()
-	s.protocolHandler[n.PublicKey().String()] = make(map[string]chan service.Message)
+	// keep live handler registrations if the identity already exists, so a
+	// concurrent duplicate creation can't wipe channels mid-delivery
+	if _, ok := s.protocolHandler[n.PublicKey().String()]; !ok {
+		s.protocolHandler[n.PublicKey().String()] = make(map[string]chan service.Message)
+	}
 	s.nodes[n.PublicKey().String()] = n
 	s.mutex.Unlock()
 }
@@ -70,12 +470,12 @@ func (s *Simulator) NewNodeFrom(n node.Node) *Node {
 func (s *Simulator) updateNode(node string, sender *Node) {
 	s.mutex.Lock()
 	n, ok := s.nodes[node]
+	s.mutex.Unlock()
 	if ok {
-		if n.dht != nil {
-			n.Update(sender.Node)
+		if d, attached := n.attachedDHT(); attached {
+			d.Update(sender.Node)
 		}
 	}
-	s.mutex.Unlock()
 }
 
 type simMessage struct {
@@ -102,10 +502,14 @@ func (sn *Node) Start() error {
 // returns error if the node cant be found. corresponds to `Service.SendMessage`
 func (sn *Node) SendMessage(nodeID string, protocol string, payload []byte) error {
 	sn.sim.mutex.RLock()
+	offline := sn.sim.offline[nodeID] || sn.sim.offline[sn.Node.String()]
 	thec, ok := sn.sim.protocolHandler[nodeID][protocol]
 	sn.sim.mutex.RUnlock()
+	if offline {
+		return ErrNodeOffline
+	}
 	if ok {
-		thec <- simMessage{payload, sn.Node}
+		sn.sim.deliver(nodeID, protocol, thec, simMessage{payload, sn.Node})
 		sn.sim.updateNode(nodeID, sn)
 		return nil
 	}
@@ -115,13 +519,27 @@ func (sn *Node) SendMessage(nodeID string, protocol string, payload []byte) erro
 
 // Broadcast
 func (sn *Node) Broadcast(protocol string, payload []byte) error {
+	type target struct {
+		id string
+		c  chan service.Message
+	}
+
 	sn.sim.mutex.RLock()
+	targets := make([]target, 0, len(sn.sim.protocolHandler))
 	for n := range sn.sim.protocolHandler {
 		if c, ok := sn.sim.protocolHandler[n][protocol]; ok {
-			c <- simMessage{payload, sn.Node}
+			targets = append(targets, target{n, c})
 		}
 	}
 	sn.sim.mutex.RUnlock()
+
+	// fan out in a stable order rather than map order
+	sort.Slice(targets, func(i, j int) bool { return targets[i].id < targets[j].id })
+
+	for _, tr := range targets {
+		sn.sim.deliver(tr.id, protocol, tr.c, simMessage{payload, sn.Node})
+	}
+
 	log.Debug("%v >> All ( Gossip ) (%v)", sn.Node.PublicKey(), payload)
 	return nil
 }
@@ -135,17 +553,81 @@ func (sn *Node) RegisterProtocol(protocol string) chan service.Message {
 	return c
 }
 
+// AttachService registers a named component on the node so the simulator's
+// message routing - and other components - can find it. Attaching while
+// messages are flowing is safe, the registry is locked independently.
+func (sn *Node) AttachService(name string, s interface{}) {
+	sn.servicesMutex.Lock()
+	if sn.services == nil {
+		sn.services = make(map[string]interface{})
+	}
+	sn.services[name] = s
+	sn.servicesMutex.Unlock()
+}
+
+// Service returns the component attached under the given name.
+func (sn *Node) Service(name string) (interface{}, bool) {
+	sn.servicesMutex.RLock()
+	defer sn.servicesMutex.RUnlock()
+	s, ok := sn.services[name]
+	return s, ok
+}
+
+// attachedDHT returns the node's dht attachment, when one is registered.
+func (sn *Node) attachedDHT() (dht, bool) {
+	s, ok := sn.Service(dhtServiceName)
+	if !ok {
+		return nil, false
+	}
+	d, ok := s.(dht)
+	return d, ok
+}
+
 // AttachDHT attaches a dht for the update function of the simulation node
 func (sn *Node) AttachDHT(dht dht) {
-	sn.dht = dht
+	sn.AttachService(dhtServiceName, dht)
+	if sized, ok := dht.(interface{ Size() int }); ok {
+		sn.ReportGauge("dht.routing_table", func() int64 { return int64(sized.Size()) })
+	}
 }
 
 // Update updates a node in the dht, it panics if no dht was declared
 func (sn *Node) Update(node2 node.Node) {
-	if sn.dht == nil {
+	d, ok := sn.attachedDHT()
+	if !ok {
 		panic("Tried to update without attaching dht")
 	}
-	sn.dht.Update(node2)
+	d.Update(node2)
+}
+
+// Stop takes the node offline gracefully - its ingress channels are closed,
+// sends to and from it fail with ErrNodeOffline and in-flight messages are
+// dropped. The node keeps its identity and can come back with Restart.
+func (sn *Node) Stop() {
+	sn.sim.mutex.Lock()
+	sn.sim.offline[sn.Node.String()] = true
+	for _, c := range sn.sim.protocolHandler[sn.Node.String()] {
+		close(c)
+	}
+	sn.sim.protocolHandler[sn.Node.String()] = make(map[string]chan service.Message)
+	sn.sim.mutex.Unlock()
+}
+
+// Crash takes the node offline without any graceful signaling - its ingress
+// channels stay open but nothing is delivered on them anymore.
+func (sn *Node) Crash() {
+	sn.sim.mutex.Lock()
+	sn.sim.offline[sn.Node.String()] = true
+	sn.sim.mutex.Unlock()
+}
+
+// Restart brings a stopped or crashed node back online under the same
+// identity. After a Stop the node registers its protocols again for fresh
+// ingress channels, after a Crash the old ones keep working.
+func (sn *Node) Restart() {
+	sn.sim.mutex.Lock()
+	delete(sn.sim.offline, sn.Node.String())
+	sn.sim.mutex.Unlock()
 }
 
 // Shutdown closes all node channels are remove it from the Simulator map
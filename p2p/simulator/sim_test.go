@@ -0,0 +1,540 @@
+package simulator
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/node"
+	"github.com/stretchr/testify/assert"
+)
+
+const testProtocol = "test/1.0/"
+
+func TestSimulatorLatency(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+	c := sim.NewNode()
+
+	bIn := b.RegisterProtocol(testProtocol)
+	cIn := c.RegisterProtocol(testProtocol)
+
+	sim.SetLatency(a.String(), b.String(), 50*time.Millisecond)
+	sim.SetLatency(b.String(), c.String(), 50*time.Millisecond)
+
+	// relay a message down the a -> b -> c chain and measure end to end time
+	start := time.Now()
+
+	err := a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send message")
+
+	msg := <-bIn
+	assert.Equal(t, a.String(), msg.Sender().String(), "unexpected sender")
+
+	err = b.SendMessage(c.String(), testProtocol, msg.Data())
+	assert.NoError(t, err, "failed to relay message")
+
+	msg = <-cIn
+	elapsed := time.Since(start)
+
+	assert.Equal(t, []byte("ping"), msg.Data(), "unexpected payload")
+	assert.True(t, elapsed >= 100*time.Millisecond, "expected two 50ms hops, got %v", elapsed)
+}
+
+func TestSimulatorZeroLatency(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+
+	bIn := b.RegisterProtocol(testProtocol)
+
+	// an unconfigured link delivers synchronously, as it always has
+	done := make(chan struct{})
+	go func() {
+		msg := <-bIn
+		assert.Equal(t, []byte("ping"), msg.Data(), "unexpected payload")
+		close(done)
+	}()
+
+	err := a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send message")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("zero-latency delivery did not arrive")
+	}
+}
+
+func TestSimulatorDropLink(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+
+	bIn := b.RegisterProtocol(testProtocol)
+	sim.SetFaults(a.String(), b.String(), Faults{DropProb: 1})
+
+	for i := 0; i < 10; i++ {
+		err := a.SendMessage(b.String(), testProtocol, []byte("ping"))
+		assert.NoError(t, err, "failed to send message")
+	}
+
+	select {
+	case <-bIn:
+		t.Fatal("received a message on a 100% drop link")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	stats := sim.LinkStats(a.String(), b.String())
+	assert.Equal(t, uint64(10), stats.Sent, "unexpected sent count")
+	assert.Equal(t, uint64(10), stats.Dropped, "unexpected dropped count")
+}
+
+func TestSimulatorDuplicationLink(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+
+	bIn := b.RegisterProtocol(testProtocol)
+	sim.SetFaults(a.String(), b.String(), Faults{DupProb: 1})
+
+	received := make(chan []byte, 10)
+	go func() {
+		for msg := range bIn {
+			received <- msg.Data()
+		}
+	}()
+
+	err := a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send message")
+
+	// the receiver sees exactly two copies
+	for i := 0; i < 2; i++ {
+		select {
+		case data := <-received:
+			assert.Equal(t, []byte("ping"), data, "unexpected payload")
+		case <-time.After(time.Second):
+			t.Fatal("missing a duplicated copy")
+		}
+	}
+	select {
+	case <-received:
+		t.Fatal("received more than two copies")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	stats := sim.LinkStats(a.String(), b.String())
+	assert.Equal(t, uint64(1), stats.Duplicated, "unexpected duplicated count")
+}
+
+func TestSimulatorDropEveryNth(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+
+	bIn := b.RegisterProtocol(testProtocol)
+	sim.SetFaults(a.String(), b.String(), Faults{DropEveryN: 3})
+
+	go func() {
+		for range bIn {
+		}
+	}()
+
+	for i := 0; i < 9; i++ {
+		err := a.SendMessage(b.String(), testProtocol, []byte("ping"))
+		assert.NoError(t, err, "failed to send message")
+	}
+
+	stats := sim.LinkStats(a.String(), b.String())
+	assert.Equal(t, uint64(3), stats.Dropped, "expected every 3rd message dropped")
+}
+
+// faultPattern sends count messages over a freshly built lossy link and
+// returns which of them were dropped.
+func faultPattern(seed int64, count int) []bool {
+	sim := New(WithFaultSeed(seed))
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+
+	bIn := b.RegisterProtocol(testProtocol)
+	go func() {
+		for range bIn {
+		}
+	}()
+
+	sim.SetFaults(a.String(), b.String(), Faults{DropProb: 0.5})
+
+	pattern := make([]bool, count)
+	var lastDropped uint64
+	for i := 0; i < count; i++ {
+		if err := a.SendMessage(b.String(), testProtocol, []byte("ping")); err != nil {
+			panic(err)
+		}
+		dropped := sim.LinkStats(a.String(), b.String()).Dropped
+		pattern[i] = dropped > lastDropped
+		lastDropped = dropped
+	}
+	return pattern
+}
+
+func TestSimulatorFaultsReproducible(t *testing.T) {
+	// the same seed produces the same drop sequence, a different seed doesn't
+	first := faultPattern(42, 50)
+	second := faultPattern(42, 50)
+	assert.Equal(t, first, second, "expected the same seed to reproduce the fault sequence")
+
+	third := faultPattern(43, 50)
+	assert.NotEqual(t, first, third, "expected a different seed to change the fault sequence")
+}
+
+func TestSimulatorFaultsScopedToLink(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+	c := sim.NewNode()
+
+	bIn := b.RegisterProtocol(testProtocol)
+	cIn := c.RegisterProtocol(testProtocol)
+
+	// a lossy a->b link must not affect the a->c link
+	sim.SetFaults(a.String(), b.String(), Faults{DropProb: 1})
+
+	done := make(chan struct{})
+	go func() {
+		<-cIn
+		close(done)
+	}()
+
+	err := a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send message")
+	err = a.SendMessage(c.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send message")
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the unrelated link did not deliver")
+	}
+	select {
+	case <-bIn:
+		t.Fatal("received a message on a 100% drop link")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSimulatorPartition(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+
+	aIn := a.RegisterProtocol(testProtocol)
+	bIn := b.RegisterProtocol(testProtocol)
+
+	sim.Partition([]string{a.String()}, []string{b.String()})
+
+	// cross-partition messages are dropped, not queued
+	err := a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send message")
+	select {
+	case <-bIn:
+		t.Fatal("received a message across a partition")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, uint64(1), sim.PartitionDrops(), "unexpected partition drop count")
+
+	// a node created after the partition joins a group and talks within it
+	c := sim.NewNode()
+	sim.AssignGroup(c.String(), 0)
+
+	done := make(chan struct{})
+	go func() {
+		<-aIn
+		close(done)
+	}()
+	err = c.SendMessage(a.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send message")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("intra-group message was not delivered")
+	}
+
+	// healing restores full connectivity
+	sim.Heal()
+	go func() {
+		<-bIn
+	}()
+	err = a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send message")
+	assert.Equal(t, uint64(1), sim.PartitionDrops(), "no drops expected after healing")
+}
+
+func TestSimulatorStopRestart(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+
+	bIn := b.RegisterProtocol(testProtocol)
+	closed := make(chan struct{})
+	go func() {
+		for range bIn {
+		}
+		close(closed)
+	}()
+
+	err := a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send message")
+
+	// a stopped neighbor errors on the sender side and signals its consumers
+	b.Stop()
+	err = a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.Equal(t, ErrNodeOffline, err, "expected an offline error")
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("stop did not close the ingress channel")
+	}
+
+	// after a restart the same identity receives again on a fresh channel
+	b.Restart()
+	bIn = b.RegisterProtocol(testProtocol)
+	done := make(chan struct{})
+	go func() {
+		<-bIn
+		close(done)
+	}()
+	err = a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send after restart")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered after restart")
+	}
+}
+
+func TestSimulatorCrashDropsInFlight(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+
+	bIn := b.RegisterProtocol(testProtocol)
+	sim.SetLatency(a.String(), b.String(), 50*time.Millisecond)
+
+	// the message is in flight when b crashes, so it must be dropped
+	err := a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send message")
+	b.Crash()
+
+	select {
+	case <-bIn:
+		t.Fatal("a crashed node received a message")
+	case <-time.After(200 * time.Millisecond):
+	}
+	assert.Equal(t, uint64(1), sim.OfflineDrops(b.String()), "unexpected offline drop count")
+
+	// a crashed node can't send either
+	err = b.SendMessage(a.String(), testProtocol, []byte("ping"))
+	assert.Equal(t, ErrNodeOffline, err, "expected an offline error")
+
+	// after a restart the old ingress channel works again
+	b.Restart()
+	done := make(chan struct{})
+	go func() {
+		<-bIn
+		close(done)
+	}()
+	err = a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send after restart")
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("message was not delivered after restart")
+	}
+}
+
+// countingDHT counts Update calls routed to it.
+type countingDHT struct {
+	mutex   sync.Mutex
+	updates int
+}
+
+func (c *countingDHT) Update(node.Node) {
+	c.mutex.Lock()
+	c.updates++
+	c.mutex.Unlock()
+}
+
+func (c *countingDHT) count() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.updates
+}
+
+// gossipStub is a second, unrelated attachment.
+type gossipStub struct{ name string }
+
+func TestNodeServiceRegistry(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+	drain(b.RegisterProtocol(testProtocol))
+
+	// two services on one node, each found under its own name
+	d := &countingDHT{}
+	g := &gossipStub{name: "gossiper"}
+	b.AttachDHT(d)
+	b.AttachService("gossip", g)
+
+	got, ok := b.Service("gossip")
+	assert.True(t, ok, "expected the gossip attachment")
+	assert.Equal(t, g, got, "wrong service routed")
+	_, ok = b.Service("sync")
+	assert.False(t, ok, "unexpected attachment")
+
+	// incoming messages are routed to the dht attachment
+	assert.NoError(t, a.SendMessage(b.String(), testProtocol, []byte("ping")))
+	assert.Equal(t, 1, d.count(), "expected the dht updated on ingress")
+}
+
+func TestNodeAttachServiceWhileFlowing(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+	drain(b.RegisterProtocol(testProtocol))
+
+	// attaching mid-traffic must be safe
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if err := a.SendMessage(b.String(), testProtocol, []byte("ping")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	d := &countingDHT{}
+	b.AttachDHT(d)
+	<-done
+
+	assert.True(t, d.count() > 0, "expected updates after attaching mid-flow")
+}
+
+func TestVirtualClock(t *testing.T) {
+	sim := New(WithVirtualClock())
+	clock := sim.Clock()
+
+	begin := clock.Now()
+	first := clock.After(time.Second)
+	second := clock.After(3 * time.Second)
+
+	// nothing fires before its deadline
+	sim.AdvanceTime(500 * time.Millisecond)
+	select {
+	case <-first:
+		t.Fatal("timer fired before its deadline")
+	default:
+	}
+
+	sim.AdvanceTime(500 * time.Millisecond)
+	select {
+	case <-first:
+	default:
+		t.Fatal("due timer did not fire")
+	}
+	select {
+	case <-second:
+		t.Fatal("timer fired before its deadline")
+	default:
+	}
+
+	// a multi-second virtual sleep costs no wall time
+	woke := make(chan struct{})
+	go func() {
+		clock.Sleep(10 * time.Second)
+		close(woke)
+	}()
+	time.Sleep(10 * time.Millisecond) // let the sleeper register
+	start := time.Now()
+	sim.AdvanceTime(10 * time.Second)
+	select {
+	case <-woke:
+	case <-time.After(time.Second):
+		t.Fatal("virtual sleep did not wake")
+	}
+	<-second
+	assert.True(t, time.Since(start) < time.Second, "advancing virtual time should not take wall time")
+	assert.Equal(t, 11*time.Second, clock.Now().Sub(begin), "unexpected virtual time")
+}
+
+func TestSimulatorDefaultLatencyAndJitter(t *testing.T) {
+	sim := New(WithDefaultLatency(30*time.Millisecond), WithJitter(10*time.Millisecond, 42))
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+
+	bIn := b.RegisterProtocol(testProtocol)
+
+	start := time.Now()
+	err := a.SendMessage(b.String(), testProtocol, []byte("ping"))
+	assert.NoError(t, err, "failed to send message")
+
+	<-bIn
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 30*time.Millisecond, "expected the default latency, got %v", elapsed)
+}
+
+func TestSimulatorMassNodeCreation(t *testing.T) {
+	sim := New()
+
+	// a settled population that keeps broadcasting throughout the churn
+	ready := make([]*Node, 100)
+	for i := range ready {
+		ready[i] = sim.NewNode()
+		drain(ready[i].RegisterProtocol(testProtocol))
+	}
+
+	var wg sync.WaitGroup
+
+	// 100 goroutines together create 1000 fresh nodes
+	for g := 0; g < 100; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				n := sim.NewNode()
+				drain(n.RegisterProtocol(testProtocol))
+				// delivery to an established node must keep working mid-churn
+				assert.NoError(t, n.SendMessage(ready[0].String(), testProtocol, []byte("hello")))
+			}
+		}()
+	}
+
+	for _, sn := range ready {
+		wg.Add(1)
+		go func(sn *Node) {
+			defer wg.Done()
+			assert.NoError(t, sn.Broadcast(testProtocol, []byte("gossip")))
+		}(sn)
+	}
+
+	wg.Wait()
+
+	// sending to an identity the simulator never saw fails cleanly
+	stranger := node.GenerateRandomNodeData()
+	assert.Error(t, ready[0].SendMessage(stranger.String(), testProtocol, []byte("hello")))
+}
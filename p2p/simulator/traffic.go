@@ -0,0 +1,139 @@
+package simulator
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+)
+
+// trafficRecord captures one delivered message. Payloads are kept as digests
+// so recording stays cheap enough to leave on by default.
+type trafficRecord struct {
+	from     string
+	to       string
+	protocol string
+	size     int
+	digest   []byte
+	when     time.Time
+}
+
+// trafficRecorder keeps a bounded log of delivered messages for test
+// assertions. With a cap set it behaves as a ring buffer over the most
+// recent deliveries.
+type trafficRecorder struct {
+	mutex   sync.Mutex
+	cap     int
+	records []trafficRecord
+	next    int // ring write position, only used once the cap is reached
+}
+
+// record appends a delivery to the log, evicting the oldest entry when the
+// cap is reached.
+func (r *trafficRecorder) record(rec trafficRecord) {
+	r.mutex.Lock()
+	if r.cap > 0 && len(r.records) == r.cap {
+		r.records[r.next] = rec
+		r.next = (r.next + 1) % r.cap
+	} else {
+		r.records = append(r.records, rec)
+	}
+	r.mutex.Unlock()
+}
+
+// snapshot returns the recorded deliveries in order.
+func (r *trafficRecorder) snapshot() []trafficRecord {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	out := make([]trafficRecord, 0, len(r.records))
+	out = append(out, r.records[r.next:]...)
+	out = append(out, r.records[:r.next]...)
+	return out
+}
+
+// reset discards all recorded deliveries.
+func (r *trafficRecorder) reset() {
+	r.mutex.Lock()
+	r.records = nil
+	r.next = 0
+	r.mutex.Unlock()
+}
+
+// WithTrafficCap bounds the traffic recorder to the n most recent deliveries.
+// Without it the recorder grows with the test.
+func WithTrafficCap(n int) Option {
+	return func(s *Simulator) {
+		s.traffic.cap = n
+	}
+}
+
+// Count returns how many messages were delivered on the given protocol.
+func (s *Simulator) Count(protocol string) int {
+	count := 0
+	for _, rec := range s.traffic.snapshot() {
+		if rec.protocol == protocol {
+			count++
+		}
+	}
+	return count
+}
+
+// CountBetween returns how many messages were delivered from one node to
+// another on the given protocol.
+func (s *Simulator) CountBetween(from, to, protocol string) int {
+	count := 0
+	for _, rec := range s.traffic.snapshot() {
+		if rec.from == from && rec.to == to && rec.protocol == protocol {
+			count++
+		}
+	}
+	return count
+}
+
+// Delivered returns the ids of the nodes that received the given message on
+// the given protocol, in delivery order. The message is identified by its
+// payload bytes.
+func (s *Simulator) Delivered(protocol string, msgID []byte) []string {
+	digest := crypto.Sha256(msgID)
+	var receivers []string
+	for _, rec := range s.traffic.snapshot() {
+		if rec.protocol == protocol && bytes.Equal(rec.digest, digest) {
+			receivers = append(receivers, rec.to)
+		}
+	}
+	return receivers
+}
+
+// TrafficRecord is one delivered message, as exposed to consumers outside the
+// package - the load-test harness computes its propagation report from these.
+type TrafficRecord struct {
+	From     string
+	To       string
+	Protocol string
+	Size     int
+	Digest   []byte
+	When     time.Time
+}
+
+// Traffic returns all recorded deliveries in order.
+func (s *Simulator) Traffic() []TrafficRecord {
+	records := s.traffic.snapshot()
+	out := make([]TrafficRecord, len(records))
+	for i, rec := range records {
+		out[i] = TrafficRecord{
+			From:     rec.from,
+			To:       rec.to,
+			Protocol: rec.protocol,
+			Size:     rec.size,
+			Digest:   rec.digest,
+			When:     rec.when,
+		}
+	}
+	return out
+}
+
+// ResetTraffic discards all recorded traffic.
+func (s *Simulator) ResetTraffic() {
+	s.traffic.reset()
+}
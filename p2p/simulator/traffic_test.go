@@ -0,0 +1,95 @@
+package simulator
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrafficRecorder(t *testing.T) {
+	sim := New()
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+	c := sim.NewNode()
+
+	bIn := b.RegisterProtocol(testProtocol)
+	cIn := c.RegisterProtocol(testProtocol)
+	other := b.RegisterProtocol("other/1.0/")
+	drain(bIn)
+	drain(cIn)
+	drain(other)
+
+	assert.NoError(t, a.SendMessage(b.String(), testProtocol, []byte("one")))
+	assert.NoError(t, a.SendMessage(b.String(), testProtocol, []byte("two")))
+	assert.NoError(t, a.SendMessage(b.String(), "other/1.0/", []byte("other")))
+	assert.NoError(t, a.Broadcast(testProtocol, []byte("cast")))
+
+	assert.Equal(t, 4, sim.Count(testProtocol), "unexpected protocol count")
+	assert.Equal(t, 1, sim.Count("other/1.0/"), "unexpected protocol count")
+	assert.Equal(t, 3, sim.CountBetween(a.String(), b.String(), testProtocol), "unexpected link count")
+	assert.Equal(t, 1, sim.CountBetween(a.String(), c.String(), testProtocol), "unexpected link count")
+
+	// the broadcast reached every other node exactly once
+	receivers := sim.Delivered(testProtocol, []byte("cast"))
+	assert.ElementsMatch(t, []string{b.String(), c.String()}, receivers, "unexpected broadcast receivers")
+
+	sim.ResetTraffic()
+	assert.Equal(t, 0, sim.Count(testProtocol), "expected no records after reset")
+}
+
+func TestTrafficRecorderCap(t *testing.T) {
+	sim := New(WithTrafficCap(5))
+
+	a := sim.NewNode()
+	b := sim.NewNode()
+	drain(b.RegisterProtocol(testProtocol))
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, a.SendMessage(b.String(), testProtocol, []byte(fmt.Sprintf("msg-%d", i))))
+	}
+
+	// only the most recent deliveries are kept
+	assert.Equal(t, 5, sim.Count(testProtocol), "expected the cap to bound the record")
+	assert.Empty(t, sim.Delivered(testProtocol, []byte("msg-0")), "expected the oldest delivery evicted")
+	assert.Len(t, sim.Delivered(testProtocol, []byte("msg-9")), 1, "expected the newest delivery kept")
+}
+
+func TestTrafficRecorderConcurrent(t *testing.T) {
+	const senders = 8
+	const perSender = 25
+
+	sim := New()
+	b := sim.NewNode()
+	drain(b.RegisterProtocol(testProtocol))
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		a := sim.NewNode()
+		wg.Add(1)
+		go func(a *Node) {
+			defer wg.Done()
+			for j := 0; j < perSender; j++ {
+				if err := a.SendMessage(b.String(), testProtocol, []byte("ping")); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(a)
+	}
+	wg.Wait()
+
+	assert.Equal(t, senders*perSender, sim.Count(testProtocol), "lost records under concurrent delivery")
+	assert.Len(t, sim.Delivered(testProtocol, []byte("ping")), senders*perSender, "unexpected delivery log")
+}
+
+// drain consumes every message arriving on the channel for the rest of the test.
+func drain(c chan service.Message) {
+	go func() {
+		for range c {
+		}
+	}()
+}
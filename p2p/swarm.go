@@ -18,6 +18,7 @@ import (
 	"github.com/spacemeshos/go-spacemesh/p2p/node"
 	"github.com/spacemeshos/go-spacemesh/p2p/pb"
 	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/p2p/trace"
 	"github.com/spacemeshos/go-spacemesh/timesync"
 	"strconv"
 	"sync"
@@ -57,6 +58,12 @@ type swarm struct {
 
 	gossip gossip.Protocol
 
+	// dedupes signed envelopes within the replay validity window
+	replay *replayGuard
+
+	// pools author-signature checks from the ingress queues into batches
+	verifier *batchVerifier
+
 	network *net.Net
 
 	cPool *connectionpool.ConnectionPool
@@ -88,6 +95,10 @@ func (s *swarm) waitForGossip() error {
 // and not load from disk. it creates a new `net`, connection pool and dht.
 func newSwarm(ctx context.Context, config config.Config, newNode bool, persist bool) (*swarm, error) {
 
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	port := config.TCPPort
 	address := inet.JoinHostPort("0.0.0.0", strconv.Itoa(port))
 
@@ -116,15 +127,28 @@ func newSwarm(ctx context.Context, config config.Config, newNode bool, persist b
 		bootChan:         make(chan struct{}),
 		gossipC:          make(chan struct{}),
 		protocolHandlers: make(map[string]chan service.Message),
+		replay:           newReplayGuard(config.MsgMaxAge, config.MsgMaxSkew, config.MsgSkewGrace, replaySeenCacheSize),
 		network:          n,
 		cPool:            connectionpool.NewConnectionPool(n, l.PublicKey()),
 		shutdown:         make(chan struct{}), // non-buffered so requests to shutdown block until swarm is shut down
 		ctx:              ctx,
 	}
 
-	s.dht = dht.New(l, config.SwarmConfig, s)
+	s.verifier = newBatchVerifier(s.shutdown)
 
-	s.gossip = gossip.NewNeighborhood(config.SwarmConfig, s.dht, s.cPool, s.lNode.Log)
+	s.cPool.SetMaxConnections(config.MaxOpenConnections)
+
+	s.dht, err = dht.New(l, config.SwarmConfig, s)
+	if err != nil {
+		return nil, fmt.Errorf("can't create swarm without a dht, err: %v", err)
+	}
+
+	ngh, err := gossip.NewNeighborhood(config.SwarmConfig, s.dht, s.cPool, s.lNode.Log)
+	if err != nil {
+		return nil, fmt.Errorf("can't create swarm without gossip, err: %v", err)
+	}
+	ngh.SetLocalID(l.String())
+	s.gossip = ngh
 
 	s.lNode.Debug("Created swarm for local node %s, %s", l.Address(), l.Pretty())
 
@@ -194,7 +218,7 @@ func (s *swarm) SendMessage(peerPubKey string, protocol string, payload []byte)
 	var conn net.Connection
 
 	peer, conn = s.gossip.Peer(peerPubKey) // check if he's a neighbor
-	if peer == node.EmptyNode {
+	if peer.IsEmpty() {
 		peer, err = s.dht.Lookup(peerPubKey) // blocking, might issue a network lookup that'll take time.
 
 		if err != nil {
@@ -232,7 +256,7 @@ func (s *swarm) SendMessage(peerPubKey string, protocol string, payload []byte)
 
 	// messages must be sent in the same order as the order that the messages were encrypted because the iv used to encrypt
 	// (and therefore decrypt) is the last encrypted block of the previous message that were encrypted
-	final, err := message.PrepareMessage(session, data)
+	final, err := message.PrepareMessage(session, protocol, data)
 
 	if err != nil {
 		session.EncryptGuard().Unlock()
@@ -247,6 +271,9 @@ func (s *swarm) SendMessage(peerPubKey string, protocol string, payload []byte)
 	err = conn.Send(final)
 	session.EncryptGuard().Unlock()
 
+	if err == nil {
+		s.cPool.CountProtocolMessage(protocol, net.FramedSize(len(final)), true)
+	}
 	return err
 }
 
@@ -269,6 +296,7 @@ func (s *swarm) Shutdown() {
 // shutdown gracefully shuts down swarm services.
 func (s *swarm) shutdownInternal() {
 	//TODO : Gracefully shutdown swarm => finish incmoing / outgoing msgs
+	s.dht.Shutdown()
 	s.network.Shutdown()
 }
 
@@ -407,7 +435,7 @@ func (s *swarm) onRemoteClientMessage(msg net.IncomingMessageEvent) error {
 		return ErrNoSession
 	}
 
-	decPayload, err := session.Decrypt(c.Payload)
+	decPayload, err := message.OpenPayload(session, c)
 	if err != nil {
 		return ErrFailDecrypt
 	}
@@ -418,12 +446,19 @@ func (s *swarm) onRemoteClientMessage(msg net.IncomingMessageEvent) error {
 		s.lNode.Errorf("proto marshinling err=", err)
 		return ErrBadFormat2
 	}
+
+	// on aead sessions the outer protocol was authenticated with the seal -
+	// an inner metadata naming another protocol is tampering
+	if c.Protocol != "" && pm.Metadata != nil && pm.Metadata.Protocol != c.Protocol {
+		return ErrBadFormat2
+	}
 	if pm.Metadata == nil {
 		spew.Dump(pm)
 		panic("this is a defected message") // todo: Session bug, session scrambles messages and remove metadata
 	}
-	// authenticate message author - we already authenticated the sender via the shared session key secret
-	err = message.AuthAuthor(pm)
+	// authenticate message author - we already authenticated the sender via the shared session key secret.
+	// the check is pooled with whatever else the other ingress queues have in flight
+	err = s.verifier.verify(pm)
 	if err != nil {
 		return ErrAuthAuthor
 	}
@@ -433,7 +468,24 @@ func (s *swarm) onRemoteClientMessage(msg net.IncomingMessageEvent) error {
 		return ErrNotFromPeer
 	}
 
+	// the authoring timestamp is covered by the author's signature - a frame
+	// captured and replayed after the validity window is stale, within it the
+	// seen-cache ensures each envelope is handled (and relayed) exactly once
+	if err := s.replay.check(string(pm.Metadata.AuthPubKey), decPayload, pm.Metadata.Timestamp); err != nil {
+		if err == ErrReplayedMsg && pm.Metadata.Gossip {
+			// a duplicate gossip envelope arrives innocently via another
+			// neighbor - drop it without penalizing the relaying connection
+			s.lNode.Debug("Dropping already handled gossip message")
+			return nil
+		}
+		return err
+	}
+
 	s.lNode.Debug("Authorized %v protocol message ", pm.Metadata.Protocol)
+	s.cPool.CountProtocolMessage(pm.Metadata.Protocol, net.FramedSize(len(msg.Message)), false)
+
+	tid := trace.IDFromBytes(pm.Metadata.TraceID)
+	trace.Emit(trace.Event{TraceID: tid, Node: s.lNode.String(), Layer: trace.LayerNet, Op: "receive", Peer: msg.Conn.RemotePublicKey().String(), Protocol: pm.Metadata.Protocol})
 
 	remoteNode := node.New(msg.Conn.RemotePublicKey(), "") // if we got so far, we already have the node in our rt, hence address won't be used
 	// update the routing table - we just heard from this authenticated node
@@ -442,12 +494,16 @@ func (s *swarm) onRemoteClientMessage(msg net.IncomingMessageEvent) error {
 	// participate in gossip even if we don't know this protocol
 	if pm.Metadata.Gossip { // todo : use gossip uid
 		s.LocalNode().Debug("Got gossip message! relaying it")
+		trace.Emit(trace.Event{TraceID: tid, Node: s.lNode.String(), Layer: trace.LayerGossip, Op: "relay", Peer: msg.Conn.RemotePublicKey().String(), Protocol: pm.Metadata.Protocol})
 		// don't block anyway
-		err = s.gossip.Broadcast(decPayload) // err only if this is an old message
-	}
-
-	if err != nil {
-		return nil
+		if _, err := s.gossip.BroadcastTraced(pm.Metadata.Protocol, decPayload, tid); err != nil {
+			if err == gossip.ErrOldMessage {
+				// we already handled this message the first time we saw it
+				return nil
+			}
+			// the relay found no takers - the message is still handled locally
+			s.lNode.Warning("failed relaying gossip message: %v", err)
+		}
 	}
 	// route authenticated message to the reigstered protocol
 	s.protocolHandlerMutex.RLock()
@@ -461,6 +517,7 @@ func (s *swarm) onRemoteClientMessage(msg net.IncomingMessageEvent) error {
 
 	s.lNode.Debug("Forwarding message to protocol")
 
+	trace.Emit(trace.Event{TraceID: tid, Node: s.lNode.String(), Layer: trace.LayerProtocol, Op: "handle", Peer: msg.Conn.RemotePublicKey().String(), Protocol: pm.Metadata.Protocol})
 	msgchan <- protocolMessage{remoteNode, pm.Payload}
 
 	return nil
@@ -474,6 +531,11 @@ func (s *swarm) Broadcast(protocol string, payload []byte) error {
 		Payload:  payload,
 	}
 
+	// a trace id born here rides inside the signed metadata, so relays carry
+	// it on untouched. with tracing off no trace bytes reach the wire.
+	tid := trace.MaybeNewID()
+	pm.Metadata.TraceID = tid.Bytes()
+
 	err := message.SignMessage(s.lNode.PrivateKey(), pm)
 	if err != nil {
 		return err
@@ -485,5 +547,7 @@ func (s *swarm) Broadcast(protocol string, payload []byte) error {
 		return err
 	}
 
-	return s.gossip.Broadcast(msg)
+	trace.Emit(trace.Event{TraceID: tid, Node: s.lNode.String(), Layer: trace.LayerGossip, Op: "broadcast", Protocol: protocol})
+	_, err = s.gossip.BroadcastTraced(protocol, msg, tid)
+	return err
 }
@@ -0,0 +1,193 @@
+// Package trace follows individual messages across nodes and layers. A
+// 16-byte trace id is drawn where a message is born - Broadcast or
+// SendRequest - rides in the wire envelopes, and every layer that touches the
+// message emits an event carrying it. Collecting the events from all nodes
+// reconstructs the message's full path. Tracing is off by default and the
+// wire field is absent while it is off.
+package trace
+
+import (
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/crypto"
+	"github.com/spacemeshos/go-spacemesh/log"
+)
+
+// IDSize is the trace id length in bytes.
+const IDSize = 16
+
+// ID is a random identifier correlating one logical message across hops. The
+// zero value marks an untraced message.
+type ID [IDSize]byte
+
+// EmptyID is the id of an untraced message.
+var EmptyID ID
+
+// NewID draws a fresh random trace id. It fails instead of returning a zero
+// value when the entropy source does, since a zero id means untraced.
+func NewID() (ID, error) {
+	var id ID
+	if err := crypto.GetRandomBytesToBuffer(len(id), id[:]); err != nil {
+		return EmptyID, err
+	}
+	return id, nil
+}
+
+// IDFromBytes rebuilds a trace id from its wire form. Anything but exactly
+// IDSize bytes - including the absent field - is the untraced id.
+func IDFromBytes(b []byte) ID {
+	if len(b) != IDSize {
+		return EmptyID
+	}
+	var id ID
+	copy(id[:], b)
+	return id
+}
+
+// IsEmpty reports whether the id marks an untraced message.
+func (id ID) IsEmpty() bool {
+	return id == EmptyID
+}
+
+// Bytes returns the id in its wire form - nil when untraced, so the wire
+// field is dropped entirely.
+func (id ID) Bytes() []byte {
+	if id.IsEmpty() {
+		return nil
+	}
+	return id[:]
+}
+
+// String renders the id as hex for logs.
+func (id ID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Layer names the stack layer an event was emitted from.
+const (
+	LayerGossip   = "gossip"
+	LayerProtocol = "protocol"
+	LayerNet      = "net"
+)
+
+// Event is one observation of a traced message at one layer of one node.
+type Event struct {
+	TraceID  ID
+	Node     string // local node id
+	Layer    string // LayerGossip, LayerProtocol or LayerNet
+	Op       string // what happened, e.g. "broadcast", "relay", "handle"
+	Peer     string // remote peer when the event involves one
+	Protocol string
+	Time     time.Time
+}
+
+// Tracer receives trace events. Implementations must be safe for concurrent
+// use and must not block - events are emitted inline on message paths.
+type Tracer interface {
+	Trace(ev Event)
+}
+
+var (
+	mu     sync.RWMutex
+	tracer Tracer
+)
+
+// Enable turns tracing on, delivering events to t. New messages start
+// carrying trace ids on the wire from here on.
+func Enable(t Tracer) {
+	mu.Lock()
+	tracer = t
+	mu.Unlock()
+}
+
+// Disable turns tracing off. Messages born after this carry no trace bytes.
+func Disable() {
+	mu.Lock()
+	tracer = nil
+	mu.Unlock()
+}
+
+// On reports whether tracing is enabled.
+func On() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return tracer != nil
+}
+
+// MaybeNewID draws a trace id when tracing is enabled and returns EmptyID -
+// no wire bytes - when it is off or the entropy source fails. Message origins
+// call it so the off switch is a single place.
+func MaybeNewID() ID {
+	if !On() {
+		return EmptyID
+	}
+	id, err := NewID()
+	if err != nil {
+		return EmptyID
+	}
+	return id
+}
+
+// Emit delivers an event for a traced message to the tracer and the
+// structured logger. Untraced messages and disabled tracing are no-ops, so
+// call sites need no guard.
+func Emit(ev Event) {
+	if ev.TraceID.IsEmpty() {
+		return
+	}
+	mu.RLock()
+	t := tracer
+	mu.RUnlock()
+	if t == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	t.Trace(ev)
+	log.With(
+		log.String("trace_id", ev.TraceID.String()),
+		log.NodeID(ev.Node),
+		log.String("layer", ev.Layer),
+		log.String("peer_id", ev.Peer),
+		log.String("protocol", ev.Protocol),
+	).Debug(ev.Op)
+}
+
+// Collector is a Tracer that stores events in arrival order, for tests and
+// debug tooling that reconstruct message paths.
+type Collector struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Trace implements Tracer.
+func (c *Collector) Trace(ev Event) {
+	c.mu.Lock()
+	c.events = append(c.events, ev)
+	c.mu.Unlock()
+}
+
+// Events returns a snapshot of the collected events.
+func (c *Collector) Events() []Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Event(nil), c.events...)
+}
+
+// Path returns the ordered node ids a trace id passed through, collapsing
+// consecutive events on the same node into one hop.
+func (c *Collector) Path(id ID) []string {
+	var path []string
+	for _, ev := range c.Events() {
+		if ev.TraceID != id {
+			continue
+		}
+		if len(path) == 0 || path[len(path)-1] != ev.Node {
+			path = append(path, ev.Node)
+		}
+	}
+	return path
+}
@@ -0,0 +1,118 @@
+package trace
+
+import (
+	"testing"
+
+	"github.com/spacemeshos/go-spacemesh/p2p/service"
+	"github.com/spacemeshos/go-spacemesh/p2p/simulator"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIDRoundTrip(t *testing.T) {
+	id, err := NewID()
+	assert.NoError(t, err)
+	assert.False(t, id.IsEmpty())
+	assert.Len(t, id.Bytes(), IDSize)
+	assert.Equal(t, id, IDFromBytes(id.Bytes()))
+
+	id2, err := NewID()
+	assert.NoError(t, err)
+	assert.NotEqual(t, id, id2)
+}
+
+func TestEmptyIDHasNoWireBytes(t *testing.T) {
+	assert.True(t, EmptyID.IsEmpty())
+	assert.Nil(t, EmptyID.Bytes(), "an untraced message must put no trace bytes on the wire")
+	assert.Equal(t, EmptyID, IDFromBytes(nil), "the absent wire field reads back as untraced")
+	assert.Equal(t, EmptyID, IDFromBytes([]byte{1, 2, 3}), "a truncated id is treated as untraced")
+}
+
+func TestDisabledTracingDrawsNoIDs(t *testing.T) {
+	Disable()
+	assert.False(t, On())
+	assert.True(t, MaybeNewID().IsEmpty())
+
+	var c Collector
+	Enable(&c)
+	defer Disable()
+	assert.True(t, On())
+	assert.False(t, MaybeNewID().IsEmpty())
+}
+
+func TestEmitIgnoresUntracedAndDisabled(t *testing.T) {
+	var c Collector
+	Enable(&c)
+	defer Disable()
+
+	Emit(Event{TraceID: EmptyID, Node: "n1", Layer: LayerGossip, Op: "broadcast"})
+	assert.Empty(t, c.Events(), "untraced messages emit no events")
+
+	Disable()
+	id, _ := NewID()
+	Emit(Event{TraceID: id, Node: "n1", Layer: LayerGossip, Op: "broadcast"})
+	assert.Empty(t, c.Events(), "a disabled tracer receives nothing")
+}
+
+// TestTracedBroadcastAcrossHops relays one traced message along a 4-node
+// simulator chain - each hop reads the trace id from the envelope and emits
+// its events, exactly as the real layers do - then reconstructs the full path
+// from the collected events.
+func TestTracedBroadcastAcrossHops(t *testing.T) {
+	var c Collector
+	Enable(&c)
+	defer Disable()
+
+	sim := simulator.New()
+	const protocol = "trace/test/1.0"
+	const hops = 4
+
+	nodes := make([]*simulator.Node, hops)
+	chans := make([]chan service.Message, hops)
+	for i := range nodes {
+		nodes[i] = sim.NewNode()
+		chans[i] = nodes[i].RegisterProtocol(protocol)
+	}
+
+	// each intermediate hop relays to the next node in the chain, emitting
+	// the same receive/relay pair the swarm emits
+	done := make(chan ID)
+	for i := 1; i < hops; i++ {
+		go func(i int) {
+			msg := <-chans[i]
+			tid := IDFromBytes(msg.Data()[:IDSize])
+			local := nodes[i].PublicKey().String()
+			Emit(Event{TraceID: tid, Node: local, Layer: LayerNet, Op: "receive", Peer: msg.Sender().String(), Protocol: protocol})
+			if i < hops-1 {
+				Emit(Event{TraceID: tid, Node: local, Layer: LayerGossip, Op: "relay", Protocol: protocol})
+				assert.NoError(t, nodes[i].SendMessage(nodes[i+1].PublicKey().String(), protocol, msg.Data()))
+				return
+			}
+			Emit(Event{TraceID: tid, Node: local, Layer: LayerProtocol, Op: "handle", Protocol: protocol})
+			done <- tid
+		}(i)
+	}
+
+	// the origin draws the id exactly like Broadcast does and prepends it to
+	// the payload as its envelope
+	tid := MaybeNewID()
+	assert.False(t, tid.IsEmpty())
+	origin := nodes[0].PublicKey().String()
+	Emit(Event{TraceID: tid, Node: origin, Layer: LayerGossip, Op: "broadcast", Protocol: protocol})
+	payload := append(tid.Bytes(), []byte("traced payload")...)
+	assert.NoError(t, nodes[0].SendMessage(nodes[1].PublicKey().String(), protocol, payload))
+
+	arrived := <-done
+	assert.Equal(t, tid, arrived, "the id born at broadcast reaches the last hop intact")
+
+	want := make([]string, hops)
+	for i, n := range nodes {
+		want[i] = n.PublicKey().String()
+	}
+	assert.Equal(t, want, c.Path(tid), "the collected events reconstruct the hop order")
+
+	// an untraced message contributes no trace bytes and no events
+	Disable()
+	untraced := MaybeNewID()
+	assert.Empty(t, untraced.Bytes())
+	assert.Equal(t, len([]byte("payload")), len(append(untraced.Bytes(), []byte("payload")...)))
+}
@@ -2,6 +2,7 @@ package ping
 
 import (
 	"errors"
+	"fmt"
 	"github.com/gogo/protobuf/proto"
 	"github.com/spacemeshos/go-spacemesh/crypto"
 	"github.com/spacemeshos/go-spacemesh/log"
@@ -38,7 +39,7 @@ var errPingTimedOut = errors.New("Ping took too long to response")
 type Ping struct {
 	p2p p2p.Service
 
-	pending    map[crypto.UUID]chan *pb.Ping
+	pending    map[crypto.RequestID]chan *pb.Ping
 	pendMuxtex sync.RWMutex
 
 	ingressChannel chan service.Message
@@ -46,7 +47,7 @@ type Ping struct {
 
 // New creates new ping instance, receives p2p as network infra
 func New(p2p p2p.Service) *Ping {
-	p := &Ping{pending: make(map[crypto.UUID]chan *pb.Ping)}
+	p := &Ping{pending: make(map[crypto.RequestID]chan *pb.Ping)}
 	p.p2p = p2p
 	p.ingressChannel = p2p.RegisterProtocol(protocol)
 	go p.readLoop()
@@ -87,9 +88,12 @@ func (p *Ping) readLoop() {
 // Ping sends actual pings to target
 func (p *Ping) Ping(target, msg string) (string, error) {
 	var response string
-	reqid := crypto.NewUUID()
+	reqid, err := crypto.NewRequestID()
+	if err != nil {
+		return response, fmt.Errorf("failed to draw a ping request id: %v", err)
+	}
 	ping := &pb.Ping{
-		ReqID:   reqid[:],
+		ReqID:   reqid.Bytes(),
 		Req:     true,
 		Message: msg,
 	}
@@ -112,7 +116,7 @@ func (p *Ping) Ping(target, msg string) (string, error) {
 	return response, nil
 }
 
-func (p *Ping) sendRequest(target string, reqid crypto.UUID, ping *pb.Ping) (chan *pb.Ping, error) {
+func (p *Ping) sendRequest(target string, reqid crypto.RequestID, ping *pb.Ping) (chan *pb.Ping, error) {
 	pchan := make(chan *pb.Ping)
 	p.pendMuxtex.Lock()
 	p.pending[reqid] = pchan
@@ -163,9 +167,7 @@ func (p *Ping) handleRequest(sender Pinger, ping *pb.Ping) error {
 }
 
 func (p *Ping) handleResponse(ping *pb.Ping) {
-	reqid := ping.ReqID
-	var creqid crypto.UUID
-	copy(creqid[:], reqid)
+	creqid := crypto.RequestIDFromBytes(ping.ReqID)
 	p.pendMuxtex.RLock()
 	c, ok := p.pending[creqid]
 	p.pendMuxtex.RUnlock()
@@ -0,0 +1,198 @@
+// Package taskgroup supervises long-lived goroutines. A task started through
+// a group can't take the process down on an unexpected panic - the panic is
+// converted to an error and logged with its stack - and the group restarts
+// failed tasks according to their policy, shuts everything down together and
+// reports per-task status for introspection.
+package taskgroup
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spacemeshos/go-spacemesh/log"
+)
+
+// Policy decides what happens after a task fails - returns a non-nil error
+// or panics.
+type Policy struct {
+	MaxRestarts int           // restarts allowed before the task gives up
+	Backoff     time.Duration // delay before the first restart, doubled per consecutive restart
+}
+
+// Never is the default policy - a failed task is not restarted.
+var Never = Policy{}
+
+// Backoff restarts a failed task up to maxRestarts times, waiting initial
+// before the first restart and doubling the wait for each one after.
+func Backoff(maxRestarts int, initial time.Duration) Policy {
+	return Policy{MaxRestarts: maxRestarts, Backoff: initial}
+}
+
+// TaskStatus is one task's supervision state, as exposed to the status
+// endpoint.
+type TaskStatus struct {
+	Name      string `json:"name"`
+	Running   bool   `json:"running"`
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Reporter exposes a group's task status without handing out the group
+// itself.
+type Reporter interface {
+	Status() []TaskStatus
+}
+
+// task is the supervision record of one goroutine.
+type task struct {
+	name     string
+	running  bool
+	restarts int
+	lastErr  error
+}
+
+// Group supervises a set of tasks sharing one lifecycle - its context is
+// canceled when the group shuts down and Shutdown waits for every task to
+// return.
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mutex sync.Mutex
+	tasks map[string]*task
+}
+
+// New creates an empty task group.
+func New() *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{ctx: ctx, cancel: cancel, tasks: make(map[string]*task)}
+}
+
+// Go starts f as a supervised task that is never restarted. The task must
+// return when its context is canceled.
+func (g *Group) Go(name string, f func(ctx context.Context) error) {
+	g.GoWithPolicy(name, Never, f)
+}
+
+// GoWithPolicy starts f as a supervised task with the given restart policy.
+// Starting a task under a name used before replaces that task's status
+// record, so a reconnecting component reuses its slot. After Shutdown new
+// tasks are not started.
+func (g *Group) GoWithPolicy(name string, policy Policy, f func(ctx context.Context) error) {
+	g.mutex.Lock()
+	if g.ctx.Err() != nil {
+		g.mutex.Unlock()
+		log.Warning("task %v not started - the group is shut down", name)
+		return
+	}
+	t := &task{name: name, running: true}
+	g.tasks[name] = t
+	g.wg.Add(1)
+	g.mutex.Unlock()
+
+	go g.supervise(t, policy, f)
+}
+
+// supervise runs the task through its restart policy until it succeeds,
+// exhausts its restarts or the group shuts down.
+func (g *Group) supervise(t *task, policy Policy, f func(ctx context.Context) error) {
+	defer g.wg.Done()
+
+	backoff := policy.Backoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+
+	for restarts := 0; ; restarts++ {
+		err := runRecovered(t.name, f, g.ctx)
+
+		g.mutex.Lock()
+		t.lastErr = err
+		done := err == nil || g.ctx.Err() != nil || restarts >= policy.MaxRestarts
+		if done {
+			t.running = false
+		} else {
+			t.restarts++
+		}
+		g.mutex.Unlock()
+
+		if done {
+			if err != nil && policy.MaxRestarts > 0 && g.ctx.Err() == nil {
+				log.Error("task %v gave up after %v restarts: %v", t.name, restarts, err)
+			}
+			return
+		}
+
+		log.Warning("task %v failed, restarting in %v: %v", t.name, backoff, err)
+		select {
+		case <-g.ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// runRecovered runs one incarnation of a task, converting a panic into an
+// error and logging it with its stack.
+func runRecovered(name string, f func(ctx context.Context) error, ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+			log.Error("task %v panicked: %v\n%s", name, r, debug.Stack())
+		}
+	}()
+	return f(ctx)
+}
+
+// Shutdown cancels the group's context and waits for every task to return,
+// bounded by ctx. Tasks still running past the deadline are abandoned and
+// counted in the returned error.
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		g.mutex.Lock()
+		var stuck []string
+		for _, t := range g.tasks {
+			if t.running {
+				stuck = append(stuck, t.name)
+			}
+		}
+		g.mutex.Unlock()
+		sort.Strings(stuck)
+		return fmt.Errorf("shutdown incomplete, tasks still running: %v", stuck)
+	}
+}
+
+// Status returns every task's supervision state, sorted by name. Completed
+// tasks stay listed with Running false, so a crashed loop is visible after
+// the fact.
+func (g *Group) Status() []TaskStatus {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	out := make([]TaskStatus, 0, len(g.tasks))
+	for _, t := range g.tasks {
+		status := TaskStatus{Name: t.name, Running: t.running, Restarts: t.restarts}
+		if t.lastErr != nil {
+			status.LastError = t.lastErr.Error()
+		}
+		out = append(out, status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
@@ -0,0 +1,173 @@
+package taskgroup
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// statusOf finds one task's status in a group.
+func statusOf(g *Group, name string) (TaskStatus, bool) {
+	for _, s := range g.Status() {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return TaskStatus{}, false
+}
+
+// eventually polls f until it holds or the wait budget runs out.
+func eventually(t *testing.T, f func() bool, msg string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if f() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+func TestPanicRecoveryWithRestart(t *testing.T) {
+	g := New()
+
+	var runs int32
+	g.GoWithPolicy("flaky", Backoff(3, time.Millisecond), func(ctx context.Context) error {
+		if atomic.AddInt32(&runs, 1) == 1 {
+			panic("first run blows up")
+		}
+		return nil
+	})
+
+	// the panic doesn't take the process down, the task is restarted and
+	// completes on its second run
+	eventually(t, func() bool {
+		s, ok := statusOf(g, "flaky")
+		return ok && !s.Running
+	}, "task did not complete")
+
+	s, _ := statusOf(g, "flaky")
+	assert.Equal(t, 1, s.Restarts, "expected one restart after the panic")
+	assert.Empty(t, s.LastError, "a successful final run clears the error")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&runs))
+
+	assert.NoError(t, g.Shutdown(context.TODO()))
+}
+
+func TestRestartLimitExhaustion(t *testing.T) {
+	g := New()
+
+	var runs int32
+	g.GoWithPolicy("hopeless", Backoff(2, time.Millisecond), func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return errors.New("always fails")
+	})
+
+	eventually(t, func() bool {
+		s, ok := statusOf(g, "hopeless")
+		return ok && !s.Running
+	}, "task did not give up")
+
+	s, _ := statusOf(g, "hopeless")
+	assert.Equal(t, 2, s.Restarts, "expected the restart limit to be used up")
+	assert.Equal(t, "always fails", s.LastError, "the final failure must be reported")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&runs), "initial run plus two restarts")
+
+	assert.NoError(t, g.Shutdown(context.TODO()))
+}
+
+func TestNeverPolicyDoesNotRestart(t *testing.T) {
+	g := New()
+
+	var runs int32
+	g.Go("once", func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return errors.New("failed")
+	})
+
+	eventually(t, func() bool {
+		s, ok := statusOf(g, "once")
+		return ok && !s.Running
+	}, "task did not complete")
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&runs), "a Never task must not be restarted")
+	s, _ := statusOf(g, "once")
+	assert.Equal(t, 0, s.Restarts)
+	assert.Equal(t, "failed", s.LastError)
+}
+
+func TestGroupShutdown(t *testing.T) {
+	g := New()
+
+	// tasks that block until the group's context is canceled, recording
+	// their exit
+	var exited int32
+	for _, name := range []string{"a", "b", "c"} {
+		g.Go(name, func(ctx context.Context) error {
+			<-ctx.Done()
+			atomic.AddInt32(&exited, 1)
+			return nil
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, g.Shutdown(ctx), "shutdown failed")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&exited), "all tasks must exit before Shutdown returns")
+
+	for _, s := range g.Status() {
+		assert.False(t, s.Running, "task %v still running after shutdown", s.Name)
+	}
+
+	// starting after shutdown is a no-op
+	g.Go("late", func(ctx context.Context) error { return nil })
+	_, ok := statusOf(g, "late")
+	assert.False(t, ok, "a task must not start after shutdown")
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	g := New()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	// a task ignoring its context holds shutdown to the deadline, not forever
+	g.Go("stuck", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := g.Shutdown(ctx)
+	assert.Error(t, err, "expected a deadline error")
+	assert.Contains(t, err.Error(), "stuck", "the stuck task must be named")
+	assert.True(t, time.Since(start) < time.Second, "shutdown must respect the deadline")
+}
+
+func TestStatusSorted(t *testing.T) {
+	g := New()
+	for _, name := range []string{"c", "a", "b"} {
+		g.Go(name, func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		})
+	}
+
+	status := g.Status()
+	assert.Len(t, status, 3)
+	assert.Equal(t, "a", status[0].Name)
+	assert.Equal(t, "b", status[1].Name)
+	assert.Equal(t, "c", status[2].Name)
+	for _, s := range status {
+		assert.True(t, s.Running)
+	}
+
+	assert.NoError(t, g.Shutdown(context.TODO()))
+}
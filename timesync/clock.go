@@ -0,0 +1,35 @@
+package timesync
+
+import (
+	"time"
+)
+
+// Clock is an injectable source of time. Components that take a Clock instead
+// of calling the time package directly can be driven deterministically in
+// tests by a virtual implementation.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel that receives the current time once d has elapsed.
+	After(d time.Duration) <-chan time.Time
+	// Sleep blocks until d has elapsed.
+	Sleep(d time.Duration)
+}
+
+// RealClock is the Clock implementation backed by the system clock.
+type RealClock struct{}
+
+// Now returns the current system time.
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// After waits for the duration to elapse on the system clock.
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Sleep pauses the calling goroutine for the given duration.
+func (RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
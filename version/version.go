@@ -1,5 +1,10 @@
 package version
 
+import (
+	"sort"
+	"sync"
+)
+
 // Version components
 const (
 	Maj = "0"
@@ -14,6 +19,8 @@ var (
 	Branch = ""
 	// GitCommit is the current HEAD set using ldflags.
 	GitCommit string
+	// BuildTime is the build timestamp set using ldflags.
+	BuildTime string
 )
 
 func init() {
@@ -21,3 +28,82 @@ func init() {
 		Version += "-" + GitCommit
 	}
 }
+
+// BuildInfo bundles what this binary was built from and which optional
+// features it runs with - the first thing to compare when two differently
+// built nodes misbehave against each other.
+type BuildInfo struct {
+	Version   string   `json:"version"`
+	Branch    string   `json:"branch,omitempty"`
+	Commit    string   `json:"commit,omitempty"`
+	BuildTime string   `json:"buildTime,omitempty"`
+	Features  []string `json:"features,omitempty"`
+}
+
+// Info returns the binary's build information and the features currently
+// registered as active.
+func Info() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		Branch:    Branch,
+		Commit:    GitCommit,
+		BuildTime: BuildTime,
+		Features:  Features(),
+	}
+}
+
+var (
+	featuresMutex sync.RWMutex
+	features      = map[string]struct{}{}
+)
+
+// RegisterFeature marks an optional feature - a transport, an encoding, a
+// protocol extension - as active in this process. Components register their
+// flag when they come up, so the set reflects the running configuration and
+// not just what was compiled in. Registering a feature twice is a no-op.
+func RegisterFeature(name string) {
+	featuresMutex.Lock()
+	features[name] = struct{}{}
+	featuresMutex.Unlock()
+}
+
+// HasFeature reports whether a feature is registered as active.
+func HasFeature(name string) bool {
+	featuresMutex.RLock()
+	_, ok := features[name]
+	featuresMutex.RUnlock()
+	return ok
+}
+
+// Features returns the active features, sorted for stable output.
+func Features() []string {
+	featuresMutex.RLock()
+	out := make([]string, 0, len(features))
+	for f := range features {
+		out = append(out, f)
+	}
+	featuresMutex.RUnlock()
+	sort.Strings(out)
+	return out
+}
+
+// MissingFeatures returns the entries of required that advertised does not
+// contain - a non-empty result means the peer lacks something we consider
+// mandatory and the mismatch should be surfaced rather than failing silently
+// later.
+func MissingFeatures(advertised, required []string) []string {
+	var missing []string
+	for _, r := range required {
+		found := false
+		for _, a := range advertised {
+			if a == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, r)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,40 @@
+package version
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeatureRegistry(t *testing.T) {
+	assert.False(t, HasFeature("test/udp-transport"))
+
+	RegisterFeature("test/udp-transport")
+	RegisterFeature("test/compression")
+	RegisterFeature("test/udp-transport") // re-registration is a no-op
+
+	assert.True(t, HasFeature("test/udp-transport"))
+	assert.True(t, HasFeature("test/compression"))
+
+	feats := Features()
+	assert.Contains(t, feats, "test/udp-transport")
+	assert.Contains(t, feats, "test/compression")
+	assert.True(t, sort.StringsAreSorted(feats), "feature listing must be sorted for stable output")
+}
+
+func TestInfoCarriesFeatures(t *testing.T) {
+	RegisterFeature("test/envelope-v2")
+	info := Info()
+	assert.Equal(t, Version, info.Version)
+	assert.Contains(t, info.Features, "test/envelope-v2")
+}
+
+func TestMissingFeatures(t *testing.T) {
+	advertised := []string{"aead/aes-gcm-1", "flate/1"}
+
+	assert.Empty(t, MissingFeatures(advertised, nil))
+	assert.Empty(t, MissingFeatures(advertised, []string{"flate/1"}))
+	assert.Equal(t, []string{"envelope/2"}, MissingFeatures(advertised, []string{"flate/1", "envelope/2"}))
+	assert.Equal(t, []string{"envelope/2"}, MissingFeatures(nil, []string{"envelope/2"}))
+}